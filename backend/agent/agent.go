@@ -0,0 +1,459 @@
+// Package agent splits flow execution from the API server. API servers
+// queue jobs in Redis; agents, started with MODE=agent on the machines
+// that run the browsers, register themselves, pull the jobs and run them.
+// Runs are saved to the shared Redis database as usual, so their results
+// and events reach the API servers like those of local runs.
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"auto/apperr"
+	"auto/flow"
+	"auto/model"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Job IDs wait in the Redis lists interactiveQueue and jobQueue, oldest
+// first; agents take interactive jobs before the others. An agent moves
+// the job it runs to its own list "agent_processing:<agent id>" so the job
+// is queued again if the agent dies. Jobs are kept as
+// "agent_job:<id>" for jobTTL, agents as "agent:<id>" while they beat.
+const (
+	jobQueue          = "agent_jobs"
+	interactiveQueue  = "agent_jobs_interactive"
+	processingPrefix  = "agent_processing:"
+	jobTTL            = 7 * 24 * time.Hour
+	heartbeatInterval = 10 * time.Second
+	agentTTL          = 3 * heartbeatInterval
+	// pullTimeout is how long an agent that is cordoned, or failed to pull
+	// a job, waits before looking again.
+	pullTimeout = 5 * time.Second
+	// batchPollInterval is how long an idle agent waits for interactive
+	// jobs before looking for batch jobs again.
+	batchPollInterval = time.Second
+)
+
+// queueFor returns the list the jobs of a priority wait in.
+func queueFor(priority string) string {
+	if priority == model.PriorityInteractive {
+		return interactiveQueue
+	}
+	return jobQueue
+}
+
+// DefaultRecoverInterval is how often API servers look for the jobs of
+// agents that died.
+const DefaultRecoverInterval = time.Minute
+
+// Job statuses.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Job is a flow run queued for the agents. Its ID is also the ID of the
+// run, so the run can be looked up as soon as an agent starts it.
+type Job struct {
+	ID         string                 `json:"id"`
+	FlowID     string                 `json:"flow_id"`
+	Params     map[string]interface{} `json:"params,omitempty"`
+	Priority   string                 `json:"priority"`
+	Force      bool                   `json:"force,omitempty"`
+	Labels     map[string]string      `json:"labels,omitempty"`
+	Status     string                 `json:"status"`
+	Agent      string                 `json:"agent,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	EnqueuedAt time.Time              `json:"enqueued_at"`
+	StartedAt  time.Time              `json:"started_at,omitempty"`
+	FinishedAt time.Time              `json:"finished_at,omitempty"`
+}
+
+// Info is what an agent reports about itself: how many jobs it runs at
+// once and now, the browsers it has running and what it is built from.
+type Info struct {
+	ID          string    `json:"id"`
+	Host        string    `json:"host"`
+	Version     string    `json:"version"`
+	GoVersion   string    `json:"go_version"`
+	Concurrency int       `json:"concurrency"`
+	Running     int64     `json:"running"`
+	Instances   []string  `json:"instances"`
+	State       string    `json:"state"`
+	Health      string    `json:"health"`
+	StartedAt   time.Time `json:"started_at"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// Queue is the API server side: it queues jobs and tells what the agents
+// are doing.
+type Queue struct {
+	db     *redis.Client
+	flows  *flow.Manager
+	logger *zap.Logger
+}
+
+func NewQueue(db *redis.Client, flows *flow.Manager, logger *zap.Logger) *Queue {
+	return &Queue{db: db, flows: flows, logger: logger}
+}
+
+// Enqueue queues a run of a flow for the agents. With force the flow runs
+// even when it has a cached result; the run is labelled with labels.
+func (q *Queue) Enqueue(flowID string, params map[string]interface{}, priority string, force bool, labels map[string]string) (*Job, error) {
+	if _, err := q.flows.GetFlow(flowID); err != nil {
+		return nil, err
+	}
+	if err := flow.ValidateLabels(labels); err != nil {
+		return nil, err
+	}
+	switch priority {
+	case "":
+		priority = model.PriorityInteractive
+	case model.PriorityInteractive, model.PriorityBatch:
+	default:
+		return nil, fmt.Errorf("invalid priority: %q", priority)
+	}
+	job := &Job{
+		ID:         uuid.New().String(),
+		FlowID:     flowID,
+		Params:     params,
+		Priority:   priority,
+		Force:      force,
+		Labels:     labels,
+		Status:     JobQueued,
+		EnqueuedAt: time.Now(),
+	}
+	if err := saveJob(context.Background(), q.db, job); err != nil {
+		return nil, err
+	}
+	// Agents pop from the right, so jobs run in the order they came.
+	if err := q.db.LPush(context.Background(), queueFor(priority), job.ID).Err(); err != nil {
+		return nil, err
+	}
+	q.logger.Info("Queued job for agents", zap.String("jobID", job.ID), zap.String("flowID", flowID), zap.String("priority", priority))
+	return job, nil
+}
+
+// GetJob returns a queued job.
+func (q *Queue) GetJob(id string) (*Job, error) {
+	return loadJob(context.Background(), q.db, id)
+}
+
+// Agents returns the agents alive.
+func (q *Queue) Agents() ([]Info, error) {
+	ctx := context.Background()
+	keys, err := scanKeys(ctx, q.db, "agent:*")
+	if err != nil {
+		return nil, err
+	}
+	agents := make([]Info, 0, len(keys))
+	now := time.Now()
+	for _, key := range keys {
+		data, err := q.db.Get(ctx, key).Bytes()
+		if err != nil {
+			continue
+		}
+		var info Info
+		if err := json.Unmarshal(data, &info); err == nil {
+			info.Health = health(info, now)
+			agents = append(agents, info)
+		}
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].Host+agents[i].ID < agents[j].Host+agents[j].ID })
+	return agents, nil
+}
+
+// Recover queues the jobs of dead agents again every interval until ctx
+// ends. A job an agent died in is run again from the start.
+func (q *Queue) Recover(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				q.recover(ctx)
+			}
+		}
+	}()
+}
+
+func (q *Queue) recover(ctx context.Context) {
+	lists, err := scanKeys(ctx, q.db, processingPrefix+"*")
+	if err != nil {
+		q.logger.Error("Failed to list agent jobs", zap.Error(err))
+		return
+	}
+	for _, list := range lists {
+		agentID := strings.TrimPrefix(list, processingPrefix)
+		alive, err := q.db.Exists(ctx, "agent:"+agentID).Result()
+		if err != nil || alive > 0 {
+			continue
+		}
+		for {
+			id, err := q.db.LIndex(ctx, list, -1).Result()
+			if err != nil {
+				break
+			}
+			job, err := loadJob(ctx, q.db, id)
+			queue := jobQueue
+			if err == nil {
+				queue = queueFor(job.Priority)
+			}
+			if id, err = q.db.RPopLPush(ctx, list, queue).Result(); err != nil {
+				break
+			}
+			if job != nil && job.ID == id {
+				job.Status, job.Agent = JobQueued, ""
+				saveJob(ctx, q.db, job)
+			}
+			q.logger.Warn("Queued job of a dead agent again", zap.String("jobID", id), zap.String("agent", agentID))
+		}
+	}
+}
+
+// Agent pulls jobs and runs them on the local browsers.
+type Agent struct {
+	id          string
+	host        string
+	concurrency int
+	running     int64
+	startedAt   time.Time
+	// prepareMu keeps workers from starting the same browser twice.
+	prepareMu sync.Mutex
+	db        *redis.Client
+	flows     *flow.Manager
+	instances *model.InstanceManager
+	logger    *zap.Logger
+}
+
+// New returns an agent running up to concurrency jobs at once.
+func New(db *redis.Client, flows *flow.Manager, instances *model.InstanceManager, concurrency int, logger *zap.Logger) *Agent {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	host, _ := os.Hostname()
+	return &Agent{
+		id:          uuid.New().String(),
+		host:        host,
+		concurrency: concurrency,
+		db:          db,
+		flows:       flows,
+		instances:   instances,
+		logger:      logger.With(zap.String("agent", host)),
+	}
+}
+
+// Run registers the agent and runs jobs until ctx ends or the agent is
+// drained. It returns once the jobs it started have finished.
+func (a *Agent) Run(ctx context.Context) {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	a.startedAt = time.Now()
+	a.beat(ctx)
+	a.logger.Info("Agent started", zap.String("agentID", a.id), zap.Int("concurrency", a.concurrency))
+
+	var wg sync.WaitGroup
+	for n := 0; n < a.concurrency; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.work(ctx)
+		}()
+	}
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			a.db.Del(context.Background(), "agent:"+a.id, "agent_state:"+a.id)
+			a.logger.Info("Agent stopped", zap.String("agentID", a.id))
+			return
+		case <-ticker.C:
+			// Keeps beating while the last jobs finish.
+			state := a.beat(context.Background())
+			if state == StateDraining && atomic.LoadInt64(&a.running) == 0 && ctx.Err() == nil {
+				a.logger.Info("Agent drained", zap.String("agentID", a.id))
+				stop()
+			}
+		}
+	}
+}
+
+// beat registers the agent for agentTTL and returns its state.
+func (a *Agent) beat(ctx context.Context) string {
+	info := Info{
+		ID:          a.id,
+		Host:        a.host,
+		Version:     buildVersion(),
+		GoVersion:   goVersion,
+		Concurrency: a.concurrency,
+		Running:     atomic.LoadInt64(&a.running),
+		Instances:   a.runningInstances(),
+		State:       a.state(ctx),
+		StartedAt:   a.startedAt,
+		LastSeen:    time.Now(),
+	}
+	data, _ := json.Marshal(info)
+	if err := a.db.Set(ctx, "agent:"+a.id, data, agentTTL).Err(); err != nil {
+		a.logger.Warn("Failed to register agent", zap.Error(err))
+	}
+	return info.State
+}
+
+// work runs jobs one at a time until ctx ends.
+func (a *Agent) work(ctx context.Context) {
+	processing := processingPrefix + a.id
+	for ctx.Err() == nil {
+		if a.state(ctx) != StateActive {
+			// Cordoned or draining: no new jobs.
+			select {
+			case <-ctx.Done():
+			case <-time.After(pullTimeout):
+			}
+			continue
+		}
+		id, err := a.pull(ctx, processing)
+		if err == redis.Nil || ctx.Err() != nil {
+			continue
+		}
+		if err != nil {
+			a.logger.Error("Failed to pull job", zap.Error(err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(pullTimeout):
+			}
+			continue
+		}
+		a.runJob(id)
+		a.db.LRem(context.Background(), processing, 1, id)
+	}
+}
+
+// pull moves the next job to processing: the oldest interactive one, else
+// the oldest batch one. An idle agent blocks on the interactive jobs, so
+// they start at once while batch jobs wait up to batchPollInterval.
+func (a *Agent) pull(ctx context.Context, processing string) (string, error) {
+	for _, queue := range []string{interactiveQueue, jobQueue} {
+		id, err := a.db.RPopLPush(ctx, queue, processing).Result()
+		if err != redis.Nil {
+			return id, err
+		}
+	}
+	return a.db.BRPopLPush(ctx, interactiveQueue, processing, batchPollInterval).Result()
+}
+
+// runJob runs a job to the end, even when the agent is stopping.
+func (a *Agent) runJob(id string) {
+	ctx := context.Background()
+	job, err := loadJob(ctx, a.db, id)
+	if err != nil {
+		a.logger.Error("Failed to load job", zap.String("jobID", id), zap.Error(err))
+		return
+	}
+	atomic.AddInt64(&a.running, 1)
+	defer atomic.AddInt64(&a.running, -1)
+
+	job.Status, job.Agent, job.StartedAt = JobRunning, a.id, time.Now()
+	saveJob(ctx, a.db, job)
+	a.logger.Info("Running job", zap.String("jobID", job.ID), zap.String("flowID", job.FlowID))
+
+	err = a.prepare(job)
+	if err == nil {
+		runCtx := flow.WithLabels(flow.WithRunID(ctx, job.ID), job.Labels)
+		if job.Force {
+			runCtx = flow.WithoutResultCache(runCtx)
+		}
+		err = a.flows.ExecuteFlowContext(runCtx, job.FlowID, a.instances, job.Params, job.Priority)
+	}
+	job.FinishedAt = time.Now()
+	job.Status = JobSucceeded
+	if err != nil {
+		job.Status, job.Error = JobFailed, err.Error()
+		a.logger.Error("Job failed", zap.String("jobID", job.ID), zap.String("flowID", job.FlowID), zap.Error(err))
+	}
+	if err := saveJob(ctx, a.db, job); err != nil {
+		a.logger.Error("Failed to save job", zap.String("jobID", job.ID), zap.Error(err))
+	}
+}
+
+// prepare loads the flow of a job as the API servers last saved it, and
+// starts the browser of its instance here if it is not running yet,
+// returning once it has logged in so the job does not race the login.
+func (a *Agent) prepare(job *Job) error {
+	f, err := a.flows.RefreshFlow(job.FlowID)
+	if err != nil {
+		return err
+	}
+	a.prepareMu.Lock()
+	defer a.prepareMu.Unlock()
+	if _, err := a.instances.LoadInstances(); err != nil {
+		return err
+	}
+	if f.GetInstanceGroup() != "" || f.GetInstanceID() == "" {
+		return nil
+	}
+	instance, err := a.instances.GetInstance(f.GetInstanceID())
+	if err != nil {
+		return err
+	}
+	if instance.GetStatus() == "On" {
+		return nil
+	}
+	a.logger.Info("Starting instance for job", zap.String("jobID", job.ID), zap.String("instanceID", instance.ID))
+	return a.instances.StartInstanceAndWait(instance.ID)
+}
+
+func saveJob(ctx context.Context, db *redis.Client, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return db.Set(ctx, "agent_job:"+job.ID, data, jobTTL).Err()
+}
+
+func loadJob(ctx context.Context, db *redis.Client, id string) (*Job, error) {
+	data, err := db.Get(ctx, "agent_job:"+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, apperr.New(apperr.CodeNotFound, fmt.Sprintf("job not found: %s", id))
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func scanKeys(ctx context.Context, db *redis.Client, pattern string) ([]string, error) {
+	var keys []string
+	iter := db.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
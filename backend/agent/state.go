@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"auto/apperr"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// States of an agent. A cordoned agent finishes the jobs it has but pulls
+// no new ones; a draining agent does the same, then stops, so it can be
+// taken down for maintenance without killing runs. The state is kept in
+// "agent_state:<id>" while it is not active.
+const (
+	StateActive   = "active"
+	StateCordoned = "cordoned"
+	StateDraining = "draining"
+)
+
+// Health of an agent, as seen from its heartbeats.
+const (
+	HealthHealthy = "healthy"
+	// HealthLate means the agent missed a heartbeat; it is dropped from
+	// the inventory once it misses agentTTL worth of them.
+	HealthLate = "late"
+)
+
+// GetAgent returns an agent alive.
+func (q *Queue) GetAgent(id string) (*Info, error) {
+	agents, err := q.Agents()
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range agents {
+		if info.ID == id {
+			return &info, nil
+		}
+	}
+	return nil, apperr.New(apperr.CodeNotFound, fmt.Sprintf("agent not found: %s", id))
+}
+
+// SetState cordons, drains or reactivates an agent. A draining agent that
+// has already stopped cannot be reactivated.
+func (q *Queue) SetState(id, state string) (*Info, error) {
+	info, err := q.GetAgent(id)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	switch state {
+	case StateActive:
+		err = q.db.Del(ctx, "agent_state:"+id).Err()
+	case StateCordoned, StateDraining:
+		err = q.db.Set(ctx, "agent_state:"+id, state, 0).Err()
+	default:
+		return nil, fmt.Errorf("invalid agent state: %q", state)
+	}
+	if err != nil {
+		return nil, err
+	}
+	q.logger.Info("Changed agent state", zap.String("agentID", id), zap.String("state", state))
+	info.State = state
+	return info, nil
+}
+
+// health tells how an agent is doing from its last heartbeat.
+func health(info Info, now time.Time) string {
+	if now.Sub(info.LastSeen) > heartbeatInterval*3/2 {
+		return HealthLate
+	}
+	return HealthHealthy
+}
+
+// state returns the state the agent was put in.
+func (a *Agent) state(ctx context.Context) string {
+	state, err := a.db.Get(ctx, "agent_state:"+a.id).Result()
+	if err == redis.Nil || state == "" {
+		return StateActive
+	}
+	if err != nil {
+		a.logger.Warn("Failed to read agent state", zap.Error(err))
+		return StateActive
+	}
+	return state
+}
+
+// runningInstances returns the IDs of the browsers running on the agent.
+func (a *Agent) runningInstances() []string {
+	var ids []string
+	for _, instance := range a.instances.GetInstances() {
+		if instance.GetStatus() == "On" {
+			ids = append(ids, instance.ID)
+		}
+	}
+	return ids
+}
+
+// buildVersion returns the version of the agent binary: its module version
+// or, for a build from a checkout, its VCS revision.
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" && s.Value != "" {
+			return s.Value
+		}
+	}
+	return info.Main.Version
+}
+
+var goVersion = runtime.Version()
@@ -0,0 +1,331 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"auto/flow"
+	"auto/model"
+	"auto/notify"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// DefaultInterval is how often the engine evaluates the rules.
+const DefaultInterval = 30 * time.Second
+
+// Engine stores alert rules and evaluates them in the background.
+type Engine struct {
+	db         *redis.Client
+	runs       *flow.RunStore
+	instances  *model.InstanceManager
+	dispatcher *notify.Dispatcher
+	logger     *zap.Logger
+}
+
+func NewEngine(db *redis.Client, runs *flow.RunStore, instances *model.InstanceManager, dispatcher *notify.Dispatcher, logger *zap.Logger) *Engine {
+	return &Engine{
+		db:         db,
+		runs:       runs,
+		instances:  instances,
+		dispatcher: dispatcher,
+		logger:     logger,
+	}
+}
+
+// Start evaluates the rules every interval until ctx is done.
+func (e *Engine) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				e.EvaluateAll(ctx)
+			}
+		}
+	}()
+}
+
+func (e *Engine) CreateRule(rule Rule) (*Rule, error) {
+	rule.ID = uuid.New().String()
+	rule.State = RuleState{}
+	if err := rule.validate(e.dispatcher); err != nil {
+		return nil, err
+	}
+	if err := e.saveRule(&rule); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (e *Engine) UpdateRule(id string, rule Rule) (*Rule, error) {
+	existing, err := e.GetRule(id)
+	if err != nil {
+		return nil, err
+	}
+	rule.ID = id
+	rule.State = existing.State
+	if err := rule.validate(e.dispatcher); err != nil {
+		return nil, err
+	}
+	if err := e.saveRule(&rule); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (e *Engine) DeleteRule(id string) error {
+	ctx := context.Background()
+	n, err := e.db.HDel(ctx, "alert_rules", id).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("alert rule not found")
+	}
+	return e.db.HDel(ctx, "alert_state", id).Err()
+}
+
+func (e *Engine) GetRule(id string) (*Rule, error) {
+	ctx := context.Background()
+	data, err := e.db.HGet(ctx, "alert_rules", id).Bytes()
+	if err == redis.Nil {
+		return nil, errors.New("alert rule not found")
+	} else if err != nil {
+		return nil, err
+	}
+	var rule Rule
+	if err := json.Unmarshal(data, &rule); err != nil {
+		return nil, err
+	}
+	if state, err := e.db.HGet(ctx, "alert_state", id).Bytes(); err == nil {
+		json.Unmarshal(state, &rule.State)
+	}
+	return &rule, nil
+}
+
+func (e *Engine) GetRules() ([]*Rule, error) {
+	ids, err := e.db.HKeys(context.Background(), "alert_rules").Result()
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]*Rule, 0, len(ids))
+	for _, id := range ids {
+		rule, err := e.GetRule(id)
+		if err != nil {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// SetMuted mutes or unmutes a rule. A muted rule keeps being evaluated but
+// sends no notifications.
+func (e *Engine) SetMuted(id string, muted bool) (*Rule, error) {
+	rule, err := e.GetRule(id)
+	if err != nil {
+		return nil, err
+	}
+	rule.Muted = muted
+	return rule, e.saveRule(rule)
+}
+
+// Snooze silences a rule for the given duration.
+func (e *Engine) Snooze(id string, d time.Duration) (*Rule, error) {
+	rule, err := e.GetRule(id)
+	if err != nil {
+		return nil, err
+	}
+	rule.SnoozedUntil = time.Now().Add(d)
+	return rule, e.saveRule(rule)
+}
+
+func (e *Engine) saveRule(rule *Rule) error {
+	def := *rule
+	def.State = RuleState{}
+	data, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+	return e.db.HSet(context.Background(), "alert_rules", rule.ID, data).Err()
+}
+
+func (e *Engine) saveState(id string, state RuleState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return e.db.HSet(context.Background(), "alert_state", id, data).Err()
+}
+
+// EvaluateAll checks every rule once.
+func (e *Engine) EvaluateAll(ctx context.Context) {
+	rules, err := e.GetRules()
+	if err != nil {
+		e.logger.Error("Failed to load alert rules", zap.Error(err))
+		return
+	}
+	for _, rule := range rules {
+		e.evaluateRule(ctx, rule)
+	}
+}
+
+// condition is the outcome of evaluating a rule. Key identifies what the
+// rule fired for, so a new offending run or instance notifies again while
+// the same one does not.
+type condition struct {
+	firing bool
+	key    string
+	n      notify.Notification
+}
+
+func (e *Engine) evaluateRule(ctx context.Context, rule *Rule) {
+	now := time.Now()
+	state := rule.State
+	state.LastChecked = now
+
+	cond, err := e.check(ctx, rule, now)
+	if err != nil {
+		state.LastError = err.Error()
+		e.saveState(rule.ID, state)
+		e.logger.Warn("Failed to evaluate alert rule", zap.String("ruleID", rule.ID), zap.Error(err))
+		return
+	}
+	state.LastError = ""
+
+	switch {
+	case cond.firing && (!state.Firing || cond.key != state.FiringKey):
+		state.Firing, state.FiringKey, state.LastFired = true, cond.key, now
+		if rule.silenced(now) {
+			e.logger.Info("Alert rule fired while silenced", zap.String("ruleID", rule.ID))
+			break
+		}
+		cond.n.Title = fmt.Sprintf("Alert: %s", rule.Name)
+		cond.n.Severity = "alert"
+		cond.n.Time = now
+		if err := e.dispatcher.Send(ctx, rule.Channels, cond.n); err != nil {
+			e.logger.Error("Failed to send alert", zap.String("ruleID", rule.ID), zap.Error(err))
+		}
+	case !cond.firing && state.Firing:
+		state.Firing, state.FiringKey = false, ""
+		e.logger.Info("Alert rule resolved", zap.String("ruleID", rule.ID))
+	}
+	if err := e.saveState(rule.ID, state); err != nil {
+		e.logger.Error("Failed to save alert state", zap.String("ruleID", rule.ID), zap.Error(err))
+	}
+}
+
+func (e *Engine) check(ctx context.Context, rule *Rule, now time.Time) (condition, error) {
+	switch rule.Type {
+	case RuleConsecutiveFailures:
+		return e.checkConsecutiveFailures(ctx, rule)
+	case RuleRunDuration:
+		return e.checkRunDuration(ctx, rule, now)
+	case RuleInstanceRestarts:
+		return e.checkInstanceRestarts(rule, now)
+	default:
+		return condition{}, fmt.Errorf("unknown rule type: %s", rule.Type)
+	}
+}
+
+func (e *Engine) checkConsecutiveFailures(ctx context.Context, rule *Rule) (condition, error) {
+	runs, err := e.runs.ListRuns(ctx, rule.FlowID, time.Time{}, rule.Threshold*2+1)
+	if err != nil {
+		return condition{}, err
+	}
+	var finished []*flow.Run
+	for _, run := range runs {
+		switch run.Status {
+		case flow.RunStatusQueued, flow.RunStatusRunning:
+			// Not over yet, so neither a failure nor a success.
+		default:
+			finished = append(finished, run)
+		}
+	}
+	if len(finished) < rule.Threshold {
+		return condition{}, nil
+	}
+	for _, run := range finished[:rule.Threshold] {
+		if run.Status != flow.RunStatusFailed {
+			return condition{}, nil
+		}
+	}
+	last := finished[0]
+	return condition{
+		firing: true,
+		key:    "streak",
+		n: notify.Notification{
+			Message: fmt.Sprintf("Flow %s failed %d times in a row; last error: %s", rule.FlowID, rule.Threshold, last.Error),
+			FlowID:  rule.FlowID,
+			RunID:   last.ID,
+		},
+	}, nil
+}
+
+func (e *Engine) checkRunDuration(ctx context.Context, rule *Rule, now time.Time) (condition, error) {
+	maxDuration, err := time.ParseDuration(rule.MaxDuration)
+	if err != nil {
+		return condition{}, err
+	}
+	runs, err := e.runs.ListRuns(ctx, rule.FlowID, time.Time{}, 1)
+	if err != nil || len(runs) == 0 {
+		return condition{}, err
+	}
+	run := runs[0]
+	took := time.Duration(run.DurationMs) * time.Millisecond
+	if run.Status == flow.RunStatusRunning {
+		took = now.Sub(run.StartedAt)
+	}
+	if took <= maxDuration {
+		return condition{}, nil
+	}
+	return condition{
+		firing: true,
+		key:    run.ID,
+		n: notify.Notification{
+			Message: fmt.Sprintf("Run %s of flow %s took %s (limit %s)", run.ID, rule.FlowID, took.Round(time.Second), maxDuration),
+			FlowID:  rule.FlowID,
+			RunID:   run.ID,
+		},
+	}, nil
+}
+
+func (e *Engine) checkInstanceRestarts(rule *Rule, now time.Time) (condition, error) {
+	window, err := time.ParseDuration(rule.Window)
+	if err != nil {
+		return condition{}, err
+	}
+	ids := []string{rule.InstanceID}
+	if rule.InstanceID == "" {
+		ids = ids[:0]
+		for _, instance := range e.instances.GetInstances() {
+			ids = append(ids, instance.ID)
+		}
+	}
+	for _, id := range ids {
+		n, err := e.instances.CountInstanceStarts(id, now.Add(-window))
+		if err != nil {
+			return condition{}, err
+		}
+		if n > int64(rule.Threshold) {
+			return condition{
+				firing: true,
+				key:    id,
+				n: notify.Notification{
+					Message:    fmt.Sprintf("Instance %s was started %d times in the last %s", id, n, window),
+					InstanceID: id,
+				},
+			}, nil
+		}
+	}
+	return condition{}, nil
+}
@@ -0,0 +1,86 @@
+// Package alert evaluates user defined alert rules against run records and
+// instance activity and notifies their channels when a rule starts firing.
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"auto/notify"
+)
+
+const (
+	// RuleConsecutiveFailures fires when the last Threshold runs of FlowID
+	// all failed.
+	RuleConsecutiveFailures = "consecutive_failures"
+	// RuleRunDuration fires when a run of FlowID takes longer than
+	// MaxDuration, including runs still in progress.
+	RuleRunDuration = "run_duration"
+	// RuleInstanceRestarts fires when an instance, or InstanceID only when
+	// set, was started more than Threshold times within Window.
+	RuleInstanceRestarts = "instance_restarts"
+)
+
+// Rule is an alert condition and the channels notified when it fires.
+type Rule struct {
+	ID           string           `json:"id"`
+	Name         string           `json:"name"`
+	Type         string           `json:"type"`
+	FlowID       string           `json:"flow_id,omitempty"`
+	InstanceID   string           `json:"instance_id,omitempty"`
+	Threshold    int              `json:"threshold,omitempty"`
+	MaxDuration  string           `json:"max_duration,omitempty"`
+	Window       string           `json:"window,omitempty"`
+	Channels     []notify.Channel `json:"channels"`
+	Muted        bool             `json:"muted"`
+	SnoozedUntil time.Time        `json:"snoozed_until,omitempty"`
+	State        RuleState        `json:"state"`
+}
+
+// RuleState is maintained by the engine and kept apart from the rule
+// definition so edits never race with evaluation.
+type RuleState struct {
+	Firing      bool      `json:"firing"`
+	FiringKey   string    `json:"firing_key,omitempty"`
+	LastFired   time.Time `json:"last_fired,omitempty"`
+	LastChecked time.Time `json:"last_checked,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// silenced reports whether notifications for the rule are suppressed.
+func (r *Rule) silenced(now time.Time) bool {
+	return r.Muted || now.Before(r.SnoozedUntil)
+}
+
+func (r *Rule) validate(dispatcher *notify.Dispatcher) error {
+	switch r.Type {
+	case RuleConsecutiveFailures:
+		if r.FlowID == "" {
+			return errors.New("flow_id is required")
+		}
+		if r.Threshold <= 0 {
+			return errors.New("threshold must be positive")
+		}
+	case RuleRunDuration:
+		if r.FlowID == "" {
+			return errors.New("flow_id is required")
+		}
+		if d, err := time.ParseDuration(r.MaxDuration); err != nil || d <= 0 {
+			return fmt.Errorf("invalid max_duration: %q", r.MaxDuration)
+		}
+	case RuleInstanceRestarts:
+		if r.Threshold <= 0 {
+			return errors.New("threshold must be positive")
+		}
+		if d, err := time.ParseDuration(r.Window); err != nil || d <= 0 || d > 24*time.Hour {
+			return fmt.Errorf("invalid window: %q", r.Window)
+		}
+	default:
+		return fmt.Errorf("unknown rule type: %s", r.Type)
+	}
+	if len(r.Channels) == 0 {
+		return errors.New("at least one channel is required")
+	}
+	return dispatcher.Validate(r.Channels)
+}
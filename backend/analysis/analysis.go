@@ -0,0 +1,242 @@
+package analysis
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"auto/model"
+)
+
+const (
+	SeverityHigh   = "high"
+	SeverityMedium = "medium"
+	SeverityLow    = "low"
+	SeverityInfo   = "info"
+)
+
+// Finding is a potential issue spotted in captured traffic.
+type Finding struct {
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	URL      string `json:"url"`
+	Detail   string `json:"detail"`
+}
+
+// Report groups the findings for one crawl or run.
+type Report struct {
+	Findings []Finding      `json:"findings"`
+	Summary  map[string]int `json:"summary"`
+}
+
+var (
+	secretParamName = regexp.MustCompile(`(?i)^(access_?token|api_?key|apikey|auth|token|secret|client_secret|password|passwd|pwd|session(id)?|jwt)$`)
+	jwtValue        = regexp.MustCompile(`^eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*$`)
+	awsKeyValue     = regexp.MustCompile(`^(AKIA|ASIA)[0-9A-Z]{16}$`)
+
+	verboseErrors = []*regexp.Regexp{
+		regexp.MustCompile(`Traceback \(most recent call last\)`),
+		regexp.MustCompile(`Exception in thread "`),
+		regexp.MustCompile(`\bat [a-z]+(\.[a-zA-Z_$][\w$]*)+\([\w]+\.java:\d+\)`),
+		regexp.MustCompile(`(Fatal error|Warning|Parse error)</b>:.* on line <b>\d+`),
+		regexp.MustCompile(`You have an error in your SQL syntax`),
+		regexp.MustCompile(`\bORA-\d{5}\b`),
+		regexp.MustCompile(`SQLSTATE\[`),
+		regexp.MustCompile(`System\.[A-Za-z]+Exception`),
+		regexp.MustCompile(`Django Version:`),
+		regexp.MustCompile(`Whitelabel Error Page`),
+	}
+)
+
+// securityHeaders are expected on every HTML document.
+var securityHeaders = []struct {
+	name     string
+	severity string
+	httpsReq bool
+}{
+	{"Content-Security-Policy", SeverityMedium, false},
+	{"X-Frame-Options", SeverityLow, false},
+	{"X-Content-Type-Options", SeverityLow, false},
+	{"Strict-Transport-Security", SeverityMedium, true},
+	{"Referrer-Policy", SeverityInfo, false},
+}
+
+// Analyze runs every passive check over the captured traffic.
+func Analyze(requests []model.Request, responses []model.Response) Report {
+	var findings []Finding
+	findings = append(findings, secretsInURLs(requests)...)
+	findings = append(findings, missingHeaders(responses)...)
+	findings = append(findings, mixedContent(responses)...)
+	findings = append(findings, reflectedParams(responses)...)
+	findings = append(findings, verboseErrorPages(responses)...)
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return severityRank(findings[i].Severity) < severityRank(findings[j].Severity)
+	})
+	summary := make(map[string]int)
+	for _, f := range findings {
+		summary[f.Severity]++
+	}
+	return Report{Findings: findings, Summary: summary}
+}
+
+func severityRank(s string) int {
+	switch s {
+	case SeverityHigh:
+		return 0
+	case SeverityMedium:
+		return 1
+	case SeverityLow:
+		return 2
+	default:
+		return 3
+	}
+}
+
+func secretsInURLs(requests []model.Request) []Finding {
+	var findings []Finding
+	for i := range requests {
+		u := requests[i].URL
+		for name, values := range u.Query() {
+			for _, v := range values {
+				var reason string
+				switch {
+				case secretParamName.MatchString(name) && v != "":
+					reason = fmt.Sprintf("parameter %q looks like a credential", name)
+				case jwtValue.MatchString(v):
+					reason = fmt.Sprintf("parameter %q carries a JWT", name)
+				case awsKeyValue.MatchString(v):
+					reason = fmt.Sprintf("parameter %q carries an AWS access key", name)
+				default:
+					continue
+				}
+				findings = append(findings, Finding{
+					Check:    "secret-in-url",
+					Severity: SeverityHigh,
+					URL:      u.NoQueryUrl(),
+					Detail:   reason,
+				})
+			}
+		}
+	}
+	return findings
+}
+
+func isHTML(r model.Response) bool {
+	return strings.HasPrefix(r.MimeType, "text/html")
+}
+
+func header(r model.Response, name string) string {
+	for k, v := range r.Headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+func missingHeaders(responses []model.Response) []Finding {
+	var findings []Finding
+	reported := make(map[string]bool)
+	for _, r := range responses {
+		if !isHTML(r) || r.Status >= 300 {
+			continue
+		}
+		u, err := url.Parse(r.URL)
+		if err != nil {
+			continue
+		}
+		for _, h := range securityHeaders {
+			if h.httpsReq && u.Scheme != "https" {
+				continue
+			}
+			if header(r, h.name) != "" {
+				continue
+			}
+			if h.name == "X-Frame-Options" && strings.Contains(header(r, "Content-Security-Policy"), "frame-ancestors") {
+				continue
+			}
+			key := u.Host + "|" + h.name
+			if reported[key] {
+				continue
+			}
+			reported[key] = true
+			findings = append(findings, Finding{
+				Check:    "missing-security-header",
+				Severity: h.severity,
+				URL:      r.URL,
+				Detail:   fmt.Sprintf("%s header is missing", h.name),
+			})
+		}
+	}
+	return findings
+}
+
+func mixedContent(responses []model.Response) []Finding {
+	var findings []Finding
+	for _, r := range responses {
+		if strings.HasPrefix(r.DocumentURL, "https://") && strings.HasPrefix(r.URL, "http://") {
+			findings = append(findings, Finding{
+				Check:    "mixed-content",
+				Severity: SeverityMedium,
+				URL:      r.DocumentURL,
+				Detail:   fmt.Sprintf("loads insecure resource %s", r.URL),
+			})
+		}
+	}
+	return findings
+}
+
+func reflectedParams(responses []model.Response) []Finding {
+	var findings []Finding
+	for _, r := range responses {
+		if r.Body == "" {
+			continue
+		}
+		u, err := url.Parse(r.URL)
+		if err != nil {
+			continue
+		}
+		for name, values := range u.Query() {
+			for _, v := range values {
+				if len(v) < 4 || !strings.Contains(r.Body, v) {
+					continue
+				}
+				severity := SeverityInfo
+				if strings.ContainsAny(v, `<>"'`) {
+					severity = SeverityMedium
+				}
+				findings = append(findings, Finding{
+					Check:    "reflected-parameter",
+					Severity: severity,
+					URL:      r.URL,
+					Detail:   fmt.Sprintf("value of parameter %q is reflected unencoded in the response", name),
+				})
+			}
+		}
+	}
+	return findings
+}
+
+func verboseErrorPages(responses []model.Response) []Finding {
+	var findings []Finding
+	for _, r := range responses {
+		if r.Body == "" {
+			continue
+		}
+		for _, re := range verboseErrors {
+			if m := re.FindString(r.Body); m != "" {
+				findings = append(findings, Finding{
+					Check:    "verbose-error",
+					Severity: SeverityMedium,
+					URL:      r.URL,
+					Detail:   fmt.Sprintf("response (status %d) exposes error details: %q", r.Status, m),
+				})
+				break
+			}
+		}
+	}
+	return findings
+}
@@ -0,0 +1,213 @@
+// Package apperr defines the errors the API reports: a stable code clients
+// can program against, the HTTP status it maps to, and the run and step a
+// failure happened in.
+package apperr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Code identifies a kind of failure. Codes are part of the API and must not
+// change once released.
+type Code string
+
+const (
+	CodeInvalidRequest       Code = "INVALID_REQUEST"
+	CodeUnauthorized         Code = "UNAUTHORIZED"
+	CodeTooManyConnections   Code = "TOO_MANY_CONNECTIONS"
+	CodePayloadTooLarge      Code = "PAYLOAD_TOO_LARGE"
+	CodeUnsupportedMediaType Code = "UNSUPPORTED_MEDIA_TYPE"
+	CodeNotFound             Code = "NOT_FOUND"
+	CodeConflict             Code = "CONFLICT"
+	CodeUnprocessable        Code = "UNPROCESSABLE"
+	CodeTimeout              Code = "TIMEOUT"
+	CodeInternal             Code = "INTERNAL"
+
+	CodeInstanceNotFound       Code = "INSTANCE_NOT_FOUND"
+	CodeInstanceNotRunning     Code = "INSTANCE_NOT_RUNNING"
+	CodeInstanceAlreadyRunning Code = "INSTANCE_ALREADY_RUNNING"
+	CodeInstanceAlreadyStopped Code = "INSTANCE_ALREADY_STOPPED"
+	CodeInstanceBusy           Code = "INSTANCE_BUSY"
+	CodeChromeCrashed          Code = "CHROME_CRASHED"
+	CodeSelectorTimeout        Code = "SELECTOR_TIMEOUT"
+	CodeUnknownAction          Code = "UNKNOWN_ACTION"
+
+	CodeFlowNotFound     Code = "FLOW_NOT_FOUND"
+	CodeRunNotFound      Code = "RUN_NOT_FOUND"
+	CodeRevisionConflict Code = "REVISION_CONFLICT"
+	CodeMissingSecrets   Code = "MISSING_SECRETS"
+	CodeStepFailed       Code = "STEP_FAILED"
+
+	CodeIdempotencyKeyReused Code = "IDEMPOTENCY_KEY_REUSED"
+	CodeRequestInProgress    Code = "REQUEST_IN_PROGRESS"
+
+	CodeTriggerNotFound Code = "TRIGGER_NOT_FOUND"
+	CodeRateLimited     Code = "RATE_LIMITED"
+	CodeReadOnly        Code = "READ_ONLY"
+
+	CodeLoginProfileNotFound Code = "LOGIN_PROFILE_NOT_FOUND"
+	CodeLoginFailed          Code = "LOGIN_FAILED"
+
+	CodePlanRequired Code = "PLAN_REQUIRED"
+	CodePlanChanged  Code = "PLAN_CHANGED"
+
+	CodeQuotaExceeded            Code = "QUOTA_EXCEEDED"
+	CodeConcurrencyQuotaExceeded Code = "CONCURRENCY_QUOTA_EXCEEDED"
+)
+
+var statuses = map[Code]int{
+	CodeInvalidRequest:       http.StatusBadRequest,
+	CodeUnauthorized:         http.StatusUnauthorized,
+	CodeTooManyConnections:   http.StatusTooManyRequests,
+	CodePayloadTooLarge:      http.StatusRequestEntityTooLarge,
+	CodeUnsupportedMediaType: http.StatusUnsupportedMediaType,
+	CodeNotFound:             http.StatusNotFound,
+	CodeConflict:             http.StatusConflict,
+	CodeUnprocessable:        http.StatusUnprocessableEntity,
+	CodeTimeout:              http.StatusGatewayTimeout,
+	CodeInternal:             http.StatusInternalServerError,
+
+	CodeInstanceNotFound:       http.StatusNotFound,
+	CodeInstanceNotRunning:     http.StatusConflict,
+	CodeInstanceAlreadyRunning: http.StatusConflict,
+	CodeInstanceAlreadyStopped: http.StatusConflict,
+	CodeInstanceBusy:           http.StatusServiceUnavailable,
+	CodeChromeCrashed:          http.StatusBadGateway,
+	CodeSelectorTimeout:        http.StatusGatewayTimeout,
+	CodeUnknownAction:          http.StatusBadRequest,
+
+	CodeFlowNotFound:     http.StatusNotFound,
+	CodeRunNotFound:      http.StatusNotFound,
+	CodeRevisionConflict: http.StatusConflict,
+	CodeMissingSecrets:   http.StatusUnprocessableEntity,
+	CodeStepFailed:       http.StatusInternalServerError,
+
+	CodeIdempotencyKeyReused: http.StatusUnprocessableEntity,
+	CodeRequestInProgress:    http.StatusConflict,
+
+	CodeTriggerNotFound: http.StatusNotFound,
+	CodeRateLimited:     http.StatusTooManyRequests,
+	CodeReadOnly:        http.StatusMethodNotAllowed,
+
+	CodeLoginProfileNotFound: http.StatusNotFound,
+	CodeLoginFailed:          http.StatusBadGateway,
+
+	CodePlanRequired: http.StatusPreconditionRequired,
+	CodePlanChanged:  http.StatusConflict,
+
+	CodeQuotaExceeded:            http.StatusForbidden,
+	CodeConcurrencyQuotaExceeded: http.StatusTooManyRequests,
+}
+
+// Status returns the HTTP status of a code.
+func (c Code) Status() int {
+	if status, ok := statuses[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// codeForStatus picks the generic code of a status.
+func codeForStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusUnprocessableEntity:
+		return CodeUnprocessable
+	case http.StatusGatewayTimeout:
+		return CodeTimeout
+	default:
+		return CodeInternal
+	}
+}
+
+// Error is an error with a code and, for flow failures, the run context.
+type Error struct {
+	Code       Code                   `json:"code"`
+	Message    string                 `json:"message"`
+	FlowID     string                 `json:"flow_id,omitempty"`
+	RunID      string                 `json:"run_id,omitempty"`
+	StepID     string                 `json:"step_id,omitempty"`
+	InstanceID string                 `json:"instance_id,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+	Err        error                  `json:"-"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Status returns the HTTP status of the error.
+func (e *Error) Status() int {
+	return e.Code.Status()
+}
+
+// New returns an error with a code.
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Wrap gives err a code, keeping its message.
+func Wrap(code Code, err error) *Error {
+	return &Error{Code: code, Message: err.Error(), Err: err}
+}
+
+// Coder is implemented by error types of other packages that map to a
+// code, optionally with details for the client.
+type Coder interface {
+	ErrorCode() Code
+}
+
+type detailer interface {
+	ErrorDetails() map[string]interface{}
+}
+
+// From turns any error into an *Error. The innermost coded error in the
+// chain decides the code, the outermost message is kept; timeouts map to
+// TIMEOUT, oversized bodies to PAYLOAD_TOO_LARGE and anything else to the generic code of fallbackStatus.
+func From(err error, fallbackStatus int) *Error {
+	out := &Error{Message: err.Error(), Err: err}
+	var e *Error
+	var coder Coder
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &e):
+		copied := *e
+		copied.Message = out.Message
+		copied.Err = err
+		out = &copied
+	case errors.As(err, &coder):
+		out.Code = coder.ErrorCode()
+	case errors.As(err, &maxErr):
+		out.Code = CodePayloadTooLarge
+	case errors.Is(err, context.DeadlineExceeded):
+		out.Code = CodeTimeout
+	default:
+		out.Code = codeForStatus(fallbackStatus)
+	}
+	var d detailer
+	if out.Details == nil && errors.As(err, &d) {
+		out.Details = d.ErrorDetails()
+	}
+	return out
+}
+
+// WithRun records the run and step err happened in.
+func WithRun(err error, flowID, runID, stepID, instanceID string) *Error {
+	e := From(err, http.StatusInternalServerError)
+	if e.Code == CodeInternal {
+		e.Code = CodeStepFailed
+	}
+	e.FlowID, e.RunID, e.StepID, e.InstanceID = flowID, runID, stepID, instanceID
+	return e
+}
@@ -0,0 +1,371 @@
+// Package backup snapshots the state kept in Redis (flows, instances,
+// schedules, alert rules and run records) into a gzipped tar archive and
+// restores it.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Version is the snapshot format written by Create. Restore accepts
+// snapshots up to this version.
+const Version = 1
+
+// maxEntrySize bounds a single file of an uploaded archive.
+const maxEntrySize = 512 << 20
+
+// Section is a group of keys that is backed up and restored together.
+type Section struct {
+	Name     string
+	Patterns []string
+}
+
+// Sections lists what a snapshot contains. Restoring a section replaces
+// every key matching its patterns.
+var Sections = []Section{
+	{Name: "flows", Patterns: []string{"flow:*"}},
+	{Name: "instances", Patterns: []string{"instances", "instance_groups", "instance_group_sticky:*", "login_profiles", "instance_snapshots", "instance_templates"}},
+	{Name: "schedules", Patterns: []string{"schedules", "calendars"}},
+	{Name: "alerts", Patterns: []string{"alert_rules", "alert_state"}},
+	{Name: "runs", Patterns: []string{"run:*", "runs:*", "run_index"}},
+	{Name: "triggers", Patterns: []string{"triggers", "trigger_tokens"}},
+	{Name: "secrets", Patterns: []string{"secrets"}},
+}
+
+// Manifest describes a snapshot; it is the first file of the archive.
+type Manifest struct {
+	Version   int            `json:"version"`
+	CreatedAt time.Time      `json:"created_at"`
+	Sections  map[string]int `json:"sections"`
+}
+
+// Key is the dump of one Redis key. Only the field matching Type is set.
+type Key struct {
+	Name   string            `json:"name"`
+	Type   string            `json:"type"`
+	String string            `json:"string,omitempty"`
+	Hash   map[string]string `json:"hash,omitempty"`
+	ZSet   []Member          `json:"zset,omitempty"`
+}
+
+// Member is an element of a sorted set.
+type Member struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// Snapshot is a parsed archive.
+type Snapshot struct {
+	Manifest Manifest
+	Keys     map[string][]Key
+}
+
+// Create writes a snapshot of every section to w.
+func Create(ctx context.Context, db redis.Cmdable, w io.Writer) (*Manifest, error) {
+	manifest := &Manifest{Version: Version, CreatedAt: time.Now().UTC(), Sections: make(map[string]int)}
+	dumps := make(map[string][]Key)
+	for _, s := range Sections {
+		names, err := scanKeys(ctx, db, s.Patterns)
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]Key, 0, len(names))
+		for _, name := range names {
+			k, err := dumpKey(ctx, db, name)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			if k != nil {
+				keys = append(keys, *k)
+			}
+		}
+		dumps[s.Name] = keys
+		manifest.Sections[s.Name] = len(keys)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := writeJSON(tw, "manifest.json", manifest); err != nil {
+		return nil, err
+	}
+	for _, s := range Sections {
+		if err := writeJSON(tw, s.Name+".json", dumps[s.Name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Read parses and validates an archive written by Create.
+func Read(r io.Reader) (*Snapshot, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a gzip archive: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	snap := &Snapshot{Keys: make(map[string][]Key)}
+	var haveManifest bool
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive: %w", err)
+		}
+		if hdr.Size > maxEntrySize {
+			return nil, fmt.Errorf("%s is too large", hdr.Name)
+		}
+		name := path.Clean(hdr.Name)
+		if name == "manifest.json" {
+			if err := json.NewDecoder(tr).Decode(&snap.Manifest); err != nil {
+				return nil, fmt.Errorf("manifest.json: %w", err)
+			}
+			haveManifest = true
+			continue
+		}
+		section := sectionFor(name)
+		if section == nil {
+			return nil, fmt.Errorf("unexpected file %s", hdr.Name)
+		}
+		var keys []Key
+		if err := json.NewDecoder(tr).Decode(&keys); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		snap.Keys[section.Name] = keys
+	}
+
+	if !haveManifest {
+		return nil, errors.New("manifest.json is missing")
+	}
+	if err := snap.validate(); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+func (s *Snapshot) validate() error {
+	if s.Manifest.Version < 1 || s.Manifest.Version > Version {
+		return fmt.Errorf("unsupported snapshot version %d", s.Manifest.Version)
+	}
+	for _, section := range Sections {
+		want, listed := s.Manifest.Sections[section.Name]
+		keys, present := s.Keys[section.Name]
+		if listed != present {
+			return fmt.Errorf("section %s does not match the manifest", section.Name)
+		}
+		if len(keys) != want {
+			return fmt.Errorf("section %s has %d keys, the manifest lists %d", section.Name, len(keys), want)
+		}
+		for _, k := range keys {
+			if !matchesAny(section.Patterns, k.Name) {
+				return fmt.Errorf("key %s does not belong to section %s", k.Name, section.Name)
+			}
+			if err := k.validate(); err != nil {
+				return fmt.Errorf("key %s: %w", k.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (k *Key) validate() error {
+	switch k.Type {
+	case "string":
+		if !json.Valid([]byte(k.String)) {
+			return errors.New("value is not valid JSON")
+		}
+	case "hash":
+		for field, v := range k.Hash {
+			if !json.Valid([]byte(v)) {
+				return fmt.Errorf("field %s is not valid JSON", field)
+			}
+		}
+	case "zset":
+	default:
+		return fmt.Errorf("unsupported type %q", k.Type)
+	}
+	return nil
+}
+
+// Restore replaces the keys of the given sections, or of every section in
+// the snapshot when sections is empty, in a single transaction.
+func Restore(ctx context.Context, db redis.Cmdable, snap *Snapshot, sections []string) (map[string]int, error) {
+	var selected []Section
+	for _, s := range Sections {
+		if _, ok := snap.Keys[s.Name]; !ok {
+			continue
+		}
+		if len(sections) == 0 || contains(sections, s.Name) {
+			selected = append(selected, s)
+		}
+	}
+	for _, name := range sections {
+		if _, ok := snap.Keys[name]; !ok {
+			return nil, fmt.Errorf("snapshot has no section %s", name)
+		}
+	}
+
+	var existing []string
+	for _, s := range selected {
+		names, err := scanKeys(ctx, db, s.Patterns)
+		if err != nil {
+			return nil, err
+		}
+		existing = append(existing, names...)
+	}
+
+	restored := make(map[string]int)
+	_, err := db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(existing) > 0 {
+			pipe.Del(ctx, existing...)
+		}
+		for _, s := range selected {
+			for _, k := range snap.Keys[s.Name] {
+				loadKey(ctx, pipe, k)
+			}
+			restored[s.Name] = len(snap.Keys[s.Name])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
+
+func dumpKey(ctx context.Context, db redis.Cmdable, name string) (*Key, error) {
+	typ, err := db.Type(ctx, name).Result()
+	if err != nil {
+		return nil, err
+	}
+	k := &Key{Name: name, Type: typ}
+	switch typ {
+	case "string":
+		if k.String, err = db.Get(ctx, name).Result(); err != nil {
+			return nil, err
+		}
+	case "hash":
+		all, err := db.HGetAll(ctx, name).Result()
+		if err != nil {
+			return nil, err
+		}
+		k.Hash = make(map[string]string, len(all))
+		for field, v := range all {
+			// Skip values that could not be encoded when they were stored.
+			if v != "" {
+				k.Hash[field] = v
+			}
+		}
+	case "zset":
+		members, err := db.ZRangeWithScores(ctx, name, 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, z := range members {
+			k.ZSet = append(k.ZSet, Member{Member: fmt.Sprint(z.Member), Score: z.Score})
+		}
+	case "none":
+		// Deleted since it was scanned.
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %q", typ)
+	}
+	return k, nil
+}
+
+func loadKey(ctx context.Context, pipe redis.Pipeliner, k Key) {
+	switch k.Type {
+	case "string":
+		pipe.Set(ctx, k.Name, k.String, 0)
+	case "hash":
+		if len(k.Hash) > 0 {
+			values := make(map[string]interface{}, len(k.Hash))
+			for field, v := range k.Hash {
+				values[field] = v
+			}
+			pipe.HSet(ctx, k.Name, values)
+		}
+	case "zset":
+		members := make([]*redis.Z, len(k.ZSet))
+		for i, m := range k.ZSet {
+			members[i] = &redis.Z{Member: m.Member, Score: m.Score}
+		}
+		if len(members) > 0 {
+			pipe.ZAdd(ctx, k.Name, members...)
+		}
+	}
+}
+
+// scanKeys returns the keys matching any of patterns.
+func scanKeys(ctx context.Context, db redis.Cmdable, patterns []string) ([]string, error) {
+	var names []string
+	for _, pattern := range patterns {
+		iter := db.Scan(ctx, 0, pattern, 500).Iterator()
+		for iter.Next(ctx) {
+			names = append(names, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+func writeJSON(tw *tar.Writer, name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+func sectionFor(file string) *Section {
+	for i := range Sections {
+		if Sections[i].Name+".json" == file {
+			return &Sections[i]
+		}
+	}
+	return nil
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -1,68 +1,409 @@
-package config
-
-import (
-	"fmt"
-	"os"
-	"strconv"
-
-	"github.com/joho/godotenv"
-)
-
-type Config struct {
-	RedisAddr    string
-	RedisDB      int
-	ServerPort   string
-	AuthUsername string
-	AuthPassword string
-}
-
-func LoadConfig(filename string) (*Config, error) {
-	// Load the environment file
-	err := godotenv.Load(filename)
-	if err != nil {
-		return nil, fmt.Errorf("error loading .env file: %v", err)
-	}
-
-	// Initialize the Config struct with default values
-	cfg := &Config{
-		RedisAddr:    getEnv("REDIS_ADDR", ""),
-		RedisDB:      getEnvInt("REDIS_DB", 0),
-		ServerPort:   getEnv("SERVER_PORT", "8080"),
-		AuthUsername: getEnv("AUTH_USERNAME", ""),
-		AuthPassword: getEnv("AUTH_PASSWORD", ""),
-	}
-
-	// Validate required configurations
-	if cfg.RedisAddr == "" {
-		return nil, fmt.Errorf("REDIS_ADDR is required")
-	}
-	if cfg.ServerPort == "" {
-		return nil, fmt.Errorf("SERVER_PORT is required")
-	}
-
-	return cfg, nil
-}
-
-// getEnv retrieves the value of the environment variable named by the key.
-// It returns the value, which will be the default value if the variable is not present.
-func getEnv(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
-	}
-	return value
-}
-
-// getEnvInt retrieves the value of the environment variable named by the key as an integer.
-// It returns the value, which will be the default value if the variable is not present.
-func getEnvInt(key string, defaultValue int) int {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
-	}
-	intValue, err := strconv.Atoi(value)
-	if err != nil {
-		return defaultValue
-	}
-	return intValue
-}
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+type Config struct {
+	RedisAddr    string
+	RedisDB      int
+	ServerPort   string
+	AuthUsername string
+	AuthPassword string
+	PluginDir    string
+	ArtifactDir  string
+	Broker       string
+	NATSURL      string
+	KafkaRESTURL string
+	EventsTopic  string
+	BaseURL      string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+	SlackToken   string
+	TelegramBot  string
+	QueueTimeout time.Duration
+
+	// Log entries at or below LogSampleLevel with the same message are
+	// sampled: LogSampleInitial each LogSampleTick, then every
+	// LogSampleThereafter-th. LogSampleInitial 0 logs them all.
+	LogLevel            string
+	LogSampleLevel      string
+	LogSampleInitial    int
+	LogSampleThereafter int
+	LogSampleTick       time.Duration
+
+	WSAPIKeys           []string
+	WSAllowedOrigins    []string
+	WSMaxConnsPerKey    int
+	WSMessagesPerSecond float64
+	WSMessageBurst      int
+	WSMaxMessageKB      int
+	WSPingInterval      time.Duration
+	WSPongWait          time.Duration
+	WSSessionTTL        time.Duration
+	WSSessionBuffer     int
+	WSLiveViewMinChange float64
+
+	MaxBodyMB   int
+	MaxUploadMB int
+
+	// Rate limits of the API, such as "10/s:20"; see handlers.ParseRateLimits.
+	RateLimitDefault string
+	RateLimitRoutes  []string
+	RateLimitKeys    []string
+	// Quotas of the workspaces, such as "acme:instances=5"; see
+	// quota.ParseLimits.
+	WorkspaceQuotas []string
+	// Timeouts of the handlers of the API, such as "30s"; see
+	// handlers.ParseRequestTimeouts.
+	RequestTimeoutDefault string
+	RequestTimeoutRoutes  []string
+
+	// Limits of the HTTP server itself. Writes are not bounded by default:
+	// uploads, exports and execute-sync answer slowly, and WebSocket
+	// connections go on for as long as they are used.
+	ServerReadTimeout       time.Duration
+	ServerReadHeaderTimeout time.Duration
+	ServerWriteTimeout      time.Duration
+	ServerIdleTimeout       time.Duration
+	ServerMaxHeaderKB       int
+
+	// MigrateDryRun makes startup report the pending migrations and exit.
+	MigrateDryRun bool
+
+	// Traces are exported over OTLP/HTTP when TracingEndpoint is set.
+	TracingEndpoint    string
+	TracingInsecure    bool
+	TracingServiceName string
+	TracingSampleRatio float64
+
+	JanitorInterval      time.Duration
+	ScreenshotRetention  time.Duration
+	ScreenshotMaxMB      int
+	ArtifactRetention    time.Duration
+	ArtifactMaxMB        int
+	DownloadDir          string
+	DownloadRetention    time.Duration
+	DownloadMaxMB        int
+	CrawlOutputDir       string
+	CrawlOutputRetention time.Duration
+	CrawlOutputMaxMB     int
+	TempProfileRetention time.Duration
+
+	HistoryMaxLen int
+
+	// TriggerFileRoot is the directory file triggers may watch and
+	// archive to; without it they can only watch S3.
+	TriggerFileRoot string
+
+	// How long the runs of flows without a retention of their own are
+	// kept, with their screenshots and artifacts; zero keeps them.
+	RunRetention           time.Duration
+	RunScreenshotRetention time.Duration
+	RunArtifactRetention   time.Duration
+
+	// Run quotas bound the runs of each priority class executing at once;
+	// zero is unbounded.
+	RunQuotaInteractive int
+	RunQuotaBatch       int
+
+	// OCREngine is "tesseract" or "http"; the ocr step is unavailable
+	// without one.
+	OCREngine     string
+	TesseractPath string
+	OCRURL        string
+	OCRAPIKey     string
+
+	// Mode is ModeServer, which serves the API and runs flows, or
+	// ModeAgent, which only runs the flows API servers queue for agents.
+	Mode string
+	// ReadOnly serves the API read-only and runs nothing, to mirror the
+	// servers sharing the Redis database.
+	ReadOnly         bool
+	AgentConcurrency int
+
+	// SelfTest launches a disposable Chrome at startup and exits when it
+	// does not work; see model.SelfTest.
+	SelfTest        bool
+	SelfTestTimeout time.Duration
+
+	// KubePodTemplate enables the kubernetes browser driver, which runs
+	// the browser of each instance in a pod made from this manifest.
+	// Without Kubeconfig the in-cluster service account is used.
+	KubePodTemplate  string
+	Kubeconfig       string
+	KubeNamespace    string
+	KubeCDPPort      int
+	KubeReadyTimeout time.Duration
+}
+
+// Modes of a process.
+const (
+	ModeServer = "server"
+	ModeAgent  = "agent"
+)
+
+// LoadConfig loads the configuration from the environment, the .env file
+// filename, if there is one, whose settings do not override those of the
+// environment, and the configuration files of CONFIG_FILES (see withFiles).
+// It fails with the Problems of a configuration that does not pass
+// Validate.
+func LoadConfig(filename string) (*Config, error) {
+	// Load the environment file
+	err := godotenv.Load(filename)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error loading .env file: %v", err)
+	}
+	lookup, err := withFiles(os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	cfg, problems := load(lookup)
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return cfg, nil
+}
+
+// Check returns the configuration LoadConfig would load and its problems,
+// reading the file anew without changing the environment.
+func Check(filename string) (*Config, Problems, error) {
+	file, err := godotenv.Read(filename)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("error loading .env file: %v", err)
+	}
+	lookup, err := withFiles(func(key string) (string, bool) {
+		if value, ok := os.LookupEnv(key); ok {
+			return value, true
+		}
+		value, ok := file[key]
+		return value, ok
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, problems := load(lookup)
+	return cfg, problems, nil
+}
+
+func load(lookup lookupFunc) (*Config, Problems) {
+	env := &environment{lookup: lookup}
+
+	// Initialize the Config struct with default values
+	cfg := &Config{
+		RedisAddr:    env.get("REDIS_ADDR", ""),
+		RedisDB:      env.getInt("REDIS_DB", 0),
+		ServerPort:   env.get("SERVER_PORT", "8080"),
+		AuthUsername: env.get("AUTH_USERNAME", ""),
+		AuthPassword: env.get("AUTH_PASSWORD", ""),
+		PluginDir:    env.get("PLUGIN_DIR", "plugins"),
+		ArtifactDir:  env.get("ARTIFACT_DIR", "artifacts"),
+		Broker:       env.get("BROKER", ""),
+		NATSURL:      env.get("NATS_URL", ""),
+		KafkaRESTURL: env.get("KAFKA_REST_URL", ""),
+		EventsTopic:  env.get("EVENTS_TOPIC", "umba.runs"),
+		BaseURL:      env.get("BASE_URL", ""),
+		SMTPHost:     env.get("SMTP_HOST", ""),
+		SMTPPort:     env.getInt("SMTP_PORT", 587),
+		SMTPUsername: env.get("SMTP_USERNAME", ""),
+		SMTPPassword: env.get("SMTP_PASSWORD", ""),
+		SMTPFrom:     env.get("SMTP_FROM", ""),
+		SlackToken:   env.get("SLACK_BOT_TOKEN", ""),
+		TelegramBot:  env.get("TELEGRAM_BOT_TOKEN", ""),
+		QueueTimeout: env.getDuration("INSTANCE_QUEUE_TIMEOUT", 5*time.Minute),
+
+		LogLevel:            env.get("LOG_LEVEL", "info"),
+		LogSampleLevel:      env.get("LOG_SAMPLE_LEVEL", "info"),
+		LogSampleInitial:    env.getInt("LOG_SAMPLE_INITIAL", 100),
+		LogSampleThereafter: env.getInt("LOG_SAMPLE_THEREAFTER", 100),
+		LogSampleTick:       env.getDuration("LOG_SAMPLE_TICK", time.Second),
+
+		WSAPIKeys:           env.getList("WS_API_KEYS"),
+		WSAllowedOrigins:    env.getList("WS_ALLOWED_ORIGINS"),
+		WSMaxConnsPerKey:    env.getInt("WS_MAX_CONNS_PER_KEY", 10),
+		WSMessagesPerSecond: env.getFloat("WS_MESSAGES_PER_SECOND", 10),
+		WSMessageBurst:      env.getInt("WS_MESSAGE_BURST", 20),
+		WSMaxMessageKB:      env.getInt("WS_MAX_MESSAGE_KB", 64),
+		WSPingInterval:      env.getDuration("WS_PING_INTERVAL", 30*time.Second),
+		WSPongWait:          env.getDuration("WS_PONG_WAIT", 60*time.Second),
+		WSSessionTTL:        env.getDuration("WS_SESSION_TTL", 2*time.Minute),
+		WSSessionBuffer:     env.getInt("WS_SESSION_BUFFER", 256),
+		WSLiveViewMinChange: env.getFloat("WS_LIVE_VIEW_MIN_CHANGE", 0.005),
+
+		MaxBodyMB:     env.getInt("MAX_BODY_MB", 1),
+		MaxUploadMB:   env.getInt("MAX_UPLOAD_MB", 256),
+		MigrateDryRun: env.getBool("MIGRATE_DRY_RUN", false),
+
+		RateLimitDefault: env.get("RATE_LIMIT_DEFAULT", ""),
+		RateLimitRoutes:  env.getList("RATE_LIMIT_ROUTES"),
+		RateLimitKeys:    env.getList("RATE_LIMIT_KEYS"),
+		WorkspaceQuotas:  env.getList("WORKSPACE_QUOTAS"),
+
+		RequestTimeoutDefault: env.get("REQUEST_TIMEOUT", ""),
+		RequestTimeoutRoutes:  env.getList("REQUEST_TIMEOUT_ROUTES"),
+
+		ServerReadTimeout:       env.getDuration("SERVER_READ_TIMEOUT", 0),
+		ServerReadHeaderTimeout: env.getDuration("SERVER_READ_HEADER_TIMEOUT", 10*time.Second),
+		ServerWriteTimeout:      env.getDuration("SERVER_WRITE_TIMEOUT", 0),
+		ServerIdleTimeout:       env.getDuration("SERVER_IDLE_TIMEOUT", 2*time.Minute),
+		ServerMaxHeaderKB:       env.getInt("SERVER_MAX_HEADER_KB", 1024),
+
+		TracingEndpoint:    env.get("OTLP_ENDPOINT", ""),
+		TracingInsecure:    env.getBool("OTLP_INSECURE", false),
+		TracingServiceName: env.get("OTEL_SERVICE_NAME", "umba"),
+		TracingSampleRatio: env.getFloat("TRACING_SAMPLE_RATIO", 1),
+
+		JanitorInterval:      env.getDuration("JANITOR_INTERVAL", time.Hour),
+		ScreenshotRetention:  env.getDuration("SCREENSHOT_RETENTION", 7*24*time.Hour),
+		ScreenshotMaxMB:      env.getInt("SCREENSHOT_MAX_MB", 0),
+		ArtifactRetention:    env.getDuration("ARTIFACT_RETENTION", 30*24*time.Hour),
+		ArtifactMaxMB:        env.getInt("ARTIFACT_MAX_MB", 0),
+		DownloadDir:          env.get("DOWNLOAD_DIR", ""),
+		DownloadRetention:    env.getDuration("DOWNLOAD_RETENTION", 7*24*time.Hour),
+		DownloadMaxMB:        env.getInt("DOWNLOAD_MAX_MB", 0),
+		CrawlOutputDir:       env.get("CRAWL_OUTPUT_DIR", ""),
+		CrawlOutputRetention: env.getDuration("CRAWL_OUTPUT_RETENTION", 30*24*time.Hour),
+		CrawlOutputMaxMB:     env.getInt("CRAWL_OUTPUT_MAX_MB", 0),
+		TempProfileRetention: env.getDuration("TEMP_PROFILE_RETENTION", 24*time.Hour),
+
+		HistoryMaxLen: env.getInt("HISTORY_MAX_LEN", 10000),
+
+		TriggerFileRoot: env.get("TRIGGER_FILE_ROOT", ""),
+
+		RunRetention:           env.getDuration("RUN_RETENTION", 0),
+		RunScreenshotRetention: env.getDuration("RUN_SCREENSHOT_RETENTION", 0),
+		RunArtifactRetention:   env.getDuration("RUN_ARTIFACT_RETENTION", 0),
+
+		RunQuotaInteractive: env.getInt("RUN_QUOTA_INTERACTIVE", 0),
+		RunQuotaBatch:       env.getInt("RUN_QUOTA_BATCH", 0),
+
+		OCREngine:     env.get("OCR_ENGINE", ""),
+		TesseractPath: env.get("TESSERACT_PATH", "tesseract"),
+		OCRURL:        env.get("OCR_URL", ""),
+		OCRAPIKey:     env.get("OCR_API_KEY", ""),
+
+		Mode:             env.get("MODE", ModeServer),
+		ReadOnly:         env.getBool("READ_ONLY", false),
+		AgentConcurrency: env.getInt("AGENT_CONCURRENCY", 2),
+
+		SelfTest:        env.getBool("SELF_TEST", false),
+		SelfTestTimeout: env.getDuration("SELF_TEST_TIMEOUT", 30*time.Second),
+
+		KubePodTemplate:  env.get("KUBE_POD_TEMPLATE", ""),
+		Kubeconfig:       env.get("KUBECONFIG", ""),
+		KubeNamespace:    env.get("KUBE_NAMESPACE", ""),
+		KubeCDPPort:      env.getInt("KUBE_CDP_PORT", 9222),
+		KubeReadyTimeout: env.getDuration("KUBE_READY_TIMEOUT", 2*time.Minute),
+	}
+
+	return cfg, append(env.problems, cfg.Validate()...)
+}
+
+// environment reads the settings of the configuration, recording those
+// set to a value of the wrong type, which then take their default.
+type environment struct {
+	lookup   lookupFunc
+	problems Problems
+}
+
+func (e *environment) value(key string) string {
+	value, _ := e.lookup(key)
+	return value
+}
+
+func (e *environment) invalid(key, value, want string) {
+	e.problems = append(e.problems, Problem{Setting: key, Value: value, Message: "must be " + want})
+}
+
+// get retrieves the value of the environment variable named by the key.
+// It returns the value, which will be the default value if the variable is not present.
+func (e *environment) get(key, defaultValue string) string {
+	value := e.value(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// getInt retrieves the value of the environment variable named by the key as an integer.
+// It returns the value, which will be the default value if the variable is not present.
+func (e *environment) getInt(key string, defaultValue int) int {
+	value := e.value(key)
+	if value == "" {
+		return defaultValue
+	}
+	intValue, err := strconv.Atoi(value)
+	if err != nil {
+		e.invalid(key, value, "an integer")
+		return defaultValue
+	}
+	return intValue
+}
+
+// getBool retrieves the value of the environment variable named by the key as a boolean such as "true".
+// It returns the value, which will be the default value if the variable is not present.
+func (e *environment) getBool(key string, defaultValue bool) bool {
+	value := e.value(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		e.invalid(key, value, "true or false")
+		return defaultValue
+	}
+	return b
+}
+
+// getList retrieves the value of the environment variable named by the key as a comma-separated list.
+// It returns nil if the variable is not present.
+func (e *environment) getList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(e.value(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getFloat retrieves the value of the environment variable named by the key as a float.
+// It returns the value, which will be the default value if the variable is not present.
+func (e *environment) getFloat(key string, defaultValue float64) float64 {
+	value := e.value(key)
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		e.invalid(key, value, "a number")
+		return defaultValue
+	}
+	return f
+}
+
+// getDuration retrieves the value of the environment variable named by the key as a duration such as "90s".
+// It returns the value, which will be the default value if the variable is not present.
+func (e *environment) getDuration(key string, defaultValue time.Duration) time.Duration {
+	value := e.value(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		e.invalid(key, value, `a duration such as "90s"`)
+		return defaultValue
+	}
+	return d
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type lookupFunc func(string) (string, bool)
+
+// withFiles returns lookup falling back on the configuration files it
+// names in CONFIG_FILES.
+//
+// A configuration file, YAML or JSON, sets the settings by their
+// environment variable names, in any case. Lists are joined with commas.
+// Its "profiles" hold settings that apply only under CONFIG_PROFILE:
+//
+//	REDIS_ADDR: localhost:6379
+//	RATE_LIMIT_ROUTES: ["POST /api/v1/flows/:id/execute=2/s"]
+//	profiles:
+//	  production:
+//	    LOG_LEVEL: warn
+//
+// The files named by CONFIG_FILES are layered: later files override
+// earlier ones, the settings of the profile override all of them, and the
+// environment, .env included, overrides everything.
+func withFiles(lookup lookupFunc) (lookupFunc, error) {
+	env := &environment{lookup: lookup}
+	paths := env.getList("CONFIG_FILES")
+	profile := env.get("CONFIG_PROFILE", "")
+	if len(paths) == 0 {
+		if profile != "" {
+			return nil, fmt.Errorf("CONFIG_PROFILE is %q but CONFIG_FILES names no configuration files", profile)
+		}
+		return lookup, nil
+	}
+
+	var bases, profiles []map[string]string
+	for _, path := range paths {
+		base, fileProfiles, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		bases = append(bases, base)
+		if settings, ok := fileProfiles[profile]; ok {
+			profiles = append(profiles, settings)
+		}
+	}
+	if profile != "" && len(profiles) == 0 {
+		return nil, fmt.Errorf("profile %q of CONFIG_PROFILE is not in any of the configuration files", profile)
+	}
+	// Highest precedence last.
+	layers := append(bases, profiles...)
+	return func(key string) (string, bool) {
+		if value, ok := lookup(key); ok && value != "" {
+			return value, true
+		}
+		for i := len(layers) - 1; i >= 0; i-- {
+			if value, ok := layers[i][key]; ok {
+				return value, true
+			}
+		}
+		return "", false
+	}, nil
+}
+
+// readConfigFile returns the settings of a configuration file and those of
+// each of its profiles.
+func readConfigFile(path string) (map[string]string, map[string]map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading configuration file: %v", err)
+	}
+	var doc map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".json":
+		err = json.Unmarshal(data, &doc)
+	default:
+		return nil, nil, fmt.Errorf("configuration file %s: must be .yaml, .yml or .json", path)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("configuration file %s: %v", path, err)
+	}
+
+	profiles := map[string]map[string]string{}
+	for key, value := range doc {
+		if !strings.EqualFold(key, "profiles") {
+			continue
+		}
+		delete(doc, key)
+		byName, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("configuration file %s: profiles must map names to settings", path)
+		}
+		for name, settings := range byName {
+			m, ok := settings.(map[string]interface{})
+			if !ok {
+				return nil, nil, fmt.Errorf("configuration file %s: profile %s must map settings to values", path, name)
+			}
+			if profiles[name], err = fileSettings(m); err != nil {
+				return nil, nil, fmt.Errorf("configuration file %s: profile %s: %v", path, name, err)
+			}
+		}
+	}
+	base, err := fileSettings(doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("configuration file %s: %v", path, err)
+	}
+	return base, profiles, nil
+}
+
+// fileSettings turns the values of a configuration file into the text
+// their environment variables would hold.
+func fileSettings(doc map[string]interface{}) (map[string]string, error) {
+	settings := make(map[string]string, len(doc))
+	for key, value := range doc {
+		var text string
+		if list, ok := value.([]interface{}); ok {
+			items := make([]string, len(list))
+			for i, item := range list {
+				s, ok := scalarText(item)
+				if !ok {
+					return nil, fmt.Errorf("%s must be a list of values", key)
+				}
+				items[i] = s
+			}
+			text = strings.Join(items, ",")
+		} else if s, ok := scalarText(value); ok {
+			text = s
+		} else {
+			return nil, fmt.Errorf("%s must be a value or a list of them", key)
+		}
+		settings[strings.ToUpper(key)] = text
+	}
+	return settings, nil
+}
+
+func scalarText(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case int:
+		return strconv.Itoa(v), true
+	case float64:
+		// JSON numbers are floats; whole ones must read back as integers.
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	}
+	return "", false
+}
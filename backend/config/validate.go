@@ -0,0 +1,191 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Problem is a setting of the configuration with a value that will not
+// work, and why.
+type Problem struct {
+	Setting string `json:"setting"`
+	Value   string `json:"value,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Value != "" {
+		return fmt.Sprintf("%s=%q: %s", p.Setting, p.Value, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.Setting, p.Message)
+}
+
+// Problems are all the problems of a configuration.
+type Problems []Problem
+
+func (p Problems) Error() string {
+	lines := make([]string, len(p))
+	for i, problem := range p {
+		lines[i] = problem.String()
+	}
+	return "invalid configuration: " + strings.Join(lines, "; ")
+}
+
+func (p *Problems) add(setting, value, format string, args ...interface{}) {
+	*p = append(*p, Problem{Setting: setting, Value: value, Message: fmt.Sprintf(format, args...)})
+}
+
+// logLevels are the levels of zap, the logger.
+var logLevels = []string{"debug", "info", "warn", "error", "dpanic", "panic", "fatal"}
+
+// Validate checks the values of the settings against what the subsystems
+// using them accept, and settings that only work together.
+func (c *Config) Validate() Problems {
+	var p Problems
+	if c.RedisAddr == "" {
+		p.add("REDIS_ADDR", "", "is required")
+	}
+	if c.ServerPort == "" {
+		p.add("SERVER_PORT", "", "is required")
+	} else if port, err := strconv.Atoi(c.ServerPort); err != nil || port < 1 || port > 65535 {
+		p.add("SERVER_PORT", c.ServerPort, "must be a port number from 1 to 65535")
+	}
+	p.oneOf("MODE", c.Mode, ModeServer, ModeAgent)
+	if c.ReadOnly && c.Mode == ModeAgent {
+		p.add("READ_ONLY", "true", "is not supported in agent mode")
+	}
+	p.oneOf("LOG_LEVEL", strings.ToLower(c.LogLevel), logLevels...)
+	p.oneOf("LOG_SAMPLE_LEVEL", strings.ToLower(c.LogSampleLevel), logLevels...)
+
+	p.oneOf("BROKER", c.Broker, "", "nats", "kafka")
+	if c.Broker == "nats" && c.NATSURL == "" {
+		p.add("NATS_URL", "", "is required when BROKER is nats")
+	}
+	if c.Broker == "kafka" && c.KafkaRESTURL == "" {
+		p.add("KAFKA_REST_URL", "", "is required when BROKER is kafka")
+	}
+	p.oneOf("OCR_ENGINE", c.OCREngine, "", "tesseract", "http")
+	if c.OCREngine == "http" && c.OCRURL == "" {
+		p.add("OCR_URL", "", "is required when OCR_ENGINE is http")
+	}
+	p.url("BASE_URL", c.BaseURL)
+	p.url("NATS_URL", c.NATSURL)
+	p.url("KAFKA_REST_URL", c.KafkaRESTURL)
+	p.url("OCR_URL", c.OCRURL)
+	if c.SMTPHost != "" && c.SMTPFrom == "" {
+		p.add("SMTP_FROM", "", "is required when SMTP_HOST is set")
+	}
+	p.port("SMTP_PORT", c.SMTPPort)
+	p.port("KUBE_CDP_PORT", c.KubeCDPPort)
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		p.add("TRACING_SAMPLE_RATIO", fmt.Sprint(c.TracingSampleRatio), "must be from 0 to 1")
+	}
+
+	for setting, n := range map[string]int{
+		"REDIS_DB":              c.RedisDB,
+		"LOG_SAMPLE_INITIAL":    c.LogSampleInitial,
+		"LOG_SAMPLE_THEREAFTER": c.LogSampleThereafter,
+		"SCREENSHOT_MAX_MB":     c.ScreenshotMaxMB,
+		"ARTIFACT_MAX_MB":       c.ArtifactMaxMB,
+		"DOWNLOAD_MAX_MB":       c.DownloadMaxMB,
+		"CRAWL_OUTPUT_MAX_MB":   c.CrawlOutputMaxMB,
+		"HISTORY_MAX_LEN":       c.HistoryMaxLen,
+		"RUN_QUOTA_INTERACTIVE": c.RunQuotaInteractive,
+		"RUN_QUOTA_BATCH":       c.RunQuotaBatch,
+	} {
+		if n < 0 {
+			p.add(setting, strconv.Itoa(n), "must not be negative")
+		}
+	}
+	for setting, n := range map[string]int{
+		"WS_MAX_CONNS_PER_KEY": c.WSMaxConnsPerKey,
+		"WS_MESSAGE_BURST":     c.WSMessageBurst,
+		"WS_MAX_MESSAGE_KB":    c.WSMaxMessageKB,
+		"WS_SESSION_BUFFER":    c.WSSessionBuffer,
+		"MAX_BODY_MB":          c.MaxBodyMB,
+		"MAX_UPLOAD_MB":        c.MaxUploadMB,
+		"SERVER_MAX_HEADER_KB": c.ServerMaxHeaderKB,
+		"AGENT_CONCURRENCY":    c.AgentConcurrency,
+	} {
+		if n < 1 {
+			p.add(setting, strconv.Itoa(n), "must be at least 1")
+		}
+	}
+	if c.WSMessagesPerSecond <= 0 {
+		p.add("WS_MESSAGES_PER_SECOND", fmt.Sprint(c.WSMessagesPerSecond), "must be more than 0")
+	}
+
+	for setting, d := range map[string]time.Duration{
+		"LOG_SAMPLE_TICK":            c.LogSampleTick,
+		"SERVER_READ_TIMEOUT":        c.ServerReadTimeout,
+		"SERVER_READ_HEADER_TIMEOUT": c.ServerReadHeaderTimeout,
+		"SERVER_WRITE_TIMEOUT":       c.ServerWriteTimeout,
+		"SERVER_IDLE_TIMEOUT":        c.ServerIdleTimeout,
+		"SCREENSHOT_RETENTION":       c.ScreenshotRetention,
+		"ARTIFACT_RETENTION":         c.ArtifactRetention,
+		"DOWNLOAD_RETENTION":         c.DownloadRetention,
+		"CRAWL_OUTPUT_RETENTION":     c.CrawlOutputRetention,
+		"TEMP_PROFILE_RETENTION":     c.TempProfileRetention,
+		"RUN_RETENTION":              c.RunRetention,
+		"RUN_SCREENSHOT_RETENTION":   c.RunScreenshotRetention,
+		"RUN_ARTIFACT_RETENTION":     c.RunArtifactRetention,
+	} {
+		if d < 0 {
+			p.add(setting, d.String(), "must not be negative")
+		}
+	}
+	for setting, d := range map[string]time.Duration{
+		"INSTANCE_QUEUE_TIMEOUT": c.QueueTimeout,
+		"WS_PING_INTERVAL":       c.WSPingInterval,
+		"WS_PONG_WAIT":           c.WSPongWait,
+		"WS_SESSION_TTL":         c.WSSessionTTL,
+		"JANITOR_INTERVAL":       c.JanitorInterval,
+		"SELF_TEST_TIMEOUT":      c.SelfTestTimeout,
+		"KUBE_READY_TIMEOUT":     c.KubeReadyTimeout,
+	} {
+		if d <= 0 {
+			p.add(setting, d.String(), "must be more than 0")
+		}
+	}
+	if c.WSPingInterval > 0 && c.WSPongWait > 0 && c.WSPongWait <= c.WSPingInterval {
+		p.add("WS_PONG_WAIT", c.WSPongWait.String(), "must be longer than WS_PING_INTERVAL (%s), or live connections are dropped", c.WSPingInterval)
+	}
+
+	// Maps are ranged in any order.
+	sort.SliceStable(p, func(i, j int) bool { return p[i].Setting < p[j].Setting })
+	return p
+}
+
+func (p *Problems) oneOf(setting, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	var names []string
+	for _, a := range allowed {
+		if a != "" {
+			names = append(names, a)
+		}
+	}
+	p.add(setting, value, "must be one of %s", strings.Join(names, ", "))
+}
+
+func (p *Problems) url(setting, value string) {
+	if value == "" {
+		return
+	}
+	if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+		p.add(setting, value, "must be an absolute URL such as https://host:port")
+	}
+}
+
+func (p *Problems) port(setting string, port int) {
+	if port < 1 || port > 65535 {
+		p.add(setting, strconv.Itoa(port), "must be a port number from 1 to 65535")
+	}
+}
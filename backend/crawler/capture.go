@@ -0,0 +1,119 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"auto/model"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	// maxResponses caps how many responses a single job records.
+	maxResponses = 5000
+	// maxBodySize caps how much of each response body is kept.
+	maxBodySize = 256 << 10
+)
+
+// capture tracks in-flight responses until their bodies are loaded. Its
+// fields are guarded by the job mutex.
+type capture struct {
+	documents map[network.RequestID]string
+	pending   map[network.RequestID]*model.Response
+	closed    bool
+}
+
+func newCapture() *capture {
+	return &capture{
+		documents: make(map[network.RequestID]string),
+		pending:   make(map[network.RequestID]*model.Response),
+	}
+}
+
+// wantsBody reports whether the response body is useful for analysis.
+func wantsBody(resp *network.Response) bool {
+	mime := resp.MimeType
+	return strings.HasPrefix(mime, "text/") || strings.Contains(mime, "json") || strings.Contains(mime, "xml")
+}
+
+// listen records traffic for job from the events of the crawl tab until
+// the returned capture is closed.
+func (c *Crawler) listen(ctx context.Context, job *Job) *capture {
+	tr := newCapture()
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch e := ev.(type) {
+		case *network.EventRequestWillBeSent:
+			job.mu.Lock()
+			tr.documents[e.RequestID] = e.DocumentURL
+			job.mu.Unlock()
+			if e.Type == network.ResourceTypeXHR || e.Type == network.ResourceTypeFetch {
+				job.addRequest(e.Request)
+			}
+		case *network.EventResponseReceived:
+			headers := make(map[string]string, len(e.Response.Headers))
+			for k, v := range e.Response.Headers {
+				headers[k] = fmt.Sprint(v)
+			}
+			job.mu.Lock()
+			resp := &model.Response{
+				URL:         e.Response.URL,
+				DocumentURL: tr.documents[e.RequestID],
+				Status:      int(e.Response.Status),
+				Headers:     headers,
+				MimeType:    e.Response.MimeType,
+			}
+			delete(tr.documents, e.RequestID)
+			if wantsBody(e.Response) {
+				tr.pending[e.RequestID] = resp
+			} else {
+				job.addResponse(resp)
+			}
+			job.mu.Unlock()
+		case *network.EventLoadingFinished:
+			job.mu.Lock()
+			resp, ok := tr.pending[e.RequestID]
+			delete(tr.pending, e.RequestID)
+			if ok && !tr.closed {
+				job.bodies.Add(1)
+				go c.fetchBody(ctx, job, e.RequestID, resp)
+			}
+			job.mu.Unlock()
+		case *network.EventLoadingFailed:
+			job.mu.Lock()
+			delete(tr.documents, e.RequestID)
+			delete(tr.pending, e.RequestID)
+			job.mu.Unlock()
+		}
+	})
+	return tr
+}
+
+// fetchBody loads the response body from the browser and records the
+// response. Bodies evicted by a later navigation are simply left empty.
+func (c *Crawler) fetchBody(ctx context.Context, job *Job, id network.RequestID, resp *model.Response) {
+	defer job.bodies.Done()
+	_ = c.chrome.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		body, err := network.GetResponseBody(id).Do(ctx)
+		if err != nil {
+			return err
+		}
+		if len(body) > maxBodySize {
+			body = body[:maxBodySize]
+		}
+		resp.Body = string(body)
+		return nil
+	}))
+	job.mu.Lock()
+	job.addResponse(resp)
+	job.mu.Unlock()
+}
+
+// addResponse appends resp to the job; callers must hold job.mu.
+func (j *Job) addResponse(resp *model.Response) {
+	if len(j.Responses) < maxResponses {
+		j.Responses = append(j.Responses, *resp)
+	}
+}
@@ -0,0 +1,332 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"auto/model"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// pageTimeout bounds how long a single page may take to load.
+const pageTimeout = 30 * time.Second
+
+const linksJS = `Array.from(document.querySelectorAll('a[href]')).map(a => a.href)`
+
+// Page is a document visited during a crawl.
+type Page struct {
+	URL   string `json:"url"`
+	Depth int    `json:"depth"`
+	Error string `json:"error,omitempty"`
+}
+
+// Job is a single crawl started from one URL.
+type Job struct {
+	ID         string                 `json:"id"`
+	StartURL   string                 `json:"start_url"`
+	Scope      Scope                  `json:"scope"`
+	Sitemap    bool                   `json:"sitemap"`
+	Proxy      *model.ProxyConfig     `json:"proxy,omitempty"`
+	Status     string                 `json:"status"`
+	Pages      []Page                 `json:"pages"`
+	Requests   []model.Request        `json:"requests"`
+	Responses  []model.Response       `json:"responses"`
+	WebSockets []model.WebSocketFrame `json:"websockets"`
+	Skipped    map[string]int         `json:"skipped"`
+	StartedAt  time.Time              `json:"started_at"`
+	FinishedAt time.Time              `json:"finished_at,omitempty"`
+	// ProxyReplay is the outcome of replaying the crawl through its proxy.
+	ProxyReplay *model.ReplayResult `json:"proxy_replay,omitempty"`
+
+	mu       sync.Mutex
+	requests map[string]bool
+	bodies   sync.WaitGroup
+	ws       *model.WebSocketCapture
+}
+
+// Crawler runs crawl jobs in their own browser contexts.
+type Crawler struct {
+	jobs   map[string]*Job
+	mu     sync.RWMutex
+	db     *redis.Client
+	chrome model.ChromeDPContext
+	client *http.Client
+	logger *zap.Logger
+}
+
+func NewCrawler(db *redis.Client, chrome model.ChromeDPContext, logger *zap.Logger) *Crawler {
+	return &Crawler{
+		jobs:   make(map[string]*Job),
+		db:     db,
+		chrome: chrome,
+		client: &http.Client{Timeout: pageTimeout},
+		logger: logger,
+	}
+}
+
+// Start validates the job scope and runs the crawl in the background. When
+// sitemap is set the frontier is seeded from the site's sitemaps. When proxy
+// is set the traffic of the crawl goes to it, for Burp or ZAP to pick up.
+func (c *Crawler) Start(startURL string, scope Scope, sitemap bool, proxy *model.ProxyConfig) (*Job, error) {
+	start, err := model.GetUrl(startURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start url: %w", err)
+	}
+	if proxy != nil {
+		if err := proxy.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	scope = scope.withDefaults()
+	matcher, err := scope.compile(start)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		ID:        uuid.New().String(),
+		StartURL:  start.String(),
+		Scope:     scope,
+		Sitemap:   sitemap,
+		Proxy:     proxy,
+		Status:    "running",
+		Skipped:   make(map[string]int),
+		StartedAt: time.Now(),
+		requests:  make(map[string]bool),
+		ws:        model.NewWebSocketCapture(model.DefaultWebSocketFrameLimit),
+	}
+	c.mu.Lock()
+	c.jobs[job.ID] = job
+	c.mu.Unlock()
+
+	go c.run(job, start, matcher)
+	return job.snapshot(), nil
+}
+
+// GetJob returns a running or finished crawl job.
+func (c *Crawler) GetJob(id string) (*Job, error) {
+	c.mu.RLock()
+	job, ok := c.jobs[id]
+	c.mu.RUnlock()
+	if ok {
+		return job.snapshot(), nil
+	}
+
+	data, err := c.db.Get(context.Background(), fmt.Sprintf("crawl:%s", id)).Bytes()
+	if err == redis.Nil {
+		return nil, errors.New("crawl job not found")
+	} else if err != nil {
+		return nil, err
+	}
+	var stored Job
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// GetJobs returns the crawl jobs known to this process.
+func (c *Crawler) GetJobs() []*Job {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	jobs := make([]*Job, 0, len(c.jobs))
+	for _, job := range c.jobs {
+		jobs = append(jobs, job.snapshot())
+	}
+	return jobs
+}
+
+type queued struct {
+	url   *model.URL
+	depth int
+}
+
+func (c *Crawler) run(job *Job, start *model.URL, matcher *scopeMatcher) {
+	parent := context.Background()
+	if job.Proxy != nil && job.Proxy.Mode == model.ProxyForward {
+		// The crawl gets a browser of its own going through the proxy.
+		allocCtx, cancelAlloc := job.Proxy.Allocator(parent)
+		defer cancelAlloc()
+		parent = allocCtx
+	}
+	ctx, cancel := c.chrome.NewContext(parent)
+	defer cancel()
+
+	var robots *robotsRules
+	if job.Scope.respectsRobots() || job.Sitemap {
+		robots = fetchRobots(ctx, c.client, start)
+	}
+	if job.Scope.respectsRobots() {
+		matcher.robots = robots
+	}
+
+	tr := c.listen(ctx, job)
+	job.ws.Listen(ctx)
+	var replay *model.RequestCapture
+	if job.Proxy != nil && job.Proxy.Mode == model.ProxyReplay {
+		replay = model.NewRequestCapture(model.DefaultRequestCaptureLimit)
+		replay.Listen(ctx)
+	}
+
+	queue := []queued{{url: start, depth: 0}}
+	seen := map[string]bool{start.NoFragmentUrl(): true}
+	if job.Sitemap {
+		for _, loc := range c.sitemapURLs(ctx, defaultSitemaps(start, robots), job.Scope.MaxPages) {
+			u, err := model.GetUrl(loc)
+			if err != nil || seen[u.NoFragmentUrl()] {
+				continue
+			}
+			seen[u.NoFragmentUrl()] = true
+			if ok, reason := matcher.allows(u, 1); !ok {
+				job.mu.Lock()
+				job.Skipped[reason]++
+				job.mu.Unlock()
+				continue
+			}
+			queue = append(queue, queued{url: u, depth: 1})
+		}
+	}
+	for len(queue) > 0 && len(job.Pages) < job.Scope.MaxPages {
+		next := queue[0]
+		queue = queue[1:]
+
+		links, err := c.visit(ctx, next.url)
+		page := Page{URL: next.url.String(), Depth: next.depth}
+		if err != nil {
+			page.Error = err.Error()
+			c.logger.Warn("Failed to crawl page", zap.String("crawlID", job.ID), zap.String("url", page.URL), zap.Error(err))
+		}
+		job.mu.Lock()
+		job.Pages = append(job.Pages, page)
+		job.mu.Unlock()
+
+		for _, href := range links {
+			u, err := model.GetUrl(href, *next.url)
+			if err != nil {
+				continue
+			}
+			key := u.NoFragmentUrl()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if ok, reason := matcher.allows(u, next.depth+1); !ok {
+				job.mu.Lock()
+				job.Skipped[reason]++
+				job.mu.Unlock()
+				continue
+			}
+			queue = append(queue, queued{url: u, depth: next.depth + 1})
+		}
+	}
+
+	job.mu.Lock()
+	tr.closed = true
+	job.mu.Unlock()
+	job.bodies.Wait()
+
+	if replay != nil {
+		result := job.Proxy.Replay(context.Background(), replay.Requests())
+		if result.Failed > 0 {
+			c.logger.Warn("Failed to replay some crawl requests through proxy", zap.String("crawlID", job.ID),
+				zap.Int("sent", result.Sent), zap.Int("failed", result.Failed))
+		}
+		job.mu.Lock()
+		job.ProxyReplay = result
+		job.mu.Unlock()
+	}
+
+	job.mu.Lock()
+	job.Status = "finished"
+	job.FinishedAt = time.Now()
+	job.mu.Unlock()
+	c.save(job)
+	c.logger.Info("Crawl finished", zap.String("crawlID", job.ID), zap.Int("pages", len(job.Pages)))
+}
+
+func (c *Crawler) visit(ctx context.Context, u *model.URL) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, pageTimeout)
+	defer cancel()
+
+	var links []string
+	err := c.chrome.Run(ctx,
+		network.Enable(),
+		chromedp.Navigate(u.String()),
+		chromedp.Evaluate(linksJS, &links),
+	)
+	return links, err
+}
+
+func (c *Crawler) save(job *Job) {
+	data, err := json.Marshal(job.snapshot())
+	if err != nil {
+		c.logger.Error("Failed to marshal crawl job", zap.String("crawlID", job.ID), zap.Error(err))
+		return
+	}
+	if err := c.db.Set(context.Background(), fmt.Sprintf("crawl:%s", job.ID), data, 0).Err(); err != nil {
+		c.logger.Error("Failed to save crawl job", zap.String("crawlID", job.ID), zap.Error(err))
+	}
+}
+
+// snapshot copies the job so it can be serialized while the crawl is
+// still appending to it.
+func (j *Job) snapshot() *Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	skipped := make(map[string]int, len(j.Skipped))
+	for k, v := range j.Skipped {
+		skipped[k] = v
+	}
+	return &Job{
+		ID:         j.ID,
+		StartURL:   j.StartURL,
+		Scope:      j.Scope,
+		Sitemap:    j.Sitemap,
+		Proxy:      j.Proxy,
+		Status:     j.Status,
+		Pages:      append([]Page(nil), j.Pages...),
+		Requests:   append([]model.Request(nil), j.Requests...),
+		Responses:  append([]model.Response(nil), j.Responses...),
+		WebSockets: j.webSocketFrames(),
+		Skipped:    skipped,
+		StartedAt:  j.StartedAt,
+		FinishedAt: j.FinishedAt,
+
+		ProxyReplay: j.ProxyReplay,
+	}
+}
+
+// webSocketFrames returns the frames captured so far, or those stored with
+// the job once it has been reloaded from Redis.
+func (j *Job) webSocketFrames() []model.WebSocketFrame {
+	if j.ws == nil {
+		return j.WebSockets
+	}
+	return j.ws.Frames()
+}
+
+func (j *Job) addRequest(r *network.Request) {
+	u, err := model.GetUrl(r.URL)
+	if err != nil {
+		return
+	}
+	req := model.GetRequest(r.Method, u, model.Options{Headers: r.Headers, PostData: model.DecodePostData(r.PostDataEntries)})
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.requests[req.UniqueId()] {
+		return
+	}
+	j.requests[req.UniqueId()] = true
+	j.Requests = append(j.Requests, req)
+}
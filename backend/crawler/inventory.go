@@ -0,0 +1,303 @@
+package crawler
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"auto/model"
+)
+
+var (
+	numericSegment = regexp.MustCompile(`^\d+$`)
+	uuidSegment    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hashSegment    = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+)
+
+// Param is a query or body parameter observed on an endpoint.
+type Param struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Endpoint groups captured requests sharing a method and path pattern.
+type Endpoint struct {
+	Method      string   `json:"method"`
+	Scheme      string   `json:"scheme"`
+	Host        string   `json:"host"`
+	Path        string   `json:"path"`
+	PathParams  []string `json:"path_params"`
+	QueryParams []Param  `json:"query_params"`
+	BodyParams  []Param  `json:"body_params"`
+	ContentType string   `json:"content_type,omitempty"`
+	Operation   string   `json:"operation,omitempty"`
+	Query       string   `json:"query,omitempty"`
+	Count       int      `json:"count"`
+	Example     string   `json:"example"`
+}
+
+// BuildInventory infers the API endpoints hit by requests, replacing IDs in
+// paths with named placeholders and merging the parameters of each call.
+func BuildInventory(requests []model.Request) []Endpoint {
+	index := make(map[string]*Endpoint)
+	for i := range requests {
+		req := &requests[i]
+		path, pathParams := pathPattern(req.URL.Path)
+		key := req.Method + " " + req.URL.Host + path
+		ops := req.GraphQL()
+		var operation, query string
+		if len(ops) == 1 {
+			operation = ops[0].Type + " " + ops[0].OperationName
+			query = ops[0].Query
+			key += " " + operation
+		}
+		ep, ok := index[key]
+		if !ok {
+			ep = &Endpoint{
+				Method:     req.Method,
+				Scheme:     req.URL.Scheme,
+				Host:       req.URL.Host,
+				Path:       path,
+				PathParams: pathParams,
+				Operation:  operation,
+				Query:      query,
+				Example:    req.SimpleFormat(),
+			}
+			index[key] = ep
+		}
+		ep.Count++
+		for name, value := range req.URL.QueryMap() {
+			ep.QueryParams = mergeParam(ep.QueryParams, name, paramType(value))
+		}
+		if len(ops) == 1 {
+			for name, value := range ops[0].Variables {
+				ep.BodyParams = mergeParam(ep.BodyParams, name, paramType(value))
+			}
+		} else if req.PostData != "" {
+			if ct, ok := req.Headers["Content-Type"].(string); ok {
+				ep.ContentType = ct
+			}
+			for name, value := range req.PostDataMap() {
+				ep.BodyParams = mergeParam(ep.BodyParams, name, paramType(value))
+			}
+		}
+	}
+
+	endpoints := make([]Endpoint, 0, len(index))
+	for _, ep := range index {
+		endpoints = append(endpoints, *ep)
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].Path == endpoints[j].Path {
+			if endpoints[i].Method == endpoints[j].Method {
+				return endpoints[i].Operation < endpoints[j].Operation
+			}
+			return endpoints[i].Method < endpoints[j].Method
+		}
+		return endpoints[i].Path < endpoints[j].Path
+	})
+	return endpoints
+}
+
+// pathPattern replaces variable path segments with placeholders.
+func pathPattern(path string) (string, []string) {
+	var params []string
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		var name string
+		switch {
+		case numericSegment.MatchString(seg):
+			name = "id"
+		case uuidSegment.MatchString(seg):
+			name = "uuid"
+		case hashSegment.MatchString(seg):
+			name = "hash"
+		default:
+			continue
+		}
+		if n := countPrefix(params, name); n > 0 {
+			name = fmt.Sprintf("%s%d", name, n+1)
+		}
+		params = append(params, name)
+		segments[i] = "{" + name + "}"
+	}
+	return strings.Join(segments, "/"), params
+}
+
+func countPrefix(names []string, prefix string) int {
+	n := 0
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func paramType(v interface{}) string {
+	switch v.(type) {
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}, []string:
+		return "array"
+	default:
+		return "string"
+	}
+}
+
+func mergeParam(params []Param, name, typ string) []Param {
+	for i, p := range params {
+		if p.Name == name {
+			if p.Type != typ {
+				params[i].Type = "string"
+			}
+			return params
+		}
+	}
+	return append(params, Param{Name: name, Type: typ})
+}
+
+// OpenAPI renders the inventory as an OpenAPI 3.0 document. When the
+// endpoints span several hosts, each path lists the hosts it was seen on
+// under its own servers, as the document servers would claim every path
+// for every host.
+func OpenAPI(title string, endpoints []Endpoint) map[string]interface{} {
+	paths := make(map[string]map[string]interface{})
+	servers := make(map[string]bool)
+	pathServers := make(map[string]map[string]bool)
+	for _, ep := range endpoints {
+		server := ep.Scheme + "://" + ep.Host
+		servers[server] = true
+		if pathServers[ep.Path] == nil {
+			pathServers[ep.Path] = make(map[string]bool)
+		}
+		pathServers[ep.Path][server] = true
+
+		var parameters []map[string]interface{}
+		for _, name := range ep.PathParams {
+			parameters = append(parameters, map[string]interface{}{
+				"name": name, "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, p := range ep.QueryParams {
+			parameters = append(parameters, map[string]interface{}{
+				"name": p.Name, "in": "query",
+				"schema": map[string]interface{}{"type": p.Type},
+			})
+		}
+		op := map[string]interface{}{
+			"summary":    ep.Example,
+			"parameters": parameters,
+			"responses":  map[string]interface{}{"default": map[string]interface{}{"description": "captured response"}},
+		}
+		if len(ep.BodyParams) > 0 {
+			props := make(map[string]interface{})
+			for _, p := range ep.BodyParams {
+				props[p.Name] = map[string]interface{}{"type": p.Type}
+			}
+			ct := ep.ContentType
+			if ct == "" {
+				ct = "application/json"
+			}
+			op["requestBody"] = map[string]interface{}{
+				"content": map[string]interface{}{
+					strings.Split(ct, ";")[0]: map[string]interface{}{
+						"schema": map[string]interface{}{"type": "object", "properties": props},
+					},
+				},
+			}
+		}
+		if paths[ep.Path] == nil {
+			paths[ep.Path] = make(map[string]interface{})
+		}
+		method := strings.ToLower(ep.Method)
+		if ep.Operation != "" {
+			// OpenAPI allows a single operation per method, so GraphQL
+			// operations on one endpoint are listed in an extension.
+			existing, ok := paths[ep.Path][method].(map[string]interface{})
+			if ok {
+				op = existing
+			}
+			ops, _ := op["x-graphql-operations"].([]string)
+			op["x-graphql-operations"] = append(ops, ep.Operation)
+			op["summary"] = "GraphQL endpoint"
+		}
+		paths[ep.Path][method] = op
+	}
+
+	if len(servers) > 1 {
+		for path, item := range paths {
+			item["servers"] = serverObjects(pathServers[path])
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    map[string]interface{}{"title": title, "version": "1.0.0"},
+		"servers": serverObjects(servers),
+		"paths":   paths,
+	}
+}
+
+// serverObjects lists the OpenAPI server objects of urls, sorted.
+func serverObjects(urls map[string]bool) []map[string]string {
+	var list []map[string]string
+	for url := range urls {
+		list = append(list, map[string]string{"url": url})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i]["url"] < list[j]["url"] })
+	return list
+}
+
+// PostmanCollection renders the inventory as a Postman v2.1 collection.
+func PostmanCollection(name string, endpoints []Endpoint) map[string]interface{} {
+	var items []map[string]interface{}
+	for _, ep := range endpoints {
+		path := ep.Path
+		for _, p := range ep.PathParams {
+			path = strings.Replace(path, "{"+p+"}", ":"+p, 1)
+		}
+		var query []map[string]string
+		for _, p := range ep.QueryParams {
+			query = append(query, map[string]string{"key": p.Name, "value": ""})
+		}
+		request := map[string]interface{}{
+			"method": ep.Method,
+			"url": map[string]interface{}{
+				"raw":      ep.Scheme + "://" + ep.Host + path,
+				"protocol": ep.Scheme,
+				"host":     strings.Split(ep.Host, "."),
+				"path":     strings.Split(strings.TrimPrefix(path, "/"), "/"),
+				"query":    query,
+			},
+		}
+		if ep.ContentType != "" {
+			request["header"] = []map[string]string{{"key": "Content-Type", "value": ep.ContentType}}
+		}
+		name := ep.Method + " " + ep.Path
+		if ep.Operation != "" {
+			name = ep.Operation
+			request["body"] = map[string]interface{}{
+				"mode":    "graphql",
+				"graphql": map[string]string{"query": ep.Query, "variables": "{}"},
+			}
+		}
+		items = append(items, map[string]interface{}{
+			"name":    name,
+			"request": request,
+		})
+	}
+	return map[string]interface{}{
+		"info": map[string]interface{}{
+			"name":   name,
+			"schema": "https://schema.getpostman.com/json/collection/v2.1.0/collection.json",
+		},
+		"item": items,
+	}
+}
@@ -0,0 +1,126 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"auto/model"
+)
+
+// robotsRule is a single Allow or Disallow line for the "*" user agent.
+type robotsRule struct {
+	pattern string
+	allow   bool
+	re      *regexp.Regexp
+}
+
+// robotsRules holds the rules that apply to every user agent and the
+// sitemaps advertised by the site.
+type robotsRules struct {
+	rules    []robotsRule
+	sitemaps []string
+}
+
+// allowed applies the longest matching rule, preferring Allow on ties, as
+// described in RFC 9309.
+func (r *robotsRules) allowed(path string) bool {
+	if path == "" {
+		path = "/"
+	}
+	best := -1
+	allow := true
+	for _, rule := range r.rules {
+		if !rule.re.MatchString(path) {
+			continue
+		}
+		if len(rule.pattern) > best || (len(rule.pattern) == best && rule.allow) {
+			best = len(rule.pattern)
+			allow = rule.allow
+		}
+	}
+	return allow
+}
+
+// fetchRobots downloads and parses robots.txt for the host of u. A missing
+// or unreadable file allows everything.
+func fetchRobots(ctx context.Context, client *http.Client, u *model.URL) *robotsRules {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.Scheme+"://"+u.Host+"/robots.txt", nil)
+	if err != nil {
+		return &robotsRules{}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return &robotsRules{}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &robotsRules{}
+	}
+	return parseRobots(resp.Body)
+}
+
+func parseRobots(r io.Reader) *robotsRules {
+	rules := &robotsRules{}
+	applies := false
+	inAgents := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				applies = false
+			}
+			inAgents = true
+			if value == "*" {
+				applies = true
+			}
+		case "allow", "disallow":
+			inAgents = false
+			if applies && value != "" {
+				rules.rules = append(rules.rules, robotsRule{
+					pattern: value,
+					allow:   key == "allow",
+					re:      robotsPattern(value),
+				})
+			}
+		case "sitemap":
+			// Sitemap lines are independent of user-agent groups.
+			if value != "" {
+				rules.sitemaps = append(rules.sitemaps, value)
+			}
+		default:
+			inAgents = false
+		}
+	}
+	return rules
+}
+
+// robotsPattern converts a robots.txt path pattern, which may use "*" and a
+// trailing "$", into an anchored regular expression.
+func robotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
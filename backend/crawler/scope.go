@@ -0,0 +1,165 @@
+package crawler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"auto/model"
+)
+
+const (
+	// DomainAny follows links to any host.
+	DomainAny = "any"
+	// DomainSame only follows links on the exact start host.
+	DomainSame = "same-domain"
+	// DomainSameRoot follows links on any subdomain of the start root domain.
+	DomainSameRoot = "same-root-domain"
+)
+
+// DefaultIgnoreExtensions lists static asset extensions that are never
+// worth opening as pages.
+var DefaultIgnoreExtensions = []string{
+	"png", "jpg", "jpeg", "gif", "svg", "ico", "webp",
+	"css", "js", "map", "woff", "woff2", "ttf", "eot",
+	"pdf", "zip", "gz", "mp3", "mp4", "avi", "mov",
+}
+
+// Scope restricts which URLs a crawl job visits. MaxDepth and
+// RespectRobots are pointers so that a job can set them to 0 or false
+// rather than get the default: a MaxDepth of 0 crawls the start page only.
+type Scope struct {
+	Include          []string `json:"include"`
+	Exclude          []string `json:"exclude"`
+	MaxDepth         *int     `json:"max_depth"`
+	MaxPages         int      `json:"max_pages"`
+	IgnoreExtensions []string `json:"ignore_extensions"`
+	RespectRobots    *bool    `json:"respect_robots"`
+	DomainPolicy     string   `json:"domain_policy"`
+}
+
+// DefaultScope is used for any field a crawl job leaves unset.
+var DefaultScope = Scope{
+	MaxDepth:         intPtr(3),
+	MaxPages:         100,
+	IgnoreExtensions: DefaultIgnoreExtensions,
+	RespectRobots:    boolPtr(true),
+	DomainPolicy:     DomainSame,
+}
+
+func intPtr(v int) *int { return &v }
+
+func boolPtr(v bool) *bool { return &v }
+
+type scopeMatcher struct {
+	scope   Scope
+	start   *model.URL
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	ignore  map[string]bool
+	robots  *robotsRules
+}
+
+func (s Scope) withDefaults() Scope {
+	if s.MaxDepth == nil {
+		s.MaxDepth = intPtr(*DefaultScope.MaxDepth)
+	}
+	if s.MaxPages == 0 {
+		s.MaxPages = DefaultScope.MaxPages
+	}
+	if s.IgnoreExtensions == nil {
+		s.IgnoreExtensions = DefaultScope.IgnoreExtensions
+	}
+	if s.RespectRobots == nil {
+		s.RespectRobots = boolPtr(*DefaultScope.RespectRobots)
+	}
+	if s.DomainPolicy == "" {
+		s.DomainPolicy = DefaultScope.DomainPolicy
+	}
+	return s
+}
+
+// respectsRobots reports whether the crawl obeys robots.txt.
+func (s Scope) respectsRobots() bool {
+	return s.RespectRobots == nil || *s.RespectRobots
+}
+
+func (s Scope) compile(start *model.URL) (*scopeMatcher, error) {
+	m := &scopeMatcher{
+		scope:  s,
+		start:  start,
+		ignore: make(map[string]bool),
+	}
+	if s.MaxDepth != nil && *s.MaxDepth < 0 {
+		return nil, fmt.Errorf("max_depth must not be negative: %d", *s.MaxDepth)
+	}
+	switch s.DomainPolicy {
+	case DomainAny, DomainSame, DomainSameRoot:
+	default:
+		return nil, fmt.Errorf("unknown domain policy: %s", s.DomainPolicy)
+	}
+	for _, expr := range s.Include {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %w", expr, err)
+		}
+		m.include = append(m.include, re)
+	}
+	for _, expr := range s.Exclude {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %w", expr, err)
+		}
+		m.exclude = append(m.exclude, re)
+	}
+	for _, ext := range s.IgnoreExtensions {
+		m.ignore[strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+	}
+	return m, nil
+}
+
+// allows reports whether u found at depth should be crawled, and if not,
+// why it was skipped.
+func (m *scopeMatcher) allows(u *model.URL, depth int) (bool, string) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false, "scheme"
+	}
+	if m.scope.MaxDepth != nil && depth > *m.scope.MaxDepth {
+		return false, "depth"
+	}
+	switch m.scope.DomainPolicy {
+	case DomainSame:
+		if u.Hostname() != m.start.Hostname() {
+			return false, "domain"
+		}
+	case DomainSameRoot:
+		if u.RootDomain() != m.start.RootDomain() {
+			return false, "domain"
+		}
+	}
+	if m.ignore[u.FileExt()] {
+		return false, "extension"
+	}
+	raw := u.String()
+	if len(m.include) > 0 {
+		matched := false
+		for _, re := range m.include {
+			if re.MatchString(raw) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, "include"
+		}
+	}
+	for _, re := range m.exclude {
+		if re.MatchString(raw) {
+			return false, "exclude"
+		}
+	}
+	if m.scope.respectsRobots() && m.robots != nil && !m.robots.allowed(u.Path) {
+		return false, "robots"
+	}
+	return true, ""
+}
@@ -0,0 +1,97 @@
+package crawler
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"auto/model"
+
+	"go.uber.org/zap"
+)
+
+// maxSitemapDepth limits how deep nested sitemap indexes are followed.
+const maxSitemapDepth = 3
+
+// sitemapDoc covers both <urlset> and <sitemapindex> documents.
+type sitemapDoc struct {
+	XMLName  xml.Name `xml:""`
+	URLs     []string `xml:"url>loc"`
+	Sitemaps []string `xml:"sitemap>loc"`
+}
+
+// sitemapURLs collects page URLs from the given sitemaps, following nested
+// indexes, until limit URLs have been found.
+func (c *Crawler) sitemapURLs(ctx context.Context, sitemaps []string, limit int) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	var walk func(loc string, depth int)
+	walk = func(loc string, depth int) {
+		if seen[loc] || depth > maxSitemapDepth || len(urls) >= limit {
+			return
+		}
+		seen[loc] = true
+		doc, err := c.fetchSitemap(ctx, loc)
+		if err != nil {
+			c.logger.Warn("Failed to read sitemap", zap.String("url", loc), zap.Error(err))
+			return
+		}
+		for _, u := range doc.URLs {
+			if len(urls) >= limit {
+				return
+			}
+			urls = append(urls, strings.TrimSpace(u))
+		}
+		for _, nested := range doc.Sitemaps {
+			walk(strings.TrimSpace(nested), depth+1)
+		}
+	}
+	for _, loc := range sitemaps {
+		walk(loc, 0)
+	}
+	return urls
+}
+
+func (c *Crawler) fetchSitemap(ctx context.Context, loc string) (*sitemapDoc, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, loc, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var body io.Reader = resp.Body
+	if strings.HasSuffix(loc, ".gz") || resp.Header.Get("Content-Type") == "application/x-gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	var doc sitemapDoc
+	if err := xml.NewDecoder(body).Decode(&doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// defaultSitemaps returns the sitemaps to read for start: those advertised
+// in robots.txt, or /sitemap.xml when there are none.
+func defaultSitemaps(start *model.URL, robots *robotsRules) []string {
+	if robots != nil && len(robots.sitemaps) > 0 {
+		return robots.sitemaps
+	}
+	return []string{start.Scheme + "://" + start.Host + "/sitemap.xml"}
+}
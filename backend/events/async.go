@@ -0,0 +1,85 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultBufferSize is how many events wait for the broker before new ones
+// are dropped.
+const DefaultBufferSize = 1024
+
+// publishTimeout bounds the time the broker gets for one event.
+const publishTimeout = 10 * time.Second
+
+// asyncPublisher hands events to a background goroutine that publishes
+// them, so a slow or unreachable broker does not hold up runs.
+type asyncPublisher struct {
+	next   Publisher
+	logger *zap.Logger
+	queue  chan Event
+	done   chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// Async publishes the events given to the returned publisher to next in
+// the background, buffering up to size of them. Events that do not fit are
+// dropped; they and the failures of next are logged, as publishing is best
+// effort.
+func Async(next Publisher, size int, logger *zap.Logger) Publisher {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	p := &asyncPublisher{
+		next:   next,
+		logger: logger,
+		queue:  make(chan Event, size),
+		done:   make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *asyncPublisher) run() {
+	defer close(p.done)
+	for e := range p.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		if err := p.next.Publish(ctx, e); err != nil {
+			p.logger.Warn("Failed to publish event", zap.String("event", e.Type), zap.String("runID", e.RunID), zap.Error(err))
+		}
+		cancel()
+	}
+}
+
+func (p *asyncPublisher) Publish(ctx context.Context, e Event) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errors.New("event publisher is closed")
+	}
+	select {
+	case p.queue <- e:
+		return nil
+	default:
+		p.logger.Warn("Dropped event, the broker is not keeping up", zap.String("event", e.Type), zap.String("runID", e.RunID))
+		return nil
+	}
+}
+
+// Close publishes the events still buffered, then closes next.
+func (p *asyncPublisher) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+	<-p.done
+	return p.next.Close()
+}
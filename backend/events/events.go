@@ -0,0 +1,120 @@
+// Package events publishes run lifecycle events and extraction outputs to a
+// message broker so downstream pipelines can consume results without
+// polling the API.
+package events
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	RunQueued    = "run.queued"
+	RunStarted   = "run.started"
+	RunSucceeded = "run.succeeded"
+	RunFailed    = "run.failed"
+	RunDegraded  = "run.degraded"
+	RunOutput    = "run.output"
+	// RunRetried is published when a run starts over after the browser
+	// failed under it.
+	RunRetried = "run.retried"
+
+	InstanceCrashed = "instance.crashed"
+)
+
+// Event is a single message published to the broker.
+type Event struct {
+	Type       string      `json:"type"`
+	RunID      string      `json:"run_id,omitempty"`
+	FlowID     string      `json:"flow_id,omitempty"`
+	InstanceID string      `json:"instance_id,omitempty"`
+	Time       time.Time   `json:"time"`
+	Data       interface{} `json:"data,omitempty"`
+
+	// Labels are those of the run, see flow.WithLabels.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Publisher sends events to a broker.
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+	Close() error
+}
+
+// Config selects and configures the broker. Broker is "nats", "kafka" or
+// empty to disable publishing. Topic is the Kafka topic, or the NATS
+// subject prefix to which the event type is appended.
+type Config struct {
+	Broker       string
+	NATSURL      string
+	KafkaRESTURL string
+	Topic        string
+
+	// BufferSize is how many events wait for the broker, DefaultBufferSize
+	// if zero. Logger gets the events that could not be published.
+	BufferSize int
+	Logger     *zap.Logger
+}
+
+// New returns the publisher for cfg, or a no-op publisher when no broker
+// is configured. Events go to the broker in the background, see Async.
+func New(cfg Config) (Publisher, error) {
+	var p Publisher
+	var err error
+	switch cfg.Broker {
+	case "":
+		return Nop{}, nil
+	case "nats":
+		p, err = NewNATSPublisher(cfg.NATSURL, cfg.Topic)
+	case "kafka":
+		p, err = NewKafkaPublisher(cfg.KafkaRESTURL, cfg.Topic)
+	default:
+		return nil, fmt.Errorf("unknown broker: %s", cfg.Broker)
+	}
+	if err != nil {
+		return nil, err
+	}
+	size := cfg.BufferSize
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	return Async(p, size, cfg.Logger), nil
+}
+
+// Tee publishes every event to each of publishers, returning the first
+// error.
+func Tee(publishers ...Publisher) Publisher {
+	return tee(publishers)
+}
+
+type tee []Publisher
+
+func (t tee) Publish(ctx context.Context, e Event) error {
+	var first error
+	for _, p := range t {
+		if err := p.Publish(ctx, e); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (t tee) Close() error {
+	var first error
+	for _, p := range t {
+		if err := p.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Nop discards every event.
+type Nop struct{}
+
+func (Nop) Publish(ctx context.Context, e Event) error { return nil }
+
+func (Nop) Close() error { return nil }
@@ -0,0 +1,71 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// KafkaPublisher produces events to a topic through a Kafka REST Proxy (v2
+// API), keyed by run ID so the events of a run stay in one partition. Each
+// Publish waits for the proxy; New puts it behind Async.
+type KafkaPublisher struct {
+	endpoint string
+	client   *http.Client
+}
+
+type kafkaRecords struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+type kafkaRecord struct {
+	Key   string `json:"key"`
+	Value Event  `json:"value"`
+}
+
+func NewKafkaPublisher(restURL string, topic string) (*KafkaPublisher, error) {
+	if restURL == "" {
+		return nil, errors.New("KAFKA_REST_URL is required for the kafka broker")
+	}
+	if topic == "" {
+		return nil, errors.New("a topic is required for the kafka broker")
+	}
+	return &KafkaPublisher{
+		endpoint: strings.TrimSuffix(restURL, "/") + "/topics/" + url.PathEscape(topic),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, e Event) error {
+	body, err := json.Marshal(kafkaRecords{Records: []kafkaRecord{{Key: e.RunID, Value: e}}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("kafka rest proxy returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (p *KafkaPublisher) Close() error {
+	return nil
+}
@@ -0,0 +1,45 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATSPublisher publishes each event on "<prefix>.<event type>". New puts
+// it behind Async, so a reconnecting connection does not hold up runs.
+type NATSPublisher struct {
+	conn   *nats.Conn
+	prefix string
+}
+
+func NewNATSPublisher(url string, prefix string) (*NATSPublisher, error) {
+	if url == "" {
+		url = nats.DefaultURL
+	}
+	conn, err := nats.Connect(url, nats.Name("umba"), nats.Timeout(5*time.Second))
+	if err != nil {
+		return nil, err
+	}
+	return &NATSPublisher{conn: conn, prefix: prefix}, nil
+}
+
+func (p *NATSPublisher) Publish(ctx context.Context, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	subject := e.Type
+	if p.prefix != "" {
+		subject = p.prefix + "." + e.Type
+	}
+	return p.conn.Publish(subject, data)
+}
+
+func (p *NATSPublisher) Close() error {
+	err := p.conn.FlushTimeout(5 * time.Second)
+	p.conn.Close()
+	return err
+}
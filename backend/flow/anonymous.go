@@ -0,0 +1,53 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+
+	"auto/apperr"
+	"auto/model"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type instanceKey struct{}
+
+// withInstance returns a context under which the next flow run started
+// runs on the instance with the ID id, whatever its flow says.
+func withInstance(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, instanceKey{}, id)
+}
+
+// ExecuteAnonymous runs a flow once on an instance made for the run from
+// opts, and destroys the instance when the run is over, successful or
+// not. It returns the run, with its results, once it is done; a run that
+// fails is returned along with its error. The run stops when ctx is
+// cancelled. A cached result is returned without making an instance.
+func (m *Manager) ExecuteAnonymous(ctx context.Context, flowID string, instanceManager *model.InstanceManager, opts model.AnonymousInstance, params map[string]interface{}, priority string) (*Run, error) {
+	flow, err := m.GetFlow(flowID)
+	if err != nil {
+		return nil, apperr.New(apperr.CodeFlowNotFound, fmt.Sprintf("flow not found: %s", flowID))
+	}
+	if run := m.cachedRun(ctx, flow, params); run != nil {
+		return run, nil
+	}
+	instance, err := instanceManager.StartAnonymousInstance(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := instanceManager.DestroyInstance(instance.ID); err != nil {
+			m.log(ctx).Error("Failed to destroy anonymous instance", zap.String("instanceID", instance.ID), zap.Error(err))
+		}
+	}()
+
+	runID := uuid.New().String()
+	err = m.execute(withInstance(WithRunID(ctx, runID), instance.ID), flowID, instanceManager, params, priority, nil)
+	run, getErr := m.GetRun(runID)
+	if getErr != nil {
+		// The run failed before it started, waiting for its lane.
+		return nil, err
+	}
+	return run, err
+}
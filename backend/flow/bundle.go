@@ -0,0 +1,304 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"auto/apperr"
+
+	"github.com/google/uuid"
+)
+
+// BundleVersion is the format version written by ExportFlow.
+const BundleVersion = 1
+
+// Bundle is a portable copy of one flow, including its hooks, outputs and
+// notification channels; templates and scripts live in step params and
+// travel with them. Secret values are replaced by "{{secret:NAME}}"
+// placeholders listed in Secrets, and the instance binding is dropped since
+// instance IDs mean nothing in another deployment.
+type Bundle struct {
+	Version    int         `json:"version"`
+	ExportedAt time.Time   `json:"exported_at"`
+	Flow       FlowImpl    `json:"flow"`
+	Secrets    []SecretRef `json:"secrets,omitempty"`
+}
+
+// SecretRef names a value that was left out of a bundle and says where it
+// was used.
+type SecretRef struct {
+	Name  string `json:"name"`
+	Usage string `json:"usage"`
+}
+
+// MissingSecretsError is returned by ImportFlow when secrets referenced by
+// the bundle were not supplied.
+type MissingSecretsError struct {
+	Missing []SecretRef
+}
+
+func (e *MissingSecretsError) Error() string {
+	names := make([]string, len(e.Missing))
+	for i, s := range e.Missing {
+		names[i] = s.Name
+	}
+	return "missing secrets: " + strings.Join(names, ", ")
+}
+
+func (e *MissingSecretsError) ErrorCode() apperr.Code {
+	return apperr.CodeMissingSecrets
+}
+
+func (e *MissingSecretsError) ErrorDetails() map[string]interface{} {
+	return map[string]interface{}{"missing_secrets": e.Missing}
+}
+
+var (
+	secretParamName = regexp.MustCompile(`(?i)(password|passwd|secret|token|api_?key|credential|authorization|cookie)`)
+	secretRef       = regexp.MustCompile(`\{\{secret:([A-Za-z0-9_.-]+)\}\}`)
+	// storedSecret matches the "secret" calls of output templates, which
+	// read the secrets store rather than hold a value.
+	storedSecret = regexp.MustCompile(`\{\{-?\s*secret\s`)
+)
+
+func secretPlaceholder(name string) string {
+	return "{{secret:" + name + "}}"
+}
+
+// ExportFlow returns a flow as a bundle with its secrets stripped.
+func (m *Manager) ExportFlow(id string) (*Bundle, error) {
+	source, err := m.GetFlow(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(copyFlow(source))
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{Version: BundleVersion, ExportedAt: time.Now().UTC()}
+	if err := json.Unmarshal(data, &b.Flow); err != nil {
+		return nil, err
+	}
+	b.Flow.InstanceID = ""
+	b.Flow.Revision = 0
+
+	secret := func(name, usage string) string {
+		b.Secrets = append(b.Secrets, SecretRef{Name: name, Usage: usage})
+		return secretPlaceholder(name)
+	}
+	stripSteps := func(kind string, steps []Step) {
+		for i, step := range steps {
+			for key, value := range step.Params {
+				if s, ok := value.(string); ok && s != "" && isSecretParam(step, key) {
+					name := fmt.Sprintf("%s_%d_%s", kind, i+1, key)
+					step.Params[key] = secret(name, fmt.Sprintf("%s %d (%s) param %q", kind, i+1, step.Action, key))
+				}
+			}
+		}
+	}
+	stripSteps("step", b.Flow.Steps)
+	stripSteps("before_each", b.Flow.Hooks.BeforeEach)
+	stripSteps("after_each", b.Flow.Hooks.AfterEach)
+	stripSteps("on_failure", b.Flow.Hooks.OnFailure)
+
+	// Webhook URLs often embed their credentials, in the path as those of
+	// chat services do or in a token query param, so none are exported.
+	for i, p := range b.Flow.Outputs {
+		for j := range p.Destinations {
+			d := &p.Destinations[j]
+			for header, value := range d.Headers {
+				if storedSecret.MatchString(value) {
+					continue
+				}
+				name := fmt.Sprintf("output_%d_destination_%d_%s", i+1, j+1, header)
+				d.Headers[header] = secret(name, fmt.Sprintf("header %q of output %q destination %d", header, p.Name, j+1))
+			}
+			if d.URL != "" && !storedSecret.MatchString(d.URL) {
+				name := fmt.Sprintf("output_%d_destination_%d_url", i+1, j+1)
+				d.URL = secret(name, fmt.Sprintf("URL of output %q destination %d", p.Name, j+1))
+			}
+		}
+	}
+	for i := range b.Flow.Notifications {
+		ch := &b.Flow.Notifications[i]
+		for header := range ch.Headers {
+			name := fmt.Sprintf("notification_%d_%s", i+1, header)
+			ch.Headers[header] = secret(name, fmt.Sprintf("header %q of %s notification %d", header, ch.Type, i+1))
+		}
+		if ch.URL != "" {
+			ch.URL = secret(fmt.Sprintf("notification_%d_url", i+1), fmt.Sprintf("webhook URL of %s notification %d", ch.Type, i+1))
+		}
+	}
+	// The proxy URL is kept unless it holds a username and password.
+	if p := b.Flow.Proxy; p != nil {
+		if u, err := url.Parse(p.URL); err != nil || u.User != nil {
+			p.URL = secret("proxy_url", "URL of the proxy, with its credentials")
+		}
+	}
+
+	sort.Slice(b.Secrets, func(i, j int) bool { return b.Secrets[i].Name < b.Secrets[j].Name })
+	return b, nil
+}
+
+// isSecretParam reports whether a step param holds a credential: its name
+// says so, or it is the text typed into a password field.
+func isSecretParam(step Step, key string) bool {
+	if secretParamName.MatchString(key) {
+		return true
+	}
+	if step.Action != "sendKeys" || key != "value" {
+		return false
+	}
+	for k, v := range step.Params {
+		if s, ok := v.(string); ok && k != "value" && strings.Contains(strings.ToLower(s), "password") {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportFlow creates a new flow from a bundle, filling secret placeholders
+// from secrets and binding it to instanceID. The flow and its steps get new
+// IDs. If secrets are missing nothing is created and a *MissingSecretsError
+// lists them.
+func (m *Manager) ImportFlow(b *Bundle, secrets map[string]string, instanceID string) (Flow, error) {
+	if b.Version != BundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+	data, err := json.Marshal(b.Flow)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make(map[string]string, len(b.Secrets))
+	for _, s := range b.Secrets {
+		usage[s.Name] = s.Usage
+	}
+	var missing []SecretRef
+	seen := make(map[string]bool)
+	for _, match := range secretRef.FindAllSubmatch(data, -1) {
+		name := string(match[1])
+		if _, ok := secrets[name]; !ok && !seen[name] {
+			seen[name] = true
+			missing = append(missing, SecretRef{Name: name, Usage: usage[name]})
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &MissingSecretsError{Missing: missing}
+	}
+
+	f := &FlowImpl{}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, err
+	}
+	fillSecrets(f, secrets)
+	f.InstanceID = instanceID
+	f.Revision = 1
+
+	f, err = remapIDs(f)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.repo.CreateFlow(context.Background(), f); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.flows[f.ID] = f
+	m.mu.Unlock()
+
+	flowJSON, _ := json.Marshal(f)
+	m.cache.HSet(context.Background(), "flows", f.ID, flowJSON)
+
+	return f, nil
+}
+
+// fillSecrets replaces the placeholders in the string values of a flow.
+// This is done on the decoded flow rather than the JSON so secret values
+// need no escaping.
+func fillSecrets(f *FlowImpl, secrets map[string]string) {
+	fill := func(s string) string {
+		return secretRef.ReplaceAllStringFunc(s, func(ref string) string {
+			return secrets[secretRef.FindStringSubmatch(ref)[1]]
+		})
+	}
+	for _, steps := range [][]Step{f.Steps, f.Hooks.BeforeEach, f.Hooks.AfterEach, f.Hooks.OnFailure} {
+		for _, step := range steps {
+			for key, value := range step.Params {
+				if s, ok := value.(string); ok {
+					step.Params[key] = fill(s)
+				}
+			}
+		}
+	}
+	for _, p := range f.Outputs {
+		for j := range p.Destinations {
+			d := &p.Destinations[j]
+			d.URL = fill(d.URL)
+			for header, value := range d.Headers {
+				d.Headers[header] = fill(value)
+			}
+		}
+	}
+	if f.Proxy != nil {
+		f.Proxy.URL = fill(f.Proxy.URL)
+	}
+	for i := range f.Notifications {
+		ch := &f.Notifications[i]
+		ch.URL = fill(ch.URL)
+		for header, value := range ch.Headers {
+			ch.Headers[header] = fill(value)
+		}
+	}
+}
+
+// remapIDs gives a flow and its steps new IDs. Step results are keyed by
+// step ID, so templates, scripts and output sources refer to steps by ID;
+// every occurrence of an old ID is rewritten to keep them working. Only
+// generated (UUID) IDs are replaced, as hand-picked ones like "login" are
+// too likely to appear in unrelated text; they are kept as they are.
+func remapIDs(f *FlowImpl) (*FlowImpl, error) {
+	ids := make(map[string]string)
+	remap := func(id string) {
+		if _, err := uuid.Parse(id); err == nil && len(id) == 36 {
+			ids[id] = uuid.New().String()
+		}
+	}
+	remap(f.ID)
+	for _, steps := range [][]Step{f.Steps, f.Hooks.BeforeEach, f.Hooks.AfterEach, f.Hooks.OnFailure} {
+		for _, step := range steps {
+			remap(step.ID)
+		}
+	}
+	pairs := make([]string, 0, 2*len(ids))
+	for old, id := range ids {
+		pairs = append(pairs, old, id)
+	}
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	data = []byte(strings.NewReplacer(pairs...).Replace(string(data)))
+	remapped := &FlowImpl{}
+	if err := json.Unmarshal(data, remapped); err != nil {
+		return nil, err
+	}
+	if _, ok := ids[f.ID]; !ok {
+		remapped.ID = uuid.New().String()
+	}
+	for _, steps := range [][]Step{remapped.Steps, remapped.Hooks.BeforeEach, remapped.Hooks.AfterEach, remapped.Hooks.OnFailure} {
+		for i := range steps {
+			if steps[i].ID == "" {
+				steps[i].ID = uuid.New().String()
+			}
+		}
+	}
+	return remapped, nil
+}
@@ -0,0 +1,385 @@
+package flow
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/url"
+	"strings"
+)
+
+// curlValueOptions are the curl options taking a value that make no
+// difference to the request sent.
+var curlValueOptions = map[string]bool{
+	"-o": true, "--output": true, "-m": true, "--max-time": true, "--connect-timeout": true,
+	"--retry": true, "-x": true, "--proxy": true, "--resolve": true, "--cacert": true,
+	"--cert": true, "-E": true, "--key": true, "-w": true, "--write-out": true,
+	"-c": true, "--cookie-jar": true, "--max-redirs": true, "-U": true, "--proxy-user": true,
+	"--limit-rate": true, "-D": true, "--dump-header": true, "--trace": true, "--trace-ascii": true,
+}
+
+// ImportCurl creates a flow with an httpRequest step for each of the curl
+// commands in cmds, such as those browsers copy from their network panel.
+// Commands are separated by newlines, ";" or "&&".
+func (m *Manager) ImportCurl(cmds string, dest ImportTarget) (*RequestImport, error) {
+	commands, err := splitShell(cmds)
+	if err != nil {
+		return nil, err
+	}
+	var steps []Step
+	for i, args := range commands {
+		req, err := parseCurl(args)
+		if err != nil {
+			return nil, fmt.Errorf("curl command %d: %w", i+1, err)
+		}
+		step, err := req.step()
+		if err != nil {
+			return nil, fmt.Errorf("curl command %d: %w", i+1, err)
+		}
+		steps = append(steps, step)
+	}
+	if len(steps) == 0 {
+		return nil, errors.New("no curl commands given")
+	}
+	return m.createImport("curl import", dest, steps, importParams{}, 0)
+}
+
+// parseCurl reads the request of the arguments of a curl command.
+func parseCurl(args []string) (*importedRequest, error) {
+	if len(args) == 0 || args[0] != "curl" {
+		return nil, errors.New("not a curl command")
+	}
+	req := &importedRequest{headers: map[string]interface{}{}}
+	var data, form []string
+	get, head, isJSON := false, false, false
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		value := func() (string, error) {
+			// Short options take their value attached too, as in -XPOST.
+			if len(arg) > 2 && arg[0] == '-' && arg[1] != '-' {
+				return arg[2:], nil
+			}
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("option %s needs a value", arg)
+			}
+			i++
+			return args[i], nil
+		}
+		name := arg
+		if len(arg) > 2 && arg[0] == '-' && arg[1] != '-' {
+			name = arg[:2]
+		}
+
+		var v string
+		var err error
+		switch name {
+		case "-X", "--request":
+			if v, err = value(); err == nil {
+				req.method = strings.ToUpper(v)
+			}
+		case "-H", "--header":
+			if v, err = value(); err == nil {
+				if k, hv, ok := strings.Cut(v, ":"); ok && strings.TrimSpace(k) != "" {
+					req.headers[strings.TrimSpace(k)] = strings.TrimSpace(hv)
+				}
+			}
+		case "-A", "--user-agent":
+			if v, err = value(); err == nil {
+				req.headers["User-Agent"] = v
+			}
+		case "-e", "--referer":
+			if v, err = value(); err == nil {
+				req.headers["Referer"] = v
+			}
+		case "-b", "--cookie":
+			// Without "=" the value is a cookie file.
+			if v, err = value(); err == nil && strings.Contains(v, "=") {
+				req.headers["Cookie"] = v
+			}
+		case "-u", "--user":
+			if v, err = value(); err == nil {
+				user, pass, _ := strings.Cut(v, ":")
+				req.auth = map[string]interface{}{"type": "basic", "username": user, "password": pass}
+			}
+		case "-d", "--data", "--data-raw", "--data-binary", "--data-ascii", "--json":
+			if v, err = value(); err == nil {
+				if strings.HasPrefix(v, "@") && name != "--data-raw" {
+					return nil, fmt.Errorf("%s reads a file, which is not supported", arg)
+				}
+				data = append(data, v)
+				isJSON = isJSON || name == "--json"
+			}
+		case "--data-urlencode":
+			if v, err = value(); err == nil {
+				data = append(data, curlURLEncode(v))
+			}
+		case "-F", "--form", "--form-string":
+			if v, err = value(); err == nil {
+				form = append(form, v)
+			}
+		case "--url":
+			if v, err = value(); err == nil {
+				req.url = v
+			}
+		case "-T", "--upload-file":
+			return nil, fmt.Errorf("%s uploads a file, which is not supported", arg)
+		case "-G", "--get":
+			get = true
+		case "-I", "--head":
+			head = true
+		default:
+			switch {
+			case curlValueOptions[name]:
+				_, err = value()
+			case strings.HasPrefix(arg, "-") && arg != "-":
+				// A flag such as --compressed, -k or -L.
+			case req.url == "":
+				req.url = arg
+			default:
+				return nil, fmt.Errorf("unexpected argument %q", arg)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if req.url == "" {
+		return nil, errors.New("no URL")
+	}
+	if !strings.Contains(req.url, "://") {
+		req.url = "http://" + req.url
+	}
+	u, err := url.Parse(req.url)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q", req.url)
+	}
+
+	switch {
+	case len(data) > 0 && get:
+		// -G sends the data as the query string.
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += strings.Join(data, "&")
+		req.url = u.String()
+	case len(data) > 0:
+		req.body = strings.Join(data, "&")
+		if isJSON {
+			setDefaultHeader(req, "Content-Type", "application/json")
+			setDefaultHeader(req, "Accept", "application/json")
+		}
+		setDefaultHeader(req, "Content-Type", "application/x-www-form-urlencoded")
+	case len(form) > 0:
+		if err := curlMultipart(req, form); err != nil {
+			return nil, err
+		}
+	}
+	if req.method == "" {
+		switch {
+		case head:
+			req.method = "HEAD"
+		case req.body != "":
+			req.method = "POST"
+		default:
+			req.method = "GET"
+		}
+	}
+	return req, nil
+}
+
+func setDefaultHeader(req *importedRequest, name, value string) {
+	if _, ok := req.header(name); !ok {
+		req.headers[name] = value
+	}
+}
+
+// curlURLEncode encodes a --data-urlencode value: "name=content",
+// "=content" or "content".
+func curlURLEncode(v string) string {
+	if strings.HasPrefix(v, "=") {
+		return url.QueryEscape(v[1:])
+	}
+	if name, content, ok := strings.Cut(v, "="); ok {
+		return name + "=" + url.QueryEscape(content)
+	}
+	return url.QueryEscape(v)
+}
+
+// curlMultipart sets the body of req to the multipart form of the -F
+// fields. Fields uploading files are not supported.
+func curlMultipart(req *importedRequest, fields []string) error {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, field := range fields {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return fmt.Errorf("invalid form field %q", field)
+		}
+		if strings.HasPrefix(value, "@") || strings.HasPrefix(value, "<") {
+			return fmt.Errorf("form field %q reads a file, which is not supported", name)
+		}
+		value, _, _ = strings.Cut(value, ";type=")
+		if err := w.WriteField(name, value); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	req.body = buf.String()
+	req.headers["Content-Type"] = w.FormDataContentType()
+	return nil
+}
+
+// splitShell splits shell commands into their words, as a POSIX shell
+// does for commands without expansions: quotes, backslash escapes and line
+// continuations, and the $'...' strings of bash. Commands end at unquoted
+// newlines, ";" and "&&".
+func splitShell(s string) ([][]string, error) {
+	var commands [][]string
+	var words []string
+	var word strings.Builder
+	inWord := false
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	endCommand := func() {
+		endWord()
+		if len(words) > 0 {
+			commands = append(commands, words)
+			words = nil
+		}
+	}
+
+	r := []rune(s)
+	for i := 0; i < len(r); i++ {
+		c := r[i]
+		switch {
+		case c == '\\':
+			if i+1 < len(r) && r[i+1] == '\r' {
+				i++
+			}
+			if i+1 < len(r) && r[i+1] == '\n' {
+				i++
+				continue
+			}
+			if i+1 < len(r) {
+				i++
+				word.WriteRune(r[i])
+				inWord = true
+			}
+		case c == '\'':
+			end := indexRune(r, i+1, '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated ' quote")
+			}
+			word.WriteString(string(r[i+1 : end]))
+			inWord = true
+			i = end
+		case c == '$' && i+1 < len(r) && r[i+1] == '\'':
+			end, err := ansiQuoted(r, i+2, &word)
+			if err != nil {
+				return nil, err
+			}
+			inWord = true
+			i = end
+		case c == '"':
+			i++
+			for ; i < len(r) && r[i] != '"'; i++ {
+				if r[i] == '\\' && i+1 < len(r) && strings.ContainsRune("$`\"\\\n", r[i+1]) {
+					i++
+					if r[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteRune(r[i])
+			}
+			if i >= len(r) {
+				return nil, errors.New(`unterminated " quote`)
+			}
+			inWord = true
+		case c == '\n' || c == ';':
+			endCommand()
+		case c == '&' && i+1 < len(r) && r[i+1] == '&':
+			i++
+			endCommand()
+		case c == ' ' || c == '\t' || c == '\r':
+			endWord()
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	endCommand()
+	return commands, nil
+}
+
+func indexRune(r []rune, from int, c rune) int {
+	for i := from; i < len(r); i++ {
+		if r[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// ansiQuoted reads a $'...' string from r[from:] into word and returns the
+// index of its closing quote.
+func ansiQuoted(r []rune, from int, word *strings.Builder) (int, error) {
+	escapes := map[rune]string{'n': "\n", 't': "\t", 'r': "\r", '\\': "\\", '\'': "'", '"': "\"", '0': "\x00"}
+	for i := from; i < len(r); i++ {
+		switch {
+		case r[i] == '\'':
+			return i, nil
+		case r[i] == '\\' && i+1 < len(r):
+			i++
+			if s, ok := escapes[r[i]]; ok {
+				word.WriteString(s)
+			} else if r[i] == 'u' || r[i] == 'x' {
+				n := 4
+				if r[i] == 'x' {
+					n = 2
+				}
+				var code rune
+				j := i + 1
+				for ; j < len(r) && j <= i+n && isHex(r[j]); j++ {
+					code = code*16 + hexValue(r[j])
+				}
+				if j == i+1 {
+					word.WriteRune('\\')
+					word.WriteRune(r[i])
+					continue
+				}
+				word.WriteRune(code)
+				i = j - 1
+			} else {
+				word.WriteRune('\\')
+				word.WriteRune(r[i])
+			}
+		default:
+			word.WriteRune(r[i])
+		}
+	}
+	return 0, errors.New("unterminated $' quote")
+}
+
+func isHex(c rune) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexValue(c rune) rune {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
@@ -0,0 +1,63 @@
+package flow
+
+import (
+	"context"
+	"errors"
+
+	"auto/model"
+)
+
+// Debug commands, the answers to a debug run paused before a step. Step
+// runs the step and pauses before the next one, Continue runs the rest of
+// the flow without pausing and Abort ends the run there.
+const (
+	DebugStep     = "step"
+	DebugContinue = "continue"
+	DebugAbort    = "abort"
+)
+
+var errDebugAborted = errors.New("run aborted from the debugger")
+
+// DebugPause is a debug run waiting before a step. Step carries the
+// parameters it is about to run with; Screenshot is the page as it is, a
+// base64 PNG, empty if it could not be taken.
+type DebugPause struct {
+	RunID      string
+	Index      int
+	Step       Step
+	Screenshot string
+}
+
+// DebugCommand says how a paused run goes on. Non-nil Params replace the
+// parameters of the step, placeholders and all.
+type DebugCommand struct {
+	Command string
+	Params  map[string]interface{}
+}
+
+// Debugger is asked how to go on before each step of a debug run. Pause
+// blocks the run until it returns.
+type Debugger interface {
+	Pause(p DebugPause) DebugCommand
+}
+
+// DebuggerFunc adapts a function to the Debugger interface.
+type DebuggerFunc func(p DebugPause) DebugCommand
+
+func (f DebuggerFunc) Pause(p DebugPause) DebugCommand {
+	return f(p)
+}
+
+// DebugFlow runs a flow like ExecuteFlowWithParams, pausing before each
+// step until dbg says how to go on.
+func (m *Manager) DebugFlow(flowID string, instanceManager *model.InstanceManager, params map[string]interface{}, dbg Debugger) error {
+	return m.execute(context.Background(), flowID, instanceManager, params, model.PriorityInteractive, dbg)
+}
+
+func screenshot(instance *model.Instance) string {
+	data, err := instance.Execute("screenshot", nil)
+	if err != nil {
+		return ""
+	}
+	return data
+}
@@ -0,0 +1,104 @@
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"auto/model"
+)
+
+// extractVariables sets the run variables the "extract" parameter of an
+// httpRequest step asks for, from the body of its response, e.g.
+//
+//	{"orderId": "$.id", "csrf": {"regex": "name=\"csrf\" value=\"([^\"]+)\""}}
+//
+// A string starting with "$" is a JSONPath, any other a regular
+// expression; {"jsonPath": ...} and {"regex": ..., "group": n} say which
+// explicitly. A regular expression yields its first group, or else its
+// whole match. Later steps use the variables as "{{var:NAME}}".
+func extractVariables(step Step, body string, results map[string]string) error {
+	extract, _ := step.Params["extract"].(map[string]interface{})
+	names := make([]string, 0, len(extract))
+	for name := range extract {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var doc interface{}
+	parsed := false
+	for _, name := range names {
+		kind, spec, group := extractSpec(extract[name])
+		switch kind {
+		case "jsonPath":
+			if !parsed {
+				if err := json.Unmarshal([]byte(body), &doc); err != nil {
+					return fmt.Errorf("extract %s: body is not JSON", name)
+				}
+				parsed = true
+			}
+			values, err := model.JSONPath(doc, spec)
+			if err != nil {
+				return fmt.Errorf("extract %s: %w", name, err)
+			}
+			if len(values) == 0 {
+				return fmt.Errorf("extract %s: %s matched nothing", name, spec)
+			}
+			var value interface{} = values
+			if len(values) == 1 {
+				value = values[0]
+			}
+			results[name] = model.JSONValueText(value)
+		case "regex":
+			re, err := regexp.Compile(spec)
+			if err != nil {
+				return fmt.Errorf("extract %s: %w", name, err)
+			}
+			m := re.FindStringSubmatch(body)
+			if m == nil {
+				return fmt.Errorf("extract %s: %q matched nothing", name, spec)
+			}
+			if group < 0 {
+				group = 0
+				if len(m) > 1 {
+					group = 1
+				}
+			}
+			if group >= len(m) {
+				return fmt.Errorf("extract %s: %q has no group %d", name, spec, group)
+			}
+			results[name] = m[group]
+		default:
+			return fmt.Errorf("extract %s: want a JSONPath or a regular expression", name)
+		}
+	}
+	return nil
+}
+
+// extractSpec reads what to extract: "jsonPath" or "regex", the path or
+// pattern, and the group of a pattern, -1 when not given.
+func extractSpec(v interface{}) (string, string, int) {
+	switch v := v.(type) {
+	case string:
+		if strings.HasPrefix(v, "$") {
+			return "jsonPath", v, -1
+		}
+		if v != "" {
+			return "regex", v, -1
+		}
+	case map[string]interface{}:
+		if path, ok := v["jsonPath"].(string); ok && path != "" {
+			return "jsonPath", path, -1
+		}
+		if pattern, ok := v["regex"].(string); ok && pattern != "" {
+			group := -1
+			if g, ok := v["group"].(float64); ok && g >= 0 {
+				group = int(g)
+			}
+			return "regex", pattern, group
+		}
+	}
+	return "", "", -1
+}
@@ -0,0 +1,20 @@
+package flow
+
+import (
+	"context"
+
+	"auto/analysis"
+	"auto/model"
+
+	"go.uber.org/zap"
+)
+
+// analyzePage adds the page a step left instance on to the traffic of the
+// run in pages, and updates the findings of run from all of it.
+func (m *Manager) analyzePage(ctx context.Context, run *Run, instance *model.Instance, pages *model.PageCapture) {
+	if err := instance.SnapshotPage(ctx, pages); err != nil {
+		m.log(ctx).Debug("Failed to read the page of a step for findings", zap.Error(err))
+	}
+	report := analysis.Analyze(pages.Traffic())
+	run.Findings = &report
+}
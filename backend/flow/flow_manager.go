@@ -1,400 +1,1319 @@
-package flow
-
-import (
-	"bytes"
-	"context"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"sync"
-	"text/template"
-	"time"
-
-	"auto/model"
-
-	"github.com/go-redis/redis/v8"
-	"github.com/google/uuid"
-	"go.uber.org/zap"
-)
-
-type FlowRepository interface {
-	CreateFlow(ctx context.Context, f Flow) error
-	GetFlow(ctx context.Context, id string) (Flow, error)
-	GetFlows(ctx context.Context) ([]Flow, error)
-	UpdateFlow(ctx context.Context, f Flow) error
-	DeleteFlow(ctx context.Context, id string) error
-}
-
-type Flow interface {
-	GetID() string
-	GetName() string
-	GetInstanceID() string
-	GetSteps() []Step
-	SetSteps(steps []Step)
-}
-
-type Step struct {
-	ID     string                 `json:"id"`
-	Action string                 `json:"action"`
-	Params map[string]interface{} `json:"params"`
-}
-
-type FlowImpl struct {
-	ID         string `json:"id"`
-	Name       string `json:"name"`
-	InstanceID string `json:"instance_id"`
-	Steps      []Step `json:"steps"`
-}
-
-func (f *FlowImpl) GetID() string {
-	return f.ID
-}
-
-func (f *FlowImpl) GetName() string {
-	return f.Name
-}
-
-func (f *FlowImpl) GetInstanceID() string {
-	return f.InstanceID
-}
-
-func (f *FlowImpl) GetSteps() []Step {
-	return f.Steps
-}
-
-func (f *FlowImpl) SetSteps(steps []Step) {
-	f.Steps = steps
-}
-
-type Manager struct {
-	flows  map[string]Flow
-	mu     sync.RWMutex
-	db     *redis.Client
-	repo   FlowRepository
-	logger *zap.Logger
-	cache  *redis.Client
-}
-
-func NewManager(db *redis.Client, repo FlowRepository, logger *zap.Logger, cache *redis.Client) *Manager {
-	m := &Manager{
-		flows:  make(map[string]Flow),
-		db:     db,
-		repo:   repo,
-		logger: logger,
-		cache:  cache,
-	}
-	if err := m.loadFlowsFromDB(); err != nil {
-		m.logger.Fatal("Failed to load flows from DB", zap.Error(err))
-	}
-	return m
-}
-
-func (m *Manager) loadFlowsFromDB() error {
-	flows, err := m.repo.GetFlows(context.Background())
-	if err != nil {
-		m.logger.Error("Failed to load flows from DB", zap.Error(err))
-		return err
-	}
-	for _, flow := range flows {
-		m.flows[flow.GetID()] = flow
-	}
-	return nil
-}
-
-func (m *Manager) CreateFlow(name string, instanceID string) Flow {
-	flow := &FlowImpl{
-		ID:         uuid.New().String(),
-		Name:       name,
-		InstanceID: instanceID,
-		Steps:      []Step{},
-	}
-
-	m.mu.Lock()
-	m.flows[flow.ID] = flow
-	m.mu.Unlock()
-
-	// Store flow details in Redis
-	flowJSON, _ := json.Marshal(flow)
-	m.cache.HSet(context.Background(), "flows", flow.ID, flowJSON)
-
-	err := m.repo.CreateFlow(context.Background(), flow)
-	if err != nil {
-		m.logger.Error("Failed to create flow in DB", zap.Error(err))
-		return nil
-	}
-
-	return flow
-}
-
-func (m *Manager) UpdateFlow(flow Flow) error {
-	m.mu.Lock()
-	m.flows[flow.GetID()] = flow
-	m.mu.Unlock()
-
-	// Update flow details in Redis
-	flowJSON, _ := json.Marshal(flow)
-	m.cache.HSet(context.Background(), "flows", flow.GetID(), flowJSON)
-
-	return m.repo.UpdateFlow(context.Background(), flow)
-}
-
-func (m *Manager) DeleteFlow(id string) error {
-	m.mu.Lock()
-	delete(m.flows, id)
-	m.mu.Unlock()
-
-	// Remove flow from Redis
-	m.cache.HDel(context.Background(), "flows", id)
-
-	return m.repo.DeleteFlow(context.Background(), id)
-}
-
-func (m *Manager) GetFlows() []Flow {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	flows := make([]Flow, 0, len(m.flows))
-	for _, flow := range m.flows {
-		flows = append(flows, flow)
-	}
-	return flows
-}
-
-func (m *Manager) AddStep(flowID string, action string, params map[string]interface{}) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	flow, exists := m.flows[flowID]
-	if !exists {
-		return fmt.Errorf("flow not found: %s", flowID)
-	}
-
-	step := Step{
-		ID:     uuid.New().String(),
-		Action: action,
-		Params: params,
-	}
-
-	steps := flow.GetSteps()
-	steps = append(steps, step)
-	flow.SetSteps(steps)
-
-	return m.repo.UpdateFlow(context.Background(), flow)
-}
-
-func (m *Manager) SaveToFile(filename string) error {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	data, err := json.MarshalIndent(m.flows, "", "  ")
-	if err != nil {
-		m.logger.Error("Failed to marshal flows", zap.Error(err))
-		return err
-	}
-
-	return ioutil.WriteFile(filename, data, 0644)
-}
-
-func (m *Manager) LoadFromFile(filename string) error {
-	data, err := ioutil.ReadFile(filename)
-	if err != nil {
-		m.logger.Error("Failed to read flows file", zap.Error(err))
-		return err
-	}
-
-	var flows map[string]Flow
-	if err := json.Unmarshal(data, &flows); err != nil {
-		m.logger.Error("Failed to unmarshal flows", zap.Error(err))
-		return err
-	}
-
-	m.mu.Lock()
-	m.flows = flows
-	m.mu.Unlock()
-
-	return nil
-}
-
-func (m *Manager) ExecuteFlow(flowID string, instanceManager model.InstanceManager) error {
-	m.mu.RLock()
-	flow, exists := m.flows[flowID]
-	m.mu.RUnlock()
-
-	if !exists {
-		return fmt.Errorf("flow not found: %s", flowID)
-	}
-
-	instance, err := instanceManager.GetInstance(flow.GetInstanceID())
-	if err != nil {
-		return fmt.Errorf("failed to get instance: %w", err)
-	}
-
-	instanceResponses := make(map[string]string)
-
-	for _, step := range flow.GetSteps() {
-		switch step.Action {
-		case "template":
-			tmpl, err := template.New("response").Parse(step.Params["template"].(string))
-			if err != nil {
-				return err
-			}
-			var result bytes.Buffer
-			err = tmpl.Execute(&result, instanceResponses)
-			if err != nil {
-				return err
-			}
-			instanceResponses["templateResult"] = result.String()
-		default:
-			result, err := instance.Execute(step.Action, step.Params)
-			if err != nil {
-				m.logger.Error("Step execution failed", zap.String("flowID", flowID), zap.String("stepID", step.ID), zap.Error(err))
-				return fmt.Errorf("failed to execute step %s: %w", step.ID, err)
-			}
-			instanceResponses[step.ID] = result
-		}
-	}
-
-	m.logger.Info("Flow executed successfully", zap.String("flowID", flowID))
-	return nil
-}
-
-func (m *Manager) ExecuteFlowsConcurrently(flowIDs []string, instanceManager model.InstanceManager) []error {
-	var wg sync.WaitGroup
-	errChan := make(chan error, len(flowIDs))
-
-	for _, id := range flowIDs {
-		wg.Add(1)
-		go func(flowID string) {
-			defer wg.Done()
-			if err := m.ExecuteFlow(flowID, instanceManager); err != nil {
-				errChan <- fmt.Errorf("failed to execute flow %s: %w", flowID, err)
-			}
-		}(id)
-	}
-
-	wg.Wait()
-	close(errChan)
-
-	var errors []error
-	for err := range errChan {
-		errors = append(errors, err)
-	}
-
-	return errors
-}
-
-func (m *Manager) GetFlowFromCache(flowID string) (Flow, error) {
-	cachedFlow, err := m.cache.Get(context.Background(), flowID).Bytes()
-	if err != nil {
-		return nil, err
-	}
-
-	var flow FlowImpl
-	err = json.Unmarshal(cachedFlow, &flow)
-	if err != nil {
-		return nil, err
-	}
-
-	return &flow, nil
-}
-
-func (m *Manager) CacheFlow(flow Flow) error {
-	flowData, err := json.Marshal(flow)
-	if err != nil {
-		return err
-	}
-
-	return m.cache.Set(context.Background(), flow.GetID(), flowData, 5*time.Minute).Err()
-}
-
-// FlowRepositoryImpl implements the FlowRepository interface
-type FlowRepositoryImpl struct {
-	db     *redis.Client
-	logger *zap.Logger
-}
-
-func NewFlowRepository(db *redis.Client, logger *zap.Logger) *FlowRepositoryImpl {
-	return &FlowRepositoryImpl{db: db, logger: logger}
-}
-
-func (r *FlowRepositoryImpl) CreateFlow(ctx context.Context, f Flow) error {
-	steps, err := json.Marshal(f.GetSteps())
-	if err != nil {
-		return err
-	}
-	flow := FlowImpl{
-		ID:         f.GetID(),
-		Name:       f.GetName(),
-		InstanceID: f.GetInstanceID(),
-		Steps:      []Step{},
-	}
-	err = json.Unmarshal(steps, &flow.Steps)
-	if err != nil {
-		return err
-	}
-	data, err := json.Marshal(flow)
-	if err != nil {
-		return err
-	}
-	return r.db.Set(ctx, fmt.Sprintf("flow:%s", flow.ID), data, 0).Err()
-}
-
-func (r *FlowRepositoryImpl) GetFlow(ctx context.Context, id string) (Flow, error) {
-	result, err := r.db.Get(ctx, fmt.Sprintf("flow:%s", id)).Result()
-	if err != nil {
-		return nil, err
-	}
-	var flow FlowImpl
-	err = json.Unmarshal([]byte(result), &flow)
-	if err != nil {
-		return nil, err
-	}
-	return &flow, nil
-}
-
-func (r *FlowRepositoryImpl) GetFlows(ctx context.Context) ([]Flow, error) {
-	keys, err := r.db.Keys(ctx, "flow:*").Result()
-	if err != nil {
-		return nil, err
-	}
-	var flows []Flow
-	for _, key := range keys {
-		result, err := r.db.Get(ctx, key).Result()
-		if err != nil {
-			return nil, err
-		}
-		var flow FlowImpl
-		err = json.Unmarshal([]byte(result), &flow)
-		if err != nil {
-			return nil, err
-		}
-		flows = append(flows, &flow)
-	}
-	return flows, nil
-}
-
-func (r *FlowRepositoryImpl) UpdateFlow(ctx context.Context, f Flow) error {
-	steps, err := json.Marshal(f.GetSteps())
-	if err != nil {
-		return err
-	}
-	flow := FlowImpl{
-		ID:         f.GetID(),
-		Name:       f.GetName(),
-		InstanceID: f.GetInstanceID(),
-		Steps:      []Step{},
-	}
-	err = json.Unmarshal(steps, &flow.Steps)
-	if err != nil {
-		return err
-	}
-	data, err := json.Marshal(flow)
-	if err != nil {
-		return err
-	}
-	return r.db.Set(ctx, fmt.Sprintf("flow:%s", flow.ID), data, 0).Err()
-}
-
-func (r *FlowRepositoryImpl) DeleteFlow(ctx context.Context, id string) error {
-	return r.db.Del(ctx, fmt.Sprintf("flow:%s", id)).Err()
-}
+package flow
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"auto/apperr"
+	"auto/events"
+	"auto/logger"
+	"auto/model"
+	"auto/notify"
+	"auto/pipeline"
+	"auto/quota"
+	"auto/sandbox"
+	"auto/schema"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+var tracer = otel.Tracer("auto/flow")
+
+type FlowRepository interface {
+	CreateFlow(ctx context.Context, f Flow) error
+	GetFlow(ctx context.Context, id string) (Flow, error)
+	GetFlows(ctx context.Context) ([]Flow, error)
+	UpdateFlow(ctx context.Context, f Flow) error
+	DeleteFlow(ctx context.Context, id string) error
+}
+
+type Flow interface {
+	GetID() string
+	GetName() string
+	GetInstanceID() string
+	GetInstanceGroup() string
+	GetSteps() []Step
+	SetSteps(steps []Step)
+	GetHooks() Hooks
+	SetHooks(hooks Hooks)
+	GetOutputs() []pipeline.Pipeline
+	SetOutputs(outputs []pipeline.Pipeline)
+	GetNotifications() []notify.Channel
+	SetNotifications(channels []notify.Channel)
+	GetOutputSchema() *schema.Schema
+	SetOutputSchema(s *schema.Schema)
+	GetRevision() int64
+	SetRevision(revision int64)
+	GetRetryOnBrowserError() bool
+	GetStepScreenshots() bool
+	GetMutex() string
+	GetProxy() *model.ProxyConfig
+	GetResultCacheTTLSeconds() int
+	GetRetention() *Retention
+}
+
+// RevisionConflictError is returned when a flow is updated from a revision
+// that is no longer the stored one.
+type RevisionConflictError struct {
+	FlowID   string
+	Expected int64
+	Current  int64
+}
+
+func (e *RevisionConflictError) Error() string {
+	return fmt.Sprintf("flow %s was modified: expected revision %d, current revision is %d", e.FlowID, e.Expected, e.Current)
+}
+
+func (e *RevisionConflictError) ErrorCode() apperr.Code {
+	return apperr.CodeRevisionConflict
+}
+
+func (e *RevisionConflictError) ErrorDetails() map[string]interface{} {
+	return map[string]interface{}{"revision": e.Current}
+}
+
+type Step struct {
+	ID     string                 `json:"id"`
+	Action string                 `json:"action"`
+	Params map[string]interface{} `json:"params"`
+}
+
+type FlowImpl struct {
+	ID            string              `json:"id"`
+	Name          string              `json:"name"`
+	InstanceID    string              `json:"instance_id"`
+	InstanceGroup string              `json:"instance_group,omitempty"`
+	Steps         []Step              `json:"steps"`
+	Hooks         Hooks               `json:"hooks"`
+	Outputs       []pipeline.Pipeline `json:"outputs"`
+	Notifications []notify.Channel    `json:"notifications"`
+	// OutputSchema describes the expected results, as an object with a
+	// property per step ID; runs whose results don't match are degraded.
+	OutputSchema *schema.Schema `json:"output_schema,omitempty"`
+	Revision     int64          `json:"revision"`
+	// RetryOnBrowserError runs the flow again, once, on a restarted
+	// instance when the browser fails under it.
+	RetryOnBrowserError bool `json:"retry_on_browser_error,omitempty"`
+	// StepScreenshots takes a thumbnail of the page after every step for
+	// the timeline of each run.
+	StepScreenshots bool `json:"step_screenshots,omitempty"`
+	// Mutex names a lock, such as "acme-admin-panel", that only one run
+	// holds at a time across every server, whatever its flow.
+	Mutex string `json:"mutex,omitempty"`
+	// Proxy replays the requests of each run through an intercepting
+	// proxy once the run is over. Its mode must be model.ProxyReplay: the
+	// browser of the instance is running already and keeps its own proxy.
+	Proxy *model.ProxyConfig `json:"proxy,omitempty"`
+	// ResultCacheTTLSeconds, if set, has runs with the same params as a
+	// successful run within that many seconds return its results instead
+	// of running the browser again.
+	ResultCacheTTLSeconds int `json:"result_cache_ttl_seconds,omitempty"`
+	// Retention overrides how long the runs of the flow are kept.
+	Retention *Retention `json:"retention,omitempty"`
+}
+
+func (f *FlowImpl) GetID() string {
+	return f.ID
+}
+
+func (f *FlowImpl) GetName() string {
+	return f.Name
+}
+
+func (f *FlowImpl) GetInstanceID() string {
+	return f.InstanceID
+}
+
+// GetInstanceGroup returns the instance group the flow targets; when set
+// it takes precedence over the instance ID.
+func (f *FlowImpl) GetInstanceGroup() string {
+	return f.InstanceGroup
+}
+
+func (f *FlowImpl) GetSteps() []Step {
+	return f.Steps
+}
+
+func (f *FlowImpl) SetSteps(steps []Step) {
+	f.Steps = steps
+}
+
+func (f *FlowImpl) GetHooks() Hooks {
+	return f.Hooks
+}
+
+func (f *FlowImpl) SetHooks(hooks Hooks) {
+	f.Hooks = hooks
+}
+
+func (f *FlowImpl) GetOutputs() []pipeline.Pipeline {
+	return f.Outputs
+}
+
+func (f *FlowImpl) SetOutputs(outputs []pipeline.Pipeline) {
+	f.Outputs = outputs
+}
+
+func (f *FlowImpl) GetNotifications() []notify.Channel {
+	return f.Notifications
+}
+
+func (f *FlowImpl) SetNotifications(channels []notify.Channel) {
+	f.Notifications = channels
+}
+
+func (f *FlowImpl) GetOutputSchema() *schema.Schema {
+	return f.OutputSchema
+}
+
+func (f *FlowImpl) SetOutputSchema(s *schema.Schema) {
+	f.OutputSchema = s
+}
+
+func (f *FlowImpl) GetRevision() int64 {
+	return f.Revision
+}
+
+func (f *FlowImpl) SetRevision(revision int64) {
+	f.Revision = revision
+}
+
+func (f *FlowImpl) GetRetryOnBrowserError() bool {
+	return f.RetryOnBrowserError
+}
+
+func (f *FlowImpl) GetStepScreenshots() bool {
+	return f.StepScreenshots
+}
+
+func (f *FlowImpl) GetMutex() string {
+	return f.Mutex
+}
+
+func (f *FlowImpl) GetProxy() *model.ProxyConfig {
+	return f.Proxy
+}
+
+func (f *FlowImpl) GetResultCacheTTLSeconds() int {
+	return f.ResultCacheTTLSeconds
+}
+
+func (f *FlowImpl) GetRetention() *Retention {
+	return f.Retention
+}
+
+// copyFlow returns a copy of f that can be modified without touching the
+// flow other goroutines see.
+func copyFlow(f Flow) *FlowImpl {
+	return &FlowImpl{
+		ID:            f.GetID(),
+		Name:          f.GetName(),
+		InstanceID:    f.GetInstanceID(),
+		InstanceGroup: f.GetInstanceGroup(),
+		Steps:         append([]Step{}, f.GetSteps()...),
+		Hooks:         f.GetHooks(),
+		Outputs:       f.GetOutputs(),
+		Notifications: f.GetNotifications(),
+		OutputSchema:  f.GetOutputSchema(),
+		Revision:      f.GetRevision(),
+
+		RetryOnBrowserError: f.GetRetryOnBrowserError(),
+		StepScreenshots:     f.GetStepScreenshots(),
+		Mutex:               f.GetMutex(),
+		Proxy:               f.GetProxy(),
+
+		ResultCacheTTLSeconds: f.GetResultCacheTTLSeconds(),
+		Retention:             f.GetRetention(),
+	}
+}
+
+type Manager struct {
+	flows     map[string]Flow
+	mu        sync.RWMutex
+	db        *redis.Client
+	repo      FlowRepository
+	runs      *RunStore
+	history   *HistoryStore
+	pipelines *pipeline.Executor
+	events    events.Publisher
+	notifier  *notify.Dispatcher
+	logger    *zap.Logger
+	cache     *redis.Client
+
+	// live holds the runs executing on this server, by run ID.
+	liveMu sync.Mutex
+	live   map[string]*liveRun
+
+	// lanes bound the runs of each priority class executing at once.
+	lanes map[string]chan struct{}
+
+	// retention is the retention of flows without one of their own.
+	retention Retention
+
+	// quotas is told when runs start and finish, if set.
+	quotas *quota.Tracker
+}
+
+func NewManager(db *redis.Client, repo FlowRepository, logger *zap.Logger, cache *redis.Client, pipelines *pipeline.Executor, publisher events.Publisher, notifier *notify.Dispatcher) *Manager {
+	m := &Manager{
+		flows:     make(map[string]Flow),
+		db:        db,
+		repo:      repo,
+		runs:      NewRunStore(db),
+		history:   NewHistoryStore(db, DefaultHistoryMaxLen),
+		pipelines: pipelines,
+		events:    publisher,
+		notifier:  notifier,
+		logger:    logger,
+		cache:     cache,
+		live:      make(map[string]*liveRun),
+	}
+	if err := m.loadFlowsFromDB(); err != nil {
+		m.logger.Fatal("Failed to load flows from DB", zap.Error(err))
+	}
+	return m
+}
+
+func (m *Manager) loadFlowsFromDB() error {
+	flows, err := m.repo.GetFlows(context.Background())
+	if err != nil {
+		m.logger.Error("Failed to load flows from DB", zap.Error(err))
+		return err
+	}
+	for _, flow := range flows {
+		m.flows[flow.GetID()] = flow
+	}
+	return nil
+}
+
+// Reload replaces the flows in memory with the stored ones, e.g. after a
+// restore, and drops the cached copies.
+func (m *Manager) Reload() error {
+	flows, err := m.repo.GetFlows(context.Background())
+	if err != nil {
+		return err
+	}
+	loaded := make(map[string]Flow, len(flows))
+	for _, flow := range flows {
+		loaded[flow.GetID()] = flow
+	}
+	m.mu.Lock()
+	m.flows = loaded
+	m.mu.Unlock()
+	m.cache.Del(context.Background(), "flows")
+	return nil
+}
+
+// RefreshFlow loads the stored version of a flow into memory, for servers
+// that run flows other servers edit.
+func (m *Manager) RefreshFlow(id string) (Flow, error) {
+	flow, err := m.repo.GetFlow(context.Background(), id)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	m.flows[id] = flow
+	m.mu.Unlock()
+	return flow, nil
+}
+
+func (m *Manager) CreateFlow(name string, instanceID string) Flow {
+	flow := &FlowImpl{
+		ID:         uuid.New().String(),
+		Name:       name,
+		InstanceID: instanceID,
+		Steps:      []Step{},
+		Revision:   1,
+	}
+
+	m.mu.Lock()
+	m.flows[flow.ID] = flow
+	m.mu.Unlock()
+
+	// Store flow details in Redis
+	flowJSON, _ := json.Marshal(flow)
+	m.cache.HSet(context.Background(), "flows", flow.ID, flowJSON)
+
+	err := m.repo.CreateFlow(context.Background(), flow)
+	if err != nil {
+		m.logger.Error("Failed to create flow in DB", zap.Error(err))
+		return nil
+	}
+
+	return flow
+}
+
+// CreateFlowFrom creates a flow as f describes it, under the ID f carries,
+// such as one from a declarative spec.
+func (m *Manager) CreateFlowFrom(f *FlowImpl) (Flow, error) {
+	if f.ID == "" {
+		return nil, errors.New("flow id is required")
+	}
+	if _, err := m.GetFlow(f.ID); err == nil {
+		return nil, apperr.New(apperr.CodeConflict, fmt.Sprintf("flow %s already exists", f.ID))
+	}
+	created := copyFlow(f)
+	created.Revision = 1
+	if err := m.repo.CreateFlow(context.Background(), created); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.flows[created.ID] = created
+	m.mu.Unlock()
+
+	flowJSON, _ := json.Marshal(created)
+	m.cache.HSet(context.Background(), "flows", created.ID, flowJSON)
+
+	return created, nil
+}
+
+// CloneFlow deep-copies a flow under new flow and step IDs, see remapIDs.
+// The copy is named after the original plus nameSuffix and bound to
+// instanceID, or to the original's instance when empty.
+func (m *Manager) CloneFlow(id string, nameSuffix string, instanceID string) (Flow, error) {
+	source, err := m.GetFlow(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// remapIDs works on a JSON round trip, which also copies step params
+	// and outputs all the way down.
+	clone, err := remapIDs(copyFlow(source))
+	if err != nil {
+		return nil, err
+	}
+	clone.Name = source.GetName() + nameSuffix
+	if instanceID != "" {
+		clone.InstanceID = instanceID
+	}
+	clone.Revision = 1
+
+	if err := m.repo.CreateFlow(context.Background(), clone); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.flows[clone.ID] = clone
+	m.mu.Unlock()
+
+	flowJSON, _ := json.Marshal(clone)
+	m.cache.HSet(context.Background(), "flows", clone.ID, flowJSON)
+
+	return clone, nil
+}
+
+// UpdateFlow stores flow if it is still at the revision it carries,
+// returning a *RevisionConflictError otherwise. On success the revision of
+// flow is advanced.
+func (m *Manager) UpdateFlow(flow Flow) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.repo.UpdateFlow(context.Background(), flow); err != nil {
+		m.refreshFlow(flow.GetID(), err)
+		return err
+	}
+	m.flows[flow.GetID()] = flow
+
+	// Update flow details in Redis
+	flowJSON, _ := json.Marshal(flow)
+	m.cache.HSet(context.Background(), "flows", flow.GetID(), flowJSON)
+	return nil
+}
+
+// GetFlow returns the current version of a flow.
+func (m *Manager) GetFlow(id string) (Flow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	flow, exists := m.flows[id]
+	if !exists {
+		return nil, apperr.New(apperr.CodeFlowNotFound, fmt.Sprintf("flow not found: %s", id))
+	}
+	return flow, nil
+}
+
+// modifyFlow applies fn to a copy of a flow and stores it. A non-zero
+// revision must match the stored one; with zero the change is applied to
+// whatever revision is current.
+func (m *Manager) modifyFlow(flowID string, revision int64, fn func(f *FlowImpl)) (Flow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		current, exists := m.flows[flowID]
+		if !exists {
+			return nil, apperr.New(apperr.CodeFlowNotFound, fmt.Sprintf("flow not found: %s", flowID))
+		}
+		updated := copyFlow(current)
+		if revision != 0 {
+			updated.Revision = revision
+		}
+		fn(updated)
+
+		err := m.repo.UpdateFlow(context.Background(), updated)
+		if err == nil {
+			m.flows[flowID] = updated
+			flowJSON, _ := json.Marshal(updated)
+			m.cache.HSet(context.Background(), "flows", flowID, flowJSON)
+			return updated, nil
+		}
+		// Another process changed the flow; retry once on top of its
+		// version unless the caller asked for a specific revision.
+		if !m.refreshFlow(flowID, err) || revision != 0 || attempt > 0 {
+			return nil, err
+		}
+	}
+}
+
+// refreshFlow reloads a flow from the repository after a revision conflict
+// so the in-memory copy catches up with changes made elsewhere. It reports
+// whether err was such a conflict. The caller must hold m.mu.
+func (m *Manager) refreshFlow(flowID string, err error) bool {
+	var conflict *RevisionConflictError
+	if !errors.As(err, &conflict) {
+		return false
+	}
+	stored, err := m.repo.GetFlow(context.Background(), flowID)
+	if err != nil {
+		m.logger.Error("Failed to reload flow", zap.String("flowID", flowID), zap.Error(err))
+		return true
+	}
+	m.flows[flowID] = stored
+	return true
+}
+
+func (m *Manager) DeleteFlow(id string) error {
+	m.mu.Lock()
+	delete(m.flows, id)
+	m.mu.Unlock()
+
+	// Remove flow from Redis
+	m.cache.HDel(context.Background(), "flows", id)
+	if err := m.history.Delete(context.Background(), id); err != nil {
+		m.logger.Warn("Failed to delete flow history", zap.String("flowID", id), zap.Error(err))
+	}
+
+	return m.repo.DeleteFlow(context.Background(), id)
+}
+
+func (m *Manager) GetFlows() []Flow {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	flows := make([]Flow, 0, len(m.flows))
+	for _, flow := range m.flows {
+		flows = append(flows, flow)
+	}
+	return flows
+}
+
+func (m *Manager) AddStep(flowID string, action string, params map[string]interface{}) error {
+	step := Step{
+		ID:     uuid.New().String(),
+		Action: action,
+		Params: params,
+	}
+
+	_, err := m.modifyFlow(flowID, 0, func(f *FlowImpl) {
+		f.Steps = append(f.Steps, step)
+	})
+	return err
+}
+
+// SetHooks replaces the hooks of a flow. A non-zero revision must match
+// the stored one, as for the other Set methods.
+func (m *Manager) SetHooks(flowID string, revision int64, hooks Hooks) (Flow, error) {
+	return m.modifyFlow(flowID, revision, func(f *FlowImpl) {
+		f.Hooks = hooks
+	})
+}
+
+func (m *Manager) SetOutputs(flowID string, revision int64, outputs []pipeline.Pipeline) (Flow, error) {
+	for _, p := range outputs {
+		if err := pipeline.Validate(p); err != nil {
+			return nil, err
+		}
+	}
+
+	return m.modifyFlow(flowID, revision, func(f *FlowImpl) {
+		f.Outputs = outputs
+	})
+}
+
+func (m *Manager) SetNotifications(flowID string, revision int64, channels []notify.Channel) (Flow, error) {
+	if m.notifier != nil {
+		if err := m.notifier.Validate(channels); err != nil {
+			return nil, err
+		}
+	}
+
+	return m.modifyFlow(flowID, revision, func(f *FlowImpl) {
+		f.Notifications = channels
+	})
+}
+
+// SetOutputSchema replaces the output schema of a flow; nil removes it.
+func (m *Manager) SetOutputSchema(flowID string, revision int64, s *schema.Schema) (Flow, error) {
+	if err := schema.Check(s); err != nil {
+		return nil, err
+	}
+
+	return m.modifyFlow(flowID, revision, func(f *FlowImpl) {
+		f.OutputSchema = s
+	})
+}
+
+// GetRun returns a recorded run.
+func (m *Manager) GetRun(id string) (*Run, error) {
+	return m.runs.GetRun(context.Background(), id)
+}
+
+// GetGraph returns the graph of a flow, with the step statuses of one of
+// its runs when runID is set.
+func (m *Manager) GetGraph(flowID, runID string) (*Graph, error) {
+	flow, err := m.GetFlow(flowID)
+	if err != nil {
+		return nil, err
+	}
+	var run *Run
+	if runID != "" {
+		if run, err = m.GetRun(runID); err != nil {
+			return nil, err
+		}
+		if run.FlowID != flowID {
+			return nil, apperr.New(apperr.CodeRunNotFound, fmt.Sprintf("run %s is not a run of flow %s", runID, flowID))
+		}
+	}
+	return BuildGraph(flow, run), nil
+}
+
+// GetRuns returns the most recent runs of a flow, newest first, only those
+// labelled with every label of labels when it is set.
+func (m *Manager) GetRuns(flowID string, limit int, labels map[string]string) ([]*Run, error) {
+	page, err := m.runs.QueryRuns(context.Background(), RunQuery{FlowID: flowID, Labels: labels, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	return page.Runs, nil
+}
+
+// SetHistoryLimit sets how many results the history of each flow keeps.
+// Call it before running flows.
+func (m *Manager) SetHistoryLimit(maxLen int64) {
+	m.history.maxLen = maxLen
+}
+
+// GetHistory returns the results of the completed runs of a flow within
+// q, oldest first.
+func (m *Manager) GetHistory(flowID string, q HistoryQuery) ([]HistoryEntry, error) {
+	return m.history.Range(context.Background(), flowID, q)
+}
+
+// GetLatestHistory returns the results of the n most recent completed runs
+// of a flow, newest first.
+func (m *Manager) GetLatestHistory(flowID string, n int64, fields []string) ([]HistoryEntry, error) {
+	return m.history.Latest(context.Background(), flowID, n, fields)
+}
+
+func (m *Manager) SaveToFile(filename string) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data, err := json.MarshalIndent(m.flows, "", "  ")
+	if err != nil {
+		m.logger.Error("Failed to marshal flows", zap.Error(err))
+		return err
+	}
+
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+func (m *Manager) LoadFromFile(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		m.logger.Error("Failed to read flows file", zap.Error(err))
+		return err
+	}
+
+	var flows map[string]Flow
+	if err := json.Unmarshal(data, &flows); err != nil {
+		m.logger.Error("Failed to unmarshal flows", zap.Error(err))
+		return err
+	}
+
+	m.mu.Lock()
+	m.flows = flows
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *Manager) ExecuteFlow(flowID string, instanceManager *model.InstanceManager) error {
+	return m.ExecuteFlowWithParams(flowID, instanceManager, nil)
+}
+
+// ExecuteFlowWithParams runs a flow whose steps take params through
+// "{{param:NAME}}" placeholders. The params are recorded on the run.
+func (m *Manager) ExecuteFlowWithParams(flowID string, instanceManager *model.InstanceManager, params map[string]interface{}) error {
+	return m.execute(context.Background(), flowID, instanceManager, params, model.PriorityInteractive, nil)
+}
+
+// errRunCancelled fails a run whose context was cancelled.
+var errRunCancelled = errors.New("run cancelled")
+
+// ExecuteFlowContext runs a flow like ExecuteFlowWithParams, in the
+// priority class given, until ctx is cancelled. A cancelled run stops at
+// the step it is at and fails with errRunCancelled.
+func (m *Manager) ExecuteFlowContext(ctx context.Context, flowID string, instanceManager *model.InstanceManager, params map[string]interface{}, priority string) error {
+	return m.execute(ctx, flowID, instanceManager, params, priority, nil)
+}
+
+// execute runs a flow, pausing before each step for dbg when it is set.
+// The run waits for a slot in the quota of its priority class first.
+func (m *Manager) execute(parent context.Context, flowID string, instanceManager *model.InstanceManager, params map[string]interface{}, priority string, dbg Debugger) error {
+	m.mu.RLock()
+	flow, exists := m.flows[flowID]
+	m.mu.RUnlock()
+
+	if !exists {
+		return apperr.New(apperr.CodeFlowNotFound, fmt.Sprintf("flow not found: %s", flowID))
+	}
+
+	// Each run is a trace of its own, with a span per step.
+	ctx, span := tracer.Start(parent, "flow.run", trace.WithAttributes(
+		attribute.String("flow.id", flowID),
+		attribute.String("flow.name", flow.GetName()),
+	))
+	var err error
+	defer func() { endSpan(span, err) }()
+
+	if dbg == nil {
+		if run := m.cachedRun(ctx, flow, params); run != nil {
+			span.SetAttributes(attribute.String("run.id", run.ID), attribute.String("run.cached_from", run.CachedFrom))
+			return nil
+		}
+	}
+
+	releaseLane, err := m.acquireLane(ctx, priority)
+	if err != nil {
+		return err
+	}
+	defer releaseLane()
+
+	var instance *model.Instance
+	if id, _ := ctx.Value(instanceKey{}).(string); id != "" {
+		instance, err = instanceManager.GetInstance(id)
+	} else if group := flow.GetInstanceGroup(); group != "" {
+		instance, err = instanceManager.PickInstance(group, flowID)
+	} else {
+		instance, err = instanceManager.GetInstance(flow.GetInstanceID())
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to get instance: %w", err)
+		return err
+	}
+	instance.BeginRun()
+	defer instance.EndRun()
+	if err = instanceManager.Wake(instance); err != nil {
+		err = fmt.Errorf("failed to wake instance: %w", err)
+		return err
+	}
+
+	// Only one flow drives an instance at a time; the others queue up.
+	run := newRun(ctx, flow, instance.ID)
+	run.Params = params
+	run.Priority = priority
+	run.AnonymousInstance = instance.Anonymous
+	run.Workspace, _ = ctx.Value(workspaceKey{}).(string)
+	if run.Workspace == "" {
+		run.Workspace = quota.Name(instance.Settings().Workspace)
+	}
+	ctx = logger.NewContext(ctx, logger.ForRun(m.log(ctx), run.ID, flowID))
+	span.SetAttributes(attribute.String("run.id", run.ID), attribute.String("instance.id", instance.ID))
+	if key := flow.GetMutex(); key != "" {
+		// Taken before the instance, so a run waiting for the mutex does
+		// not hold up the instance for others.
+		var unlock func()
+		unlock, err = m.acquireMutex(ctx, key, run.ID, func() {
+			run.Status = RunStatusQueued
+			m.saveRun(ctx, run)
+			m.publish(events.RunQueued, run, map[string]string{"mutex": key})
+		})
+		if err != nil {
+			run.finish("", err)
+			m.saveRun(ctx, run)
+			m.publish(events.RunFailed, run, run)
+			return apperr.WithRun(err, flowID, run.ID, "", instance.ID)
+		}
+		defer unlock()
+	}
+	release, err := instanceManager.AcquireInstance(instance, run.ID, priority, func(position int) {
+		run.Status = RunStatusQueued
+		run.QueuePosition = position
+		m.saveRun(ctx, run)
+		m.publish(events.RunQueued, run, map[string]int{"position": position})
+	})
+	if err != nil {
+		run.finish("", err)
+		m.saveRun(ctx, run)
+		m.publish(events.RunFailed, run, run)
+		return apperr.WithRun(err, flowID, run.ID, "", instance.ID)
+	}
+	defer release()
+	run.start()
+	m.saveRun(ctx, run)
+	m.publish(events.RunStarted, run, nil)
+	if m.quotas != nil {
+		m.trackUsage(ctx, run)
+		defer m.untrackUsage(ctx, run)
+	}
+	instanceResponses := run.Results
+	hooks := flow.GetHooks()
+	live := m.trackRun(run)
+	defer m.untrackRun(run.ID)
+	var capture *model.RequestCapture
+	if proxy := flow.GetProxy(); proxy != nil {
+		capture = model.NewRequestCapture(model.DefaultRequestCaptureLimit)
+		defer m.replayRun(ctx, run, proxy, capture)
+	}
+	captureCtx, stopCapture := context.WithCancel(ctx)
+	defer stopCapture()
+	pages := model.NewPageCapture(model.DefaultRequestCaptureLimit)
+
+	// The steps start over when the browser fails and the flow retries.
+attempts:
+	for {
+		if capture != nil {
+			// A retry restarts the browser, so each attempt listens anew.
+			if err := instance.CaptureRequests(captureCtx, capture); err != nil {
+				m.log(ctx).Warn("Failed to capture requests for proxy", zap.Error(err))
+			}
+		}
+		if err := instance.CapturePages(captureCtx, pages); err != nil {
+			m.log(ctx).Warn("Failed to capture pages for findings", zap.Error(err))
+		}
+		for i, step := range flow.GetSteps() {
+			stepCtx, stepSpan := tracer.Start(ctx, "flow.step "+step.Action, trace.WithAttributes(
+				attribute.String("step.id", step.ID),
+				attribute.String("step.action", step.Action),
+			))
+			live.at(i, step.ID)
+			started := time.Now()
+			step.Params, err = fillParams(step.Params, params)
+			if err == nil {
+				step.Params, err = fillVars(step.Params, instanceResponses)
+			}
+			if err == nil {
+				err = ctx.Err()
+			}
+			if err == nil && dbg != nil {
+				live.setPaused(true)
+				cmd := dbg.Pause(DebugPause{RunID: run.ID, Index: i, Step: step, Screenshot: screenshot(instance)})
+				live.setPaused(false)
+				if cmd.Command == DebugAbort {
+					// An abort is the user's doing, not a failure of the flow:
+					// no failure hooks or notifications.
+					err = errDebugAborted
+					run.finish(step.ID, err)
+					m.saveRun(ctx, run)
+					m.publish(events.RunFailed, run, run)
+					endSpan(stepSpan, err)
+					return apperr.WithRun(err, flowID, run.ID, step.ID, instance.ID)
+				}
+				if cmd.Command == DebugContinue {
+					dbg = nil
+				}
+				if cmd.Params != nil {
+					step.Params, err = fillParams(cmd.Params, params)
+					if err == nil {
+						step.Params, err = fillVars(step.Params, instanceResponses)
+					}
+				}
+			}
+			if err == nil {
+				err = m.runHooks(stepCtx, flowID, "beforeEach", hooks.BeforeEach, step, instance)
+			}
+			if err == nil {
+				err = m.executeStep(stepCtx, run, step, instance, instanceResponses)
+				live.snapshot(instanceResponses)
+			}
+			if err == nil && step.Action == "extractTable" {
+				m.writeTableArtifact(run, step)
+			}
+			if err == nil && step.Action == "accessibilitySnapshot" {
+				m.writeSnapshotArtifact(run, step)
+			}
+			if err == nil {
+				err = m.runHooks(stepCtx, flowID, "afterEach", hooks.AfterEach, step, instance)
+			}
+			if err != nil && ctx.Err() != nil {
+				// Like an abort, a cancelled run is not a failure of the
+				// flow: no retry, failure hooks or notifications.
+				err = errRunCancelled
+				m.recordStep(stepCtx, flow, run, step, instance, started, err)
+				run.finish(step.ID, err)
+				m.saveRun(ctx, run)
+				m.publish(events.RunFailed, run, run)
+				endSpan(stepSpan, err)
+				return apperr.WithRun(err, flowID, run.ID, step.ID, instance.ID)
+			}
+			if err != nil {
+				if dbg == nil && m.retryOnFreshInstance(ctx, flow, run, step.ID, instance, instanceManager, err) {
+					endSpan(stepSpan, err)
+					err = nil
+					live.snapshot(instanceResponses)
+					continue attempts
+				}
+				m.recordStep(stepCtx, flow, run, step, instance, started, err)
+				m.analyzePage(stepCtx, run, instance, pages)
+				if hookErr := m.runHooks(stepCtx, flowID, "onFailure", hooks.OnFailure, step, instance); hookErr != nil {
+					m.log(ctx).Error("Failure hook failed", zap.String("stepID", step.ID), zap.Error(hookErr))
+				}
+				run.finish(step.ID, err)
+				m.saveRun(ctx, run)
+				m.publish(events.RunFailed, run, run)
+				m.notifyFailure(flow, run, instance)
+				endSpan(stepSpan, err)
+				return apperr.WithRun(err, flowID, run.ID, step.ID, instance.ID)
+			}
+			m.recordStep(stepCtx, flow, run, step, instance, started, nil)
+			m.analyzePage(stepCtx, run, instance, pages)
+			endSpan(stepSpan, nil)
+		}
+		break
+	}
+
+	run.finish("", nil)
+	if s := flow.GetOutputSchema(); s != nil {
+		run.checkSchema(s)
+	}
+	m.runOutputs(flow, run)
+	m.saveRun(ctx, run)
+	if dbg == nil {
+		m.cacheResult(ctx, flow, run)
+	}
+	if err := m.history.Record(context.WithoutCancel(ctx), run); err != nil {
+		m.log(ctx).Error("Failed to record run history", zap.Error(err))
+	}
+	m.publish(events.RunSucceeded, run, run)
+	if run.Status == RunStatusDegraded {
+		m.log(ctx).Warn("Flow output does not match its schema", zap.Int("violations", len(run.SchemaViolations)))
+		m.publish(events.RunDegraded, run, run.SchemaViolations)
+	}
+	m.publish(events.RunOutput, run, run.Results)
+
+	m.log(ctx).Info("Flow executed successfully")
+	return nil
+}
+
+// retryOnFreshInstance restarts the instance of a run whose browser failed
+// under it, for the run to start over, if its flow asks for that and this
+// was its first attempt. The failed attempt is recorded on the run.
+func (m *Manager) retryOnFreshInstance(ctx context.Context, flow Flow, run *Run, stepID string, instance *model.Instance, instanceManager *model.InstanceManager, err error) bool {
+	if !flow.GetRetryOnBrowserError() || run.Attempt >= maxRunAttempts || !isBrowserError(err, instance) {
+		return false
+	}
+	m.log(ctx).Warn("Browser failed during run, retrying on a restarted instance", zap.String("stepID", stepID), zap.Error(err))
+	if restartErr := instanceManager.RestartInstance(instance.ID); restartErr != nil {
+		m.log(ctx).Error("Failed to restart instance for retry", zap.String("instanceID", instance.ID), zap.Error(restartErr))
+		return false
+	}
+	run.retry(stepID, err)
+	m.saveRun(ctx, run)
+	m.publish(events.RunRetried, run, run.Attempts[len(run.Attempts)-1])
+	return true
+}
+
+// isBrowserError reports whether err comes from the browser failing rather
+// than the flow: a crash, or a context cancelled without the instance
+// being stopped.
+func isBrowserError(err error, instance *model.Instance) bool {
+	if instance.GetStatus() == "Off" {
+		return false
+	}
+	if apperr.From(err, http.StatusInternalServerError).Code == apperr.CodeChromeCrashed || errors.Is(err, context.Canceled) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "target crashed") || strings.Contains(msg, "target closed")
+}
+
+// log returns the logger of ctx: that of the run or request it belongs to.
+func (m *Manager) log(ctx context.Context) *zap.Logger {
+	return logger.FromContext(ctx, m.logger)
+}
+
+// saveRun stores run, even once the context of a cancelled run is done.
+func (m *Manager) saveRun(ctx context.Context, run *Run) {
+	if err := m.runs.SaveRun(context.WithoutCancel(ctx), run); err != nil {
+		m.log(ctx).Error("Failed to save run", zap.String("runID", run.ID), zap.Error(err))
+	}
+}
+
+// publish sends a run event to the broker. Publishing is best effort and
+// never fails the run.
+func (m *Manager) publish(eventType string, run *Run, data interface{}) {
+	if m.events == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := m.events.Publish(ctx, events.Event{
+		Type:   eventType,
+		RunID:  run.ID,
+		FlowID: run.FlowID,
+		Labels: run.Labels,
+		Time:   time.Now(),
+		Data:   data,
+	})
+	if err != nil {
+		m.logger.Warn("Failed to publish run event", zap.String("runID", run.ID), zap.String("event", eventType), zap.Error(err))
+	}
+}
+
+// notifyFailure sends a failed run to the notification channels of its
+// flow, with a screenshot of the page the run stopped on.
+func (m *Manager) notifyFailure(flow Flow, run *Run, instance *model.Instance) {
+	channels := flow.GetNotifications()
+	if m.notifier == nil || len(channels) == 0 {
+		return
+	}
+	n := notify.Notification{
+		Title:      fmt.Sprintf("Flow %s failed", flow.GetName()),
+		Message:    fmt.Sprintf("Step %s failed: %s", run.FailedStep, run.Error),
+		Severity:   "error",
+		FlowID:     run.FlowID,
+		RunID:      run.ID,
+		InstanceID: run.InstanceID,
+		Labels:     run.Labels,
+	}
+	if data, err := instance.Execute("screenshot", nil); err == nil {
+		n.Screenshot, _ = base64.StdEncoding.DecodeString(data)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := m.notifier.Send(ctx, channels, n); err != nil {
+		m.logger.Error("Failed to send failure notification", zap.String("runID", run.ID), zap.Error(err))
+	}
+}
+
+// runOutputs feeds the results of a completed run through the output
+// pipelines of its flow. Pipeline failures are recorded on the run but do
+// not fail it.
+func (m *Manager) runOutputs(flow Flow, run *Run) {
+	if m.pipelines == nil {
+		return
+	}
+	info := pipeline.RunInfo{RunID: run.ID, FlowID: run.FlowID, Labels: run.Labels}
+	for _, p := range flow.GetOutputs() {
+		if err := m.pipelines.Run(context.Background(), p, info, run.Results); err != nil {
+			m.logger.Error("Output pipeline failed", zap.String("flowID", run.FlowID), zap.String("pipeline", p.Name), zap.Error(err))
+			if run.OutputErrors == nil {
+				run.OutputErrors = make(map[string]string)
+			}
+			run.OutputErrors[p.Name] = err.Error()
+		}
+	}
+}
+
+// writeTableArtifact stores the rows of an extractTable step as the CSV
+// artifact <step id>.csv of the run, keeping the column order of the page.
+// Like output pipelines, a failure is recorded on the run but does not fail
+// it.
+func (m *Manager) writeTableArtifact(run *Run, step Step) {
+	if m.pipelines == nil {
+		return
+	}
+	records, columns, err := pipeline.DecodeRecords([]byte(run.Results[step.ID]))
+	if err == nil {
+		info := pipeline.RunInfo{RunID: run.ID, FlowID: run.FlowID, Labels: run.Labels}
+		err = m.pipelines.WriteCSV(info, step.ID+".csv", columns, records)
+	}
+	if err != nil {
+		m.logger.Error("Failed to write table artifact", zap.String("runID", run.ID), zap.String("stepID", step.ID), zap.Error(err))
+		if run.OutputErrors == nil {
+			run.OutputErrors = make(map[string]string)
+		}
+		run.OutputErrors[step.ID] = err.Error()
+	}
+}
+
+// writeSnapshotArtifact stores the tree of an accessibilitySnapshot step
+// as the artifact <step id>.a11y.json of the run. Like table artifacts, a
+// failure is recorded on the run but does not fail it.
+func (m *Manager) writeSnapshotArtifact(run *Run, step Step) {
+	if m.pipelines == nil {
+		return
+	}
+	info := pipeline.RunInfo{RunID: run.ID, FlowID: run.FlowID, Labels: run.Labels}
+	if err := m.pipelines.WriteArtifact(info, step.ID+".a11y.json", []byte(run.Results[step.ID])); err != nil {
+		m.logger.Error("Failed to write accessibility snapshot", zap.String("runID", run.ID), zap.String("stepID", step.ID), zap.Error(err))
+		if run.OutputErrors == nil {
+			run.OutputErrors = make(map[string]string)
+		}
+		run.OutputErrors[step.ID] = err.Error()
+	}
+}
+
+// endSpan ends span, marking it failed if err is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (m *Manager) executeStep(ctx context.Context, run *Run, step Step, instance *model.Instance, instanceResponses map[string]string) error {
+	switch step.Action {
+	case "template":
+		tmpl, err := template.New("response").Parse(step.Params["template"].(string))
+		if err != nil {
+			return err
+		}
+		var result bytes.Buffer
+		err = tmpl.Execute(&result, instanceResponses)
+		if err != nil {
+			return err
+		}
+		instanceResponses["templateResult"] = result.String()
+	case "serverScript":
+		result, err := m.runServerScript(step, instanceResponses)
+		if err != nil {
+			m.log(ctx).Error("Script step failed", zap.String("stepID", step.ID), zap.Error(err))
+			return fmt.Errorf("failed to execute step %s: %w", step.ID, err)
+		}
+		instanceResponses[step.ID] = result
+	case "visualCheck":
+		result, err := m.visualCheck(ctx, run, step, instance)
+		if result != "" {
+			instanceResponses[step.ID] = result
+		}
+		if err != nil {
+			m.log(ctx).Error("Visual check failed", zap.String("stepID", step.ID), zap.Error(err))
+			return fmt.Errorf("failed to execute step %s: %w", step.ID, err)
+		}
+	default:
+		result, err := instance.ExecuteContext(ctx, step.Action, step.Params)
+		if err != nil {
+			m.log(ctx).Error("Step execution failed", zap.String("stepID", step.ID), zap.Error(err))
+			return fmt.Errorf("failed to execute step %s: %w", step.ID, err)
+		}
+		instanceResponses[step.ID] = result
+		if step.Action == "httpRequest" {
+			if err := extractVariables(step, result, instanceResponses); err != nil {
+				return fmt.Errorf("failed to execute step %s: %w", step.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// runServerScript evaluates the "script" parameter of a step on the server.
+// The script sees the previous step results as "steps" and the step
+// parameters as "params"; its last expression becomes the step result.
+func (m *Manager) runServerScript(step Step, instanceResponses map[string]string) (string, error) {
+	code, ok := step.Params["script"].(string)
+	if !ok || code == "" {
+		return "", fmt.Errorf("missing script parameter")
+	}
+	timeout := sandbox.DefaultTimeout
+	if ms, ok := step.Params["timeout"].(float64); ok && ms > 0 {
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+	steps := make(map[string]interface{}, len(instanceResponses))
+	for id, result := range instanceResponses {
+		steps[id] = result
+	}
+	vars := map[string]interface{}{
+		"steps":  steps,
+		"params": step.Params,
+	}
+	return sandbox.RunString(code, vars, timeout, m.logger)
+}
+
+func (m *Manager) ExecuteFlowsConcurrently(flowIDs []string, instanceManager *model.InstanceManager) []error {
+	var wg sync.WaitGroup
+	errChan := make(chan error, len(flowIDs))
+
+	for _, id := range flowIDs {
+		wg.Add(1)
+		go func(flowID string) {
+			defer wg.Done()
+			if err := m.ExecuteFlow(flowID, instanceManager); err != nil {
+				errChan <- fmt.Errorf("failed to execute flow %s: %w", flowID, err)
+			}
+		}(id)
+	}
+
+	wg.Wait()
+	close(errChan)
+
+	var errors []error
+	for err := range errChan {
+		errors = append(errors, err)
+	}
+
+	return errors
+}
+
+func (m *Manager) GetFlowFromCache(flowID string) (Flow, error) {
+	cachedFlow, err := m.cache.Get(context.Background(), flowID).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var flow FlowImpl
+	err = json.Unmarshal(cachedFlow, &flow)
+	if err != nil {
+		return nil, err
+	}
+
+	return &flow, nil
+}
+
+func (m *Manager) CacheFlow(flow Flow) error {
+	flowData, err := json.Marshal(flow)
+	if err != nil {
+		return err
+	}
+
+	return m.cache.Set(context.Background(), flow.GetID(), flowData, 5*time.Minute).Err()
+}
+
+// FlowRepositoryImpl implements the FlowRepository interface
+type FlowRepositoryImpl struct {
+	db     *redis.Client
+	logger *zap.Logger
+}
+
+func NewFlowRepository(db *redis.Client, logger *zap.Logger) *FlowRepositoryImpl {
+	return &FlowRepositoryImpl{db: db, logger: logger}
+}
+
+// CreateFlow stores the whole of f, so that every setting of the flow
+// survives a restart and reaches the servers that load it.
+func (r *FlowRepositoryImpl) CreateFlow(ctx context.Context, f Flow) error {
+	data, err := json.Marshal(copyFlow(f))
+	if err != nil {
+		return err
+	}
+	return r.db.Set(ctx, fmt.Sprintf("flow:%s", f.GetID()), data, 0).Err()
+}
+
+func (r *FlowRepositoryImpl) GetFlow(ctx context.Context, id string) (Flow, error) {
+	result, err := r.db.Get(ctx, fmt.Sprintf("flow:%s", id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	var flow FlowImpl
+	err = json.Unmarshal([]byte(result), &flow)
+	if err != nil {
+		return nil, err
+	}
+	return &flow, nil
+}
+
+func (r *FlowRepositoryImpl) GetFlows(ctx context.Context) ([]Flow, error) {
+	keys, err := r.db.Keys(ctx, "flow:*").Result()
+	if err != nil {
+		return nil, err
+	}
+	var flows []Flow
+	for _, key := range keys {
+		result, err := r.db.Get(ctx, key).Result()
+		if err != nil {
+			return nil, err
+		}
+		var flow FlowImpl
+		err = json.Unmarshal([]byte(result), &flow)
+		if err != nil {
+			return nil, err
+		}
+		flows = append(flows, &flow)
+	}
+	return flows, nil
+}
+
+// UpdateFlow stores f only if the stored flow is still at f's revision,
+// and then advances the revision of both. Otherwise it returns a
+// *RevisionConflictError carrying the stored revision.
+func (r *FlowRepositoryImpl) UpdateFlow(ctx context.Context, f Flow) error {
+	// The copy is what gets stored, whole; its revision is set once the
+	// stored one is known.
+	flow := copyFlow(f)
+
+	key := fmt.Sprintf("flow:%s", flow.ID)
+	err := r.db.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := storedRevision(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+		if current != f.GetRevision() {
+			return &RevisionConflictError{FlowID: flow.ID, Expected: f.GetRevision(), Current: current}
+		}
+		flow.Revision = current + 1
+		data, err := json.Marshal(flow)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, data, 0)
+			return nil
+		})
+		return err
+	}, key)
+	if err == redis.TxFailedErr {
+		// The flow changed between the read and the write.
+		current, rerr := storedRevision(ctx, r.db, key)
+		if rerr != nil {
+			return rerr
+		}
+		return &RevisionConflictError{FlowID: flow.ID, Expected: f.GetRevision(), Current: current}
+	}
+	if err != nil {
+		return err
+	}
+	f.SetRevision(flow.Revision)
+	return nil
+}
+
+// storedRevision returns the revision of the flow stored at key, zero for
+// flows saved before revisions existed.
+func storedRevision(ctx context.Context, db redis.Cmdable, key string) (int64, error) {
+	data, err := db.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return 0, errors.New("flow not found")
+	} else if err != nil {
+		return 0, err
+	}
+	var stored struct {
+		Revision int64 `json:"revision"`
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return 0, err
+	}
+	return stored.Revision, nil
+}
+
+func (r *FlowRepositoryImpl) DeleteFlow(ctx context.Context, id string) error {
+	return r.db.Del(ctx, fmt.Sprintf("flow:%s", id)).Err()
+}
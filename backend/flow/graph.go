@@ -0,0 +1,171 @@
+package flow
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kinds of graph node.
+const (
+	NodeStep  = "step"
+	NodeInner = "inner"
+	NodeHook  = "hook"
+)
+
+// Kinds of graph edge: "next" joins consecutive steps, "repeats" a
+// composite step, such as paginate, to the step it repeats and "runs" a
+// parallel step to each of the steps it runs.
+const (
+	EdgeNext    = "next"
+	EdgeRepeats = "repeats"
+	EdgeRuns    = "runs"
+)
+
+// Node statuses in the graph of a run. Steps after the failed one are
+// skipped; steps of a run that has not finished are pending until their
+// result is recorded.
+const (
+	NodeSucceeded = "succeeded"
+	NodeDegraded  = "degraded"
+	NodeFailed    = "failed"
+	NodeSkipped   = "skipped"
+	NodePending   = "pending"
+)
+
+// Graph is a flow laid out for drawing as a diagram.
+type Graph struct {
+	FlowID string      `json:"flow_id"`
+	RunID  string      `json:"run_id,omitempty"`
+	Nodes  []GraphNode `json:"nodes"`
+	Edges  []GraphEdge `json:"edges"`
+}
+
+// GraphNode is a step of the flow, a step repeated by a composite step
+// (ID "<step id>/step"), a step run by a parallel step (ID "<step
+// id>/<child id>"), or a hook (ID "hook:<stage>:<index>", Hook the
+// stage). Status and Error are only set for the graph of a run.
+type GraphNode struct {
+	ID     string                 `json:"id"`
+	Kind   string                 `json:"kind"`
+	Action string                 `json:"action"`
+	Parent string                 `json:"parent,omitempty"`
+	Hook   string                 `json:"hook,omitempty"`
+	Params map[string]interface{} `json:"params,omitempty"`
+	Status string                 `json:"status,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"`
+}
+
+// BuildGraph lays out f. With a run, the nodes carry the status they had
+// in it.
+func BuildGraph(f Flow, run *Run) *Graph {
+	g := &Graph{FlowID: f.GetID(), Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	statuses := map[string]string{}
+	if run != nil {
+		g.RunID = run.ID
+		statuses = stepStatuses(f.GetSteps(), run)
+	}
+
+	prev := ""
+	for _, step := range f.GetSteps() {
+		node := GraphNode{ID: step.ID, Kind: NodeStep, Action: step.Action, Params: step.Params, Status: statuses[step.ID]}
+		if run != nil && step.ID == run.FailedStep {
+			node.Error = run.Error
+		}
+		g.Nodes = append(g.Nodes, node)
+		if prev != "" {
+			g.Edges = append(g.Edges, GraphEdge{From: prev, To: step.ID, Kind: EdgeNext})
+		}
+		prev = step.ID
+
+		if inner, ok := step.Params["step"].(map[string]interface{}); ok {
+			action, _ := inner["action"].(string)
+			params, _ := inner["params"].(map[string]interface{})
+			id := step.ID + "/step"
+			g.Nodes = append(g.Nodes, GraphNode{ID: id, Kind: NodeInner, Action: action, Parent: step.ID, Params: params, Status: statuses[step.ID]})
+			g.Edges = append(g.Edges, GraphEdge{From: step.ID, To: id, Kind: EdgeRepeats})
+		}
+		if children, ok := step.Params["steps"].([]interface{}); ok && step.Action == "parallel" {
+			for n, c := range children {
+				child, _ := c.(map[string]interface{})
+				action, _ := child["action"].(string)
+				params, _ := child["params"].(map[string]interface{})
+				childID, _ := child["id"].(string)
+				if childID == "" {
+					childID = fmt.Sprintf("%d", n)
+				}
+				id := step.ID + "/" + childID
+				g.Nodes = append(g.Nodes, GraphNode{ID: id, Kind: NodeInner, Action: action, Parent: step.ID, Params: params, Status: statuses[step.ID]})
+				g.Edges = append(g.Edges, GraphEdge{From: step.ID, To: id, Kind: EdgeRuns})
+			}
+		}
+	}
+
+	hooks := f.GetHooks()
+	for _, stage := range []struct {
+		name  string
+		steps []Step
+	}{
+		{"before_each", hooks.BeforeEach},
+		{"after_each", hooks.AfterEach},
+		{"on_failure", hooks.OnFailure},
+	} {
+		for i, step := range stage.steps {
+			g.Nodes = append(g.Nodes, GraphNode{
+				ID:     fmt.Sprintf("hook:%s:%d", stage.name, i),
+				Kind:   NodeHook,
+				Action: step.Action,
+				Hook:   stage.name,
+				Params: step.Params,
+			})
+		}
+	}
+	return g
+}
+
+// stepStatuses works out the status of each step in run from how it ended.
+func stepStatuses(steps []Step, run *Run) map[string]string {
+	statuses := make(map[string]string, len(steps))
+	switch run.Status {
+	case RunStatusSucceeded, RunStatusDegraded:
+		for _, step := range steps {
+			statuses[step.ID] = NodeSucceeded
+		}
+		// Violations are located as "$.<step id>...".
+		for _, v := range run.SchemaViolations {
+			id := strings.TrimPrefix(v.Path, "$.")
+			if i := strings.IndexAny(id, ".["); i >= 0 {
+				id = id[:i]
+			}
+			if _, ok := statuses[id]; ok {
+				statuses[id] = NodeDegraded
+			}
+		}
+	case RunStatusFailed:
+		status := NodeSucceeded
+		if run.FailedStep == "" {
+			status = NodeSkipped
+		}
+		for _, step := range steps {
+			if step.ID == run.FailedStep {
+				statuses[step.ID] = NodeFailed
+				status = NodeSkipped
+				continue
+			}
+			statuses[step.ID] = status
+		}
+	default:
+		for _, step := range steps {
+			statuses[step.ID] = NodePending
+			if _, ok := run.Results[step.ID]; ok {
+				statuses[step.ID] = NodeSucceeded
+			}
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,211 @@
+package flow
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+
+	"auto/model"
+
+	"github.com/google/uuid"
+)
+
+// skippedResourceTypes are the resources a page loads on its own, which a
+// flow need not request.
+var skippedResourceTypes = map[string]bool{
+	"stylesheet": true, "script": true, "image": true, "font": true, "media": true,
+	"manifest": true, "texttrack": true, "ping": true, "preflight": true,
+	"websocket": true, "eventsource": true, "other": true,
+}
+
+// skippedHeaders are set by the browser for every request, or carry the
+// session the instance has on its own.
+var skippedHeaders = map[string]bool{
+	"host": true, "content-length": true, "cookie": true, "connection": true,
+	"accept-encoding": true, "user-agent": true, "origin": true, "referer": true,
+	"pragma": true, "cache-control": true, "priority": true, "te": true,
+	"upgrade-insecure-requests": true, "keep-alive": true,
+}
+
+// ImportHAR creates a flow from the entries of a HAR file: a navigate step
+// for each document the browser loaded and an httpRequest step for each
+// XHR or fetch call, in the order they were sent. Query string parameters,
+// form fields and the top-level fields of JSON bodies become run
+// parameters. Static resources, preflights and the redirects a navigation
+// follows anyway are skipped.
+func (m *Manager) ImportHAR(har *model.HAR, dest ImportTarget) (*RequestImport, error) {
+	steps, params, skipped := stepsFromHAR(har)
+	if len(steps) == 0 {
+		return nil, errors.New("the HAR file has no documents or API requests to make steps of")
+	}
+	return m.createImport("HAR import", dest, steps, params, skipped)
+}
+
+func stepsFromHAR(har *model.HAR) ([]Step, importParams, int) {
+	params := importParams{}
+	steps := []Step{}
+	skipped := 0
+	redirectTo := ""
+	for _, entry := range har.Log.Entries {
+		req := entry.Request
+		u, err := url.Parse(req.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || req.Method == "OPTIONS" {
+			skipped++
+			continue
+		}
+		u.Fragment = ""
+
+		switch harKind(entry) {
+		case "document":
+			if req.Method == "GET" {
+				if redirectTo != "" && u.String() == redirectTo {
+					// The navigation before this one lands here by itself.
+					skipped++
+					redirectTo = ""
+					continue
+				}
+				redirectTo = ""
+				if entry.Response.RedirectURL != "" {
+					if target, err := u.Parse(entry.Response.RedirectURL); err == nil {
+						target.Fragment = ""
+						redirectTo = target.String()
+					}
+				}
+				steps = append(steps, Step{
+					ID:     uuid.New().String(),
+					Action: "navigate",
+					Params: map[string]interface{}{"url": harURL(u, params)},
+				})
+				continue
+			}
+			// A form posted by the page: navigate cannot send a body.
+			steps = append(steps, httpRequestStep(entry, u, params))
+		case "api":
+			steps = append(steps, httpRequestStep(entry, u, params))
+		default:
+			skipped++
+		}
+	}
+	return steps, params, skipped
+}
+
+// harKind tells the documents and API calls of a HAR file from the rest by
+// the resource type Chrome records or else by the content types.
+func harKind(entry model.HAREntry) string {
+	switch t := strings.ToLower(entry.ResourceType); {
+	case t == "document":
+		return "document"
+	case t == "xhr" || t == "fetch":
+		return "api"
+	case skippedResourceTypes[t]:
+		return ""
+	}
+	mime := strings.ToLower(entry.Response.Content.MimeType)
+	switch {
+	case entry.Request.Method == "GET" && strings.Contains(mime, "text/html"):
+		return "document"
+	case strings.Contains(mime, "json") || strings.Contains(mime, "xml") || entry.Request.Method != "GET":
+		return "api"
+	}
+	return ""
+}
+
+// harURL returns u with the values of its query string replaced by run
+// parameters. The placeholders are left unescaped for fillParams to find,
+// and the values are filled in as given, so they are recorded URL-encoded.
+func harURL(u *url.URL, params importParams) string {
+	query := u.Query()
+	if len(query) == 0 {
+		return u.String()
+	}
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			value := url.QueryEscape(v)
+			if v != "" {
+				value = params.placeholder(k, value)
+			}
+			pairs = append(pairs, url.QueryEscape(k)+"="+value)
+		}
+	}
+	base := *u
+	base.RawQuery = ""
+	return base.String() + "?" + strings.Join(pairs, "&")
+}
+
+func httpRequestStep(entry model.HAREntry, u *url.URL, params importParams) Step {
+	req := entry.Request
+	stepParams := map[string]interface{}{
+		"url":    harURL(u, params),
+		"method": req.Method,
+	}
+	headers := map[string]interface{}{}
+	contentType := ""
+	for _, h := range req.Headers {
+		name := strings.ToLower(h.Name)
+		if strings.HasPrefix(name, ":") || strings.HasPrefix(name, "sec-") || skippedHeaders[name] {
+			continue
+		}
+		if name == "content-type" {
+			contentType = h.Value
+		}
+		headers[h.Name] = h.Value
+	}
+	if len(headers) > 0 {
+		stepParams["headers"] = headers
+	}
+	if req.PostData != nil && req.PostData.Text != "" {
+		if contentType == "" {
+			contentType = req.PostData.MimeType
+		}
+		body := req.PostData.Text
+		switch {
+		case strings.Contains(contentType, "json"):
+			var fields map[string]interface{}
+			if json.Unmarshal([]byte(body), &fields) == nil {
+				keys := make([]string, 0, len(fields))
+				for k := range fields {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				for _, k := range keys {
+					switch fields[k].(type) {
+					case string, float64, bool:
+						fields[k] = params.placeholder(k, fields[k])
+					}
+				}
+				stepParams["json"] = fields
+				break
+			}
+			stepParams["body"] = body
+		case strings.Contains(contentType, "application/x-www-form-urlencoded"):
+			stepParams["body"] = harForm(body, params)
+		default:
+			stepParams["body"] = body
+		}
+	}
+	return Step{ID: uuid.New().String(), Action: "httpRequest", Params: stepParams}
+}
+
+// harForm replaces the values of a form body by run parameters, recorded
+// URL-encoded like those of query strings.
+func harForm(body string, params importParams) string {
+	var pairs []string
+	for _, pair := range strings.Split(body, "&") {
+		k, v, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(k)
+		if err != nil || v == "" {
+			pairs = append(pairs, pair)
+			continue
+		}
+		pairs = append(pairs, k+"="+params.placeholder(name, v))
+	}
+	return strings.Join(pairs, "&")
+}
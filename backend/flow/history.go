@@ -0,0 +1,169 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// DefaultHistoryMaxLen is how many results are kept per flow unless
+// SetHistoryLimit says otherwise.
+const DefaultHistoryMaxLen = 10000
+
+// HistoryEntry is the results of one completed run, as kept in the
+// history of its flow.
+type HistoryEntry struct {
+	RunID   string                 `json:"run_id"`
+	Status  string                 `json:"status"`
+	Time    time.Time              `json:"time"`
+	Results map[string]interface{} `json:"results"`
+}
+
+// HistoryQuery selects history entries. Zero From or To leave the range
+// open; Fields projects the results onto step IDs or dotted paths into
+// them, such as "prices.0.amount".
+type HistoryQuery struct {
+	From   time.Time
+	To     time.Time
+	Limit  int64
+	Fields []string
+}
+
+// HistoryStore keeps the results of completed runs in a Redis stream per
+// flow, "history:<flow id>", trimmed to the newest maxLen entries. Stream
+// IDs are the time the results were recorded, so ranges are cheap.
+type HistoryStore struct {
+	db     *redis.Client
+	maxLen int64
+}
+
+func NewHistoryStore(db *redis.Client, maxLen int64) *HistoryStore {
+	return &HistoryStore{db: db, maxLen: maxLen}
+}
+
+func historyKey(flowID string) string {
+	return "history:" + flowID
+}
+
+// Record appends the results of run to the history of its flow.
+func (s *HistoryStore) Record(ctx context.Context, run *Run) error {
+	results, err := json.Marshal(run.Results)
+	if err != nil {
+		return err
+	}
+	return s.db.XAdd(ctx, &redis.XAddArgs{
+		Stream: historyKey(run.FlowID),
+		MaxLen: s.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"run_id":  run.ID,
+			"status":  run.Status,
+			"results": results,
+		},
+	}).Err()
+}
+
+// Range returns the entries of a flow within q, oldest first.
+func (s *HistoryStore) Range(ctx context.Context, flowID string, q HistoryQuery) ([]HistoryEntry, error) {
+	start, stop := "-", "+"
+	if !q.From.IsZero() {
+		start = strconv.FormatInt(q.From.UnixMilli(), 10)
+	}
+	if !q.To.IsZero() {
+		stop = strconv.FormatInt(q.To.UnixMilli(), 10)
+	}
+	var msgs []redis.XMessage
+	var err error
+	if q.Limit > 0 {
+		msgs, err = s.db.XRangeN(ctx, historyKey(flowID), start, stop, q.Limit).Result()
+	} else {
+		msgs, err = s.db.XRange(ctx, historyKey(flowID), start, stop).Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeHistory(msgs, q.Fields)
+}
+
+// Latest returns the n newest entries of a flow, newest first.
+func (s *HistoryStore) Latest(ctx context.Context, flowID string, n int64, fields []string) ([]HistoryEntry, error) {
+	msgs, err := s.db.XRevRangeN(ctx, historyKey(flowID), "+", "-", n).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeHistory(msgs, fields)
+}
+
+// Delete drops the history of a flow.
+func (s *HistoryStore) Delete(ctx context.Context, flowID string) error {
+	return s.db.Del(ctx, historyKey(flowID)).Err()
+}
+
+func decodeHistory(msgs []redis.XMessage, fields []string) ([]HistoryEntry, error) {
+	entries := make([]HistoryEntry, 0, len(msgs))
+	for _, msg := range msgs {
+		ms, err := strconv.ParseInt(strings.SplitN(msg.ID, "-", 2)[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid history entry ID %s", msg.ID)
+		}
+		entry := HistoryEntry{Time: time.UnixMilli(ms).UTC()}
+		entry.RunID, _ = msg.Values["run_id"].(string)
+		entry.Status, _ = msg.Values["status"].(string)
+
+		var raw map[string]string
+		data, _ := msg.Values["results"].(string)
+		if err := json.Unmarshal([]byte(data), &raw); err != nil {
+			return nil, fmt.Errorf("invalid history entry %s: %w", msg.ID, err)
+		}
+		results := make(map[string]interface{}, len(raw))
+		for id, result := range raw {
+			var v interface{}
+			if err := json.Unmarshal([]byte(result), &v); err != nil {
+				v = result
+			}
+			results[id] = v
+		}
+		entry.Results = project(results, fields)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// project keeps the values at the given dotted paths, keyed by path;
+// paths that lead nowhere are left out.
+func project(results map[string]interface{}, fields []string) map[string]interface{} {
+	if len(fields) == 0 {
+		return results
+	}
+	out := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		var v interface{} = results
+		found := true
+		for _, part := range strings.Split(field, ".") {
+			switch node := v.(type) {
+			case map[string]interface{}:
+				v, found = node[part]
+			case []interface{}:
+				i, err := strconv.Atoi(part)
+				found = err == nil && i >= 0 && i < len(node)
+				if found {
+					v = node[i]
+				}
+			default:
+				found = false
+			}
+			if !found {
+				break
+			}
+		}
+		if found {
+			out[field] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,81 @@
+package flow
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"auto/model"
+
+	"go.uber.org/zap"
+)
+
+// DefaultScreenshotDir is where screenshot hooks save their images when no
+// "dir" parameter is given.
+const DefaultScreenshotDir = "screenshots"
+
+// Hooks are steps run around every step of a flow, so cross-cutting
+// behaviour does not have to be repeated in the flow itself. A hook is a
+// regular step action, or one of the hook-only actions "log", "script" and
+// "screenshot".
+type Hooks struct {
+	BeforeEach []Step `json:"before_each,omitempty"`
+	AfterEach  []Step `json:"after_each,omitempty"`
+	OnFailure  []Step `json:"on_failure,omitempty"`
+}
+
+// runHooks runs the hooks of one stage for step, stopping at the first
+// failing hook.
+func (m *Manager) runHooks(ctx context.Context, flowID string, stage string, hooks []Step, step Step, instance *model.Instance) error {
+	for _, hook := range hooks {
+		if err := m.runHook(ctx, flowID, stage, hook, step, instance); err != nil {
+			return fmt.Errorf("%s hook %s failed: %w", stage, hook.Action, err)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) runHook(ctx context.Context, flowID string, stage string, hook Step, step Step, instance *model.Instance) error {
+	switch hook.Action {
+	case "log":
+		message, _ := hook.Params["message"].(string)
+		m.logger.Info(message, zap.String("flowID", flowID), zap.String("stepID", step.ID), zap.String("stage", stage))
+		return nil
+	case "script":
+		_, err := instance.ExecuteContext(ctx, "evaluate", hook.Params)
+		return err
+	case "screenshot":
+		data, err := instance.ExecuteContext(ctx, "screenshot", hook.Params)
+		if err != nil {
+			return err
+		}
+		return m.saveScreenshot(flowID, stage, step, hook.Params, data)
+	default:
+		_, err := instance.ExecuteContext(ctx, hook.Action, hook.Params)
+		return err
+	}
+}
+
+func (m *Manager) saveScreenshot(flowID string, stage string, step Step, params map[string]interface{}, data string) error {
+	img, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return err
+	}
+	dir, _ := params["dir"].(string)
+	if dir == "" {
+		dir = DefaultScreenshotDir
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s_%s_%s_%d.png", flowID, step.ID, stage, time.Now().UnixNano())
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, img, 0644); err != nil {
+		return err
+	}
+	m.logger.Info("Saved hook screenshot", zap.String("flowID", flowID), zap.String("stepID", step.ID), zap.String("path", path))
+	return nil
+}
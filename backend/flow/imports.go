@@ -0,0 +1,138 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"auto/model"
+
+	"github.com/google/uuid"
+)
+
+// ImportTarget names a flow made from recorded requests and what it runs
+// on. The instance group, when set, takes precedence over the instance.
+type ImportTarget struct {
+	Name          string
+	InstanceID    string
+	InstanceGroup string
+}
+
+// RequestImport is a flow made from recorded requests: a HAR file, curl
+// commands or a Postman collection. Params holds the values the requests
+// were sent with, by the name of the run parameter that replaced them:
+// running the flow with them repeats the requests.
+type RequestImport struct {
+	Flow   *FlowImpl              `json:"flow"`
+	Params map[string]interface{} `json:"params"`
+	// Skipped counts the requests left out.
+	Skipped int `json:"skipped"`
+}
+
+// paramName turns a field name into a valid run parameter name.
+var paramName = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// importParams hands out run parameter names for recorded values. A field
+// recorded again with the same value shares its parameter; with another
+// value it gets a parameter of its own, "name_2" and so on.
+type importParams map[string]interface{}
+
+func (p importParams) placeholder(field string, value interface{}) string {
+	base := strings.Trim(paramName.ReplaceAllString(field, "_"), "_")
+	if base == "" {
+		base = "param"
+	}
+	name := base
+	for n := 2; ; n++ {
+		existing, ok := p[name]
+		if !ok {
+			p[name] = value
+			break
+		}
+		if existing == value {
+			break
+		}
+		name = fmt.Sprintf("%s_%d", base, n)
+	}
+	return "{{param:" + name + "}}"
+}
+
+// createImport stores a flow of imported steps. Without a name it is named
+// after kind and the host of its first step.
+func (m *Manager) createImport(kind string, dest ImportTarget, steps []Step, params importParams, skipped int) (*RequestImport, error) {
+	name := dest.Name
+	if name == "" {
+		name = kind
+		first, _ := steps[0].Params["url"].(string)
+		if u, err := url.Parse(first); err == nil && u.Host != "" {
+			name += " " + u.Host
+		}
+	}
+	f := &FlowImpl{
+		ID:            uuid.New().String(),
+		Name:          name,
+		InstanceID:    dest.InstanceID,
+		InstanceGroup: dest.InstanceGroup,
+		Steps:         steps,
+		Revision:      1,
+	}
+	if err := m.repo.CreateFlow(context.Background(), f); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.flows[f.ID] = f
+	m.mu.Unlock()
+
+	flowJSON, _ := json.Marshal(f)
+	m.cache.HSet(context.Background(), "flows", f.ID, flowJSON)
+
+	return &RequestImport{Flow: f, Params: params, Skipped: skipped}, nil
+}
+
+// importedRequest is a request of a curl command or a Postman collection
+// on its way to becoming an httpRequest step.
+type importedRequest struct {
+	method  string
+	url     string
+	headers map[string]interface{}
+	body    string
+	auth    map[string]interface{}
+}
+
+func (r *importedRequest) header(name string) (string, bool) {
+	for k, v := range r.headers {
+		if strings.EqualFold(k, name) {
+			s, _ := v.(string)
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// step returns the httpRequest step sending r. A body must be of a content
+// type the Request model understands.
+func (r *importedRequest) step() (Step, error) {
+	params := map[string]interface{}{"url": r.url, "method": r.method}
+	if r.body != "" {
+		contentType, ok := r.header("Content-Type")
+		if !ok {
+			return Step{}, fmt.Errorf("%s %s has a body but no content type", r.method, r.url)
+		}
+		if !model.SupportedContentType(contentType) {
+			return Step{}, fmt.Errorf("%s %s: content type %q is not supported: must be application/json, application/x-www-form-urlencoded or multipart/form-data",
+				r.method, r.url, contentType)
+		}
+		params["body"] = r.body
+	}
+	if len(r.headers) > 0 {
+		params["headers"] = r.headers
+	}
+	if r.auth != nil {
+		params["auth"] = r.auth
+	}
+	return Step{ID: uuid.New().String(), Action: "httpRequest", Params: params}, nil
+}
@@ -0,0 +1,59 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"auto/apperr"
+)
+
+// Labels are free-form key/value pairs a run is started with, such as the
+// ticket or the customer it runs for, so that systems downstream can
+// correlate the run with their own records. They are stored with the run,
+// filter run lists, and go out with its events, notifications and output
+// webhooks.
+
+// Limits on the labels of a run.
+const (
+	maxLabels          = 32
+	maxLabelValueBytes = 256
+)
+
+var labelKey = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// ValidateLabels checks that labels are few and short enough to go along
+// with every event of a run.
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return apperr.New(apperr.CodeInvalidRequest, fmt.Sprintf("too many labels: %d, at most %d", len(labels), maxLabels))
+	}
+	for key, value := range labels {
+		if !labelKey.MatchString(key) {
+			return apperr.New(apperr.CodeInvalidRequest, fmt.Sprintf("invalid label %q: use up to 64 letters, digits, '_', '.' and '-'", key))
+		}
+		if len(value) > maxLabelValueBytes {
+			return apperr.New(apperr.CodeInvalidRequest, fmt.Sprintf("label %s is longer than %d bytes", key, maxLabelValueBytes))
+		}
+	}
+	return nil
+}
+
+type labelsKey struct{}
+
+// WithLabels returns a context under which the next flow run started is
+// labelled with labels.
+func WithLabels(ctx context.Context, labels map[string]string) context.Context {
+	return context.WithValue(ctx, labelsKey{}, labels)
+}
+
+// hasLabels reports whether the run carries every label of want, with its
+// value.
+func (r *Run) hasLabels(want map[string]string) bool {
+	for key, value := range want {
+		if got, ok := r.Labels[key]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
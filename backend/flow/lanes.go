@@ -0,0 +1,33 @@
+package flow
+
+import (
+	"context"
+)
+
+// SetRunQuotas bounds how many runs of each priority class execute at
+// once, so that batch runs cannot take every browser from interactive
+// ones; classes without a positive quota are unbounded. Call it before
+// running flows.
+func (m *Manager) SetRunQuotas(quotas map[string]int) {
+	m.lanes = make(map[string]chan struct{}, len(quotas))
+	for priority, n := range quotas {
+		if n > 0 {
+			m.lanes[priority] = make(chan struct{}, n)
+		}
+	}
+}
+
+// acquireLane waits for a slot in the quota of priority. The returned
+// function frees the slot.
+func (m *Manager) acquireLane(ctx context.Context, priority string) (func(), error) {
+	lane, ok := m.lanes[priority]
+	if !ok {
+		return func() {}, nil
+	}
+	select {
+	case lane <- struct{}{}:
+		return func() { <-lane }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,332 @@
+package flow
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"auto/model"
+)
+
+// Lint rules.
+const (
+	RuleUnknownAction     = "unknown-action"
+	RuleDuplicateStepID   = "duplicate-step-id"
+	RuleUnreachable       = "unreachable"
+	RuleUndefinedVariable = "undefined-variable"
+	RuleBrittleSelector   = "brittle-selector"
+	RuleMissingWait       = "missing-wait"
+)
+
+// Lint severities. Errors make a run fail; warnings are likely mistakes.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// LintIssue is a problem found in a flow by Lint.
+type LintIssue struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	StepID   string `json:"step_id,omitempty"`
+	Message  string `json:"message"`
+}
+
+// serverActions are run by the flow manager rather than the instance.
+var serverActions = map[string]bool{"template": true, "serverScript": true, "visualCheck": true}
+
+// autoWaitActions wait for their selector before acting, so they are safe
+// right after a navigation.
+var autoWaitActions = map[string]bool{
+	"click": true, "sendKeys": true, "waitVisible": true, "text": true,
+	"fingerprint": true, "wait": true, "tap": true,
+}
+
+var (
+	nthSelector   = regexp.MustCompile(`:nth-(child|of-type|last-child|last-of-type)\(`)
+	scriptStepRef = regexp.MustCompile(`\bsteps(?:\.([A-Za-z_$][\w$]*)|\[\s*["']([^"']+)["']\s*\])`)
+)
+
+// Lint checks the steps of f for problems that show up only when it runs:
+// unknown actions and the steps they leave unreachable, results used
+// before any step sets them, brittle selectors and pages used right after
+// navigating without waiting for them.
+func Lint(f Flow) []LintIssue {
+	issues := []LintIssue{}
+	add := func(rule, severity, stepID, format string, args ...interface{}) {
+		issues = append(issues, LintIssue{Rule: rule, Severity: severity, StepID: stepID, Message: fmt.Sprintf(format, args...)})
+	}
+
+	known := make(map[string]bool)
+	for _, name := range model.Actions() {
+		known[name] = true
+	}
+	steps := f.GetSteps()
+
+	// Results a step can use: those of the steps before it.
+	setAt := make(map[string]int)
+	for i, step := range steps {
+		name := step.ID
+		if step.Action == "template" {
+			name = "templateResult"
+		}
+		if _, ok := setAt[name]; !ok && name != "" {
+			setAt[name] = i
+		}
+		if step.Action == "httpRequest" {
+			extract, _ := step.Params["extract"].(map[string]interface{})
+			for name := range extract {
+				if _, ok := setAt[name]; !ok {
+					setAt[name] = i
+				}
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	blockedBy := ""
+	for i, step := range steps {
+		if step.ID != "" && seen[step.ID] {
+			add(RuleDuplicateStepID, SeverityError, step.ID, "step ID %q is used more than once; later results overwrite earlier ones", step.ID)
+		}
+		seen[step.ID] = true
+
+		if blockedBy != "" {
+			add(RuleUnreachable, SeverityWarning, step.ID, "step %s never runs: step %s before it always fails", stepName(step, i), blockedBy)
+		}
+		if !known[step.Action] && !serverActions[step.Action] {
+			add(RuleUnknownAction, SeverityError, step.ID, "unknown action %q", step.Action)
+			if blockedBy == "" {
+				blockedBy = stepName(step, i)
+			}
+		}
+
+		for _, ref := range variableRefs(step) {
+			at, ok := setAt[ref]
+			switch {
+			case !ok:
+				add(RuleUndefinedVariable, SeverityError, step.ID, "%q is referenced but no step sets it", ref)
+			case at >= i:
+				add(RuleUndefinedVariable, SeverityError, step.ID, "%q is referenced before step %s sets it", ref, stepName(steps[at], at))
+			}
+		}
+
+		sels := selectors(step.Params)
+		keys := make([]string, 0, len(sels))
+		for key := range sels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if reason := brittleSelector(sels[key]); reason != "" {
+				add(RuleBrittleSelector, SeverityWarning, step.ID, "%s %q %s", key, sels[key], reason)
+			}
+		}
+
+		if i > 0 && steps[i-1].Action == "navigate" && !waitsForPage(step) {
+			add(RuleMissingWait, SeverityWarning, step.ID, "step %s (%s) uses the page right after navigating without waiting for it; add a waitVisible or wait step", stepName(step, i), step.Action)
+		}
+	}
+	return issues
+}
+
+// LintFlow lints a stored flow.
+func (m *Manager) LintFlow(id string) ([]LintIssue, error) {
+	f, err := m.GetFlow(id)
+	if err != nil {
+		return nil, err
+	}
+	return Lint(f), nil
+}
+
+func stepName(step Step, i int) string {
+	if step.ID != "" {
+		return step.ID
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
+
+// variableRefs returns the results a step reads: its "{{var:NAME}}"
+// placeholders and, for server steps, the fields of the template or the
+// steps.X of the script.
+func variableRefs(step Step) []string {
+	var refs []string
+	for _, m := range varRef.FindAllStringSubmatch(paramText(step.Params), -1) {
+		refs = append(refs, m[1])
+	}
+	sort.Strings(refs)
+	switch step.Action {
+	case "template":
+		text, _ := step.Params["template"].(string)
+		tmpl, err := template.New("response").Parse(text)
+		if err != nil || tmpl.Tree == nil {
+			return refs
+		}
+		return append(refs, templateRefs(tmpl.Tree.Root)...)
+	case "serverScript":
+		code, _ := step.Params["script"].(string)
+		for _, m := range scriptStepRef.FindAllStringSubmatch(code, -1) {
+			if m[1] != "" {
+				refs = append(refs, m[1])
+			} else {
+				refs = append(refs, m[2])
+			}
+		}
+	}
+	return refs
+}
+
+// paramText joins the string values of params, nested ones included.
+func paramText(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		var parts []string
+		for _, item := range v {
+			parts = append(parts, paramText(item))
+		}
+		return strings.Join(parts, "\n")
+	case []interface{}:
+		var parts []string
+		for _, item := range v {
+			parts = append(parts, paramText(item))
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+// templateRefs finds ".name" and `index . "name"` uses of the results map.
+// The bodies of range and with rebind dot, so only their pipelines count.
+func templateRefs(node parse.Node) []string {
+	var refs []string
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			refs = append(refs, templateRefs(child)...)
+		}
+	case *parse.ActionNode:
+		refs = append(refs, templateRefs(n.Pipe)...)
+	case *parse.IfNode:
+		refs = append(refs, templateRefs(n.Pipe)...)
+		refs = append(refs, templateRefs(n.List)...)
+		refs = append(refs, templateRefs(n.ElseList)...)
+	case *parse.RangeNode:
+		refs = append(refs, templateRefs(n.Pipe)...)
+		refs = append(refs, templateRefs(n.ElseList)...)
+	case *parse.WithNode:
+		refs = append(refs, templateRefs(n.Pipe)...)
+		refs = append(refs, templateRefs(n.ElseList)...)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			refs = append(refs, templateRefs(cmd)...)
+		}
+	case *parse.CommandNode:
+		if len(n.Args) == 3 {
+			if id, ok := n.Args[0].(*parse.IdentifierNode); ok && id.Ident == "index" {
+				if _, ok := n.Args[1].(*parse.DotNode); ok {
+					if s, ok := n.Args[2].(*parse.StringNode); ok {
+						refs = append(refs, s.Text)
+					}
+				}
+			}
+		}
+		for _, arg := range n.Args {
+			refs = append(refs, templateRefs(arg)...)
+		}
+	case *parse.FieldNode:
+		refs = append(refs, n.Ident[0])
+	}
+	return refs
+}
+
+// selectors returns the string params of a step that hold selectors, by
+// name, including those of a step repeated by a composite step.
+func selectors(params map[string]interface{}) map[string]string {
+	out := make(map[string]string)
+	for key, value := range params {
+		lower := strings.ToLower(key)
+		if !strings.Contains(lower, "selector") && lower != "container" && lower != "next" {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			if v != "" {
+				out[key] = v
+			}
+		case []interface{}:
+			// Fallback lists, as in "selectors".
+			for i, item := range v {
+				if s, ok := item.(string); ok && s != "" {
+					out[fmt.Sprintf("%s[%d]", key, i)] = s
+				}
+			}
+		}
+	}
+	if inner, ok := params["step"].(map[string]interface{}); ok {
+		if innerParams, ok := inner["params"].(map[string]interface{}); ok {
+			for key, s := range selectors(innerParams) {
+				out["step."+key] = s
+			}
+		}
+	}
+	if children, ok := params["steps"].([]interface{}); ok {
+		// The children of a parallel step.
+		for n, c := range children {
+			child, _ := c.(map[string]interface{})
+			childParams, _ := child["params"].(map[string]interface{})
+			for key, s := range selectors(childParams) {
+				out[fmt.Sprintf("steps[%d].%s", n, key)] = s
+			}
+		}
+	}
+	return out
+}
+
+// brittleSelector says why sel is likely to break when the page changes,
+// or returns "".
+func brittleSelector(sel string) string {
+	if strings.HasPrefix(sel, "/html") || strings.HasPrefix(sel, "//html") {
+		return "is an absolute XPath"
+	}
+	if n := len(nthSelector.FindAllStringIndex(sel, -1)); n >= 2 {
+		return fmt.Sprintf("chains %d positional :nth-* selectors", n)
+	}
+	if n := strings.Count(sel, ">"); n >= 4 {
+		return fmt.Sprintf("spells out %d levels of the DOM", n+1)
+	}
+	return ""
+}
+
+// waitsForPage reports whether step is safe right after a navigation: it
+// does not touch the page, or it waits for its element first.
+func waitsForPage(step Step) bool {
+	if step.Action == "visualCheck" {
+		// Screenshots the page as it is.
+		return false
+	}
+	if step.Action == "navigate" || step.Action == "restoreSnapshot" || serverActions[step.Action] {
+		return true
+	}
+	if strings.HasPrefix(step.Action, "storage") || strings.HasPrefix(step.Action, "clipboard") || step.Action == "setGeolocation" || step.Action == "emulateDevice" || step.Action == "httpRequest" {
+		return true
+	}
+	if autoWait, ok := step.Params["autoWait"].(bool); ok && !autoWait {
+		return false
+	}
+	if step.Action == "extractTable" || step.Action == "ocr" {
+		sel, _ := step.Params["selector"].(string)
+		return sel != ""
+	}
+	return autoWaitActions[step.Action]
+}
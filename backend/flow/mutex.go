@@ -0,0 +1,84 @@
+package flow
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// A flow mutex is the Redis key "flow_mutex:<key>" holding the ID of the
+// run that owns it. The owner renews it while it runs, so the mutex of a
+// server that dies frees itself after mutexTTL.
+const (
+	mutexTTL  = 30 * time.Second
+	mutexPoll = 500 * time.Millisecond
+)
+
+var (
+	// renewMutex extends the mutex KEYS[1] if ARGV[1] still owns it.
+	renewMutex = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	// releaseMutex deletes the mutex KEYS[1] if ARGV[1] still owns it.
+	releaseMutex = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// acquireMutex waits until the run owner holds the flow mutex key, across
+// every server sharing the Redis database. onWait is called once if the
+// mutex is held by another run. The returned function frees it.
+func (m *Manager) acquireMutex(ctx context.Context, key, owner string, onWait func()) (func(), error) {
+	redisKey := "flow_mutex:" + key
+	waited := false
+	for {
+		ok, err := m.db.SetNX(ctx, redisKey, owner, mutexTTL).Result()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		if !waited {
+			waited = true
+			onWait()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(mutexPoll):
+		}
+	}
+
+	renewCtx, stop := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(mutexTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-renewCtx.Done():
+				return
+			case <-ticker.C:
+				held, err := renewMutex.Run(renewCtx, m.db, []string{redisKey}, owner, mutexTTL.Milliseconds()).Int()
+				if err != nil && renewCtx.Err() == nil {
+					m.logger.Warn("Failed to renew flow mutex", zap.String("mutex", key), zap.String("runID", owner), zap.Error(err))
+				} else if err == nil && held == 0 {
+					m.logger.Warn("Lost flow mutex", zap.String("mutex", key), zap.String("runID", owner))
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		stop()
+		if err := releaseMutex.Run(context.Background(), m.db, []string{redisKey}, owner).Err(); err != nil {
+			m.logger.Warn("Failed to release flow mutex", zap.String("mutex", key), zap.String("runID", owner), zap.Error(err))
+		}
+	}, nil
+}
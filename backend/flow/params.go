@@ -0,0 +1,97 @@
+package flow
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// paramRef matches the "{{param:NAME}}" placeholders through which step
+// parameters use the parameters a run was started with.
+var paramRef = regexp.MustCompile(`\{\{param:([A-Za-z0-9_.-]+)\}\}`)
+
+// varRef matches the "{{var:NAME}}" placeholders through which step
+// parameters use run variables: the results of earlier steps, by step ID,
+// and the values httpRequest steps extract.
+var varRef = regexp.MustCompile(`\{\{var:([A-Za-z0-9_.-]+)\}\}`)
+
+// placeholders fills one kind of placeholder with values.
+type placeholders struct {
+	ref    *regexp.Regexp
+	kind   string
+	values map[string]interface{}
+}
+
+// fillParams returns a copy of params with the run parameter placeholders
+// in its string values replaced. A value that is a single placeholder
+// takes the parameter as is, keeping its type.
+func fillParams(params map[string]interface{}, values map[string]interface{}) (map[string]interface{}, error) {
+	return placeholders{paramRef, "run parameter", values}.fill(params)
+}
+
+// fillVars returns a copy of params with the run variable placeholders in
+// its string values replaced.
+func fillVars(params map[string]interface{}, results map[string]string) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(results))
+	for k, v := range results {
+		values[k] = v
+	}
+	return placeholders{varRef, "run variable", values}.fill(params)
+}
+
+func (p placeholders) fill(params map[string]interface{}) (map[string]interface{}, error) {
+	filled, err := p.fillValue(params)
+	if err != nil {
+		return nil, err
+	}
+	out, _ := filled.(map[string]interface{})
+	return out, nil
+}
+
+func (p placeholders) fillValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		if m := p.ref.FindStringSubmatch(v); m != nil && m[0] == v {
+			value, ok := p.values[m[1]]
+			if !ok {
+				return nil, fmt.Errorf("missing %s: %s", p.kind, m[1])
+			}
+			return value, nil
+		}
+		var missing string
+		s := p.ref.ReplaceAllStringFunc(v, func(ref string) string {
+			name := p.ref.FindStringSubmatch(ref)[1]
+			value, ok := p.values[name]
+			if !ok {
+				missing = name
+				return ref
+			}
+			return fmt.Sprint(value)
+		})
+		if missing != "" {
+			return nil, fmt.Errorf("missing %s: %s", p.kind, missing)
+		}
+		return s, nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			filled, err := p.fillValue(item)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = filled
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			filled, err := p.fillValue(item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = filled
+		}
+		return out, nil
+	default:
+		return v, nil
+	}
+}
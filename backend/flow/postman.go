@@ -0,0 +1,309 @@
+package flow
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// PostmanCollection is the part of a Postman collection, format v2.0 or
+// v2.1, that becomes a flow.
+type PostmanCollection struct {
+	Info struct {
+		Name string `json:"name"`
+	} `json:"info"`
+	Item     []PostmanItem `json:"item"`
+	Auth     *PostmanAuth  `json:"auth,omitempty"`
+	Variable []PostmanKV   `json:"variable,omitempty"`
+}
+
+// PostmanItem is a request or, with Item, a folder of them.
+type PostmanItem struct {
+	Name    string          `json:"name"`
+	Item    []PostmanItem   `json:"item,omitempty"`
+	Request *PostmanRequest `json:"request,omitempty"`
+	Auth    *PostmanAuth    `json:"auth,omitempty"`
+}
+
+type PostmanRequest struct {
+	Method string       `json:"method"`
+	Header []PostmanKV  `json:"header"`
+	URL    PostmanURL   `json:"url"`
+	Body   *PostmanBody `json:"body,omitempty"`
+	Auth   *PostmanAuth `json:"auth,omitempty"`
+}
+
+// PostmanURL is written either as a string or as an object with the raw
+// URL and its parts.
+type PostmanURL struct {
+	Raw   string      `json:"raw"`
+	Query []PostmanKV `json:"query,omitempty"`
+}
+
+func (u *PostmanURL) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &u.Raw)
+	}
+	type plain PostmanURL
+	return json.Unmarshal(data, (*plain)(u))
+}
+
+type PostmanBody struct {
+	Mode       string      `json:"mode"`
+	Raw        string      `json:"raw,omitempty"`
+	URLEncoded []PostmanKV `json:"urlencoded,omitempty"`
+	FormData   []PostmanKV `json:"formdata,omitempty"`
+	GraphQL    *struct {
+		Query     string `json:"query"`
+		Variables string `json:"variables"`
+	} `json:"graphql,omitempty"`
+	Options struct {
+		Raw struct {
+			Language string `json:"language"`
+		} `json:"raw"`
+	} `json:"options"`
+}
+
+// PostmanAuth keeps its settings as lists of key and value, by type.
+type PostmanAuth struct {
+	Type   string      `json:"type"`
+	Bearer []PostmanKV `json:"bearer,omitempty"`
+	Basic  []PostmanKV `json:"basic,omitempty"`
+	APIKey []PostmanKV `json:"apikey,omitempty"`
+}
+
+// PostmanKV is a header, query parameter, form field, variable or auth
+// setting. Values of variables and settings may be of any JSON type.
+type PostmanKV struct {
+	Key      string      `json:"key"`
+	Value    interface{} `json:"value"`
+	Type     string      `json:"type,omitempty"`
+	Disabled bool        `json:"disabled,omitempty"`
+}
+
+func (kv PostmanKV) value() string {
+	if kv.Value == nil {
+		return ""
+	}
+	if s, ok := kv.Value.(string); ok {
+		return s
+	}
+	return fmt.Sprint(kv.Value)
+}
+
+// postmanVar matches the "{{name}}" variables of Postman. Dynamic ones,
+// such as {{$guid}}, are left alone.
+var postmanVar = regexp.MustCompile(`\{\{([A-Za-z0-9_.-]+)\}\}`)
+
+// ImportPostman creates a flow with an httpRequest step for each request
+// of a collection, folders included, in order. The variables of the
+// collection become run parameters, with their values as the defaults.
+func (m *Manager) ImportPostman(c *PostmanCollection, dest ImportTarget) (*RequestImport, error) {
+	params := importParams{}
+	for _, v := range c.Variable {
+		if !v.Disabled && v.Key != "" {
+			params[v.Key] = v.value()
+		}
+	}
+	var steps []Step
+	skipped := 0
+	var walk func(items []PostmanItem, auth *PostmanAuth) error
+	walk = func(items []PostmanItem, auth *PostmanAuth) error {
+		for _, item := range items {
+			itemAuth := auth
+			if item.Auth != nil {
+				itemAuth = item.Auth
+			}
+			if item.Request == nil {
+				if err := walk(item.Item, itemAuth); err != nil {
+					return err
+				}
+				continue
+			}
+			req, err := postmanRequest(item.Request, itemAuth)
+			if err == errNoURL {
+				skipped++
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("request %q: %w", item.Name, err)
+			}
+			step, err := req.step()
+			if err != nil {
+				return fmt.Errorf("request %q: %w", item.Name, err)
+			}
+			steps = append(steps, step)
+		}
+		return nil
+	}
+	if err := walk(c.Item, c.Auth); err != nil {
+		return nil, err
+	}
+	if len(steps) == 0 {
+		return nil, errors.New("the collection has no requests")
+	}
+	if dest.Name == "" {
+		dest.Name = c.Info.Name
+	}
+	return m.createImport("Postman import", dest, steps, params, skipped)
+}
+
+var errNoURL = errors.New("request has no URL")
+
+func postmanRequest(r *PostmanRequest, auth *PostmanAuth) (*importedRequest, error) {
+	raw := r.URL.Raw
+	if raw == "" {
+		return nil, errNoURL
+	}
+	req := &importedRequest{
+		method:  strings.ToUpper(r.Method),
+		url:     postmanParams(raw),
+		headers: map[string]interface{}{},
+	}
+	if req.method == "" {
+		req.method = "GET"
+	}
+	for _, h := range r.Header {
+		if !h.Disabled && h.Key != "" {
+			req.headers[h.Key] = postmanParams(h.value())
+		}
+	}
+	if r.Auth != nil {
+		auth = r.Auth
+	}
+	if err := postmanAuth(req, auth); err != nil {
+		return nil, err
+	}
+	if r.Body != nil {
+		if err := postmanBody(req, r.Body); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// postmanParams turns the variables of s into run parameter placeholders.
+func postmanParams(s string) string {
+	return postmanVar.ReplaceAllString(s, "{{param:$1}}")
+}
+
+func postmanSetting(kvs []PostmanKV, key string) string {
+	for _, kv := range kvs {
+		if kv.Key == key {
+			return postmanParams(kv.value())
+		}
+	}
+	return ""
+}
+
+func postmanAuth(req *importedRequest, auth *PostmanAuth) error {
+	if auth == nil {
+		return nil
+	}
+	switch auth.Type {
+	case "", "noauth":
+	case "bearer":
+		req.auth = map[string]interface{}{"type": "bearer", "token": postmanSetting(auth.Bearer, "token")}
+	case "basic":
+		req.auth = map[string]interface{}{
+			"type":     "basic",
+			"username": postmanSetting(auth.Basic, "username"),
+			"password": postmanSetting(auth.Basic, "password"),
+		}
+	case "apikey":
+		key, value := postmanSetting(auth.APIKey, "key"), postmanSetting(auth.APIKey, "value")
+		if postmanSetting(auth.APIKey, "in") == "query" {
+			sep := "?"
+			if strings.Contains(req.url, "?") {
+				sep = "&"
+			}
+			req.url += sep + key + "=" + value
+		} else {
+			req.headers[key] = value
+		}
+	default:
+		return fmt.Errorf("auth type %q is not supported: must be bearer, basic or apikey", auth.Type)
+	}
+	return nil
+}
+
+func postmanBody(req *importedRequest, body *PostmanBody) error {
+	switch body.Mode {
+	case "", "none":
+	case "raw":
+		req.body = postmanParams(body.Raw)
+		if body.Options.Raw.Language == "json" {
+			setDefaultHeader(req, "Content-Type", "application/json")
+		}
+	case "urlencoded":
+		var pairs []string
+		for _, kv := range body.URLEncoded {
+			if !kv.Disabled {
+				pairs = append(pairs, escapeOutsideParams(kv.Key)+"="+escapeOutsideParams(kv.value()))
+			}
+		}
+		req.body = strings.Join(pairs, "&")
+		setDefaultHeader(req, "Content-Type", "application/x-www-form-urlencoded")
+	case "formdata":
+		var buf bytes.Buffer
+		w := multipart.NewWriter(&buf)
+		for _, kv := range body.FormData {
+			if kv.Disabled {
+				continue
+			}
+			if kv.Type == "file" {
+				return fmt.Errorf("form field %q uploads a file, which is not supported", kv.Key)
+			}
+			if err := w.WriteField(kv.Key, postmanParams(kv.value())); err != nil {
+				return err
+			}
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		req.body = buf.String()
+		// Postman sets the boundary itself, so a recorded header is stale.
+		for k := range req.headers {
+			if strings.EqualFold(k, "Content-Type") {
+				delete(req.headers, k)
+			}
+		}
+		req.headers["Content-Type"] = w.FormDataContentType()
+	case "graphql":
+		if body.GraphQL == nil {
+			return nil
+		}
+		payload := map[string]interface{}{"query": body.GraphQL.Query}
+		if vars := strings.TrimSpace(body.GraphQL.Variables); vars != "" {
+			payload["variables"] = json.RawMessage(vars)
+		}
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("invalid GraphQL variables: %w", err)
+		}
+		req.body = postmanParams(string(data))
+		setDefaultHeader(req, "Content-Type", "application/json")
+	default:
+		return fmt.Errorf("body mode %q is not supported", body.Mode)
+	}
+	return nil
+}
+
+// escapeOutsideParams URL-encodes s, turning its variables into run
+// parameter placeholders left as they are.
+func escapeOutsideParams(s string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range postmanVar.FindAllStringSubmatchIndex(s, -1) {
+		b.WriteString(url.QueryEscape(s[last:loc[0]]))
+		b.WriteString("{{param:" + s[loc[2]:loc[3]] + "}}")
+		last = loc[1]
+	}
+	b.WriteString(url.QueryEscape(s[last:]))
+	return b.String()
+}
@@ -0,0 +1,26 @@
+package flow
+
+import (
+	"context"
+
+	"auto/model"
+
+	"go.uber.org/zap"
+)
+
+// replayRun replays the requests captured during run through proxy, in the
+// background so the run is not held up by it, and stores the outcome on
+// the run.
+func (m *Manager) replayRun(ctx context.Context, run *Run, proxy *model.ProxyConfig, capture *model.RequestCapture) {
+	reqs := capture.Requests()
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		result := proxy.Replay(ctx, reqs)
+		if result.Failed > 0 {
+			m.log(ctx).Warn("Failed to replay some run requests through proxy",
+				zap.Int("sent", result.Sent), zap.Int("failed", result.Failed))
+		}
+		run.ProxyReplay = result
+		m.saveRun(ctx, run)
+	}()
+}
@@ -0,0 +1,100 @@
+package flow
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"auto/events"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// A cached result is the Redis key "result_cache:<flowID>:<hash>" holding
+// the ID of the run whose results the next runs of the same flow revision
+// with the same params reuse, until it expires after the result cache TTL
+// of the flow.
+
+type skipCacheKey struct{}
+
+// WithoutResultCache returns a context under which the next flow run
+// started executes even when its flow has a cached result; it caches its
+// own results as usual.
+func WithoutResultCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipCacheKey{}, true)
+}
+
+// resultCacheKey returns the key caching the results of flow for params.
+// Editing the flow changes its revision and so its key.
+func resultCacheKey(flow Flow, params map[string]interface{}) (string, error) {
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	// Maps marshal with their keys sorted, so equal params hash alike.
+	data, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(fmt.Sprintf("%d:", flow.GetRevision())), data...))
+	return fmt.Sprintf("result_cache:%s:%s", flow.GetID(), hex.EncodeToString(sum[:])), nil
+}
+
+// cachedRun returns a run of flow with the results of the cached run for
+// params, stored and finished, or nil when the flow has no result cache,
+// nothing is cached or ctx asks for a fresh run.
+func (m *Manager) cachedRun(ctx context.Context, flow Flow, params map[string]interface{}) *Run {
+	if flow.GetResultCacheTTLSeconds() <= 0 {
+		return nil
+	}
+	if skip, _ := ctx.Value(skipCacheKey{}).(bool); skip {
+		return nil
+	}
+	key, err := resultCacheKey(flow, params)
+	if err != nil {
+		return nil
+	}
+	cachedID, err := m.db.Get(ctx, key).Result()
+	if err != nil {
+		if err != redis.Nil {
+			m.log(ctx).Warn("Failed to read result cache", zap.String("flowID", flow.GetID()), zap.Error(err))
+		}
+		return nil
+	}
+	cached, err := m.GetRun(cachedID)
+	if err != nil {
+		return nil
+	}
+
+	run := newRun(ctx, flow, cached.InstanceID)
+	run.Params = params
+	run.CachedFrom = cached.ID
+	for k, v := range cached.Results {
+		run.Results[k] = v
+	}
+	run.finish("", nil)
+	m.saveRun(ctx, run)
+	m.publish(events.RunSucceeded, run, run)
+	m.publish(events.RunOutput, run, run.Results)
+	m.log(ctx).Info("Flow result served from cache", zap.String("runID", run.ID), zap.String("cachedFrom", cached.ID))
+	return run
+}
+
+// cacheResult caches the results of a successful run for the result cache
+// TTL of its flow.
+func (m *Manager) cacheResult(ctx context.Context, flow Flow, run *Run) {
+	ttl := flow.GetResultCacheTTLSeconds()
+	if ttl <= 0 || run.Status != RunStatusSucceeded {
+		return
+	}
+	key, err := resultCacheKey(flow, run.Params)
+	if err != nil {
+		return
+	}
+	if err := m.db.Set(context.WithoutCancel(ctx), key, run.ID, time.Duration(ttl)*time.Second).Err(); err != nil {
+		m.log(ctx).Warn("Failed to cache flow result", zap.Error(err))
+	}
+}
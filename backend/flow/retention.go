@@ -0,0 +1,187 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// DefaultRetentionInterval is how often runs past their retention are
+// cleaned up.
+const DefaultRetentionInterval = time.Hour
+
+// Retention says how long what the runs of a flow leave behind is kept, in
+// seconds; zero falls back on the default of the server, set with
+// SetDefaultRetention, and zero there keeps it. Files also go when the
+// janitor policies of the server say so, so a flow can keep its files for
+// less time than those policies, not more.
+type Retention struct {
+	// RunsSeconds keeps run records, with their screenshots and artifacts.
+	RunsSeconds int `json:"runs_seconds,omitempty"`
+	// ScreenshotsSeconds keeps the step thumbnails and visual check
+	// screenshots of runs, and the screenshots of hooks.
+	ScreenshotsSeconds int `json:"screenshots_seconds,omitempty"`
+	// ArtifactsSeconds keeps the files output pipelines wrote for runs.
+	ArtifactsSeconds int `json:"artifacts_seconds,omitempty"`
+}
+
+// Validate rejects negative retention periods.
+func (r *Retention) Validate() error {
+	if r.RunsSeconds < 0 || r.ScreenshotsSeconds < 0 || r.ArtifactsSeconds < 0 {
+		return errors.New("retention periods must not be negative")
+	}
+	return nil
+}
+
+// SetDefaultRetention sets the retention of the flows that do not set
+// their own.
+func (m *Manager) SetDefaultRetention(r Retention) {
+	m.retention = r
+}
+
+// retentionOf returns the retention of flow, filled in from the default.
+func (m *Manager) retentionOf(flow Flow) Retention {
+	r := m.retention
+	if own := flow.GetRetention(); own != nil {
+		if own.RunsSeconds > 0 {
+			r.RunsSeconds = own.RunsSeconds
+		}
+		if own.ScreenshotsSeconds > 0 {
+			r.ScreenshotsSeconds = own.ScreenshotsSeconds
+		}
+		if own.ArtifactsSeconds > 0 {
+			r.ArtifactsSeconds = own.ArtifactsSeconds
+		}
+	}
+	return r
+}
+
+// StartRetention removes what runs left behind past the retention of
+// their flows every interval until ctx is done.
+func (m *Manager) StartRetention(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.ApplyRetention(ctx)
+			}
+		}
+	}()
+}
+
+// ApplyRetention removes, for every flow, the runs, screenshots and
+// artifacts older than its retention allows, and returns how many runs it
+// removed.
+func (m *Manager) ApplyRetention(ctx context.Context) int {
+	m.mu.RLock()
+	flows := make([]Flow, 0, len(m.flows))
+	for _, f := range m.flows {
+		flows = append(flows, f)
+	}
+	m.mu.RUnlock()
+
+	removed := 0
+	for _, f := range flows {
+		n, err := m.applyRetention(ctx, f, time.Now())
+		if err != nil {
+			m.logger.Error("Failed to apply run retention", zap.String("flowID", f.GetID()), zap.Error(err))
+		}
+		if n > 0 {
+			m.logger.Info("Removed runs past their retention", zap.String("flowID", f.GetID()), zap.Int("runs", n))
+		}
+		removed += n
+	}
+	return removed
+}
+
+func (m *Manager) applyRetention(ctx context.Context, flow Flow, now time.Time) (int, error) {
+	r := m.retentionOf(flow)
+	flowID := flow.GetID()
+	runsKey := fmt.Sprintf("runs:%s", flowID)
+
+	if r.ScreenshotsSeconds > 0 {
+		ids, err := m.runsBefore(ctx, runsKey, now, r.ScreenshotsSeconds)
+		if err != nil {
+			return 0, err
+		}
+		for _, id := range ids {
+			m.db.Del(ctx, fmt.Sprintf("run_timeline:%s", id), fmt.Sprintf("visual_run:%s", id))
+		}
+		m.removeHookScreenshots(flowID, now.Add(-time.Duration(r.ScreenshotsSeconds)*time.Second))
+	}
+	if r.ArtifactsSeconds > 0 && m.pipelines != nil {
+		ids, err := m.runsBefore(ctx, runsKey, now, r.ArtifactsSeconds)
+		if err != nil {
+			return 0, err
+		}
+		for _, id := range ids {
+			if err := m.pipelines.RemoveArtifacts(id); err != nil {
+				m.logger.Error("Failed to remove run artifacts", zap.String("runID", id), zap.Error(err))
+			}
+		}
+	}
+	if r.RunsSeconds <= 0 {
+		return 0, nil
+	}
+	ids, err := m.runsBefore(ctx, runsKey, now, r.RunsSeconds)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if m.pipelines != nil {
+			if err := m.pipelines.RemoveArtifacts(id); err != nil {
+				m.logger.Error("Failed to remove run artifacts", zap.String("runID", id), zap.Error(err))
+				continue
+			}
+		}
+		pipe := m.db.TxPipeline()
+		pipe.Del(ctx, fmt.Sprintf("run:%s", id), fmt.Sprintf("run_timeline:%s", id), fmt.Sprintf("visual_run:%s", id))
+		pipe.ZRem(ctx, runsKey, id)
+		pipe.ZRem(ctx, runIndexKey, id)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+// runsBefore returns the runs of the index runsKey started more than
+// seconds before now.
+func (m *Manager) runsBefore(ctx context.Context, runsKey string, now time.Time, seconds int) ([]string, error) {
+	cutoff := now.Add(-time.Duration(seconds) * time.Second)
+	ids, err := m.db.ZRangeByScore(ctx, runsKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: "(" + strconv.FormatInt(cutoff.UnixNano(), 10),
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return ids, err
+}
+
+// removeHookScreenshots removes the screenshots hooks of the flow saved in
+// DefaultScreenshotDir before cutoff. Those saved elsewhere are left to
+// whoever chose the directory.
+func (m *Manager) removeHookScreenshots(flowID string, cutoff time.Time) {
+	paths, _ := filepath.Glob(filepath.Join(DefaultScreenshotDir, flowID+"_*.png"))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			m.logger.Error("Failed to remove hook screenshot", zap.String("path", path), zap.Error(err))
+		}
+	}
+}
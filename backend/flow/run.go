@@ -0,0 +1,251 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"auto/analysis"
+	"auto/apperr"
+	"auto/model"
+	"auto/schema"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	RunStatusQueued    = "queued"
+	RunStatusRunning   = "running"
+	RunStatusSucceeded = "succeeded"
+	RunStatusFailed    = "failed"
+	// RunStatusDegraded is a run that completed but whose results do not
+	// match the output schema of its flow, often because the site changed.
+	RunStatusDegraded = "degraded"
+)
+
+// Run records a single execution of a flow.
+type Run struct {
+	ID         string `json:"id"`
+	FlowID     string `json:"flow_id"`
+	InstanceID string `json:"instance_id"`
+	Status     string `json:"status"`
+	// Params are the parameters the run was started with.
+	Params map[string]interface{} `json:"params,omitempty"`
+	// Labels are the labels the run was started with, see WithLabels.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Priority is the class the run queues in: model.PriorityInteractive
+	// or model.PriorityBatch.
+	Priority string `json:"priority,omitempty"`
+	// QueuePosition is the place of a queued run in the line for its
+	// instance, 1 being next.
+	QueuePosition int               `json:"queue_position,omitempty"`
+	StartedAt     time.Time         `json:"started_at"`
+	FinishedAt    time.Time         `json:"finished_at,omitempty"`
+	DurationMs    int64             `json:"duration_ms"`
+	FailedStep    string            `json:"failed_step,omitempty"`
+	Error         string            `json:"error,omitempty"`
+	Results       map[string]string `json:"results"`
+	OutputErrors  map[string]string `json:"output_errors,omitempty"`
+	// SchemaViolations says how the results of a degraded run differ from
+	// the output schema.
+	SchemaViolations []schema.Violation `json:"schema_violations,omitempty"`
+	// Attempt counts the times the run was started; Attempts are the ones
+	// before the current one, which the browser failed.
+	Attempt  int          `json:"attempt"`
+	Attempts []RunAttempt `json:"attempts,omitempty"`
+	// Steps record how the steps of the current attempt went, in order.
+	Steps []StepTiming `json:"steps,omitempty"`
+	// Visual are the outcomes of the visualCheck steps, by step ID.
+	Visual map[string]VisualCheck `json:"visual,omitempty"`
+	// ProxyReplay is the outcome of replaying the requests of the run
+	// through the proxy of its flow, once it is done.
+	ProxyReplay *model.ReplayResult `json:"proxy_replay,omitempty"`
+	// Findings are the issues passive analysis spotted in the traffic and
+	// pages of the steps run so far.
+	Findings *analysis.Report `json:"findings,omitempty"`
+	// AnonymousInstance says the run had an instance made for it, which
+	// is gone now.
+	AnonymousInstance bool `json:"anonymous_instance,omitempty"`
+	// CachedFrom is the run whose cached results the run returned, without
+	// running the browser.
+	CachedFrom string `json:"cached_from,omitempty"`
+	// Workspace owns the run, for its quotas: that of its instance unless
+	// it was started with WithWorkspace.
+	Workspace string `json:"workspace,omitempty"`
+}
+
+// maxRunAttempts is how many times a run is started at most.
+const maxRunAttempts = 2
+
+// RunAttempt is an attempt of a run that the browser failed.
+type RunAttempt struct {
+	Attempt    int       `json:"attempt"`
+	InstanceID string    `json:"instance_id"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	FailedStep string    `json:"failed_step"`
+	Error      string    `json:"error"`
+}
+
+type runIDKey struct{}
+
+// WithRunID returns a context under which the next flow run started gets
+// the ID id, so that a run can be looked up by an ID given out before it
+// starts.
+func WithRunID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, id)
+}
+
+type workspaceKey struct{}
+
+// WithWorkspace returns a context under which the next flow run started
+// belongs to workspace, whatever its instance does.
+func WithWorkspace(ctx context.Context, workspace string) context.Context {
+	return context.WithValue(ctx, workspaceKey{}, workspace)
+}
+
+func newRun(ctx context.Context, flow Flow, instanceID string) *Run {
+	id, _ := ctx.Value(runIDKey{}).(string)
+	if id == "" {
+		id = uuid.New().String()
+	}
+	labels, _ := ctx.Value(labelsKey{}).(map[string]string)
+	return &Run{
+		ID:         id,
+		FlowID:     flow.GetID(),
+		InstanceID: instanceID,
+		Labels:     labels,
+		Status:     RunStatusRunning,
+		StartedAt:  time.Now(),
+		Results:    make(map[string]string),
+		Attempt:    1,
+	}
+}
+
+// start marks a run that was queued for its instance as running; the
+// time spent queued is not part of its duration.
+func (r *Run) start() {
+	r.Status = RunStatusRunning
+	r.QueuePosition = 0
+	r.StartedAt = time.Now()
+}
+
+// retry records the current attempt as failed and starts the next one
+// with no results.
+func (r *Run) retry(failedStep string, err error) {
+	now := time.Now()
+	started := r.StartedAt
+	if len(r.Attempts) > 0 {
+		started = r.Attempts[len(r.Attempts)-1].FinishedAt
+	}
+	r.Attempts = append(r.Attempts, RunAttempt{
+		Attempt:    r.Attempt,
+		InstanceID: r.InstanceID,
+		StartedAt:  started,
+		FinishedAt: now,
+		FailedStep: failedStep,
+		Error:      err.Error(),
+	})
+	r.Attempt++
+	r.Steps = nil
+	r.Visual = nil
+	for id := range r.Results {
+		delete(r.Results, id)
+	}
+}
+
+func (r *Run) finish(failedStep string, err error) {
+	r.FinishedAt = time.Now()
+	r.DurationMs = r.FinishedAt.Sub(r.StartedAt).Milliseconds()
+	r.Status = RunStatusSucceeded
+	if err != nil {
+		r.Status = RunStatusFailed
+		r.FailedStep = failedStep
+		r.Error = err.Error()
+	}
+}
+
+// checkSchema validates the results of a completed run against s, as an
+// object with a property per step. Results that are JSON are checked as
+// the value they encode, others as strings.
+func (r *Run) checkSchema(s *schema.Schema) {
+	doc := make(map[string]interface{}, len(r.Results))
+	for id, result := range r.Results {
+		var v interface{}
+		if err := json.Unmarshal([]byte(result), &v); err != nil {
+			v = result
+		}
+		doc[id] = v
+	}
+	if violations := s.Validate(doc); len(violations) > 0 {
+		r.Status = RunStatusDegraded
+		r.SchemaViolations = violations
+	}
+}
+
+// RunStore keeps run records in Redis: each run under "run:<id>", in a
+// per-flow index "runs:<flow id>" and in "run_index", both scored by start
+// time.
+type RunStore struct {
+	db *redis.Client
+}
+
+func NewRunStore(db *redis.Client) *RunStore {
+	return &RunStore{db: db}
+}
+
+func (s *RunStore) SaveRun(ctx context.Context, run *Run) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	pipe := s.db.TxPipeline()
+	pipe.Set(ctx, fmt.Sprintf("run:%s", run.ID), data, 0)
+	z := &redis.Z{Score: float64(run.StartedAt.UnixNano()), Member: run.ID}
+	pipe.ZAdd(ctx, fmt.Sprintf("runs:%s", run.FlowID), z)
+	pipe.ZAdd(ctx, runIndexKey, z)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *RunStore) GetRun(ctx context.Context, id string) (*Run, error) {
+	data, err := s.db.Get(ctx, fmt.Sprintf("run:%s", id)).Bytes()
+	if err == redis.Nil {
+		return nil, apperr.New(apperr.CodeRunNotFound, "run not found")
+	} else if err != nil {
+		return nil, err
+	}
+	var run Run
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// ListRuns returns the runs of a flow started at or after since, newest
+// first. A limit of zero or less returns every run.
+func (s *RunStore) ListRuns(ctx context.Context, flowID string, since time.Time, limit int) ([]*Run, error) {
+	minScore := "-inf"
+	if !since.IsZero() {
+		minScore = fmt.Sprintf("%d", since.UnixNano())
+	}
+	opt := &redis.ZRangeBy{Min: minScore, Max: "+inf"}
+	if limit > 0 {
+		opt.Count = int64(limit)
+	}
+	ids, err := s.db.ZRevRangeByScore(ctx, fmt.Sprintf("runs:%s", flowID), opt).Result()
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]*Run, 0, len(ids))
+	for _, id := range ids {
+		run, err := s.GetRun(ctx, id)
+		if err != nil {
+			continue
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
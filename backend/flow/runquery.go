@@ -0,0 +1,154 @@
+package flow
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"time"
+
+	"auto/apperr"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// runIndexKey indexes every run, whatever its flow, by start time.
+const runIndexKey = "run_index"
+
+// runsPage is how many runs a query reads from Redis at a time.
+const runsPage = 100
+
+// RunQuery selects runs. Fields left empty do not filter.
+type RunQuery struct {
+	FlowID     string
+	InstanceID string
+	// Statuses are the statuses a run may have.
+	Statuses []string
+	// Labels are labels a run must all have, with their values.
+	Labels map[string]string
+	// From and To bound the time the runs started at, To excluded.
+	From time.Time
+	To   time.Time
+	// Oldest lists the oldest runs first rather than the newest.
+	Oldest bool
+	// Cursor is the NextCursor of the page before.
+	Cursor string
+	// Limit is the most runs a page holds; zero or less puts every run in
+	// one page.
+	Limit int
+}
+
+// RunPage is a page of the runs a RunQuery selects.
+type RunPage struct {
+	Runs []*Run `json:"runs"`
+	// NextCursor continues the listing; it is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// QueryRuns returns the runs of every flow that q selects.
+func (m *Manager) QueryRuns(q RunQuery) (*RunPage, error) {
+	return m.runs.QueryRuns(context.Background(), q)
+}
+
+// QueryRuns returns a page of the runs q selects, by start time. The pages
+// are cut by start time, so runs started while listing show up in later
+// pages when they sort after the cursor.
+func (s *RunStore) QueryRuns(ctx context.Context, q RunQuery) (*RunPage, error) {
+	key := runIndexKey
+	if q.FlowID != "" {
+		key = fmt.Sprintf("runs:%s", q.FlowID)
+	}
+	min, max := "-inf", "+inf"
+	if !q.From.IsZero() {
+		min = strconv.FormatInt(q.From.UnixNano(), 10)
+	}
+	if !q.To.IsZero() {
+		max = "(" + strconv.FormatInt(q.To.UnixNano(), 10)
+	}
+	if q.Cursor != "" {
+		after, err := decodeRunCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if q.Oldest {
+			min = "(" + after
+		} else {
+			max = "(" + after
+		}
+	}
+
+	page := &RunPage{Runs: []*Run{}}
+	for {
+		opt := &redis.ZRangeBy{Min: min, Max: max, Count: runsPage}
+		var batch []redis.Z
+		var err error
+		if q.Oldest {
+			batch, err = s.db.ZRangeByScoreWithScores(ctx, key, opt).Result()
+		} else {
+			batch, err = s.db.ZRevRangeByScoreWithScores(ctx, key, opt).Result()
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, z := range batch {
+			id, _ := z.Member.(string)
+			run, err := s.GetRun(ctx, id)
+			if err != nil || !q.matches(run) {
+				continue
+			}
+			page.Runs = append(page.Runs, run)
+			if q.Limit > 0 && len(page.Runs) == q.Limit {
+				page.NextCursor = encodeRunCursor(z.Score)
+				return page, nil
+			}
+		}
+		if len(batch) < runsPage {
+			return page, nil
+		}
+		// The next batch starts past the last run of this one.
+		last := "(" + strconv.FormatFloat(batch[len(batch)-1].Score, 'f', -1, 64)
+		if q.Oldest {
+			min = last
+		} else {
+			max = last
+		}
+	}
+}
+
+func (q *RunQuery) matches(run *Run) bool {
+	if q.InstanceID != "" && run.InstanceID != q.InstanceID {
+		return false
+	}
+	if len(q.Statuses) > 0 {
+		found := false
+		for _, status := range q.Statuses {
+			if run.Status == status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return run.hasLabels(q.Labels)
+}
+
+var errInvalidCursor = apperr.New(apperr.CodeInvalidRequest, "invalid cursor")
+
+// A cursor is the start time score of the last run of a page, opaque to
+// clients.
+func encodeRunCursor(score float64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatFloat(score, 'f', -1, 64)))
+}
+
+func decodeRunCursor(cursor string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", errInvalidCursor
+	}
+	if _, err := strconv.ParseFloat(string(data), 64); err != nil {
+		return "", errInvalidCursor
+	}
+	return string(data), nil
+}
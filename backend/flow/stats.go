@@ -0,0 +1,130 @@
+package flow
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FlowStats summarises the runs of a flow over a time window.
+type FlowStats struct {
+	FlowID          string         `json:"flow_id"`
+	Since           time.Time      `json:"since"`
+	Runs            int            `json:"runs"`
+	Succeeded       int            `json:"succeeded"`
+	Degraded        int            `json:"degraded"`
+	Failed          int            `json:"failed"`
+	SuccessRate     float64        `json:"success_rate"`
+	AvgDurationMs   int64          `json:"avg_duration_ms"`
+	P50DurationMs   int64          `json:"p50_duration_ms"`
+	P95DurationMs   int64          `json:"p95_duration_ms"`
+	MostFailingStep string         `json:"most_failing_step,omitempty"`
+	StepFailures    map[string]int `json:"step_failures"`
+	FailureCategory map[string]int `json:"failure_categories"`
+}
+
+// failureCategories classifies run errors by the first matching marker.
+var failureCategories = []struct {
+	name    string
+	markers []string
+}{
+	{"timeout", []string{"context deadline exceeded", "timed out", "timeout"}},
+	{"instance", []string{"instance is not running", "instance not found", "failed to get instance"}},
+	{"selector", []string{"no candidate selector matched", "could not find node", "missing selector"}},
+	{"navigation", []string{"net::err_", "page load error", "navigate"}},
+	{"hook", []string{"hook"}},
+	{"script", []string{"script failed", "javascript", "exception"}},
+	{"parameter", []string{"missing", "parameter", "unknown action"}},
+}
+
+// categorizeFailure maps a run error to a coarse failure category.
+func categorizeFailure(msg string) string {
+	msg = strings.ToLower(msg)
+	for _, c := range failureCategories {
+		for _, m := range c.markers {
+			if strings.Contains(msg, m) {
+				return c.name
+			}
+		}
+	}
+	return "other"
+}
+
+// GetStats computes the run statistics of a flow for runs started within
+// window of now. A flow that does not exist is an error with
+// apperr.CodeFlowNotFound.
+func (m *Manager) GetStats(flowID string, window time.Duration) (*FlowStats, error) {
+	if _, err := m.GetFlow(flowID); err != nil {
+		return nil, err
+	}
+	since := time.Now().Add(-window)
+	runs, err := m.runs.ListRuns(context.Background(), flowID, since, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &FlowStats{
+		FlowID:          flowID,
+		Since:           since,
+		StepFailures:    make(map[string]int),
+		FailureCategory: make(map[string]int),
+	}
+	var durations []int64
+	for _, run := range runs {
+		switch run.Status {
+		case RunStatusSucceeded:
+			stats.Succeeded++
+		case RunStatusDegraded:
+			// The run completed; its data is what went wrong.
+			stats.Succeeded++
+			stats.Degraded++
+		case RunStatusFailed:
+			stats.Failed++
+			if run.FailedStep != "" {
+				stats.StepFailures[run.FailedStep]++
+			}
+			stats.FailureCategory[categorizeFailure(run.Error)]++
+		default:
+			continue
+		}
+		stats.Runs++
+		durations = append(durations, run.DurationMs)
+	}
+
+	if stats.Runs > 0 {
+		stats.SuccessRate = float64(stats.Succeeded) / float64(stats.Runs)
+		var total int64
+		for _, d := range durations {
+			total += d
+		}
+		stats.AvgDurationMs = total / int64(stats.Runs)
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	stats.P50DurationMs = percentile(durations, 0.50)
+	stats.P95DurationMs = percentile(durations, 0.95)
+
+	most := 0
+	for step, n := range stats.StepFailures {
+		if n > most || (n == most && step < stats.MostFailingStep) {
+			most, stats.MostFailingStep = n, step
+		}
+	}
+	return stats, nil
+}
+
+// percentile returns the nearest-rank percentile of sorted values.
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
@@ -0,0 +1,116 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"auto/model"
+
+	"go.uber.org/zap"
+)
+
+// Thumbnails of the steps of a run are kept in the Redis hash
+// "run_timeline:<run id>", by step index, for timelineTTL.
+const (
+	timelineTTL      = 7 * 24 * time.Hour
+	thumbnailWidth   = 320
+	thumbnailQuality = 60
+)
+
+// Statuses of a step in the timeline of a run.
+const (
+	StepSucceeded = "succeeded"
+	StepFailed    = "failed"
+	StepCancelled = "cancelled"
+)
+
+// StepTiming records how a step of a run went.
+type StepTiming struct {
+	ID         string    `json:"id"`
+	Action     string    `json:"action"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	// Screenshot says a thumbnail was taken after the step.
+	Screenshot bool `json:"screenshot,omitempty"`
+}
+
+// Timeline is the storyboard of a run: its steps in order, with their
+// durations and, for flows with step screenshots, a thumbnail of the page
+// after each one as a base64 encoded JPEG.
+type Timeline struct {
+	RunID  string         `json:"run_id"`
+	FlowID string         `json:"flow_id"`
+	Status string         `json:"status"`
+	Steps  []TimelineStep `json:"steps"`
+}
+
+type TimelineStep struct {
+	StepTiming
+	Index     int    `json:"index"`
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+// recordStep adds how step went to the run, taking a thumbnail of the page
+// when the flow asks for step screenshots. A thumbnail that cannot be
+// taken is left out.
+func (m *Manager) recordStep(ctx context.Context, flow Flow, run *Run, step Step, instance *model.Instance, started time.Time, err error) {
+	timing := StepTiming{
+		ID:         step.ID,
+		Action:     step.Action,
+		StartedAt:  started,
+		DurationMs: time.Since(started).Milliseconds(),
+		Status:     StepSucceeded,
+	}
+	switch {
+	case err == errRunCancelled:
+		timing.Status = StepCancelled
+	case err != nil:
+		timing.Status = StepFailed
+		timing.Error = err.Error()
+	}
+	index := len(run.Steps)
+	if flow.GetStepScreenshots() && err != errRunCancelled {
+		if data, shotErr := instance.ExecuteContext(ctx, "screenshot", map[string]interface{}{
+			"width":   float64(thumbnailWidth),
+			"quality": float64(thumbnailQuality),
+		}); shotErr != nil {
+			m.logger.Warn("Failed to take step screenshot", zap.String("runID", run.ID), zap.String("stepID", step.ID), zap.Error(shotErr))
+		} else {
+			key := fmt.Sprintf("run_timeline:%s", run.ID)
+			pipe := m.db.TxPipeline()
+			pipe.HSet(context.Background(), key, strconv.Itoa(index), data)
+			pipe.Expire(context.Background(), key, timelineTTL)
+			if _, err := pipe.Exec(context.Background()); err != nil {
+				m.logger.Warn("Failed to save step screenshot", zap.String("runID", run.ID), zap.String("stepID", step.ID), zap.Error(err))
+			} else {
+				timing.Screenshot = true
+			}
+		}
+	}
+	run.Steps = append(run.Steps, timing)
+}
+
+// GetTimeline returns the steps of a run in order, with their thumbnails.
+func (m *Manager) GetTimeline(runID string) (*Timeline, error) {
+	run, err := m.GetRun(runID)
+	if err != nil {
+		return nil, err
+	}
+	thumbnails, err := m.db.HGetAll(context.Background(), fmt.Sprintf("run_timeline:%s", runID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	t := &Timeline{RunID: run.ID, FlowID: run.FlowID, Status: run.Status, Steps: make([]TimelineStep, 0, len(run.Steps))}
+	for i, timing := range run.Steps {
+		step := TimelineStep{StepTiming: timing, Index: i}
+		if timing.Screenshot {
+			step.Thumbnail = thumbnails[strconv.Itoa(i)]
+		}
+		t.Steps = append(t.Steps, step)
+	}
+	return t, nil
+}
@@ -0,0 +1,32 @@
+package flow
+
+import (
+	"context"
+	"time"
+
+	"auto/quota"
+
+	"go.uber.org/zap"
+)
+
+// SetQuotaTracker has the runs counted against the quotas of their
+// workspace in t: the runs going at once and their browser minutes. Call
+// it before running flows.
+func (m *Manager) SetQuotaTracker(t *quota.Tracker) {
+	m.quotas = t
+}
+
+// trackUsage counts run among the runs of its workspace going at once.
+func (m *Manager) trackUsage(ctx context.Context, run *Run) {
+	if err := m.quotas.RunStarted(context.WithoutCancel(ctx), run.Workspace, run.ID); err != nil {
+		m.log(ctx).Warn("Failed to record run usage", zap.String("workspace", run.Workspace), zap.Error(err))
+	}
+}
+
+// untrackUsage records that run is over, adding the time it ran to the
+// browser minutes of its workspace.
+func (m *Manager) untrackUsage(ctx context.Context, run *Run) {
+	if err := m.quotas.RunFinished(context.WithoutCancel(ctx), run.Workspace, run.ID, time.Since(run.StartedAt)); err != nil {
+		m.log(ctx).Warn("Failed to record run usage", zap.String("workspace", run.Workspace), zap.Error(err))
+	}
+}
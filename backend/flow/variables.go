@@ -0,0 +1,230 @@
+package flow
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redacted = "********"
+
+// RunVariables is what the steps of a run can see: the parameters it was
+// started with and the results of the steps so far, keyed by step ID (the
+// template step writes "templateResult"). Live is set while the run is
+// executing on this server, with the step it is at and whether a debugger
+// holds it there; otherwise the variables are those recorded when it
+// ended. Loops such as paginate keep their state inside their step, so
+// only their result shows up here.
+//
+// Values that look like credentials are redacted: parameters and result
+// fields with secret-sounding names, and anywhere the secret step
+// parameters of the flow appear.
+type RunVariables struct {
+	RunID       string                 `json:"run_id"`
+	FlowID      string                 `json:"flow_id"`
+	Status      string                 `json:"status"`
+	Live        bool                   `json:"live"`
+	CurrentStep string                 `json:"current_step,omitempty"`
+	StepIndex   int                    `json:"step_index,omitempty"`
+	Paused      bool                   `json:"paused,omitempty"`
+	FailedStep  string                 `json:"failed_step,omitempty"`
+	Params      map[string]interface{} `json:"params"`
+	Results     map[string]interface{} `json:"results"`
+}
+
+// liveRun is the state of a run executing on this server. The run goroutine
+// owns the results map, so readers get a copy taken after each step.
+type liveRun struct {
+	mu      sync.Mutex
+	run     Run
+	step    string
+	index   int
+	paused  bool
+	results map[string]string
+}
+
+func (l *liveRun) at(index int, stepID string) {
+	l.mu.Lock()
+	l.index, l.step = index, stepID
+	l.mu.Unlock()
+}
+
+func (l *liveRun) setPaused(paused bool) {
+	l.mu.Lock()
+	l.paused = paused
+	l.mu.Unlock()
+}
+
+func (l *liveRun) snapshot(results map[string]string) {
+	copied := make(map[string]string, len(results))
+	for k, v := range results {
+		copied[k] = v
+	}
+	l.mu.Lock()
+	l.results = copied
+	l.mu.Unlock()
+}
+
+// trackRun makes the variables of run available while it executes.
+func (m *Manager) trackRun(run *Run) *liveRun {
+	l := &liveRun{run: *run, results: map[string]string{}}
+	m.liveMu.Lock()
+	m.live[run.ID] = l
+	m.liveMu.Unlock()
+	return l
+}
+
+func (m *Manager) untrackRun(id string) {
+	m.liveMu.Lock()
+	delete(m.live, id)
+	m.liveMu.Unlock()
+}
+
+// LiveRun is a run executing on this server.
+type LiveRun struct {
+	RunID       string    `json:"run_id"`
+	FlowID      string    `json:"flow_id"`
+	InstanceID  string    `json:"instance_id"`
+	StartedAt   time.Time `json:"started_at"`
+	CurrentStep string    `json:"current_step,omitempty"`
+	StepIndex   int       `json:"step_index"`
+	Paused      bool      `json:"paused,omitempty"`
+}
+
+// LiveRuns returns the runs executing on this server, oldest first.
+func (m *Manager) LiveRuns() []LiveRun {
+	m.liveMu.Lock()
+	runs := make([]LiveRun, 0, len(m.live))
+	for _, l := range m.live {
+		l.mu.Lock()
+		runs = append(runs, LiveRun{
+			RunID:       l.run.ID,
+			FlowID:      l.run.FlowID,
+			InstanceID:  l.run.InstanceID,
+			StartedAt:   l.run.StartedAt,
+			CurrentStep: l.step,
+			StepIndex:   l.index,
+			Paused:      l.paused,
+		})
+		l.mu.Unlock()
+	}
+	m.liveMu.Unlock()
+	sort.Slice(runs, func(i, j int) bool { return runs[i].StartedAt.Before(runs[j].StartedAt) })
+	return runs
+}
+
+// GetRunVariables returns the variables of a run, live while it executes
+// on this server and as recorded once it has ended.
+func (m *Manager) GetRunVariables(runID string) (*RunVariables, error) {
+	m.liveMu.Lock()
+	l, live := m.live[runID]
+	m.liveMu.Unlock()
+
+	var v *RunVariables
+	if live {
+		l.mu.Lock()
+		v = &RunVariables{
+			RunID:       l.run.ID,
+			FlowID:      l.run.FlowID,
+			Status:      RunStatusRunning,
+			Live:        true,
+			CurrentStep: l.step,
+			StepIndex:   l.index,
+			Paused:      l.paused,
+			Params:      l.run.Params,
+			Results:     decodeResults(l.results),
+		}
+		l.mu.Unlock()
+	} else {
+		run, err := m.GetRun(runID)
+		if err != nil {
+			return nil, err
+		}
+		v = &RunVariables{
+			RunID:      run.ID,
+			FlowID:     run.FlowID,
+			Status:     run.Status,
+			FailedStep: run.FailedStep,
+			Params:     run.Params,
+			Results:    decodeResults(run.Results),
+		}
+	}
+
+	var secrets []string
+	if f, err := m.GetFlow(v.FlowID); err == nil {
+		secrets = secretValues(f)
+	}
+	v.Params, _ = redactValue(v.Params, secrets).(map[string]interface{})
+	v.Results, _ = redactValue(v.Results, secrets).(map[string]interface{})
+	if v.Params == nil {
+		v.Params = map[string]interface{}{}
+	}
+	return v, nil
+}
+
+// decodeResults turns step results that are JSON into the values they
+// encode, so they can be browsed and redacted field by field.
+func decodeResults(results map[string]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(results))
+	for id, result := range results {
+		var v interface{}
+		if err := json.Unmarshal([]byte(result), &v); err != nil {
+			v = result
+		}
+		out[id] = v
+	}
+	return out
+}
+
+// secretValues collects the secret step parameters of f, as ExportFlow
+// finds them.
+func secretValues(f Flow) []string {
+	var values []string
+	collect := func(steps []Step) {
+		for _, step := range steps {
+			for key, value := range step.Params {
+				if s, ok := value.(string); ok && s != "" && isSecretParam(step, key) {
+					values = append(values, s)
+				}
+			}
+		}
+	}
+	collect(f.GetSteps())
+	hooks := f.GetHooks()
+	collect(hooks.BeforeEach)
+	collect(hooks.AfterEach)
+	collect(hooks.OnFailure)
+	return values
+}
+
+// redactValue returns a copy of v with the values of secret-named fields
+// and every occurrence of secrets replaced.
+func redactValue(v interface{}, secrets []string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			if secretParamName.MatchString(k) {
+				out[k] = redacted
+				continue
+			}
+			out[k] = redactValue(item, secrets)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = redactValue(item, secrets)
+		}
+		return out
+	case string:
+		for _, s := range secrets {
+			v = strings.ReplaceAll(v, s, redacted)
+		}
+		return v
+	default:
+		return v
+	}
+}
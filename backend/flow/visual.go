@@ -0,0 +1,258 @@
+package flow
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"auto/apperr"
+	"auto/model"
+	"auto/visual"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// The approved screenshots of a flow are kept in the Redis hash
+// "visual_baseline:<flow id>", by step ID. The screenshots a run takes, and
+// their diffs under "<step id>:diff", are kept in "visual_run:<run id>" for
+// visualTTL so they can be approved later.
+const visualTTL = 7 * 24 * time.Hour
+
+// Outcomes of a visualCheck step.
+const (
+	// VisualNew means the step has no baseline yet; approve the screenshot
+	// of the run to make it one.
+	VisualNew     = "new"
+	VisualPassed  = "passed"
+	VisualChanged = "changed"
+)
+
+// Baseline is the approved screenshot of a visualCheck step.
+type Baseline struct {
+	StepID     string    `json:"step_id"`
+	RunID      string    `json:"run_id"`
+	ApprovedAt time.Time `json:"approved_at"`
+	Image      []byte    `json:"image,omitempty"`
+}
+
+// VisualCheck is how the screenshot of a visualCheck step compared with
+// its baseline.
+type VisualCheck struct {
+	Status        string `json:"status"`
+	BaselineRunID string `json:"baseline_run_id,omitempty"`
+	visual.Result
+}
+
+// RunVisual are the visual checks of a run with their screenshots and, for
+// changed ones, their diffs, as base64 encoded PNGs.
+type RunVisual struct {
+	RunID  string                  `json:"run_id"`
+	FlowID string                  `json:"flow_id"`
+	Checks map[string]VisualReport `json:"checks"`
+}
+
+type VisualReport struct {
+	VisualCheck
+	Screenshot string `json:"screenshot,omitempty"`
+	Diff       string `json:"diff,omitempty"`
+}
+
+// visualParams are the parameters of a visualCheck step.
+type visualParams struct {
+	FullPage  bool            `json:"fullPage"`
+	Ignore    []visual.Region `json:"ignore"`
+	Tolerance int             `json:"tolerance"`
+	// Threshold is the share of pixels, between 0 and 1, that may differ
+	// before the step counts as changed.
+	Threshold float64 `json:"threshold"`
+	// FailOnChange fails the step, and so the run, when it changed;
+	// otherwise the change is only reported.
+	FailOnChange bool `json:"failOnChange"`
+}
+
+// visualCheck screenshots the page and compares it with the baseline of
+// the step. The result of the step is the VisualCheck as JSON.
+func (m *Manager) visualCheck(ctx context.Context, run *Run, step Step, instance *model.Instance) (string, error) {
+	var p visualParams
+	if raw, err := json.Marshal(step.Params); err == nil {
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return "", fmt.Errorf("invalid visualCheck parameters: %w", err)
+		}
+	}
+
+	data, err := instance.ExecuteContext(ctx, "screenshot", map[string]interface{}{"fullPage": p.FullPage})
+	if err != nil {
+		return "", err
+	}
+	shot, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", err
+	}
+
+	saved := context.WithoutCancel(ctx)
+	runKey := fmt.Sprintf("visual_run:%s", run.ID)
+	pipe := m.db.TxPipeline()
+	pipe.HSet(saved, runKey, step.ID, shot)
+	pipe.Expire(saved, runKey, visualTTL)
+	if _, err := pipe.Exec(saved); err != nil {
+		return "", fmt.Errorf("failed to save screenshot: %w", err)
+	}
+
+	check := VisualCheck{Status: VisualNew}
+	baseline, err := m.loadBaseline(run.FlowID, step.ID)
+	switch {
+	case err != nil:
+		return "", err
+	case baseline != nil:
+		result, err := visual.Compare(baseline.Image, shot, visual.Options{Ignore: p.Ignore, Tolerance: p.Tolerance})
+		if err != nil {
+			return "", fmt.Errorf("failed to compare with baseline: %w", err)
+		}
+		check.BaselineRunID = baseline.RunID
+		check.Result = *result
+		check.Status = VisualPassed
+		if result.DiffPixels > 0 && result.Ratio > p.Threshold {
+			check.Status = VisualChanged
+		}
+		if result.Image != nil {
+			if err := m.db.HSet(saved, runKey, step.ID+":diff", result.Image).Err(); err != nil {
+				m.logger.Warn("Failed to save visual diff", zap.String("runID", run.ID), zap.String("stepID", step.ID), zap.Error(err))
+			}
+		}
+	}
+
+	if run.Visual == nil {
+		run.Visual = make(map[string]VisualCheck)
+	}
+	run.Visual[step.ID] = check
+	out, err := json.Marshal(check)
+	if err != nil {
+		return "", err
+	}
+	if check.Status == VisualChanged && p.FailOnChange {
+		return string(out), fmt.Errorf("%.2f%% of the page differs from the baseline", check.Ratio*100)
+	}
+	return string(out), nil
+}
+
+// ApproveBaseline makes the screenshot the visualCheck step stepID took in
+// run runID the baseline later runs of the flow are compared with.
+func (m *Manager) ApproveBaseline(flowID, stepID, runID string) (*Baseline, error) {
+	if _, err := m.GetFlow(flowID); err != nil {
+		return nil, err
+	}
+	run, err := m.GetRun(runID)
+	if err != nil {
+		return nil, err
+	}
+	if run.FlowID != flowID {
+		return nil, apperr.New(apperr.CodeRunNotFound, fmt.Sprintf("run %s is not a run of flow %s", runID, flowID))
+	}
+	shot, err := m.db.HGet(context.Background(), fmt.Sprintf("visual_run:%s", runID), stepID).Bytes()
+	if err == redis.Nil {
+		return nil, apperr.New(apperr.CodeNotFound, fmt.Sprintf("run %s has no screenshot for step %s", runID, stepID))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	baseline := &Baseline{StepID: stepID, RunID: runID, ApprovedAt: time.Now(), Image: shot}
+	data, err := json.Marshal(baseline)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.db.HSet(context.Background(), fmt.Sprintf("visual_baseline:%s", flowID), stepID, data).Err(); err != nil {
+		return nil, err
+	}
+	m.logger.Info("Approved visual baseline", zap.String("flowID", flowID), zap.String("stepID", stepID), zap.String("runID", runID))
+	return baseline, nil
+}
+
+// GetBaseline returns the baseline of a step, with its image.
+func (m *Manager) GetBaseline(flowID, stepID string) (*Baseline, error) {
+	baseline, err := m.loadBaseline(flowID, stepID)
+	if err == nil && baseline == nil {
+		return nil, apperr.New(apperr.CodeNotFound, fmt.Sprintf("step %s has no baseline", stepID))
+	}
+	return baseline, err
+}
+
+// loadBaseline returns the baseline of a step, or nil if it has none.
+func (m *Manager) loadBaseline(flowID, stepID string) (*Baseline, error) {
+	data, err := m.db.HGet(context.Background(), fmt.Sprintf("visual_baseline:%s", flowID), stepID).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var baseline Baseline
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, err
+	}
+	return &baseline, nil
+}
+
+// ListBaselines returns the baselines of a flow by step ID, without their
+// images.
+func (m *Manager) ListBaselines(flowID string) ([]Baseline, error) {
+	if _, err := m.GetFlow(flowID); err != nil {
+		return nil, err
+	}
+	all, err := m.db.HGetAll(context.Background(), fmt.Sprintf("visual_baseline:%s", flowID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	baselines := make([]Baseline, 0, len(all))
+	for _, data := range all {
+		var baseline Baseline
+		if err := json.Unmarshal([]byte(data), &baseline); err != nil {
+			continue
+		}
+		baseline.Image = nil
+		baselines = append(baselines, baseline)
+	}
+	sort.Slice(baselines, func(a, b int) bool { return baselines[a].StepID < baselines[b].StepID })
+	return baselines, nil
+}
+
+// DeleteBaseline removes the baseline of a step; its next run starts over
+// as new.
+func (m *Manager) DeleteBaseline(flowID, stepID string) error {
+	n, err := m.db.HDel(context.Background(), fmt.Sprintf("visual_baseline:%s", flowID), stepID).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return apperr.New(apperr.CodeNotFound, fmt.Sprintf("step %s has no baseline", stepID))
+	}
+	return nil
+}
+
+// GetRunVisual returns the visual checks of a run with their screenshots.
+func (m *Manager) GetRunVisual(runID string) (*RunVisual, error) {
+	run, err := m.GetRun(runID)
+	if err != nil {
+		return nil, err
+	}
+	images, err := m.db.HGetAll(context.Background(), fmt.Sprintf("visual_run:%s", runID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	v := &RunVisual{RunID: run.ID, FlowID: run.FlowID, Checks: make(map[string]VisualReport, len(run.Visual))}
+	for stepID, check := range run.Visual {
+		report := VisualReport{VisualCheck: check}
+		if shot, ok := images[stepID]; ok {
+			report.Screenshot = base64.StdEncoding.EncodeToString([]byte(shot))
+		}
+		if diff, ok := images[stepID+":diff"]; ok {
+			report.Diff = base64.StdEncoding.EncodeToString([]byte(diff))
+		}
+		v.Checks[stepID] = report
+	}
+	return v, nil
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	httppprof "net/http/pprof"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"auto/apperr"
+	"auto/backup"
+	"auto/model"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Admin Handlers
+func (h *Handler) GetJanitorStatsHandler(c *gin.Context) {
+	type policy struct {
+		Name    string `json:"name"`
+		Dir     string `json:"dir"`
+		Pattern string `json:"pattern,omitempty"`
+		MaxAge  string `json:"max_age"`
+		MaxSize int64  `json:"max_size"`
+	}
+	var policies []policy
+	for _, p := range h.janitor.Policies() {
+		policies = append(policies, policy{Name: p.Name, Dir: p.Dir, Pattern: p.Pattern, MaxAge: p.MaxAge.String(), MaxSize: p.MaxSize})
+	}
+	c.JSON(http.StatusOK, gin.H{"policies": policies, "stats": h.janitor.Stats()})
+}
+
+// PurgeHandler runs the janitor right away. The body may name the policies
+// to apply and a max_age such as "1h" overriding their age limit.
+func (h *Handler) PurgeHandler(c *gin.Context) {
+	var req struct {
+		Policies []string `json:"policies"`
+		MaxAge   string   `json:"max_age"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := bindJSON(c, &req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+	var maxAge time.Duration
+	if req.MaxAge != "" {
+		d, err := time.ParseDuration(req.MaxAge)
+		if err != nil || d <= 0 {
+			respondError(c, http.StatusBadRequest, errors.New("invalid max_age"))
+			return
+		}
+		maxAge = d
+	}
+
+	results := h.janitor.Purge(req.Policies, maxAge)
+	var removed int
+	var reclaimed int64
+	for _, r := range results {
+		removed += r.Removed
+		reclaimed += r.Bytes
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results, "removed": removed, "bytes_reclaimed": reclaimed})
+}
+
+// BackupHandler streams a snapshot of the Redis state as a .tar.gz download.
+func (h *Handler) BackupHandler(c *gin.Context) {
+	var buf bytes.Buffer
+	manifest, err := backup.Create(c.Request.Context(), h.dbManager.Client, &buf)
+	if err != nil {
+		h.log(c).Error("Failed to create backup", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	filename := fmt.Sprintf("umba-backup-%s.tar.gz", manifest.CreatedAt.Format("20060102-150405"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, "application/gzip", buf.Bytes())
+}
+
+// RestoreHandler loads a snapshot uploaded as the request body or as the
+// "backup" form file. With ?dry_run=true it is only validated; ?sections=
+// restores a comma-separated subset.
+func (h *Handler) RestoreHandler(c *gin.Context) {
+	body := c.Request.Body
+	if file, err := c.FormFile("backup"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+	snap, err := backup.Read(body)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if c.Query("dry_run") == "true" {
+		c.JSON(http.StatusOK, gin.H{"valid": true, "manifest": snap.Manifest})
+		return
+	}
+
+	var sections []string
+	if s := c.Query("sections"); s != "" {
+		sections = strings.Split(s, ",")
+	}
+	restored, err := backup.Restore(c.Request.Context(), h.dbManager.Client, snap, sections)
+	if err != nil {
+		h.log(c).Error("Failed to restore backup", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Bring the in-memory state in line with what was restored.
+	if err := h.flowManager.Reload(); err != nil {
+		h.log(c).Error("Failed to reload flows", zap.Error(err))
+	}
+	if _, err := h.instanceManager.LoadInstances(); err != nil {
+		h.log(c).Error("Failed to load instances", zap.Error(err))
+	}
+	if err := h.scheduler.Reload(); err != nil {
+		h.log(c).Error("Failed to reload schedules", zap.Error(err))
+	}
+	h.log(c).Info("Restored backup", zap.Time("createdAt", snap.Manifest.CreatedAt), zap.Any("sections", restored))
+	c.JSON(http.StatusOK, gin.H{"restored": restored, "manifest": snap.Manifest})
+}
+
+// AdminAuth are the basic auth credentials the profiling endpoints require.
+// Profiling is disabled without them.
+type AdminAuth struct {
+	Username string
+	Password string
+}
+
+// ProfileHandler serves a runtime profile in the format of net/http/pprof:
+// "goroutine", "heap", "allocs", "block", "mutex" or "threadcreate", "profile"
+// for a CPU profile of ?seconds= and "trace" for an execution trace.
+func (h *Handler) ProfileHandler(c *gin.Context) {
+	if h.adminAuth.Username == "" || h.adminAuth.Password == "" {
+		respondError(c, http.StatusNotFound, apperr.New(apperr.CodeNotFound, "profiling is disabled: set AUTH_USERNAME and AUTH_PASSWORD"))
+		return
+	}
+	user, password, ok := c.Request.BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(h.adminAuth.Username)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(password), []byte(h.adminAuth.Password)) != 1 {
+		c.Header("WWW-Authenticate", `Basic realm="umba admin"`)
+		respondError(c, http.StatusUnauthorized, apperr.New(apperr.CodeUnauthorized, "valid admin credentials are required"))
+		return
+	}
+
+	switch name := c.Param("profile"); name {
+	case "profile":
+		httppprof.Profile(c.Writer, c.Request)
+	case "trace":
+		httppprof.Trace(c.Writer, c.Request)
+	default:
+		if pprof.Lookup(name) == nil {
+			respondError(c, http.StatusNotFound, apperr.New(apperr.CodeNotFound, fmt.Sprintf("unknown profile: %s", name)))
+			return
+		}
+		httppprof.Handler(name).ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// GetBrowserContextsHandler lists the browsers open on this server with
+// their ages.
+func (h *Handler) GetBrowserContextsHandler(c *gin.Context) {
+	contexts := h.instanceManager.BrowserContexts()
+	c.JSON(http.StatusOK, gin.H{"contexts": contexts, "count": len(contexts)})
+}
+
+// GetRedisKeysHandler counts the Redis keys of each namespace.
+func (h *Handler) GetRedisKeysHandler(c *gin.Context) {
+	counts, err := h.dbManager.KeyCounts(c.Request.Context())
+	if err != nil {
+		h.log(c).Error("Failed to count Redis keys", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	var total int64
+	for _, n := range counts {
+		total += n
+	}
+	c.JSON(http.StatusOK, gin.H{"namespaces": counts, "total": total})
+}
+
+// selfTestTimeout bounds the browser self-test of the API.
+const selfTestTimeout = 30 * time.Second
+
+// SelfTestHandler launches a disposable Chrome and reports its version,
+// or why it does not work on this host.
+func (h *Handler) SelfTestHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), selfTestTimeout)
+	defer cancel()
+	result, err := model.SelfTest(ctx)
+	if err != nil {
+		h.log(c).Error("Browser self-test failed", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// GetLiveRunsHandler lists the runs executing on this server.
+func (h *Handler) GetLiveRunsHandler(c *gin.Context) {
+	runs := h.flowManager.LiveRuns()
+	c.JSON(http.StatusOK, gin.H{"runs": runs, "count": len(runs), "goroutines": runtime.NumGoroutine()})
+}
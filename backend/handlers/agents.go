@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/http"
+
+	"auto/agent"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Agent Handlers
+
+// DispatchFlowHandler queues a run of a flow for the agents. The job ID is
+// also the ID the run gets once an agent starts it.
+func (h *Handler) DispatchFlowHandler(c *gin.Context) {
+	var req struct {
+		Params   map[string]interface{} `json:"params"`
+		Priority string                 `json:"priority"`
+		// Force runs the flow even when it has a cached result.
+		Force bool `json:"force"`
+		// Labels are stored with the run for correlation.
+		Labels map[string]string `json:"labels"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		h.log(c).Error("Failed to bind JSON", zap.Error(err))
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if !h.checkRunQuota(c, h.flowWorkspace(c.Param("id"))) {
+		return
+	}
+
+	job, err := h.agents.Enqueue(c.Param("id"), req.Params, req.Priority, req.Force, req.Labels)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
+
+func (h *Handler) GetJobHandler(c *gin.Context) {
+	job, err := h.agents.GetJob(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+func (h *Handler) GetAgentsHandler(c *gin.Context) {
+	agents, err := h.agents.Agents()
+	if err != nil {
+		h.log(c).Error("Failed to get agents", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, agents)
+}
+
+func (h *Handler) GetAgentHandler(c *gin.Context) {
+	info, err := h.agents.GetAgent(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// CordonAgentHandler stops an agent from pulling new jobs; the ones it has
+// run to the end.
+func (h *Handler) CordonAgentHandler(c *gin.Context) {
+	h.setAgentState(c, agent.StateCordoned)
+}
+
+// DrainAgentHandler cordons an agent and stops it once its jobs are done.
+func (h *Handler) DrainAgentHandler(c *gin.Context) {
+	h.setAgentState(c, agent.StateDraining)
+}
+
+func (h *Handler) UncordonAgentHandler(c *gin.Context) {
+	h.setAgentState(c, agent.StateActive)
+}
+
+func (h *Handler) setAgentState(c *gin.Context, state string) {
+	info, err := h.agents.SetState(c.Param("id"), state)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"auto/alert"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Alert Handlers
+func (h *Handler) CreateAlertRuleHandler(c *gin.Context) {
+	var req alert.Rule
+	if err := bindJSON(c, &req); err != nil {
+		h.log(c).Error("Failed to bind JSON", zap.Error(err))
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	rule, err := h.alerts.CreateRule(req)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+func (h *Handler) GetAlertRulesHandler(c *gin.Context) {
+	rules, err := h.alerts.GetRules()
+	if err != nil {
+		h.log(c).Error("Failed to get alert rules", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+func (h *Handler) GetAlertRuleHandler(c *gin.Context) {
+	rule, err := h.alerts.GetRule(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+func (h *Handler) UpdateAlertRuleHandler(c *gin.Context) {
+	var req alert.Rule
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	rule, err := h.alerts.UpdateRule(c.Param("id"), req)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+func (h *Handler) DeleteAlertRuleHandler(c *gin.Context) {
+	if err := h.alerts.DeleteRule(c.Param("id")); err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+func (h *Handler) MuteAlertRuleHandler(c *gin.Context) {
+	h.setAlertRuleMuted(c, true)
+}
+
+func (h *Handler) UnmuteAlertRuleHandler(c *gin.Context) {
+	h.setAlertRuleMuted(c, false)
+}
+
+func (h *Handler) setAlertRuleMuted(c *gin.Context, muted bool) {
+	rule, err := h.alerts.SetMuted(c.Param("id"), muted)
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+func (h *Handler) SnoozeAlertRuleHandler(c *gin.Context) {
+	var req struct {
+		Duration string `json:"duration"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	d, err := time.ParseDuration(req.Duration)
+	if err != nil || d <= 0 {
+		respondError(c, http.StatusBadRequest, errors.New("invalid duration"))
+		return
+	}
+
+	rule, err := h.alerts.Snooze(c.Param("id"), d)
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
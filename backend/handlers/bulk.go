@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"auto/apperr"
+	"auto/model"
+	"auto/reconcile"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bulk requests act on at most maxBulkItems instances, bulkConcurrency at
+// a time.
+const (
+	maxBulkItems    = 100
+	bulkConcurrency = 8
+)
+
+// bulkResult is the outcome of a bulk request for one of its items, in
+// the order of the request.
+type bulkResult struct {
+	Index    int             `json:"index"`
+	ID       string          `json:"id,omitempty"`
+	Status   string          `json:"status"`
+	Instance *model.Instance `json:"instance,omitempty"`
+	Error    *apperr.Error   `json:"error,omitempty"`
+}
+
+// runBulk runs op for items 0 to n-1 and answers with their results: 200
+// when every item succeeded, 207 when some failed. A failed item does not
+// stop the others.
+func runBulk(c *gin.Context, n int, op func(i int) bulkResult) {
+	results := make([]bulkResult, n)
+	sem := make(chan struct{}, bulkConcurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = op(i)
+			results[i].Index = i
+		}(i)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, r := range results {
+		if r.Error != nil {
+			failed++
+		}
+	}
+	status := http.StatusOK
+	if failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, gin.H{"results": results, "succeeded": n - failed, "failed": failed})
+}
+
+func bulkOK(id string) bulkResult {
+	return bulkResult{ID: id, Status: "ok"}
+}
+
+func bulkFailed(id string, status int, err error) bulkResult {
+	return bulkResult{ID: id, Status: "failed", Error: apperr.From(err, status)}
+}
+
+// checkBulkSize rejects bulk requests with no items or too many.
+func checkBulkSize(c *gin.Context, n int) bool {
+	if n == 0 {
+		respondError(c, http.StatusBadRequest, errors.New("no instances given"))
+		return false
+	}
+	if n > maxBulkItems {
+		respondError(c, http.StatusBadRequest, fmt.Errorf("too many instances: %d, at most %d per request", n, maxBulkItems))
+		return false
+	}
+	return true
+}
+
+// bulkIDs are the instances a bulk request acts on.
+type bulkIDs struct {
+	InstanceIDs []string `json:"instance_ids"`
+}
+
+// BulkCreateInstancesHandler creates every instance of the body, each like
+// AddInstanceHandler does.
+func (h *Handler) BulkCreateInstancesHandler(c *gin.Context) {
+	var req struct {
+		Instances []instanceRequest `json:"instances"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if !checkBulkSize(c, len(req.Instances)) {
+		return
+	}
+
+	runBulk(c, len(req.Instances), func(i int) bulkResult {
+		instance, status, err := h.createInstance(c, req.Instances[i])
+		if err != nil {
+			return bulkFailed("", status, err)
+		}
+		result := bulkOK(instance.ID)
+		result.Instance = instance
+		return result
+	})
+}
+
+// BulkTagInstancesHandler adds the tags of "add" to the instances and
+// removes those of "remove", or replaces their tags with "set".
+func (h *Handler) BulkTagInstancesHandler(c *gin.Context) {
+	var req struct {
+		bulkIDs
+		Add    []string `json:"add"`
+		Remove []string `json:"remove"`
+		Set    []string `json:"set"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if !checkBulkSize(c, len(req.InstanceIDs)) {
+		return
+	}
+	if req.Set != nil && (len(req.Add) > 0 || len(req.Remove) > 0) {
+		respondError(c, http.StatusBadRequest, errors.New("set replaces the tags: give either set or add and remove"))
+		return
+	}
+
+	runBulk(c, len(req.InstanceIDs), func(i int) bulkResult {
+		id := req.InstanceIDs[i]
+		if err := h.instanceManager.UpdateInstanceTags(id, req.Add, req.Remove, req.Set); err != nil {
+			return bulkFailed(id, http.StatusBadRequest, err)
+		}
+		return bulkOK(id)
+	})
+}
+
+// BulkStartInstancesHandler starts the instances; each logs in in the
+// background.
+func (h *Handler) BulkStartInstancesHandler(c *gin.Context) {
+	h.bulkInstances(c, func(id string) (int, error) {
+		return http.StatusInternalServerError, h.instanceManager.StartInstance(id)
+	})
+}
+
+func (h *Handler) BulkStopInstancesHandler(c *gin.Context) {
+	h.bulkInstances(c, func(id string) (int, error) {
+		return http.StatusInternalServerError, h.instanceManager.StopInstance(id)
+	})
+}
+
+// BulkDeleteInstancesPlanHandler lists the instances a bulk delete of the
+// same "instance_ids" would delete, with the runs using them, and the hash
+// BulkDeleteInstancesHandler takes to delete them.
+func (h *Handler) BulkDeleteInstancesPlanHandler(c *gin.Context) {
+	var req bulkIDs
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if !checkBulkSize(c, len(req.InstanceIDs)) {
+		return
+	}
+
+	plan, err := h.reconciler().PlanInstanceDeletes(req.InstanceIDs)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, plan)
+}
+
+// BulkDeleteInstancesHandler deletes the instances of "instance_ids",
+// provided "plan_hash" is the hash of their plan, so that a mistaken list
+// does not delete instances nobody reviewed.
+func (h *Handler) BulkDeleteInstancesHandler(c *gin.Context) {
+	var req struct {
+		bulkIDs
+		PlanHash string `json:"plan_hash"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if !checkBulkSize(c, len(req.InstanceIDs)) {
+		return
+	}
+	plan, err := h.reconciler().PlanInstanceDeletes(req.InstanceIDs)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if err := reconcile.CheckPlan(plan, req.PlanHash); err != nil {
+		respondError(c, http.StatusConflict, err)
+		return
+	}
+
+	runBulk(c, len(req.InstanceIDs), func(i int) bulkResult {
+		id := req.InstanceIDs[i]
+		if status, err := h.deleteInstance(c, id); err != nil {
+			return bulkFailed(id, status, err)
+		}
+		return bulkOK(id)
+	})
+}
+
+// bulkInstances runs op for each instance of the "instance_ids" of the
+// body.
+func (h *Handler) bulkInstances(c *gin.Context, op func(id string) (int, error)) {
+	var req bulkIDs
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if !checkBulkSize(c, len(req.InstanceIDs)) {
+		return
+	}
+
+	runBulk(c, len(req.InstanceIDs), func(i int) bulkResult {
+		id := req.InstanceIDs[i]
+		if status, err := op(id); err != nil {
+			return bulkFailed(id, status, err)
+		}
+		return bulkOK(id)
+	})
+}
+
+// SetInstanceTagsHandler replaces the tags of an instance.
+func (h *Handler) SetInstanceTagsHandler(c *gin.Context) {
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.instanceManager.SetInstanceTags(c.Param("id"), req.Tags); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
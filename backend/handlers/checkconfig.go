@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"auto/config"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ConfigFile is the file the configuration of the server is loaded from.
+const ConfigFile = ".env"
+
+// CheckConfig returns the problems of the configuration in filename and the
+// environment, the settings of the API parsed here included.
+func CheckConfig(filename string) (config.Problems, error) {
+	cfg, problems, err := config.Check(filename)
+	if err != nil {
+		return nil, err
+	}
+	check := func(setting string, value []string, err error) {
+		if err != nil {
+			problems = append(problems, config.Problem{Setting: setting, Value: strings.Join(value, ","), Message: err.Error()})
+		}
+	}
+	_, err = ParseRateLimit(cfg.RateLimitDefault)
+	check("RATE_LIMIT_DEFAULT", []string{cfg.RateLimitDefault}, err)
+	_, err = ParseRateLimits("", cfg.RateLimitRoutes, nil)
+	check("RATE_LIMIT_ROUTES", cfg.RateLimitRoutes, err)
+	_, err = ParseRateLimits("", nil, cfg.RateLimitKeys)
+	check("RATE_LIMIT_KEYS", cfg.RateLimitKeys, err)
+	_, err = ParseRequestTimeouts(cfg.RequestTimeoutDefault, nil)
+	check("REQUEST_TIMEOUT", []string{cfg.RequestTimeoutDefault}, err)
+	_, err = ParseRequestTimeouts("", cfg.RequestTimeoutRoutes)
+	check("REQUEST_TIMEOUT_ROUTES", cfg.RequestTimeoutRoutes, err)
+	return problems, nil
+}
+
+// ValidateConfigHandler checks the configuration file as it is now, so an
+// edit can be checked before the server restarts with it.
+func (h *Handler) ValidateConfigHandler(c *gin.Context) {
+	problems, err := CheckConfig(ConfigFile)
+	if err != nil {
+		h.log(c).Error("Failed to check configuration", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if problems == nil {
+		problems = config.Problems{}
+	}
+	c.JSON(http.StatusOK, gin.H{"valid": len(problems) == 0, "problems": problems})
+}
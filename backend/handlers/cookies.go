@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"auto/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExportCookiesHandler returns the cookies of a running instance, only
+// those of the "domain" query parameter and its subdomains when it is set.
+func (h *Handler) ExportCookiesHandler(c *gin.Context) {
+	cookies, err := h.instanceManager.ExportCookies(c.Param("id"), c.Query("domain"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, cookies)
+}
+
+// ImportCookiesHandler sets cookies in a running instance: the cookies of
+// the body, or those of the running instance from_instance, filtered by
+// domain like an export.
+func (h *Handler) ImportCookiesHandler(c *gin.Context) {
+	var req struct {
+		Cookies      []model.Cookie `json:"cookies"`
+		FromInstance string         `json:"from_instance"`
+		Domain       string         `json:"domain"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if (req.FromInstance == "") == (len(req.Cookies) == 0) {
+		respondError(c, http.StatusBadRequest, errors.New("either cookies or from_instance is required"))
+		return
+	}
+
+	count := len(req.Cookies)
+	var err error
+	if req.FromInstance != "" {
+		count, err = h.instanceManager.CopyCookies(req.FromInstance, c.Param("id"), req.Domain)
+	} else {
+		err = h.instanceManager.ImportCookies(c.Param("id"), req.Cookies)
+	}
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "imported", "count": count})
+}
+
+// SetSeedCookiesHandler sets the cookies an instance gets each time it
+// starts; an empty list removes them.
+func (h *Handler) SetSeedCookiesHandler(c *gin.Context) {
+	var req struct {
+		Cookies []model.Cookie `json:"cookies"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.instanceManager.SetInstanceSeedCookies(c.Param("id"), req.Cookies); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+
+	"auto/analysis"
+	"auto/crawler"
+	"auto/model"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Crawl Handlers
+func (h *Handler) StartCrawlHandler(c *gin.Context) {
+	var req struct {
+		URL     string             `json:"url"`
+		Scope   crawler.Scope      `json:"scope"`
+		Sitemap bool               `json:"sitemap"`
+		Proxy   *model.ProxyConfig `json:"proxy"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		h.log(c).Error("Failed to bind JSON", zap.Error(err))
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := h.crawler.Start(req.URL, req.Scope, req.Sitemap, req.Proxy)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func (h *Handler) GetCrawlsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, h.crawler.GetJobs())
+}
+
+func (h *Handler) GetCrawlHandler(c *gin.Context) {
+	id := c.Param("id")
+	job, err := h.crawler.GetJob(id)
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+func (h *Handler) GetCrawlInventoryHandler(c *gin.Context) {
+	id := c.Param("id")
+	job, err := h.crawler.GetJob(id)
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+
+	endpoints := crawler.BuildInventory(job.Requests)
+	title := "Crawl of " + job.StartURL
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.JSON(http.StatusOK, endpoints)
+	case "openapi":
+		c.JSON(http.StatusOK, crawler.OpenAPI(title, endpoints))
+	case "postman":
+		c.JSON(http.StatusOK, crawler.PostmanCollection(title, endpoints))
+	default:
+		respondError(c, http.StatusBadRequest, errors.New("format must be json, openapi or postman"))
+	}
+}
+
+// ExportCrawlRequestsHandler downloads the requests a crawl captured in the
+// ?format= of an exporter, "har" by default.
+func (h *Handler) ExportCrawlRequestsHandler(c *gin.Context) {
+	id := c.Param("id")
+	job, err := h.crawler.GetJob(id)
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	exporter, err := model.GetExporter(c.DefaultQuery("format", "har"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := exporter.Export(&buf, job.Requests); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.Data(http.StatusOK, exporter.ContentType(), buf.Bytes())
+}
+
+func (h *Handler) GetCrawlFindingsHandler(c *gin.Context) {
+	id := c.Param("id")
+	job, err := h.crawler.GetJob(id)
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, analysis.Analyze(job.Requests, job.Responses))
+}
+
+func (h *Handler) GetCrawlWebSocketsHandler(c *gin.Context) {
+	id := c.Param("id")
+	job, err := h.crawler.GetJob(id)
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	frames, err := model.FilterWebSocketFrames(job.WebSockets, c.Query("url"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	c.JSON(http.StatusOK, frames)
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+
+	"auto/apperr"
+
+	"github.com/gin-gonic/gin"
+)
+
+// respondError answers with err as a structured error:
+//
+//	{"error": {"code": "INSTANCE_NOT_FOUND", "message": "instance not found"}}
+//
+// Errors that carry a code decide the status themselves; for any other
+// error status is used, with the generic code matching it.
+func respondError(c *gin.Context, status int, err error) {
+	e := apperr.From(err, status)
+	if e.Code != apperr.CodeInternal {
+		status = e.Status()
+	}
+	c.JSON(status, gin.H{"error": e})
+}
+
+// respondErrors answers a batch operation that failed for some items with
+// their errors under "errors".
+func respondErrors(c *gin.Context, errs []error) {
+	failures := make([]*apperr.Error, len(errs))
+	for i, err := range errs {
+		failures[i] = apperr.From(err, http.StatusInternalServerError)
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"errors": failures})
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"auto/dbmanager"
+	"auto/flow"
+	"auto/model"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// importTarget reads the name of an imported flow and what it runs on
+// from ?name=, ?instance_id= and ?instance_group=.
+func importTarget(c *gin.Context) flow.ImportTarget {
+	return flow.ImportTarget{
+		Name:          c.Query("name"),
+		InstanceID:    c.Query("instance_id"),
+		InstanceGroup: c.Query("instance_group"),
+	}
+}
+
+// respondImport saves an imported flow to the database and answers with it
+// and the recorded values of its run parameters.
+func (h *Handler) respondImport(c *gin.Context, imported *flow.RequestImport, err error) {
+	if err != nil {
+		respondError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	dbFlow := dbmanager.DbFlow{
+		ID:        dbmanager.NewNullString(imported.Flow.GetID()),
+		Instances: dbmanager.NewNullString(imported.Flow.GetInstanceID()),
+		Steps:     dbmanager.NewNullString(""),
+		Status:    dbmanager.NewNullString("created"),
+	}
+	if err := h.dbManager.SaveFlow(dbFlow); err != nil {
+		h.log(c).Error("Failed to save flow to database", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, errors.New("failed to save flow to database"))
+		return
+	}
+
+	c.Header("ETag", flowETag(imported.Flow.GetRevision()))
+	c.JSON(http.StatusOK, imported)
+}
+
+// ImportHARHandler creates a flow from the HAR file in the request body.
+func (h *Handler) ImportHARHandler(c *gin.Context) {
+	var har model.HAR
+	if err := json.NewDecoder(c.Request.Body).Decode(&har); err != nil {
+		respondError(c, http.StatusBadRequest, fmt.Errorf("invalid HAR file: %w", err))
+		return
+	}
+	imported, err := h.flowManager.ImportHAR(&har, importTarget(c))
+	h.respondImport(c, imported, err)
+}
+
+// ImportCurlHandler creates a flow from pasted curl commands.
+func (h *Handler) ImportCurlHandler(c *gin.Context) {
+	var req struct {
+		Curl          string `json:"curl"`
+		Name          string `json:"name"`
+		InstanceID    string `json:"instance_id"`
+		InstanceGroup string `json:"instance_group"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	imported, err := h.flowManager.ImportCurl(req.Curl, flow.ImportTarget{
+		Name:          req.Name,
+		InstanceID:    req.InstanceID,
+		InstanceGroup: req.InstanceGroup,
+	})
+	h.respondImport(c, imported, err)
+}
+
+// ImportPostmanHandler creates a flow from the Postman collection in the
+// request body.
+func (h *Handler) ImportPostmanHandler(c *gin.Context) {
+	var collection flow.PostmanCollection
+	if err := json.NewDecoder(c.Request.Body).Decode(&collection); err != nil {
+		respondError(c, http.StatusBadRequest, fmt.Errorf("invalid Postman collection: %w", err))
+		return
+	}
+	imported, err := h.flowManager.ImportPostman(&collection, importTarget(c))
+	h.respondImport(c, imported, err)
+}
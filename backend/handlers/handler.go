@@ -1,258 +1,1583 @@
-package handlers
-
-import (
-	"net/http"
-	"time"
-
-	"auto/dbmanager"
-	"auto/flow"
-	"auto/model"
-
-	"github.com/gin-gonic/gin"
-	"go.uber.org/zap"
-)
-
-type Handler struct {
-	logger          *zap.Logger
-	dbManager       *dbmanager.DbManager
-	flowManager     *flow.Manager
-	instanceManager *model.InstanceManager
-}
-
-func NewHandler(logger *zap.Logger, dbManager *dbmanager.DbManager, flowManager *flow.Manager, instanceManager *model.InstanceManager) *Handler {
-	return &Handler{
-		logger:          logger,
-		dbManager:       dbManager,
-		flowManager:     flowManager,
-		instanceManager: instanceManager,
-	}
-}
-
-// Flow Handlers
-func (h *Handler) CreateFlowHandler(c *gin.Context) {
-	var req struct {
-		Name string `json:"name"`
-	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		h.logger.Error("Failed to bind JSON", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	newFlow := h.flowManager.CreateFlow(req.Name, "")
-	if newFlow == nil {
-		h.logger.Error("Failed to create flow")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create flow"})
-		return
-	}
-
-	// Save flow to database
-	dbFlow := dbmanager.DbFlow{
-		ID:        dbmanager.NewNullString(newFlow.GetID()),
-		Instances: dbmanager.NewNullString(newFlow.GetInstanceID()),
-		Steps:     dbmanager.NewNullString(""), // Assuming steps are initially empty
-		Status:    dbmanager.NewNullString("created"),
-	}
-	if err := h.dbManager.SaveFlow(dbFlow); err != nil {
-		h.logger.Error("Failed to save flow to database", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save flow to database"})
-		return
-	}
-
-	c.JSON(http.StatusOK, newFlow)
-}
-
-func (h *Handler) GetFlowsHandler(c *gin.Context) {
-	flows := h.flowManager.GetFlows()
-	c.JSON(http.StatusOK, flows)
-}
-
-func (h *Handler) DeleteFlowHandler(c *gin.Context) {
-	id := c.Param("id")
-	err := h.flowManager.DeleteFlow(id)
-	if err != nil {
-		h.logger.Error("Failed to delete flow", zap.String("flowID", id), zap.Error(err))
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-		return
-	}
-
-	// Delete flow from database
-	if err := h.dbManager.DeleteFlow(id); err != nil {
-		h.logger.Error("Failed to delete flow from database", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete flow from database"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
-}
-
-func (h *Handler) ExecuteFlowsHandler(c *gin.Context) {
-	var req struct {
-		FlowIDs []string `json:"flow_ids"`
-	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		h.logger.Error("Failed to bind JSON", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	errors := h.flowManager.ExecuteFlowsConcurrently(req.FlowIDs, *h.instanceManager)
-	if len(errors) > 0 {
-		h.logger.Error("Failed to execute flows", zap.Errors("errors", errors))
-		c.JSON(http.StatusInternalServerError, gin.H{"errors": errors})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"status": "flows executed"})
-}
-
-// Instance Handlers
-func (h *Handler) AddInstanceHandler(c *gin.Context) {
-	var req struct {
-		URL  string     `json:"url"`
-		Auth model.Auth `json:"auth"`
-	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		h.logger.Error("Failed to bind JSON", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	newInstance, err := h.instanceManager.CreateInstance(req.URL, req.Auth)
-	if err != nil {
-		h.logger.Error("Failed to create instance", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	// Save instance to database
-	dbInstance := dbmanager.DbInstance{
-		ID:       dbmanager.NewNullString(newInstance.ID),
-		URL:      dbmanager.NewNullString(newInstance.URL),
-		Auth:     dbmanager.NewNullString(""), // Assuming auth is stored as JSON string
-		Status:   dbmanager.NewNullString(newInstance.Status),
-		LastUsed: dbmanager.NewNullTime(time.Now()),
-	}
-	if err := h.dbManager.SaveInstance(dbInstance); err != nil {
-		h.logger.Error("Failed to save instance to database", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save instance to database"})
-		return
-	}
-
-	c.JSON(http.StatusOK, newInstance)
-}
-
-func (h *Handler) GetInstancesHandler(c *gin.Context) {
-	instances := h.instanceManager.GetInstances()
-	c.JSON(http.StatusOK, instances)
-}
-
-func (h *Handler) DeleteInstanceHandler(c *gin.Context) {
-	id := c.Param("id")
-	err := h.instanceManager.DeleteInstance(id)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-		return
-	}
-
-	// Delete instance from database
-	if err := h.dbManager.DeleteInstance(id); err != nil {
-		h.logger.Error("Failed to delete instance from database", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete instance from database"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
-}
-
-func (h *Handler) StartInstancesHandler(c *gin.Context) {
-	var req struct {
-		InstanceIDs []string `json:"instance_ids"`
-	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	errors := h.instanceManager.StartInstancesConcurrently(req.InstanceIDs)
-	if len(errors) > 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{"errors": errors})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"status": "instances started"})
-}
-
-func (h *Handler) StopAllInstancesHandler(c *gin.Context) {
-	errors := h.instanceManager.StopAllInstances()
-	if len(errors) > 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{"errors": errors})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"status": "all instances stopped"})
-}
-
-func (h *Handler) StopInstanceHandler(c *gin.Context) {
-	id := c.Param("id")
-	err := h.instanceManager.StopInstance(id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"status": "stopped"})
-}
-
-func (h *Handler) UpdateInstanceStatusHandler(c *gin.Context) {
-	id := c.Param("id")
-	var req struct {
-		Status string `json:"status"`
-	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	err := h.instanceManager.UpdateInstanceStatus(id, req.Status)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"status": "updated"})
-}
-
-func (h *Handler) GetInstanceScreenshotHandler(c *gin.Context) {
-	id := c.Param("id")
-	screenshot, err := h.instanceManager.GetInstanceScreenshot(id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	c.Data(http.StatusOK, "image/png", screenshot)
-}
-
-// RegisterRoutes registers all routes with the Gin engine
-func RegisterRoutes(r *gin.Engine, handler *Handler) {
-	// Middleware to inject logger into context
-	r.Use(func(c *gin.Context) {
-		c.Set("logger", handler.logger)
-		c.Next()
-	})
-
-	// Instance routes
-	r.POST("/api/v1/instances", handler.AddInstanceHandler)
-	r.GET("/api/v1/instances", handler.GetInstancesHandler)
-	r.DELETE("/api/v1/instances/:id", handler.DeleteInstanceHandler)
-	r.POST("/api/v1/instances/start", handler.StartInstancesHandler)
-	r.POST("/api/v1/instances/stop-all", handler.StopAllInstancesHandler)
-	r.POST("/api/v1/instances/:id/stop", handler.StopInstanceHandler)
-	r.PUT("/api/v1/instances/:id/status", handler.UpdateInstanceStatusHandler)
-	r.GET("/api/v1/instances/:id/screenshot", handler.GetInstanceScreenshotHandler)
-
-	// Flow routes
-	r.POST("/api/v1/flows", handler.CreateFlowHandler)
-	r.GET("/api/v1/flows", handler.GetFlowsHandler)
-	r.DELETE("/api/v1/flows/:id", handler.DeleteFlowHandler)
-	r.POST("/api/v1/flows/execute", handler.ExecuteFlowsHandler)
-}
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"auto/agent"
+	"auto/alert"
+	"auto/analysis"
+	"auto/crawler"
+	"auto/dbmanager"
+	"auto/flow"
+	"auto/janitor"
+	"auto/model"
+	"auto/notify"
+	"auto/pipeline"
+	"auto/quota"
+	"auto/scheduler"
+	"auto/schema"
+	"auto/secrets"
+	"auto/trigger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type Handler struct {
+	logger          *zap.Logger
+	dbManager       *dbmanager.DbManager
+	flowManager     *flow.Manager
+	instanceManager *model.InstanceManager
+	crawler         *crawler.Crawler
+	alerts          *alert.Engine
+	scheduler       *scheduler.Scheduler
+	janitor         *janitor.Janitor
+	triggers        *trigger.Manager
+	agents          *agent.Queue
+	secrets         *secrets.Store
+	quotas          *quota.Tracker
+	limits          BodyLimits
+	rateLimits      RateLimits
+	timeouts        RequestTimeouts
+	adminAuth       AdminAuth
+
+	// createMu makes counting the instances of a workspace and creating
+	// one a single step, so concurrent creations keep to its quota.
+	createMu sync.Mutex
+}
+
+func NewHandler(logger *zap.Logger, dbManager *dbmanager.DbManager, flowManager *flow.Manager, instanceManager *model.InstanceManager, crawler *crawler.Crawler, alerts *alert.Engine, scheduler *scheduler.Scheduler, janitor *janitor.Janitor, triggers *trigger.Manager, agents *agent.Queue, secrets *secrets.Store, quotas *quota.Tracker, limits BodyLimits, rateLimits RateLimits, timeouts RequestTimeouts, adminAuth AdminAuth) *Handler {
+	return &Handler{
+		logger:          logger,
+		dbManager:       dbManager,
+		flowManager:     flowManager,
+		instanceManager: instanceManager,
+		crawler:         crawler,
+		alerts:          alerts,
+		scheduler:       scheduler,
+		janitor:         janitor,
+		triggers:        triggers,
+		agents:          agents,
+		secrets:         secrets,
+		quotas:          quotas,
+		limits:          limits,
+		rateLimits:      rateLimits,
+		timeouts:        timeouts,
+		adminAuth:       adminAuth,
+	}
+}
+
+// Flow Handlers
+func (h *Handler) CreateFlowHandler(c *gin.Context) {
+	var req struct {
+		Name                string `json:"name"`
+		RetryOnBrowserError bool   `json:"retry_on_browser_error"`
+		StepScreenshots     bool   `json:"step_screenshots"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		h.log(c).Error("Failed to bind JSON", zap.Error(err))
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	newFlow, err := h.flowManager.CreateFlowFrom(&flow.FlowImpl{
+		ID:    uuid.New().String(),
+		Name:  req.Name,
+		Steps: []flow.Step{},
+
+		RetryOnBrowserError: req.RetryOnBrowserError,
+		StepScreenshots:     req.StepScreenshots,
+	})
+	if err != nil {
+		h.log(c).Error("Failed to create flow", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, errors.New("failed to create flow"))
+		return
+	}
+
+	// Save flow to database
+	dbFlow := dbmanager.DbFlow{
+		ID:        dbmanager.NewNullString(newFlow.GetID()),
+		Instances: dbmanager.NewNullString(newFlow.GetInstanceID()),
+		Steps:     dbmanager.NewNullString(""), // Assuming steps are initially empty
+		Status:    dbmanager.NewNullString("created"),
+	}
+	if err := h.dbManager.SaveFlow(dbFlow); err != nil {
+		h.log(c).Error("Failed to save flow to database", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, errors.New("failed to save flow to database"))
+		return
+	}
+
+	c.JSON(http.StatusOK, newFlow)
+}
+
+func (h *Handler) GetFlowsHandler(c *gin.Context) {
+	flows := h.flowManager.GetFlows()
+	c.JSON(http.StatusOK, flows)
+}
+
+func (h *Handler) GetFlowHandler(c *gin.Context) {
+	f, err := h.flowManager.GetFlow(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.Header("ETag", flowETag(f.GetRevision()))
+	c.JSON(http.StatusOK, f)
+}
+
+// UpdateFlowHandler serves both PUT, which replaces the name, instance and
+// steps of a flow, and PATCH, which only changes the fields present in the
+// body. Steps without an ID get a new one.
+func (h *Handler) UpdateFlowHandler(c *gin.Context) {
+	id := c.Param("id")
+	revision, ok := ifMatchRevision(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name          *string      `json:"name"`
+		InstanceID    *string      `json:"instance_id"`
+		InstanceGroup *string      `json:"instance_group"`
+		Steps         *[]flow.Step `json:"steps"`
+		Mutex         *string      `json:"mutex"`
+		// RetryOnBrowserError runs the flow again on a restarted instance
+		// when the browser fails under it.
+		RetryOnBrowserError *bool `json:"retry_on_browser_error"`
+		// StepScreenshots takes a thumbnail after every step of each run.
+		StepScreenshots *bool `json:"step_screenshots"`
+		// Proxy is replaced as a whole; null removes it.
+		Proxy json.RawMessage `json:"proxy"`
+		// ResultCacheTTLSeconds of 0 turns the result cache off.
+		ResultCacheTTLSeconds *int `json:"result_cache_ttl_seconds"`
+		// Retention is replaced as a whole; null falls back on the
+		// retention of the server.
+		Retention json.RawMessage `json:"retention"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	current, err := h.flowManager.GetFlow(id)
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+
+	updated := &flow.FlowImpl{
+		ID:            id,
+		Name:          current.GetName(),
+		InstanceID:    current.GetInstanceID(),
+		InstanceGroup: current.GetInstanceGroup(),
+		Steps:         current.GetSteps(),
+		Hooks:         current.GetHooks(),
+		Outputs:       current.GetOutputs(),
+		Notifications: current.GetNotifications(),
+		OutputSchema:  current.GetOutputSchema(),
+		Revision:      current.GetRevision(),
+		Mutex:         current.GetMutex(),
+		Proxy:         current.GetProxy(),
+
+		RetryOnBrowserError: current.GetRetryOnBrowserError(),
+		StepScreenshots:     current.GetStepScreenshots(),
+
+		ResultCacheTTLSeconds: current.GetResultCacheTTLSeconds(),
+		Retention:             current.GetRetention(),
+	}
+	if revision != 0 {
+		updated.Revision = revision
+	}
+	if c.Request.Method == http.MethodPut {
+		updated.Name, updated.InstanceID, updated.InstanceGroup, updated.Steps = "", "", "", []flow.Step{}
+	}
+	if req.Name != nil {
+		updated.Name = *req.Name
+	}
+	if req.InstanceID != nil {
+		updated.InstanceID = *req.InstanceID
+	}
+	if req.InstanceGroup != nil {
+		updated.InstanceGroup = *req.InstanceGroup
+	}
+	if req.Mutex != nil {
+		updated.Mutex = *req.Mutex
+	}
+	if req.RetryOnBrowserError != nil {
+		updated.RetryOnBrowserError = *req.RetryOnBrowserError
+	}
+	if req.StepScreenshots != nil {
+		updated.StepScreenshots = *req.StepScreenshots
+	}
+	if req.ResultCacheTTLSeconds != nil {
+		if *req.ResultCacheTTLSeconds < 0 {
+			respondError(c, http.StatusBadRequest, errors.New("result cache TTL must not be negative"))
+			return
+		}
+		updated.ResultCacheTTLSeconds = *req.ResultCacheTTLSeconds
+	}
+	if req.Retention != nil {
+		updated.Retention = nil
+		if err := json.Unmarshal(req.Retention, &updated.Retention); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if updated.Retention != nil {
+			if err := updated.Retention.Validate(); err != nil {
+				respondError(c, http.StatusBadRequest, err)
+				return
+			}
+		}
+	}
+	if req.Proxy != nil {
+		if err := json.Unmarshal(req.Proxy, &updated.Proxy); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if updated.Proxy != nil {
+		if err := updated.Proxy.Validate(); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if updated.Proxy.Mode != model.ProxyReplay {
+			respondError(c, http.StatusBadRequest, errors.New("a flow can only replay its runs through a proxy: mode must be replay"))
+			return
+		}
+	}
+	if req.Steps != nil {
+		updated.Steps = *req.Steps
+		for i := range updated.Steps {
+			if updated.Steps[i].ID == "" {
+				updated.Steps[i].ID = uuid.New().String()
+			}
+		}
+	}
+
+	if err := h.flowManager.UpdateFlow(updated); err != nil {
+		h.flowUpdateError(c, id, err)
+		return
+	}
+
+	c.Header("ETag", flowETag(updated.GetRevision()))
+	// Saving lints the flow; the issues themselves come from the lint
+	// endpoint.
+	if issues := flow.Lint(updated); len(issues) > 0 {
+		c.Header("X-Lint-Issues", strconv.Itoa(len(issues)))
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+func (h *Handler) CloneFlowHandler(c *gin.Context) {
+	id := c.Param("id")
+	var req struct {
+		NameSuffix *string `json:"name_suffix"`
+		InstanceID string  `json:"instance_id"`
+	}
+	// The body is optional.
+	if c.Request.ContentLength != 0 {
+		if err := bindJSON(c, &req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+	suffix := " (copy)"
+	if req.NameSuffix != nil {
+		suffix = *req.NameSuffix
+	}
+
+	clone, err := h.flowManager.CloneFlow(id, suffix, req.InstanceID)
+	if err != nil {
+		h.log(c).Error("Failed to clone flow", zap.String("flowID", id), zap.Error(err))
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+
+	dbFlow := dbmanager.DbFlow{
+		ID:        dbmanager.NewNullString(clone.GetID()),
+		Instances: dbmanager.NewNullString(clone.GetInstanceID()),
+		Steps:     dbmanager.NewNullString(""),
+		Status:    dbmanager.NewNullString("created"),
+	}
+	if err := h.dbManager.SaveFlow(dbFlow); err != nil {
+		h.log(c).Error("Failed to save flow to database", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, errors.New("failed to save flow to database"))
+		return
+	}
+
+	c.Header("ETag", flowETag(clone.GetRevision()))
+	c.JSON(http.StatusOK, clone)
+}
+
+func (h *Handler) ExportFlowHandler(c *gin.Context) {
+	id := c.Param("id")
+	bundle, err := h.flowManager.ExportFlow(id)
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "flow-"+id+".json"))
+	c.JSON(http.StatusOK, bundle)
+}
+
+// ImportFlowHandler creates a flow from an exported bundle. When secrets
+// the bundle needs are missing it answers 422 listing them, so the client
+// can ask for them and retry.
+func (h *Handler) ImportFlowHandler(c *gin.Context) {
+	var req struct {
+		Bundle     *flow.Bundle      `json:"bundle"`
+		Secrets    map[string]string `json:"secrets"`
+		InstanceID string            `json:"instance_id"`
+	}
+	// Bundles exported by newer releases may carry fields this one doesn't
+	// know, so they are decoded leniently.
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if req.Bundle == nil {
+		respondError(c, http.StatusBadRequest, errors.New("bundle is required"))
+		return
+	}
+
+	imported, err := h.flowManager.ImportFlow(req.Bundle, req.Secrets, req.InstanceID)
+	var missing *flow.MissingSecretsError
+	if errors.As(err, &missing) {
+		respondError(c, http.StatusUnprocessableEntity, err)
+		return
+	} else if err != nil {
+		h.log(c).Error("Failed to import flow", zap.Error(err))
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	dbFlow := dbmanager.DbFlow{
+		ID:        dbmanager.NewNullString(imported.GetID()),
+		Instances: dbmanager.NewNullString(imported.GetInstanceID()),
+		Steps:     dbmanager.NewNullString(""),
+		Status:    dbmanager.NewNullString("created"),
+	}
+	if err := h.dbManager.SaveFlow(dbFlow); err != nil {
+		h.log(c).Error("Failed to save flow to database", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, errors.New("failed to save flow to database"))
+		return
+	}
+
+	c.Header("ETag", flowETag(imported.GetRevision()))
+	c.JSON(http.StatusOK, imported)
+}
+
+func (h *Handler) DeleteFlowHandler(c *gin.Context) {
+	id := c.Param("id")
+	err := h.flowManager.DeleteFlow(id)
+	if err != nil {
+		h.log(c).Error("Failed to delete flow", zap.String("flowID", id), zap.Error(err))
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+
+	// Delete flow from database
+	if err := h.dbManager.DeleteFlow(id); err != nil {
+		h.log(c).Error("Failed to delete flow from database", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, errors.New("failed to delete flow from database"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+func (h *Handler) SetFlowHooksHandler(c *gin.Context) {
+	id := c.Param("id")
+	revision, ok := ifMatchRevision(c)
+	if !ok {
+		return
+	}
+	var hooks flow.Hooks
+	if err := bindJSON(c, &hooks); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	f, err := h.flowManager.SetHooks(id, revision, hooks)
+	if err != nil {
+		h.flowUpdateError(c, id, err)
+		return
+	}
+
+	c.Header("ETag", flowETag(f.GetRevision()))
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+func (h *Handler) SetFlowOutputsHandler(c *gin.Context) {
+	id := c.Param("id")
+	revision, ok := ifMatchRevision(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Outputs []pipeline.Pipeline `json:"outputs"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	f, err := h.flowManager.SetOutputs(id, revision, req.Outputs)
+	if err != nil {
+		h.flowUpdateError(c, id, err)
+		return
+	}
+
+	c.Header("ETag", flowETag(f.GetRevision()))
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+// SetFlowSchemaHandler sets the schema the results of the flow are
+// validated against; a null schema removes it.
+func (h *Handler) SetFlowSchemaHandler(c *gin.Context) {
+	id := c.Param("id")
+	revision, ok := ifMatchRevision(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		OutputSchema *schema.Schema `json:"output_schema"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	f, err := h.flowManager.SetOutputSchema(id, revision, req.OutputSchema)
+	if err != nil {
+		h.flowUpdateError(c, id, err)
+		return
+	}
+
+	c.Header("ETag", flowETag(f.GetRevision()))
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+func (h *Handler) SetFlowNotificationsHandler(c *gin.Context) {
+	id := c.Param("id")
+	revision, ok := ifMatchRevision(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Notifications []notify.Channel `json:"notifications"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	f, err := h.flowManager.SetNotifications(id, revision, req.Notifications)
+	if err != nil {
+		h.flowUpdateError(c, id, err)
+		return
+	}
+
+	c.Header("ETag", flowETag(f.GetRevision()))
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+// flowUpdateError answers a failed flow update: 409 with the current
+// revision on a conflict, 400 otherwise.
+func (h *Handler) flowUpdateError(c *gin.Context, id string, err error) {
+	var conflict *flow.RevisionConflictError
+	if errors.As(err, &conflict) {
+		c.Header("ETag", flowETag(conflict.Current))
+		respondError(c, http.StatusConflict, err)
+		return
+	}
+	h.log(c).Error("Failed to update flow", zap.String("flowID", id), zap.Error(err))
+	respondError(c, http.StatusBadRequest, err)
+}
+
+func flowETag(revision int64) string {
+	return fmt.Sprintf("%q", strconv.FormatInt(revision, 10))
+}
+
+// ifMatchRevision reads the revision from an If-Match header. Zero means
+// the header is absent or "*", so any revision may be modified. An invalid
+// header is answered with 400 and ok is false.
+func ifMatchRevision(c *gin.Context) (revision int64, ok bool) {
+	value := strings.TrimPrefix(strings.TrimSpace(c.GetHeader("If-Match")), "W/")
+	if value == "" || value == "*" {
+		return 0, true
+	}
+	revision, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64)
+	if err != nil || revision <= 0 {
+		respondError(c, http.StatusBadRequest, errors.New("invalid If-Match header"))
+		return 0, false
+	}
+	return revision, true
+}
+
+func (h *Handler) GetFlowRunsHandler(c *gin.Context) {
+	id := c.Param("id")
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errors.New("invalid limit"))
+		return
+	}
+
+	// ?labels[ticket]=T-1 lists only the runs labelled ticket=T-1.
+	runs, err := h.flowManager.GetRuns(id, limit, c.QueryMap("labels"))
+	if err != nil {
+		h.log(c).Error("Failed to get flow runs", zap.String("flowID", id), zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, runs)
+}
+
+// GetFlowHistoryHandler returns the results of the completed runs of a
+// flow between "from" and "to" (RFC 3339), oldest first. "fields" is a
+// comma-separated list of step IDs or dotted paths to return instead of
+// every result.
+func (h *Handler) GetFlowHistoryHandler(c *gin.Context) {
+	id := c.Param("id")
+	var q flow.HistoryQuery
+	var err error
+	if from := c.Query("from"); from != "" {
+		if q.From, err = time.Parse(time.RFC3339, from); err != nil {
+			respondError(c, http.StatusBadRequest, errors.New("invalid from"))
+			return
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		if q.To, err = time.Parse(time.RFC3339, to); err != nil {
+			respondError(c, http.StatusBadRequest, errors.New("invalid to"))
+			return
+		}
+	}
+	if q.Limit, err = strconv.ParseInt(c.DefaultQuery("limit", "1000"), 10, 64); err != nil || q.Limit < 0 {
+		respondError(c, http.StatusBadRequest, errors.New("invalid limit"))
+		return
+	}
+	q.Fields = splitFields(c.Query("fields"))
+
+	entries, err := h.flowManager.GetHistory(id, q)
+	if err != nil {
+		h.log(c).Error("Failed to get flow history", zap.String("flowID", id), zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// GetLatestFlowHistoryHandler returns the results of the "n" most recent
+// completed runs of a flow, newest first.
+func (h *Handler) GetLatestFlowHistoryHandler(c *gin.Context) {
+	id := c.Param("id")
+	n, err := strconv.ParseInt(c.DefaultQuery("n", "10"), 10, 64)
+	if err != nil || n <= 0 {
+		respondError(c, http.StatusBadRequest, errors.New("invalid n"))
+		return
+	}
+
+	entries, err := h.flowManager.GetLatestHistory(id, n, splitFields(c.Query("fields")))
+	if err != nil {
+		h.log(c).Error("Failed to get flow history", zap.String("flowID", id), zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+func splitFields(s string) []string {
+	var fields []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// GetFlowGraphHandler returns the flow as nodes and edges for drawing. With
+// "runID" the nodes carry their status in that run.
+func (h *Handler) GetFlowGraphHandler(c *gin.Context) {
+	graph, err := h.flowManager.GetGraph(c.Param("id"), c.Query("runID"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, graph)
+}
+
+// LintFlowHandler reports likely problems in the steps of a flow.
+func (h *Handler) LintFlowHandler(c *gin.Context) {
+	id := c.Param("id")
+	issues, err := h.flowManager.LintFlow(id)
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"flow_id": id, "issues": issues})
+}
+
+func (h *Handler) GetFlowStatsHandler(c *gin.Context) {
+	id := c.Param("id")
+	window, err := time.ParseDuration(c.DefaultQuery("window", "24h"))
+	if err != nil || window <= 0 {
+		respondError(c, http.StatusBadRequest, errors.New("invalid window"))
+		return
+	}
+	if _, err := h.flowManager.GetFlow(id); err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+
+	stats, err := h.flowManager.GetStats(id, window)
+	if err != nil {
+		h.log(c).Error("Failed to compute flow stats", zap.String("flowID", id), zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+func (h *Handler) GetRunHandler(c *gin.Context) {
+	run, err := h.flowManager.GetRun(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	// The stored position goes stale as the runs ahead finish.
+	if run.Status == flow.RunStatusQueued {
+		if queue, err := h.instanceManager.GetInstanceQueue(run.InstanceID); err == nil {
+			if position := queue.Position(run.ID); position > 0 {
+				run.QueuePosition = position
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// Run lists hold "limit" runs, at most maxRunsLimit.
+const (
+	defaultRunsLimit = 50
+	maxRunsLimit     = 500
+)
+
+// GetRunsHandler lists the runs of every flow, newest first or, with
+// "sort=started_at", oldest first. "flow", "instance", "status" (a
+// comma-separated list), "labels[key]=value" and "from" and "to" (RFC
+// 3339, on the start time) filter them. A page with more runs after it
+// has a "next_cursor" to pass as "cursor" for the next page.
+func (h *Handler) GetRunsHandler(c *gin.Context) {
+	q := flow.RunQuery{
+		FlowID:     c.Query("flow"),
+		InstanceID: c.Query("instance"),
+		Statuses:   splitFields(c.Query("status")),
+		Labels:     c.QueryMap("labels"),
+		Cursor:     c.Query("cursor"),
+	}
+	for _, status := range q.Statuses {
+		switch status {
+		case flow.RunStatusQueued, flow.RunStatusRunning, flow.RunStatusSucceeded, flow.RunStatusFailed, flow.RunStatusDegraded:
+		default:
+			respondError(c, http.StatusBadRequest, fmt.Errorf("invalid status: %q", status))
+			return
+		}
+	}
+	var err error
+	if from := c.Query("from"); from != "" {
+		if q.From, err = time.Parse(time.RFC3339, from); err != nil {
+			respondError(c, http.StatusBadRequest, errors.New("invalid from"))
+			return
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		if q.To, err = time.Parse(time.RFC3339, to); err != nil {
+			respondError(c, http.StatusBadRequest, errors.New("invalid to"))
+			return
+		}
+	}
+	switch c.DefaultQuery("sort", "-started_at") {
+	case "-started_at":
+	case "started_at":
+		q.Oldest = true
+	default:
+		respondError(c, http.StatusBadRequest, errors.New("invalid sort: must be started_at or -started_at"))
+		return
+	}
+	q.Limit, err = strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultRunsLimit)))
+	if err != nil || q.Limit < 1 || q.Limit > maxRunsLimit {
+		respondError(c, http.StatusBadRequest, fmt.Errorf("invalid limit: must be from 1 to %d", maxRunsLimit))
+		return
+	}
+
+	page, err := h.flowManager.QueryRuns(q)
+	if err != nil {
+		h.log(c).Error("Failed to list runs", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// GetRunVariablesHandler returns the parameters and step results of a run,
+// live while it executes, with credentials redacted.
+func (h *Handler) GetRunVariablesHandler(c *gin.Context) {
+	vars, err := h.flowManager.GetRunVariables(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, vars)
+}
+
+// GetRunTimelineHandler returns the steps of a run in order, with their
+// durations and thumbnails.
+func (h *Handler) GetRunTimelineHandler(c *gin.Context) {
+	timeline, err := h.flowManager.GetTimeline(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, timeline)
+}
+
+// GetRunFindingsHandler returns the issues passive analysis spotted in the
+// traffic and pages of a run, like those of a crawl.
+func (h *Handler) GetRunFindingsHandler(c *gin.Context) {
+	run, err := h.flowManager.GetRun(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	report := run.Findings
+	if report == nil {
+		report = &analysis.Report{Findings: []analysis.Finding{}, Summary: map[string]int{}}
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func (h *Handler) ExecuteFlowsHandler(c *gin.Context) {
+	var req struct {
+		FlowIDs []string `json:"flow_ids"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		h.log(c).Error("Failed to bind JSON", zap.Error(err))
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	for _, id := range req.FlowIDs {
+		if !h.checkRunQuota(c, h.flowWorkspace(id)) {
+			return
+		}
+	}
+
+	errs := h.flowManager.ExecuteFlowsConcurrently(req.FlowIDs, h.instanceManager)
+	if len(errs) > 0 {
+		h.log(c).Error("Failed to execute flows", zap.Errors("errors", errs))
+		respondErrors(c, errs)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "flows executed"})
+}
+
+// Sync executions wait ?timeout= for the run, at most maxSyncTimeout, and
+// stop waiting syncDeadlineMargin before the request times out so there is
+// time left to answer with the run ID.
+const (
+	defaultSyncTimeout = 30 * time.Second
+	maxSyncTimeout     = 5 * time.Minute
+	syncDeadlineMargin = time.Second
+)
+
+// ExecuteSyncHandler runs a flow and answers with the run, its results
+// included, once it is done. A run that takes longer than the timeout
+// goes on in the background: the answer is then 202 with the run ID to
+// poll GET /api/v1/runs/:id with.
+func (h *Handler) ExecuteSyncHandler(c *gin.Context) {
+	var req struct {
+		Params   map[string]interface{} `json:"params"`
+		Priority string                 `json:"priority"`
+		// Force runs the flow even when it has a cached result.
+		Force bool `json:"force"`
+		// Labels are stored with the run for correlation.
+		Labels map[string]string `json:"labels"`
+	}
+	// The body is optional.
+	if c.Request.ContentLength != 0 {
+		if err := bindJSON(c, &req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if err := flow.ValidateLabels(req.Labels); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	timeout, err := time.ParseDuration(c.DefaultQuery("timeout", defaultSyncTimeout.String()))
+	if err != nil || timeout <= 0 || timeout > maxSyncTimeout {
+		respondError(c, http.StatusBadRequest, fmt.Errorf("invalid timeout: must be a duration of at most %s", maxSyncTimeout))
+		return
+	}
+	switch req.Priority {
+	case "":
+		req.Priority = model.PriorityInteractive
+	case model.PriorityInteractive, model.PriorityBatch:
+	default:
+		respondError(c, http.StatusBadRequest, fmt.Errorf("invalid priority: %q", req.Priority))
+		return
+	}
+	id := c.Param("id")
+	if _, err := h.flowManager.GetFlow(id); err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	if !h.checkRunQuota(c, h.flowWorkspace(id)) {
+		return
+	}
+
+	// The run outlives the request when it times out.
+	runID := uuid.New().String()
+	ctx := flow.WithLabels(flow.WithRunID(context.Background(), runID), req.Labels)
+	if req.Force {
+		ctx = flow.WithoutResultCache(ctx)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- h.flowManager.ExecuteFlowContext(ctx, id, h.instanceManager, req.Params, req.Priority)
+	}()
+
+	if deadline, ok := c.Request.Context().Deadline(); ok {
+		if left := time.Until(deadline) - syncDeadlineMargin; left < timeout {
+			timeout = left
+		}
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	finished := false
+	select {
+	case err = <-done:
+		finished = true
+	case <-timer.C:
+	case <-c.Request.Context().Done():
+		// The client is gone, or the request timed out before the wait
+		// did; the run goes on either way.
+		if c.Request.Context().Err() != context.DeadlineExceeded {
+			return
+		}
+	}
+	if !finished {
+		c.Header("Location", "/api/v1/runs/"+runID)
+		c.JSON(http.StatusAccepted, gin.H{"run_id": runID, "status": "running"})
+		return
+	}
+
+	if err != nil {
+		// The error names the run and the step it failed at.
+		h.log(c).Error("Failed to execute flow", zap.String("flowID", id), zap.String("runID", runID), zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	run, err := h.flowManager.GetRun(runID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
+
+// ExecuteAnonymousHandler runs a flow on an instance made for the run from
+// the options given, which is destroyed afterwards, and answers with the
+// run once it is done.
+func (h *Handler) ExecuteAnonymousHandler(c *gin.Context) {
+	var req struct {
+		Instance model.AnonymousInstance `json:"instance"`
+		Params   map[string]interface{}  `json:"params"`
+		Priority string                  `json:"priority"`
+		// Force runs the flow even when it has a cached result.
+		Force bool `json:"force"`
+		// Labels are stored with the run for correlation.
+		Labels map[string]string `json:"labels"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		h.log(c).Error("Failed to bind JSON", zap.Error(err))
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := req.Instance.Validate(); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := flow.ValidateLabels(req.Labels); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	switch req.Priority {
+	case "":
+		req.Priority = model.PriorityInteractive
+	case model.PriorityInteractive, model.PriorityBatch:
+	default:
+		respondError(c, http.StatusBadRequest, fmt.Errorf("invalid priority: %q", req.Priority))
+		return
+	}
+
+	workspace, err := requestWorkspace(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if !h.checkRunQuota(c, workspace) {
+		return
+	}
+
+	ctx := flow.WithWorkspace(flow.WithLabels(c.Request.Context(), req.Labels), workspace)
+	if req.Force {
+		ctx = flow.WithoutResultCache(ctx)
+	}
+	id := c.Param("id")
+	run, err := h.flowManager.ExecuteAnonymous(ctx, id, h.instanceManager, req.Instance, req.Params, req.Priority)
+	if err != nil {
+		h.log(c).Error("Failed to execute flow on anonymous instance", zap.String("flowID", id), zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// instanceRequest describes an instance to create. With a template, the
+// fields left empty come from the template, whose URL takes vars; tags add
+// to those of the template.
+type instanceRequest struct {
+	TemplateID string            `json:"template_id"`
+	Vars       map[string]string `json:"vars"`
+
+	URL            string         `json:"url"`
+	Auth           model.Auth     `json:"auth"`
+	LoginProfileID string         `json:"login_profile_id"`
+	Cookies        []model.Cookie `json:"cookies"`
+	// IdleTimeoutMinutes stops the instance after that long unused.
+	IdleTimeoutMinutes int      `json:"idle_timeout_minutes"`
+	Tags               []string `json:"tags"`
+}
+
+// Instance Handlers
+func (h *Handler) AddInstanceHandler(c *gin.Context) {
+	var req instanceRequest
+	if err := bindJSON(c, &req); err != nil {
+		h.log(c).Error("Failed to bind JSON", zap.Error(err))
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	newInstance, status, err := h.createInstance(c, req)
+	if err != nil {
+		respondError(c, status, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newInstance)
+}
+
+// createInstance creates the instance req describes, and returns it or the
+// status of the error that stopped it.
+func (h *Handler) createInstance(c *gin.Context, req instanceRequest) (*model.Instance, int, error) {
+	workspace, err := requestWorkspace(c)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	var template *model.InstanceTemplate
+	if req.TemplateID != "" {
+		var err error
+		if template, err = h.instanceManager.GetInstanceTemplate(req.TemplateID); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		if req.URL == "" {
+			if req.URL, err = template.URLFor(req.Vars); err != nil {
+				return nil, http.StatusBadRequest, err
+			}
+		}
+		if req.LoginProfileID == "" {
+			req.LoginProfileID = template.LoginProfileID
+		}
+		if req.IdleTimeoutMinutes == 0 {
+			req.IdleTimeoutMinutes = template.IdleTimeoutMinutes
+		}
+		req.Tags = append(append([]string{}, template.Tags...), req.Tags...)
+	}
+	if req.LoginProfileID != "" {
+		if _, err := h.instanceManager.GetLoginProfile(req.LoginProfileID); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
+
+	h.createMu.Lock()
+	if err := h.quotas.CheckInstances(workspace, h.countInstances(workspace)); err != nil {
+		h.createMu.Unlock()
+		return nil, http.StatusForbidden, err
+	}
+	newInstance, err := h.instanceManager.CreateInstance(req.URL, req.Auth)
+	if err == nil {
+		err = h.instanceManager.SetInstanceWorkspace(newInstance.ID, workspace)
+	}
+	h.createMu.Unlock()
+	if err != nil {
+		h.log(c).Error("Failed to create instance", zap.Error(err))
+		return nil, http.StatusInternalServerError, err
+	}
+	if req.LoginProfileID != "" {
+		if err := h.instanceManager.SetInstanceLoginProfile(newInstance.ID, req.LoginProfileID); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+	if len(req.Cookies) > 0 {
+		if err := h.instanceManager.SetInstanceSeedCookies(newInstance.ID, req.Cookies); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
+	if req.IdleTimeoutMinutes != 0 {
+		if err := h.instanceManager.SetInstanceIdleTimeout(newInstance.ID, req.IdleTimeoutMinutes); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
+	if len(req.Tags) > 0 {
+		if err := h.instanceManager.SetInstanceTags(newInstance.ID, req.Tags); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
+	if template != nil {
+		if err := h.instanceManager.ApplyInstanceTemplate(newInstance.ID, template); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+
+	// Save instance to database
+	dbInstance := dbmanager.DbInstance{
+		ID:       dbmanager.NewNullString(newInstance.ID),
+		URL:      dbmanager.NewNullString(newInstance.URL),
+		Auth:     dbmanager.NewNullString(""), // Assuming auth is stored as JSON string
+		Status:   dbmanager.NewNullString(newInstance.GetStatus()),
+		LastUsed: dbmanager.NewNullTime(time.Now()),
+	}
+	if err := h.dbManager.SaveInstance(dbInstance); err != nil {
+		h.log(c).Error("Failed to save instance to database", zap.Error(err))
+		return nil, http.StatusInternalServerError, errors.New("failed to save instance to database")
+	}
+	return newInstance, http.StatusOK, nil
+}
+
+// GetInstancesHandler lists the instances, only those tagged with "tag"
+// when it is set.
+func (h *Handler) GetInstancesHandler(c *gin.Context) {
+	instances := h.instanceManager.GetInstances()
+	if tag := c.Query("tag"); tag != "" {
+		tagged := make([]*model.Instance, 0, len(instances))
+		for _, instance := range instances {
+			if instance.HasTag(tag) {
+				tagged = append(tagged, instance)
+			}
+		}
+		instances = tagged
+	}
+	c.JSON(http.StatusOK, instances)
+}
+
+func (h *Handler) DeleteInstanceHandler(c *gin.Context) {
+	if status, err := h.deleteInstance(c, c.Param("id")); err != nil {
+		respondError(c, status, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// deleteInstance deletes an instance, and returns the status of the error
+// that stopped it.
+func (h *Handler) deleteInstance(c *gin.Context, id string) (int, error) {
+	err := h.instanceManager.DeleteInstance(id)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+
+	// Delete instance from database
+	if err := h.dbManager.DeleteInstance(id); err != nil {
+		h.log(c).Error("Failed to delete instance from database", zap.Error(err))
+		return http.StatusInternalServerError, errors.New("failed to delete instance from database")
+	}
+	return http.StatusOK, nil
+}
+
+func (h *Handler) StartInstancesHandler(c *gin.Context) {
+	var req struct {
+		InstanceIDs []string `json:"instance_ids"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	errs := h.instanceManager.StartInstancesConcurrently(req.InstanceIDs)
+	if len(errs) > 0 {
+		respondErrors(c, errs)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "instances started"})
+}
+
+func (h *Handler) StopAllInstancesHandler(c *gin.Context) {
+	errs := h.instanceManager.StopAllInstances()
+	if len(errs) > 0 {
+		respondErrors(c, errs)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "all instances stopped"})
+}
+
+func (h *Handler) StopInstanceHandler(c *gin.Context) {
+	id := c.Param("id")
+	err := h.instanceManager.StopInstance(id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "stopped"})
+}
+
+func (h *Handler) UpdateInstanceStatusHandler(c *gin.Context) {
+	id := c.Param("id")
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err := h.instanceManager.UpdateInstanceStatus(id, req.Status)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+func (h *Handler) GetInstanceScreenshotHandler(c *gin.Context) {
+	id := c.Param("id")
+	screenshot, err := h.instanceManager.GetInstanceScreenshot(c.Request.Context(), id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "image/png", screenshot)
+}
+
+func (h *Handler) GetInstanceWebSocketsHandler(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := h.instanceManager.GetInstance(id); err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	frames, err := h.instanceManager.GetInstanceWebSocketFrames(id, c.Query("url"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	c.JSON(http.StatusOK, frames)
+}
+
+func (h *Handler) GetInstanceQueueHandler(c *gin.Context) {
+	queue, err := h.instanceManager.GetInstanceQueue(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, queue)
+}
+
+func (h *Handler) GetInstanceStorageHandler(c *gin.Context) {
+	id := c.Param("id")
+	dump, err := h.instanceManager.GetInstanceStorage(c.Request.Context(), id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, dump)
+}
+
+func (h *Handler) SetInstancePermissionsHandler(c *gin.Context) {
+	id := c.Param("id")
+	var req struct {
+		Permissions map[string]string `json:"permissions"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err := h.instanceManager.SetInstancePermissions(id, req.Permissions)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+func (h *Handler) SetInstanceHTTPAuthHandler(c *gin.Context) {
+	id := c.Param("id")
+	var req model.HTTPAuth
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err := h.instanceManager.SetInstanceHTTPAuth(id, req)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+// SetInstanceHeadersHandler sets the User-Agent, Accept-Language and extra
+// headers an instance sends.
+func (h *Handler) SetInstanceHeadersHandler(c *gin.Context) {
+	var req model.HeaderOverrides
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.instanceManager.SetInstanceHeaders(c.Param("id"), req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+// SetInstanceDriverHandler selects the browser driver of an instance.
+func (h *Handler) SetInstanceDriverHandler(c *gin.Context) {
+	var req model.DriverConfig
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.instanceManager.SetInstanceDriver(c.Param("id"), req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+// GetDriversHandler lists the browser drivers instances can use.
+func (h *Handler) GetDriversHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"drivers": model.Drivers()})
+}
+
+// SetInstanceDeviceHandler makes an instance emulate a mobile device; an
+// empty device goes back to a desktop browser.
+func (h *Handler) SetInstanceDeviceHandler(c *gin.Context) {
+	var req struct {
+		Device string `json:"device"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.instanceManager.SetInstanceDevice(c.Param("id"), req.Device); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+// SetInstanceIdleTimeoutHandler sets after how many minutes without a flow
+// an instance is stopped; zero keeps it running.
+func (h *Handler) SetInstanceIdleTimeoutHandler(c *gin.Context) {
+	var req struct {
+		Minutes int `json:"minutes"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.instanceManager.SetInstanceIdleTimeout(c.Param("id"), req.Minutes); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+// GetDevicesHandler lists the mobile devices instances can emulate.
+func (h *Handler) GetDevicesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"devices": model.DevicePresets()})
+}
+
+// Instance Group Handlers
+func (h *Handler) CreateInstanceGroupHandler(c *gin.Context) {
+	var group model.InstanceGroup
+	if err := bindJSON(c, &group); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := h.instanceManager.GetGroup(group.Name); err == nil {
+		respondError(c, http.StatusConflict, errors.New("instance group already exists"))
+		return
+	}
+
+	if err := h.instanceManager.SetGroup(group); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, group)
+}
+
+func (h *Handler) GetInstanceGroupsHandler(c *gin.Context) {
+	groups, err := h.instanceManager.GetGroups()
+	if err != nil {
+		h.log(c).Error("Failed to get instance groups", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, groups)
+}
+
+func (h *Handler) GetInstanceGroupHandler(c *gin.Context) {
+	group, err := h.instanceManager.GetGroup(c.Param("name"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, group)
+}
+
+func (h *Handler) UpdateInstanceGroupHandler(c *gin.Context) {
+	var group model.InstanceGroup
+	if err := bindJSON(c, &group); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	group.Name = c.Param("name")
+	if _, err := h.instanceManager.GetGroup(group.Name); err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+
+	if err := h.instanceManager.SetGroup(group); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, group)
+}
+
+func (h *Handler) DeleteInstanceGroupHandler(c *gin.Context) {
+	if err := h.instanceManager.DeleteGroup(c.Param("name")); err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+func (h *Handler) GetActionsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"actions": model.Actions()})
+}
+
+// RegisterRoutes registers all routes with the Gin engine
+func RegisterRoutes(r *gin.Engine, handler *Handler) {
+	// Middleware to inject a logger for the request into context
+	r.Use(handler.requestLogger)
+	r.Use(handler.rateLimit)
+	r.Use(handler.validateBody)
+	r.Use(handler.requestTimeout)
+
+	// Instance routes
+	r.POST("/api/v1/instances", handler.idempotent(handler.AddInstanceHandler))
+	r.GET("/api/v1/instances", handler.GetInstancesHandler)
+	r.DELETE("/api/v1/instances/:id", handler.DeleteInstanceHandler)
+	r.POST("/api/v1/instances/start", handler.StartInstancesHandler)
+	r.POST("/api/v1/instances/stop-all", handler.StopAllInstancesHandler)
+	r.POST("/api/v1/instances/bulk/create", handler.idempotent(handler.BulkCreateInstancesHandler))
+	r.POST("/api/v1/instances/bulk/tag", handler.BulkTagInstancesHandler)
+	r.POST("/api/v1/instances/bulk/start", handler.BulkStartInstancesHandler)
+	r.POST("/api/v1/instances/bulk/stop", handler.BulkStopInstancesHandler)
+	r.POST("/api/v1/instances/bulk/delete/plan", handler.BulkDeleteInstancesPlanHandler)
+	r.POST("/api/v1/instances/bulk/delete", handler.BulkDeleteInstancesHandler)
+	r.POST("/api/v1/instances/:id/stop", handler.StopInstanceHandler)
+	r.PUT("/api/v1/instances/:id/status", handler.UpdateInstanceStatusHandler)
+	r.GET("/api/v1/instances/:id/screenshot", handler.GetInstanceScreenshotHandler)
+	r.GET("/api/v1/instances/:id/websockets", handler.GetInstanceWebSocketsHandler)
+	r.GET("/api/v1/instances/:id/storage", handler.GetInstanceStorageHandler)
+	r.GET("/api/v1/instances/:id/queue", handler.GetInstanceQueueHandler)
+	r.PUT("/api/v1/instances/:id/permissions", handler.SetInstancePermissionsHandler)
+	r.PUT("/api/v1/instances/:id/http-auth", handler.SetInstanceHTTPAuthHandler)
+	r.PUT("/api/v1/instances/:id/login-profile", handler.SetInstanceLoginProfileHandler)
+	r.GET("/api/v1/instances/:id/login-failure", handler.GetInstanceLoginFailureHandler)
+	r.GET("/api/v1/instances/:id/cookies", handler.ExportCookiesHandler)
+	r.POST("/api/v1/instances/:id/cookies", handler.ImportCookiesHandler)
+	r.PUT("/api/v1/instances/:id/seed-cookies", handler.SetSeedCookiesHandler)
+	r.PUT("/api/v1/instances/:id/headers", handler.SetInstanceHeadersHandler)
+	r.PUT("/api/v1/instances/:id/driver", handler.SetInstanceDriverHandler)
+	r.PUT("/api/v1/instances/:id/device", handler.SetInstanceDeviceHandler)
+	r.PUT("/api/v1/instances/:id/idle-timeout", handler.SetInstanceIdleTimeoutHandler)
+	r.PUT("/api/v1/instances/:id/tags", handler.SetInstanceTagsHandler)
+	r.POST("/api/v1/instances/:id/snapshots", handler.TakeSnapshotHandler)
+	r.POST("/api/v1/instances/:id/snapshots/:name/restore", handler.RestoreSnapshotHandler)
+	r.GET("/api/v1/devices", handler.GetDevicesHandler)
+	r.GET("/api/v1/drivers", handler.GetDriversHandler)
+	r.GET("/api/v1/version", handler.VersionHandler)
+
+	// Login profile routes
+	r.POST("/api/v1/login-profiles", handler.CreateLoginProfileHandler)
+	r.GET("/api/v1/login-profiles", handler.GetLoginProfilesHandler)
+	r.GET("/api/v1/login-profiles/:id", handler.GetLoginProfileHandler)
+	r.PUT("/api/v1/login-profiles/:id", handler.UpdateLoginProfileHandler)
+	r.DELETE("/api/v1/login-profiles/:id", handler.DeleteLoginProfileHandler)
+
+	// Snapshot routes
+	r.GET("/api/v1/snapshots", handler.GetSnapshotsHandler)
+	r.GET("/api/v1/snapshots/:name", handler.GetSnapshotHandler)
+	r.DELETE("/api/v1/snapshots/:name", handler.DeleteSnapshotHandler)
+
+	// Instance template routes
+	r.POST("/api/v1/instance-templates", handler.CreateInstanceTemplateHandler)
+	r.GET("/api/v1/instance-templates", handler.GetInstanceTemplatesHandler)
+	r.GET("/api/v1/instance-templates/:id", handler.GetInstanceTemplateHandler)
+	r.PUT("/api/v1/instance-templates/:id", handler.UpdateInstanceTemplateHandler)
+	r.DELETE("/api/v1/instance-templates/:id", handler.DeleteInstanceTemplateHandler)
+
+	// Secret routes
+	r.GET("/api/v1/secrets", handler.GetSecretsHandler)
+	r.PUT("/api/v1/secrets/:name", handler.SetSecretHandler)
+	r.DELETE("/api/v1/secrets/:name", handler.DeleteSecretHandler)
+
+	// Instance group routes
+	r.POST("/api/v1/instance-groups", handler.CreateInstanceGroupHandler)
+	r.GET("/api/v1/instance-groups", handler.GetInstanceGroupsHandler)
+	r.GET("/api/v1/instance-groups/:name", handler.GetInstanceGroupHandler)
+	r.PUT("/api/v1/instance-groups/:name", handler.UpdateInstanceGroupHandler)
+	r.DELETE("/api/v1/instance-groups/:name", handler.DeleteInstanceGroupHandler)
+
+	// Flow routes
+	r.POST("/api/v1/flows", handler.idempotent(handler.CreateFlowHandler))
+	r.GET("/api/v1/flows", handler.GetFlowsHandler)
+	r.GET("/api/v1/flows/:id", handler.GetFlowHandler)
+	r.PUT("/api/v1/flows/:id", handler.UpdateFlowHandler)
+	r.PATCH("/api/v1/flows/:id", handler.UpdateFlowHandler)
+	r.DELETE("/api/v1/flows/:id", handler.DeleteFlowHandler)
+	r.POST("/api/v1/flows/:id/clone", handler.CloneFlowHandler)
+	r.GET("/api/v1/flows/:id/export", handler.ExportFlowHandler)
+	r.POST("/api/v1/flows/import", handler.ImportFlowHandler)
+	r.POST("/api/v1/flows/from-har", handler.ImportHARHandler)
+	r.POST("/api/v1/flows/from-curl", handler.ImportCurlHandler)
+	r.POST("/api/v1/flows/from-postman", handler.ImportPostmanHandler)
+	r.PUT("/api/v1/flows/:id/hooks", handler.SetFlowHooksHandler)
+	r.PUT("/api/v1/flows/:id/outputs", handler.SetFlowOutputsHandler)
+	r.PUT("/api/v1/flows/:id/notifications", handler.SetFlowNotificationsHandler)
+	r.PUT("/api/v1/flows/:id/schema", handler.SetFlowSchemaHandler)
+	r.GET("/api/v1/flows/:id/runs", handler.GetFlowRunsHandler)
+	r.GET("/api/v1/flows/:id/stats", handler.GetFlowStatsHandler)
+	r.GET("/api/v1/flows/:id/graph", handler.GetFlowGraphHandler)
+	r.POST("/api/v1/flows/:id/lint", handler.LintFlowHandler)
+	r.GET("/api/v1/flows/:id/history", handler.GetFlowHistoryHandler)
+	r.GET("/api/v1/flows/:id/history/latest", handler.GetLatestFlowHistoryHandler)
+	r.POST("/api/v1/flows/:id/dispatch", handler.idempotent(handler.DispatchFlowHandler))
+	r.POST("/api/v1/flows/:id/execute-anonymous", handler.idempotent(handler.ExecuteAnonymousHandler))
+	r.GET("/api/v1/flows/:id/baselines", handler.ListBaselinesHandler)
+	r.GET("/api/v1/flows/:id/baselines/:step", handler.GetBaselineHandler)
+	r.POST("/api/v1/flows/:id/baselines/:step/approve", handler.ApproveBaselineHandler)
+	r.DELETE("/api/v1/flows/:id/baselines/:step", handler.DeleteBaselineHandler)
+	r.GET("/api/v1/runs", handler.GetRunsHandler)
+	r.GET("/api/v1/runs/:id", handler.GetRunHandler)
+	r.GET("/api/v1/runs/:id/variables", handler.GetRunVariablesHandler)
+	r.GET("/api/v1/runs/:id/timeline", handler.GetRunTimelineHandler)
+	r.GET("/api/v1/runs/:id/findings", handler.GetRunFindingsHandler)
+	r.GET("/api/v1/runs/:id/visual", handler.GetRunVisualHandler)
+	r.POST("/api/v1/flows/execute", handler.idempotent(handler.ExecuteFlowsHandler))
+	r.POST("/api/v1/flows/:id/execute-sync", handler.ExecuteSyncHandler)
+	r.GET("/api/v1/actions", handler.GetActionsHandler)
+	r.GET("/api/v1/workspaces/:workspace/usage", handler.GetWorkspaceUsageHandler)
+
+	// Alert routes
+	r.POST("/api/v1/alerts", handler.CreateAlertRuleHandler)
+	r.GET("/api/v1/alerts", handler.GetAlertRulesHandler)
+	r.GET("/api/v1/alerts/:id", handler.GetAlertRuleHandler)
+	r.PUT("/api/v1/alerts/:id", handler.UpdateAlertRuleHandler)
+	r.DELETE("/api/v1/alerts/:id", handler.DeleteAlertRuleHandler)
+	r.POST("/api/v1/alerts/:id/mute", handler.MuteAlertRuleHandler)
+	r.POST("/api/v1/alerts/:id/unmute", handler.UnmuteAlertRuleHandler)
+	r.POST("/api/v1/alerts/:id/snooze", handler.SnoozeAlertRuleHandler)
+
+	// Trigger routes
+	r.POST("/api/v1/triggers", handler.CreateTriggerHandler)
+	r.GET("/api/v1/triggers", handler.GetTriggersHandler)
+	r.GET("/api/v1/triggers/:id", handler.GetTriggerHandler)
+	r.PUT("/api/v1/triggers/:id", handler.UpdateTriggerHandler)
+	r.DELETE("/api/v1/triggers/:id", handler.DeleteTriggerHandler)
+	r.POST("/api/v1/triggers/:token", handler.WebhookTriggerHandler)
+
+	// Admin routes
+	r.GET("/api/v1/admin/janitor", handler.GetJanitorStatsHandler)
+	r.POST("/api/v1/admin/janitor/purge", handler.PurgeHandler)
+	r.POST("/api/v1/admin/backup", handler.BackupHandler)
+	r.POST("/api/v1/admin/restore", handler.RestoreHandler)
+	r.GET("/api/v1/admin/pprof/:profile", handler.ProfileHandler)
+	r.GET("/api/v1/admin/contexts", handler.GetBrowserContextsHandler)
+	r.GET("/api/v1/admin/redis/keys", handler.GetRedisKeysHandler)
+	r.GET("/api/v1/admin/runs", handler.GetLiveRunsHandler)
+	r.POST("/api/v1/admin/selftest", handler.SelfTestHandler)
+	r.POST("/api/v1/admin/validate-config", handler.ValidateConfigHandler)
+	r.POST("/api/v1/admin/reconcile/plan", handler.ReconcilePlanHandler)
+	r.POST("/api/v1/admin/reconcile", handler.ReconcileHandler)
+
+	// Schedule routes
+	r.POST("/api/v1/schedules", handler.CreateScheduleHandler)
+	r.GET("/api/v1/schedules", handler.GetSchedulesHandler)
+	r.GET("/api/v1/schedules/leader", handler.GetSchedulerLeaderHandler)
+	r.GET("/api/v1/agents", handler.GetAgentsHandler)
+	r.GET("/api/v1/agents/:id", handler.GetAgentHandler)
+	r.POST("/api/v1/agents/:id/cordon", handler.CordonAgentHandler)
+	r.POST("/api/v1/agents/:id/uncordon", handler.UncordonAgentHandler)
+	r.POST("/api/v1/agents/:id/drain", handler.DrainAgentHandler)
+	r.GET("/api/v1/jobs/:id", handler.GetJobHandler)
+	r.GET("/api/v1/schedules/:id", handler.GetScheduleHandler)
+	r.PUT("/api/v1/schedules/:id", handler.UpdateScheduleHandler)
+	r.DELETE("/api/v1/schedules/:id", handler.DeleteScheduleHandler)
+	r.POST("/api/v1/calendars", handler.CreateCalendarHandler)
+	r.GET("/api/v1/calendars", handler.GetCalendarsHandler)
+	r.DELETE("/api/v1/calendars/:id", handler.DeleteCalendarHandler)
+
+	// Crawl routes
+	r.POST("/api/v1/crawls", handler.StartCrawlHandler)
+	r.GET("/api/v1/crawls", handler.GetCrawlsHandler)
+	r.GET("/api/v1/crawls/:id", handler.GetCrawlHandler)
+	r.GET("/api/v1/crawls/:id/inventory", handler.GetCrawlInventoryHandler)
+	r.GET("/api/v1/crawls/:id/requests", handler.ExportCrawlRequestsHandler)
+	r.GET("/api/v1/crawls/:id/findings", handler.GetCrawlFindingsHandler)
+	r.GET("/api/v1/crawls/:id/websockets", handler.GetCrawlWebSocketsHandler)
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"auto/apperr"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// IdempotencyTTL is how long a response is replayed for a repeated
+// Idempotency-Key.
+const IdempotencyTTL = 24 * time.Hour
+
+// idempotentResponse is what gets stored per key; a record without a
+// Status marks a request that is still in progress.
+type idempotentResponse struct {
+	Fingerprint string `json:"fingerprint"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// bodyRecorder keeps a copy of what the handler writes.
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotent makes a POST handler safe to retry: a request carrying an
+// Idempotency-Key that was already answered gets the stored response
+// instead of running again. Reusing a key with a different body is
+// rejected, as is a retry while the first request is still running.
+// Server errors are not stored so the client can retry them.
+func (h *Handler) idempotent(next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			next(c)
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(append([]byte(c.Request.Method+" "+c.FullPath()+"\n"), body...))
+		fingerprint := hex.EncodeToString(sum[:])
+
+		ctx := context.Background()
+		redisKey := "idempotency:" + c.FullPath() + ":" + key
+		pending, _ := json.Marshal(idempotentResponse{Fingerprint: fingerprint})
+		claimed, err := h.dbManager.Client.SetNX(ctx, redisKey, pending, IdempotencyTTL).Result()
+		if err != nil {
+			h.log(c).Error("Failed to claim idempotency key", zap.String("key", key), zap.Error(err))
+			respondError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		if !claimed {
+			var stored idempotentResponse
+			data, err := h.dbManager.Client.Get(ctx, redisKey).Bytes()
+			if err == nil {
+				err = json.Unmarshal(data, &stored)
+			}
+			switch {
+			case err != nil:
+				respondError(c, http.StatusInternalServerError, err)
+			case stored.Fingerprint != fingerprint:
+				respondError(c, http.StatusUnprocessableEntity, apperr.New(apperr.CodeIdempotencyKeyReused, "idempotency key was used with a different request"))
+			case stored.Status == 0:
+				respondError(c, http.StatusConflict, apperr.New(apperr.CodeRequestInProgress, "a request with this idempotency key is still in progress"))
+			default:
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(stored.Status, stored.ContentType, stored.Body)
+			}
+			return
+		}
+
+		recorder := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		// A handler that panics leaves no response to store, so the key
+		// is released for the retry before the panic goes on to the
+		// recovery middleware.
+		defer func() {
+			if r := recover(); r != nil {
+				h.dbManager.Client.Del(ctx, redisKey)
+				panic(r)
+			}
+		}()
+		next(c)
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			h.dbManager.Client.Del(ctx, redisKey)
+			return
+		}
+		data, err := json.Marshal(idempotentResponse{
+			Fingerprint: fingerprint,
+			Status:      status,
+			ContentType: recorder.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+		})
+		if err == nil {
+			err = h.dbManager.Client.Set(ctx, redisKey, data, IdempotencyTTL).Err()
+		}
+		if err != nil {
+			h.log(c).Error("Failed to store idempotent response", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"auto/logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// requestIDHeader carries the ID of a request. One sent by the client, such
+// as a proxy, is kept; otherwise one is generated. It is echoed back.
+const requestIDHeader = "X-Request-ID"
+
+// requestLogger gives each request a child logger with its request_id,
+// under "logger" in the gin context and in the context of the request.
+func (h *Handler) requestLogger(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if id == "" || len(id) > 128 {
+		id = uuid.New().String()
+	}
+	c.Header(requestIDHeader, id)
+	l := logger.ForRequest(h.logger, id)
+	c.Set("logger", l)
+	c.Request = c.Request.WithContext(logger.NewContext(c.Request.Context(), l))
+	c.Next()
+}
+
+// log returns the logger of the request.
+func (h *Handler) log(c *gin.Context) *zap.Logger {
+	if l, ok := c.Get("logger"); ok {
+		if l, ok := l.(*zap.Logger); ok {
+			return l
+		}
+	}
+	return h.logger
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+
+	"auto/model"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Login Profile Handlers
+func (h *Handler) CreateLoginProfileHandler(c *gin.Context) {
+	var req model.LoginProfile
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	profile, err := h.instanceManager.CreateLoginProfile(req)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+func (h *Handler) GetLoginProfilesHandler(c *gin.Context) {
+	profiles, err := h.instanceManager.GetLoginProfiles()
+	if err != nil {
+		h.log(c).Error("Failed to get login profiles", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, profiles)
+}
+
+func (h *Handler) GetLoginProfileHandler(c *gin.Context) {
+	profile, err := h.instanceManager.GetLoginProfile(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+func (h *Handler) UpdateLoginProfileHandler(c *gin.Context) {
+	var req model.LoginProfile
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	profile, err := h.instanceManager.UpdateLoginProfile(c.Param("id"), req)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+func (h *Handler) DeleteLoginProfileHandler(c *gin.Context) {
+	if err := h.instanceManager.DeleteLoginProfile(c.Param("id")); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// GetInstanceLoginFailureHandler returns the last failed login of an
+// instance, with a screenshot of the page it ended on.
+func (h *Handler) GetInstanceLoginFailureHandler(c *gin.Context) {
+	failure, err := h.instanceManager.GetLoginFailure(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, failure)
+}
+
+// SetInstanceLoginProfileHandler sets the login profile of an instance; an
+// empty login_profile_id makes it use its own selectors again.
+func (h *Handler) SetInstanceLoginProfileHandler(c *gin.Context) {
+	var req struct {
+		LoginProfileID string `json:"login_profile_id"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.instanceManager.SetInstanceLoginProfile(c.Param("id"), req.LoginProfileID); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
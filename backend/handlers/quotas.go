@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"auto/quota"
+
+	"github.com/gin-gonic/gin"
+)
+
+// workspaceHeader names the workspace a request acts for; without it, the
+// default workspace. Instances created by the request belong to it, and
+// their runs with them.
+const workspaceHeader = "X-Workspace"
+
+// requestWorkspace returns the workspace of a request.
+func requestWorkspace(c *gin.Context) (string, error) {
+	name := strings.TrimSpace(c.GetHeader(workspaceHeader))
+	if name == "" {
+		return quota.DefaultWorkspace, nil
+	}
+	return name, quota.ValidateName(name)
+}
+
+// countInstances counts the instances of workspace.
+func (h *Handler) countInstances(workspace string) int {
+	n := 0
+	for _, instance := range h.instanceManager.GetInstances() {
+		if quota.Name(instance.Settings().Workspace) == workspace {
+			n++
+		}
+	}
+	return n
+}
+
+// flowWorkspace returns the workspace the runs of a flow belong to: that
+// of its instance.
+func (h *Handler) flowWorkspace(flowID string) string {
+	f, err := h.flowManager.GetFlow(flowID)
+	if err != nil {
+		return quota.DefaultWorkspace
+	}
+	instance, err := h.instanceManager.GetInstance(f.GetInstanceID())
+	if err != nil {
+		return quota.DefaultWorkspace
+	}
+	return quota.Name(instance.Settings().Workspace)
+}
+
+// checkRunQuota answers the request with the quota error and returns false
+// when workspace may not start a run.
+func (h *Handler) checkRunQuota(c *gin.Context, workspace string) bool {
+	if err := h.quotas.CheckRun(c.Request.Context(), workspace, h.countInstances(workspace)); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return false
+	}
+	return true
+}
+
+// GetWorkspaceUsageHandler returns what a workspace uses, next to its
+// quotas.
+func (h *Handler) GetWorkspaceUsageHandler(c *gin.Context) {
+	workspace := c.Param("workspace")
+	if err := quota.ValidateName(workspace); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	usage, err := h.quotas.Usage(c.Request.Context(), workspace, h.countInstances(workspace))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, usage)
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"auto/apperr"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// RateLimit is a token bucket refilled with Rate tokens a second that holds
+// at most Burst of them. Every request takes one.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimits configures the buckets of the API. Each client, told apart by
+// its API key when it is one of Keys or else by its address, draws from one
+// bucket per limited route and from one bucket for all other routes. Routes are keyed by
+// method and path as registered, e.g. "GET /api/v1/instances/:id/screenshot";
+// Keys overrides Default for the clients of some API keys. Other keys are
+// not checked by the API, so a client cannot get buckets of its own by
+// making them up. A zero limit is unlimited.
+type RateLimits struct {
+	Default RateLimit
+	Routes  map[string]RateLimit
+	Keys    map[string]RateLimit
+}
+
+// takeToken takes a token from the bucket KEYS[1], refilled at ARGV[1]
+// tokens a second up to ARGV[2], and returns whether there was one and how
+// many are left. The clock of Redis is used so servers agree on it.
+var takeToken = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call("TIME")
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1]) or burst
+local ts = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}`)
+
+// ParseRateLimit parses a limit such as "2/s", "100/m:20" or "5000/h",
+// with an optional burst after the colon. A bare number is per second and
+// the burst defaults to one second worth of tokens, at least one.
+func ParseRateLimit(s string) (RateLimit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return RateLimit{}, nil
+	}
+	spec, burst, hasBurst := strings.Cut(s, ":")
+	count, unit, _ := strings.Cut(spec, "/")
+	n, err := strconv.ParseFloat(strings.TrimSpace(count), 64)
+	if err != nil || n < 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q", s)
+	}
+	var limit RateLimit
+	switch strings.TrimSpace(unit) {
+	case "", "s":
+		limit.Rate = n
+	case "m":
+		limit.Rate = n / 60
+	case "h":
+		limit.Rate = n / 3600
+	default:
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: unit must be s, m or h", s)
+	}
+	limit.Burst = int(math.Max(1, math.Ceil(limit.Rate)))
+	if hasBurst {
+		if limit.Burst, err = strconv.Atoi(strings.TrimSpace(burst)); err != nil || limit.Burst < 1 {
+			return RateLimit{}, fmt.Errorf("invalid rate limit %q: burst must be a positive integer", s)
+		}
+	}
+	return limit, nil
+}
+
+// ParseRateLimits builds the limits from a default limit and lists of
+// "<method> <path>=<limit>" and "<API key>=<limit>" entries.
+func ParseRateLimits(def string, routes, keys []string) (RateLimits, error) {
+	var limits RateLimits
+	var err error
+	if limits.Default, err = ParseRateLimit(def); err != nil {
+		return limits, err
+	}
+	if limits.Routes, err = parseRateRules(routes); err != nil {
+		return limits, err
+	}
+	for route := range limits.Routes {
+		if method, path, ok := strings.Cut(route, " "); !ok || method == "" || !strings.HasPrefix(path, "/") {
+			return limits, fmt.Errorf("invalid rate limited route %q: want \"<method> <path>\"", route)
+		}
+	}
+	limits.Keys, err = parseRateRules(keys)
+	return limits, err
+}
+
+func parseRateRules(rules []string) (map[string]RateLimit, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	limits := make(map[string]RateLimit, len(rules))
+	for _, rule := range rules {
+		i := strings.LastIndex(rule, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid rate limit rule %q: want \"<name>=<limit>\"", rule)
+		}
+		limit, err := ParseRateLimit(rule[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		limits[strings.TrimSpace(rule[:i])] = limit
+	}
+	return limits, nil
+}
+
+// rateLimit takes a token from the bucket of the request and answers 429
+// when it is empty. It sets the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers of the IETF draft, the latter in seconds until the
+// bucket is full again. When Redis cannot be reached requests go through.
+func (h *Handler) rateLimit(c *gin.Context) {
+	key := apiKey(c.Request)
+	keyLimit, known := h.rateLimits.Keys[key]
+	if !known {
+		key = ""
+	}
+	route := c.Request.Method + " " + c.FullPath()
+	limit, bucket := h.rateLimits.Routes[route], route
+	if limit.Rate == 0 {
+		limit, bucket = h.rateLimits.Default, ""
+		if known {
+			limit = keyLimit
+		}
+	}
+	if limit.Rate == 0 {
+		c.Next()
+		return
+	}
+
+	client := "ip:" + c.ClientIP()
+	if key != "" {
+		// Keep API keys themselves out of Redis.
+		sum := sha256.Sum256([]byte(key))
+		client = "key:" + hex.EncodeToString(sum[:8])
+	}
+	res, err := takeToken.Run(c.Request.Context(), h.dbManager.Client,
+		[]string{"rate_limit:" + client + ":" + bucket}, limit.Rate, limit.Burst).Slice()
+	if err != nil || len(res) != 2 {
+		h.log(c).Warn("Failed to check rate limit", zap.String("route", route), zap.Error(err))
+		c.Next()
+		return
+	}
+	allowed, _ := res[0].(int64)
+	tokens, _ := strconv.ParseFloat(fmt.Sprint(res[1]), 64)
+
+	c.Header("RateLimit-Limit", strconv.Itoa(limit.Burst))
+	c.Header("RateLimit-Remaining", strconv.Itoa(int(tokens)))
+	c.Header("RateLimit-Reset", strconv.Itoa(int(math.Ceil((float64(limit.Burst)-tokens)/limit.Rate))))
+	if allowed != 1 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil((1-tokens)/limit.Rate))))
+		respondError(c, http.StatusTooManyRequests, apperr.New(apperr.CodeRateLimited, "rate limit exceeded"))
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// apiKey returns the API key of a request, sent in an "Authorization:
+// Bearer" or "X-API-Key" header, or "".
+func apiKey(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http"
+
+	"auto/apperr"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReadOnly serves a read-only mirror of the API: it answers every request
+// but GET, HEAD and OPTIONS with 405, so dashboards can be exposed without
+// exposing a way to change state.
+func ReadOnly(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		c.Next()
+		return
+	}
+	c.Header("Allow", "GET, HEAD, OPTIONS")
+	respondError(c, http.StatusMethodNotAllowed, apperr.New(apperr.CodeReadOnly, "this server is read-only"))
+	c.Abort()
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+
+	"auto/reconcile"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func (h *Handler) reconciler() *reconcile.Reconciler {
+	return reconcile.New(h.dbManager.Client, h.dbManager, h.instanceManager, h.flowManager, h.scheduler, h.logger)
+}
+
+// ReconcilePlanHandler lists the changes that would bring instances, flows
+// and schedules in line with the spec of the request, with the hash that
+// ReconcileHandler takes to make them.
+func (h *Handler) ReconcilePlanHandler(c *gin.Context) {
+	if _, plan, ok := h.planSpec(c); ok {
+		c.JSON(http.StatusOK, plan)
+	}
+}
+
+// ReconcileHandler brings instances, flows and schedules in line with the
+// spec of the request, provided ?plan_hash= is the hash of the plan that
+// ReconcilePlanHandler gives for the same spec.
+func (h *Handler) ReconcileHandler(c *gin.Context) {
+	r, plan, ok := h.planSpec(c)
+	if !ok {
+		return
+	}
+
+	failed, err := r.Apply(c.Request.Context(), plan, c.Query("plan_hash"))
+	if err != nil {
+		respondError(c, http.StatusConflict, err)
+		return
+	}
+	status := http.StatusOK
+	if failed > 0 {
+		h.log(c).Error("Reconciliation incomplete", zap.Int("failed", failed))
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, gin.H{"changes": plan.Changes, "applied": len(plan.Changes) - failed, "failed": failed})
+}
+
+// planSpec plans the spec of the body, in JSON or YAML, or of the "spec"
+// form file.
+func (h *Handler) planSpec(c *gin.Context) (*reconcile.Reconciler, *reconcile.Plan, bool) {
+	body, name := c.Request.Body, ""
+	if file, err := c.FormFile("spec"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return nil, nil, false
+		}
+		defer f.Close()
+		body, name = f, file.Filename
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return nil, nil, false
+	}
+	spec, err := reconcile.ParseSpec(data, name, c.ContentType())
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return nil, nil, false
+	}
+
+	r := h.reconciler()
+	plan, err := r.Plan(c.Request.Context(), spec)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return nil, nil, false
+	}
+	return r, plan, true
+}
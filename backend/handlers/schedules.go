@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"auto/scheduler"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Schedule Handlers
+func (h *Handler) CreateScheduleHandler(c *gin.Context) {
+	var req scheduler.Schedule
+	if err := bindJSON(c, &req); err != nil {
+		h.log(c).Error("Failed to bind JSON", zap.Error(err))
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	schedule, err := h.scheduler.CreateSchedule(req)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+func (h *Handler) GetSchedulesHandler(c *gin.Context) {
+	schedules, err := h.scheduler.GetSchedules()
+	if err != nil {
+		h.log(c).Error("Failed to get schedules", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, schedules)
+}
+
+func (h *Handler) GetScheduleHandler(c *gin.Context) {
+	schedule, err := h.scheduler.GetSchedule(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, schedule)
+}
+
+func (h *Handler) UpdateScheduleHandler(c *gin.Context) {
+	var req scheduler.Schedule
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	schedule, err := h.scheduler.UpdateSchedule(c.Param("id"), req)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+func (h *Handler) DeleteScheduleHandler(c *gin.Context) {
+	if err := h.scheduler.DeleteSchedule(c.Param("id")); err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// Calendar Handlers
+func (h *Handler) CreateCalendarHandler(c *gin.Context) {
+	var req struct {
+		Name  string   `json:"name"`
+		Dates []string `json:"dates"`
+		ICal  string   `json:"ical"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.Dates) == 0 && req.ICal == "" {
+		respondError(c, http.StatusBadRequest, errors.New("dates or ical is required"))
+		return
+	}
+
+	calendar, err := h.scheduler.CreateCalendar(req.Name, req.Dates, req.ICal)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, calendar)
+}
+
+func (h *Handler) GetCalendarsHandler(c *gin.Context) {
+	calendars, err := h.scheduler.GetCalendars()
+	if err != nil {
+		h.log(c).Error("Failed to get calendars", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, calendars)
+}
+
+func (h *Handler) DeleteCalendarHandler(c *gin.Context) {
+	if err := h.scheduler.DeleteCalendar(c.Param("id")); err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// GetSchedulerLeaderHandler tells which server triggers the schedules and
+// whether it is this one.
+func (h *Handler) GetSchedulerLeaderHandler(c *gin.Context) {
+	leader, err := h.scheduler.Leader()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"leader": leader, "is_leader": h.scheduler.IsLeader()})
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Secret Handlers
+
+// GetSecretsHandler lists the names of the stored secrets; their values
+// are never returned.
+func (h *Handler) GetSecretsHandler(c *gin.Context) {
+	names, err := h.secrets.Names(c.Request.Context())
+	if err != nil {
+		h.log(c).Error("Failed to list secrets", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"secrets": names})
+}
+
+func (h *Handler) SetSecretHandler(c *gin.Context) {
+	var req struct {
+		Value string `json:"value"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if req.Value == "" {
+		respondError(c, http.StatusBadRequest, errors.New("value is required"))
+		return
+	}
+	name := c.Param("name")
+	if err := h.secrets.Set(c.Request.Context(), name, req.Value); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"name": name})
+}
+
+func (h *Handler) DeleteSecretHandler(c *gin.Context) {
+	if err := h.secrets.Delete(c.Request.Context(), c.Param("name")); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// TakeSnapshotHandler stores the cookies, storage and URL of a running
+// instance under the name of the body.
+func (h *Handler) TakeSnapshotHandler(c *gin.Context) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	snapshot, err := h.instanceManager.TakeSnapshot(c.Request.Context(), c.Param("id"), req.Name)
+	if err != nil {
+		h.log(c).Error("Failed to take snapshot", zap.String("instanceID", c.Param("id")), zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusCreated, snapshot)
+}
+
+// RestoreSnapshotHandler puts a running instance in the state of a
+// snapshot, which any instance may have been taken from.
+func (h *Handler) RestoreSnapshotHandler(c *gin.Context) {
+	if err := h.instanceManager.RestoreSnapshot(c.Request.Context(), c.Param("id"), c.Param("name")); err != nil {
+		h.log(c).Error("Failed to restore snapshot", zap.String("instanceID", c.Param("id")), zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "restored"})
+}
+
+func (h *Handler) GetSnapshotsHandler(c *gin.Context) {
+	snapshots, err := h.instanceManager.ListSnapshots(c.Request.Context())
+	if err != nil {
+		h.log(c).Error("Failed to get snapshots", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, snapshots)
+}
+
+func (h *Handler) GetSnapshotHandler(c *gin.Context) {
+	snapshot, err := h.instanceManager.GetSnapshot(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, snapshot)
+}
+
+func (h *Handler) DeleteSnapshotHandler(c *gin.Context) {
+	if err := h.instanceManager.DeleteSnapshot(c.Request.Context(), c.Param("name")); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+
+	"auto/model"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Instance Template Handlers
+func (h *Handler) CreateInstanceTemplateHandler(c *gin.Context) {
+	var req model.InstanceTemplate
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	template, err := h.instanceManager.CreateInstanceTemplate(req)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, template)
+}
+
+func (h *Handler) GetInstanceTemplatesHandler(c *gin.Context) {
+	templates, err := h.instanceManager.GetInstanceTemplates()
+	if err != nil {
+		h.log(c).Error("Failed to get instance templates", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, templates)
+}
+
+// GetInstanceTemplateHandler returns a template with the names of the vars
+// its URL takes.
+func (h *Handler) GetInstanceTemplateHandler(c *gin.Context) {
+	template, err := h.instanceManager.GetInstanceTemplate(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"template": template, "vars": template.Vars()})
+}
+
+func (h *Handler) UpdateInstanceTemplateHandler(c *gin.Context) {
+	var req model.InstanceTemplate
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	template, err := h.instanceManager.UpdateInstanceTemplate(c.Param("id"), req)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, template)
+}
+
+func (h *Handler) DeleteInstanceTemplateHandler(c *gin.Context) {
+	if err := h.instanceManager.DeleteInstanceTemplate(c.Param("id")); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
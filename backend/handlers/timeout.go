@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"auto/apperr"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestTimeouts bounds how long the handlers of the API may take. Routes
+// are keyed by method and path as registered, e.g.
+// "GET /api/v1/instances/:id/screenshot", and override Default. A zero
+// timeout is unbounded.
+type RequestTimeouts struct {
+	Default time.Duration
+	Routes  map[string]time.Duration
+}
+
+// ParseRequestTimeouts builds the timeouts from a default duration, such
+// as "30s", and a list of "<method> <path>=<duration>" entries.
+func ParseRequestTimeouts(def string, routes []string) (RequestTimeouts, error) {
+	var timeouts RequestTimeouts
+	var err error
+	if timeouts.Default, err = parseRequestTimeout(def); err != nil {
+		return timeouts, err
+	}
+	if len(routes) == 0 {
+		return timeouts, nil
+	}
+	timeouts.Routes = make(map[string]time.Duration, len(routes))
+	for _, rule := range routes {
+		i := strings.LastIndex(rule, "=")
+		if i < 0 {
+			return timeouts, fmt.Errorf("invalid request timeout rule %q: want \"<method> <path>=<duration>\"", rule)
+		}
+		route := strings.TrimSpace(rule[:i])
+		if method, path, ok := strings.Cut(route, " "); !ok || method == "" || !strings.HasPrefix(path, "/") {
+			return timeouts, fmt.Errorf("invalid timed out route %q: want \"<method> <path>\"", route)
+		}
+		if timeouts.Routes[route], err = parseRequestTimeout(rule[i+1:]); err != nil {
+			return timeouts, err
+		}
+	}
+	return timeouts, nil
+}
+
+func parseRequestTimeout(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid request timeout %q", s)
+	}
+	return d, nil
+}
+
+// requestTimeout gives the request context the deadline of its route.
+// Handlers pass that context on to what they wait for, so a slow browser
+// or database gives up when time is up; when a handler returns past the
+// deadline without having answered, the request gets 504. WebSocket
+// upgrades are left alone, their connections outlive any timeout.
+func (h *Handler) requestTimeout(c *gin.Context) {
+	timeout, ok := h.timeouts.Routes[c.Request.Method+" "+c.FullPath()]
+	if !ok {
+		timeout = h.timeouts.Default
+	}
+	if timeout <= 0 || strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+		c.Next()
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+
+	if ctx.Err() == context.DeadlineExceeded && !c.Writer.Written() {
+		respondError(c, http.StatusGatewayTimeout, apperr.New(apperr.CodeTimeout, fmt.Sprintf("request took longer than %s", timeout)))
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+
+	"auto/trigger"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Trigger Handlers
+func (h *Handler) CreateTriggerHandler(c *gin.Context) {
+	var req trigger.Trigger
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	t, err := h.triggers.CreateTrigger(req)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, t)
+}
+
+func (h *Handler) GetTriggersHandler(c *gin.Context) {
+	triggers, err := h.triggers.GetTriggers()
+	if err != nil {
+		h.log(c).Error("Failed to get triggers", zap.Error(err))
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, triggers)
+}
+
+func (h *Handler) GetTriggerHandler(c *gin.Context) {
+	t, err := h.triggers.GetTrigger(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, t.Redacted())
+}
+
+func (h *Handler) UpdateTriggerHandler(c *gin.Context) {
+	var req trigger.Trigger
+	if err := bindJSON(c, &req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	t, err := h.triggers.UpdateTrigger(c.Param("id"), req)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, t)
+}
+
+func (h *Handler) DeleteTriggerHandler(c *gin.Context) {
+	if err := h.triggers.DeleteTrigger(c.Param("id")); err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// WebhookTriggerHandler starts the flow of the webhook trigger named by
+// the token in the URL. The body is any JSON payload; it is read raw so
+// its signature can be checked.
+func (h *Handler) WebhookTriggerHandler(c *gin.Context) {
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, decodeError(err))
+		return
+	}
+
+	t, params, err := h.triggers.Webhook(c.Param("token"), payload, c.GetHeader(trigger.SignatureHeader))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":     "accepted",
+		"trigger_id": t.ID,
+		"flow_id":    t.FlowID,
+		"params":     params,
+	})
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"auto/apperr"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Default request size limits.
+const (
+	DefaultMaxBodyBytes   = 1 << 20
+	DefaultMaxUploadBytes = 256 << 20
+)
+
+// BodyLimits bounds the size of request bodies. Upload routes take
+// archives instead of JSON and get the larger limit.
+type BodyLimits struct {
+	MaxBody   int64
+	MaxUpload int64
+}
+
+// uploadRoutes accept a non-JSON body, or recordings as large as uploads.
+var uploadRoutes = map[string]bool{
+	"/api/v1/admin/reconcile":      true,
+	"/api/v1/admin/reconcile/plan": true,
+	"/api/v1/admin/restore":        true,
+	"/api/v1/flows/from-har":       true,
+	"/api/v1/flows/from-postman":   true,
+}
+
+// validateBody limits the size of the body of POST, PUT and PATCH requests
+// and requires it to be JSON except on upload routes.
+func (h *Handler) validateBody(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		c.Next()
+		return
+	}
+
+	upload := uploadRoutes[c.FullPath()]
+	limit := h.limits.MaxBody
+	if upload {
+		limit = h.limits.MaxUpload
+	}
+	if limit > 0 {
+		if c.Request.ContentLength > limit {
+			respondError(c, http.StatusRequestEntityTooLarge, tooLarge(limit))
+			c.Abort()
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	}
+
+	if !upload && c.Request.ContentLength != 0 && !isJSON(c.ContentType()) {
+		respondError(c, http.StatusUnsupportedMediaType, apperr.New(apperr.CodeUnsupportedMediaType,
+			fmt.Sprintf("content type %q is not supported, use application/json", c.ContentType())))
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func tooLarge(limit int64) *apperr.Error {
+	return apperr.New(apperr.CodePayloadTooLarge, fmt.Sprintf("request body exceeds %d bytes", limit))
+}
+
+// bindJSON decodes the request body into obj, rejecting fields obj does
+// not have and anything after the JSON value.
+func bindJSON(c *gin.Context, obj interface{}) error {
+	dec := json.NewDecoder(c.Request.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(obj); err != nil {
+		return decodeError(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return apperr.New(apperr.CodeInvalidRequest, "request body must contain a single JSON value")
+	}
+	return nil
+}
+
+// decodeError turns a JSON decoding failure into a client error that says
+// what was wrong.
+func decodeError(err error) error {
+	var maxErr *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &maxErr):
+		return tooLarge(maxErr.Limit)
+	case errors.Is(err, io.EOF):
+		return apperr.New(apperr.CodeInvalidRequest, "request body is empty")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return apperr.New(apperr.CodeInvalidRequest, "request body is truncated JSON")
+	case errors.As(err, &syntaxErr):
+		return apperr.New(apperr.CodeInvalidRequest, fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset))
+	case errors.As(err, &typeErr):
+		e := apperr.New(apperr.CodeInvalidRequest, fmt.Sprintf("field %q must be %s", typeErr.Field, typeErr.Type))
+		e.Details = map[string]interface{}{"field": typeErr.Field}
+		return e
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no error type for unknown fields.
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		e := apperr.New(apperr.CodeInvalidRequest, fmt.Sprintf("unknown field %q", field))
+		e.Details = map[string]interface{}{"field": field}
+		return e
+	default:
+		return apperr.Wrap(apperr.CodeInvalidRequest, err)
+	}
+}
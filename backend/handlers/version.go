@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"auto/model"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// BuildRevision and BuildDate describe the build of the server. Builds
+// without VCS information, such as those of a Docker context without .git,
+// set them with -ldflags "-X auto/handlers.BuildRevision=...".
+var (
+	BuildRevision string
+	BuildDate     string
+)
+
+// versionModules are the dependencies whose versions are reported: they
+// decide how browsers are driven.
+var versionModules = []string{"github.com/chromedp/chromedp", "github.com/chromedp/cdproto"}
+
+// browserVersionTimeout bounds the launch of the browser asked for its
+// version.
+const browserVersionTimeout = 15 * time.Second
+
+// VersionHandler reports the build of the server, the versions of the
+// browser libraries it uses and the version of the Chrome instances launch,
+// with the error that kept it from being found out, if any.
+func (h *Handler) VersionHandler(c *gin.Context) {
+	build := gin.H{"revision": BuildRevision, "date": BuildDate, "go": runtime.Version()}
+	deps := gin.H{}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		build["version"] = info.Main.Version
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && BuildRevision == "":
+				build["revision"] = s.Value
+			case s.Key == "vcs.time" && BuildDate == "":
+				build["date"] = s.Value
+			case s.Key == "vcs.modified":
+				build["modified"] = s.Value == "true"
+			}
+		}
+		for _, dep := range info.Deps {
+			for _, path := range versionModules {
+				if dep.Path == path {
+					deps[path] = dep.Version
+				}
+			}
+		}
+	}
+
+	resp := gin.H{"build": build, "dependencies": deps}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), browserVersionTimeout)
+	defer cancel()
+	browser, err := model.LocalBrowserVersion(ctx)
+	if err != nil {
+		h.log(c).Warn("Failed to find out the browser version", zap.Error(err))
+		resp["browser_error"] = err.Error()
+	} else {
+		resp["browser"] = browser
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Visual Regression Handlers
+func (h *Handler) ListBaselinesHandler(c *gin.Context) {
+	baselines, err := h.flowManager.ListBaselines(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, baselines)
+}
+
+// GetBaselineHandler answers with the baseline image of a step as a PNG.
+func (h *Handler) GetBaselineHandler(c *gin.Context) {
+	baseline, err := h.flowManager.GetBaseline(c.Param("id"), c.Param("step"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.Data(http.StatusOK, "image/png", baseline.Image)
+}
+
+// ApproveBaselineHandler makes the screenshot a run took at a step the
+// baseline of that step.
+func (h *Handler) ApproveBaselineHandler(c *gin.Context) {
+	var req struct {
+		RunID string `json:"run_id"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		h.log(c).Error("Failed to bind JSON", zap.Error(err))
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if req.RunID == "" {
+		respondError(c, http.StatusBadRequest, errors.New("run_id is required"))
+		return
+	}
+
+	baseline, err := h.flowManager.ApproveBaseline(c.Param("id"), c.Param("step"), req.RunID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	baseline.Image = nil
+	c.JSON(http.StatusOK, baseline)
+}
+
+func (h *Handler) DeleteBaselineHandler(c *gin.Context) {
+	if err := h.flowManager.DeleteBaseline(c.Param("id"), c.Param("step")); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+func (h *Handler) GetRunVisualHandler(c *gin.Context) {
+	v, err := h.flowManager.GetRunVisual(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, v)
+}
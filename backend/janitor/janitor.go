@@ -0,0 +1,221 @@
+// Package janitor keeps the disk usage of long-running deployments in
+// check by deleting old screenshots, artifacts, downloads, crawl output and
+// browser profiles according to retention policies.
+package janitor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultInterval is how often the policies are applied.
+const DefaultInterval = time.Hour
+
+// Policy says how long the entries directly inside Dir that match Pattern
+// are kept. Entries older than MaxAge are removed, then the oldest ones
+// until they take up at most MaxSize bytes; zero disables either limit.
+// Entries that are directories count with everything below them. Keep, if
+// set, protects entries that are still in use.
+type Policy struct {
+	Name    string                 `json:"name"`
+	Dir     string                 `json:"dir"`
+	Pattern string                 `json:"pattern,omitempty"`
+	MaxAge  time.Duration          `json:"max_age"`
+	MaxSize int64                  `json:"max_size"`
+	Keep    func(path string) bool `json:"-"`
+}
+
+// Result is what applying one policy removed.
+type Result struct {
+	Policy  string   `json:"policy"`
+	Removed int      `json:"removed"`
+	Bytes   int64    `json:"bytes"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// PolicyStats accumulates the results of a policy since startup.
+type PolicyStats struct {
+	Removed int64 `json:"removed"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// Stats describes the space reclaimed since startup.
+type Stats struct {
+	Runs           int64                   `json:"runs"`
+	LastRun        time.Time               `json:"last_run,omitempty"`
+	FilesRemoved   int64                   `json:"files_removed"`
+	BytesReclaimed int64                   `json:"bytes_reclaimed"`
+	Policies       map[string]*PolicyStats `json:"policies"`
+	LastResults    []Result                `json:"last_results"`
+}
+
+type Janitor struct {
+	policies []Policy
+	logger   *zap.Logger
+	mu       sync.Mutex
+	stats    Stats
+}
+
+func New(policies []Policy, logger *zap.Logger) *Janitor {
+	return &Janitor{
+		policies: policies,
+		logger:   logger,
+		stats:    Stats{Policies: make(map[string]*PolicyStats)},
+	}
+}
+
+// Policies returns the configured policies.
+func (j *Janitor) Policies() []Policy {
+	return j.policies
+}
+
+// Start applies the policies every interval until ctx is done.
+func (j *Janitor) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				j.Purge(nil, 0)
+			}
+		}
+	}()
+}
+
+// Purge applies the named policies, or all of them when names is empty.
+// A positive maxAge overrides the age limit of the policies for this run,
+// to free space right away.
+func (j *Janitor) Purge(names []string, maxAge time.Duration) []Result {
+	wanted := make(map[string]bool, len(names))
+	for _, n := range names {
+		wanted[n] = true
+	}
+
+	var results []Result
+	for _, p := range j.policies {
+		if len(wanted) > 0 && !wanted[p.Name] {
+			continue
+		}
+		if maxAge > 0 {
+			p.MaxAge = maxAge
+		}
+		r := apply(p, time.Now())
+		if r.Removed > 0 || len(r.Errors) > 0 {
+			j.logger.Info("Janitor cleaned up",
+				zap.String("policy", r.Policy),
+				zap.Int("removed", r.Removed),
+				zap.Int64("bytes", r.Bytes),
+				zap.Strings("errors", r.Errors))
+		}
+		results = append(results, r)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.stats.Runs++
+	j.stats.LastRun = time.Now()
+	j.stats.LastResults = results
+	for _, r := range results {
+		ps, ok := j.stats.Policies[r.Policy]
+		if !ok {
+			ps = &PolicyStats{}
+			j.stats.Policies[r.Policy] = ps
+		}
+		ps.Removed += int64(r.Removed)
+		ps.Bytes += r.Bytes
+		j.stats.FilesRemoved += int64(r.Removed)
+		j.stats.BytesReclaimed += r.Bytes
+	}
+	return results
+}
+
+// Stats returns a copy of the cleanup statistics.
+func (j *Janitor) Stats() Stats {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := j.stats
+	s.Policies = make(map[string]*PolicyStats, len(j.stats.Policies))
+	for name, ps := range j.stats.Policies {
+		copied := *ps
+		s.Policies[name] = &copied
+	}
+	s.LastResults = append([]Result{}, j.stats.LastResults...)
+	return s
+}
+
+type entry struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+func apply(p Policy, now time.Time) Result {
+	r := Result{Policy: p.Name}
+	pattern := p.Pattern
+	if pattern == "" {
+		pattern = "*"
+	}
+	paths, err := filepath.Glob(filepath.Join(p.Dir, pattern))
+	if err != nil {
+		r.Errors = append(r.Errors, err.Error())
+		return r
+	}
+
+	var entries []entry
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil {
+			continue
+		}
+		if p.Keep != nil && p.Keep(path) {
+			continue
+		}
+		entries = append(entries, entry{path: path, modTime: info.ModTime(), size: diskUsage(path, info)})
+	}
+	// Oldest first, so the size limit removes the oldest entries.
+	sort.Slice(entries, func(i, k int) bool { return entries[i].modTime.Before(entries[k].modTime) })
+
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+	for _, e := range entries {
+		expired := p.MaxAge > 0 && now.Sub(e.modTime) > p.MaxAge
+		oversize := p.MaxSize > 0 && total > p.MaxSize
+		if !expired && !oversize {
+			continue
+		}
+		if err := os.RemoveAll(e.path); err != nil {
+			r.Errors = append(r.Errors, err.Error())
+			continue
+		}
+		total -= e.size
+		r.Removed++
+		r.Bytes += e.size
+	}
+	return r
+}
+
+// diskUsage returns the size of a file, or of everything below a directory.
+func diskUsage(path string, info os.FileInfo) int64 {
+	if !info.IsDir() {
+		return info.Size()
+	}
+	var size int64
+	filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size
+}
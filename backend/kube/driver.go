@@ -0,0 +1,206 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"auto/model"
+
+	"github.com/chromedp/chromedp"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// DriverName is the browser driver instances select to run in a pod.
+const DriverName = "kubernetes"
+
+// Labels of the pods the executor starts. labelNode names the server that
+// started a pod, so it can remove the pods it left behind when it died.
+const (
+	labelManaged = "app.kubernetes.io/managed-by"
+	labelNode    = "umba-node"
+	managedBy    = "umba"
+)
+
+// Config configures the executor. PodTemplate is a pod manifest, in YAML or
+// JSON, whose browser serves the DevTools protocol on CDPPort on all
+// addresses, e.g. chrome --headless --remote-debugging-address=0.0.0.0
+// --remote-debugging-port=9222.
+type Config struct {
+	Kubeconfig   string
+	Namespace    string
+	PodTemplate  string
+	CDPPort      int
+	ReadyTimeout time.Duration
+}
+
+// Executor starts a pod from a template for every browser, waits until it
+// is ready and deletes it when the browser is no longer needed.
+type Executor struct {
+	client       *Client
+	template     map[string]interface{}
+	cdpPort      int
+	readyTimeout time.Duration
+	node         string
+	logger       *zap.Logger
+}
+
+func NewExecutor(cfg Config, logger *zap.Logger) (*Executor, error) {
+	client, err := NewClient(cfg.Kubeconfig, cfg.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(cfg.PodTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pod template: %w", err)
+	}
+	var template map[string]interface{}
+	if err := yaml.Unmarshal(data, &template); err != nil {
+		return nil, fmt.Errorf("invalid pod template: %w", err)
+	}
+	if cfg.CDPPort <= 0 {
+		cfg.CDPPort = 9222
+	}
+	if cfg.ReadyTimeout <= 0 {
+		cfg.ReadyTimeout = 2 * time.Minute
+	}
+	node, _ := os.Hostname()
+	return &Executor{
+		client:       client,
+		template:     template,
+		cdpPort:      cfg.CDPPort,
+		readyTimeout: cfg.ReadyTimeout,
+		node:         node,
+		logger:       logger,
+	}, nil
+}
+
+// Cleanup deletes the pods an earlier run of this server left behind.
+func (e *Executor) Cleanup(ctx context.Context) error {
+	return e.client.DeletePods(ctx, fmt.Sprintf("%s=%s,%s=%s", labelManaged, managedBy, labelNode, e.node))
+}
+
+// Launch starts a pod and waits until it is ready. It returns the pod name
+// and the DevTools endpoint of its browser.
+func (e *Executor) Launch(ctx context.Context) (string, string, error) {
+	manifest, err := e.manifest()
+	if err != nil {
+		return "", "", err
+	}
+	pod, err := e.client.CreatePod(ctx, manifest)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create browser pod: %w", err)
+	}
+	name := pod.Metadata.Name
+	e.logger.Info("Started browser pod", zap.String("pod", name), zap.String("namespace", e.client.Namespace))
+
+	ctx, cancel := context.WithTimeout(ctx, e.readyTimeout)
+	defer cancel()
+	for {
+		pod, err = e.client.GetPod(ctx, name)
+		if err == nil {
+			switch {
+			case pod.Status.Phase == "Failed" || pod.Status.Phase == "Succeeded":
+				err = fmt.Errorf("browser pod %s ended: %s", name, pod.Status.Phase)
+			case pod.Ready() && pod.Status.PodIP != "":
+				return name, "ws://" + net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(e.cdpPort)), nil
+			}
+		}
+		if err == nil {
+			select {
+			case <-ctx.Done():
+				err = fmt.Errorf("browser pod %s not ready: %w", name, ctx.Err())
+			case <-time.After(time.Second):
+				continue
+			}
+		}
+		e.Release(name)
+		return "", "", err
+	}
+}
+
+// Release deletes a pod.
+func (e *Executor) Release(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := e.client.DeletePod(ctx, name); err != nil {
+		e.logger.Warn("Failed to delete browser pod", zap.String("pod", name), zap.Error(err))
+		return
+	}
+	e.logger.Info("Deleted browser pod", zap.String("pod", name))
+}
+
+// manifest returns a copy of the template with a generated name and the
+// labels of the executor.
+func (e *Executor) manifest() (map[string]interface{}, error) {
+	data, err := json.Marshal(e.template)
+	if err != nil {
+		return nil, err
+	}
+	var manifest map[string]interface{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	metadata, _ := manifest["metadata"].(map[string]interface{})
+	if metadata == nil {
+		metadata = make(map[string]interface{})
+		manifest["metadata"] = metadata
+	}
+	delete(metadata, "name")
+	metadata["generateName"] = "umba-browser-"
+	labels, _ := metadata["labels"].(map[string]interface{})
+	if labels == nil {
+		labels = make(map[string]interface{})
+		metadata["labels"] = labels
+	}
+	labels[labelManaged] = managedBy
+	labels[labelNode] = e.node
+	manifest["apiVersion"], manifest["kind"] = "v1", "Pod"
+	return manifest, nil
+}
+
+// Factory returns the factory of the kubernetes browser driver.
+func (e *Executor) Factory() model.DriverFactory {
+	return func(model.DriverConfig) (model.BrowserDriver, error) {
+		return &Driver{executor: e}, nil
+	}
+}
+
+// Driver runs the browser of an instance in a pod of its own, from when
+// the instance starts until it stops.
+type Driver struct {
+	model.DefaultChromeDPContext
+	executor *Executor
+}
+
+// NewContext starts the pod. When it cannot, the context returned is
+// already cancelled, with the failure as its cause. A context of a browser
+// running already gets a new tab in it instead.
+func (d *Driver) NewContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if chromedp.FromContext(ctx) != nil {
+		return chromedp.NewContext(ctx)
+	}
+	name, endpoint, err := d.executor.Launch(ctx)
+	if err != nil {
+		d.executor.logger.Error("Failed to launch browser pod", zap.Error(err))
+		failed, cancel := context.WithCancelCause(ctx)
+		cancel(err)
+		return failed, func() { cancel(nil) }
+	}
+	allocCtx, cancelAlloc := chromedp.NewRemoteAllocator(ctx, endpoint)
+	browserCtx, cancel := chromedp.NewContext(allocCtx)
+	var once sync.Once
+	return browserCtx, func() {
+		once.Do(func() {
+			cancel()
+			cancelAlloc()
+			d.executor.Release(name)
+		})
+	}
+}
@@ -0,0 +1,320 @@
+// Package kube runs browsers in short-lived Kubernetes pods. It talks to the
+// Kubernetes API over plain HTTP, configured from a kubeconfig file or, in
+// a cluster, from the service account of the pod.
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Client calls the Kubernetes API of one namespace.
+type Client struct {
+	Server    string
+	Namespace string
+	Token     string
+	HTTP      *http.Client
+}
+
+// NewClient returns a client configured from the kubeconfig file at path,
+// using its current context, or from the service account of the pod when
+// path is empty. A non-empty namespace overrides the configured one.
+func NewClient(path, namespace string) (*Client, error) {
+	var c *Client
+	var err error
+	if path == "" {
+		c, err = inCluster()
+	} else {
+		c, err = fromKubeconfig(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if namespace != "" {
+		c.Namespace = namespace
+	}
+	if c.Namespace == "" {
+		c.Namespace = "default"
+	}
+	return c, nil
+}
+
+func inCluster() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes cluster and no kubeconfig given")
+	}
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	namespace, _ := os.ReadFile(serviceAccountDir + "/namespace")
+	tlsConfig, err := tlsConfig(ca, nil, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		Server:    "https://" + net.JoinHostPort(host, port),
+		Namespace: strings.TrimSpace(string(namespace)),
+		Token:     strings.TrimSpace(string(token)),
+		HTTP:      httpClient(tlsConfig),
+	}, nil
+}
+
+// kubeconfig is the part of a kubeconfig file the client uses.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+func fromKubeconfig(path string) (*Client, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg kubeconfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig: %w", err)
+	}
+
+	c := &Client{}
+	var clusterName, userName string
+	for _, ctx := range cfg.Contexts {
+		if ctx.Name == cfg.CurrentContext {
+			clusterName, userName, c.Namespace = ctx.Context.Cluster, ctx.Context.User, ctx.Context.Namespace
+		}
+	}
+	if clusterName == "" {
+		return nil, fmt.Errorf("kubeconfig has no context %q", cfg.CurrentContext)
+	}
+
+	var ca []byte
+	insecure := false
+	for _, cluster := range cfg.Clusters {
+		if cluster.Name != clusterName {
+			continue
+		}
+		c.Server = strings.TrimSuffix(cluster.Cluster.Server, "/")
+		insecure = cluster.Cluster.InsecureSkipTLSVerify
+		if ca, err = fileOrData(cluster.Cluster.CertificateAuthority, cluster.Cluster.CertificateAuthorityData); err != nil {
+			return nil, err
+		}
+	}
+	if c.Server == "" {
+		return nil, fmt.Errorf("kubeconfig has no cluster %q", clusterName)
+	}
+
+	var cert, key []byte
+	for _, user := range cfg.Users {
+		if user.Name != userName {
+			continue
+		}
+		c.Token = user.User.Token
+		if user.User.TokenFile != "" {
+			token, err := os.ReadFile(user.User.TokenFile)
+			if err != nil {
+				return nil, err
+			}
+			c.Token = strings.TrimSpace(string(token))
+		}
+		if cert, err = fileOrData(user.User.ClientCertificate, user.User.ClientCertificateData); err != nil {
+			return nil, err
+		}
+		if key, err = fileOrData(user.User.ClientKey, user.User.ClientKeyData); err != nil {
+			return nil, err
+		}
+	}
+
+	tlsConfig, err := tlsConfig(ca, cert, key, insecure)
+	if err != nil {
+		return nil, err
+	}
+	c.HTTP = httpClient(tlsConfig)
+	return c, nil
+}
+
+// fileOrData returns the contents of path, or else the base64 data.
+func fileOrData(path, data string) ([]byte, error) {
+	if path != "" {
+		return os.ReadFile(path)
+	}
+	if data == "" {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(data)
+}
+
+func tlsConfig(ca, cert, key []byte, insecure bool) (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: insecure}
+	if len(ca) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("invalid certificate authority")
+		}
+		cfg.RootCAs = pool
+	}
+	if len(cert) > 0 {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+	return cfg, nil
+}
+
+func httpClient(cfg *tls.Config) *http.Client {
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: cfg},
+	}
+}
+
+// Pod is the part of a pod the executor looks at.
+type Pod struct {
+	Metadata struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels,omitempty"`
+	} `json:"metadata"`
+	Status struct {
+		Phase      string `json:"phase"`
+		PodIP      string `json:"podIP"`
+		Conditions []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+// Ready reports whether the containers of the pod are ready.
+func (p *Pod) Ready() bool {
+	for _, c := range p.Status.Conditions {
+		if c.Type == "Ready" {
+			return c.Status == "True"
+		}
+	}
+	return false
+}
+
+// CreatePod creates a pod from its manifest.
+func (c *Client) CreatePod(ctx context.Context, manifest map[string]interface{}) (*Pod, error) {
+	var pod Pod
+	err := c.do(ctx, http.MethodPost, c.podsPath(), nil, manifest, &pod)
+	return &pod, err
+}
+
+// GetPod returns a pod by name.
+func (c *Client) GetPod(ctx context.Context, name string) (*Pod, error) {
+	var pod Pod
+	err := c.do(ctx, http.MethodGet, c.podsPath()+"/"+url.PathEscape(name), nil, nil, &pod)
+	return &pod, err
+}
+
+// DeletePod deletes a pod right away.
+func (c *Client) DeletePod(ctx context.Context, name string) error {
+	q := url.Values{"gracePeriodSeconds": {"0"}}
+	return c.do(ctx, http.MethodDelete, c.podsPath()+"/"+url.PathEscape(name), q, nil, nil)
+}
+
+// DeletePods deletes the pods matching a label selector.
+func (c *Client) DeletePods(ctx context.Context, selector string) error {
+	q := url.Values{"labelSelector": {selector}, "gracePeriodSeconds": {"0"}}
+	return c.do(ctx, http.MethodDelete, c.podsPath(), q, nil, nil)
+}
+
+func (c *Client) podsPath() string {
+	return "/api/v1/namespaces/" + url.PathEscape(c.Namespace) + "/pods"
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	u := c.Server + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var status struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(data, &status) != nil || status.Message == "" {
+			status.Message = strings.TrimSpace(string(data))
+		}
+		return fmt.Errorf("kubernetes API returned %s: %s", resp.Status, status.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
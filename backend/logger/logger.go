@@ -1,7 +1,10 @@
 package logger
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -9,6 +12,19 @@ import (
 
 var logger *zap.Logger
 
+// Options configure the logger. Entries at or below SampleLevel are sampled:
+// of the entries with the same level and message, the first
+// SampleInitial each SampleTick are logged and then every SampleThereafter-th.
+// Entries above SampleLevel are never dropped. A SampleInitial of zero turns
+// sampling off.
+type Options struct {
+	Level            string
+	SampleLevel      string
+	SampleInitial    int
+	SampleThereafter int
+	SampleTick       time.Duration
+}
+
 func init() {
 	InitLogger()
 }
@@ -19,10 +35,97 @@ func InitLogger() {
 	logger, _ = config.Build()
 }
 
+// Configure replaces the logger with one built from opts. Loggers handed
+// out before keep the old configuration.
+func Configure(opts Options) error {
+	level, err := zapcore.ParseLevel(opts.Level)
+	if err != nil {
+		return fmt.Errorf("invalid log level: %w", err)
+	}
+	sampleLevel, err := zapcore.ParseLevel(opts.SampleLevel)
+	if err != nil {
+		return fmt.Errorf("invalid log sampling level: %w", err)
+	}
+	if opts.SampleTick <= 0 {
+		opts.SampleTick = time.Second
+	}
+	if opts.SampleThereafter <= 0 {
+		opts.SampleThereafter = 1
+	}
+
+	config := zap.NewProductionConfig()
+	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	config.Level = zap.NewAtomicLevelAt(level)
+	config.Sampling = nil
+	built, err := config.Build(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		if opts.SampleInitial <= 0 {
+			return core
+		}
+		sampled := zapcore.NewSamplerWithOptions(levelCore{core, zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+			return l <= sampleLevel
+		})}, opts.SampleTick, opts.SampleInitial, opts.SampleThereafter)
+		return zapcore.NewTee(sampled, levelCore{core, zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+			return l > sampleLevel
+		})})
+	}))
+	if err != nil {
+		return err
+	}
+	logger = built
+	return nil
+}
+
+// levelCore passes on the entries of the levels enabled allows.
+type levelCore struct {
+	zapcore.Core
+	enabled zapcore.LevelEnabler
+}
+
+func (c levelCore) Enabled(l zapcore.Level) bool {
+	return c.enabled.Enabled(l) && c.Core.Enabled(l)
+}
+
+func (c levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return levelCore{c.Core.With(fields), c.enabled}
+}
+
+func (c levelCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.enabled.Enabled(e.Level) {
+		return ce
+	}
+	return c.Core.Check(e, ce)
+}
+
 func NewLogger() *zap.Logger {
 	return logger
 }
 
+type contextKey struct{}
+
+// NewContext returns a context carrying l.
+func NewContext(ctx context.Context, l *zap.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger of ctx, or fallback if it has none. The
+// loggers of requests and runs carry their request_id or run_id.
+func FromContext(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	if l, ok := ctx.Value(contextKey{}).(*zap.Logger); ok {
+		return l
+	}
+	return fallback
+}
+
+// ForRequest returns a child of l for the HTTP or WebSocket request id.
+func ForRequest(l *zap.Logger, id string) *zap.Logger {
+	return l.With(zap.String("request_id", id))
+}
+
+// ForRun returns a child of l for a run of a flow.
+func ForRun(l *zap.Logger, runID, flowID string) *zap.Logger {
+	return l.With(zap.String("run_id", runID), zap.String("flow_id", flowID))
+}
+
 func Debug(msg string, fields ...zap.Field) {
 	logger.Debug(msg, fields...)
 }
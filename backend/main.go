@@ -1,64 +1,393 @@
-package main
-
-import (
-	"net/http"
-
-	"auto/backend/handlers"
-	"auto/config"
-	"auto/dbmanager"
-	"auto/flow"
-	"auto/logger"
-	"auto/model"
-	"auto/websocket"
-
-	"github.com/gin-gonic/gin"
-	"go.uber.org/zap"
-)
-
-func main() {
-	// Initialize logger
-	logger := logger.NewLogger()
-	defer logger.Sync()
-
-	// Load configuration
-	cfg, err := config.LoadConfig(".env")
-	if err != nil {
-		logger.Fatal("Failed to load configuration", zap.Error(err))
-	}
-
-	// Initialize database manager
-	dbManager := &dbmanager.DbManager{}
-	if err := dbManager.Init(); err != nil {
-		logger.Fatal("Failed to initialize database manager", zap.Error(err))
-	}
-
-	// Initialize instance manager
-	instanceManager := model.NewInstanceManager(logger)
-
-	// Initialize flow repository
-	flowRepo := flow.NewFlowRepository(dbManager.Client, logger)
-
-	// Initialize flow manager
-	flowManager := flow.NewManager(dbManager.Client, flowRepo, logger, dbManager.Client)
-
-	// Initialize handler
-	handler := handlers.NewHandler(logger, dbManager, flowManager, instanceManager)
-
-	// Set up Gin router
-	r := gin.Default()
-
-	// Register routes
-	handlers.RegisterRoutes(r, handler)
-
-	// WebSocket Route
-	r.GET("/ws", func(c *gin.Context) {
-		websocket.WebsocketHandler(c.Writer, c.Request)
-	})
-
-	// Start the server
-	addr := ":" + cfg.ServerPort
-	logger.Info("Starting server", zap.String("addr", addr))
-	if err := http.ListenAndServe(addr, r); err != nil {
-		logger.Fatal("Failed to start server", zap.Error(err))
-	}
-}
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"auto/agent"
+	"auto/alert"
+	"auto/backend/handlers"
+	"auto/config"
+	"auto/crawler"
+	"auto/dbmanager"
+	"auto/events"
+	"auto/flow"
+	"auto/janitor"
+	"auto/kube"
+	"auto/logger"
+	"auto/migrate"
+	"auto/model"
+	"auto/notify"
+	"auto/ocr"
+	"auto/pipeline"
+	"auto/plugins"
+	"auto/quota"
+	"auto/scheduler"
+	"auto/secrets"
+	"auto/tracing"
+	"auto/trigger"
+	"auto/websocket"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func main() {
+	checkConfig := flag.Bool("check-config", false, "check the configuration, print its problems and exit")
+	flag.Parse()
+	if *checkConfig {
+		problems, err := handlers.CheckConfig(handlers.ConfigFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		for _, p := range problems {
+			fmt.Fprintln(os.Stderr, p)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+		fmt.Println("Configuration is valid")
+		return
+	}
+
+	// Load configuration
+	cfg, err := config.LoadConfig(".env")
+	if err != nil {
+		logger.Fatal("Failed to load configuration", zap.Error(err))
+	}
+
+	// Initialize logger
+	if err := logger.Configure(logger.Options{
+		Level:            cfg.LogLevel,
+		SampleLevel:      cfg.LogSampleLevel,
+		SampleInitial:    cfg.LogSampleInitial,
+		SampleThereafter: cfg.LogSampleThereafter,
+		SampleTick:       cfg.LogSampleTick,
+	}); err != nil {
+		logger.Fatal("Invalid log configuration", zap.Error(err))
+	}
+	logger := logger.NewLogger()
+	defer logger.Sync()
+	websocket.SetLogger(logger)
+
+	// Initialize tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Endpoint:    cfg.TracingEndpoint,
+		Insecure:    cfg.TracingInsecure,
+		ServiceName: cfg.TracingServiceName,
+		SampleRatio: cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		logger.Fatal("Failed to set up tracing", zap.Error(err))
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownTracing(ctx)
+	}()
+
+	// Initialize database manager
+	dbManager := &dbmanager.DbManager{}
+	if err := dbManager.Init(); err != nil {
+		logger.Fatal("Failed to initialize database manager", zap.Error(err))
+	}
+	dbManager.Client.AddHook(tracing.RedisHook{})
+
+	// Upgrade the stored data before anything reads it
+	migrator, err := migrate.New(migrate.NewRedisVersionStore(dbManager.Client, migrate.SchemaVersionKey), dbManager.Client, migrate.RedisMigrations, logger)
+	if err != nil {
+		logger.Fatal("Invalid migrations", zap.Error(err))
+	}
+	// A read-only server leaves the upgrade to the servers it mirrors
+	steps, err := migrator.Run(context.Background(), cfg.MigrateDryRun || cfg.ReadOnly)
+	if err != nil {
+		logger.Fatal("Failed to migrate data", zap.Error(err))
+	}
+	if cfg.MigrateDryRun {
+		for _, step := range steps {
+			logger.Info("Pending migration", zap.Int("version", step.Version), zap.String("description", step.Description), zap.Strings("changes", step.Changes))
+		}
+		logger.Info("Dry run finished, nothing was changed", zap.Int("pending", len(steps)))
+		return
+	}
+	if cfg.ReadOnly && len(steps) > 0 {
+		logger.Warn("Serving read-only with migrations pending", zap.Int("pending", len(steps)))
+	}
+
+	// Initialize instance manager
+	instanceManager := model.NewInstanceManager(logger, dbManager.Client, cfg.QueueTimeout)
+
+	// Make sure browsers can run here before anything needs one
+	if cfg.SelfTest && !cfg.ReadOnly {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.SelfTestTimeout)
+		result, err := model.SelfTest(ctx)
+		cancel()
+		if err != nil {
+			logger.Fatal("Browser self-test failed", zap.Error(err))
+		}
+		logger.Info("Browser self-test passed", zap.String("product", result.Product), zap.String("protocol", result.Protocol), zap.Int64("durationMs", result.DurationMs))
+	}
+
+	// Recognise text for the ocr step
+	ocrEngine, err := ocr.New(ocr.Config{
+		Engine:        cfg.OCREngine,
+		TesseractPath: cfg.TesseractPath,
+		URL:           cfg.OCRURL,
+		APIKey:        cfg.OCRAPIKey,
+	})
+	if err != nil {
+		logger.Fatal("Failed to initialize OCR", zap.Error(err))
+	}
+	instanceManager.SetOCR(ocrEngine)
+
+	// Run browsers in Kubernetes pods for instances that ask for it
+	if cfg.KubePodTemplate != "" {
+		executor, err := kube.NewExecutor(kube.Config{
+			Kubeconfig:   cfg.Kubeconfig,
+			Namespace:    cfg.KubeNamespace,
+			PodTemplate:  cfg.KubePodTemplate,
+			CDPPort:      cfg.KubeCDPPort,
+			ReadyTimeout: cfg.KubeReadyTimeout,
+		}, logger)
+		if err != nil {
+			logger.Fatal("Failed to initialize Kubernetes executor", zap.Error(err))
+		}
+		if err := executor.Cleanup(context.Background()); err != nil {
+			logger.Warn("Failed to remove leftover browser pods", zap.Error(err))
+		}
+		if err := model.RegisterDriver(kube.DriverName, executor.Factory()); err != nil {
+			logger.Fatal("Failed to register Kubernetes driver", zap.Error(err))
+		}
+	}
+
+	// Register step actions provided by external plugins
+	if _, err := plugins.Load(cfg.PluginDir, logger); err != nil {
+		logger.Error("Failed to load plugins", zap.String("dir", cfg.PluginDir), zap.Error(err))
+	}
+
+	// Initialize flow repository
+	flowRepo := flow.NewFlowRepository(dbManager.Client, logger)
+
+	// Initialize notifications
+	dispatcher := notify.NewDispatcher(cfg.BaseURL)
+	dispatcher.Register("ws", func(ch notify.Channel) (notify.Notifier, error) {
+		return notify.NotifierFunc(func(ctx context.Context, n notify.Notification) error {
+			websocket.Broadcast("notification", n)
+			return nil
+		}), nil
+	})
+	if cfg.SMTPHost != "" {
+		dispatcher.Register("email", notify.NewEmailFactory(notify.SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.SMTPFrom,
+		}))
+	}
+	dispatcher.Register("slack", notify.NewSlackFactory(cfg.SlackToken))
+	dispatcher.Register("telegram", notify.NewTelegramFactory(cfg.TelegramBot))
+
+	// Initialize output pipelines
+	secretStore := secrets.NewStore(dbManager.Client)
+	pipelines := pipeline.NewExecutor(dbManager.Client, cfg.ArtifactDir, logger)
+	pipelines.SetSecrets(secretStore)
+
+	// Initialize run event publishing
+	publisher, err := events.New(events.Config{
+		Broker:       cfg.Broker,
+		NATSURL:      cfg.NATSURL,
+		KafkaRESTURL: cfg.KafkaRESTURL,
+		Topic:        cfg.EventsTopic,
+		Logger:       logger,
+	})
+	if err != nil {
+		logger.Fatal("Failed to initialize event publisher", zap.Error(err))
+	}
+	publisher = events.Tee(publisher, websocket.EventPublisher{})
+	defer publisher.Close()
+
+	// Supervise browsers and report crashes
+	instanceManager.OnCrash(func(instance *model.Instance, reason string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		publisher.Publish(ctx, events.Event{
+			Type:       events.InstanceCrashed,
+			InstanceID: instance.ID,
+			Time:       time.Now(),
+			Data:       map[string]string{"reason": reason},
+		})
+	})
+	if !cfg.ReadOnly {
+		instanceManager.Supervise(context.Background(), model.DefaultSuperviseInterval)
+		instanceManager.StopIdle(context.Background(), model.DefaultIdleCheckInterval)
+	}
+
+	// Initialize flow manager
+	flowManager := flow.NewManager(dbManager.Client, flowRepo, logger, dbManager.Client, pipelines, publisher, dispatcher)
+	flowManager.SetHistoryLimit(int64(cfg.HistoryMaxLen))
+	flowManager.SetDefaultRetention(flow.Retention{
+		RunsSeconds:        int(cfg.RunRetention.Seconds()),
+		ScreenshotsSeconds: int(cfg.RunScreenshotRetention.Seconds()),
+		ArtifactsSeconds:   int(cfg.RunArtifactRetention.Seconds()),
+	})
+	flowManager.SetRunQuotas(map[string]int{
+		model.PriorityInteractive: cfg.RunQuotaInteractive,
+		model.PriorityBatch:       cfg.RunQuotaBatch,
+	})
+	workspaceLimits, err := quota.ParseLimits(cfg.WorkspaceQuotas)
+	if err != nil {
+		logger.Fatal("Invalid workspace quotas", zap.Error(err))
+	}
+	quotas := quota.NewTracker(dbManager.Client, workspaceLimits, cfg.ArtifactDir)
+	flowManager.SetQuotaTracker(quotas)
+
+	// An agent only runs the flows queued for agents, on its own browsers
+	if cfg.Mode == config.ModeAgent {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		agent.New(dbManager.Client, flowManager, instanceManager, cfg.AgentConcurrency, logger).Run(ctx)
+		return
+	}
+	agents := agent.NewQueue(dbManager.Client, flowManager, logger)
+	if !cfg.ReadOnly {
+		agents.Recover(context.Background(), agent.DefaultRecoverInterval)
+	}
+
+	// Initialize crawler
+	crawlSvc := crawler.NewCrawler(dbManager.Client, &model.DefaultChromeDPContext{}, logger)
+
+	// Initialize alerting
+	alerts := alert.NewEngine(dbManager.Client, flow.NewRunStore(dbManager.Client), instanceManager, dispatcher, logger)
+	if !cfg.ReadOnly {
+		alerts.Start(context.Background(), alert.DefaultInterval)
+	}
+
+	// Remove runs past the retention of their flows
+	if !cfg.ReadOnly {
+		flowManager.StartRetention(context.Background(), flow.DefaultRetentionInterval)
+	}
+
+	// Initialize flow scheduling
+	sched := scheduler.NewScheduler(dbManager.Client, flowManager, instanceManager, logger)
+	if !cfg.ReadOnly {
+		if err := sched.Start(); err != nil {
+			logger.Error("Failed to load schedules", zap.Error(err))
+		}
+		defer sched.Stop()
+	}
+
+	// Initialize disk cleanup
+	const mb = 1 << 20
+	policies := []janitor.Policy{
+		{Name: "screenshots", Dir: flow.DefaultScreenshotDir, MaxAge: cfg.ScreenshotRetention, MaxSize: int64(cfg.ScreenshotMaxMB) * mb},
+		{Name: "artifacts", Dir: cfg.ArtifactDir, MaxAge: cfg.ArtifactRetention, MaxSize: int64(cfg.ArtifactMaxMB) * mb},
+		{Name: "temp_profiles", Dir: os.TempDir(), Pattern: "chromedp-runner*", MaxAge: cfg.TempProfileRetention, Keep: model.ChromeProfileInUse},
+	}
+	if cfg.DownloadDir != "" {
+		policies = append(policies, janitor.Policy{Name: "downloads", Dir: cfg.DownloadDir, MaxAge: cfg.DownloadRetention, MaxSize: int64(cfg.DownloadMaxMB) * mb})
+	}
+	if cfg.CrawlOutputDir != "" {
+		policies = append(policies, janitor.Policy{Name: "crawl_output", Dir: cfg.CrawlOutputDir, MaxAge: cfg.CrawlOutputRetention, MaxSize: int64(cfg.CrawlOutputMaxMB) * mb})
+	}
+	janitor := janitor.New(policies, logger)
+	if !cfg.ReadOnly {
+		janitor.Start(context.Background(), cfg.JanitorInterval)
+	}
+
+	// Initialize triggers
+	triggers := trigger.NewManager(dbManager.Client, flowManager, instanceManager, logger)
+	triggers.SetFileRoot(cfg.TriggerFileRoot)
+	if !cfg.ReadOnly {
+		if err := triggers.Start(); err != nil {
+			logger.Error("Failed to start queue triggers", zap.Error(err))
+		}
+		defer triggers.Stop()
+	}
+
+	// Initialize handler
+	rateLimits, err := handlers.ParseRateLimits(cfg.RateLimitDefault, cfg.RateLimitRoutes, cfg.RateLimitKeys)
+	if err != nil {
+		logger.Fatal("Invalid rate limits", zap.Error(err))
+	}
+	timeouts, err := handlers.ParseRequestTimeouts(cfg.RequestTimeoutDefault, cfg.RequestTimeoutRoutes)
+	if err != nil {
+		logger.Fatal("Invalid request timeouts", zap.Error(err))
+	}
+	handler := handlers.NewHandler(logger, dbManager, flowManager, instanceManager, crawlSvc, alerts, sched, janitor, triggers, agents, secretStore, quotas, handlers.BodyLimits{
+		MaxBody:   int64(cfg.MaxBodyMB) * mb,
+		MaxUpload: int64(cfg.MaxUploadMB) * mb,
+	}, rateLimits, timeouts, handlers.AdminAuth{
+		Username: cfg.AuthUsername,
+		Password: cfg.AuthPassword,
+	})
+
+	// Set up Gin router
+	r := gin.Default()
+	r.Use(tracing.Middleware())
+	if cfg.ReadOnly {
+		logger.Info("Serving the API read-only")
+		r.Use(handlers.ReadOnly)
+	}
+
+	// Register routes
+	handlers.RegisterRoutes(r, handler)
+
+	// WebSocket Route
+	websocket.Configure(websocket.Options{
+		APIKeys:           cfg.WSAPIKeys,
+		AllowedOrigins:    cfg.WSAllowedOrigins,
+		MaxConnsPerKey:    cfg.WSMaxConnsPerKey,
+		MessagesPerSecond: cfg.WSMessagesPerSecond,
+		MessageBurst:      cfg.WSMessageBurst,
+		MaxMessageBytes:   int64(cfg.WSMaxMessageKB) << 10,
+		PingInterval:      cfg.WSPingInterval,
+		PongWait:          cfg.WSPongWait,
+		SessionTTL:        cfg.WSSessionTTL,
+		SessionBuffer:     cfg.WSSessionBuffer,
+		LiveViewMinChange: cfg.WSLiveViewMinChange,
+		ReadOnly:          cfg.ReadOnly,
+	})
+	if len(cfg.WSAPIKeys) == 0 {
+		logger.Warn("WS_API_KEYS is not set, WebSocket connections are not authenticated")
+	}
+	websocket.SetDebugFunc(func(flowID string, params map[string]interface{}, pause func(websocket.DebugStep) websocket.DebugCommand) error {
+		return flowManager.DebugFlow(flowID, instanceManager, params, flow.DebuggerFunc(func(p flow.DebugPause) flow.DebugCommand {
+			cmd := pause(websocket.DebugStep{
+				RunID:      p.RunID,
+				Index:      p.Index,
+				ID:         p.Step.ID,
+				Action:     p.Step.Action,
+				Params:     p.Step.Params,
+				Screenshot: p.Screenshot,
+			})
+			return flow.DebugCommand{Command: cmd.Command, Params: cmd.Params}
+		}))
+	})
+	r.GET("/ws", func(c *gin.Context) {
+		websocket.WebsocketHandler(c.Writer, c.Request)
+	})
+
+	// Start the server
+	addr := ":" + cfg.ServerPort
+	logger.Info("Starting server", zap.String("addr", addr))
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           r,
+		ReadTimeout:       cfg.ServerReadTimeout,
+		ReadHeaderTimeout: cfg.ServerReadHeaderTimeout,
+		WriteTimeout:      cfg.ServerWriteTimeout,
+		IdleTimeout:       cfg.ServerIdleTimeout,
+		MaxHeaderBytes:    cfg.ServerMaxHeaderKB << 10,
+	}
+	if err := server.ListenAndServe(); err != nil {
+		logger.Fatal("Failed to start server", zap.Error(err))
+	}
+}
@@ -0,0 +1,197 @@
+// Package migrate upgrades persisted data between releases. Each store
+// records the schema version it is at; on startup the migrations above
+// that version run in order and the version advances after each one.
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// VersionStore keeps the schema version of a backend. A SQL backend would
+// implement it with a table of its own.
+type VersionStore interface {
+	Version(ctx context.Context) (int, error)
+	SetVersion(ctx context.Context, version int) error
+}
+
+// Migration moves the data from Version-1 to Version. Up must make its
+// changes through tx so dry runs can report them without applying them.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, tx *Tx) error
+}
+
+// Step is the outcome of one migration.
+type Step struct {
+	Version     int      `json:"version"`
+	Description string   `json:"description"`
+	Changes     []string `json:"changes"`
+}
+
+// Tx gives a migration read access to Redis and records its writes,
+// applying them unless it is a dry run.
+type Tx struct {
+	db      redis.Cmdable
+	dryRun  bool
+	changes []string
+}
+
+// DB is for reads; writes go through the methods of Tx.
+func (tx *Tx) DB() redis.Cmdable {
+	return tx.db
+}
+
+// DryRun reports whether changes are only recorded.
+func (tx *Tx) DryRun() bool {
+	return tx.dryRun
+}
+
+func (tx *Tx) record(change string, apply func() error) error {
+	tx.changes = append(tx.changes, change)
+	if tx.dryRun {
+		return nil
+	}
+	return apply()
+}
+
+func (tx *Tx) Set(ctx context.Context, key string, value interface{}) error {
+	return tx.record("SET "+key, func() error {
+		return tx.db.Set(ctx, key, value, 0).Err()
+	})
+}
+
+func (tx *Tx) Del(ctx context.Context, key string) error {
+	return tx.record("DEL "+key, func() error {
+		return tx.db.Del(ctx, key).Err()
+	})
+}
+
+func (tx *Tx) Rename(ctx context.Context, key, newKey string) error {
+	return tx.record(fmt.Sprintf("RENAME %s %s", key, newKey), func() error {
+		return tx.db.Rename(ctx, key, newKey).Err()
+	})
+}
+
+func (tx *Tx) HSet(ctx context.Context, key, field string, value interface{}) error {
+	return tx.record(fmt.Sprintf("HSET %s %s", key, field), func() error {
+		return tx.db.HSet(ctx, key, field, value).Err()
+	})
+}
+
+func (tx *Tx) HDel(ctx context.Context, key, field string) error {
+	return tx.record(fmt.Sprintf("HDEL %s %s", key, field), func() error {
+		return tx.db.HDel(ctx, key, field).Err()
+	})
+}
+
+func (tx *Tx) ZAdd(ctx context.Context, key string, members ...*redis.Z) error {
+	return tx.record(fmt.Sprintf("ZADD %s (%d members)", key, len(members)), func() error {
+		return tx.db.ZAdd(ctx, key, members...).Err()
+	})
+}
+
+// Migrator runs the migrations of one backend.
+type Migrator struct {
+	store      VersionStore
+	db         redis.Cmdable
+	migrations []Migration
+	logger     *zap.Logger
+}
+
+// New returns a migrator for migrations, which must have distinct
+// versions starting at 1 without gaps.
+func New(store VersionStore, db redis.Cmdable, migrations []Migration, logger *zap.Logger) (*Migrator, error) {
+	sorted := append([]Migration{}, migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must be 1..%d without gaps, found %d", len(sorted), m.Version)
+		}
+		if m.Up == nil {
+			return nil, fmt.Errorf("migration %d has no Up", m.Version)
+		}
+	}
+	return &Migrator{store: store, db: db, migrations: sorted, logger: logger}, nil
+}
+
+// Latest returns the version the migrations lead to.
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Pending returns the migrations that have not run yet.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	current, err := m.store.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if current > m.Latest() {
+		return nil, fmt.Errorf("stored schema version %d is newer than this release supports (%d)", current, m.Latest())
+	}
+	return m.migrations[current:], nil
+}
+
+// Run applies the pending migrations in order, storing the version after
+// each one so a failure resumes from there. With dryRun nothing is written
+// and the returned steps list what would change.
+func (m *Migrator) Run(ctx context.Context, dryRun bool) ([]Step, error) {
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var steps []Step
+	for _, mig := range pending {
+		tx := &Tx{db: m.db, dryRun: dryRun}
+		if err := mig.Up(ctx, tx); err != nil {
+			return steps, fmt.Errorf("migration %d (%s): %w", mig.Version, mig.Description, err)
+		}
+		steps = append(steps, Step{Version: mig.Version, Description: mig.Description, Changes: tx.changes})
+		if dryRun {
+			continue
+		}
+		if err := m.store.SetVersion(ctx, mig.Version); err != nil {
+			return steps, err
+		}
+		m.logger.Info("Applied migration",
+			zap.Int("version", mig.Version),
+			zap.String("description", mig.Description),
+			zap.Int("changes", len(tx.changes)))
+	}
+	return steps, nil
+}
+
+// RedisVersionStore keeps the schema version in a Redis key.
+type RedisVersionStore struct {
+	db  redis.Cmdable
+	key string
+}
+
+func NewRedisVersionStore(db redis.Cmdable, key string) *RedisVersionStore {
+	return &RedisVersionStore{db: db, key: key}
+}
+
+// Version returns the stored version, 0 if none is stored yet.
+func (s *RedisVersionStore) Version(ctx context.Context) (int, error) {
+	value, err := s.db.Get(ctx, s.key).Result()
+	if err == redis.Nil {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema version %q", value)
+	}
+	return version, nil
+}
+
+func (s *RedisVersionStore) SetVersion(ctx context.Context, version int) error {
+	return s.db.Set(ctx, s.key, version, 0).Err()
+}
@@ -0,0 +1,70 @@
+package migrate
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// SchemaVersionKey holds the schema version of the Redis key-space.
+const SchemaVersionKey = "schema_version"
+
+// RedisMigrations upgrade the Redis key-space. Append new steps at the end;
+// never change or reorder released ones.
+var RedisMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "baseline key-space",
+		Up:          func(ctx context.Context, tx *Tx) error { return nil },
+	},
+	{
+		Version:     2,
+		Description: "drop instance entries that were stored without data",
+		Up: func(ctx context.Context, tx *Tx) error {
+			all, err := tx.DB().HGetAll(ctx, "instances").Result()
+			if err != nil {
+				return err
+			}
+			for id, data := range all {
+				if data == "" {
+					if err := tx.HDel(ctx, "instances", id); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     3,
+		Description: "index the runs of every flow in run_index",
+		Up: func(ctx context.Context, tx *Tx) error {
+			var cursor uint64
+			for {
+				keys, next, err := tx.DB().Scan(ctx, cursor, "runs:*", 100).Result()
+				if err != nil {
+					return err
+				}
+				for _, key := range keys {
+					runs, err := tx.DB().ZRangeWithScores(ctx, key, 0, -1).Result()
+					if err != nil {
+						return err
+					}
+					if len(runs) == 0 {
+						continue
+					}
+					members := make([]*redis.Z, len(runs))
+					for i := range runs {
+						members[i] = &runs[i]
+					}
+					if err := tx.ZAdd(ctx, "run_index", members...); err != nil {
+						return err
+					}
+				}
+				if cursor = next; cursor == 0 {
+					return nil
+				}
+			}
+		},
+	},
+}
@@ -0,0 +1,178 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/chromedp/cdproto/accessibility"
+	"github.com/chromedp/cdproto/dom"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+	"go.uber.org/zap"
+)
+
+func init() {
+	actionHandlers["accessibilitySnapshot"] = accessibilitySnapshotAction
+}
+
+// roleAttr marks the element found by role and name so the remaining
+// actions can address it through a plain CSS selector.
+const roleAttr = "data-umba-role"
+
+// markElementJS is called on the element found by role and name.
+const markElementJS = `function(marker) { this.setAttribute('` + roleAttr + `', marker); }`
+
+// AXNode is a node of the accessibility tree: what assistive technology,
+// and a step targeting by role and name, sees of the page.
+type AXNode struct {
+	Role        string                 `json:"role"`
+	Name        string                 `json:"name,omitempty"`
+	Value       string                 `json:"value,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Properties  map[string]interface{} `json:"properties,omitempty"`
+	Children    []*AXNode              `json:"children,omitempty"`
+}
+
+// axValue returns the value of an accessibility property as a string.
+func axValue(v *accessibility.Value) string {
+	if v == nil || len(v.Value) == 0 {
+		return ""
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(v.Value, &decoded); err != nil {
+		return string(v.Value)
+	}
+	if s, ok := decoded.(string); ok {
+		return s
+	}
+	return fmt.Sprint(decoded)
+}
+
+// axTree builds the tree of the nodes that are not ignored; the children
+// of ignored nodes take their place.
+func axTree(nodes []*accessibility.Node) []*AXNode {
+	byID := make(map[accessibility.NodeID]*accessibility.Node, len(nodes))
+	for _, n := range nodes {
+		byID[n.NodeID] = n
+	}
+	var build func(n *accessibility.Node) []*AXNode
+	build = func(n *accessibility.Node) []*AXNode {
+		var children []*AXNode
+		for _, id := range n.ChildIDs {
+			if child, ok := byID[id]; ok {
+				children = append(children, build(child)...)
+			}
+		}
+		if n.Ignored {
+			return children
+		}
+		node := &AXNode{
+			Role:        axValue(n.Role),
+			Name:        axValue(n.Name),
+			Value:       axValue(n.Value),
+			Description: axValue(n.Description),
+			Children:    children,
+		}
+		for _, p := range n.Properties {
+			if node.Properties == nil {
+				node.Properties = make(map[string]interface{})
+			}
+			var v interface{}
+			if p.Value != nil && json.Unmarshal(p.Value.Value, &v) == nil {
+				node.Properties[string(p.Name)] = v
+			}
+		}
+		return []*AXNode{node}
+	}
+
+	var roots []*AXNode
+	for _, n := range nodes {
+		if _, ok := byID[n.ParentID]; !ok {
+			roots = append(roots, build(n)...)
+		}
+	}
+	return roots
+}
+
+// accessibilitySnapshotAction returns the accessibility tree of the page
+// as JSON: roles, names, values and states, without the DOM structure
+// around them.
+func accessibilitySnapshotAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	var nodes []*accessibility.Node
+	err := i.driver().Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		nodes, err = accessibility.GetFullAXTree().Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(axTree(nodes))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// resolveRole waits up to timeout for an element with the accessibility
+// role and name given, e.g. button "Submit", and returns a selector for
+// it. An empty name matches any.
+func (i *Instance) resolveRole(ctx context.Context, role, name string, timeout time.Duration) (string, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	marker := GenerateID()
+	arg, _ := json.Marshal(marker)
+	for {
+		var found bool
+		err := i.driver().Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+			doc, err := dom.GetDocument().WithDepth(0).Do(ctx)
+			if err != nil {
+				return err
+			}
+			query := accessibility.QueryAXTree().WithBackendNodeID(doc.BackendNodeID).WithRole(role)
+			if name != "" {
+				query = query.WithAccessibleName(name)
+			}
+			nodes, err := query.Do(ctx)
+			if err != nil {
+				return err
+			}
+			for _, n := range nodes {
+				if n.Ignored || n.BackendDOMNodeID == 0 {
+					continue
+				}
+				obj, err := dom.ResolveNode().WithBackendNodeID(n.BackendDOMNodeID).Do(ctx)
+				if err != nil {
+					continue
+				}
+				_, exception, err := runtime.CallFunctionOn(markElementJS).
+					WithObjectID(obj.ObjectID).
+					WithArguments([]*runtime.CallArgument{{Value: arg}}).
+					Do(ctx)
+				if err == nil && exception == nil {
+					found = true
+					return nil
+				}
+			}
+			return nil
+		}))
+		if err != nil {
+			return "", err
+		}
+		if found {
+			i.im.logger.Debug("Located element by role", zap.String("id", i.ID), zap.String("role", role), zap.String("name", name))
+			return fmt.Sprintf("[%s=%q]", roleAttr, marker), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("no element with role %s and name %q: %w", role, name, ctx.Err())
+		case <-time.After(probeInterval):
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package model
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// actionHandler runs a single flow step against a started instance.
+type actionHandler func(ctx context.Context, i *Instance, params map[string]interface{}) (string, error)
+
+var actionHandlers = map[string]actionHandler{
+	"navigate":     navigateAction,
+	"click":        clickAction,
+	"sendKeys":     sendKeysAction,
+	"waitVisible":  waitVisibleAction,
+	"text":         textAction,
+	"extractTable": extractTableAction,
+	"fingerprint":  fingerprintAction,
+	"evaluate":     evaluateAction,
+	"screenshot":   screenshotAction,
+	"wait":         waitAction,
+
+	"storageGet":    storageGetAction,
+	"storageSet":    storageSetAction,
+	"storageRemove": storageRemoveAction,
+	"storageClear":  storageClearAction,
+
+	"clipboardRead":  clipboardReadAction,
+	"clipboardWrite": clipboardWriteAction,
+	"setGeolocation": setGeolocationAction,
+}
+
+// RegisterAction adds a step action to the registry. It is meant to be
+// called at startup, before any flow runs, and refuses to replace an
+// existing action.
+func RegisterAction(name string, handler func(ctx context.Context, i *Instance, params map[string]interface{}) (string, error)) error {
+	if name == "" {
+		return errors.New("action name is empty")
+	}
+	if _, exists := actionHandlers[name]; exists {
+		return fmt.Errorf("action already registered: %s", name)
+	}
+	actionHandlers[name] = handler
+	return nil
+}
+
+// Actions returns the names of every registered step action.
+func Actions() []string {
+	names := make([]string, 0, len(actionHandlers))
+	for name := range actionHandlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func navigateAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	url, err := stringParam(params, "url")
+	if err != nil {
+		return "", err
+	}
+	step, err := stepHeaderOverrides(params)
+	if err != nil {
+		return "", err
+	}
+	if step.empty() {
+		return "", i.driver().Run(ctx, navigateTo(url))
+	}
+	// The step overrides hold for this navigation only; the instance ones
+	// are back for the steps after it.
+	err = i.driver().Run(ctx, applyHeaders(i.baseHeaders().merge(step)), navigateTo(url))
+	if restoreErr := i.driver().Run(ctx, applyHeaders(i.baseHeaders())); err == nil {
+		err = restoreErr
+	}
+	return "", err
+}
+
+func clickAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	sel, w, err := i.targetFor(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	return "", i.runWithAutoWait(ctx, w, sel, clickOn(sel))
+}
+
+func sendKeysAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	value, err := stringParam(params, "value")
+	if err != nil {
+		return "", err
+	}
+	sel, w, err := i.targetFor(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	return "", i.runWithAutoWait(ctx, w, sel, typeInto(sel, value))
+}
+
+func waitVisibleAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	sel, w, err := i.targetFor(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	w.Visible = true
+	return "", i.runWithAutoWait(ctx, w, sel, chromedp.Tasks{})
+}
+
+func textAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	sel, w, err := i.targetFor(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	var text string
+	w.Enabled, w.Stable = false, false
+	if err := i.runWithAutoWait(ctx, w, sel, textOf(sel, &text)); err != nil {
+		return "", err
+	}
+	return text, nil
+}
+
+// evaluateAction runs the "script" parameter in the page and returns its
+// result, JSON encoded unless it is a string.
+func evaluateAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	script, err := stringParam(params, "script")
+	if err != nil {
+		return "", err
+	}
+	var result interface{}
+	if err := i.driver().Run(ctx, chromedp.Evaluate(script, &result, awaitPromise)); err != nil {
+		return "", err
+	}
+	switch v := result.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+}
+
+// viewportJS reports the scroll offset and size of the viewport.
+const viewportJS = `[window.scrollX, window.scrollY, window.innerWidth, window.innerHeight]`
+
+// screenshotAction captures the viewport, or the full page when "fullPage"
+// is set, and returns it as a base64 encoded PNG. With "width" the
+// viewport is scaled down to that many pixels wide and returned as a JPEG
+// of "quality" (default 60), for thumbnails.
+func screenshotAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	var buf []byte
+	action := chromedp.CaptureScreenshot(&buf)
+	if full, _ := params["fullPage"].(bool); full {
+		action = chromedp.FullScreenshot(&buf, 100)
+	} else if width := intParam(params, "width", 0); width > 0 {
+		quality := intParam(params, "quality", 60)
+		action = chromedp.ActionFunc(func(ctx context.Context) error {
+			var vp []float64
+			if err := chromedp.Evaluate(viewportJS, &vp).Do(ctx); err != nil {
+				return err
+			}
+			if len(vp) != 4 || vp[2] <= 0 || vp[3] <= 0 {
+				return errors.New("could not measure the viewport")
+			}
+			scale := float64(width) / vp[2]
+			if scale > 1 {
+				scale = 1
+			}
+			var err error
+			buf, err = page.CaptureScreenshot().
+				WithFormat(page.CaptureScreenshotFormatJpeg).
+				WithQuality(int64(quality)).
+				WithClip(&page.Viewport{X: vp[0], Y: vp[1], Width: vp[2], Height: vp[3], Scale: scale}).
+				Do(ctx)
+			return err
+		})
+	}
+	if err := i.driver().Run(ctx, action); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// waitAction pauses for "duration" milliseconds.
+func waitAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	ms, ok := params["duration"].(float64)
+	if !ok || ms < 0 {
+		return "", errors.New("missing duration parameter")
+	}
+	return "", i.driver().Run(ctx, chromedp.Sleep(time.Duration(ms)*time.Millisecond))
+}
+
+// targetFor resolves the element a step acts on together with the auto-wait
+// configuration that applies to it.
+func (i *Instance) targetFor(ctx context.Context, params map[string]interface{}) (string, AutoWait, error) {
+	w := autoWaitFromParams(i.AutoWait, params)
+	sel, err := i.resolveSelector(ctx, params, w.Timeout)
+	return sel, w, err
+}
+
+func stringParam(params map[string]interface{}, key string) (string, error) {
+	v, ok := params[key].(string)
+	if !ok || v == "" {
+		return "", fmt.Errorf("missing %s parameter", key)
+	}
+	return v, nil
+}
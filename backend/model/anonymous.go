@@ -0,0 +1,100 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AnonymousInstance configures an instance made for a single run, which
+// is destroyed once the run is over.
+type AnonymousInstance struct {
+	URL            string            `json:"url"`
+	Auth           Auth              `json:"auth"`
+	LoginProfileID string            `json:"login_profile_id,omitempty"`
+	Cookies        []Cookie          `json:"cookies,omitempty"`
+	Headers        HeaderOverrides   `json:"headers"`
+	Device         string            `json:"device,omitempty"`
+	Permissions    map[string]string `json:"permissions,omitempty"`
+	HTTPAuth       HTTPAuth          `json:"http_auth"`
+	Driver         DriverConfig      `json:"driver"`
+}
+
+// Validate checks the options the way the setters of a stored instance
+// would.
+func (a *AnonymousInstance) Validate() error {
+	if a.URL == "" {
+		return errors.New("url is required")
+	}
+	if u, err := ParseURL(a.URL); err != nil || u.Host == "" {
+		return fmt.Errorf("invalid url %q", a.URL)
+	}
+	if err := validateCookies(a.Cookies); err != nil {
+		return err
+	}
+	if err := ValidateHeaderOverrides(a.Headers); err != nil {
+		return err
+	}
+	if a.Device != "" {
+		if _, err := devicePreset(a.Device); err != nil {
+			return err
+		}
+	}
+	if err := ValidatePermissions(a.Permissions); err != nil {
+		return err
+	}
+	if err := ValidateHTTPAuth(a.HTTPAuth); err != nil {
+		return err
+	}
+	_, err := newDriver(a.Driver)
+	return err
+}
+
+// StartAnonymousInstance creates an instance configured by a and starts
+// it, returning once it has logged in. The instance is not stored, so it
+// is gone after a restart of the server; DestroyInstance removes it.
+func (im *InstanceManager) StartAnonymousInstance(a AnonymousInstance) (*Instance, error) {
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+	if a.LoginProfileID != "" {
+		if _, err := im.getLoginProfile(a.LoginProfileID); err != nil {
+			return nil, err
+		}
+	}
+	auth := a.Auth
+	instance := &Instance{
+		ID:             GenerateID(),
+		URL:            a.URL,
+		Auth:           &auth,
+		Status:         "Off",
+		Elements:       defaultElements(),
+		LoginProfileID: a.LoginProfileID,
+		AutoWait:       DefaultAutoWait,
+		SeedCookies:    a.Cookies,
+		Headers:        a.Headers,
+		Device:         a.Device,
+		Permissions:    a.Permissions,
+		HTTPAuth:       a.HTTPAuth,
+		Driver:         a.Driver,
+		Anonymous:      true,
+		chrome:         &DefaultChromeDPContext{},
+		im:             im,
+	}
+	im.mu.Lock()
+	im.instances[instance.ID] = instance
+	im.mu.Unlock()
+
+	if err := im.RestartInstance(instance.ID); err != nil {
+		im.DestroyInstance(instance.ID)
+		return nil, fmt.Errorf("failed to start anonymous instance: %w", err)
+	}
+	return instance, nil
+}
+
+// DestroyInstance stops an instance, if it is running, and deletes it.
+func (im *InstanceManager) DestroyInstance(id string) error {
+	if err := im.StopInstance(id); err != nil && err != ErrInstanceAlreadyStopped {
+		return err
+	}
+	return im.DeleteInstance(id)
+}
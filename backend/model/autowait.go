@@ -0,0 +1,194 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"auto/apperr"
+
+	"github.com/chromedp/cdproto/dom"
+	"github.com/chromedp/chromedp"
+	"go.uber.org/zap"
+)
+
+// AutoWait controls the checks performed on an element before an action
+// such as click or sendKeys is dispatched to it. In JSON its timeout is in
+// milliseconds, as in the "autoWait" step parameter.
+type AutoWait struct {
+	Attached bool          `json:"attached"`
+	Visible  bool          `json:"visible"`
+	Stable   bool          `json:"stable"`
+	Enabled  bool          `json:"enabled"`
+	Timeout  time.Duration `json:"timeout"`
+	Retries  int           `json:"retries"`
+}
+
+// DefaultAutoWait is applied when neither the instance nor the step
+// configure auto-waiting.
+var DefaultAutoWait = AutoWait{
+	Attached: true,
+	Visible:  true,
+	Stable:   true,
+	Enabled:  true,
+	Timeout:  30 * time.Second,
+	Retries:  3,
+}
+
+type autoWait AutoWait
+
+func (w AutoWait) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		autoWait
+		Timeout int64 `json:"timeout"`
+	}{autoWait(w), w.Timeout.Milliseconds()})
+}
+
+func (w *AutoWait) UnmarshalJSON(data []byte) error {
+	v := struct {
+		*autoWait
+		Timeout int64 `json:"timeout"`
+	}{(*autoWait)(w), w.Timeout.Milliseconds()}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	w.Timeout = time.Duration(v.Timeout) * time.Millisecond
+	if w.Retries < 0 {
+		w.Retries = 0
+	}
+	return nil
+}
+
+// stableInterval is the delay between the two box model samples used to
+// decide whether an element has stopped moving.
+const stableInterval = 100 * time.Millisecond
+
+// detachedErrors lists the CDP error messages returned when a node was
+// replaced in the DOM between being resolved and being acted upon.
+var detachedErrors = []string{
+	"Node is detached from document",
+	"Could not find node with given id",
+	"No node with given id found",
+	"Cannot find context with specified id",
+}
+
+// autoWaitFromParams overrides the base configuration with the "autoWait"
+// step parameter. It accepts either a boolean to toggle all checks or an
+// object with the individual fields, timeout given in milliseconds.
+func autoWaitFromParams(base AutoWait, params map[string]interface{}) AutoWait {
+	w := base
+	switch v := params["autoWait"].(type) {
+	case bool:
+		w.Attached, w.Visible, w.Stable, w.Enabled = v, v, v, v
+	case map[string]interface{}:
+		if b, ok := v["attached"].(bool); ok {
+			w.Attached = b
+		}
+		if b, ok := v["visible"].(bool); ok {
+			w.Visible = b
+		}
+		if b, ok := v["stable"].(bool); ok {
+			w.Stable = b
+		}
+		if b, ok := v["enabled"].(bool); ok {
+			w.Enabled = b
+		}
+		if ms, ok := v["timeout"].(float64); ok {
+			w.Timeout = time.Duration(ms) * time.Millisecond
+		}
+		if n, ok := v["retries"].(float64); ok && n >= 0 {
+			w.Retries = int(n)
+		}
+	}
+	return w
+}
+
+// Tasks returns the wait actions to run against sel before acting on it.
+func (w AutoWait) Tasks(sel string) chromedp.Tasks {
+	var tasks chromedp.Tasks
+	if w.Attached {
+		tasks = append(tasks, waitReady(sel))
+	}
+	if w.Visible {
+		tasks = append(tasks, waitVisible(sel))
+	}
+	if w.Enabled {
+		tasks = append(tasks, waitEnabled(sel))
+	}
+	if w.Stable {
+		tasks = append(tasks, waitStable(sel))
+	}
+	return tasks
+}
+
+// pollStable polls the element box model until two consecutive samples
+// match, meaning animations or layout shifts have settled.
+func pollStable(ctx context.Context, sel string) error {
+	var prev *dom.BoxModel
+	for {
+		var box *dom.BoxModel
+		if err := chromedp.Dimensions(sel, &box).Do(ctx); err != nil {
+			return err
+		}
+		if prev != nil && sameQuad(prev.Border, box.Border) {
+			return nil
+		}
+		prev = box
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(stableInterval):
+		}
+	}
+}
+
+func sameQuad(a, b dom.Quad) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isDetachedError(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, msg := range detachedErrors {
+		if strings.Contains(err.Error(), msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// runWithAutoWait waits for sel according to w and then runs action,
+// retrying the whole sequence when the node got detached in between.
+func (i *Instance) runWithAutoWait(ctx context.Context, w AutoWait, sel string, action chromedp.Action) error {
+	if w.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.Timeout)
+		defer cancel()
+	}
+
+	var err error
+	for attempt := 0; attempt <= w.Retries; attempt++ {
+		err = i.driver().Run(ctx, w.Tasks(sel), action)
+		if !isDetachedError(err) {
+			break
+		}
+		i.im.logger.Warn("Node detached, retrying action",
+			zap.String("id", i.ID), zap.String("selector", sel), zap.Int("attempt", attempt+1))
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return apperr.Wrap(apperr.CodeSelectorTimeout, fmt.Errorf("timed out waiting for %s: %w", sel, err))
+	}
+	return err
+}
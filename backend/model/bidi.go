@@ -0,0 +1,780 @@
+package model
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/cdproto/storage"
+	"github.com/chromedp/chromedp"
+	"github.com/gorilla/websocket"
+	"github.com/mailru/easyjson"
+)
+
+// errBiDiUnsupported is returned for the actions the BiDi driver cannot
+// translate, those that need DevTools events or domains BiDi lacks.
+var errBiDiUnsupported = errors.New("not supported by the bidi driver")
+
+// bidiPollInterval is the delay between two looks at an element an action
+// waits for.
+const bidiPollInterval = 100 * time.Millisecond
+
+// bidiCloseTimeout bounds how long closing a tab or ending a session may
+// take.
+const bidiCloseTimeout = 5 * time.Second
+
+// BiDiDriver runs instances in a browser reached over WebDriver BiDi, such
+// as Firefox, at the WebSocket URL in Endpoint: either that of a remote
+// end, ending in /session, on which it starts a session of its own, or that
+// of a session a WebDriver client has started. Each context it returns is
+// a tab of its own.
+//
+// Navigation, element steps, scripts, screenshots, the viewport, extra
+// headers, the User-Agent and cookies are translated to BiDi commands. The
+// actions that need DevTools events or domains BiDi lacks, such as request
+// interception or network capture, fail with errBiDiUnsupported.
+type BiDiDriver struct {
+	Endpoint string
+}
+
+// NewContext connects to the browser and opens a tab. When it cannot, the
+// context returned is already cancelled, with the failure as its cause. A
+// context of a browser connected already gets a new tab in it instead.
+func (d *BiDiDriver) NewContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if tab, ok := portableRunnerFrom(ctx).(*bidiTab); ok {
+		return tab.conn.newTab(ctx)
+	}
+	conn, err := dialBiDi(ctx, d.Endpoint)
+	if err != nil {
+		failed, cancel := context.WithCancelCause(ctx)
+		cancel(err)
+		return failed, func() { cancel(nil) }
+	}
+	// The listeners of an instance only hear DevTools events; a chromedp
+	// context that never allocates a browser lets them be added anyway.
+	cdpCtx, cancelCDP := chromedp.NewContext(ctx)
+	tabCtx, cancelTab := conn.newTab(cdpCtx)
+	if tab, ok := portableRunnerFrom(tabCtx).(*bidiTab); ok && conn.userAgent == "" {
+		var userAgent string
+		if err := tab.eval(tabCtx, "navigator.userAgent", &userAgent); err == nil {
+			conn.userAgent = userAgent
+		}
+	}
+	var once sync.Once
+	return tabCtx, func() {
+		once.Do(func() {
+			cancelTab()
+			conn.close()
+			cancelCDP()
+		})
+	}
+}
+
+// Run runs actions in the tab of ctx. Portable actions and the DevTools
+// commands the tab translates work; chromedp actions that need a DevTools
+// target of their own panic for want of one, which is reported as
+// errBiDiUnsupported.
+func (d *BiDiDriver) Run(ctx context.Context, actions ...chromedp.Action) (err error) {
+	tab, ok := portableRunnerFrom(ctx).(*bidiTab)
+	if !ok {
+		if err := context.Cause(ctx); err != nil {
+			return err
+		}
+		return errors.New("bidi driver: context has no tab")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("action %w: %v", errBiDiUnsupported, r)
+		}
+	}()
+	return chromedp.Tasks(actions).Do(cdp.WithExecutor(ctx, tab))
+}
+
+// bidiMessage is a message from the browser: the response to a command,
+// with its ID, or an event.
+type bidiMessage struct {
+	ID      *int64          `json:"id"`
+	Type    string          `json:"type"`
+	Method  string          `json:"method"`
+	Result  json.RawMessage `json:"result"`
+	Error   string          `json:"error"`
+	Message string          `json:"message"`
+}
+
+// bidiConn is a WebSocket connection to a BiDi browser.
+type bidiConn struct {
+	ws      *websocket.Conn
+	writeMu sync.Mutex
+	nextID  int64
+
+	mu      sync.Mutex
+	pending map[int64]chan bidiMessage
+	// err is set before closed is closed, when the connection is lost.
+	err    error
+	closed chan struct{}
+
+	// ownsSession is set when the driver started the session, which it
+	// then ends.
+	ownsSession bool
+	// userAgent is the browser's own User-Agent.
+	userAgent string
+}
+
+func dialBiDi(ctx context.Context, endpoint string) (*bidiConn, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") {
+		return nil, fmt.Errorf("bidi driver needs a ws:// or wss:// endpoint, not %q", endpoint)
+	}
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("connect to bidi endpoint: %w", err)
+	}
+	c := &bidiConn{
+		ws:      ws,
+		pending: make(map[int64]chan bidiMessage),
+		closed:  make(chan struct{}),
+	}
+	go c.read()
+	if strings.HasSuffix(u.Path, "/session") {
+		var session struct {
+			Capabilities struct {
+				UserAgent string `json:"userAgent"`
+			} `json:"capabilities"`
+		}
+		params := map[string]interface{}{"capabilities": map[string]interface{}{}}
+		if err := c.call(ctx, "session.new", params, &session); err != nil {
+			c.close()
+			return nil, err
+		}
+		c.ownsSession = true
+		c.userAgent = session.Capabilities.UserAgent
+	}
+	return c, nil
+}
+
+// read hands the responses of the browser to the commands waiting for them
+// until the connection is lost. Events are not subscribed to, so none come.
+func (c *bidiConn) read() {
+	for {
+		var msg bidiMessage
+		if err := c.ws.ReadJSON(&msg); err != nil {
+			c.fail(err)
+			return
+		}
+		if msg.ID == nil {
+			continue
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[*msg.ID]
+		c.mu.Unlock()
+		if ok {
+			ch <- msg
+		}
+	}
+}
+
+func (c *bidiConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = fmt.Errorf("bidi connection lost: %w", err)
+		close(c.closed)
+	}
+}
+
+// call sends a command and decodes its result into result, unless it is
+// nil.
+func (c *bidiConn) call(ctx context.Context, method string, params, result interface{}) error {
+	if params == nil {
+		params = struct{}{}
+	}
+	id := atomic.AddInt64(&c.nextID, 1)
+	ch := make(chan bidiMessage, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	c.writeMu.Lock()
+	err := c.ws.WriteJSON(map[string]interface{}{"id": id, "method": method, "params": params})
+	c.writeMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	select {
+	case msg := <-ch:
+		if msg.Error != "" || msg.Type == "error" {
+			return fmt.Errorf("%s: %s: %s", method, msg.Error, msg.Message)
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(msg.Result, result)
+	case <-c.closed:
+		return c.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close ends the session the driver started and closes the connection.
+func (c *bidiConn) close() {
+	if c.ownsSession {
+		ctx, cancel := context.WithTimeout(context.Background(), bidiCloseTimeout)
+		c.call(ctx, "session.end", nil, nil)
+		cancel()
+	}
+	c.ws.Close()
+}
+
+// newTab opens a tab and returns a context running in it, cancelled when
+// the connection is lost. Cancelling it closes the tab.
+func (c *bidiConn) newTab(ctx context.Context) (context.Context, context.CancelFunc) {
+	var created struct {
+		Context string `json:"context"`
+	}
+	if err := c.call(ctx, "browsingContext.create", map[string]interface{}{"type": "tab"}, &created); err != nil {
+		failed, cancel := context.WithCancelCause(ctx)
+		cancel(err)
+		return failed, func() { cancel(nil) }
+	}
+	tab := &bidiTab{conn: c, context: created.Context}
+	tabCtx, cancel := context.WithCancelCause(withPortableRunner(ctx, tab))
+	go func() {
+		select {
+		case <-c.closed:
+			cancel(c.err)
+		case <-tabCtx.Done():
+		}
+	}()
+	var once sync.Once
+	return tabCtx, func() {
+		once.Do(func() {
+			cancel(nil)
+			closeCtx, done := context.WithTimeout(context.Background(), bidiCloseTimeout)
+			defer done()
+			c.call(closeCtx, "browsingContext.close", map[string]interface{}{"context": tab.context}, nil)
+		})
+	}
+}
+
+// bidiTab is a browsing context of a BiDi browser. It runs the portable
+// actions, and as the cdp.Executor of the actions run in it, it translates
+// the DevTools commands BiDi has counterparts for.
+type bidiTab struct {
+	conn    *bidiConn
+	context string
+
+	// Overrides are only cleared once set, as not every browser has
+	// the commands.
+	userAgentSet atomic.Bool
+	headersSet   atomic.Bool
+}
+
+func (t *bidiTab) call(ctx context.Context, method string, params map[string]interface{}, result interface{}) error {
+	params["context"] = t.context
+	return t.conn.call(ctx, method, params, result)
+}
+
+func (t *bidiTab) navigate(ctx context.Context, url string) error {
+	return t.call(ctx, "browsingContext.navigate", map[string]interface{}{"url": url, "wait": "complete"}, nil)
+}
+
+func (t *bidiTab) Execute(ctx context.Context, method string, params easyjson.Marshaler, res easyjson.Unmarshaler) error {
+	switch p := params.(type) {
+	case *page.NavigateParams:
+		return t.navigate(ctx, p.URL)
+	case *page.ReloadParams:
+		return t.call(ctx, "browsingContext.reload", map[string]interface{}{"wait": "complete"}, nil)
+	case *runtime.EvaluateParams:
+		return t.evaluate(ctx, p, res.(*runtime.EvaluateReturns))
+	case *page.CaptureScreenshotParams:
+		return t.screenshot(ctx, p, res.(*page.CaptureScreenshotReturns))
+	case *emulation.SetDeviceMetricsOverrideParams:
+		if p.Width == 0 || p.Height == 0 {
+			return t.setViewport(ctx, nil, 0)
+		}
+		return t.setViewport(ctx, map[string]interface{}{"width": p.Width, "height": p.Height}, p.DeviceScaleFactor)
+	case *emulation.SetTouchEmulationEnabledParams:
+		if !p.Enabled {
+			return nil
+		}
+	case *emulation.SetUserAgentOverrideParams:
+		return t.setUserAgent(ctx, p)
+	case *network.SetExtraHTTPHeadersParams:
+		return t.setHeaders(ctx, p.Headers)
+	case *network.SetCookieParams:
+		return t.setCookie(ctx, p)
+	case *network.GetCookiesParams:
+		cookies, err := t.cookies(ctx)
+		res.(*network.GetCookiesReturns).Cookies = cookies
+		return err
+	case *storage.GetCookiesParams:
+		cookies, err := t.cookies(ctx)
+		res.(*storage.GetCookiesReturns).Cookies = cookies
+		return err
+	}
+	// Commands without parameters have none to switch on.
+	switch method {
+	case network.CommandEnable, page.CommandEnable, runtime.CommandEnable:
+		// BiDi has no domains to enable.
+		return nil
+	case emulation.CommandClearDeviceMetricsOverride:
+		return t.setViewport(ctx, nil, 0)
+	case browser.CommandGetVersion:
+		v := res.(*browser.GetVersionReturns)
+		v.Product, v.UserAgent = "bidi", t.conn.userAgent
+		return nil
+	}
+	return fmt.Errorf("%s %w", method, errBiDiUnsupported)
+}
+
+// bidiValue is a value serialized by the browser.
+type bidiValue struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// decode returns the JSON counterpart of v, as DevTools returns values by
+// value: objects and maps become objects, arrays and sets arrays, and
+// values JSON has no room for, such as DOM nodes, empty objects.
+func (v bidiValue) decode() interface{} {
+	switch v.Type {
+	case "undefined", "null":
+		return nil
+	case "string", "boolean", "bigint", "date":
+		var out interface{}
+		json.Unmarshal(v.Value, &out)
+		return out
+	case "number":
+		var out interface{}
+		json.Unmarshal(v.Value, &out)
+		if _, special := out.(string); special {
+			// NaN, -0 and the infinities have no JSON form.
+			return nil
+		}
+		return out
+	case "array", "set":
+		var items []bidiValue
+		json.Unmarshal(v.Value, &items)
+		out := make([]interface{}, len(items))
+		for i, item := range items {
+			out[i] = item.decode()
+		}
+		return out
+	case "object", "map":
+		var entries [][2]json.RawMessage
+		json.Unmarshal(v.Value, &entries)
+		out := make(map[string]interface{}, len(entries))
+		for _, entry := range entries {
+			var key string
+			if json.Unmarshal(entry[0], &key) != nil {
+				var k bidiValue
+				json.Unmarshal(entry[0], &k)
+				key = fmt.Sprint(k.decode())
+			}
+			var value bidiValue
+			json.Unmarshal(entry[1], &value)
+			out[key] = value.decode()
+		}
+		return out
+	}
+	return map[string]interface{}{}
+}
+
+// remoteObject returns v as DevTools returns a value by value.
+func (v bidiValue) remoteObject() (*runtime.RemoteObject, error) {
+	obj := &runtime.RemoteObject{Type: runtime.TypeObject}
+	switch v.Type {
+	case "undefined":
+		obj.Type = runtime.TypeUndefined
+		return obj, nil
+	case "null":
+		obj.Subtype = runtime.SubtypeNull
+		return obj, nil
+	case "string", "number", "boolean", "bigint", "function":
+		obj.Type = runtime.Type(v.Type)
+	}
+	data, err := json.Marshal(v.decode())
+	if err != nil {
+		return nil, err
+	}
+	obj.Value = data
+	return obj, nil
+}
+
+type bidiEvaluateResult struct {
+	Type             string    `json:"type"`
+	Result           bidiValue `json:"result"`
+	ExceptionDetails *struct {
+		Text         string `json:"text"`
+		LineNumber   int64  `json:"lineNumber"`
+		ColumnNumber int64  `json:"columnNumber"`
+	} `json:"exceptionDetails"`
+}
+
+func (t *bidiTab) script(ctx context.Context, expression string, awaitPromise bool) (*bidiEvaluateResult, error) {
+	var out bidiEvaluateResult
+	err := t.conn.call(ctx, "script.evaluate", map[string]interface{}{
+		"expression":      expression,
+		"target":          map[string]interface{}{"context": t.context},
+		"awaitPromise":    awaitPromise,
+		"resultOwnership": "none",
+	}, &out)
+	return &out, err
+}
+
+func (t *bidiTab) evaluate(ctx context.Context, p *runtime.EvaluateParams, res *runtime.EvaluateReturns) error {
+	out, err := t.script(ctx, p.Expression, p.AwaitPromise)
+	if err != nil {
+		return err
+	}
+	if out.Type == "exception" && out.ExceptionDetails != nil {
+		e := out.ExceptionDetails
+		res.Result = &runtime.RemoteObject{Type: runtime.TypeObject}
+		res.ExceptionDetails = &runtime.ExceptionDetails{
+			Text:         "Uncaught",
+			LineNumber:   e.LineNumber,
+			ColumnNumber: e.ColumnNumber,
+			Exception:    &runtime.RemoteObject{Type: runtime.TypeObject, Description: e.Text},
+		}
+		return nil
+	}
+	res.Result, err = out.Result.remoteObject()
+	return err
+}
+
+// eval evaluates expression in the tab and decodes its value into v.
+func (t *bidiTab) eval(ctx context.Context, expression string, v interface{}) error {
+	out, err := t.script(ctx, expression, false)
+	if err != nil {
+		return err
+	}
+	if out.Type == "exception" && out.ExceptionDetails != nil {
+		return fmt.Errorf("script error: %s", out.ExceptionDetails.Text)
+	}
+	data, err := json.Marshal(out.Result.decode())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (t *bidiTab) screenshot(ctx context.Context, p *page.CaptureScreenshotParams, res *page.CaptureScreenshotReturns) error {
+	params := map[string]interface{}{"origin": "viewport"}
+	if p.CaptureBeyondViewport {
+		params["origin"] = "document"
+	}
+	if p.Format == page.CaptureScreenshotFormatJpeg {
+		format := map[string]interface{}{"type": "image/jpeg"}
+		if p.Quality > 0 {
+			format["quality"] = float64(p.Quality) / 100
+		}
+		params["format"] = format
+	}
+	if c := p.Clip; c != nil {
+		// DevTools clips are in page coordinates. BiDi cannot scale a
+		// screenshot, so a clip is captured at its size.
+		params["origin"] = "document"
+		params["clip"] = map[string]interface{}{"type": "box", "x": c.X, "y": c.Y, "width": c.Width, "height": c.Height}
+	}
+	var out struct {
+		Data string `json:"data"`
+	}
+	if err := t.call(ctx, "browsingContext.captureScreenshot", params, &out); err != nil {
+		return err
+	}
+	res.Data = out.Data
+	return nil
+}
+
+func (t *bidiTab) setViewport(ctx context.Context, viewport map[string]interface{}, scale float64) error {
+	params := map[string]interface{}{"viewport": viewport}
+	if viewport != nil && scale > 0 {
+		params["devicePixelRatio"] = scale
+	} else {
+		params["devicePixelRatio"] = nil
+	}
+	return t.call(ctx, "browsingContext.setViewport", params, nil)
+}
+
+// setUserAgent overrides the User-Agent of the tab; the browser's own one
+// clears the override.
+func (t *bidiTab) setUserAgent(ctx context.Context, p *emulation.SetUserAgentOverrideParams) error {
+	if p.AcceptLanguage != "" {
+		return fmt.Errorf("Accept-Language overrides are %w", errBiDiUnsupported)
+	}
+	var userAgent interface{} = p.UserAgent
+	if p.UserAgent == "" || p.UserAgent == t.conn.userAgent {
+		if !t.userAgentSet.Load() {
+			return nil
+		}
+		userAgent = nil
+	}
+	err := t.conn.call(ctx, "emulation.setUserAgentOverride", map[string]interface{}{
+		"userAgent": userAgent,
+		"contexts":  []string{t.context},
+	}, nil)
+	if err == nil {
+		t.userAgentSet.Store(userAgent != nil)
+	}
+	return err
+}
+
+func (t *bidiTab) setHeaders(ctx context.Context, headers network.Headers) error {
+	if len(headers) == 0 && !t.headersSet.Load() {
+		return nil
+	}
+	list := make([]map[string]interface{}, 0, len(headers))
+	for name, value := range headers {
+		list = append(list, map[string]interface{}{
+			"name":  name,
+			"value": map[string]interface{}{"type": "string", "value": fmt.Sprint(value)},
+		})
+	}
+	err := t.conn.call(ctx, "network.setExtraHeaders", map[string]interface{}{
+		"headers":  list,
+		"contexts": []string{t.context},
+	}, nil)
+	if err == nil {
+		t.headersSet.Store(len(headers) > 0)
+	}
+	return err
+}
+
+func (t *bidiTab) partition() map[string]interface{} {
+	return map[string]interface{}{"type": "context", "context": t.context}
+}
+
+func (t *bidiTab) setCookie(ctx context.Context, p *network.SetCookieParams) error {
+	domain := p.Domain
+	if domain == "" {
+		u, err := url.Parse(p.URL)
+		if err != nil || u.Hostname() == "" {
+			return fmt.Errorf("cookie %s needs a domain", p.Name)
+		}
+		domain = u.Hostname()
+	}
+	cookie := map[string]interface{}{
+		"name":     p.Name,
+		"value":    map[string]interface{}{"type": "string", "value": p.Value},
+		"domain":   domain,
+		"httpOnly": p.HTTPOnly,
+		"secure":   p.Secure,
+	}
+	if p.Path != "" {
+		cookie["path"] = p.Path
+	}
+	if p.SameSite != "" {
+		cookie["sameSite"] = strings.ToLower(string(p.SameSite))
+	}
+	if p.Expires != nil {
+		cookie["expiry"] = p.Expires.Time().Unix()
+	}
+	return t.conn.call(ctx, "storage.setCookie", map[string]interface{}{
+		"cookie":    cookie,
+		"partition": t.partition(),
+	}, nil)
+}
+
+func (t *bidiTab) cookies(ctx context.Context) ([]*network.Cookie, error) {
+	var out struct {
+		Cookies []struct {
+			Name  string `json:"name"`
+			Value struct {
+				Type  string `json:"type"`
+				Value string `json:"value"`
+			} `json:"value"`
+			Domain   string `json:"domain"`
+			Path     string `json:"path"`
+			Size     int64  `json:"size"`
+			HTTPOnly bool   `json:"httpOnly"`
+			Secure   bool   `json:"secure"`
+			SameSite string `json:"sameSite"`
+			Expiry   *int64 `json:"expiry"`
+		} `json:"cookies"`
+	}
+	err := t.conn.call(ctx, "storage.getCookies", map[string]interface{}{"partition": t.partition()}, &out)
+	if err != nil {
+		return nil, err
+	}
+	cookies := make([]*network.Cookie, 0, len(out.Cookies))
+	for _, c := range out.Cookies {
+		value := c.Value.Value
+		if c.Value.Type == "base64" {
+			if data, err := base64.StdEncoding.DecodeString(value); err == nil {
+				value = string(data)
+			}
+		}
+		cookie := &network.Cookie{
+			Name:         c.Name,
+			Value:        value,
+			Domain:       c.Domain,
+			Path:         c.Path,
+			Size:         c.Size,
+			HTTPOnly:     c.HTTPOnly,
+			Secure:       c.Secure,
+			Session:      c.Expiry == nil,
+			Expires:      -1,
+			Priority:     network.CookiePriorityMedium,
+			SourceScheme: network.CookieSourceSchemeUnset,
+			SourcePort:   -1,
+		}
+		if c.Expiry != nil {
+			cookie.Expires = float64(*c.Expiry)
+		}
+		switch c.SameSite {
+		case "strict":
+			cookie.SameSite = network.CookieSameSiteStrict
+		case "lax":
+			cookie.SameSite = network.CookieSameSiteLax
+		case "none":
+			cookie.SameSite = network.CookieSameSiteNone
+		}
+		cookies = append(cookies, cookie)
+	}
+	return cookies, nil
+}
+
+// bidiElementJS passes the element a selector matches, as a CSS selector
+// or else as an XPath expression, to a function, and returns its result;
+// null when nothing matches.
+const bidiElementJS = `(function(sel, fn) {
+	let el = null;
+	try { el = document.querySelector(sel); } catch (e) {}
+	if (!el) {
+		try { el = document.evaluate(sel, document, null, XPathResult.FIRST_ORDERED_NODE_TYPE, null).singleNodeValue; } catch (e) {}
+	}
+	if (el && el.nodeType !== Node.ELEMENT_NODE) {
+		el = el.parentElement;
+	}
+	return el ? fn(el) : null;
+})(%s, %s)`
+
+const (
+	bidiStateJS = `el => {
+	const r = el.getBoundingClientRect(), s = getComputedStyle(el);
+	return {
+		visible: r.width > 0 && r.height > 0 && s.visibility !== "hidden",
+		enabled: !el.disabled,
+		rect: [r.x, r.y, r.width, r.height],
+		text: el.innerText === undefined ? el.textContent : el.innerText,
+	};
+}`
+	bidiCenterJS = `el => {
+	el.scrollIntoView({block: "center", inline: "center"});
+	const r = el.getBoundingClientRect();
+	return [Math.round(r.x + r.width / 2), Math.round(r.y + r.height / 2)];
+}`
+	bidiFocusJS = `el => { el.focus(); return true; }`
+)
+
+type bidiElementState struct {
+	Visible bool       `json:"visible"`
+	Enabled bool       `json:"enabled"`
+	Rect    [4]float64 `json:"rect"`
+	Text    string     `json:"text"`
+}
+
+// element waits for the element of a as chromedp does, visible unless it
+// only waits for it to be there, enabled or stable, and then acts on it.
+func (t *bidiTab) element(ctx context.Context, a elementAction) error {
+	var state, prev *bidiElementState
+	for {
+		state = nil
+		if err := t.eval(ctx, fmt.Sprintf(bidiElementJS, jsString(a.sel), bidiStateJS), &state); err != nil {
+			return err
+		}
+		if state != nil {
+			ready := state.Visible
+			switch a.op {
+			case opWaitReady:
+				ready = true
+			case opWaitEnabled:
+				ready = state.Enabled
+			case opWaitStable:
+				ready = prev != nil && prev.Rect == state.Rect
+			}
+			if ready {
+				break
+			}
+		}
+		prev = state
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(bidiPollInterval):
+		}
+	}
+
+	switch a.op {
+	case opClick:
+		var center [2]int
+		if err := t.eval(ctx, fmt.Sprintf(bidiElementJS, jsString(a.sel), bidiCenterJS), &center); err != nil {
+			return err
+		}
+		return t.input(ctx, map[string]interface{}{
+			"type":       "pointer",
+			"id":         "mouse",
+			"parameters": map[string]interface{}{"pointerType": "mouse"},
+			"actions": []map[string]interface{}{
+				{"type": "pointerMove", "x": center[0], "y": center[1]},
+				{"type": "pointerDown", "button": 0},
+				{"type": "pointerUp", "button": 0},
+			},
+		})
+	case opSendKeys:
+		var focused bool
+		if err := t.eval(ctx, fmt.Sprintf(bidiElementJS, jsString(a.sel), bidiFocusJS), &focused); err != nil {
+			return err
+		}
+		var keys []map[string]interface{}
+		for _, r := range a.value {
+			key := string(r)
+			switch r {
+			case '\n', '\r':
+				key = "\uE007" // Enter
+			case '\t':
+				key = "\uE004" // Tab
+			}
+			keys = append(keys,
+				map[string]interface{}{"type": "keyDown", "value": key},
+				map[string]interface{}{"type": "keyUp", "value": key},
+			)
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		return t.input(ctx, map[string]interface{}{"type": "key", "id": "keyboard", "actions": keys})
+	case opText:
+		*a.text = state.Text
+	}
+	return nil
+}
+
+func (t *bidiTab) input(ctx context.Context, source map[string]interface{}) error {
+	return t.call(ctx, "input.performActions", map[string]interface{}{
+		"actions": []map[string]interface{}{source},
+	}, nil)
+}
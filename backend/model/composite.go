@@ -0,0 +1,253 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// Composite actions run another action repeatedly. They are registered in
+// init because they look up actionHandlers themselves.
+func init() {
+	actionHandlers["paginate"] = paginateAction
+	actionHandlers["scrollAndCollect"] = scrollAndCollectAction
+}
+
+// defaultMaxPages bounds a paginate step that sets no "maxPages".
+const defaultMaxPages = 10
+
+// nextPageJS reports whether the next-page control exists and can be used.
+const nextPageJS = `(function(sel) {
+	const el = document.querySelector(sel);
+	return !!el && !el.disabled && el.getAttribute('aria-disabled') !== 'true' && el.getClientRects().length > 0;
+})(%s)`
+
+// scrollJS scrolls a container, or the page when the selector is null, to
+// its end and reports whether there was one.
+const scrollJS = `(function(sel) {
+	const el = sel ? document.querySelector(sel) : document.scrollingElement;
+	if (!el) { return false; }
+	el.scrollTop = el.scrollHeight;
+	return true;
+})(%s)`
+
+// resourceCountJS counts the requests the page has completed.
+const resourceCountJS = `(function() {
+	performance.setResourceTimingBufferSize(100000);
+	return performance.getEntriesByType('resource').length;
+})()`
+
+// innerStep returns the action and parameters of the step a composite
+// action repeats, given as {"action": ..., "params": {...}} under "step".
+func innerStep(params map[string]interface{}, composite string) (actionHandler, map[string]interface{}, error) {
+	step, ok := params["step"].(map[string]interface{})
+	if !ok {
+		return nil, nil, errors.New("missing step parameter")
+	}
+	action, _ := step["action"].(string)
+	if action == "" || action == composite {
+		return nil, nil, fmt.Errorf("invalid step action: %q", action)
+	}
+	handler, ok := actionHandlers[action]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown action: %s", action)
+	}
+	stepParams, _ := step["params"].(map[string]interface{})
+	if stepParams == nil {
+		stepParams = map[string]interface{}{}
+	}
+	return handler, stepParams, nil
+}
+
+// mergeResult appends the result of one repetition to merged: the elements
+// of a JSON array, any other JSON value, or the plain string. It reports
+// how many items were added.
+func mergeResult(merged []interface{}, result string) ([]interface{}, int) {
+	if strings.TrimSpace(result) == "" {
+		return merged, 0
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(result), &v); err != nil {
+		return append(merged, result), 1
+	}
+	if items, ok := v.([]interface{}); ok {
+		return append(merged, items...), len(items)
+	}
+	return append(merged, v), 1
+}
+
+func intParam(params map[string]interface{}, key string, def int) int {
+	if v, ok := params[key].(float64); ok && v > 0 {
+		return int(v)
+	}
+	return def
+}
+
+// paginateAction runs "step" on each page of a paginated listing and
+// returns the merged results as a JSON array. Pages are reached by
+// clicking "next" until it disappears or is disabled, or by navigating to
+// "urlPattern" with "{page}" replaced by the page number until a page
+// yields nothing. "maxPages" bounds the pages visited and "delay" is the
+// time in milliseconds given a clicked page to load (default 1000).
+func paginateAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	handler, stepParams, err := innerStep(params, "paginate")
+	if err != nil {
+		return "", err
+	}
+	next, _ := params["next"].(string)
+	urlPattern, _ := params["urlPattern"].(string)
+	if (next == "") == (urlPattern == "") {
+		return "", errors.New("paginate needs either a next or a urlPattern parameter")
+	}
+	if urlPattern != "" && !strings.Contains(urlPattern, "{page}") {
+		return "", errors.New("urlPattern must contain {page}")
+	}
+	maxPages := intParam(params, "maxPages", defaultMaxPages)
+	delay := time.Duration(intParam(params, "delay", 1000)) * time.Millisecond
+	page := intParam(params, "startPage", 1)
+
+	merged := []interface{}{}
+	for visited := 1; ; visited++ {
+		result, err := handler(ctx, i, stepParams)
+		if err != nil {
+			return "", fmt.Errorf("page %d: %w", page, err)
+		}
+		var added int
+		merged, added = mergeResult(merged, result)
+		if visited >= maxPages || urlPattern != "" && added == 0 {
+			break
+		}
+
+		page++
+		if urlPattern != "" {
+			url := strings.ReplaceAll(urlPattern, "{page}", strconv.Itoa(page))
+			if err := i.driver().Run(ctx, navigateTo(url)); err != nil {
+				return "", fmt.Errorf("page %d: %w", page, err)
+			}
+			continue
+		}
+		var more bool
+		if err := i.driver().Run(ctx, chromedp.Evaluate(fmt.Sprintf(nextPageJS, jsString(next)), &more)); err != nil {
+			return "", err
+		}
+		if !more {
+			break
+		}
+		if err := i.driver().Run(ctx, chromedp.Click(next, chromedp.ByQuery), chromedp.Sleep(delay), chromedp.WaitReady("body", chromedp.ByQuery)); err != nil {
+			return "", fmt.Errorf("page %d: %w", page, err)
+		}
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// scrollAndCollectAction scrolls the page, or the "container" element, to
+// its end repeatedly, waits for the network to go idle and runs "step"
+// after each scroll. The distinct items extracted are returned as a JSON
+// array once a scroll yields nothing new, after "maxScrolls" (default 20)
+// or on reaching "maxItems". "idle" is how long in milliseconds no request
+// may complete for the network to count as idle (default 500), "timeout"
+// how long to wait for that after a scroll (default 10000).
+func scrollAndCollectAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	handler, stepParams, err := innerStep(params, "scrollAndCollect")
+	if err != nil {
+		return "", err
+	}
+	container := "null"
+	if sel, ok := params["container"].(string); ok && sel != "" {
+		container = jsString(sel)
+	}
+	maxScrolls := intParam(params, "maxScrolls", 20)
+	maxItems := intParam(params, "maxItems", 0)
+	idle := time.Duration(intParam(params, "idle", 500)) * time.Millisecond
+	timeout := time.Duration(intParam(params, "timeout", 10000)) * time.Millisecond
+
+	seen := make(map[string]bool)
+	collected := []interface{}{}
+	collect := func() (int, error) {
+		result, err := handler(ctx, i, stepParams)
+		if err != nil {
+			return 0, err
+		}
+		items, _ := mergeResult(nil, result)
+		added := 0
+		for _, item := range items {
+			key, err := json.Marshal(item)
+			if err != nil || seen[string(key)] {
+				continue
+			}
+			seen[string(key)] = true
+			collected = append(collected, item)
+			added++
+		}
+		return added, nil
+	}
+
+	if _, err := collect(); err != nil {
+		return "", err
+	}
+	for scroll := 1; scroll <= maxScrolls; scroll++ {
+		if maxItems > 0 && len(collected) >= maxItems {
+			break
+		}
+		var found bool
+		if err := i.driver().Run(ctx, chromedp.Evaluate(fmt.Sprintf(scrollJS, container), &found)); err != nil {
+			return "", err
+		}
+		if !found {
+			return "", fmt.Errorf("scroll container not found: %s", params["container"])
+		}
+		if err := i.waitNetworkIdle(ctx, idle, timeout); err != nil {
+			return "", err
+		}
+		added, err := collect()
+		if err != nil {
+			return "", fmt.Errorf("scroll %d: %w", scroll, err)
+		}
+		if added == 0 {
+			break
+		}
+	}
+
+	if maxItems > 0 && len(collected) > maxItems {
+		collected = collected[:maxItems]
+	}
+	data, err := json.Marshal(collected)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// waitNetworkIdle waits until no request has completed for idle, giving up
+// quietly after timeout since pages that poll never go idle.
+func (i *Instance) waitNetworkIdle(ctx context.Context, idle, timeout time.Duration) error {
+	const poll = 100 * time.Millisecond
+	deadline := time.Now().Add(timeout)
+	last, quietSince := -1, time.Now()
+	for time.Now().Before(deadline) {
+		var count int
+		if err := i.driver().Run(ctx, chromedp.Evaluate(resourceCountJS, &count)); err != nil {
+			return err
+		}
+		if count != last {
+			last, quietSince = count, time.Now()
+		} else if time.Since(quietSince) >= idle {
+			return nil
+		}
+		if err := i.driver().Run(ctx, chromedp.Sleep(poll)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
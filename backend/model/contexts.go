@@ -0,0 +1,43 @@
+package model
+
+import (
+	"sort"
+	"time"
+)
+
+// BrowserContext is the open browser of an instance.
+type BrowserContext struct {
+	InstanceID string    `json:"instance_id"`
+	Driver     string    `json:"driver,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	AgeSeconds int64     `json:"age_seconds"`
+	ActiveRuns int       `json:"active_runs"`
+	Healthy    bool      `json:"healthy"`
+}
+
+// BrowserContexts returns the browsers open on this server, oldest first,
+// so contexts that outlive their use stand out.
+func (im *InstanceManager) BrowserContexts() []BrowserContext {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	now := time.Now()
+	contexts := make([]BrowserContext, 0, len(im.instances))
+	for _, instance := range im.instances {
+		instance.stateMu.RLock()
+		chromeCtx, startedAt, driver := instance.ChromeCtx, instance.startedAt, instance.Driver.Name
+		instance.stateMu.RUnlock()
+		if chromeCtx == nil || chromeCtx.Err() != nil {
+			continue
+		}
+		contexts = append(contexts, BrowserContext{
+			InstanceID: instance.ID,
+			Driver:     driver,
+			StartedAt:  startedAt,
+			AgeSeconds: int64(now.Sub(startedAt).Seconds()),
+			ActiveRuns: instance.ActiveRuns(),
+			Healthy:    instance.Healthy(),
+		})
+	}
+	sort.Slice(contexts, func(i, j int) bool { return contexts[i].StartedAt.Before(contexts[j].StartedAt) })
+	return contexts
+}
@@ -0,0 +1,174 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/storage"
+	"github.com/chromedp/chromedp"
+)
+
+// Cookie is a browser cookie as exported and imported. Expires is in
+// seconds since the epoch; 0 makes a session cookie.
+type Cookie struct {
+	Name     string  `json:"name"`
+	Value    string  `json:"value"`
+	Domain   string  `json:"domain"`
+	Path     string  `json:"path,omitempty"`
+	Expires  float64 `json:"expires,omitempty"`
+	Secure   bool    `json:"secure,omitempty"`
+	HTTPOnly bool    `json:"http_only,omitempty"`
+	SameSite string  `json:"same_site,omitempty"`
+}
+
+func (c Cookie) validate() error {
+	if c.Name == "" || c.Domain == "" {
+		return errors.New("cookies need a name and a domain")
+	}
+	switch network.CookieSameSite(c.SameSite) {
+	case "", network.CookieSameSiteStrict, network.CookieSameSiteLax, network.CookieSameSiteNone:
+		return nil
+	default:
+		return fmt.Errorf("cookie %s: invalid same_site %q", c.Name, c.SameSite)
+	}
+}
+
+// inDomain reports whether the cookie is sent to domain or its subdomains.
+func (c Cookie) inDomain(domain string) bool {
+	cookieDomain := strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	return cookieDomain == domain || strings.HasSuffix(cookieDomain, "."+domain)
+}
+
+func validateCookies(cookies []Cookie) error {
+	for _, c := range cookies {
+		if err := c.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setCookies sets cookies in the browser.
+func setCookies(cookies []Cookie) chromedp.ActionFunc {
+	return func(ctx context.Context) error {
+		for _, c := range cookies {
+			set := network.SetCookie(c.Name, c.Value).
+				WithDomain(c.Domain).
+				WithSecure(c.Secure).
+				WithHTTPOnly(c.HTTPOnly)
+			if c.Path != "" {
+				set = set.WithPath(c.Path)
+			}
+			if c.Expires > 0 {
+				expires := cdp.TimeSinceEpoch(time.Unix(0, int64(c.Expires*float64(time.Second))))
+				set = set.WithExpires(&expires)
+			}
+			if c.SameSite != "" {
+				set = set.WithSameSite(network.CookieSameSite(c.SameSite))
+			}
+			if err := set.Do(ctx); err != nil {
+				return fmt.Errorf("set cookie %s: %w", c.Name, err)
+			}
+		}
+		return nil
+	}
+}
+
+// ExportCookies returns the cookies of a running instance, only those of
+// domain and its subdomains when domain is set.
+func (im *InstanceManager) ExportCookies(id, domain string) ([]Cookie, error) {
+	instance, err := im.runningInstance(id)
+	if err != nil {
+		return nil, err
+	}
+	var all []*network.Cookie
+	err = instance.driver().Run(instance.liveContext(), chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		all, err = storage.GetCookies().Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	cookies := []Cookie{}
+	for _, c := range all {
+		cookie := Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HTTPOnly: c.HTTPOnly,
+			SameSite: string(c.SameSite),
+		}
+		if !c.Session {
+			cookie.Expires = c.Expires
+		}
+		if domain == "" || cookie.inDomain(domain) {
+			cookies = append(cookies, cookie)
+		}
+	}
+	return cookies, nil
+}
+
+// ImportCookies sets cookies in a running instance.
+func (im *InstanceManager) ImportCookies(id string, cookies []Cookie) error {
+	if err := validateCookies(cookies); err != nil {
+		return err
+	}
+	instance, err := im.runningInstance(id)
+	if err != nil {
+		return err
+	}
+	return instance.driver().Run(instance.liveContext(), setCookies(cookies))
+}
+
+// CopyCookies imports the cookies of one running instance into another,
+// only those of domain when it is set, and returns how many were copied.
+func (im *InstanceManager) CopyCookies(fromID, toID, domain string) (int, error) {
+	cookies, err := im.ExportCookies(fromID, domain)
+	if err != nil {
+		return 0, err
+	}
+	if err := im.ImportCookies(toID, cookies); err != nil {
+		return 0, err
+	}
+	return len(cookies), nil
+}
+
+// SetInstanceSeedCookies sets the cookies an instance gets each time it
+// starts, before it logs in, so it can reuse a session made elsewhere.
+func (im *InstanceManager) SetInstanceSeedCookies(id string, cookies []Cookie) error {
+	if err := validateCookies(cookies); err != nil {
+		return err
+	}
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return err
+	}
+	instance.stateMu.Lock()
+	instance.SeedCookies = cookies
+	instance.stateMu.Unlock()
+
+	im.saveInstance(instance)
+
+	return nil
+}
+
+func (im *InstanceManager) runningInstance(id string) (*Instance, error) {
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return nil, err
+	}
+	if instance.liveContext() == nil {
+		return nil, ErrInstanceNotRunning
+	}
+	return instance, nil
+}
@@ -0,0 +1,129 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+// Built-in browser drivers. DriverChromedp launches a local Chrome;
+// DriverRemote attaches to a browser already running elsewhere that speaks
+// the Chrome DevTools protocol, such as a browser service or grid node, at
+// the DevTools WebSocket URL in Endpoint; DriverBiDi drives a browser that
+// speaks WebDriver BiDi, such as Firefox, at the BiDi WebSocket URL in
+// Endpoint.
+const (
+	DriverChromedp = "chromedp"
+	DriverRemote   = "remote"
+	DriverBiDi     = "bidi"
+)
+
+// BrowserDriver starts browsers and runs actions in them for instances.
+// Actions are chromedp actions. A driver whose browser does not speak the
+// DevTools protocol runs the portable actions itself and translates the
+// DevTools commands of the others, as BiDiDriver does.
+type BrowserDriver interface {
+	Run(context.Context, ...chromedp.Action) error
+	NewContext(context.Context) (context.Context, context.CancelFunc)
+}
+
+// DriverConfig selects the browser driver of an instance. An empty Name is
+// DriverChromedp.
+type DriverConfig struct {
+	Name     string `json:"name,omitempty"`
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// DriverFactory returns a driver configured by cfg.
+type DriverFactory func(cfg DriverConfig) (BrowserDriver, error)
+
+var drivers = map[string]DriverFactory{
+	DriverChromedp: func(DriverConfig) (BrowserDriver, error) {
+		return &DefaultChromeDPContext{}, nil
+	},
+	DriverRemote: func(cfg DriverConfig) (BrowserDriver, error) {
+		if !strings.HasPrefix(cfg.Endpoint, "ws://") && !strings.HasPrefix(cfg.Endpoint, "wss://") {
+			return nil, errors.New("remote driver needs a ws:// or wss:// endpoint")
+		}
+		return &RemoteDriver{Endpoint: cfg.Endpoint}, nil
+	},
+	DriverBiDi: func(cfg DriverConfig) (BrowserDriver, error) {
+		if !strings.HasPrefix(cfg.Endpoint, "ws://") && !strings.HasPrefix(cfg.Endpoint, "wss://") {
+			return nil, errors.New("bidi driver needs a ws:// or wss:// endpoint")
+		}
+		return &BiDiDriver{Endpoint: cfg.Endpoint}, nil
+	},
+}
+
+// RegisterDriver adds a browser driver to the registry. Like
+// RegisterAction it is meant to be called at startup and refuses to
+// replace an existing driver.
+func RegisterDriver(name string, factory DriverFactory) error {
+	if name == "" {
+		return errors.New("driver name is empty")
+	}
+	if _, exists := drivers[name]; exists {
+		return fmt.Errorf("driver already registered: %s", name)
+	}
+	drivers[name] = factory
+	return nil
+}
+
+// Drivers returns the names of every registered browser driver.
+func Drivers() []string {
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newDriver(cfg DriverConfig) (BrowserDriver, error) {
+	name := cfg.Name
+	if name == "" {
+		name = DriverChromedp
+	}
+	factory, ok := drivers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown browser driver: %s", name)
+	}
+	return factory(cfg)
+}
+
+// RemoteDriver runs instances in a browser reached at a DevTools endpoint
+// instead of a local Chrome.
+type RemoteDriver struct {
+	DefaultChromeDPContext
+	Endpoint string
+}
+
+func (d *RemoteDriver) NewContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	allocCtx, cancelAlloc := chromedp.NewRemoteAllocator(ctx, d.Endpoint)
+	browserCtx, cancel := chromedp.NewContext(allocCtx)
+	return browserCtx, func() {
+		cancel()
+		cancelAlloc()
+	}
+}
+
+// SetInstanceDriver selects the browser driver of an instance; it takes
+// effect the next time the instance starts.
+func (im *InstanceManager) SetInstanceDriver(id string, cfg DriverConfig) error {
+	if _, err := newDriver(cfg); err != nil {
+		return err
+	}
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return err
+	}
+	instance.stateMu.Lock()
+	instance.Driver = cfg
+	instance.stateMu.Unlock()
+	im.saveInstance(instance)
+	return nil
+}
@@ -0,0 +1,320 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RequestExporter writes captured requests in a format other tools read.
+type RequestExporter interface {
+	// ContentType is the media type of the output.
+	ContentType() string
+	Export(w io.Writer, reqs []Request) error
+}
+
+var exporters = map[string]RequestExporter{
+	"raw":  RawExporter{},
+	"curl": CurlExporter{},
+	"har":  HARExporter{},
+	"burp": BurpExporter{},
+}
+
+// GetExporter returns the exporter of a format: "raw", "curl", "har" or
+// "burp".
+func GetExporter(format string) (RequestExporter, error) {
+	if e, ok := exporters[format]; ok {
+		return e, nil
+	}
+	return nil, fmt.Errorf("unknown export format %q: must be raw, curl, har or burp", format)
+}
+
+// headerNames returns the names of the headers of req in order, without
+// HTTP/2 pseudo-headers.
+func (req *Request) headerNames() []string {
+	names := make([]string, 0, len(req.Headers))
+	for k := range req.Headers {
+		if !strings.HasPrefix(k, ":") {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (req *Request) header(name string) string {
+	return fmt.Sprint(req.Headers[name])
+}
+
+// Raw returns the request as it goes over the wire in HTTP/1.1.
+func (req *Request) Raw() string {
+	var b strings.Builder
+	target := req.URL.RequestURI()
+	b.WriteString(req.Method + " " + target + " HTTP/1.1\r\n")
+	hasHost := false
+	for _, k := range req.headerNames() {
+		hasHost = hasHost || strings.EqualFold(k, "Host")
+	}
+	if !hasHost {
+		b.WriteString("Host: " + req.URL.Host + "\r\n")
+	}
+	for _, k := range req.headerNames() {
+		b.WriteString(k + ": " + req.header(k) + "\r\n")
+	}
+	b.WriteString("\r\n")
+	b.WriteString(req.PostData)
+	return b.String()
+}
+
+// RawExporter writes requests as raw HTTP, separated by blank lines.
+type RawExporter struct{}
+
+func (RawExporter) ContentType() string { return "text/plain; charset=utf-8" }
+
+func (RawExporter) Export(w io.Writer, reqs []Request) error {
+	for i := range reqs {
+		if _, err := io.WriteString(w, reqs[i].Raw()+"\r\n\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CurlExporter writes a curl command per request.
+type CurlExporter struct{}
+
+func (CurlExporter) ContentType() string { return "text/x-shellscript; charset=utf-8" }
+
+func (CurlExporter) Export(w io.Writer, reqs []Request) error {
+	for i := range reqs {
+		if _, err := io.WriteString(w, reqs[i].Curl()+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Curl returns a curl command sending the request.
+func (req *Request) Curl() string {
+	parts := []string{"curl", "-X", req.Method, shellQuote(req.URL.String())}
+	for _, k := range req.headerNames() {
+		parts = append(parts, "-H", shellQuote(k+": "+req.header(k)))
+	}
+	if req.PostData != "" {
+		parts = append(parts, "--data-raw", shellQuote(req.PostData))
+	}
+	return strings.Join(parts, " ")
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// HAR is an HTTP Archive 1.2 document.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	// ResourceType is the Chrome DevTools extension naming the kind of
+	// resource, such as "document" or "xhr".
+	ResourceType string `json:"_resourceType,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	Cookies     []HARNameValue `json:"cookies"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []HARNameValue `json:"headers"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+}
+
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// HARExporter writes the requests as the entries of a HAR file. Responses
+// are not part of a request, so they are left empty.
+type HARExporter struct{}
+
+func (HARExporter) ContentType() string { return "application/json" }
+
+func (HARExporter) Export(w io.Writer, reqs []Request) error {
+	now := time.Now().UTC()
+	har := HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "umba", Version: "1.0"},
+		Entries: make([]HAREntry, 0, len(reqs)),
+	}}
+	for i := range reqs {
+		req := &reqs[i]
+		entry := HAREntry{
+			StartedDateTime: now,
+			Request: HARRequest{
+				Method:      req.Method,
+				URL:         req.URL.String(),
+				HTTPVersion: "HTTP/1.1",
+				Headers:     []HARNameValue{},
+				QueryString: []HARNameValue{},
+				Cookies:     []HARNameValue{},
+				HeadersSize: -1,
+				BodySize:    len(req.PostData),
+			},
+			Response: HARResponse{
+				HTTPVersion: "HTTP/1.1",
+				Headers:     []HARNameValue{},
+				Cookies:     []HARNameValue{},
+				HeadersSize: -1,
+				BodySize:    -1,
+			},
+		}
+		for _, k := range req.headerNames() {
+			entry.Request.Headers = append(entry.Request.Headers, HARNameValue{Name: k, Value: req.header(k)})
+		}
+		for k, values := range req.URL.Query() {
+			for _, v := range values {
+				entry.Request.QueryString = append(entry.Request.QueryString, HARNameValue{Name: k, Value: v})
+			}
+		}
+		sort.Slice(entry.Request.QueryString, func(a, b int) bool {
+			return entry.Request.QueryString[a].Name < entry.Request.QueryString[b].Name
+		})
+		if req.PostData != "" {
+			entry.Request.PostData = &HARPostData{Text: req.PostData}
+			for _, k := range req.headerNames() {
+				if strings.EqualFold(k, "Content-Type") {
+					entry.Request.PostData.MimeType = req.header(k)
+				}
+			}
+		}
+		har.Log.Entries = append(har.Log.Entries, entry)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(har)
+}
+
+// burpItems is the XML Burp Suite writes with "Save items" and imports.
+type burpItems struct {
+	XMLName    xml.Name   `xml:"items"`
+	Version    string     `xml:"burpVersion,attr"`
+	ExportTime string     `xml:"exportTime,attr"`
+	Items      []burpItem `xml:"item"`
+}
+
+type burpItem struct {
+	Time     string      `xml:"time"`
+	URL      string      `xml:"url"`
+	Host     burpHost    `xml:"host"`
+	Port     string      `xml:"port"`
+	Protocol string      `xml:"protocol"`
+	Method   string      `xml:"method"`
+	Path     string      `xml:"path"`
+	Request  burpMessage `xml:"request"`
+	Status   string      `xml:"status"`
+	Response burpMessage `xml:"response"`
+}
+
+type burpHost struct {
+	IP   string `xml:"ip,attr"`
+	Name string `xml:",chardata"`
+}
+
+type burpMessage struct {
+	Base64 bool   `xml:"base64,attr"`
+	Data   string `xml:",chardata"`
+}
+
+// BurpExporter writes the requests as Burp Suite items, which Burp and
+// ZAP import.
+type BurpExporter struct{}
+
+func (BurpExporter) ContentType() string { return "application/xml" }
+
+func (BurpExporter) Export(w io.Writer, reqs []Request) error {
+	now := time.Now().Format(time.ANSIC)
+	items := burpItems{Version: "2023.1", ExportTime: now}
+	for i := range reqs {
+		req := &reqs[i]
+		port := req.URL.Port()
+		if port == "" {
+			port = "80"
+			if req.URL.Scheme == "https" {
+				port = "443"
+			}
+		}
+		items.Items = append(items.Items, burpItem{
+			Time:     now,
+			URL:      req.URL.String(),
+			Host:     burpHost{Name: req.URL.Hostname()},
+			Port:     port,
+			Protocol: req.URL.Scheme,
+			Method:   req.Method,
+			Path:     req.URL.RequestURI(),
+			Request:  burpMessage{Base64: true, Data: base64.StdEncoding.EncodeToString([]byte(req.Raw()))},
+			Response: burpMessage{Base64: true},
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(items)
+}
@@ -0,0 +1,107 @@
+package model
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	graphQLHeader     = regexp.MustCompile(`^\s*(query|mutation|subscription)\b\s*([A-Za-z_][A-Za-z0-9_]*)?`)
+	graphQLWhitespace = regexp.MustCompile(`\s+`)
+)
+
+// GraphQLOperation is a single GraphQL operation carried by a request.
+type GraphQLOperation struct {
+	Type          string                 `json:"type"`
+	OperationName string                 `json:"operation_name"`
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+type graphQLBody struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// GraphQL returns the operations sent by req, or nil when it is not a
+// GraphQL request. Both single and batched POST bodies are recognised, as
+// well as GET requests carrying the query in the URL.
+func (req *Request) GraphQL() []GraphQLOperation {
+	var bodies []graphQLBody
+	switch {
+	case req.Method == "GET" && req.URL != nil && req.URL.Query().Get("query") != "":
+		q := req.URL.Query()
+		body := graphQLBody{Query: q.Get("query"), OperationName: q.Get("operationName")}
+		if vars := q.Get("variables"); vars != "" {
+			json.Unmarshal([]byte(vars), &body.Variables)
+		}
+		bodies = append(bodies, body)
+	case strings.HasPrefix(strings.TrimSpace(req.PostData), "["):
+		if err := json.Unmarshal([]byte(req.PostData), &bodies); err != nil {
+			return nil
+		}
+	case strings.HasPrefix(strings.TrimSpace(req.PostData), "{"):
+		var body graphQLBody
+		if err := json.Unmarshal([]byte(req.PostData), &body); err != nil {
+			return nil
+		}
+		bodies = append(bodies, body)
+	}
+
+	var ops []GraphQLOperation
+	for _, body := range bodies {
+		if body.Query == "" {
+			return nil
+		}
+		op := GraphQLOperation{
+			Type:          "query",
+			OperationName: body.OperationName,
+			Query:         graphQLWhitespace.ReplaceAllString(strings.TrimSpace(body.Query), " "),
+			Variables:     body.Variables,
+		}
+		if m := graphQLHeader.FindStringSubmatch(body.Query); m != nil {
+			op.Type = m[1]
+			if op.OperationName == "" {
+				op.OperationName = m[2]
+			}
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// graphQLKey identifies the operations of a GraphQL request independently
+// of their variables, so repeated calls with different inputs dedupe.
+func graphQLKey(ops []GraphQLOperation) string {
+	keys := make([]string, 0, len(ops))
+	for _, op := range ops {
+		name := op.OperationName
+		if name == "" {
+			name = op.Query
+		}
+		keys = append(keys, op.Type+" "+name)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ";")
+}
+
+// graphQLSummary renders the operations for human-readable output.
+func graphQLSummary(ops []GraphQLOperation) string {
+	parts := make([]string, 0, len(ops))
+	for _, op := range ops {
+		name := op.OperationName
+		if name == "" {
+			name = "<anonymous>"
+		}
+		part := "graphql " + op.Type + " " + name
+		if len(op.Variables) > 0 {
+			vars, _ := json.Marshal(op.Variables)
+			part += " " + string(vars)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,185 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// InstanceGroup is a named set of interchangeable instances. Flows that
+// target a group run on whichever member is available; with Sticky a flow
+// keeps using the member it last ran on for as long as that one is healthy,
+// so a logged-in session can be reused. Standby members are kept started
+// and idle so that runs, e.g. of webhook triggers, do not wait for Chrome
+// to start; the pool is refilled from the stopped members after each pick.
+type InstanceGroup struct {
+	Name        string   `json:"name"`
+	InstanceIDs []string `json:"instance_ids"`
+	Sticky      bool     `json:"sticky"`
+	Standby     int      `json:"standby,omitempty"`
+}
+
+// BeginRun marks the instance as running a flow until EndRun is called.
+func (i *Instance) BeginRun() {
+	atomic.AddInt32(&i.activeRuns, 1)
+	i.touch()
+}
+
+func (i *Instance) EndRun() {
+	i.touch()
+	atomic.AddInt32(&i.activeRuns, -1)
+}
+
+// ActiveRuns returns the number of flows currently running on the instance.
+func (i *Instance) ActiveRuns() int {
+	return int(atomic.LoadInt32(&i.activeRuns))
+}
+
+// Healthy reports whether the instance is running with a live browser and
+// has finished starting, login included.
+func (i *Instance) Healthy() bool {
+	ctx := i.liveContext()
+	return ctx != nil && !i.Booting() && ctx.Err() == nil
+}
+
+// Booting reports whether the instance is starting and not logged in yet.
+func (i *Instance) Booting() bool {
+	return atomic.LoadInt32(&i.booting) == 1
+}
+
+// SetGroup creates or replaces an instance group
+func (im *InstanceManager) SetGroup(group InstanceGroup) error {
+	if group.Name == "" {
+		return errors.New("group name is required")
+	}
+	if len(group.InstanceIDs) == 0 {
+		return errors.New("group has no instances")
+	}
+	if group.Standby < 0 || group.Standby > len(group.InstanceIDs) {
+		return fmt.Errorf("standby must be between 0 and the %d members of the group", len(group.InstanceIDs))
+	}
+	for _, id := range group.InstanceIDs {
+		if _, err := im.GetInstance(id); err != nil {
+			return fmt.Errorf("instance %s: %w", id, err)
+		}
+	}
+	data, err := json.Marshal(group)
+	if err != nil {
+		return err
+	}
+	if err := im.rdb.HSet(context.Background(), "instance_groups", group.Name, data).Err(); err != nil {
+		return err
+	}
+	go im.refillStandby(&group, "")
+	return nil
+}
+
+// GetGroup retrieves an instance group by name
+func (im *InstanceManager) GetGroup(name string) (*InstanceGroup, error) {
+	data, err := im.rdb.HGet(context.Background(), "instance_groups", name).Bytes()
+	if err == redis.Nil {
+		return nil, errors.New("instance group not found")
+	} else if err != nil {
+		return nil, err
+	}
+	var group InstanceGroup
+	if err := json.Unmarshal(data, &group); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// GetGroups retrieves all instance groups
+func (im *InstanceManager) GetGroups() ([]*InstanceGroup, error) {
+	all, err := im.rdb.HGetAll(context.Background(), "instance_groups").Result()
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]*InstanceGroup, 0, len(all))
+	for _, data := range all {
+		var group InstanceGroup
+		if err := json.Unmarshal([]byte(data), &group); err != nil {
+			continue
+		}
+		groups = append(groups, &group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+// DeleteGroup deletes an instance group
+func (im *InstanceManager) DeleteGroup(name string) error {
+	n, err := im.rdb.HDel(context.Background(), "instance_groups", name).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("instance group not found")
+	}
+	im.rdb.Del(context.Background(), "instance_group_sticky:"+name)
+	return nil
+}
+
+// PickInstance chooses the instance of a group a flow runs on: the sticky
+// instance of the flow if the group is sticky and it is still healthy,
+// otherwise the healthy member running the fewest flows, preferring idle
+// ones in group order.
+func (im *InstanceManager) PickInstance(groupName string, flowID string) (*Instance, error) {
+	group, err := im.GetGroup(groupName)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	stickyKey := "instance_group_sticky:" + group.Name
+
+	if group.Sticky {
+		if id, err := im.rdb.HGet(ctx, stickyKey, flowID).Result(); err == nil {
+			if instance, err := im.GetInstance(id); err == nil && instance.Healthy() && containsID(group.InstanceIDs, id) {
+				return instance, nil
+			}
+		}
+	}
+
+	var picked *Instance
+	for _, id := range group.InstanceIDs {
+		instance, err := im.GetInstance(id)
+		if err != nil || !instance.Healthy() {
+			continue
+		}
+		if picked == nil || instance.ActiveRuns() < picked.ActiveRuns() {
+			picked = instance
+		}
+	}
+	if picked == nil {
+		// A member the idle timeout stopped starts again when used.
+		for _, id := range group.InstanceIDs {
+			if instance, err := im.GetInstance(id); err == nil && instance.Settings().IdleStopped {
+				picked = instance
+				break
+			}
+		}
+	}
+	if picked == nil {
+		return nil, fmt.Errorf("no healthy instance in group %s", group.Name)
+	}
+
+	if group.Sticky {
+		im.rdb.HSet(ctx, stickyKey, flowID, picked.ID)
+	}
+	go im.refillStandby(group, picked.ID)
+	return picked, nil
+}
+
+func containsID(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
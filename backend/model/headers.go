@@ -0,0 +1,138 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// HeaderOverrides change what the browser sends with its requests: the
+// User-Agent (also seen by scripts as navigator.userAgent), the
+// Accept-Language and any extra headers, such as a tenant header of an
+// internal application.
+type HeaderOverrides struct {
+	UserAgent      string            `json:"user_agent,omitempty"`
+	AcceptLanguage string            `json:"accept_language,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"`
+}
+
+func (h HeaderOverrides) empty() bool {
+	return h.UserAgent == "" && h.AcceptLanguage == "" && len(h.Headers) == 0
+}
+
+// ValidateHeaderOverrides checks the header names and values can be sent.
+func ValidateHeaderOverrides(h HeaderOverrides) error {
+	if strings.ContainsAny(h.UserAgent+h.AcceptLanguage, "\r\n") {
+		return errors.New("header values must not contain line breaks")
+	}
+	for name, value := range h.Headers {
+		if name == "" || strings.ContainsAny(name, " :\r\n") {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("header %s: values must not contain line breaks", name)
+		}
+	}
+	return nil
+}
+
+// merge returns h with the overrides of step taking precedence.
+func (h HeaderOverrides) merge(step HeaderOverrides) HeaderOverrides {
+	out := HeaderOverrides{UserAgent: h.UserAgent, AcceptLanguage: h.AcceptLanguage, Headers: map[string]string{}}
+	if step.UserAgent != "" {
+		out.UserAgent = step.UserAgent
+	}
+	if step.AcceptLanguage != "" {
+		out.AcceptLanguage = step.AcceptLanguage
+	}
+	for name, value := range h.Headers {
+		out.Headers[name] = value
+	}
+	for name, value := range step.Headers {
+		out.Headers[name] = value
+	}
+	return out
+}
+
+// applyHeaders makes the browser send h from its next request on. Headers
+// left out of h go back to what the browser sends by itself.
+func applyHeaders(h HeaderOverrides) chromedp.ActionFunc {
+	return func(ctx context.Context) error {
+		userAgent := h.UserAgent
+		if userAgent == "" {
+			// There is no way to clear an override, so the browser's own
+			// User-Agent is set instead.
+			var err error
+			if _, _, _, userAgent, _, err = browser.GetVersion().Do(ctx); err != nil {
+				return err
+			}
+		}
+		override := emulation.SetUserAgentOverride(userAgent)
+		if h.AcceptLanguage != "" {
+			override = override.WithAcceptLanguage(h.AcceptLanguage)
+		}
+		if err := override.Do(ctx); err != nil {
+			return err
+		}
+
+		headers := network.Headers{}
+		for name, value := range h.Headers {
+			headers[name] = value
+		}
+		if err := network.Enable().Do(ctx); err != nil {
+			return err
+		}
+		return network.SetExtraHTTPHeaders(headers).Do(ctx)
+	}
+}
+
+// stepHeaderOverrides reads the "userAgent", "acceptLanguage" and
+// "headers" parameters of a step.
+func stepHeaderOverrides(params map[string]interface{}) (HeaderOverrides, error) {
+	var h HeaderOverrides
+	h.UserAgent, _ = params["userAgent"].(string)
+	h.AcceptLanguage, _ = params["acceptLanguage"].(string)
+	if raw, ok := params["headers"]; ok {
+		headers, ok := raw.(map[string]interface{})
+		if !ok {
+			return h, errors.New("headers must be an object")
+		}
+		h.Headers = make(map[string]string, len(headers))
+		for name, value := range headers {
+			s, ok := value.(string)
+			if !ok {
+				return h, fmt.Errorf("header %s must be a string", name)
+			}
+			h.Headers[name] = s
+		}
+	}
+	return h, ValidateHeaderOverrides(h)
+}
+
+// SetInstanceHeaders sets the header overrides of an instance. A running
+// instance sends them from its next request on.
+func (im *InstanceManager) SetInstanceHeaders(id string, h HeaderOverrides) error {
+	if err := ValidateHeaderOverrides(h); err != nil {
+		return err
+	}
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return err
+	}
+	instance.stateMu.Lock()
+	instance.Headers = h
+	instance.stateMu.Unlock()
+
+	im.saveInstance(instance)
+
+	if ctx := instance.liveContext(); ctx != nil {
+		return instance.driver().Run(ctx, applyHeaders(instance.baseHeaders()))
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"go.uber.org/zap"
+)
+
+// BasicAuth holds credentials answered to HTTP authentication challenges.
+// They are only sent to Host, or to the host of the instance URL when it
+// is empty.
+type BasicAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Host     string `json:"host,omitempty"`
+}
+
+// ClientCertificate is a PEM certificate and key presented to hosts that
+// require mutual TLS. Host may start with "*." to cover subdomains.
+type ClientCertificate struct {
+	Host     string `json:"host"`
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// HTTPAuth groups the HTTP level authentication options of an instance.
+type HTTPAuth struct {
+	Basic              *BasicAuth          `json:"basic_auth,omitempty"`
+	ClientCertificates []ClientCertificate `json:"client_certificates,omitempty"`
+}
+
+// certRoute sends the requests for one host through a TLS client that
+// presents the configured certificate.
+type certRoute struct {
+	host   string
+	client *http.Client
+}
+
+func hostMatches(pattern, host string) bool {
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return strings.EqualFold(pattern, host)
+}
+
+func loadCertRoutes(certs []ClientCertificate) ([]certRoute, error) {
+	routes := make([]certRoute, 0, len(certs))
+	for _, c := range certs {
+		if c.Host == "" {
+			return nil, errors.New("client certificate is missing a host")
+		}
+		pair, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate for %s: %w", c.Host, err)
+		}
+		routes = append(routes, certRoute{
+			host: c.Host,
+			client: &http.Client{
+				Timeout: 60 * time.Second,
+				Transport: &http.Transport{
+					Proxy:           http.ProxyFromEnvironment,
+					TLSClientConfig: &tls.Config{Certificates: []tls.Certificate{pair}},
+				},
+				// Redirects are handed back to the browser so it keeps
+				// control of navigation and cookies.
+				CheckRedirect: func(*http.Request, []*http.Request) error {
+					return http.ErrUseLastResponse
+				},
+			},
+		})
+	}
+	return routes, nil
+}
+
+// ValidateHTTPAuth checks that the client certificates can be loaded.
+func ValidateHTTPAuth(auth HTTPAuth) error {
+	if auth.Basic != nil && auth.Basic.Username == "" {
+		return errors.New("basic auth is missing a username")
+	}
+	_, err := loadCertRoutes(auth.ClientCertificates)
+	return err
+}
+
+// enableHTTPAuth intercepts the instance traffic so authentication
+// challenges are answered with the configured credentials and requests to
+// mTLS hosts are replayed with the client certificate, instead of Chrome
+// blocking on a native dialog. Only requests to those hosts are paused:
+// the host of the basic auth, or of the instance URL when it has none, and
+// the hosts of the certificates.
+func (i *Instance) enableHTTPAuth(ctx context.Context) error {
+	settings := i.Settings()
+	auth := settings.HTTPAuth
+	if auth.Basic == nil && len(auth.ClientCertificates) == 0 {
+		return nil
+	}
+	routes, err := loadCertRoutes(auth.ClientCertificates)
+	if err != nil {
+		return err
+	}
+	basic := auth.Basic
+
+	var patterns []*fetch.RequestPattern
+	if basic != nil {
+		host := basic.Host
+		if host == "" {
+			u, err := url.Parse(settings.URL)
+			if err != nil || u.Hostname() == "" {
+				return fmt.Errorf("basic auth needs a host when the instance URL %q has none", settings.URL)
+			}
+			host = u.Hostname()
+		}
+		// Patterns are globs over the whole URL, so answerAuth checks the
+		// host again before sending the credentials.
+		scoped := *basic
+		scoped.Host = host
+		basic = &scoped
+		patterns = append(patterns, hostPatterns("*", host)...)
+	}
+	for _, route := range routes {
+		patterns = append(patterns, hostPatterns("https", route.host)...)
+	}
+
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch e := ev.(type) {
+		case *fetch.EventRequestPaused:
+			go func() {
+				tctx := cdp.WithExecutor(ctx, chromedp.FromContext(ctx).Target)
+				if err := continuePaused(tctx, routes, e); err != nil {
+					i.im.logger.Warn("Failed to continue intercepted request", zap.String("id", i.ID), zap.String("url", e.Request.URL), zap.Error(err))
+				}
+			}()
+		case *fetch.EventAuthRequired:
+			go func() {
+				tctx := cdp.WithExecutor(ctx, chromedp.FromContext(ctx).Target)
+				if err := answerAuth(tctx, basic, e); err != nil {
+					i.im.logger.Warn("Failed to answer auth challenge", zap.String("id", i.ID), zap.String("url", e.Request.URL), zap.Error(err))
+				}
+			}()
+		}
+	})
+
+	return i.driver().Run(ctx, fetch.Enable().
+		WithHandleAuthRequests(true).
+		WithPatterns(patterns))
+}
+
+// hostPatterns are the Fetch patterns for the URLs of host, with or without
+// a port. A host of "*.example.com" covers its subdomains.
+func hostPatterns(scheme, host string) []*fetch.RequestPattern {
+	return []*fetch.RequestPattern{
+		{URLPattern: scheme + "://" + host + "/*"},
+		{URLPattern: scheme + "://" + host + ":*"},
+	}
+}
+
+func answerAuth(ctx context.Context, basic *BasicAuth, e *fetch.EventAuthRequired) error {
+	// Without matching credentials the challenge is cancelled so the page
+	// receives the 401 rather than waiting on a dialog nobody will answer.
+	resp := &fetch.AuthChallengeResponse{Response: fetch.AuthChallengeResponseResponseCancelAuth}
+	if basic != nil && e.AuthChallenge.Source == fetch.AuthChallengeSourceServer {
+		u, err := url.Parse(e.Request.URL)
+		if err == nil && (basic.Host == "" || hostMatches(basic.Host, u.Hostname())) {
+			resp = &fetch.AuthChallengeResponse{
+				Response: fetch.AuthChallengeResponseResponseProvideCredentials,
+				Username: basic.Username,
+				Password: basic.Password,
+			}
+		}
+	}
+	return fetch.ContinueWithAuth(e.RequestID, resp).Do(ctx)
+}
+
+func continuePaused(ctx context.Context, routes []certRoute, e *fetch.EventRequestPaused) error {
+	u, err := url.Parse(e.Request.URL)
+	if err != nil || u.Scheme != "https" {
+		return fetch.ContinueRequest(e.RequestID).Do(ctx)
+	}
+	for _, route := range routes {
+		if hostMatches(route.host, u.Hostname()) {
+			if err := fulfilWithCertificate(ctx, route.client, e); err != nil {
+				fetch.FailRequest(e.RequestID, network.ErrorReasonConnectionFailed).Do(ctx)
+				return err
+			}
+			return nil
+		}
+	}
+	return fetch.ContinueRequest(e.RequestID).Do(ctx)
+}
+
+func fulfilWithCertificate(ctx context.Context, client *http.Client, e *fetch.EventRequestPaused) error {
+	var body bytes.Buffer
+	for _, entry := range e.Request.PostDataEntries {
+		if b, err := base64.StdEncoding.DecodeString(entry.Bytes); err == nil {
+			body.Write(b)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, e.Request.Method, e.Request.URL, &body)
+	if err != nil {
+		return err
+	}
+	for k, v := range e.Request.Headers {
+		req.Header.Set(k, fmt.Sprint(v))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var headers []*fetch.HeaderEntry
+	for k, values := range resp.Header {
+		for _, v := range values {
+			headers = append(headers, &fetch.HeaderEntry{Name: k, Value: v})
+		}
+	}
+	return fetch.FulfillRequest(e.RequestID, int64(resp.StatusCode)).
+		WithResponseHeaders(headers).
+		WithBody(base64.StdEncoding.EncodeToString(data)).
+		Do(ctx)
+}
@@ -0,0 +1,292 @@
+package model
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+func init() {
+	actionHandlers["httpRequest"] = httpRequestAction
+}
+
+// httpRequestJS sends a request with fetch from the page, so it carries
+// the cookies of the session like the requests of the page itself.
+const httpRequestJS = `(async (url, init) => {
+	const started = performance.now();
+	const resp = await fetch(url, Object.assign({credentials: "include", redirect: "follow"}, init));
+	const body = await resp.text();
+	return {
+		status: resp.status,
+		headers: Object.fromEntries(resp.headers.entries()),
+		body: body,
+		timeMs: performance.now() - started,
+	};
+})(%s, %s)`
+
+// HTTPResponse is the response an httpRequest step got.
+type HTTPResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	TimeMs  float64           `json:"timeMs"`
+}
+
+// httpRequestAction sends a request from the page to "url" with "method"
+// (default GET), "headers" and a "body", which is either a string or, with
+// "json", an object sent as JSON. "auth" sets the Authorization header from
+// {"type": "basic", "username": ..., "password": ...} or {"type": "bearer",
+// "token": ...}. It returns the body of the response and fails on a status
+// of 400 or more, or when it does not pass the checks of "assert" (see
+// HTTPAssertions). Its "extract" parameter sets run variables from the
+// response for later steps to use as "{{var:NAME}}".
+func httpRequestAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	url, err := stringParam(params, "url")
+	if err != nil {
+		return "", err
+	}
+	init := map[string]interface{}{"method": "GET"}
+	if method, _ := params["method"].(string); method != "" {
+		init["method"] = strings.ToUpper(method)
+	}
+	headers := map[string]string{}
+	if h, ok := params["headers"].(map[string]interface{}); ok {
+		for name, value := range h {
+			headers[name] = fmt.Sprint(value)
+		}
+	}
+	if auth, ok := params["auth"].(map[string]interface{}); ok {
+		switch auth["type"] {
+		case "basic":
+			username, _ := auth["username"].(string)
+			password, _ := auth["password"].(string)
+			headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+		case "bearer":
+			token, _ := auth["token"].(string)
+			headers["Authorization"] = "Bearer " + token
+		default:
+			return "", fmt.Errorf("unsupported auth type %v: must be basic or bearer", auth["type"])
+		}
+	}
+	if v, ok := params["json"]; ok && v != nil {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", fmt.Errorf("invalid json parameter: %w", err)
+		}
+		init["body"] = string(data)
+		if !hasHeader(headers, "Content-Type") {
+			headers["Content-Type"] = "application/json"
+		}
+	} else if body, ok := params["body"].(string); ok && body != "" {
+		init["body"] = body
+	}
+	init["headers"] = headers
+	initJSON, err := json.Marshal(init)
+	if err != nil {
+		return "", err
+	}
+
+	var assertions HTTPAssertions
+	if a, ok := params["assert"]; ok && a != nil {
+		data, err := json.Marshal(a)
+		if err == nil {
+			err = json.Unmarshal(data, &assertions)
+		}
+		if err != nil {
+			return "", fmt.Errorf("invalid assert parameter: %w", err)
+		}
+	}
+
+	var resp HTTPResponse
+	script := fmt.Sprintf(httpRequestJS, jsString(url), initJSON)
+	if err := i.driver().Run(ctx, chromedp.Evaluate(script, &resp, awaitPromise)); err != nil {
+		return "", err
+	}
+	if err := assertions.Check(&resp); err != nil {
+		return resp.Body, fmt.Errorf("%s %s: %w", init["method"], url, err)
+	}
+	return resp.Body, nil
+}
+
+func hasHeader(headers map[string]string, name string) bool {
+	for k := range headers {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTPAssertions are the checks of an httpRequest step, such as
+//
+//	{"status": [200, 201], "maxTimeMs": 500,
+//	 "headers": {"Content-Type": "^application/json"},
+//	 "jsonPath": {"$.id": {"exists": true}, "$.items[0].name": "shoe"}}
+//
+// Headers are matched against regular expressions. A JSONPath check is a
+// value the path must equal, or an object with "exists", "equals" or
+// "matches", a regular expression for its value as text.
+type HTTPAssertions struct {
+	Status    statusList             `json:"status"`
+	Headers   map[string]string      `json:"headers"`
+	JSONPath  map[string]interface{} `json:"jsonPath"`
+	MaxTimeMs float64                `json:"maxTimeMs"`
+}
+
+// statusList is a status code or a list of them.
+type statusList []int
+
+func (s *statusList) UnmarshalJSON(data []byte) error {
+	var one int
+	if err := json.Unmarshal(data, &one); err == nil {
+		*s = statusList{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]int)(s))
+}
+
+// Check returns an error listing the checks resp fails. Without a status
+// check, a status of 400 or more fails.
+func (a *HTTPAssertions) Check(resp *HTTPResponse) error {
+	var failures []string
+	if len(a.Status) == 0 {
+		if resp.Status >= 400 {
+			failures = append(failures, fmt.Sprintf("returned status %d", resp.Status))
+		}
+	} else if !containsInt(a.Status, resp.Status) {
+		failures = append(failures, fmt.Sprintf("returned status %d, want %v", resp.Status, []int(a.Status)))
+	}
+	if a.MaxTimeMs > 0 && resp.TimeMs > a.MaxTimeMs {
+		failures = append(failures, fmt.Sprintf("took %.0fms, more than %.0fms", resp.TimeMs, a.MaxTimeMs))
+	}
+	names := make([]string, 0, len(a.Headers))
+	for name := range a.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, ok := headerValue(resp.Headers, name)
+		if !ok {
+			failures = append(failures, fmt.Sprintf("has no %s header", name))
+			continue
+		}
+		re, err := regexp.Compile(a.Headers[name])
+		if err != nil {
+			return fmt.Errorf("invalid pattern for header %s: %w", name, err)
+		}
+		if !re.MatchString(value) {
+			failures = append(failures, fmt.Sprintf("header %s is %q, which does not match %q", name, value, a.Headers[name]))
+		}
+	}
+	if len(a.JSONPath) > 0 {
+		var doc interface{}
+		if err := json.Unmarshal([]byte(resp.Body), &doc); err != nil {
+			failures = append(failures, "body is not JSON")
+		} else {
+			paths := make([]string, 0, len(a.JSONPath))
+			for path := range a.JSONPath {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			for _, path := range paths {
+				failure, err := checkJSONPath(doc, path, a.JSONPath[path])
+				if err != nil {
+					return err
+				}
+				if failure != "" {
+					failures = append(failures, failure)
+				}
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("assertion failed: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func checkJSONPath(doc interface{}, path string, want interface{}) (string, error) {
+	values, err := JSONPath(doc, path)
+	if err != nil {
+		return "", err
+	}
+	var got interface{}
+	if len(values) == 1 {
+		got = values[0]
+	} else if len(values) > 1 {
+		got = values
+	}
+	check, isCheck := want.(map[string]interface{})
+	if !isCheck {
+		check = map[string]interface{}{"equals": want}
+	}
+	if exists, ok := check["exists"].(bool); ok && exists != (len(values) > 0) {
+		if exists {
+			return fmt.Sprintf("%s does not exist", path), nil
+		}
+		return fmt.Sprintf("%s exists", path), nil
+	}
+	if equals, ok := check["equals"]; ok {
+		if len(values) == 0 {
+			return fmt.Sprintf("%s does not exist", path), nil
+		}
+		if !jsonEqual(got, equals) {
+			return fmt.Sprintf("%s is %s, want %s", path, jsonText(got), jsonText(equals)), nil
+		}
+	}
+	if pattern, ok := check["matches"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return "", fmt.Errorf("invalid pattern for %s: %w", path, err)
+		}
+		if len(values) == 0 {
+			return fmt.Sprintf("%s does not exist", path), nil
+		}
+		if s := JSONValueText(got); !re.MatchString(s) {
+			return fmt.Sprintf("%s is %q, which does not match %q", path, s, pattern), nil
+		}
+	}
+	return "", nil
+}
+
+func jsonEqual(a, b interface{}) bool {
+	return jsonText(a) == jsonText(b)
+}
+
+func jsonText(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// JSONValueText is a JSON value as text: strings as they are, anything
+// else as JSON.
+func JSONValueText(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return jsonText(v)
+}
+
+func headerValue(headers map[string]string, name string) (string, bool) {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
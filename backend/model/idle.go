@@ -0,0 +1,138 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// DefaultIdleCheckInterval is how often StopIdle looks for instances that
+// have been idle for longer than their idle timeout.
+const DefaultIdleCheckInterval = time.Minute
+
+// SetInstanceIdleTimeout sets after how many minutes without a flow an
+// instance is stopped to free its memory; zero keeps it running. A stopped
+// instance starts again the next time a flow uses it.
+func (im *InstanceManager) SetInstanceIdleTimeout(id string, minutes int) error {
+	if minutes < 0 {
+		return errors.New("idle timeout must not be negative")
+	}
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return err
+	}
+	instance.stateMu.Lock()
+	instance.IdleTimeoutMinutes = minutes
+	instance.stateMu.Unlock()
+
+	im.saveInstance(instance)
+
+	return nil
+}
+
+// touch records that the instance is in use.
+func (i *Instance) touch() {
+	atomic.StoreInt64(&i.lastUsed, time.Now().UnixNano())
+}
+
+// idle reports whether the instance is running, has an idle timeout and
+// no flow has used it for that long.
+func (i *Instance) idle(now time.Time) bool {
+	timeout := i.Settings().IdleTimeoutMinutes
+	if timeout <= 0 || i.GetStatus() != "On" || i.ActiveRuns() > 0 {
+		return false
+	}
+	lastUsed := time.Unix(0, atomic.LoadInt64(&i.lastUsed))
+	return now.Sub(lastUsed) >= time.Duration(timeout)*time.Minute
+}
+
+// StopIdle periodically stops instances that have been idle for longer
+// than their idle timeout, until ctx is done.
+func (im *InstanceManager) StopIdle(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for _, instance := range im.GetInstances() {
+					im.stopIfIdle(instance)
+				}
+			}
+		}
+	}()
+}
+
+// stopIfIdle stops an idle instance, keeping its cookies under
+// "idle_cookies:<id>" for Wake to restore. An instance whose cookies
+// cannot be read keeps running.
+func (im *InstanceManager) stopIfIdle(instance *Instance) {
+	instance.idleMu.Lock()
+	defer instance.idleMu.Unlock()
+	if !instance.idle(time.Now()) {
+		return
+	}
+	cookies, err := im.ExportCookies(instance.ID, "")
+	if err != nil {
+		im.logger.Warn("Failed to save cookies of idle instance", zap.String("id", instance.ID), zap.Error(err))
+		return
+	}
+	data, err := json.Marshal(cookies)
+	if err != nil {
+		return
+	}
+	if err := im.rdb.Set(context.Background(), "idle_cookies:"+instance.ID, data, 0).Err(); err != nil {
+		im.logger.Warn("Failed to save cookies of idle instance", zap.String("id", instance.ID), zap.Error(err))
+		return
+	}
+	if err := im.StopInstance(instance.ID); err != nil {
+		im.logger.Warn("Failed to stop idle instance", zap.String("id", instance.ID), zap.Error(err))
+		return
+	}
+	instance.stateMu.Lock()
+	instance.IdleStopped = true
+	instance.stateMu.Unlock()
+	im.saveInstance(instance)
+	im.logger.Info("Stopped idle instance", zap.String("id", instance.ID), zap.Int("idleTimeoutMinutes", instance.Settings().IdleTimeoutMinutes))
+}
+
+// Wake starts an instance the idle timeout stopped again, with the cookies
+// it had, returning once it has logged in. Other instances are left as
+// they are. Callers mark the instance busy with BeginRun first so it is
+// not stopped again right away.
+func (im *InstanceManager) Wake(instance *Instance) error {
+	instance.idleMu.Lock()
+	defer instance.idleMu.Unlock()
+	if !instance.Settings().IdleStopped || instance.GetStatus() == "On" {
+		return nil
+	}
+	im.logger.Info("Waking idle instance", zap.String("id", instance.ID))
+	return im.RestartInstance(instance.ID)
+}
+
+// restoreIdleCookies sets the cookies the instance had when the idle
+// timeout stopped it.
+func (i *Instance) restoreIdleCookies(ctx context.Context) error {
+	key := "idle_cookies:" + i.ID
+	data, err := i.im.rdb.Get(context.Background(), key).Bytes()
+	if err == redis.Nil {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var cookies []Cookie
+	if err := json.Unmarshal(data, &cookies); err != nil {
+		return err
+	}
+	if err := i.driver().Run(ctx, setCookies(cookies)); err != nil {
+		return err
+	}
+	return i.im.rdb.Del(context.Background(), key).Err()
+}
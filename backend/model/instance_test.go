@@ -0,0 +1,201 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// testDriver gives instances a browser context without starting a browser
+// and runs every action as a success.
+type testDriver struct{}
+
+func (testDriver) Run(context.Context, ...chromedp.Action) error { return nil }
+
+// NewContext cancels once: instances cancel their context twice, and the
+// second cancel of a context that never allocated a browser blocks.
+func (testDriver) NewContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := chromedp.NewContext(ctx)
+	var once sync.Once
+	return ctx, func() { once.Do(cancel) }
+}
+
+func init() {
+	RegisterDriver("test", func(DriverConfig) (BrowserDriver, error) {
+		return testDriver{}, nil
+	})
+}
+
+// newTestManager returns a manager whose Redis is unreachable, so saving
+// instances fails fast without a server.
+func newTestManager(t *testing.T) *InstanceManager {
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 10 * time.Millisecond})
+	t.Cleanup(func() { rdb.Close() })
+	return NewInstanceManager(zap.NewNop(), rdb, time.Second)
+}
+
+func newTestInstance(t *testing.T, im *InstanceManager) *Instance {
+	instance, err := im.CreateInstance("about:blank", Auth{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance.Driver = DriverConfig{Name: "test"}
+	instance.IdleTimeoutMinutes = 1
+	return instance
+}
+
+// TestInstanceStateRace starts, stops and crashes an instance and changes
+// its settings while its state is read the ways handlers, the supervisor
+// and the idle reaper read it. Run with -race.
+func TestInstanceStateRace(t *testing.T) {
+	im := newTestManager(t)
+	instance := newTestInstance(t, im)
+
+	var writers, readers sync.WaitGroup
+	done := make(chan struct{})
+	setters := []func(n int) error{
+		func(n int) error { return im.SetInstanceURL(instance.ID, fmt.Sprintf("about:blank#%d", n)) },
+		func(n int) error {
+			return im.SetInstanceHeaders(instance.ID, HeaderOverrides{Headers: map[string]string{"X-Run": fmt.Sprint(n)}})
+		},
+		func(n int) error { return im.SetInstanceDevice(instance.ID, []string{"", "iphone-15"}[n%2]) },
+		func(n int) error {
+			return im.SetInstancePermissions(instance.ID, map[string]string{"geolocation": "granted"})
+		},
+		func(n int) error {
+			return im.SetInstanceHTTPAuth(instance.ID, HTTPAuth{Basic: &BasicAuth{Username: "u", Password: fmt.Sprint(n)}})
+		},
+		func(n int) error { return im.SetInstanceIdleTimeout(instance.ID, n%3+1) },
+		func(n int) error { return im.SetInstanceDriver(instance.ID, DriverConfig{Name: "test"}) },
+		func(n int) error {
+			return im.SetInstanceSeedCookies(instance.ID, []Cookie{{Name: "n", Value: fmt.Sprint(n), Domain: "example.com"}})
+		},
+		func(n int) error {
+			return im.UpdateInstanceTags(instance.ID, []string{fmt.Sprintf("tag-%d", n)}, nil, nil)
+		},
+		func(n int) error { return im.SetInstanceLoginProfile(instance.ID, "") },
+	}
+	for s, set := range setters {
+		writers.Add(1)
+		go func(s int, set func(int) error) {
+			defer writers.Done()
+			for n := 0; n < 50; n++ {
+				if err := set(n); err != nil {
+					t.Errorf("setter %d: %v", s, err)
+					return
+				}
+			}
+		}(s, set)
+	}
+	for w := 0; w < 4; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			for n := 0; n < 50; n++ {
+				switch (w + n) % 4 {
+				case 0:
+					im.StartInstance(instance.ID)
+				case 1:
+					im.StopInstance(instance.ID)
+				case 2:
+					im.RestartInstance(instance.ID)
+				case 3:
+					instance.markCrashed("test")
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				instance.GetStatus()
+				instance.Healthy()
+				instance.idle(time.Now())
+				instance.baseHeaders()
+				instance.HasTag("tag-1")
+				im.BrowserContexts()
+				if _, err := json.Marshal(instance); err != nil {
+					t.Error(err)
+					return
+				}
+				if ctx := instance.liveContext(); ctx != nil {
+					ctx.Err()
+				}
+			}
+		}()
+	}
+	writers.Wait()
+	close(done)
+	readers.Wait()
+	im.StopInstance(instance.ID)
+}
+
+// TestInstanceTagsConcurrent checks that concurrent tag updates keep every
+// added tag.
+func TestInstanceTagsConcurrent(t *testing.T) {
+	im := newTestManager(t)
+	instance := newTestInstance(t, im)
+
+	var wg sync.WaitGroup
+	for n := 0; n < 16; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			if err := im.UpdateInstanceTags(instance.ID, []string{fmt.Sprintf("tag-%d", n)}, nil, nil); err != nil {
+				t.Error(err)
+			}
+		}(n)
+	}
+	wg.Wait()
+	if tags := instance.Settings().Tags; len(tags) != 16 {
+		t.Fatalf("got %d tags, want 16: %v", len(tags), tags)
+	}
+}
+
+// TestInstanceStartOnce checks that concurrent starts launch one browser.
+func TestInstanceStartOnce(t *testing.T) {
+	im := newTestManager(t)
+	instance := newTestInstance(t, im)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	started := 0
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := im.StartInstanceAndWait(instance.ID); err == nil {
+				mu.Lock()
+				started++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if started != 1 {
+		t.Fatalf("started %d times, want 1", started)
+	}
+	if status := instance.GetStatus(); status != "On" {
+		t.Fatalf("status %q, want On", status)
+	}
+	if err := im.StopInstance(instance.ID); err != nil {
+		t.Fatal(err)
+	}
+	if status := instance.GetStatus(); status != "Off" {
+		t.Fatalf("status %q, want Off", status)
+	}
+}
@@ -0,0 +1,83 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// JSONPath returns the values at path in doc, a decoded JSON document. It
+// understands the subset of JSONPath API checks need: "$" for the
+// document, ".name" and "['name']" for fields, "[n]" for elements, with
+// negative n counting from the end, and ".*" or "[*]" for all of them.
+// A path that leads nowhere returns no values.
+func JSONPath(doc interface{}, path string) ([]interface{}, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("invalid JSONPath %q: must start with $", path)
+	}
+	values := []interface{}{doc}
+	rest := path[1:]
+	for rest != "" {
+		var sel string
+		var err error
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			sel, rest = rest[1:end+1], rest[end+1:]
+			if sel == "" {
+				return nil, fmt.Errorf("invalid JSONPath %q: empty field name", path)
+			}
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSONPath %q: unclosed [", path)
+			}
+			sel, rest = rest[1:end], rest[end+1:]
+			if len(sel) >= 2 && (sel[0] == '\'' || sel[0] == '"') && sel[len(sel)-1] == sel[0] {
+				sel = "." + sel[1:len(sel)-1]
+			} else if sel != "*" {
+				if _, err = strconv.Atoi(sel); err != nil {
+					return nil, fmt.Errorf("invalid JSONPath %q: bad index %q", path, sel)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("invalid JSONPath %q at %q", path, rest)
+		}
+		values = selectJSON(values, sel)
+	}
+	return values, nil
+}
+
+// selectJSON applies a selector to each value: "*", an index, or a field
+// name, which bracketed names get with a leading dot so they can be any
+// string.
+func selectJSON(values []interface{}, sel string) []interface{} {
+	var out []interface{}
+	for _, v := range values {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if sel == "*" {
+				for _, item := range v {
+					out = append(out, item)
+				}
+			} else if item, ok := v[strings.TrimPrefix(sel, ".")]; ok {
+				out = append(out, item)
+			}
+		case []interface{}:
+			if sel == "*" {
+				out = append(out, v...)
+			} else if n, err := strconv.Atoi(sel); err == nil {
+				if n < 0 {
+					n += len(v)
+				}
+				if n >= 0 && n < len(v) {
+					out = append(out, v[n])
+				}
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,494 @@
+package model
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"auto/apperr"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// ErrLoginProfileNotFound is returned for an unknown login profile ID.
+var ErrLoginProfileNotFound = apperr.New(apperr.CodeLoginProfileNotFound, "login profile not found")
+
+// LoginProfile is how to log in to an application, shared by the instances
+// that use it. Instances look their profile up each time they log in, so a
+// change applies to all of them from their next start.
+//
+// URL is the login page; without it the instance URL is. When it is set
+// the instance navigates to its own URL once logged in. With SSO the
+// credentials are entered at an identity provider and the selectors of
+// the profile itself are not used.
+type LoginProfile struct {
+	ID               string        `json:"id"`
+	Name             string        `json:"name"`
+	URL              string        `json:"url,omitempty"`
+	UsernameSelector string        `json:"username_selector"`
+	PasswordSelector string        `json:"password_selector"`
+	SubmitSelector   string        `json:"submit_selector"`
+	SSO              *SSOLogin     `json:"sso,omitempty"`
+	Credential       CredentialRef `json:"credential"`
+	TwoFactor        *TwoFactor    `json:"two_factor,omitempty"`
+	Success          SuccessCheck  `json:"success"`
+	UpdatedAt        time.Time     `json:"updated_at"`
+}
+
+// CredentialRef says where the username and password come from: the
+// environment variables it names, or the Auth of the instance logging in
+// when it names none. Profiles never hold the credentials themselves.
+type CredentialRef struct {
+	UsernameEnv string `json:"username_env,omitempty"`
+	PasswordEnv string `json:"password_env,omitempty"`
+}
+
+// TwoFactor enters a time-based one-time password (RFC 6238) after the
+// password, generated from the base32 secret in SecretEnv. SubmitSelector
+// defaults to that of the login form, or of the identity provider form.
+type TwoFactor struct {
+	Type           string `json:"type"`
+	SecretEnv      string `json:"secret_env"`
+	Selector       string `json:"selector"`
+	SubmitSelector string `json:"submit_selector,omitempty"`
+}
+
+// SuccessCheck tells a login worked. Every condition set must hold within
+// TimeoutSeconds (DefaultLoginTimeout when 0) of submitting: an element
+// matching Selector, a page URL matching the URLPattern regexp and a
+// cookie named Cookie. Without any, the login is not checked.
+type SuccessCheck struct {
+	Selector       string `json:"selector,omitempty"`
+	URLPattern     string `json:"url_pattern,omitempty"`
+	Cookie         string `json:"cookie,omitempty"`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"`
+}
+
+// DefaultLoginTimeout is how long a SuccessCheck waits by default.
+const DefaultLoginTimeout = 30 * time.Second
+
+// loginFailureTTL is how long the last login failure of an instance is kept.
+const loginFailureTTL = 7 * 24 * time.Hour
+
+func (s SuccessCheck) empty() bool {
+	return s.Selector == "" && s.URLPattern == "" && s.Cookie == ""
+}
+
+// LoginFailedError is returned when the page after logging in does not
+// pass the SuccessCheck of the profile.
+type LoginFailedError struct {
+	InstanceID string
+	ProfileID  string
+	Reason     string
+	URL        string
+}
+
+func (e *LoginFailedError) Error() string {
+	return fmt.Sprintf("login failed: %s (at %s)", e.Reason, e.URL)
+}
+
+func (e *LoginFailedError) ErrorCode() apperr.Code {
+	return apperr.CodeLoginFailed
+}
+
+func (e *LoginFailedError) ErrorDetails() map[string]interface{} {
+	return map[string]interface{}{"instance_id": e.InstanceID, "profile_id": e.ProfileID, "reason": e.Reason, "url": e.URL}
+}
+
+// LoginFailure is the last failed login of an instance, with the page it
+// ended on.
+type LoginFailure struct {
+	InstanceID string    `json:"instance_id"`
+	ProfileID  string    `json:"profile_id"`
+	Reason     string    `json:"reason"`
+	URL        string    `json:"url"`
+	At         time.Time `json:"at"`
+	Screenshot []byte    `json:"screenshot,omitempty"`
+}
+
+func (p *LoginProfile) validate() error {
+	if p.Name == "" {
+		return errors.New("login profile name is required")
+	}
+	if p.SSO != nil {
+		if err := p.SSO.validate(); err != nil {
+			return err
+		}
+	} else if p.UsernameSelector == "" || p.PasswordSelector == "" || p.SubmitSelector == "" {
+		return errors.New("username, password and submit selectors are required")
+	}
+	if (p.Credential.UsernameEnv == "") != (p.Credential.PasswordEnv == "") {
+		return errors.New("credential needs both username_env and password_env, or neither")
+	}
+	if p.Success.URLPattern != "" {
+		if _, err := regexp.Compile(p.Success.URLPattern); err != nil {
+			return fmt.Errorf("invalid success url_pattern: %w", err)
+		}
+	}
+	if p.Success.TimeoutSeconds < 0 {
+		return errors.New("success timeout_seconds must not be negative")
+	}
+	if tf := p.TwoFactor; tf != nil {
+		if tf.Type != "totp" {
+			return fmt.Errorf("unsupported two-factor type: %s", tf.Type)
+		}
+		if tf.SecretEnv == "" || tf.Selector == "" {
+			return errors.New("two-factor login needs secret_env and selector")
+		}
+	}
+	return nil
+}
+
+// credentials resolves the username and password for instance.
+func (p *LoginProfile) credentials(instance *Instance) (string, string, error) {
+	if p.Credential.UsernameEnv == "" {
+		if instance.Auth == nil {
+			return "", "", errors.New("instance has no credentials")
+		}
+		return instance.Auth.Email, instance.Auth.Password, nil
+	}
+	username, password := os.Getenv(p.Credential.UsernameEnv), os.Getenv(p.Credential.PasswordEnv)
+	if username == "" || password == "" {
+		return "", "", fmt.Errorf("%s and %s must be set", p.Credential.UsernameEnv, p.Credential.PasswordEnv)
+	}
+	return username, password, nil
+}
+
+// tasks logs instance in as the profile describes.
+func (p *LoginProfile) tasks(instance *Instance) (chromedp.Tasks, error) {
+	username, password, err := p.credentials(instance)
+	if err != nil {
+		return nil, err
+	}
+	appURL := instance.Settings().URL
+	url := p.URL
+	if url == "" {
+		url = appURL
+	}
+	tasks := chromedp.Tasks{navigateTo(url)}
+	if p.SSO != nil {
+		tasks = append(tasks, p.ssoTasks(instance, username, password)...)
+	} else {
+		tasks = append(tasks,
+			waitVisible(p.UsernameSelector),
+			typeInto(p.UsernameSelector, username),
+			clickOn(p.PasswordSelector),
+			waitVisible(p.PasswordSelector),
+			typeInto(p.PasswordSelector, password),
+			clickOn(p.SubmitSelector),
+		)
+		tasks = append(tasks, p.twoFactorTasks(p.SubmitSelector)...)
+	}
+	if !p.Success.empty() {
+		tasks = append(tasks, chromedp.ActionFunc(func(ctx context.Context) error {
+			return p.verify(ctx, instance)
+		}))
+	}
+	if p.URL != "" && p.URL != appURL {
+		tasks = append(tasks, navigateTo(appURL))
+	}
+	return tasks, nil
+}
+
+// twoFactorTasks enter the one-time password, if the profile asks for one.
+// submit is the default button to send it with.
+func (p *LoginProfile) twoFactorTasks(submit string) chromedp.Tasks {
+	tf := p.TwoFactor
+	if tf == nil {
+		return nil
+	}
+	if tf.SubmitSelector != "" {
+		submit = tf.SubmitSelector
+	}
+	return chromedp.Tasks{
+		waitVisible(tf.Selector),
+		// The code is generated when the field shows up, so it is
+		// not stale by the time it is typed.
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			code, err := totp(os.Getenv(tf.SecretEnv), time.Now())
+			if err != nil {
+				return fmt.Errorf("two-factor code: %w", err)
+			}
+			return typeInto(tf.Selector, code).Do(ctx)
+		}),
+		clickOn(submit),
+	}
+}
+
+// verify waits for the SuccessCheck of the profile to pass. When it does
+// not, the page is recorded as the last login failure of instance.
+func (p *LoginProfile) verify(ctx context.Context, instance *Instance) error {
+	timeout := DefaultLoginTimeout
+	if p.Success.TimeoutSeconds > 0 {
+		timeout = time.Duration(p.Success.TimeoutSeconds) * time.Second
+	}
+	var urlPattern *regexp.Regexp
+	if p.Success.URLPattern != "" {
+		urlPattern = regexp.MustCompile(p.Success.URLPattern)
+	}
+
+	deadline := time.Now().Add(timeout)
+	var reason, url string
+	for {
+		var err error
+		reason, url, err = p.Success.unmet(ctx, urlPattern)
+		if err != nil {
+			return err
+		}
+		if reason == "" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+
+	failure := &LoginFailedError{InstanceID: instance.ID, ProfileID: p.ID, Reason: reason, URL: url}
+	instance.im.recordLoginFailure(ctx, failure)
+	return failure
+}
+
+// unmet returns the first condition of s the page does not meet, or "",
+// and the page URL.
+func (s SuccessCheck) unmet(ctx context.Context, urlPattern *regexp.Regexp) (string, string, error) {
+	var url string
+	if err := chromedp.Location(&url).Do(ctx); err != nil {
+		return "", "", err
+	}
+	if urlPattern != nil && !urlPattern.MatchString(url) {
+		return fmt.Sprintf("URL does not match %q", s.URLPattern), url, nil
+	}
+	if s.Selector != "" {
+		var nodes []*cdp.Node
+		if err := chromedp.Nodes(s.Selector, &nodes, chromedp.AtLeast(0)).Do(ctx); err != nil {
+			return "", "", err
+		}
+		if len(nodes) == 0 {
+			return fmt.Sprintf("no element matches %q", s.Selector), url, nil
+		}
+	}
+	if s.Cookie != "" {
+		cookies, err := network.GetCookies().Do(ctx)
+		if err != nil {
+			return "", "", err
+		}
+		found := false
+		for _, cookie := range cookies {
+			if cookie.Name == s.Cookie {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Sprintf("cookie %q is not set", s.Cookie), url, nil
+		}
+	}
+	return "", url, nil
+}
+
+// recordLoginFailure keeps a failed login with a screenshot of the page,
+// for GetLoginFailure.
+func (im *InstanceManager) recordLoginFailure(ctx context.Context, e *LoginFailedError) {
+	failure := LoginFailure{
+		InstanceID: e.InstanceID,
+		ProfileID:  e.ProfileID,
+		Reason:     e.Reason,
+		URL:        e.URL,
+		At:         time.Now().UTC(),
+	}
+	if err := chromedp.CaptureScreenshot(&failure.Screenshot).Do(ctx); err != nil {
+		im.logger.Warn("Failed to capture login failure screenshot", zap.String("id", e.InstanceID), zap.Error(err))
+	}
+	data, err := json.Marshal(failure)
+	if err != nil {
+		return
+	}
+	im.rdb.Set(context.Background(), fmt.Sprintf("login_failure:%s", e.InstanceID), data, loginFailureTTL)
+}
+
+// GetLoginFailure returns the last failed login of an instance, if it was
+// in the last week.
+func (im *InstanceManager) GetLoginFailure(id string) (*LoginFailure, error) {
+	data, err := im.rdb.Get(context.Background(), fmt.Sprintf("login_failure:%s", id)).Bytes()
+	if err == redis.Nil {
+		return nil, apperr.New(apperr.CodeNotFound, "instance has no recorded login failure")
+	} else if err != nil {
+		return nil, err
+	}
+	var failure LoginFailure
+	if err := json.Unmarshal(data, &failure); err != nil {
+		return nil, err
+	}
+	return &failure, nil
+}
+
+// loginWithProfile returns the login tasks of instance with profileID.
+// Failing to build them fails the tasks, so the start fails as a login
+// failure would.
+func loginWithProfile(instance *Instance, profileID string) chromedp.Tasks {
+	profile, err := instance.im.getLoginProfile(profileID)
+	var tasks chromedp.Tasks
+	if err == nil {
+		tasks, err = profile.tasks(instance)
+	}
+	if err != nil {
+		return chromedp.Tasks{chromedp.ActionFunc(func(context.Context) error {
+			return fmt.Errorf("login profile %s: %w", profileID, err)
+		})}
+	}
+	return tasks
+}
+
+// totp returns the current six-digit code for a base32 secret.
+func totp(secret string, now time.Time) (string, error) {
+	secret = strings.ToUpper(strings.NewReplacer(" ", "", "=", "").Replace(secret))
+	if secret == "" {
+		return "", errors.New("no secret")
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return "", errors.New("secret is not base32")
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(now.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code%1000000), nil
+}
+
+func (im *InstanceManager) getLoginProfile(id string) (*LoginProfile, error) {
+	data, err := im.rdb.HGet(context.Background(), "login_profiles", id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrLoginProfileNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var p LoginProfile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (im *InstanceManager) saveLoginProfile(p *LoginProfile) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return im.rdb.HSet(context.Background(), "login_profiles", p.ID, data).Err()
+}
+
+// CreateLoginProfile stores a new login profile
+func (im *InstanceManager) CreateLoginProfile(p LoginProfile) (*LoginProfile, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	p.ID = GenerateID()
+	p.UpdatedAt = time.Now().UTC()
+	if err := im.saveLoginProfile(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// UpdateLoginProfile replaces a login profile; the instances using it log
+// in the new way from their next start.
+func (im *InstanceManager) UpdateLoginProfile(id string, p LoginProfile) (*LoginProfile, error) {
+	if _, err := im.getLoginProfile(id); err != nil {
+		return nil, err
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	p.ID = id
+	p.UpdatedAt = time.Now().UTC()
+	if err := im.saveLoginProfile(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetLoginProfile retrieves a login profile by ID
+func (im *InstanceManager) GetLoginProfile(id string) (*LoginProfile, error) {
+	return im.getLoginProfile(id)
+}
+
+// GetLoginProfiles retrieves all login profiles, by name
+func (im *InstanceManager) GetLoginProfiles() ([]*LoginProfile, error) {
+	all, err := im.rdb.HGetAll(context.Background(), "login_profiles").Result()
+	if err != nil {
+		return nil, err
+	}
+	profiles := make([]*LoginProfile, 0, len(all))
+	for _, data := range all {
+		var p LoginProfile
+		if err := json.Unmarshal([]byte(data), &p); err != nil {
+			continue
+		}
+		profiles = append(profiles, &p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles, nil
+}
+
+// DeleteLoginProfile removes a login profile no instance uses.
+func (im *InstanceManager) DeleteLoginProfile(id string) error {
+	if _, err := im.getLoginProfile(id); err != nil {
+		return err
+	}
+	var users []string
+	for _, instance := range im.GetInstances() {
+		if instance.Settings().LoginProfileID == id {
+			users = append(users, instance.ID)
+		}
+	}
+	if len(users) > 0 {
+		sort.Strings(users)
+		return apperr.New(apperr.CodeConflict, fmt.Sprintf("login profile is used by instances %s", strings.Join(users, ", ")))
+	}
+	return im.rdb.HDel(context.Background(), "login_profiles", id).Err()
+}
+
+// SetInstanceLoginProfile makes an instance log in with a profile instead
+// of its own selectors; an empty profileID goes back to them. It takes
+// effect the next time the instance starts.
+func (im *InstanceManager) SetInstanceLoginProfile(id, profileID string) error {
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return err
+	}
+	if profileID != "" {
+		if _, err := im.getLoginProfile(profileID); err != nil {
+			return err
+		}
+	}
+	instance.stateMu.Lock()
+	instance.LoginProfileID = profileID
+	instance.stateMu.Unlock()
+
+	im.saveInstance(instance)
+
+	return nil
+}
@@ -0,0 +1,314 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/chromedp/cdproto/dom"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/input"
+	"github.com/chromedp/chromedp"
+)
+
+// DevicePreset is a mobile device an instance can emulate: its screen in
+// CSS pixels, pixel ratio and User-Agent. Emulating one also turns on
+// touch events, which the tap, swipe and pinch actions need.
+type DevicePreset struct {
+	Width             int64   `json:"width"`
+	Height            int64   `json:"height"`
+	DeviceScaleFactor float64 `json:"device_scale_factor"`
+	UserAgent         string  `json:"user_agent"`
+	MaxTouchPoints    int64   `json:"max_touch_points"`
+}
+
+var devicePresets = map[string]DevicePreset{
+	"iphone-15": {
+		Width: 393, Height: 852, DeviceScaleFactor: 3, MaxTouchPoints: 5,
+		UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1",
+	},
+	"iphone-se": {
+		Width: 375, Height: 667, DeviceScaleFactor: 2, MaxTouchPoints: 5,
+		UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1",
+	},
+	"pixel-8": {
+		Width: 412, Height: 915, DeviceScaleFactor: 2.625, MaxTouchPoints: 5,
+		UserAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+	},
+	"galaxy-s23": {
+		Width: 360, Height: 780, DeviceScaleFactor: 3, MaxTouchPoints: 5,
+		UserAgent: "Mozilla/5.0 (Linux; Android 14; SM-S911B) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+	},
+	"ipad-air": {
+		Width: 820, Height: 1180, DeviceScaleFactor: 2, MaxTouchPoints: 5,
+		UserAgent: "Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1",
+	},
+}
+
+// gestureSteps is how many moves a swipe or pinch is made of.
+const gestureSteps = 10
+
+func init() {
+	actionHandlers["emulateDevice"] = emulateDeviceAction
+	actionHandlers["tap"] = tapAction
+	actionHandlers["swipe"] = swipeAction
+	actionHandlers["pinch"] = pinchAction
+}
+
+// DevicePresets returns the mobile devices instances can emulate, by name.
+func DevicePresets() map[string]DevicePreset {
+	out := make(map[string]DevicePreset, len(devicePresets))
+	for name, p := range devicePresets {
+		out[name] = p
+	}
+	return out
+}
+
+func devicePreset(name string) (DevicePreset, error) {
+	p, ok := devicePresets[name]
+	if !ok {
+		names := make([]string, 0, len(devicePresets))
+		for n := range devicePresets {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return p, fmt.Errorf("unknown device %q, expected one of %v", name, names)
+	}
+	return p, nil
+}
+
+// emulateDevice makes the page look like it is on the device, or like a
+// desktop again without one.
+func emulateDevice(device string) chromedp.ActionFunc {
+	return func(ctx context.Context) error {
+		if device == "" {
+			if err := emulation.ClearDeviceMetricsOverride().Do(ctx); err != nil {
+				return err
+			}
+			return emulation.SetTouchEmulationEnabled(false).Do(ctx)
+		}
+		p, err := devicePreset(device)
+		if err != nil {
+			return err
+		}
+		if err := emulation.SetDeviceMetricsOverride(p.Width, p.Height, p.DeviceScaleFactor, true).Do(ctx); err != nil {
+			return err
+		}
+		if err := emulation.SetTouchEmulationEnabled(true).WithMaxTouchPoints(p.MaxTouchPoints).Do(ctx); err != nil {
+			return err
+		}
+		return emulation.SetUserAgentOverride(p.UserAgent).Do(ctx)
+	}
+}
+
+// baseHeaders are the header overrides of the instance, with the
+// User-Agent of the device it emulates unless it sets its own.
+func (i *Instance) baseHeaders() HeaderOverrides {
+	settings := i.Settings()
+	h := settings.Headers
+	if h.UserAgent == "" && settings.Device != "" {
+		if p, err := devicePreset(settings.Device); err == nil {
+			h.UserAgent = p.UserAgent
+		}
+	}
+	return h
+}
+
+// SetInstanceDevice makes an instance emulate a mobile device; an empty
+// device goes back to a desktop browser. A running instance switches
+// straight away.
+func (im *InstanceManager) SetInstanceDevice(id, device string) error {
+	if device != "" {
+		if _, err := devicePreset(device); err != nil {
+			return err
+		}
+	}
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return err
+	}
+	instance.stateMu.Lock()
+	instance.Device = device
+	instance.stateMu.Unlock()
+	im.saveInstance(instance)
+
+	if ctx := instance.liveContext(); ctx != nil {
+		return instance.driver().Run(ctx, emulateDevice(device), applyHeaders(instance.baseHeaders()))
+	}
+	return nil
+}
+
+// emulateDeviceAction switches the device for the rest of the run:
+// "device" names a preset, or is empty for a desktop browser.
+func emulateDeviceAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	device, _ := params["device"].(string)
+	if device != "" {
+		if _, err := devicePreset(device); err != nil {
+			return "", err
+		}
+	}
+	return "", i.driver().Run(ctx, emulateDevice(device))
+}
+
+// gestureTarget returns the centre and size of the element the gesture is
+// aimed at, scrolled into view, or of the viewport when the step has no
+// selector.
+func (i *Instance) gestureTarget(ctx context.Context, params map[string]interface{}) (x, y, width, height float64, err error) {
+	if _, ok := params["selector"]; !ok {
+		if _, ok := params["selectors"]; !ok {
+			var size []float64
+			if err := i.driver().Run(ctx, chromedp.Evaluate(`[window.innerWidth, window.innerHeight]`, &size)); err != nil {
+				return 0, 0, 0, 0, err
+			}
+			if len(size) != 2 {
+				return 0, 0, 0, 0, errors.New("could not read the viewport size")
+			}
+			return size[0] / 2, size[1] / 2, size[0], size[1], nil
+		}
+	}
+	sel, w, err := i.targetFor(ctx, params)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	w.Enabled = false
+	var box *dom.BoxModel
+	if err := i.runWithAutoWait(ctx, w, sel, chromedp.Tasks{
+		chromedp.ScrollIntoView(sel),
+		chromedp.Dimensions(sel, &box),
+	}); err != nil {
+		return 0, 0, 0, 0, err
+	}
+	q := box.Border
+	if len(q) != 8 {
+		return 0, 0, 0, 0, fmt.Errorf("element has no box: %s", sel)
+	}
+	x = (q[0] + q[2] + q[4] + q[6]) / 4
+	y = (q[1] + q[3] + q[5] + q[7]) / 4
+	return x, y, float64(box.Width), float64(box.Height), nil
+}
+
+// touch dispatches one touch event with a point per finger.
+func touch(ctx context.Context, typ input.TouchType, points ...[2]float64) error {
+	touchPoints := make([]*input.TouchPoint, len(points))
+	for n, p := range points {
+		touchPoints[n] = &input.TouchPoint{X: p[0], Y: p[1], ID: float64(n)}
+	}
+	if typ == input.TouchEnd {
+		touchPoints = []*input.TouchPoint{}
+	}
+	return input.DispatchTouchEvent(typ, touchPoints).Do(ctx)
+}
+
+// gesture moves fingers from their start to their end points in
+// gestureSteps moves spread over duration.
+func gesture(from, to [][2]float64, duration time.Duration) chromedp.ActionFunc {
+	return func(ctx context.Context) error {
+		if err := touch(ctx, input.TouchStart, from...); err != nil {
+			return err
+		}
+		for step := 1; step <= gestureSteps; step++ {
+			if err := sleepCtx(ctx, duration/gestureSteps); err != nil {
+				return err
+			}
+			t := float64(step) / gestureSteps
+			points := make([][2]float64, len(from))
+			for n := range from {
+				points[n] = [2]float64{from[n][0] + (to[n][0]-from[n][0])*t, from[n][1] + (to[n][1]-from[n][1])*t}
+			}
+			if err := touch(ctx, input.TouchMove, points...); err != nil {
+				return err
+			}
+		}
+		return touch(ctx, input.TouchEnd)
+	}
+}
+
+func durationParam(params map[string]interface{}, def time.Duration) time.Duration {
+	if ms, ok := params["durationMs"].(float64); ok && ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	return def
+}
+
+// tapAction taps an element with one finger.
+func tapAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	if _, err := stringParam(params, "selector"); err != nil {
+		if _, ok := params["selectors"]; !ok {
+			return "", err
+		}
+	}
+	x, y, _, _, err := i.gestureTarget(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	return "", i.driver().Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		if err := touch(ctx, input.TouchStart, [2]float64{x, y}); err != nil {
+			return err
+		}
+		return touch(ctx, input.TouchEnd)
+	}))
+}
+
+// swipeAction drags one finger across an element, or the page, in
+// "direction" (left, right, up or down) over "distance" pixels, by default
+// most of its width or height. Swiping left shows what is to the right,
+// as in a carousel.
+func swipeAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	direction, err := stringParam(params, "direction")
+	if err != nil {
+		return "", err
+	}
+	x, y, width, height, err := i.gestureTarget(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	distance, _ := params["distance"].(float64)
+	var dx, dy float64
+	switch direction {
+	case "left", "right":
+		if distance <= 0 {
+			distance = width * 0.6
+		}
+		dx = distance
+	case "up", "down":
+		if distance <= 0 {
+			distance = height * 0.6
+		}
+		dy = distance
+	default:
+		return "", fmt.Errorf("unknown direction: %s", direction)
+	}
+	if direction == "left" || direction == "up" {
+		dx, dy = -dx, -dy
+	}
+	from := [2]float64{x - dx/2, y - dy/2}
+	to := [2]float64{x + dx/2, y + dy/2}
+	return "", i.driver().Run(ctx, gesture([][2]float64{from}, [][2]float64{to}, durationParam(params, 300*time.Millisecond)))
+}
+
+// pinchAction moves two fingers apart, "scale" above 1 zooming in, or
+// together, below 1 zooming out, around the centre of an element or the
+// page.
+func pinchAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	scale, ok := params["scale"].(float64)
+	if !ok || scale <= 0 || scale == 1 {
+		return "", errors.New("scale must be a positive number other than 1")
+	}
+	x, y, width, height, err := i.gestureTarget(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	// Fingers start a quarter of the smaller side apart, and never further
+	// than the element allows.
+	start := math.Min(width, height) / 4
+	end := start * scale
+	if limit := math.Min(width, height) * 0.9; end > limit {
+		end = limit
+	}
+	from := [][2]float64{{x - start/2, y}, {x + start/2, y}}
+	to := [][2]float64{{x - end/2, y}, {x + end/2, y}}
+	return "", i.driver().Run(ctx, gesture(from, to, durationParam(params, 400*time.Millisecond)))
+}
@@ -1,674 +1,1139 @@
-package model
-
-import (
-	"auto/websocket"
-	"context"
-	"crypto/md5"
-	"encoding/hex"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io/ioutil"
-	"net/url"
-	"regexp"
-	"strings"
-	"sync"
-	"time"
-
-	"github.com/chromedp/chromedp"
-	"github.com/go-redis/redis/v8"
-	"go.uber.org/zap"
-)
-
-var logger *zap.Logger
-var instances = make(map[string]*Instance)
-var instancesLock sync.Mutex
-var rdb *redis.Client
-
-type ChromeDPContext interface {
-	Run(context.Context, ...chromedp.Action) error
-	NewContext(context.Context) (context.Context, context.CancelFunc)
-}
-
-type DefaultChromeDPContext struct{}
-
-func (d *DefaultChromeDPContext) Run(ctx context.Context, actions ...chromedp.Action) error {
-	return chromedp.Run(ctx, actions...)
-}
-
-func (d *DefaultChromeDPContext) NewContext(ctx context.Context) (context.Context, context.CancelFunc) {
-	return chromedp.NewContext(ctx)
-}
-
-type Instance struct {
-	ID           string
-	URL          string
-	Auth         *Auth
-	Status       string
-	Context      context.Context
-	Cancel       context.CancelFunc
-	ChromeCtx    context.Context
-	ChromeCancel context.CancelFunc
-	Elements     *Elements
-	chrome       ChromeDPContext
-}
-
-type Auth struct {
-	Email    string
-	Password string
-}
-
-type Elements struct {
-	UsernameSel string
-	PasswordSel string
-	SubmitSel   string
-}
-
-func init() {
-	var err error
-	logger, err = zap.NewProduction()
-	if err != nil {
-		panic(err)
-	}
-	rdb = redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
-		DB:   0,
-	})
-}
-
-func GenerateID() string {
-	return fmt.Sprintf("%x", md5.Sum([]byte(time.Now().String())))
-}
-
-func CreateInstance(url string, auth *Auth, elements *Elements, chrome ChromeDPContext) *Instance {
-	id := GenerateID()
-	instance := &Instance{
-		ID:       id,
-		URL:      url,
-		Auth:     auth,
-		Status:   "Off",
-		Elements: elements,
-		chrome:   chrome,
-	}
-	instancesLock.Lock()
-	instances[id] = instance
-	instancesLock.Unlock()
-
-	// Store instance details in Redis
-	instanceJSON, _ := json.Marshal(instance)
-	rdb.HSet(context.Background(), "instances", id, instanceJSON)
-
-	return instance
-}
-
-func StartInstance(id string) error {
-	instancesLock.Lock()
-	instance, ok := instances[id]
-	instancesLock.Unlock()
-	if !ok {
-		return errors.New("instance not found")
-	}
-	if instance.Status == "On" {
-		return errors.New("instance is already running")
-	}
-	ctx, cancel := instance.chrome.NewContext(context.Background())
-	instance.Context = ctx
-	instance.Cancel = cancel
-	instance.ChromeCtx, instance.ChromeCancel = ctx, cancel
-	instance.Status = "On"
-	go func() {
-		if err := instance.chrome.Run(ctx, navigateAndAuthenticate(instance)); err != nil {
-			logger.Error("Failed to start instance", zap.Error(err))
-			instance.Status = "Off"
-			return
-		}
-		logger.Info("Instance started", zap.String("id", instance.ID))
-	}()
-
-	// Update instance status in Redis
-	instanceJSON, _ := json.Marshal(instance)
-	rdb.HSet(context.Background(), "instances", id, instanceJSON)
-
-	return nil
-}
-
-func StopInstance(id string) error {
-	instancesLock.Lock()
-	instance, ok := instances[id]
-	instancesLock.Unlock()
-	if !ok {
-		return errors.New("instance not found")
-	}
-	if instance.Status == "Off" {
-		return errors.New("instance is already stopped")
-	}
-	instance.Cancel()
-	instance.ChromeCancel()
-	instance.Status = "Off"
-
-	// Update instance status in Redis
-	instanceJSON, _ := json.Marshal(instance)
-	rdb.HSet(context.Background(), "instances", id, instanceJSON)
-
-	return nil
-}
-
-func DeleteInstance(id string) error {
-	instancesLock.Lock()
-	defer instancesLock.Unlock()
-	if _, ok := instances[id]; !ok {
-		return errors.New("instance not found")
-	}
-	delete(instances, id)
-
-	// Remove instance from Redis
-	rdb.HDel(context.Background(), "instances", id)
-
-	return nil
-}
-
-func DebugInstance(id string) ([]byte, error) {
-	instancesLock.Lock()
-	instance, ok := instances[id]
-	instancesLock.Unlock()
-	if !ok {
-		return nil, errors.New("instance not found")
-	}
-	var buf []byte
-	if err := instance.chrome.Run(instance.ChromeCtx, chromedp.CaptureScreenshot(&buf)); err != nil {
-		return nil, err
-	}
-	return buf, nil
-}
-
-func navigateAndAuthenticate(instance *Instance) chromedp.Tasks {
-	return chromedp.Tasks{
-		chromedp.Navigate(instance.URL),
-		chromedp.WaitVisible(instance.Elements.UsernameSel),
-		chromedp.SendKeys(instance.Elements.UsernameSel, instance.Auth.Email),
-		chromedp.Click(instance.Elements.PasswordSel),
-		chromedp.WaitVisible(instance.Elements.PasswordSel),
-		chromedp.SendKeys(instance.Elements.PasswordSel, instance.Auth.Password),
-		chromedp.Click(instance.Elements.SubmitSel),
-	}
-}
-
-func SendMessage(conn *websocket.Conn, status int, message interface{}, instanceID string) error {
-	return conn.WriteJSON(map[string]interface{}{
-		"status":   status,
-		"message":  message,
-		"instance": instanceID,
-	})
-}
-
-func SaveCrawOutput(resultList map[string][]interface{}, filePath string) error {
-	data, err := json.Marshal(resultList)
-	if err != nil {
-		return err
-	}
-	return ioutil.WriteFile(filePath, data, 0644)
-}
-
-func ParseURL(sourceURL string) (*url.URL, error) {
-	u, err := url.Parse(sourceURL)
-	if err != nil {
-		return nil, err
-	}
-	return u, nil
-}
-
-func EscapePercentSign(raw string) string {
-	return strings.ReplaceAll(raw, "%", "%25")
-}
-
-func DealMultipart(contentType, ruleBody string) (string, error) {
-	re := regexp.MustCompile(`(?m)multipart\/form-Data; boundary=(.*)`)
-	match := re.FindStringSubmatch(contentType)
-	if len(match) != 2 {
-		return "", errors.New("no boundary in content-type")
-	}
-	boundary := "--" + match[1]
-	multiPartContent := ""
-	multiFile := strings.Split(ruleBody, boundary)
-	if len(multiFile) == 0 {
-		return "", errors.New("ruleBody.Body multi content format err")
-	}
-	for _, singleFile := range multiFile {
-		spliteTmp := strings.Split(singleFile, "\n\n")
-		if len(spliteTmp) == 2 {
-			fileHeader := spliteTmp[0]
-			fileBody := spliteTmp[1]
-			fileHeader = strings.Replace(fileHeader, "\n", "\r\n", -1)
-			multiPartContent += boundary + fileHeader + "\r\n\r\n" + strings.TrimRight(fileBody, "\n") + "\r\n"
-		}
-	}
-	multiPartContent += boundary + "--" + "\r\n"
-	return multiPartContent, nil
-}
-
-// Define the missing types and variables
-
-type Options struct {
-	Headers  map[string]interface{}
-	PostData string
-}
-
-type URL struct {
-	url.URL
-}
-
-type Request struct {
-	URL             *URL
-	Method          string
-	Headers         map[string]interface{}
-	PostData        string
-	RedirectionFlag bool
-}
-
-var supportContentType = []string{
-	"application/json",
-	"application/x-www-form-urlencoded",
-	"multipart/form-data",
-}
-
-func GetRequest(method string, URL *URL, options ...Options) Request {
-	var req Request
-	req.URL = URL
-	req.Method = strings.ToUpper(method)
-	if len(options) != 0 {
-		option := options[0]
-		if option.Headers != nil {
-			req.Headers = option.Headers
-		}
-		if option.PostData != "" {
-			req.PostData = option.PostData
-		}
-	} else {
-		req.Headers = map[string]interface{}{}
-	}
-	return req
-}
-
-func (req *Request) FormatPrint() {
-	var tempStr = req.Method
-	tempStr += " " + req.URL.String() + " HTTP/1.1\r\n"
-	for k, v := range req.Headers {
-		tempStr += k + ": " + v.(string) + "\r\n"
-	}
-	tempStr += "\r\n"
-	if req.Method == "POST" {
-		tempStr += req.PostData
-	}
-	fmt.Println(tempStr)
-}
-
-func (req *Request) SimplePrint() {
-	var tempStr = req.Method
-	tempStr += " " + req.URL.String() + " "
-	if req.Method == "POST" {
-		tempStr += req.PostData
-	}
-	fmt.Println(tempStr)
-}
-
-func (req *Request) SimpleFormat() string {
-	var tempStr = req.Method
-	tempStr += " " + req.URL.String() + " "
-	if req.Method == "POST" {
-		tempStr += req.PostData
-	}
-	return tempStr
-}
-
-func (req *Request) NoHeaderId() string {
-	h := md5.New()
-	h.Write([]byte(req.Method + req.URL.String() + req.PostData))
-	return hex.EncodeToString(h.Sum(nil))
-}
-
-func (req *Request) UniqueId() string {
-	if req.RedirectionFlag {
-		h := md5.New()
-		h.Write([]byte(req.NoHeaderId() + "Redirection"))
-		return hex.EncodeToString(h.Sum(nil))
-	} else {
-		return req.NoHeaderId()
-	}
-}
-
-func (req *Request) PostDataMap() map[string]interface{} {
-	contentType, err := req.getContentType()
-	if err != nil {
-		return map[string]interface{}{
-			"key": req.PostData,
-		}
-	}
-	if strings.HasPrefix(contentType, "application/json") {
-		var result map[string]interface{}
-		err = json.Unmarshal([]byte(req.PostData), &result)
-		if err != nil {
-			return map[string]interface{}{
-				"key": req.PostData,
-			}
-		} else {
-			return result
-		}
-	} else if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
-		var result = map[string]interface{}{}
-		r, err := url.ParseQuery(req.PostData)
-		if err != nil {
-			return map[string]interface{}{
-				"key": req.PostData,
-			}
-		} else {
-			for key, value := range r {
-				if len(value) == 1 {
-					result[key] = value[0]
-				} else {
-					result[key] = value
-				}
-			}
-			return result
-		}
-	} else {
-		return map[string]interface{}{
-			"key": req.PostData,
-		}
-	}
-}
-
-func (req *Request) QueryMap() map[string][]string {
-	return req.URL.Query()
-}
-
-func (req *Request) getContentType() (string, error) {
-	headers := req.Headers
-	var contentType string
-	if ct, ok := headers["Content-Type"]; ok {
-		contentType = ct.(string)
-	} else if ct, ok := headers["Content-type"]; ok {
-		contentType = ct.(string)
-	} else if ct, ok := headers["content-type"]; ok {
-		contentType = ct.(string)
-	} else {
-		return "", errors.New("no content-type")
-	}
-	for _, ct := range supportContentType {
-		if strings.HasPrefix(contentType, ct) {
-			return contentType, nil
-		}
-	}
-	return "", errors.New("dont support such content-type:" + contentType)
-}
-
-func UrlParse(sourceUrl string) (*url.URL, error) {
-	u, err := url.Parse(sourceUrl)
-	if err != nil {
-		return nil, err
-	}
-	return u, nil
-}
-
-func GetUrl(_url string, parentUrls ...URL) (*URL, error) {
-	var u URL
-	_url, err := u.parse(_url, parentUrls...)
-	if err != nil {
-		return nil, err
-	}
-	if len(parentUrls) == 0 {
-		_u, err := UrlParse(_url)
-		if err != nil {
-			return nil, err
-		}
-		u = URL{*_u}
-		if u.Path == "" {
-			u.Path = "/"
-		}
-	} else {
-		pUrl := parentUrls[0]
-		_u, err := pUrl.Parse(_url)
-		if err != nil {
-			return nil, err
-		}
-		u = URL{*_u}
-		if u.Path == "" {
-			u.Path = "/"
-		}
-	}
-	fixPath := regexp.MustCompile("^/{2,}")
-	if fixPath.MatchString(u.Path) {
-		u.Path = fixPath.ReplaceAllString(u.Path, "/")
-	}
-	return &u, nil
-}
-
-func (u *URL) parse(_url string, parentUrls ...URL) (string, error) {
-	_url = strings.Trim(_url, " ")
-	if len(_url) == 0 {
-		return "", errors.New("invalid url, length 0")
-	}
-	if strings.Count(_url, "#") > 1 {
-		_url = regexp.MustCompile(`#+`).ReplaceAllString(_url, "#")
-	}
-	if len(parentUrls) == 0 {
-		return _url, nil
-	}
-	if strings.HasPrefix(_url, "http://") || strings.HasPrefix(_url, "https://") {
-		return _url, nil
-	} else if strings.HasPrefix(_url, "javascript:") {
-		return "", errors.New("invalid url, javascript protocol")
-	} else if strings.HasPrefix(_url, "mailto:") {
-		return "", errors.New("invalid url, mailto protocol")
-	}
-	return _url, nil
-}
-
-func (u *URL) QueryMap() map[string]interface{} {
-	queryMap := map[string]interface{}{}
-	for key, value := range u.Query() {
-		if len(value) == 1 {
-			queryMap[key] = value[0]
-		} else {
-			queryMap[key] = value
-		}
-	}
-	return queryMap
-}
-
-func (u *URL) NoQueryUrl() string {
-	return fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, u.Path)
-}
-
-func (u *URL) NoFragmentUrl() string {
-	return strings.Replace(u.String(), u.Fragment, "", -1)
-}
-
-func (u *URL) NoSchemeFragmentUrl() string {
-	return fmt.Sprintf("://%s%s", u.Host, u.Path)
-}
-
-func (u *URL) NavigationUrl() string {
-	return u.NoSchemeFragmentUrl()
-}
-
-func (u *URL) RootDomain() string {
-	domain := u.Hostname()
-	if strings.Count(domain, ".") == 1 {
-		return domain
-	}
-	parts := strings.Split(domain, ".")
-	if len(parts) >= 2 {
-		parts = parts[len(parts)-2:]
-		return strings.Join(parts, ".")
-	} else {
-		return ""
-	}
-}
-
-func (u *URL) FileName() string {
-	parts := strings.Split(u.Path, `/`)
-	lastPart := parts[len(parts)-1]
-	if strings.Contains(lastPart, ".") {
-		return lastPart
-	} else {
-		return ""
-	}
-}
-
-func (u *URL) FileExt() string {
-	fileName := u.FileName()
-	if fileName == "" {
-		return ""
-	}
-	parts := strings.Split(fileName, ".")
-	return strings.ToLower(parts[len(parts)-1])
-}
-
-func (u *URL) ParentPath() string {
-	if u.Path == "/" {
-		return ""
-	} else if strings.HasSuffix(u.Path, "/") {
-		if strings.Count(u.Path, "/") == 2 {
-			return "/"
-		}
-		parts := strings.Split(u.Path, "/")
-		parts = parts[:len(parts)-2]
-		return strings.Join(parts, "/")
-	} else {
-		if strings.Count(u.Path, "/") == 1 {
-			return "/"
-		}
-		parts := strings.Split(u.Path, "/")
-		parts = parts[:len(parts)-1]
-		return strings.Join(parts, "/")
-	}
-}
-
-// InstanceManager manages instances
-type InstanceManager struct {
-	logger *zap.Logger
-}
-
-// NewInstanceManager creates a new InstanceManager
-func NewInstanceManager(logger *zap.Logger) *InstanceManager {
-	return &InstanceManager{
-		logger: logger,
-	}
-}
-
-// CreateInstance creates a new instance
-func (im *InstanceManager) CreateInstance(url string, auth Auth) (*Instance, error) {
-	elements := &Elements{
-		UsernameSel: "input[name='username']",
-		PasswordSel: "input[name='password']",
-		SubmitSel:   "button[type='submit']",
-	}
-	instance := CreateInstance(url, &auth, elements, &DefaultChromeDPContext{})
-	return instance, nil
-}
-
-// GetInstance retrieves an instance by ID
-func (im *InstanceManager) GetInstance(id string) (*Instance, error) {
-	instancesLock.Lock()
-	defer instancesLock.Unlock()
-	instance, ok := instances[id]
-	if !ok {
-		return nil, errors.New("instance not found")
-	}
-	return instance, nil
-}
-
-// GetInstances retrieves all instances
-func (im *InstanceManager) GetInstances() []*Instance {
-	instancesLock.Lock()
-	defer instancesLock.Unlock()
-	instanceList := make([]*Instance, 0, len(instances))
-	for _, instance := range instances {
-		instanceList = append(instanceList, instance)
-	}
-	return instanceList
-}
-
-// StartInstancesConcurrently starts multiple instances concurrently
-func (im *InstanceManager) StartInstancesConcurrently(instanceIDs []string) []error {
-	var wg sync.WaitGroup
-	errChan := make(chan error, len(instanceIDs))
-
-	for _, id := range instanceIDs {
-		wg.Add(1)
-		go func(id string) {
-			defer wg.Done()
-			if err := StartInstance(id); err != nil {
-				errChan <- err
-			}
-		}(id)
-	}
-
-	wg.Wait()
-	close(errChan)
-
-	var errors []error
-	for err := range errChan {
-		errors = append(errors, err)
-	}
-
-	return errors
-}
-
-// StopAllInstances stops all instances
-func (im *InstanceManager) StopAllInstances() []error {
-	instancesLock.Lock()
-	defer instancesLock.Unlock()
-
-	var errors []error
-	for id := range instances {
-		if err := StopInstance(id); err != nil {
-			errors = append(errors, err)
-		}
-	}
-
-	return errors
-}
-
-// StopInstance stops an instance by ID
-func (im *InstanceManager) StopInstance(id string) error {
-	return StopInstance(id)
-}
-
-// DeleteInstance deletes an instance by ID
-func (im *InstanceManager) DeleteInstance(id string) error {
-	return DeleteInstance(id)
-}
-
-// UpdateInstanceStatus updates the status of an instance
-func (im *InstanceManager) UpdateInstanceStatus(id string, status string) error {
-	instancesLock.Lock()
-	instance, ok := instances[id]
-	instancesLock.Unlock()
-	if !ok {
-		return errors.New("instance not found")
-	}
-	instance.Status = status
-
-	// Update instance status in Redis
-	instanceJSON, _ := json.Marshal(instance)
-	rdb.HSet(context.Background(), "instances", id, instanceJSON)
-
-	return nil
-}
-
-// GetInstanceScreenshot captures a screenshot of an instance
-func (im *InstanceManager) GetInstanceScreenshot(id string) ([]byte, error) {
-	return DebugInstance(id)
-}
-
-func (i *Instance) Execute(action string, params map[string]interface{}) (string, error) {
-	// Implement the logic to execute the action on the instance
-	// This is a placeholder implementation
-	switch action {
-	case "exampleAction":
-		return "Action executed successfully", nil
-	default:
-		return "", fmt.Errorf("unknown action: %s", action)
-	}
-}
+package model
+
+import (
+	"auto/apperr"
+	"auto/ocr"
+	"auto/websocket"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInstanceNotFound       = apperr.New(apperr.CodeInstanceNotFound, "instance not found")
+	ErrInstanceNotRunning     = apperr.New(apperr.CodeInstanceNotRunning, "instance is not running")
+	ErrInstanceAlreadyRunning = apperr.New(apperr.CodeInstanceAlreadyRunning, "instance is already running")
+	ErrInstanceAlreadyStopped = apperr.New(apperr.CodeInstanceAlreadyStopped, "instance is already stopped")
+	ErrChromeCrashed          = apperr.New(apperr.CodeChromeCrashed, "instance browser crashed")
+)
+var tracer = otel.Tracer("auto/model")
+
+// ChromeDPContext is the former name of BrowserDriver.
+type ChromeDPContext = BrowserDriver
+
+type DefaultChromeDPContext struct{}
+
+func (d *DefaultChromeDPContext) Run(ctx context.Context, actions ...chromedp.Action) error {
+	if !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		return chromedp.Run(ctx, actions...)
+	}
+	ctx, span := tracer.Start(ctx, "chromedp.run", trace.WithAttributes(attribute.Int("chromedp.actions", len(actions))))
+	defer span.End()
+	err := chromedp.Run(ctx, actions...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+func (d *DefaultChromeDPContext) NewContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return chromedp.NewContext(ctx)
+}
+
+// Instance is a browser session. Its Status and browser contexts change
+// under the boot, stop and crash goroutines while handlers and the idle
+// reaper read them, so outside of this package they are read with
+// GetStatus; the package itself goes through state and setStatus. Its
+// settings are changed by the InstanceManager setters and read with
+// Settings.
+type Instance struct {
+	ID           string
+	URL          string
+	Auth         *Auth
+	Status       string
+	Context      context.Context    `json:"-"`
+	Cancel       context.CancelFunc `json:"-"`
+	ChromeCtx    context.Context    `json:"-"`
+	ChromeCancel context.CancelFunc `json:"-"`
+	Elements     *Elements
+	// LoginProfileID names the shared LoginProfile the instance logs in
+	// with; without one it uses Elements.
+	LoginProfileID string `json:",omitempty"`
+	AutoWait       AutoWait
+	// SeedCookies are set each time the instance starts, before it logs in.
+	SeedCookies []Cookie `json:",omitempty"`
+	Headers     HeaderOverrides
+	// Device is the mobile device preset the instance emulates, if any.
+	Device      string `json:",omitempty"`
+	Permissions map[string]string
+	HTTPAuth    HTTPAuth
+	// Tags are free-form names that select instances, see SetInstanceTags.
+	Tags []string `json:",omitempty"`
+	// TemplateID is the InstanceTemplate the instance was created from.
+	TemplateID string `json:",omitempty"`
+	// Driver selects the browser the instance runs in.
+	Driver DriverConfig
+	// Anonymous instances serve a single run and are not stored.
+	Anonymous bool `json:",omitempty"`
+	// Workspace owns the instance and its runs, for their quotas; empty
+	// is the default workspace.
+	Workspace string `json:",omitempty"`
+	// IdleTimeoutMinutes, if set, stops the instance when no flow has used
+	// it for that long; IdleStopped says it was and starts again on use.
+	IdleTimeoutMinutes int  `json:",omitempty"`
+	IdleStopped        bool `json:",omitempty"`
+	chrome             BrowserDriver
+	im                 *InstanceManager
+	webSockets         *WebSocketCapture
+	activeRuns         int32
+	lastUsed           int64
+	startedAt          time.Time
+	idleMu             sync.Mutex
+	queue              runQueue
+	stopping           int32
+	// booting is set from the launch of the browser until boot has logged
+	// in, or failed to.
+	booting int32
+	// stateMu guards Status, startedAt, the driver, the contexts of the
+	// browser, webSockets and the settings in InstanceSettings.
+	stateMu sync.RWMutex
+}
+
+// InstanceSettings are the settings of an instance its setters change,
+// read together.
+type InstanceSettings struct {
+	URL                string
+	LoginProfileID     string
+	SeedCookies        []Cookie
+	Headers            HeaderOverrides
+	Device             string
+	Permissions        map[string]string
+	HTTPAuth           HTTPAuth
+	Tags               []string
+	TemplateID         string
+	Driver             DriverConfig
+	IdleTimeoutMinutes int
+	IdleStopped        bool
+	Workspace          string
+}
+
+// Settings returns the settings of the instance. Setters replace slices
+// and maps rather than change them, so they are safe to read but not to
+// modify.
+func (i *Instance) Settings() InstanceSettings {
+	i.stateMu.RLock()
+	defer i.stateMu.RUnlock()
+	return InstanceSettings{
+		URL:                i.URL,
+		LoginProfileID:     i.LoginProfileID,
+		SeedCookies:        i.SeedCookies,
+		Headers:            i.Headers,
+		Device:             i.Device,
+		Permissions:        i.Permissions,
+		HTTPAuth:           i.HTTPAuth,
+		Tags:               i.Tags,
+		TemplateID:         i.TemplateID,
+		Driver:             i.Driver,
+		IdleTimeoutMinutes: i.IdleTimeoutMinutes,
+		IdleStopped:        i.IdleStopped,
+		Workspace:          i.Workspace,
+	}
+}
+
+// MarshalJSON encodes the instance with its state read under stateMu.
+func (i *Instance) MarshalJSON() ([]byte, error) {
+	type instance Instance
+	i.stateMu.RLock()
+	defer i.stateMu.RUnlock()
+	return json.Marshal((*instance)(i))
+}
+
+// GetStatus returns the status of the instance: "On", "Off" or
+// StatusCrashed.
+func (i *Instance) GetStatus() string {
+	i.stateMu.RLock()
+	defer i.stateMu.RUnlock()
+	return i.Status
+}
+
+// driver returns the driver the instance was last started with.
+func (i *Instance) driver() BrowserDriver {
+	i.stateMu.RLock()
+	defer i.stateMu.RUnlock()
+	return i.chrome
+}
+
+func (i *Instance) setStatus(status string) {
+	i.stateMu.Lock()
+	i.Status = status
+	i.stateMu.Unlock()
+}
+
+// state returns the status of the instance and the context of its
+// browser, read together; the context is nil if it never started.
+func (i *Instance) state() (string, context.Context) {
+	i.stateMu.RLock()
+	defer i.stateMu.RUnlock()
+	return i.Status, i.ChromeCtx
+}
+
+// liveContext returns the context of the browser of a running instance,
+// nil when it is not running.
+func (i *Instance) liveContext() context.Context {
+	status, ctx := i.state()
+	if status != "On" {
+		return nil
+	}
+	return ctx
+}
+
+type Auth struct {
+	Email    string
+	Password string
+}
+
+type Elements struct {
+	UsernameSel string
+	PasswordSel string
+	SubmitSel   string
+}
+
+func GenerateID() string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(time.Now().String())))
+}
+
+func (im *InstanceManager) newInstance(url string, auth *Auth, elements *Elements, chrome ChromeDPContext) *Instance {
+	return im.newInstanceWithID(GenerateID(), url, auth, elements, chrome)
+}
+
+func (im *InstanceManager) newInstanceWithID(id, url string, auth *Auth, elements *Elements, chrome ChromeDPContext) *Instance {
+	instance := &Instance{
+		ID:       id,
+		URL:      url,
+		Auth:     auth,
+		Status:   "Off",
+		Elements: elements,
+		AutoWait: DefaultAutoWait,
+		chrome:   chrome,
+		im:       im,
+	}
+	im.mu.Lock()
+	im.instances[id] = instance
+	im.mu.Unlock()
+
+	// Store instance details in Redis
+	im.saveInstance(instance)
+
+	return instance
+}
+
+// saveInstance stores the details of an instance in Redis; those of
+// anonymous instances are not kept.
+func (im *InstanceManager) saveInstance(instance *Instance) {
+	if instance.Anonymous {
+		return
+	}
+	instanceJSON, _ := json.Marshal(instance)
+	im.rdb.HSet(context.Background(), "instances", instance.ID, instanceJSON)
+}
+
+// StartInstance starts the browser of an instance; it logs in in the
+// background.
+func (im *InstanceManager) StartInstance(id string) error {
+	instance, ctx, err := im.launchInstance(id)
+	if err != nil {
+		return err
+	}
+	go instance.boot(ctx)
+	return nil
+}
+
+// StartInstanceAndWait starts an instance like StartInstance but returns
+// once it has logged in, for callers that use it straight away.
+func (im *InstanceManager) StartInstanceAndWait(id string) error {
+	instance, ctx, err := im.launchInstance(id)
+	if err != nil {
+		return err
+	}
+	return instance.boot(ctx)
+}
+
+// RestartInstance stops an instance, if it is not stopped already, and
+// starts it again, returning once it has logged in.
+func (im *InstanceManager) RestartInstance(id string) error {
+	if err := im.StopInstance(id); err != nil && err != ErrInstanceAlreadyStopped {
+		return err
+	}
+	instance, ctx, err := im.launchInstance(id)
+	if err != nil {
+		return err
+	}
+	return instance.boot(ctx)
+}
+
+// launchInstance starts the browser of an instance.
+func (im *InstanceManager) launchInstance(id string) (*Instance, context.Context, error) {
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Held until the instance is on, so that two starts do not launch two
+	// browsers.
+	instance.stateMu.Lock()
+	if instance.Status == "On" {
+		instance.stateMu.Unlock()
+		return nil, nil, ErrInstanceAlreadyRunning
+	}
+	chrome, err := newDriver(instance.Driver)
+	if err != nil {
+		instance.stateMu.Unlock()
+		return nil, nil, err
+	}
+	instance.chrome = chrome
+	ctx, cancel := chrome.NewContext(context.Background())
+	instance.Context = ctx
+	instance.Cancel = cancel
+	instance.ChromeCtx, instance.ChromeCancel = ctx, cancel
+	instance.webSockets = NewWebSocketCapture(DefaultWebSocketFrameLimit)
+	instance.webSockets.Listen(ctx)
+	atomic.StoreInt32(&instance.stopping, 0)
+	atomic.StoreInt32(&instance.booting, 1)
+	instance.supervise(ctx, cancel)
+	instance.Status = "On"
+	instance.IdleStopped = false
+	instance.startedAt = time.Now()
+	instance.touch()
+	instance.stateMu.Unlock()
+
+	// Update instance status in Redis
+	im.saveInstance(instance)
+	im.recordInstanceStart(id)
+
+	return instance, ctx, nil
+}
+
+// boot prepares a freshly launched browser and logs the instance in. An
+// instance that fails to log in is off again.
+func (i *Instance) boot(ctx context.Context) error {
+	defer atomic.StoreInt32(&i.booting, 0)
+	if err := i.enableHTTPAuth(ctx); err != nil {
+		i.im.logger.Error("Failed to enable HTTP auth", zap.String("id", i.ID), zap.Error(err))
+	}
+	if err := i.applyPermissions(ctx); err != nil {
+		i.im.logger.Error("Failed to apply instance permissions", zap.String("id", i.ID), zap.Error(err))
+	}
+	settings := i.Settings()
+	if settings.Device != "" {
+		if err := i.driver().Run(ctx, emulateDevice(settings.Device)); err != nil {
+			i.im.logger.Error("Failed to emulate instance device", zap.String("id", i.ID), zap.String("device", settings.Device), zap.Error(err))
+		}
+	}
+	if h := i.baseHeaders(); !h.empty() {
+		if err := i.driver().Run(ctx, applyHeaders(h)); err != nil {
+			i.im.logger.Error("Failed to apply instance header overrides", zap.String("id", i.ID), zap.Error(err))
+		}
+	}
+	if len(settings.SeedCookies) > 0 {
+		if err := i.driver().Run(ctx, setCookies(settings.SeedCookies)); err != nil {
+			i.im.logger.Error("Failed to set instance seed cookies", zap.String("id", i.ID), zap.Error(err))
+		}
+	}
+	if err := i.restoreIdleCookies(ctx); err != nil {
+		i.im.logger.Error("Failed to restore cookies of idle instance", zap.String("id", i.ID), zap.Error(err))
+	}
+	if err := i.driver().Run(ctx, navigateAndAuthenticate(i)); err != nil {
+		var loginErr *LoginFailedError
+		if errors.As(err, &loginErr) {
+			i.im.logger.Error("Instance login failed", zap.String("id", i.ID), zap.String("reason", loginErr.Reason), zap.String("url", loginErr.URL))
+		}
+		i.im.logger.Error("Failed to start instance", zap.Error(err))
+		i.setStatus("Off")
+		return err
+	}
+	i.im.logger.Info("Instance started", zap.String("id", i.ID))
+	return nil
+}
+
+// recordInstanceStart keeps the start times of an instance for a day so
+// restart rates can be monitored.
+func (im *InstanceManager) recordInstanceStart(id string) {
+	now := time.Now()
+	key := fmt.Sprintf("instance_starts:%s", id)
+	im.rdb.ZAdd(context.Background(), key, &redis.Z{Score: float64(now.UnixNano()), Member: now.UnixNano()})
+	im.rdb.ZRemRangeByScore(context.Background(), key, "-inf", fmt.Sprintf("%d", now.Add(-24*time.Hour).UnixNano()))
+}
+
+// StopInstance stops an instance by ID
+func (im *InstanceManager) StopInstance(id string) error {
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return err
+	}
+	instance.stateMu.Lock()
+	if instance.Status == "Off" {
+		instance.stateMu.Unlock()
+		return ErrInstanceAlreadyStopped
+	}
+	atomic.StoreInt32(&instance.stopping, 1)
+	cancel, chromeCancel := instance.Cancel, instance.ChromeCancel
+	instance.Status = "Off"
+	instance.IdleStopped = false
+	instance.stateMu.Unlock()
+	// Cancelling waits for the browser to exit, which is not worth holding
+	// up readers of the status for.
+	if cancel != nil {
+		cancel()
+		chromeCancel()
+	}
+
+	// Update instance status in Redis
+	im.saveInstance(instance)
+
+	return nil
+}
+
+// DeleteInstance deletes an instance by ID
+func (im *InstanceManager) DeleteInstance(id string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if _, ok := im.instances[id]; !ok {
+		return ErrInstanceNotFound
+	}
+	delete(im.instances, id)
+
+	// Remove instance from Redis
+	im.rdb.HDel(context.Background(), "instances", id)
+
+	return nil
+}
+
+// GetInstanceScreenshot captures a screenshot of an instance, giving up
+// when ctx is done.
+func (im *InstanceManager) GetInstanceScreenshot(ctx context.Context, id string) ([]byte, error) {
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return nil, err
+	}
+	browserCtx, cancel, err := instance.browserContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	var buf []byte
+	if err := instance.driver().Run(browserCtx, chromedp.CaptureScreenshot(&buf)); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// browserContext returns a context of the browser of the instance that
+// ends with ctx too, by its deadline if it has one, for calls made on
+// behalf of a request. It fails with ErrInstanceNotRunning when the
+// instance has no browser.
+func (i *Instance) browserContext(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	chromeCtx := i.liveContext()
+	if chromeCtx == nil {
+		return nil, nil, ErrInstanceNotRunning
+	}
+	browserCtx, cancel := context.WithCancel(chromeCtx)
+	cancelDeadline := func() {}
+	if deadline, ok := ctx.Deadline(); ok {
+		browserCtx, cancelDeadline = context.WithDeadline(browserCtx, deadline)
+	}
+	stop := context.AfterFunc(ctx, cancel)
+	return browserCtx, func() {
+		stop()
+		cancelDeadline()
+		cancel()
+	}, nil
+}
+
+func navigateAndAuthenticate(instance *Instance) chromedp.Tasks {
+	settings := instance.Settings()
+	if settings.LoginProfileID != "" {
+		return loginWithProfile(instance, settings.LoginProfileID)
+	}
+	return chromedp.Tasks{
+		navigateTo(settings.URL),
+		waitVisible(instance.Elements.UsernameSel),
+		typeInto(instance.Elements.UsernameSel, instance.Auth.Email),
+		clickOn(instance.Elements.PasswordSel),
+		waitVisible(instance.Elements.PasswordSel),
+		typeInto(instance.Elements.PasswordSel, instance.Auth.Password),
+		clickOn(instance.Elements.SubmitSel),
+	}
+}
+
+func SendMessage(conn *websocket.Conn, status int, message interface{}, instanceID string) error {
+	return conn.WriteJSON(map[string]interface{}{
+		"status":   status,
+		"message":  message,
+		"instance": instanceID,
+	})
+}
+
+func SaveCrawOutput(resultList map[string][]interface{}, filePath string) error {
+	data, err := json.Marshal(resultList)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, data, 0644)
+}
+
+func ParseURL(sourceURL string) (*url.URL, error) {
+	u, err := url.Parse(sourceURL)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func EscapePercentSign(raw string) string {
+	return strings.ReplaceAll(raw, "%", "%25")
+}
+
+func DealMultipart(contentType, ruleBody string) (string, error) {
+	re := regexp.MustCompile(`(?m)multipart\/form-Data; boundary=(.*)`)
+	match := re.FindStringSubmatch(contentType)
+	if len(match) != 2 {
+		return "", errors.New("no boundary in content-type")
+	}
+	boundary := "--" + match[1]
+	multiPartContent := ""
+	multiFile := strings.Split(ruleBody, boundary)
+	if len(multiFile) == 0 {
+		return "", errors.New("ruleBody.Body multi content format err")
+	}
+	for _, singleFile := range multiFile {
+		spliteTmp := strings.Split(singleFile, "\n\n")
+		if len(spliteTmp) == 2 {
+			fileHeader := spliteTmp[0]
+			fileBody := spliteTmp[1]
+			fileHeader = strings.Replace(fileHeader, "\n", "\r\n", -1)
+			multiPartContent += boundary + fileHeader + "\r\n\r\n" + strings.TrimRight(fileBody, "\n") + "\r\n"
+		}
+	}
+	multiPartContent += boundary + "--" + "\r\n"
+	return multiPartContent, nil
+}
+
+// Define the missing types and variables
+
+type Options struct {
+	Headers  map[string]interface{}
+	PostData string
+}
+
+type URL struct {
+	url.URL
+}
+
+type Request struct {
+	URL             *URL
+	Method          string
+	Headers         map[string]interface{}
+	PostData        string
+	RedirectionFlag bool
+}
+
+// Response is a captured HTTP response together with the document that
+// triggered the request.
+type Response struct {
+	URL         string            `json:"url"`
+	DocumentURL string            `json:"document_url"`
+	Status      int               `json:"status"`
+	Headers     map[string]string `json:"headers"`
+	MimeType    string            `json:"mime_type"`
+	Body        string            `json:"body,omitempty"`
+}
+
+var supportContentType = []string{
+	"application/json",
+	"application/x-www-form-urlencoded",
+	"multipart/form-data",
+}
+
+func GetRequest(method string, URL *URL, options ...Options) Request {
+	var req Request
+	req.URL = URL
+	req.Method = strings.ToUpper(method)
+	if len(options) != 0 {
+		option := options[0]
+		if option.Headers != nil {
+			req.Headers = option.Headers
+		}
+		if option.PostData != "" {
+			req.PostData = option.PostData
+		}
+	} else {
+		req.Headers = map[string]interface{}{}
+	}
+	return req
+}
+
+func (req *Request) SimpleFormat() string {
+	if ops := req.GraphQL(); ops != nil {
+		return req.Method + " " + req.URL.NoQueryUrl() + " " + graphQLSummary(ops)
+	}
+	var tempStr = req.Method
+	tempStr += " " + req.URL.String() + " "
+	if req.Method == "POST" {
+		tempStr += req.PostData
+	}
+	return tempStr
+}
+
+func (req *Request) NoHeaderId() string {
+	h := md5.New()
+	if ops := req.GraphQL(); ops != nil {
+		h.Write([]byte(req.Method + req.URL.NoQueryUrl() + graphQLKey(ops)))
+	} else {
+		h.Write([]byte(req.Method + req.URL.String() + req.PostData))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (req *Request) UniqueId() string {
+	if req.RedirectionFlag {
+		h := md5.New()
+		h.Write([]byte(req.NoHeaderId() + "Redirection"))
+		return hex.EncodeToString(h.Sum(nil))
+	} else {
+		return req.NoHeaderId()
+	}
+}
+
+func (req *Request) PostDataMap() map[string]interface{} {
+	contentType, err := req.getContentType()
+	if err != nil {
+		return map[string]interface{}{
+			"key": req.PostData,
+		}
+	}
+	if strings.HasPrefix(contentType, "application/json") {
+		var result map[string]interface{}
+		err = json.Unmarshal([]byte(req.PostData), &result)
+		if err != nil {
+			return map[string]interface{}{
+				"key": req.PostData,
+			}
+		} else {
+			return result
+		}
+	} else if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		var result = map[string]interface{}{}
+		r, err := url.ParseQuery(req.PostData)
+		if err != nil {
+			return map[string]interface{}{
+				"key": req.PostData,
+			}
+		} else {
+			for key, value := range r {
+				if len(value) == 1 {
+					result[key] = value[0]
+				} else {
+					result[key] = value
+				}
+			}
+			return result
+		}
+	} else {
+		return map[string]interface{}{
+			"key": req.PostData,
+		}
+	}
+}
+
+func (req *Request) QueryMap() map[string][]string {
+	return req.URL.Query()
+}
+
+func (req *Request) getContentType() (string, error) {
+	headers := req.Headers
+	var contentType string
+	if ct, ok := headers["Content-Type"]; ok {
+		contentType = ct.(string)
+	} else if ct, ok := headers["Content-type"]; ok {
+		contentType = ct.(string)
+	} else if ct, ok := headers["content-type"]; ok {
+		contentType = ct.(string)
+	} else {
+		return "", errors.New("no content-type")
+	}
+	if SupportedContentType(contentType) {
+		return contentType, nil
+	}
+	return "", errors.New("dont support such content-type:" + contentType)
+}
+
+// SupportedContentType reports whether bodies of contentType are
+// understood: JSON, URL-encoded forms and multipart forms.
+func SupportedContentType(contentType string) bool {
+	for _, ct := range supportContentType {
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(contentType)), ct) {
+			return true
+		}
+	}
+	return false
+}
+
+func UrlParse(sourceUrl string) (*url.URL, error) {
+	u, err := url.Parse(sourceUrl)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func GetUrl(_url string, parentUrls ...URL) (*URL, error) {
+	var u URL
+	_url, err := u.parse(_url, parentUrls...)
+	if err != nil {
+		return nil, err
+	}
+	if len(parentUrls) == 0 {
+		_u, err := UrlParse(_url)
+		if err != nil {
+			return nil, err
+		}
+		u = URL{*_u}
+		if u.Path == "" {
+			u.Path = "/"
+		}
+	} else {
+		pUrl := parentUrls[0]
+		_u, err := pUrl.Parse(_url)
+		if err != nil {
+			return nil, err
+		}
+		u = URL{*_u}
+		if u.Path == "" {
+			u.Path = "/"
+		}
+	}
+	fixPath := regexp.MustCompile("^/{2,}")
+	if fixPath.MatchString(u.Path) {
+		u.Path = fixPath.ReplaceAllString(u.Path, "/")
+	}
+	return &u, nil
+}
+
+func (u *URL) parse(_url string, parentUrls ...URL) (string, error) {
+	_url = strings.Trim(_url, " ")
+	if len(_url) == 0 {
+		return "", errors.New("invalid url, length 0")
+	}
+	if strings.Count(_url, "#") > 1 {
+		_url = regexp.MustCompile(`#+`).ReplaceAllString(_url, "#")
+	}
+	if len(parentUrls) == 0 {
+		return _url, nil
+	}
+	if strings.HasPrefix(_url, "http://") || strings.HasPrefix(_url, "https://") {
+		return _url, nil
+	} else if strings.HasPrefix(_url, "javascript:") {
+		return "", errors.New("invalid url, javascript protocol")
+	} else if strings.HasPrefix(_url, "mailto:") {
+		return "", errors.New("invalid url, mailto protocol")
+	}
+	return _url, nil
+}
+
+func (u *URL) QueryMap() map[string]interface{} {
+	queryMap := map[string]interface{}{}
+	for key, value := range u.Query() {
+		if len(value) == 1 {
+			queryMap[key] = value[0]
+		} else {
+			queryMap[key] = value
+		}
+	}
+	return queryMap
+}
+
+func (u *URL) NoQueryUrl() string {
+	return fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, u.Path)
+}
+
+func (u *URL) NoFragmentUrl() string {
+	return strings.Replace(u.String(), u.Fragment, "", -1)
+}
+
+func (u *URL) NoSchemeFragmentUrl() string {
+	return fmt.Sprintf("://%s%s", u.Host, u.Path)
+}
+
+func (u *URL) NavigationUrl() string {
+	return u.NoSchemeFragmentUrl()
+}
+
+func (u *URL) RootDomain() string {
+	domain := u.Hostname()
+	if strings.Count(domain, ".") == 1 {
+		return domain
+	}
+	parts := strings.Split(domain, ".")
+	if len(parts) >= 2 {
+		parts = parts[len(parts)-2:]
+		return strings.Join(parts, ".")
+	} else {
+		return ""
+	}
+}
+
+func (u *URL) FileName() string {
+	parts := strings.Split(u.Path, `/`)
+	lastPart := parts[len(parts)-1]
+	if strings.Contains(lastPart, ".") {
+		return lastPart
+	} else {
+		return ""
+	}
+}
+
+func (u *URL) FileExt() string {
+	fileName := u.FileName()
+	if fileName == "" {
+		return ""
+	}
+	parts := strings.Split(fileName, ".")
+	return strings.ToLower(parts[len(parts)-1])
+}
+
+func (u *URL) ParentPath() string {
+	if u.Path == "/" {
+		return ""
+	} else if strings.HasSuffix(u.Path, "/") {
+		if strings.Count(u.Path, "/") == 2 {
+			return "/"
+		}
+		parts := strings.Split(u.Path, "/")
+		parts = parts[:len(parts)-2]
+		return strings.Join(parts, "/")
+	} else {
+		if strings.Count(u.Path, "/") == 1 {
+			return "/"
+		}
+		parts := strings.Split(u.Path, "/")
+		parts = parts[:len(parts)-1]
+		return strings.Join(parts, "/")
+	}
+}
+
+// InstanceManager manages instances. It holds all of their state, so
+// managers with their own Redis database do not share anything.
+type InstanceManager struct {
+	logger       *zap.Logger
+	rdb          *redis.Client
+	queueTimeout time.Duration
+
+	mu        sync.Mutex
+	instances map[string]*Instance
+
+	crashMu        sync.Mutex
+	crashListeners []func(instance *Instance, reason string)
+
+	// standbyMu keeps refills of standby pools from starting the same
+	// instance twice.
+	standbyMu sync.Mutex
+
+	ocr ocr.Engine
+}
+
+// NewInstanceManager creates a new InstanceManager keeping instances in
+// rdb. Flows wait at most queueTimeout for an instance another flow is
+// driving.
+func NewInstanceManager(logger *zap.Logger, rdb *redis.Client, queueTimeout time.Duration) *InstanceManager {
+	return &InstanceManager{
+		logger:       logger,
+		rdb:          rdb,
+		queueTimeout: queueTimeout,
+		instances:    make(map[string]*Instance),
+	}
+}
+
+// CreateInstance creates a new instance
+func (im *InstanceManager) CreateInstance(url string, auth Auth) (*Instance, error) {
+	instance := im.newInstance(url, &auth, defaultElements(), &DefaultChromeDPContext{})
+	return instance, nil
+}
+
+// CreateInstanceWithID creates a new instance under an ID of the caller's
+// choosing, such as one from a declarative spec.
+func (im *InstanceManager) CreateInstanceWithID(id, url string, auth Auth) (*Instance, error) {
+	if id == "" {
+		return nil, errors.New("instance id is required")
+	}
+	if _, err := im.GetInstance(id); err == nil {
+		return nil, apperr.New(apperr.CodeConflict, fmt.Sprintf("instance %s already exists", id))
+	}
+	return im.newInstanceWithID(id, url, &auth, defaultElements(), &DefaultChromeDPContext{}), nil
+}
+
+// SetInstanceURL changes the URL an instance opens; it takes effect the
+// next time the instance starts.
+func (im *InstanceManager) SetInstanceURL(id, url string) error {
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return err
+	}
+	instance.stateMu.Lock()
+	instance.URL = url
+	instance.stateMu.Unlock()
+
+	im.saveInstance(instance)
+
+	return nil
+}
+
+// SetInstanceWorkspace gives an instance to a workspace.
+func (im *InstanceManager) SetInstanceWorkspace(id, workspace string) error {
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return err
+	}
+	instance.stateMu.Lock()
+	instance.Workspace = workspace
+	instance.stateMu.Unlock()
+
+	im.saveInstance(instance)
+
+	return nil
+}
+
+// defaultElements are the login form selectors new instances start with.
+func defaultElements() *Elements {
+	return &Elements{
+		UsernameSel: "input[name='username']",
+		PasswordSel: "input[name='password']",
+		SubmitSel:   "button[type='submit']",
+	}
+}
+
+// GetInstance retrieves an instance by ID
+func (im *InstanceManager) GetInstance(id string) (*Instance, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	instance, ok := im.instances[id]
+	if !ok {
+		return nil, ErrInstanceNotFound
+	}
+	return instance, nil
+}
+
+// LoadInstances registers the instances stored in Redis that are not
+// known yet, stopped, and returns how many were added. Instances that are
+// already registered keep running as they are.
+func (im *InstanceManager) LoadInstances() (int, error) {
+	all, err := im.rdb.HGetAll(context.Background(), "instances").Result()
+	if err != nil {
+		return 0, err
+	}
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	added := 0
+	for id, data := range all {
+		if _, ok := im.instances[id]; ok {
+			continue
+		}
+		var instance Instance
+		if err := json.Unmarshal([]byte(data), &instance); err != nil {
+			im.logger.Warn("Skipping unreadable instance", zap.String("id", id), zap.Error(err))
+			continue
+		}
+		instance.ID = id
+		instance.Status = "Off"
+		instance.chrome = &DefaultChromeDPContext{}
+		instance.im = im
+		im.instances[id] = &instance
+		added++
+	}
+	return added, nil
+}
+
+// GetInstances retrieves all instances
+func (im *InstanceManager) GetInstances() []*Instance {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	instanceList := make([]*Instance, 0, len(im.instances))
+	for _, instance := range im.instances {
+		instanceList = append(instanceList, instance)
+	}
+	return instanceList
+}
+
+// StartInstancesConcurrently starts multiple instances concurrently
+func (im *InstanceManager) StartInstancesConcurrently(instanceIDs []string) []error {
+	var wg sync.WaitGroup
+	errChan := make(chan error, len(instanceIDs))
+
+	for _, id := range instanceIDs {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if err := im.StartInstance(id); err != nil {
+				errChan <- err
+			}
+		}(id)
+	}
+
+	wg.Wait()
+	close(errChan)
+
+	var errors []error
+	for err := range errChan {
+		errors = append(errors, err)
+	}
+
+	return errors
+}
+
+// StopAllInstances stops all instances. StopInstance takes the lock of the
+// manager, so the instances are listed first.
+func (im *InstanceManager) StopAllInstances() []error {
+	var errors []error
+	for _, instance := range im.GetInstances() {
+		if err := im.StopInstance(instance.ID); err != nil {
+			errors = append(errors, err)
+		}
+	}
+
+	return errors
+}
+
+// UpdateInstanceStatus updates the status of an instance
+func (im *InstanceManager) UpdateInstanceStatus(id string, status string) error {
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return err
+	}
+	instance.setStatus(status)
+
+	// Update instance status in Redis
+	im.saveInstance(instance)
+
+	return nil
+}
+
+// GetInstanceWebSocketFrames returns the WebSocket frames captured on an
+// instance since it was last started, filtered by socket URL pattern
+func (im *InstanceManager) GetInstanceWebSocketFrames(id string, pattern string) ([]WebSocketFrame, error) {
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return nil, err
+	}
+	instance.stateMu.RLock()
+	webSockets := instance.webSockets
+	instance.stateMu.RUnlock()
+	if webSockets == nil {
+		return []WebSocketFrame{}, nil
+	}
+	return FilterWebSocketFrames(webSockets.Frames(), pattern)
+}
+
+// GetInstanceStorage dumps the Web Storage of the page an instance shows,
+// giving up when ctx is done.
+func (im *InstanceManager) GetInstanceStorage(ctx context.Context, id string) (*StorageDump, error) {
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return nil, err
+	}
+	return instance.DumpStorage(ctx)
+}
+
+// SetInstancePermissions replaces the browser permissions of an instance,
+// applying them straight away when it is running
+func (im *InstanceManager) SetInstancePermissions(id string, perms map[string]string) error {
+	if err := ValidatePermissions(perms); err != nil {
+		return err
+	}
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return err
+	}
+	instance.stateMu.Lock()
+	instance.Permissions = perms
+	instance.stateMu.Unlock()
+
+	im.saveInstance(instance)
+
+	if ctx := instance.liveContext(); ctx != nil {
+		return instance.applyPermissions(ctx)
+	}
+	return nil
+}
+
+// SetInstanceHTTPAuth replaces the basic-auth credentials and client
+// certificates of an instance; they take effect the next time it starts
+func (im *InstanceManager) SetInstanceHTTPAuth(id string, auth HTTPAuth) error {
+	if err := ValidateHTTPAuth(auth); err != nil {
+		return err
+	}
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return err
+	}
+	instance.stateMu.Lock()
+	instance.HTTPAuth = auth
+	instance.stateMu.Unlock()
+
+	im.saveInstance(instance)
+
+	return nil
+}
+
+// CountInstanceStarts returns how many times an instance was started since
+// the given time, looking back at most a day
+func (im *InstanceManager) CountInstanceStarts(id string, since time.Time) (int64, error) {
+	return im.rdb.ZCount(context.Background(), fmt.Sprintf("instance_starts:%s", id), fmt.Sprintf("%d", since.UnixNano()), "+inf").Result()
+}
+
+func (i *Instance) Execute(action string, params map[string]interface{}) (string, error) {
+	return i.ExecuteContext(context.Background(), action, params)
+}
+
+// ExecuteContext runs an action like Execute, tracing the browser calls it
+// makes as part of the span in ctx. The action stops when ctx is cancelled
+// or the browser context of the instance ends.
+func (i *Instance) ExecuteContext(ctx context.Context, action string, params map[string]interface{}) (string, error) {
+	handler, ok := actionHandlers[action]
+	if !ok {
+		return "", apperr.New(apperr.CodeUnknownAction, fmt.Sprintf("unknown action: %s", action))
+	}
+	status, chromeCtx := i.state()
+	if status == StatusCrashed {
+		return "", ErrChromeCrashed
+	}
+	if status != "On" || chromeCtx == nil {
+		return "", ErrInstanceNotRunning
+	}
+	actionCtx, cancel := context.WithCancel(trace.ContextWithSpan(chromeCtx, trace.SpanFromContext(ctx)))
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+	result, err := handler(actionCtx, i, params)
+	if err != nil && chromeCtx.Err() != nil && atomic.LoadInt32(&i.stopping) == 0 {
+		// The browser went away while the action ran.
+		return "", apperr.Wrap(apperr.CodeChromeCrashed, err)
+	}
+	return result, err
+}
@@ -0,0 +1,94 @@
+package model
+
+import (
+	"context"
+	"errors"
+
+	"auto/ocr"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+func init() {
+	actionHandlers["ocr"] = ocrAction
+}
+
+// SetOCR sets the engine the "ocr" action recognises text with.
+func (im *InstanceManager) SetOCR(engine ocr.Engine) {
+	im.ocr = engine
+}
+
+// ocrAction screenshots the "selector" element, the "region" of the page
+// given as {"x", "y", "width", "height"} in CSS pixels, or the viewport,
+// and returns the text the OCR engine recognises in it. "scale" enlarges
+// the image first, which helps with small text; "lang" is the language
+// of the text, as a tesseract language code.
+func ocrAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	if i.im.ocr == nil {
+		return "", errors.New("OCR is not configured")
+	}
+	scale := 1.0
+	if v, ok := params["scale"].(float64); ok && v > 0 {
+		scale = v
+	}
+	lang, _ := params["lang"].(string)
+
+	var img []byte
+	_, bySelector := params["selector"]
+	_, byRole := params["role"]
+	if bySelector || byRole {
+		sel, w, err := i.targetFor(ctx, params)
+		if err != nil {
+			return "", err
+		}
+		w.Enabled = false
+		if err := i.runWithAutoWait(ctx, w, sel, chromedp.ScreenshotScale(sel, scale, &img, chromedp.ByQuery)); err != nil {
+			return "", err
+		}
+	} else {
+		clip, err := ocrRegion(params, scale)
+		if err != nil {
+			return "", err
+		}
+		err = i.driver().Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+			if clip == nil {
+				var vp []float64
+				if err := chromedp.Evaluate(viewportJS, &vp).Do(ctx); err != nil {
+					return err
+				}
+				if len(vp) != 4 {
+					return errors.New("could not measure the viewport")
+				}
+				clip = &page.Viewport{X: vp[0], Y: vp[1], Width: vp[2], Height: vp[3], Scale: scale}
+			}
+			var err error
+			img, err = page.CaptureScreenshot().WithFormat(page.CaptureScreenshotFormatPng).WithClip(clip).Do(ctx)
+			return err
+		}))
+		if err != nil {
+			return "", err
+		}
+	}
+	return i.im.ocr.Recognize(ctx, img, lang)
+}
+
+// ocrRegion reads the "region" parameter, if any.
+func ocrRegion(params map[string]interface{}, scale float64) (*page.Viewport, error) {
+	raw, ok := params["region"]
+	if !ok {
+		return nil, nil
+	}
+	region, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("region must be an object with x, y, width and height")
+	}
+	x, _ := region["x"].(float64)
+	y, _ := region["y"].(float64)
+	width, _ := region["width"].(float64)
+	height, _ := region["height"].(float64)
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("region needs a positive width and height")
+	}
+	return &page.Viewport{X: x, Y: y, Width: width, Height: height, Scale: scale}, nil
+}
@@ -0,0 +1,131 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// maxPageBody caps how much of the HTML of a page a PageCapture keeps.
+const maxPageBody = 256 << 10
+
+// pageSnapshotTimeout bounds how long reading the page of a step may take.
+const pageSnapshotTimeout = 5 * time.Second
+
+// PageCapture records the traffic of the pages a browser target shows,
+// along with their HTML as steps leave it, for passive analysis of a run.
+type PageCapture struct {
+	mu        sync.Mutex
+	documents map[network.RequestID]string
+	reqs      []Request
+	resps     []Response
+	limit     int
+}
+
+func NewPageCapture(limit int) *PageCapture {
+	return &PageCapture{documents: make(map[network.RequestID]string), limit: limit}
+}
+
+// Listen starts recording the traffic of the target behind ctx until ctx
+// is cancelled.
+func (c *PageCapture) Listen(ctx context.Context) {
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch e := ev.(type) {
+		case *network.EventRequestWillBeSent:
+			if e.Request == nil || (!strings.HasPrefix(e.Request.URL, "http://") && !strings.HasPrefix(e.Request.URL, "https://")) {
+				return
+			}
+			u, err := GetUrl(e.Request.URL)
+			if err != nil {
+				return
+			}
+			req := GetRequest(e.Request.Method, u, Options{Headers: e.Request.Headers, PostData: DecodePostData(e.Request.PostDataEntries)})
+			c.mu.Lock()
+			if c.limit <= 0 || len(c.reqs) < c.limit {
+				c.reqs = append(c.reqs, req)
+				c.documents[e.RequestID] = e.DocumentURL
+			}
+			c.mu.Unlock()
+		case *network.EventResponseReceived:
+			headers := make(map[string]string, len(e.Response.Headers))
+			for k, v := range e.Response.Headers {
+				headers[k] = fmt.Sprint(v)
+			}
+			c.mu.Lock()
+			documentURL, ok := c.documents[e.RequestID]
+			delete(c.documents, e.RequestID)
+			if ok && (c.limit <= 0 || len(c.resps) < c.limit) {
+				c.resps = append(c.resps, Response{
+					URL:         e.Response.URL,
+					DocumentURL: documentURL,
+					Status:      int(e.Response.Status),
+					Headers:     headers,
+					MimeType:    e.Response.MimeType,
+				})
+			}
+			c.mu.Unlock()
+		}
+	})
+}
+
+// addPage keeps html as the body of the last response for url, or as a
+// response of its own for a page the browser did not load from the
+// network, such as one a script navigated to.
+func (c *PageCapture) addPage(url, html string) {
+	if len(html) > maxPageBody {
+		html = html[:maxPageBody]
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := len(c.resps) - 1; i >= 0; i-- {
+		if c.resps[i].URL == url {
+			c.resps[i].Body = html
+			return
+		}
+	}
+	if c.limit <= 0 || len(c.resps) < c.limit {
+		c.resps = append(c.resps, Response{URL: url, DocumentURL: url, Body: html})
+	}
+}
+
+// Traffic returns copies of the recorded requests and responses.
+func (c *PageCapture) Traffic() ([]Request, []Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Request(nil), c.reqs...), append([]Response(nil), c.resps...)
+}
+
+// CapturePages records the traffic of the browser of the instance into c
+// until ctx is done.
+func (i *Instance) CapturePages(ctx context.Context, c *PageCapture) error {
+	return i.listen(ctx, c.Listen)
+}
+
+// SnapshotPage records the HTML of the page the instance shows into c.
+func (i *Instance) SnapshotPage(ctx context.Context, c *PageCapture) error {
+	chromeCtx := i.liveContext()
+	if chromeCtx == nil {
+		return ErrInstanceNotRunning
+	}
+	snapCtx, cancel := context.WithTimeout(chromeCtx, pageSnapshotTimeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+	var url, html string
+	err := i.driver().Run(snapCtx,
+		chromedp.Location(&url),
+		chromedp.Evaluate(`document.documentElement ? document.documentElement.outerHTML : ""`, &html),
+	)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		c.addPage(url, html)
+	}
+	return nil
+}
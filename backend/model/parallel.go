@@ -0,0 +1,218 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/chromedp"
+)
+
+func init() {
+	actionHandlers["parallel"] = parallelAction
+}
+
+// Join modes of a parallel step: "all" needs every child to succeed, "any"
+// at least one, and "first-success" stops the others once one has.
+const (
+	JoinAll          = "all"
+	JoinAny          = "any"
+	JoinFirstSuccess = "first-success"
+)
+
+// parallelChild is a step run by a parallel step.
+type parallelChild struct {
+	id      string
+	url     string
+	handler actionHandler
+	params  map[string]interface{}
+}
+
+type childResult struct {
+	id     string
+	result string
+	err    error
+}
+
+// parallelChildren reads the "steps" of a parallel step, each given as
+// {"id": ..., "action": ..., "params": {...}} with an optional "url".
+func parallelChildren(params map[string]interface{}) ([]parallelChild, error) {
+	steps, ok := params["steps"].([]interface{})
+	if !ok || len(steps) == 0 {
+		return nil, errors.New("missing steps parameter")
+	}
+	children := make([]parallelChild, 0, len(steps))
+	seen := make(map[string]bool, len(steps))
+	for n, s := range steps {
+		step, ok := s.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("steps[%d] is not an object", n)
+		}
+		id, _ := step["id"].(string)
+		if id == "" {
+			id = fmt.Sprintf("%d", n)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate step id: %s", id)
+		}
+		seen[id] = true
+		action, _ := step["action"].(string)
+		if action == "" || action == "parallel" {
+			return nil, fmt.Errorf("step %s: invalid action: %q", id, action)
+		}
+		handler, ok := actionHandlers[action]
+		if !ok {
+			return nil, fmt.Errorf("step %s: unknown action: %s", id, action)
+		}
+		stepParams, _ := step["params"].(map[string]interface{})
+		if stepParams == nil {
+			stepParams = map[string]interface{}{}
+		}
+		url, _ := step["url"].(string)
+		children = append(children, parallelChild{id: id, url: url, handler: handler, params: stepParams})
+	}
+	return children, nil
+}
+
+// parallelAction runs the "steps" concurrently and returns their results
+// as a JSON object keyed by step ID. By default each step gets a tab of
+// its own, opened on its "url" or the current page, so steps cannot
+// disturb each other; tabs share cookies and storage with the page but
+// not its request interception. With "isolate" false the steps share the
+// page, which suits steps that only read it. "join" is one of JoinAll
+// (the default), JoinAny and JoinFirstSuccess; "maxConcurrency" bounds the
+// steps running at once.
+func parallelAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	children, err := parallelChildren(params)
+	if err != nil {
+		return "", err
+	}
+	join, _ := params["join"].(string)
+	if join == "" {
+		join = JoinAll
+	}
+	if join != JoinAll && join != JoinAny && join != JoinFirstSuccess {
+		return "", fmt.Errorf("invalid join: %q", join)
+	}
+	isolate := true
+	if v, ok := params["isolate"].(bool); ok {
+		isolate = v
+	}
+	maxConcurrency := intParam(params, "maxConcurrency", len(children))
+
+	pageURL := ""
+	if isolate {
+		if err := i.driver().Run(ctx, chromedp.Location(&pageURL)); err != nil {
+			return "", err
+		}
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan childResult, len(children))
+	slots := make(chan struct{}, maxConcurrency)
+	var wg sync.WaitGroup
+	for _, child := range children {
+		wg.Add(1)
+		go func(child parallelChild) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-runCtx.Done():
+				results <- childResult{id: child.id, err: runCtx.Err()}
+				return
+			}
+			result, err := i.runChild(runCtx, child, isolate, pageURL)
+			results <- childResult{id: child.id, result: result, err: err}
+		}(child)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	succeeded := make(map[string]interface{})
+	failed := make(map[string]error)
+	for r := range results {
+		if r.err != nil {
+			failed[r.id] = r.err
+			if join == JoinAll {
+				cancel()
+			}
+			continue
+		}
+		succeeded[r.id] = resultValue(r.result)
+		if join == JoinFirstSuccess {
+			cancel()
+			break
+		}
+	}
+
+	switch {
+	case join == JoinAll && len(failed) > 0:
+		return "", fmt.Errorf("parallel step failed: %s", describeFailures(failed, ctx.Err()))
+	case len(succeeded) == 0:
+		return "", fmt.Errorf("no parallel step succeeded: %s", describeFailures(failed, ctx.Err()))
+	}
+	data, err := json.Marshal(succeeded)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// runChild runs one step of a parallel step, in a tab of its own when
+// isolate is set. The tab is closed when the step ends.
+func (i *Instance) runChild(ctx context.Context, child parallelChild, isolate bool, pageURL string) (string, error) {
+	if !isolate {
+		return child.handler(ctx, i, child.params)
+	}
+	tabCtx, cancel := i.driver().NewContext(ctx)
+	defer cancel()
+	url := child.url
+	if url == "" {
+		url = pageURL
+	}
+	if err := i.driver().Run(tabCtx, navigateTo(url)); err != nil {
+		return "", err
+	}
+	return child.handler(tabCtx, i, child.params)
+}
+
+// resultValue returns the value a JSON result encodes, or the result as a
+// string when it is not JSON.
+func resultValue(result string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(result), &v); err != nil {
+		return result
+	}
+	return v
+}
+
+// describeFailures describes the failures of a parallel step, leaving out the
+// steps that were only cancelled unless nothing else failed.
+func describeFailures(failed map[string]error, ctxErr error) string {
+	ids := make([]string, 0, len(failed))
+	for id, err := range failed {
+		if ctxErr == nil && errors.Is(err, context.Canceled) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		for id := range failed {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, fmt.Sprintf("%s: %v", id, failed[id]))
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,103 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// permissionDescriptors maps the permission names accepted by the API to
+// the browser permission descriptors they control.
+var permissionDescriptors = map[string][]string{
+	"clipboard":     {"clipboard-read", "clipboard-write"},
+	"geolocation":   {"geolocation"},
+	"notifications": {"notifications"},
+	"camera":        {"camera"},
+	"microphone":    {"microphone"},
+}
+
+// ValidatePermissions checks that every permission name is known and every
+// setting is granted, denied or prompt.
+func ValidatePermissions(perms map[string]string) error {
+	for name, setting := range perms {
+		if _, ok := permissionDescriptors[name]; !ok {
+			return fmt.Errorf("unknown permission: %s", name)
+		}
+		switch browser.PermissionSetting(setting) {
+		case browser.PermissionSettingGranted, browser.PermissionSettingDenied, browser.PermissionSettingPrompt:
+		default:
+			return fmt.Errorf("invalid setting %q for permission %s", setting, name)
+		}
+	}
+	return nil
+}
+
+// applyPermissions sets the instance permissions for the origin of its URL.
+func (i *Instance) applyPermissions(ctx context.Context) error {
+	settings := i.Settings()
+	if len(settings.Permissions) == 0 {
+		return nil
+	}
+	u, err := url.Parse(settings.URL)
+	if err != nil {
+		return err
+	}
+	origin := u.Scheme + "://" + u.Host
+	return i.driver().Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		// Permissions are a Browser domain command, so they must be sent
+		// to the browser rather than to the page session.
+		bctx := cdp.WithExecutor(ctx, chromedp.FromContext(ctx).Browser)
+		for name, setting := range settings.Permissions {
+			for _, desc := range permissionDescriptors[name] {
+				err := browser.SetPermission(&browser.PermissionDescriptor{Name: desc}, browser.PermissionSetting(setting)).
+					WithOrigin(origin).
+					Do(bctx)
+				if err != nil {
+					return fmt.Errorf("failed to set %s permission: %w", name, err)
+				}
+			}
+		}
+		return nil
+	}))
+}
+
+func clipboardReadAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	var text string
+	err := i.driver().Run(ctx, chromedp.Evaluate(`navigator.clipboard.readText()`, &text, awaitPromise))
+	return text, err
+}
+
+func clipboardWriteAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	value, ok := params["value"].(string)
+	if !ok {
+		return "", errors.New("missing value parameter")
+	}
+	return "", i.driver().Run(ctx, chromedp.Evaluate(fmt.Sprintf("navigator.clipboard.writeText(%s)", jsString(value)), nil, awaitPromise))
+}
+
+func setGeolocationAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	lat, ok := params["latitude"].(float64)
+	if !ok {
+		return "", errors.New("missing latitude parameter")
+	}
+	lng, ok := params["longitude"].(float64)
+	if !ok {
+		return "", errors.New("missing longitude parameter")
+	}
+	accuracy, ok := params["accuracy"].(float64)
+	if !ok {
+		accuracy = 1
+	}
+	return "", i.driver().Run(ctx, emulation.SetGeolocationOverride().WithLatitude(lat).WithLongitude(lng).WithAccuracy(accuracy))
+}
+
+func awaitPromise(p *runtime.EvaluateParams) *runtime.EvaluateParams {
+	return p.WithAwaitPromise(true)
+}
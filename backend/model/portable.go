@@ -0,0 +1,107 @@
+package model
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/chromedp/chromedp"
+)
+
+// Portable actions navigate and act on elements the same way in every
+// driver. DevTools drivers run them as the chromedp actions they stand
+// for; a driver whose browser does not speak the DevTools protocol puts a
+// portableRunner in the contexts it returns from NewContext and runs them
+// itself. Steps use them rather than chromedp.Navigate, chromedp.Click and
+// the like, which only work over DevTools.
+type portableRunner interface {
+	navigate(ctx context.Context, url string) error
+	element(ctx context.Context, a elementAction) error
+}
+
+type portableRunnerKey struct{}
+
+func withPortableRunner(ctx context.Context, r portableRunner) context.Context {
+	return context.WithValue(ctx, portableRunnerKey{}, r)
+}
+
+func portableRunnerFrom(ctx context.Context) portableRunner {
+	r, _ := ctx.Value(portableRunnerKey{}).(portableRunner)
+	return r
+}
+
+// navigation loads a URL in the page and waits for it to load, as
+// chromedp.Navigate does.
+type navigation string
+
+func navigateTo(url string) chromedp.Action {
+	return navigation(url)
+}
+
+func (a navigation) Do(ctx context.Context) error {
+	if r := portableRunnerFrom(ctx); r != nil {
+		return r.navigate(ctx, string(a))
+	}
+	return chromedp.Navigate(string(a)).Do(ctx)
+}
+
+// Operations of an elementAction.
+const (
+	opWaitReady   = "waitReady"
+	opWaitVisible = "waitVisible"
+	opWaitEnabled = "waitEnabled"
+	opWaitStable  = "waitStable"
+	opClick       = "click"
+	opSendKeys    = "sendKeys"
+	opText        = "text"
+)
+
+// elementAction acts on the first element sel matches, waiting for it to
+// show up. sel is a CSS selector or an XPath expression, as chromedp
+// queries take them by default.
+type elementAction struct {
+	op    string
+	sel   string
+	value string
+	text  *string
+}
+
+func waitReady(sel string) chromedp.Action   { return elementAction{op: opWaitReady, sel: sel} }
+func waitVisible(sel string) chromedp.Action { return elementAction{op: opWaitVisible, sel: sel} }
+func waitEnabled(sel string) chromedp.Action { return elementAction{op: opWaitEnabled, sel: sel} }
+
+// waitStable waits until the element has stopped moving, its animations
+// or layout shifts having settled.
+func waitStable(sel string) chromedp.Action { return elementAction{op: opWaitStable, sel: sel} }
+
+func clickOn(sel string) chromedp.Action { return elementAction{op: opClick, sel: sel} }
+
+func typeInto(sel, value string) chromedp.Action {
+	return elementAction{op: opSendKeys, sel: sel, value: value}
+}
+
+func textOf(sel string, text *string) chromedp.Action {
+	return elementAction{op: opText, sel: sel, text: text}
+}
+
+func (a elementAction) Do(ctx context.Context) error {
+	if r := portableRunnerFrom(ctx); r != nil {
+		return r.element(ctx, a)
+	}
+	switch a.op {
+	case opWaitReady:
+		return chromedp.WaitReady(a.sel).Do(ctx)
+	case opWaitVisible:
+		return chromedp.WaitVisible(a.sel).Do(ctx)
+	case opWaitEnabled:
+		return chromedp.WaitEnabled(a.sel).Do(ctx)
+	case opWaitStable:
+		return pollStable(ctx, a.sel)
+	case opClick:
+		return chromedp.Click(a.sel).Do(ctx)
+	case opSendKeys:
+		return chromedp.SendKeys(a.sel, a.value).Do(ctx)
+	case opText:
+		return chromedp.Text(a.sel, a.text).Do(ctx)
+	}
+	return fmt.Errorf("unknown element action: %s", a.op)
+}
@@ -0,0 +1,223 @@
+package model
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// Proxy modes. In ProxyForward the browser itself goes through the proxy,
+// so it sees the traffic as it happens; in ProxyReplay the requests
+// captured are sent through it again once the job is over.
+const (
+	ProxyForward = "forward"
+	ProxyReplay  = "replay"
+)
+
+// DefaultRequestCaptureLimit bounds how many requests a capture keeps for
+// replay; later requests are dropped.
+const DefaultRequestCaptureLimit = 5000
+
+// maxReplayErrors bounds the errors a replay keeps.
+const maxReplayErrors = 20
+
+// ProxyConfig sends the traffic of a job to an intercepting proxy such as
+// Burp or ZAP, e.g. {"url": "http://127.0.0.1:8080", "mode": "forward"}.
+type ProxyConfig struct {
+	URL  string `json:"url"`
+	Mode string `json:"mode"`
+}
+
+// Validate checks the proxy URL and mode. An empty mode is ProxyForward.
+func (p *ProxyConfig) Validate() error {
+	u, err := url.Parse(p.URL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid proxy url %q", p.URL)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("invalid proxy url %q: scheme must be http, https or socks5", p.URL)
+	}
+	switch p.Mode {
+	case "":
+		p.Mode = ProxyForward
+	case ProxyForward, ProxyReplay:
+	default:
+		return fmt.Errorf("invalid proxy mode %q: must be forward or replay", p.Mode)
+	}
+	return nil
+}
+
+// Allocator returns a context that starts browsers going through the
+// proxy. Intercepting proxies sign certificates of their own, so the
+// browser does not check them.
+func (p *ProxyConfig) Allocator(ctx context.Context) (context.Context, context.CancelFunc) {
+	opts := append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.ProxyServer(p.URL),
+		// Chrome bypasses proxies for localhost unless told otherwise.
+		chromedp.Flag("proxy-bypass-list", "<-loopback>"),
+		chromedp.Flag("ignore-certificate-errors", true),
+	)
+	return chromedp.NewExecAllocator(ctx, opts...)
+}
+
+// ReplayResult is the outcome of replaying requests through a proxy.
+type ReplayResult struct {
+	Sent       int       `json:"sent"`
+	Failed     int       `json:"failed"`
+	Errors     []string  `json:"errors,omitempty"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// Replay sends reqs through the proxy one after the other, as the browser
+// sent them. The responses are left to the proxy; a request fails only
+// when it gets none.
+func (p *ProxyConfig) Replay(ctx context.Context, reqs []Request) *ReplayResult {
+	result := &ReplayResult{}
+	proxyURL, err := url.Parse(p.URL)
+	if err != nil {
+		result.Failed = len(reqs)
+		result.Errors = []string{err.Error()}
+		result.FinishedAt = time.Now()
+		return result
+	}
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		// Redirects were captured as requests of their own.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for i := range reqs {
+		if ctx.Err() != nil {
+			result.fail(ctx.Err())
+			continue
+		}
+		if err := replay(ctx, client, &reqs[i]); err != nil {
+			result.fail(fmt.Errorf("%s %s: %w", reqs[i].Method, reqs[i].URL.String(), err))
+			continue
+		}
+		result.Sent++
+	}
+	result.FinishedAt = time.Now()
+	return result
+}
+
+func (r *ReplayResult) fail(err error) {
+	r.Failed++
+	if len(r.Errors) < maxReplayErrors {
+		r.Errors = append(r.Errors, err.Error())
+	}
+}
+
+func replay(ctx context.Context, client *http.Client, req *Request) error {
+	var body io.Reader
+	if req.PostData != "" {
+		body = strings.NewReader(req.PostData)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, req.URL.String(), body)
+	if err != nil {
+		return err
+	}
+	for _, k := range req.headerNames() {
+		// The client sets these from the request itself.
+		if strings.EqualFold(k, "Host") || strings.EqualFold(k, "Content-Length") {
+			continue
+		}
+		httpReq.Header.Set(k, req.header(k))
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// DecodePostData joins the base64 entries of the body of a request seen
+// on the DevTools protocol.
+func DecodePostData(entries []*network.PostDataEntry) string {
+	var postData string
+	for _, entry := range entries {
+		if b, err := base64.StdEncoding.DecodeString(entry.Bytes); err == nil {
+			postData += string(b)
+		}
+	}
+	return postData
+}
+
+// RequestCapture records the requests a browser target sends, for replay.
+type RequestCapture struct {
+	mu    sync.Mutex
+	reqs  []Request
+	limit int
+}
+
+func NewRequestCapture(limit int) *RequestCapture {
+	return &RequestCapture{limit: limit}
+}
+
+// Listen starts recording the requests of the target behind ctx until ctx
+// is cancelled. Requests the browser does not send over the network, such
+// as data: URLs, are left out.
+func (c *RequestCapture) Listen(ctx context.Context) {
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		e, ok := ev.(*network.EventRequestWillBeSent)
+		if !ok || e.Request == nil {
+			return
+		}
+		if !strings.HasPrefix(e.Request.URL, "http://") && !strings.HasPrefix(e.Request.URL, "https://") {
+			return
+		}
+		u, err := GetUrl(e.Request.URL)
+		if err != nil {
+			return
+		}
+		req := GetRequest(e.Request.Method, u, Options{Headers: e.Request.Headers, PostData: DecodePostData(e.Request.PostDataEntries)})
+		c.mu.Lock()
+		if c.limit <= 0 || len(c.reqs) < c.limit {
+			c.reqs = append(c.reqs, req)
+		}
+		c.mu.Unlock()
+	})
+}
+
+// Requests returns a copy of the recorded requests.
+func (c *RequestCapture) Requests() []Request {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Request(nil), c.reqs...)
+}
+
+// CaptureRequests records the requests the browser of the instance sends
+// into c until ctx is done.
+func (i *Instance) CaptureRequests(ctx context.Context, c *RequestCapture) error {
+	return i.listen(ctx, c.Listen)
+}
+
+// listen has listen follow the network events of the browser of the
+// instance until ctx is done.
+func (i *Instance) listen(ctx context.Context, listen func(context.Context)) error {
+	_, chromeCtx := i.state()
+	if chromeCtx == nil {
+		return ErrInstanceNotRunning
+	}
+	listenCtx, cancel := context.WithCancel(chromeCtx)
+	context.AfterFunc(ctx, cancel)
+	listen(listenCtx)
+	return i.driver().Run(chromeCtx, network.Enable())
+}
@@ -0,0 +1,156 @@
+package model
+
+import (
+	"sync"
+	"time"
+
+	"auto/apperr"
+)
+
+// DefaultQueueTimeout is how long a flow waits for a busy instance by default.
+const DefaultQueueTimeout = 5 * time.Minute
+
+// ErrQueueTimeout is returned when an instance did not become free in time.
+var ErrQueueTimeout = apperr.New(apperr.CodeInstanceBusy, "timed out waiting for instance")
+
+// Priority classes of runs. Interactive runs, started by a user or from a
+// debugger, wait for an instance ahead of batch runs such as scheduled
+// ones.
+const (
+	PriorityInteractive = "interactive"
+	PriorityBatch       = "batch"
+)
+
+// runQueue hands an instance to one flow at a time, so the actions of
+// concurrent flows don't interleave on the same page. Interactive owners
+// go ahead of batch ones; within a class the queue keeps arrival order.
+// Its zero value is an empty queue.
+type runQueue struct {
+	mu      sync.Mutex
+	holder  string
+	waiting []*queueTicket
+}
+
+type queueTicket struct {
+	owner    string
+	priority string
+	ready    chan struct{}
+}
+
+// InstanceQueue describes who drives an instance and who waits for it.
+type InstanceQueue struct {
+	Holder  string   `json:"holder,omitempty"`
+	Waiting []string `json:"waiting"`
+}
+
+// acquire blocks until owner holds the queue or timeout expires, calling
+// onQueued with the position of owner if it has to wait. A timeout of zero
+// or less waits forever.
+func (q *runQueue) acquire(owner, priority string, timeout time.Duration, onQueued func(position int)) error {
+	q.mu.Lock()
+	if q.holder == "" && len(q.waiting) == 0 {
+		q.holder = owner
+		q.mu.Unlock()
+		return nil
+	}
+	t := &queueTicket{owner: owner, priority: priority, ready: make(chan struct{})}
+	at := len(q.waiting)
+	if priority == PriorityInteractive {
+		at = 0
+		for at < len(q.waiting) && q.waiting[at].priority == PriorityInteractive {
+			at++
+		}
+	}
+	q.waiting = append(q.waiting, nil)
+	copy(q.waiting[at+1:], q.waiting[at:])
+	q.waiting[at] = t
+	position := at + 1
+	q.mu.Unlock()
+	if onQueued != nil {
+		onQueued(position)
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-t.ready:
+		return nil
+	case <-expired:
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, w := range q.waiting {
+		if w == t {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			return ErrQueueTimeout
+		}
+	}
+	// The instance was handed over while the timer fired.
+	return nil
+}
+
+// release passes the queue on to the next waiting owner.
+func (q *runQueue) release(owner string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.holder != owner {
+		return
+	}
+	q.holder = ""
+	if len(q.waiting) > 0 {
+		next := q.waiting[0]
+		q.waiting = q.waiting[1:]
+		q.holder = next.owner
+		close(next.ready)
+	}
+}
+
+func (q *runQueue) snapshot() InstanceQueue {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := InstanceQueue{Holder: q.holder, Waiting: make([]string, len(q.waiting))}
+	for i, w := range q.waiting {
+		s.Waiting[i] = w.owner
+	}
+	return s
+}
+
+// Position returns how many owners are ahead of owner: 0 while it holds
+// the instance, -1 if it is not queued at all.
+func (s InstanceQueue) Position(owner string) int {
+	if s.Holder == owner {
+		return 0
+	}
+	for i, w := range s.Waiting {
+		if w == owner {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// AcquireInstance waits until owner, typically a run ID, may drive the
+// instance, queueing by priority and calling onQueued with its position if
+// it has to wait. The returned function hands the instance to the next
+// owner.
+func (im *InstanceManager) AcquireInstance(instance *Instance, owner, priority string, onQueued func(position int)) (func(), error) {
+	q := &instance.queue
+	if err := q.acquire(owner, priority, im.queueTimeout, onQueued); err != nil {
+		return nil, err
+	}
+	return func() { q.release(owner) }, nil
+}
+
+// GetInstanceQueue reports which run drives an instance and which wait
+func (im *InstanceManager) GetInstanceQueue(id string) (InstanceQueue, error) {
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return InstanceQueue{}, err
+	}
+	return instance.queue.snapshot(), nil
+}
@@ -0,0 +1,134 @@
+package model
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// profileGrace keeps a fresh temporary profile safe while its browser is
+// still starting up.
+const profileGrace = 10 * time.Minute
+
+type procInfo struct {
+	pid     int
+	ppid    int
+	state   byte
+	comm    string
+	cmdline []string
+}
+
+// reapChromeProcesses cleans up after browsers that died or outlived their
+// server: it waits for zombie Chrome children of this process, kills
+// orphaned Chrome browsers started by chromedp, and removes chromedp
+// temporary profiles no running Chrome uses. live holds the PIDs of the
+// browsers of running instances.
+func reapChromeProcesses(live map[int]bool) (reaped, killed, profiles int) {
+	procs := listProcs()
+	self := os.Getpid()
+	inUse := make(map[string]bool)
+
+	for _, p := range procs {
+		if !isChrome(p.comm) {
+			continue
+		}
+		dataDir := userDataDir(p.cmdline)
+		switch {
+		case p.state == 'Z':
+			if p.ppid == self {
+				var status syscall.WaitStatus
+				if pid, err := syscall.Wait4(p.pid, &status, syscall.WNOHANG, nil); err == nil && pid == p.pid {
+					reaped++
+				}
+			}
+		case live[p.pid]:
+			inUse[dataDir] = true
+		case p.ppid == 1 && self != 1 && strings.Contains(dataDir, "chromedp-runner"):
+			// A chromedp browser whose server went away. When running as
+			// PID 1 orphans can't be told apart from our own browsers.
+			if err := syscall.Kill(p.pid, syscall.SIGKILL); err == nil {
+				killed++
+			}
+		default:
+			inUse[dataDir] = true
+		}
+	}
+
+	dirs, _ := filepath.Glob(filepath.Join(os.TempDir(), "chromedp-runner*"))
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() || inUse[dir] || time.Since(info.ModTime()) < profileGrace {
+			continue
+		}
+		if os.RemoveAll(dir) == nil {
+			profiles++
+		}
+	}
+	return reaped, killed, profiles
+}
+
+func isChrome(comm string) bool {
+	comm = strings.ToLower(comm)
+	return strings.Contains(comm, "chrome") || strings.Contains(comm, "chromium") || comm == "headless_shell"
+}
+
+func userDataDir(cmdline []string) string {
+	for _, arg := range cmdline {
+		if strings.HasPrefix(arg, "--user-data-dir=") {
+			return filepath.Clean(strings.TrimPrefix(arg, "--user-data-dir="))
+		}
+	}
+	return ""
+}
+
+// listProcs reads the processes of the host from /proc.
+func listProcs() []procInfo {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	var procs []procInfo
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := os.ReadFile(filepath.Join("/proc", e.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// The command name is in parentheses and may itself contain
+		// spaces or parentheses, so split at the last ")".
+		open, end := bytes.IndexByte(stat, '('), bytes.LastIndexByte(stat, ')')
+		if open < 0 || end < open {
+			continue
+		}
+		fields := strings.Fields(string(stat[end+1:]))
+		if len(fields) < 2 {
+			continue
+		}
+		ppid, _ := strconv.Atoi(fields[1])
+		p := procInfo{pid: pid, ppid: ppid, state: fields[0][0], comm: string(stat[open+1 : end])}
+		if cmdline, err := os.ReadFile(filepath.Join("/proc", e.Name(), "cmdline")); err == nil {
+			p.cmdline = strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+		}
+		procs = append(procs, p)
+	}
+	return procs
+}
+
+// ChromeProfileInUse reports whether a running Chrome uses dir as its
+// profile.
+func ChromeProfileInUse(dir string) bool {
+	dir = filepath.Clean(dir)
+	for _, p := range listProcs() {
+		if isChrome(p.comm) && p.state != 'Z' && userDataDir(p.cmdline) == dir {
+			return true
+		}
+	}
+	return false
+}
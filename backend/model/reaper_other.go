@@ -0,0 +1,15 @@
+//go:build !linux
+
+package model
+
+// reapChromeProcesses needs /proc to find Chrome processes; elsewhere
+// chromedp's own cleanup has to do.
+func reapChromeProcesses(live map[int]bool) (reaped, killed, profiles int) {
+	return 0, 0, 0
+}
+
+// ChromeProfileInUse can't tell which profiles are in use without /proc,
+// so it treats them all as used.
+func ChromeProfileInUse(dir string) bool {
+	return true
+}
@@ -0,0 +1,186 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"go.uber.org/zap"
+)
+
+// Fingerprint describes an element as it looked when a step was recorded,
+// so it can be located heuristically once its selectors stop matching.
+type Fingerprint struct {
+	Tag        string            `json:"tag"`
+	Text       string            `json:"text"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// probeInterval is the delay between rounds of candidate selector probes.
+const probeInterval = 250 * time.Millisecond
+
+// healAttr marks the element picked by fingerprint matching so the
+// remaining actions can address it through a plain CSS selector.
+const healAttr = "data-umba-heal"
+
+const fingerprintJS = `(function(sel) {
+	const el = document.querySelector(sel);
+	if (!el) { return null; }
+	const attrs = {};
+	for (const a of el.attributes) { attrs[a.name] = a.value; }
+	return {tag: el.tagName.toLowerCase(), text: (el.innerText || '').trim().slice(0, 200), attributes: attrs};
+})(%s)`
+
+const healJS = `(function(fp, marker) {
+	let best = null, bestScore = 0;
+	for (const el of document.getElementsByTagName(fp.tag || '*')) {
+		let score = 0;
+		const text = (el.innerText || '').trim();
+		if (fp.text && text === fp.text) { score += 3; } else if (fp.text && text.includes(fp.text)) { score += 1; }
+		for (const [k, v] of Object.entries(fp.attributes || {})) {
+			if (el.getAttribute(k) === v) { score += (k === 'id' || k === 'name') ? 3 : 1; }
+		}
+		if (score > bestScore) { best = el; bestScore = score; }
+	}
+	if (!best) { return false; }
+	best.setAttribute('` + healAttr + `', marker);
+	return true;
+})(%s, %s)`
+
+// candidateSelectors returns the "selector" parameter followed by any
+// fallbacks listed in "selectors", in the order they should be tried.
+func candidateSelectors(params map[string]interface{}) []string {
+	var sels []string
+	if sel, ok := params["selector"].(string); ok && sel != "" {
+		sels = append(sels, sel)
+	}
+	if list, ok := params["selectors"].([]interface{}); ok {
+		for _, v := range list {
+			if sel, ok := v.(string); ok && sel != "" {
+				sels = append(sels, sel)
+			}
+		}
+	}
+	return sels
+}
+
+func fingerprintFromParams(params map[string]interface{}) (*Fingerprint, error) {
+	raw, ok := params["fingerprint"]
+	if !ok {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var fp Fingerprint
+	if err := json.Unmarshal(data, &fp); err != nil {
+		return nil, fmt.Errorf("invalid fingerprint parameter: %w", err)
+	}
+	return &fp, nil
+}
+
+// resolveSelector picks the selector a step should act on. A step with a
+// "role", and optionally a "name", targets the element with that
+// accessibility role and name. A single selector without a fingerprint is
+// returned untouched so auto-waiting behaves as before; otherwise every
+// candidate is probed in turn, falling back to fingerprint matching, until
+// one matches or the timeout expires.
+func (i *Instance) resolveSelector(ctx context.Context, params map[string]interface{}, timeout time.Duration) (string, error) {
+	if role, _ := params["role"].(string); role != "" {
+		name, _ := params["name"].(string)
+		return i.resolveRole(ctx, role, name, timeout)
+	}
+	sels := candidateSelectors(params)
+	fp, err := fingerprintFromParams(params)
+	if err != nil {
+		return "", err
+	}
+	if len(sels) == 0 && fp == nil {
+		return "", errors.New("missing selector parameter")
+	}
+	if len(sels) == 1 && fp == nil {
+		return sels[0], nil
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	for {
+		for n, sel := range sels {
+			var found bool
+			if err := i.driver().Run(ctx, chromedp.Evaluate(fmt.Sprintf("document.querySelector(%s) !== null", jsString(sel)), &found)); err != nil {
+				return "", err
+			}
+			if found {
+				if n > 0 {
+					i.im.logger.Info("Primary selector failed, used fallback",
+						zap.String("id", i.ID), zap.String("selector", sels[0]), zap.String("fallback", sel))
+				}
+				return sel, nil
+			}
+		}
+		if fp != nil {
+			if sel, ok, err := i.healSelector(ctx, fp); err != nil {
+				return "", err
+			} else if ok {
+				i.im.logger.Info("All selectors failed, located element by fingerprint",
+					zap.String("id", i.ID), zap.Strings("selectors", sels), zap.String("tag", fp.Tag))
+				return sel, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("no candidate selector matched %v: %w", sels, ctx.Err())
+		case <-time.After(probeInterval):
+		}
+	}
+}
+
+func (i *Instance) healSelector(ctx context.Context, fp *Fingerprint) (string, bool, error) {
+	fpJSON, err := json.Marshal(fp)
+	if err != nil {
+		return "", false, err
+	}
+	marker := GenerateID()
+	var ok bool
+	if err := i.driver().Run(ctx, chromedp.Evaluate(fmt.Sprintf(healJS, fpJSON, jsString(marker)), &ok)); err != nil {
+		return "", false, err
+	}
+	return fmt.Sprintf("[%s=%q]", healAttr, marker), ok, nil
+}
+
+// fingerprintAction captures the fingerprint of the element matching the
+// step selector so recorders can store it alongside the step.
+func fingerprintAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	sel, w, err := i.targetFor(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	var fp *Fingerprint
+	w.Enabled, w.Stable = false, false
+	if err := i.runWithAutoWait(ctx, w, sel, chromedp.Evaluate(fmt.Sprintf(fingerprintJS, jsString(sel)), &fp)); err != nil {
+		return "", err
+	}
+	if fp == nil {
+		return "", fmt.Errorf("element not found: %s", sel)
+	}
+	data, err := json.Marshal(fp)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// jsString encodes s as a JavaScript string literal.
+func jsString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
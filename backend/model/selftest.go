@@ -0,0 +1,139 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"auto/apperr"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/chromedp"
+)
+
+// BrowserVersion is the version of a browser and of the DevTools protocol
+// it speaks.
+type BrowserVersion struct {
+	Product   string `json:"product"`
+	Protocol  string `json:"protocol"`
+	Revision  string `json:"revision"`
+	UserAgent string `json:"user_agent"`
+	JSVersion string `json:"js_version"`
+}
+
+// SelfTestResult describes the browser a self-test launched.
+type SelfTestResult struct {
+	BrowserVersion
+	ScreenshotBytes int   `json:"screenshot_bytes"`
+	DurationMs      int64 `json:"duration_ms"`
+}
+
+// localBrowser is the version of the browser instances launch, known once
+// one has been asked for it.
+var localBrowser struct {
+	sync.Mutex
+	version *BrowserVersion
+}
+
+// LocalBrowserVersion returns the version of the Chrome instances launch
+// by default. The first call launches a disposable one to ask it, later
+// calls return what it said.
+func LocalBrowserVersion(ctx context.Context) (*BrowserVersion, error) {
+	localBrowser.Lock()
+	defer localBrowser.Unlock()
+	if localBrowser.version != nil {
+		return localBrowser.version, nil
+	}
+	chrome := &DefaultChromeDPContext{}
+	browserCtx, cancel := chrome.NewContext(ctx)
+	defer cancel()
+	var version BrowserVersion
+	if err := chrome.Run(browserCtx, getBrowserVersion(&version)); err != nil {
+		return nil, &SelfTestError{Stage: "launch Chrome", Hint: launchHint(err), Err: err}
+	}
+	localBrowser.version = &version
+	return &version, nil
+}
+
+func getBrowserVersion(v *BrowserVersion) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		v.Protocol, v.Product, v.Revision, v.UserAgent, v.JSVersion, err = browser.GetVersion().Do(ctx)
+		return err
+	})
+}
+
+// SelfTestError tells at which stage a self-test failed and what to look
+// at on the host.
+type SelfTestError struct {
+	Stage string
+	Hint  string
+	Err   error
+}
+
+func (e *SelfTestError) Error() string {
+	return fmt.Sprintf("browser self-test failed to %s: %v; %s", e.Stage, e.Err, e.Hint)
+}
+
+func (e *SelfTestError) Unwrap() error {
+	return e.Err
+}
+
+func (e *SelfTestError) ErrorCode() apperr.Code {
+	return apperr.CodeChromeCrashed
+}
+
+func (e *SelfTestError) ErrorDetails() map[string]interface{} {
+	return map[string]interface{}{"stage": e.Stage, "hint": e.Hint}
+}
+
+// SelfTest launches a disposable headless Chrome the way instances launch
+// theirs, opens about:blank and takes a screenshot, so a host that cannot
+// run browsers is found out before the first flow. The browser is closed
+// when it returns.
+func SelfTest(ctx context.Context) (*SelfTestResult, error) {
+	started := time.Now()
+	chrome := &DefaultChromeDPContext{}
+	browserCtx, cancel := chrome.NewContext(ctx)
+	defer cancel()
+
+	var result SelfTestResult
+	if err := chrome.Run(browserCtx, getBrowserVersion(&result.BrowserVersion)); err != nil {
+		return nil, &SelfTestError{Stage: "launch Chrome", Hint: launchHint(err), Err: err}
+	}
+	if err := chrome.Run(browserCtx, chromedp.Navigate("about:blank")); err != nil {
+		return nil, &SelfTestError{Stage: "open about:blank", Hint: "the browser started but cannot load pages; check its logs and the memory of the host", Err: err}
+	}
+	var buf []byte
+	if err := chrome.Run(browserCtx, chromedp.CaptureScreenshot(&buf)); err != nil {
+		return nil, &SelfTestError{Stage: "take a screenshot", Hint: "the browser cannot render; check that /dev/shm is large enough (--shm-size in Docker)", Err: err}
+	}
+	result.ScreenshotBytes = len(buf)
+	result.DurationMs = time.Since(started).Milliseconds()
+
+	version := result.BrowserVersion
+	localBrowser.Lock()
+	localBrowser.version = &version
+	localBrowser.Unlock()
+	return &result, nil
+}
+
+// launchHint guesses the cause of a browser that did not start.
+func launchHint(err error) string {
+	msg := err.Error()
+	switch {
+	case errors.Is(err, exec.ErrNotFound) || strings.Contains(msg, "executable file not found"):
+		return "Chrome or Chromium is not installed or not on the PATH"
+	case strings.Contains(msg, "sandbox"):
+		return "Chrome cannot set up its sandbox; run as a user other than root or give the container the capabilities it needs"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "Chrome did not answer in time; the host may be short of CPU or memory"
+	case strings.Contains(msg, "error while loading shared libraries"):
+		return "Chrome is missing shared libraries; install the dependencies of its package"
+	}
+	return "check that Chrome runs headless on this host"
+}
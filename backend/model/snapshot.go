@@ -0,0 +1,217 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"auto/apperr"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"github.com/go-redis/redis/v8"
+)
+
+func init() {
+	actionHandlers["restoreSnapshot"] = restoreSnapshotAction
+}
+
+// snapshotsKey is the Redis hash of the snapshots, by name.
+const snapshotsKey = "instance_snapshots"
+
+var snapshotName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Snapshot is the state of the browser of an instance at some point: its
+// cookies, the Web Storage of the page it showed and the URL of that page.
+// Restoring it puts any instance back in that state, such as logged in
+// with an empty cart, between test flows.
+type Snapshot struct {
+	Name       string       `json:"name"`
+	InstanceID string       `json:"instance_id"`
+	URL        string       `json:"url"`
+	Cookies    []Cookie     `json:"cookies"`
+	Storage    *StorageDump `json:"storage"`
+	CreatedAt  time.Time    `json:"created_at"`
+}
+
+// SnapshotInfo describes a snapshot without its cookies and storage.
+type SnapshotInfo struct {
+	Name         string    `json:"name"`
+	InstanceID   string    `json:"instance_id"`
+	URL          string    `json:"url"`
+	Cookies      int       `json:"cookies"`
+	StorageItems int       `json:"storage_items"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ErrSnapshotNotFound is returned for a snapshot that was never taken or
+// was deleted.
+var ErrSnapshotNotFound = apperr.New(apperr.CodeNotFound, "snapshot not found")
+
+// TakeSnapshot stores the state of a running instance under name,
+// replacing the snapshot of that name if there is one.
+func (im *InstanceManager) TakeSnapshot(ctx context.Context, id, name string) (*Snapshot, error) {
+	if !snapshotName.MatchString(name) {
+		return nil, apperr.New(apperr.CodeInvalidRequest, fmt.Sprintf("invalid snapshot name %q: use letters, digits, '_', '.' and '-'", name))
+	}
+	instance, err := im.runningInstance(id)
+	if err != nil {
+		return nil, err
+	}
+	cookies, err := im.ExportCookies(id, "")
+	if err != nil {
+		return nil, err
+	}
+	storage, err := instance.DumpStorage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	browserCtx, cancel, err := instance.browserContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	var url string
+	if err := instance.driver().Run(browserCtx, chromedp.Location(&url)); err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{
+		Name:       name,
+		InstanceID: id,
+		URL:        url,
+		Cookies:    cookies,
+		Storage:    storage,
+		CreatedAt:  time.Now(),
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if err := im.rdb.HSet(ctx, snapshotsKey, name, data).Err(); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// GetSnapshot returns the snapshot stored under name.
+func (im *InstanceManager) GetSnapshot(ctx context.Context, name string) (*Snapshot, error) {
+	data, err := im.rdb.HGet(ctx, snapshotsKey, name).Bytes()
+	if err == redis.Nil {
+		return nil, ErrSnapshotNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// ListSnapshots describes the stored snapshots, by name.
+func (im *InstanceManager) ListSnapshots(ctx context.Context) ([]SnapshotInfo, error) {
+	all, err := im.rdb.HGetAll(ctx, snapshotsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]SnapshotInfo, 0, len(all))
+	for _, data := range all {
+		var s Snapshot
+		if err := json.Unmarshal([]byte(data), &s); err != nil {
+			continue
+		}
+		info := SnapshotInfo{Name: s.Name, InstanceID: s.InstanceID, URL: s.URL, Cookies: len(s.Cookies), CreatedAt: s.CreatedAt}
+		if s.Storage != nil {
+			info.StorageItems = len(s.Storage.LocalStorage) + len(s.Storage.SessionStorage)
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// DeleteSnapshot removes the snapshot stored under name.
+func (im *InstanceManager) DeleteSnapshot(ctx context.Context, name string) error {
+	n, err := im.rdb.HDel(ctx, snapshotsKey, name).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSnapshotNotFound
+	}
+	return nil
+}
+
+// RestoreSnapshot puts a running instance in the state of the snapshot
+// stored under name: its cookies replace those of the browser, its storage
+// that of its page, which the instance shows once done.
+func (im *InstanceManager) RestoreSnapshot(ctx context.Context, id, name string) error {
+	instance, err := im.runningInstance(id)
+	if err != nil {
+		return err
+	}
+	browserCtx, cancel, err := instance.browserContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	return instance.restoreSnapshot(browserCtx, name)
+}
+
+func (i *Instance) restoreSnapshot(ctx context.Context, name string) error {
+	snapshot, err := i.im.GetSnapshot(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err := validateCookies(snapshot.Cookies); err != nil {
+		return err
+	}
+	actions := []chromedp.Action{network.ClearBrowserCookies(), setCookies(snapshot.Cookies)}
+	if s := snapshot.Storage; s != nil && s.Origin != "" && s.Origin != "null" {
+		// Storage belongs to an origin, so it is set from a page of it;
+		// the page then loads again to read it.
+		state, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		actions = append(actions,
+			navigateTo(snapshot.URL),
+			chromedp.Evaluate(fmt.Sprintf(storageRestoreJS, state), nil),
+		)
+	}
+	if snapshot.URL != "" {
+		actions = append(actions, navigateTo(snapshot.URL))
+	}
+	if err := i.driver().Run(ctx, actions...); err != nil {
+		return fmt.Errorf("restore snapshot %s: %w", name, err)
+	}
+	return nil
+}
+
+// storageRestoreJS replaces the Web Storage of the page with a StorageDump.
+const storageRestoreJS = `(function(dump) {
+	const restore = (s, items) => { s.clear(); for (const k in items || {}) { s.setItem(k, items[k]); } };
+	restore(localStorage, dump.local_storage);
+	restore(sessionStorage, dump.session_storage);
+})(%s)`
+
+// restoreSnapshotAction restores the snapshot "name" in the instance the
+// flow runs on.
+func restoreSnapshotAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	name, err := stringParam(params, "name")
+	if err != nil {
+		return "", err
+	}
+	if i.im == nil {
+		return "", errors.New("instance has no snapshots")
+	}
+	if err := i.restoreSnapshot(ctx, name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
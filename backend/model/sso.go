@@ -0,0 +1,210 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/chromedp"
+	"go.uber.org/zap"
+)
+
+// SSO providers with preset identity provider selectors. Others need every
+// selector set.
+const (
+	SSOGoogle    = "google"
+	SSOMicrosoft = "microsoft"
+)
+
+// SSOLogin logs in through a single sign-on identity provider instead of a
+// form of the application itself. StartSelector is clicked on the login
+// page to leave for the provider, if the application does not redirect by
+// itself. Once the browser is on IdPHost (or a subdomain), the provider
+// form is filled in; providers asking for the username and password on
+// separate pages have a UsernameSubmitSelector. A consent screen, one with
+// an element matching ConsentSelector, is accepted by clicking it. The
+// login ends when the browser is back on the host of the instance URL.
+type SSOLogin struct {
+	Provider               string `json:"provider,omitempty"`
+	StartSelector          string `json:"start_selector,omitempty"`
+	IdPHost                string `json:"idp_host"`
+	UsernameSelector       string `json:"username_selector,omitempty"`
+	UsernameSubmitSelector string `json:"username_submit_selector,omitempty"`
+	PasswordSelector       string `json:"password_selector,omitempty"`
+	SubmitSelector         string `json:"submit_selector,omitempty"`
+	ConsentSelector        string `json:"consent_selector,omitempty"`
+}
+
+// ssoPresets are the forms of the providers known by name.
+var ssoPresets = map[string]SSOLogin{
+	SSOGoogle: {
+		IdPHost:                "accounts.google.com",
+		UsernameSelector:       `input[type="email"]`,
+		UsernameSubmitSelector: "#identifierNext",
+		PasswordSelector:       `input[type="password"]`,
+		SubmitSelector:         "#passwordNext",
+		ConsentSelector:        "#submit_approve_access",
+	},
+	SSOMicrosoft: {
+		IdPHost:                "login.microsoftonline.com",
+		UsernameSelector:       `input[name="loginfmt"]`,
+		UsernameSubmitSelector: "#idSIButton9",
+		PasswordSelector:       `input[name="passwd"]`,
+		SubmitSelector:         "#idSIButton9",
+		ConsentSelector:        "#idBtn_Back",
+	},
+}
+
+// withPreset fills the selectors left empty from the preset of the provider.
+func (s SSOLogin) withPreset() SSOLogin {
+	preset, ok := ssoPresets[s.Provider]
+	if !ok {
+		return s
+	}
+	setDefault(&s.IdPHost, preset.IdPHost)
+	setDefault(&s.UsernameSelector, preset.UsernameSelector)
+	setDefault(&s.UsernameSubmitSelector, preset.UsernameSubmitSelector)
+	setDefault(&s.PasswordSelector, preset.PasswordSelector)
+	setDefault(&s.SubmitSelector, preset.SubmitSelector)
+	setDefault(&s.ConsentSelector, preset.ConsentSelector)
+	return s
+}
+
+func (s SSOLogin) validate() error {
+	if s.Provider != "" {
+		if _, ok := ssoPresets[s.Provider]; !ok {
+			return fmt.Errorf("unknown SSO provider: %s", s.Provider)
+		}
+	}
+	s = s.withPreset()
+	if s.IdPHost == "" {
+		return errors.New("SSO login needs idp_host")
+	}
+	if s.UsernameSelector == "" || s.PasswordSelector == "" || s.SubmitSelector == "" {
+		return errors.New("SSO login needs username, password and submit selectors")
+	}
+	return nil
+}
+
+// ssoTasks leave the login page for the identity provider, log in there and
+// wait to be sent back to the application.
+func (p *LoginProfile) ssoTasks(instance *Instance, username, password string) chromedp.Tasks {
+	s := p.SSO.withPreset()
+	var tasks chromedp.Tasks
+	if s.StartSelector != "" {
+		tasks = append(tasks,
+			waitVisible(s.StartSelector),
+			clickOn(s.StartSelector),
+		)
+	}
+	tasks = append(tasks,
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			return waitForHost(ctx, s.IdPHost, "the identity provider")
+		}),
+		waitVisible(s.UsernameSelector),
+		typeInto(s.UsernameSelector, username),
+	)
+	if s.UsernameSubmitSelector != "" {
+		tasks = append(tasks, clickOn(s.UsernameSubmitSelector))
+	}
+	tasks = append(tasks,
+		waitVisible(s.PasswordSelector),
+		typeInto(s.PasswordSelector, password),
+		clickOn(s.SubmitSelector),
+	)
+	tasks = append(tasks, p.twoFactorTasks(s.SubmitSelector)...)
+	tasks = append(tasks, chromedp.ActionFunc(func(ctx context.Context) error {
+		return returnFromIdP(ctx, instance, s.ConsentSelector)
+	}))
+	return tasks
+}
+
+// waitForHost waits until the page is on host or one of its subdomains,
+// following the redirects that take it there.
+func waitForHost(ctx context.Context, host, what string) error {
+	deadline := time.Now().Add(DefaultLoginTimeout)
+	for {
+		var location string
+		if err := chromedp.Location(&location).Do(ctx); err != nil {
+			return err
+		}
+		if onHost(location, host) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not redirected to %s (%s), at %s", what, host, location)
+		}
+		if err := sleepCtx(ctx, 500*time.Millisecond); err != nil {
+			return err
+		}
+	}
+}
+
+// returnFromIdP waits for the identity provider to send the browser back to
+// the URL of instance, accepting a consent screen if one is shown on the way.
+func returnFromIdP(ctx context.Context, instance *Instance, consentSelector string) error {
+	app, err := url.Parse(instance.Settings().URL)
+	if err != nil {
+		return err
+	}
+	consented := false
+	deadline := time.Now().Add(DefaultLoginTimeout)
+	for {
+		var location string
+		if err := chromedp.Location(&location).Do(ctx); err != nil {
+			return err
+		}
+		if onHost(location, app.Hostname()) {
+			return nil
+		}
+		if consentSelector != "" && !consented {
+			var nodes []*cdp.Node
+			if err := chromedp.Nodes(consentSelector, &nodes, chromedp.AtLeast(0)).Do(ctx); err != nil {
+				return err
+			}
+			if len(nodes) > 0 {
+				instance.im.logger.Info("Accepting SSO consent screen", zap.String("id", instance.ID))
+				if err := chromedp.Click(consentSelector).Do(ctx); err != nil {
+					return fmt.Errorf("accept consent screen: %w", err)
+				}
+				consented = true
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("identity provider did not return to %s, at %s", app.Hostname(), location)
+		}
+		if err := sleepCtx(ctx, 500*time.Millisecond); err != nil {
+			return err
+		}
+	}
+}
+
+// onHost reports whether rawURL is on host or one of its subdomains.
+func onHost(rawURL, host string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || host == "" {
+		return false
+	}
+	hostname := strings.ToLower(u.Hostname())
+	host = strings.ToLower(host)
+	return hostname == host || strings.HasSuffix(hostname, "."+host)
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+func setDefault(s *string, value string) {
+	if *s == "" {
+		*s = value
+	}
+}
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"go.uber.org/zap"
+)
+
+// refillStandby starts stopped members of a group until Standby of them
+// are running without a flow, so flows picking the group do not wait for
+// Chrome to start. except is a member that was just picked and is about
+// to become busy. Members that fail to start are skipped. Members still
+// starting count towards Standby, but are not picked until logged in.
+func (im *InstanceManager) refillStandby(group *InstanceGroup, except string) {
+	if group.Standby <= 0 {
+		return
+	}
+	im.standbyMu.Lock()
+	defer im.standbyMu.Unlock()
+
+	ready, starting := 0, 0
+	var stopped []*Instance
+	for _, id := range group.InstanceIDs {
+		instance, err := im.GetInstance(id)
+		if err != nil || id == except {
+			continue
+		}
+		switch {
+		case instance.Booting():
+			starting++
+		case instance.Healthy():
+			if instance.ActiveRuns() == 0 {
+				ready++
+			}
+		case instance.GetStatus() != "On":
+			stopped = append(stopped, instance)
+		}
+	}
+	for _, instance := range stopped {
+		if ready+starting >= group.Standby {
+			break
+		}
+		if err := im.StartInstance(instance.ID); err != nil {
+			im.logger.Warn("Failed to start standby instance", zap.String("group", group.Name), zap.String("id", instance.ID), zap.Error(err))
+			continue
+		}
+		starting++
+	}
+}
+
+// refillAllStandby refills the standby pools of every group.
+func (im *InstanceManager) refillAllStandby() {
+	groups, err := im.GetGroups()
+	if err != nil {
+		im.logger.Warn("Failed to load instance groups", zap.Error(err))
+		return
+	}
+	for _, group := range groups {
+		im.refillStandby(group, "")
+	}
+}
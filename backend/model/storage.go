@@ -0,0 +1,104 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/chromedp/chromedp"
+)
+
+// StorageDump holds the Web Storage contents of the current page origin.
+type StorageDump struct {
+	Origin         string            `json:"origin"`
+	LocalStorage   map[string]string `json:"local_storage"`
+	SessionStorage map[string]string `json:"session_storage"`
+}
+
+const storageDumpJS = `(function() {
+	const dump = s => { const out = {}; for (let i = 0; i < s.length; i++) { const k = s.key(i); out[k] = s.getItem(k); } return out; };
+	return {origin: location.origin, local_storage: dump(localStorage), session_storage: dump(sessionStorage)};
+})()`
+
+// storageObject maps the "storage" step parameter to the JS global.
+func storageObject(params map[string]interface{}) (string, error) {
+	switch params["storage"] {
+	case nil, "", "local":
+		return "localStorage", nil
+	case "session":
+		return "sessionStorage", nil
+	default:
+		return "", fmt.Errorf("unknown storage: %v", params["storage"])
+	}
+}
+
+func storageGetAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	storage, err := storageObject(params)
+	if err != nil {
+		return "", err
+	}
+	key, err := stringParam(params, "key")
+	if err != nil {
+		return "", err
+	}
+	var value *string
+	if err := i.driver().Run(ctx, chromedp.Evaluate(fmt.Sprintf("%s.getItem(%s)", storage, jsString(key)), &value)); err != nil {
+		return "", err
+	}
+	if value == nil {
+		return "", nil
+	}
+	return *value, nil
+}
+
+func storageSetAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	storage, err := storageObject(params)
+	if err != nil {
+		return "", err
+	}
+	key, err := stringParam(params, "key")
+	if err != nil {
+		return "", err
+	}
+	value, ok := params["value"].(string)
+	if !ok {
+		return "", errors.New("missing value parameter")
+	}
+	js := fmt.Sprintf("%s.setItem(%s, %s)", storage, jsString(key), jsString(value))
+	return "", i.driver().Run(ctx, chromedp.Evaluate(js, nil))
+}
+
+func storageRemoveAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	storage, err := storageObject(params)
+	if err != nil {
+		return "", err
+	}
+	key, err := stringParam(params, "key")
+	if err != nil {
+		return "", err
+	}
+	return "", i.driver().Run(ctx, chromedp.Evaluate(fmt.Sprintf("%s.removeItem(%s)", storage, jsString(key)), nil))
+}
+
+func storageClearAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	storage, err := storageObject(params)
+	if err != nil {
+		return "", err
+	}
+	return "", i.driver().Run(ctx, chromedp.Evaluate(storage+".clear()", nil))
+}
+
+// DumpStorage returns the localStorage and sessionStorage of the page the
+// instance is currently showing, giving up when ctx is done.
+func (i *Instance) DumpStorage(ctx context.Context) (*StorageDump, error) {
+	browserCtx, cancel, err := i.browserContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	var dump StorageDump
+	if err := i.driver().Run(browserCtx, chromedp.Evaluate(storageDumpJS, &dump)); err != nil {
+		return nil, err
+	}
+	return &dump, nil
+}
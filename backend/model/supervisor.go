@@ -0,0 +1,124 @@
+package model
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/chromedp/cdproto/inspector"
+	"github.com/chromedp/chromedp"
+	"go.uber.org/zap"
+)
+
+// StatusCrashed is the status of an instance whose browser died without
+// being stopped.
+const StatusCrashed = "Crashed"
+
+// DefaultSuperviseInterval is how often Supervise looks for dead browsers
+// and stray Chrome processes.
+const DefaultSuperviseInterval = 30 * time.Second
+
+// OnCrash registers fn to be called when the browser of an instance exits
+// unexpectedly.
+func (im *InstanceManager) OnCrash(fn func(instance *Instance, reason string)) {
+	im.crashMu.Lock()
+	im.crashListeners = append(im.crashListeners, fn)
+	im.crashMu.Unlock()
+}
+
+// supervise watches the browser started for ctx. A crashed page target
+// brings the whole browser down so the instance can be restarted cleanly;
+// any end of ctx that StopInstance did not ask for is reported as a crash.
+func (i *Instance) supervise(ctx context.Context, cancel context.CancelFunc) {
+	var reason atomic.Value
+	reason.Store("browser exited unexpectedly")
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		if _, ok := ev.(*inspector.EventTargetCrashed); ok {
+			reason.Store("page crashed")
+			cancel()
+		}
+	})
+
+	go func() {
+		<-ctx.Done()
+		if atomic.LoadInt32(&i.stopping) == 1 {
+			return
+		}
+		i.markCrashed(reason.Load().(string))
+	}()
+}
+
+// markCrashed releases the contexts of a dead browser, which also makes
+// chromedp delete its temporary profile, and records the failure.
+func (i *Instance) markCrashed(reason string) {
+	i.stateMu.Lock()
+	if i.Status != "On" {
+		i.stateMu.Unlock()
+		return
+	}
+	i.Status = StatusCrashed
+	cancel, chromeCancel := i.Cancel, i.ChromeCancel
+	i.stateMu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	if chromeCancel != nil {
+		chromeCancel()
+	}
+	i.im.logger.Error("Instance browser crashed", zap.String("id", i.ID), zap.String("reason", reason))
+
+	i.im.saveInstance(i)
+
+	i.im.crashMu.Lock()
+	listeners := append([]func(*Instance, string){}, i.im.crashListeners...)
+	i.im.crashMu.Unlock()
+	for _, fn := range listeners {
+		fn(i, reason)
+	}
+}
+
+// Supervise periodically marks instances whose browser is gone as crashed,
+// reaps Chrome processes no instance owns and refills the standby pools of
+// instance groups, until ctx is done.
+func (im *InstanceManager) Supervise(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				im.superviseOnce()
+				im.refillAllStandby()
+			}
+		}
+	}()
+}
+
+func (im *InstanceManager) superviseOnce() {
+	live := make(map[int]bool)
+	for _, instance := range im.GetInstances() {
+		chromeCtx := instance.liveContext()
+		if chromeCtx == nil {
+			continue
+		}
+		if chromeCtx.Err() != nil && atomic.LoadInt32(&instance.stopping) == 0 {
+			instance.markCrashed("browser exited unexpectedly")
+			continue
+		}
+		if c := chromedp.FromContext(chromeCtx); c != nil && c.Browser != nil {
+			if p := c.Browser.Process(); p != nil {
+				live[p.Pid] = true
+			}
+		}
+	}
+
+	reaped, killed, profiles := reapChromeProcesses(live)
+	if reaped > 0 || killed > 0 || profiles > 0 {
+		im.logger.Info("Cleaned up Chrome leftovers",
+			zap.Int("zombiesReaped", reaped),
+			zap.Int("orphansKilled", killed),
+			zap.Int("profilesRemoved", profiles))
+	}
+}
@@ -0,0 +1,110 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/chromedp/chromedp"
+)
+
+// tableJS lays an HTML table out on a grid, repeating cells that span
+// rows or columns into every slot they cover, and turns the rows below the
+// header rows into objects keyed by header text. Header rows are those of
+// the thead, or else the first row; stacked header texts are joined with
+// " / ". Blank and duplicate headers get positional names.
+const tableJS = `(function(sel) {
+	const table = document.querySelector(sel);
+	if (!table || !table.rows) { return null; }
+	const rows = Array.from(table.rows);
+	const grid = rows.map(() => []);
+	rows.forEach((tr, r) => {
+		let c = 0;
+		for (const cell of tr.cells) {
+			while (grid[r][c] !== undefined) { c++; }
+			const text = (cell.innerText || '').trim();
+			const rs = Math.max(1, cell.rowSpan || 1), cs = Math.max(1, cell.colSpan || 1);
+			for (let i = 0; i < rs && r + i < rows.length; i++) {
+				for (let j = 0; j < cs; j++) { grid[r + i][c + j] = text; }
+			}
+			c += cs;
+		}
+	});
+	const headerRows = table.tHead && table.tHead.rows.length ? table.tHead.rows.length : 1;
+	const width = Math.max(0, ...grid.map(row => row.length));
+	const seen = {};
+	const keys = [];
+	for (let c = 0; c < width; c++) {
+		const parts = [];
+		for (let r = 0; r < headerRows && r < grid.length; r++) {
+			const t = grid[r][c] || '';
+			if (t && parts[parts.length - 1] !== t) { parts.push(t); }
+		}
+		let key = parts.join(' / ') || 'column' + (c + 1);
+		if (seen[key]) { key = key + '_' + (++seen[key]); } else { seen[key] = 1; }
+		keys.push(key);
+	}
+	return grid.slice(headerRows).map(row => {
+		const obj = {};
+		keys.forEach((k, c) => { obj[k] = row[c] === undefined ? '' : row[c]; });
+		return obj;
+	});
+})(%s)`
+
+// cardsJS reads repeated elements: every match of the row selector becomes
+// an object with the text of each column selector found within it.
+const cardsJS = `(function(rowSel, columns) {
+	return Array.from(document.querySelectorAll(rowSel)).map(row => {
+		const obj = {};
+		for (const [key, sel] of Object.entries(columns)) {
+			const el = row.querySelector(sel);
+			obj[key] = el ? (el.innerText || '').trim() : '';
+		}
+		return obj;
+	});
+})(%s, %s)`
+
+// extractTableAction returns the rows of the table matching "selector" as
+// a JSON array of objects keyed by column header. Listings built from
+// repeated elements instead of a table are read with "rowSelector" and
+// "columns", which maps each key to a selector within the row. Table keys
+// keep the column order of the page.
+func extractTableAction(ctx context.Context, i *Instance, params map[string]interface{}) (string, error) {
+	var script string
+	if rowSel, ok := params["rowSelector"].(string); ok && rowSel != "" {
+		columns, ok := params["columns"].(map[string]interface{})
+		if !ok || len(columns) == 0 {
+			return "", errors.New("missing columns parameter")
+		}
+		for key, sel := range columns {
+			if s, ok := sel.(string); !ok || s == "" {
+				return "", fmt.Errorf("invalid selector for column %s", key)
+			}
+		}
+		data, err := json.Marshal(columns)
+		if err != nil {
+			return "", err
+		}
+		script = fmt.Sprintf(cardsJS, jsString(rowSel), data)
+	} else {
+		sel, w, err := i.targetFor(ctx, params)
+		if err != nil {
+			return "", err
+		}
+		w.Enabled, w.Stable = false, false
+		if err := i.runWithAutoWait(ctx, w, sel, chromedp.Tasks{}); err != nil {
+			return "", err
+		}
+		script = fmt.Sprintf(tableJS, jsString(sel))
+	}
+
+	var raw []byte
+	if err := i.driver().Run(ctx, chromedp.Evaluate(script, &raw)); err != nil {
+		return "", err
+	}
+	if string(raw) == "null" {
+		return "", errors.New("element is not a table")
+	}
+	return string(raw), nil
+}
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+var tagName = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,64}$`)
+
+// validateTags checks that tags are names that fit in a query parameter.
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if !tagName.MatchString(tag) {
+			return fmt.Errorf("invalid tag %q: use up to 64 letters, digits, '_', '.', ':' and '-'", tag)
+		}
+	}
+	return nil
+}
+
+// SetInstanceTags replaces the tags of an instance.
+func (im *InstanceManager) SetInstanceTags(id string, tags []string) error {
+	// Not nil, so that no tags clears them.
+	return im.UpdateInstanceTags(id, nil, nil, append([]string{}, tags...))
+}
+
+// UpdateInstanceTags adds the tags of add to an instance and removes those
+// of remove, or replaces them all with set when it is not nil. The tags are
+// kept sorted, without duplicates.
+func (im *InstanceManager) UpdateInstanceTags(id string, add, remove, set []string) error {
+	for _, tags := range [][]string{add, remove, set} {
+		if err := validateTags(tags); err != nil {
+			return err
+		}
+	}
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return err
+	}
+
+	// Held across the update, so that concurrent updates do not lose each
+	// other's tags.
+	instance.stateMu.Lock()
+	current := instance.Tags
+	if set != nil {
+		current = set
+	}
+	keep := make(map[string]bool, len(current)+len(add))
+	for _, tag := range current {
+		keep[tag] = true
+	}
+	for _, tag := range add {
+		keep[tag] = true
+	}
+	for _, tag := range remove {
+		delete(keep, tag)
+	}
+	tags := make([]string, 0, len(keep))
+	for tag := range keep {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	instance.Tags = tags
+	instance.stateMu.Unlock()
+
+	im.saveInstance(instance)
+
+	return nil
+}
+
+// HasTag reports whether the instance is tagged with tag.
+func (i *Instance) HasTag(tag string) bool {
+	for _, t := range i.Settings().Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"auto/apperr"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrInstanceTemplateNotFound is returned for an unknown template ID.
+var ErrInstanceTemplateNotFound = apperr.New(apperr.CodeNotFound, "instance template not found")
+
+// InstanceTemplate holds the settings a fleet of instances shares, so that
+// creating one of them takes only what differs. Its URL may hold
+// "{{NAME}}" placeholders, filled in from the vars given on creation:
+//
+//	https://{{tenant}}.example.com/login
+//
+// Instances copy the settings of their template when they are created;
+// editing the template later leaves them as they are.
+type InstanceTemplate struct {
+	ID                 string            `json:"id"`
+	Name               string            `json:"name"`
+	URL                string            `json:"url"`
+	LoginProfileID     string            `json:"login_profile_id,omitempty"`
+	Tags               []string          `json:"tags,omitempty"`
+	Headers            HeaderOverrides   `json:"headers"`
+	Device             string            `json:"device,omitempty"`
+	Permissions        map[string]string `json:"permissions,omitempty"`
+	Driver             DriverConfig      `json:"driver"`
+	IdleTimeoutMinutes int               `json:"idle_timeout_minutes,omitempty"`
+	UpdatedAt          time.Time         `json:"updated_at"`
+}
+
+var templateVar = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+// Vars returns the names of the placeholders of the URL, in order.
+func (t *InstanceTemplate) Vars() []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, m := range templateVar.FindAllStringSubmatch(t.URL, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// URLFor returns the URL of the template with its placeholders filled in
+// from vars, all of which must be given.
+func (t *InstanceTemplate) URLFor(vars map[string]string) (string, error) {
+	var missing []string
+	for _, name := range t.Vars() {
+		if _, ok := vars[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", apperr.New(apperr.CodeInvalidRequest, fmt.Sprintf("template %s needs vars %s", t.Name, strings.Join(missing, ", ")))
+	}
+	return templateVar.ReplaceAllStringFunc(t.URL, func(placeholder string) string {
+		return vars[templateVar.FindStringSubmatch(placeholder)[1]]
+	}), nil
+}
+
+func (t *InstanceTemplate) validate(im *InstanceManager) error {
+	if t.Name == "" {
+		return errors.New("template name is required")
+	}
+	if t.URL == "" {
+		return errors.New("template url is required")
+	}
+	if strings.Count(t.URL, "{{") != len(templateVar.FindAllString(t.URL, -1)) {
+		return fmt.Errorf("template url %q has a malformed placeholder: use {{NAME}}", t.URL)
+	}
+	if t.IdleTimeoutMinutes < 0 {
+		return errors.New("idle timeout must not be negative")
+	}
+	if t.LoginProfileID != "" {
+		if _, err := im.getLoginProfile(t.LoginProfileID); err != nil {
+			return err
+		}
+	}
+	if err := validateTags(t.Tags); err != nil {
+		return err
+	}
+	if err := ValidateHeaderOverrides(t.Headers); err != nil {
+		return err
+	}
+	if t.Device != "" {
+		if _, err := devicePreset(t.Device); err != nil {
+			return err
+		}
+	}
+	if err := ValidatePermissions(t.Permissions); err != nil {
+		return err
+	}
+	if _, err := newDriver(t.Driver); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (im *InstanceManager) saveInstanceTemplate(t *InstanceTemplate) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return im.rdb.HSet(context.Background(), "instance_templates", t.ID, data).Err()
+}
+
+// CreateInstanceTemplate stores a new instance template.
+func (im *InstanceManager) CreateInstanceTemplate(t InstanceTemplate) (*InstanceTemplate, error) {
+	if err := t.validate(im); err != nil {
+		return nil, err
+	}
+	t.ID = GenerateID()
+	t.UpdatedAt = time.Now().UTC()
+	if err := im.saveInstanceTemplate(&t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// UpdateInstanceTemplate replaces an instance template; the instances
+// created from it keep the settings they were created with.
+func (im *InstanceManager) UpdateInstanceTemplate(id string, t InstanceTemplate) (*InstanceTemplate, error) {
+	if _, err := im.GetInstanceTemplate(id); err != nil {
+		return nil, err
+	}
+	if err := t.validate(im); err != nil {
+		return nil, err
+	}
+	t.ID = id
+	t.UpdatedAt = time.Now().UTC()
+	if err := im.saveInstanceTemplate(&t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetInstanceTemplate retrieves an instance template by ID.
+func (im *InstanceManager) GetInstanceTemplate(id string) (*InstanceTemplate, error) {
+	data, err := im.rdb.HGet(context.Background(), "instance_templates", id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrInstanceTemplateNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var t InstanceTemplate
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetInstanceTemplates retrieves all instance templates, by name.
+func (im *InstanceManager) GetInstanceTemplates() ([]*InstanceTemplate, error) {
+	all, err := im.rdb.HGetAll(context.Background(), "instance_templates").Result()
+	if err != nil {
+		return nil, err
+	}
+	templates := make([]*InstanceTemplate, 0, len(all))
+	for _, data := range all {
+		var t InstanceTemplate
+		if err := json.Unmarshal([]byte(data), &t); err != nil {
+			continue
+		}
+		templates = append(templates, &t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+
+// DeleteInstanceTemplate removes an instance template. The instances
+// created from it are left as they are.
+func (im *InstanceManager) DeleteInstanceTemplate(id string) error {
+	n, err := im.rdb.HDel(context.Background(), "instance_templates", id).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInstanceTemplateNotFound
+	}
+	return nil
+}
+
+// ApplyInstanceTemplate gives an instance the browser settings of a
+// template: its headers, device, permissions and driver. The URL, login
+// profile, tags and idle timeout are set on creation like those of any
+// instance.
+func (im *InstanceManager) ApplyInstanceTemplate(id string, t *InstanceTemplate) error {
+	instance, err := im.GetInstance(id)
+	if err != nil {
+		return err
+	}
+	instance.stateMu.Lock()
+	instance.TemplateID = t.ID
+	instance.Headers = t.Headers
+	instance.Device = t.Device
+	instance.Permissions = t.Permissions
+	instance.Driver = t.Driver
+	instance.stateMu.Unlock()
+
+	im.saveInstance(instance)
+
+	return nil
+}
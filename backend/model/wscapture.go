@@ -0,0 +1,103 @@
+package model
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// DefaultWebSocketFrameLimit bounds how many frames a capture keeps; the
+// oldest frames are dropped first.
+const DefaultWebSocketFrameLimit = 2000
+
+// WebSocketFrame is a message exchanged over a WebSocket opened by the page.
+type WebSocketFrame struct {
+	URL       string    `json:"url"`
+	Direction string    `json:"direction"`
+	Opcode    int       `json:"opcode"`
+	Payload   string    `json:"payload"`
+	Time      time.Time `json:"time"`
+}
+
+// WebSocketCapture records WebSocket frames seen on a browser target.
+type WebSocketCapture struct {
+	mu     sync.Mutex
+	urls   map[network.RequestID]string
+	frames []WebSocketFrame
+	limit  int
+}
+
+func NewWebSocketCapture(limit int) *WebSocketCapture {
+	return &WebSocketCapture{
+		urls:  make(map[network.RequestID]string),
+		limit: limit,
+	}
+}
+
+// Listen starts recording frames for the target behind ctx.
+func (c *WebSocketCapture) Listen(ctx context.Context) {
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch e := ev.(type) {
+		case *network.EventWebSocketCreated:
+			c.mu.Lock()
+			c.urls[e.RequestID] = e.URL
+			c.mu.Unlock()
+		case *network.EventWebSocketFrameSent:
+			c.add(e.RequestID, "sent", e.Response)
+		case *network.EventWebSocketFrameReceived:
+			c.add(e.RequestID, "received", e.Response)
+		case *network.EventWebSocketClosed:
+			c.mu.Lock()
+			delete(c.urls, e.RequestID)
+			c.mu.Unlock()
+		}
+	})
+}
+
+func (c *WebSocketCapture) add(id network.RequestID, direction string, frame *network.WebSocketFrame) {
+	if frame == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.frames = append(c.frames, WebSocketFrame{
+		URL:       c.urls[id],
+		Direction: direction,
+		Opcode:    int(frame.Opcode),
+		Payload:   frame.PayloadData,
+		Time:      time.Now(),
+	})
+	if c.limit > 0 && len(c.frames) > c.limit {
+		c.frames = c.frames[len(c.frames)-c.limit:]
+	}
+}
+
+// Frames returns a copy of the recorded frames.
+func (c *WebSocketCapture) Frames() []WebSocketFrame {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]WebSocketFrame(nil), c.frames...)
+}
+
+// FilterWebSocketFrames keeps the frames whose socket URL matches pattern.
+// An empty pattern keeps every frame.
+func FilterWebSocketFrames(frames []WebSocketFrame, pattern string) ([]WebSocketFrame, error) {
+	if pattern == "" {
+		return frames, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	filtered := make([]WebSocketFrame, 0, len(frames))
+	for _, f := range frames {
+		if re.MatchString(f.URL) {
+			filtered = append(filtered, f)
+		}
+	}
+	return filtered, nil
+}
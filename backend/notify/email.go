@@ -0,0 +1,167 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPConfig holds the mail server used by email channels.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+type emailNotifier struct {
+	cfg SMTPConfig
+	to  []string
+}
+
+// NewEmailFactory returns the factory for "email" channels, which send to
+// the channel's To addresses through the given server.
+func NewEmailFactory(cfg SMTPConfig) Factory {
+	return func(ch Channel) (Notifier, error) {
+		if len(ch.To) == 0 {
+			return nil, errors.New("email channel has no recipients")
+		}
+		return &emailNotifier{cfg: cfg, to: ch.To}, nil
+	}
+}
+
+func (e *emailNotifier) Notify(ctx context.Context, n Notification) error {
+	msg, err := buildEmail(e.cfg.From, e.to, n)
+	if err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- e.send(msg)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *emailNotifier) send(msg []byte) error {
+	addr := net.JoinHostPort(e.cfg.Host, strconv.Itoa(e.cfg.Port))
+	var auth smtp.Auth
+	if e.cfg.Username != "" {
+		auth = smtp.PlainAuth("", e.cfg.Username, e.cfg.Password, e.cfg.Host)
+	}
+	if e.cfg.Port != 465 {
+		// SendMail upgrades to TLS with STARTTLS when the server offers it.
+		return smtp.SendMail(addr, auth, e.cfg.From, e.to, msg)
+	}
+
+	// Port 465 expects TLS from the first byte.
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 15 * time.Second}, "tcp", addr, &tls.Config{ServerName: e.cfg.Host})
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, e.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(e.cfg.From); err != nil {
+		return err
+	}
+	for _, to := range e.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// buildEmail renders n as a MIME message, attaching the screenshot when
+// there is one.
+func buildEmail(from string, to []string, n Notification) ([]byte, error) {
+	if from == "" {
+		return nil, errors.New("SMTP_FROM is not configured")
+	}
+	var body strings.Builder
+	body.WriteString(n.Message)
+	body.WriteString("\r\n")
+	if n.FlowID != "" {
+		fmt.Fprintf(&body, "\r\nFlow: %s", n.FlowID)
+	}
+	if n.RunID != "" {
+		fmt.Fprintf(&body, "\r\nRun: %s", n.RunID)
+	}
+	if n.InstanceID != "" {
+		fmt.Fprintf(&body, "\r\nInstance: %s", n.InstanceID)
+	}
+	if n.Link != "" {
+		fmt.Fprintf(&body, "\r\n\r\n%s", n.Link)
+	}
+	body.WriteString("\r\n")
+
+	const boundary = "umba-notification-boundary"
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.Title))
+	fmt.Fprintf(&buf, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", boundary)
+
+	fmt.Fprintf(&buf, "--%s\r\n", boundary)
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+	writeBase64Lines(&buf, []byte(body.String()))
+
+	if len(n.Screenshot) > 0 {
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		buf.WriteString("Content-Type: image/png\r\n")
+		buf.WriteString("Content-Transfer-Encoding: base64\r\n")
+		buf.WriteString("Content-Disposition: attachment; filename=\"screenshot.png\"\r\n\r\n")
+		writeBase64Lines(&buf, n.Screenshot)
+	}
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes(), nil
+}
+
+// writeBase64Lines writes data base64 encoded in 76 character lines as
+// required by RFC 2045.
+func writeBase64Lines(buf *bytes.Buffer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76])
+		buf.WriteString("\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded)
+	buf.WriteString("\r\n")
+}
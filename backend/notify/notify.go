@@ -0,0 +1,123 @@
+// Package notify delivers notifications about flows, runs and alerts to
+// external channels such as webhooks or connected WebSocket clients.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notification is a message sent to one or more channels.
+type Notification struct {
+	Title      string    `json:"title"`
+	Message    string    `json:"message"`
+	Severity   string    `json:"severity"`
+	FlowID     string    `json:"flow_id,omitempty"`
+	RunID      string    `json:"run_id,omitempty"`
+	InstanceID string    `json:"instance_id,omitempty"`
+	Link       string    `json:"link,omitempty"`
+	Screenshot []byte    `json:"-"`
+	Time       time.Time `json:"time"`
+
+	// Labels are those of the run, for the receiver to correlate it.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Channel selects a notifier and carries its settings. Which fields are
+// used depends on Type.
+type Channel struct {
+	Type    string            `json:"type"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	To      []string          `json:"to,omitempty"`
+	ChatID  string            `json:"chat_id,omitempty"`
+	Target  string            `json:"target,omitempty"`
+}
+
+// Notifier sends a notification to a single channel.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// NotifierFunc adapts a function to the Notifier interface.
+type NotifierFunc func(ctx context.Context, n Notification) error
+
+func (f NotifierFunc) Notify(ctx context.Context, n Notification) error {
+	return f(ctx, n)
+}
+
+// Factory builds the notifier for a channel.
+type Factory func(ch Channel) (Notifier, error)
+
+// Dispatcher sends notifications to channels through the notifier
+// registered for their type.
+type Dispatcher struct {
+	mu        sync.RWMutex
+	factories map[string]Factory
+	baseURL   string
+}
+
+// NewDispatcher creates a dispatcher with the webhook channel registered.
+// baseURL, when set, is used to link notifications to their run.
+func NewDispatcher(baseURL string) *Dispatcher {
+	d := &Dispatcher{
+		factories: make(map[string]Factory),
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+	}
+	d.Register("webhook", newWebhookNotifier)
+	return d
+}
+
+// Register makes a channel type available.
+func (d *Dispatcher) Register(channelType string, factory Factory) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.factories[channelType] = factory
+}
+
+// Validate checks that every channel has a registered type and valid
+// settings.
+func (d *Dispatcher) Validate(channels []Channel) error {
+	for _, ch := range channels {
+		if _, err := d.notifier(ch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) notifier(ch Channel) (Notifier, error) {
+	d.mu.RLock()
+	factory, ok := d.factories[ch.Type]
+	d.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown notification channel: %s", ch.Type)
+	}
+	return factory(ch)
+}
+
+// Send delivers n to every channel, returning the joined errors of the
+// channels that failed.
+func (d *Dispatcher) Send(ctx context.Context, channels []Channel, n Notification) error {
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
+	if n.Link == "" && n.RunID != "" && d.baseURL != "" {
+		n.Link = d.baseURL + "/api/v1/runs/" + n.RunID
+	}
+	var errs []error
+	for _, ch := range channels {
+		notifier, err := d.notifier(ch)
+		if err == nil {
+			err = notifier.Notify(ctx, n)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ch.Type, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,184 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const slackAPI = "https://slack.com/api/"
+
+type slackNotifier struct {
+	webhookURL string
+	token      string
+	channel    string
+}
+
+// NewSlackFactory returns the factory for "slack" channels. A channel
+// either posts to an incoming webhook given as URL, or, with a bot token
+// configured, to the channel ID given as Target; only the bot can attach the
+// screenshot.
+func NewSlackFactory(botToken string) Factory {
+	return func(ch Channel) (Notifier, error) {
+		switch {
+		case ch.URL != "":
+			return &slackNotifier{webhookURL: ch.URL, token: botToken, channel: ch.Target}, nil
+		case ch.Target != "" && botToken != "":
+			return &slackNotifier{token: botToken, channel: ch.Target}, nil
+		case ch.Target != "":
+			return nil, errors.New("slack channel target requires SLACK_BOT_TOKEN")
+		default:
+			return nil, errors.New("slack channel needs a webhook url or a target channel")
+		}
+	}
+}
+
+func (s *slackNotifier) Notify(ctx context.Context, n Notification) error {
+	payload := map[string]interface{}{
+		"text":   n.Title + ": " + n.Message,
+		"blocks": slackBlocks(n),
+	}
+	if s.webhookURL != "" {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if err := postJSON(ctx, s.webhookURL, nil, body); err != nil {
+			return err
+		}
+	} else {
+		payload["channel"] = s.channel
+		if err := s.call(ctx, "chat.postMessage", payload); err != nil {
+			return err
+		}
+	}
+	if len(n.Screenshot) > 0 && s.token != "" && s.channel != "" {
+		return s.uploadScreenshot(ctx, n)
+	}
+	return nil
+}
+
+func slackBlocks(n Notification) []interface{} {
+	text := func(t string) map[string]interface{} {
+		return map[string]interface{}{"type": "mrkdwn", "text": t}
+	}
+	blocks := []interface{}{
+		map[string]interface{}{
+			"type": "header",
+			"text": map[string]interface{}{"type": "plain_text", "text": n.Title},
+		},
+		map[string]interface{}{"type": "section", "text": text(n.Message)},
+	}
+	var fields []interface{}
+	if n.FlowID != "" {
+		fields = append(fields, text("*Flow*\n"+n.FlowID))
+	}
+	if n.RunID != "" {
+		fields = append(fields, text("*Run*\n"+n.RunID))
+	}
+	if n.InstanceID != "" {
+		fields = append(fields, text("*Instance*\n"+n.InstanceID))
+	}
+	if len(fields) > 0 {
+		blocks = append(blocks, map[string]interface{}{"type": "section", "fields": fields})
+	}
+	if n.Link != "" {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "actions",
+			"elements": []interface{}{map[string]interface{}{
+				"type": "button",
+				"text": map[string]interface{}{"type": "plain_text", "text": "View run"},
+				"url":  n.Link,
+			}},
+		})
+	}
+	return blocks
+}
+
+// uploadScreenshot shares the screenshot in the channel using the external
+// upload flow: reserve an upload URL, send the bytes, then complete it.
+func (s *slackNotifier) uploadScreenshot(ctx context.Context, n Notification) error {
+	form := url.Values{
+		"filename": {"screenshot.png"},
+		"length":   {strconv.Itoa(len(n.Screenshot))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, slackAPI+"files.getUploadURLExternal", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var reserved struct {
+		UploadURL string `json:"upload_url"`
+		FileID    string `json:"file_id"`
+	}
+	if err := s.do(req, &reserved); err != nil {
+		return err
+	}
+
+	up, err := http.NewRequestWithContext(ctx, http.MethodPost, reserved.UploadURL, bytes.NewReader(n.Screenshot))
+	if err != nil {
+		return err
+	}
+	up.Header.Set("Content-Type", "image/png")
+	resp, err := httpClient.Do(up)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("slack upload returned status %d", resp.StatusCode)
+	}
+
+	return s.call(ctx, "files.completeUploadExternal", map[string]interface{}{
+		"files":      []interface{}{map[string]string{"id": reserved.FileID, "title": "Screenshot"}},
+		"channel_id": s.channel,
+	})
+}
+
+func (s *slackNotifier) call(ctx context.Context, method string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, slackAPI+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	return s.do(req, nil)
+}
+
+// do sends a Web API request and decodes the response into out, turning
+// "ok": false into an error.
+func (s *slackNotifier) do(req *http.Request, out interface{}) error {
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return err
+	}
+	var status struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &status); err != nil {
+		return err
+	}
+	if !status.OK {
+		return fmt.Errorf("slack api error: %s", status.Error)
+	}
+	if out != nil {
+		return json.Unmarshal(raw, out)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+const telegramAPI = "https://api.telegram.org/bot"
+
+// Telegram caps photo captions at 1024 characters.
+const telegramCaptionLimit = 1024
+
+type telegramNotifier struct {
+	token  string
+	chatID string
+}
+
+// NewTelegramFactory returns the factory for "telegram" channels, which
+// message the chat given as ChatID through the configured bot.
+func NewTelegramFactory(botToken string) Factory {
+	return func(ch Channel) (Notifier, error) {
+		if botToken == "" {
+			return nil, errors.New("telegram channel requires TELEGRAM_BOT_TOKEN")
+		}
+		if ch.ChatID == "" {
+			return nil, errors.New("telegram channel is missing a chat_id")
+		}
+		return &telegramNotifier{token: botToken, chatID: ch.ChatID}, nil
+	}
+}
+
+func (t *telegramNotifier) Notify(ctx context.Context, n Notification) error {
+	text := telegramText(n)
+	if len(n.Screenshot) > 0 && len(text) <= telegramCaptionLimit {
+		return t.sendPhoto(ctx, text, n.Screenshot)
+	}
+	if err := t.sendMessage(ctx, text); err != nil {
+		return err
+	}
+	if len(n.Screenshot) > 0 {
+		return t.sendPhoto(ctx, "", n.Screenshot)
+	}
+	return nil
+}
+
+func telegramText(n Notification) string {
+	var b strings.Builder
+	b.WriteString(n.Title)
+	b.WriteString("\n\n")
+	b.WriteString(n.Message)
+	if n.FlowID != "" {
+		fmt.Fprintf(&b, "\nFlow: %s", n.FlowID)
+	}
+	if n.RunID != "" {
+		fmt.Fprintf(&b, "\nRun: %s", n.RunID)
+	}
+	if n.InstanceID != "" {
+		fmt.Fprintf(&b, "\nInstance: %s", n.InstanceID)
+	}
+	if n.Link != "" {
+		fmt.Fprintf(&b, "\n%s", n.Link)
+	}
+	return b.String()
+}
+
+func (t *telegramNotifier) sendMessage(ctx context.Context, text string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"chat_id": t.chatID,
+		"text":    text,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, telegramAPI+t.token+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return t.do(req)
+}
+
+func (t *telegramNotifier) sendPhoto(ctx context.Context, caption string, photo []byte) error {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("chat_id", t.chatID)
+	if caption != "" {
+		w.WriteField("caption", caption)
+	}
+	part, err := w.CreateFormFile("photo", "screenshot.png")
+	if err != nil {
+		return err
+	}
+	part.Write(photo)
+	if err := w.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, telegramAPI+t.token+"/sendPhoto", &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return t.do(req)
+}
+
+func (t *telegramNotifier) do(req *http.Request) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var status struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return err
+	}
+	if !status.OK {
+		return fmt.Errorf("telegram api error: %s", status.Description)
+	}
+	return nil
+}
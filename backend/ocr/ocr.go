@@ -0,0 +1,131 @@
+// Package ocr recognises the text in images, for pages that render their
+// data in canvases or images. Recognition runs the tesseract command line
+// tool or posts the image to an external service.
+package ocr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Engine names in Config.
+const (
+	EngineTesseract = "tesseract"
+	EngineHTTP      = "http"
+)
+
+// DefaultLanguage is the language text is recognised in when none is given.
+const DefaultLanguage = "eng"
+
+// Engine recognises the text in a PNG image.
+type Engine interface {
+	Recognize(ctx context.Context, img []byte, lang string) (string, error)
+}
+
+// Config selects an engine. TesseractPath is the tesseract binary, found
+// on the PATH by default; URL and APIKey are those of the HTTP service.
+type Config struct {
+	Engine        string
+	TesseractPath string
+	URL           string
+	APIKey        string
+}
+
+// New returns the engine cfg selects, or nil when it selects none.
+func New(cfg Config) (Engine, error) {
+	switch cfg.Engine {
+	case "":
+		return nil, nil
+	case EngineTesseract:
+		path := cfg.TesseractPath
+		if path == "" {
+			path = "tesseract"
+		}
+		if _, err := exec.LookPath(path); err != nil {
+			return nil, fmt.Errorf("tesseract not found: %w", err)
+		}
+		return &Tesseract{Path: path}, nil
+	case EngineHTTP:
+		if cfg.URL == "" {
+			return nil, errors.New("OCR service URL is required")
+		}
+		return &HTTPService{URL: cfg.URL, APIKey: cfg.APIKey, Client: &http.Client{Timeout: 60 * time.Second}}, nil
+	default:
+		return nil, fmt.Errorf("unknown OCR engine: %s", cfg.Engine)
+	}
+}
+
+// Tesseract runs the tesseract command line tool.
+type Tesseract struct {
+	Path string
+}
+
+func (t *Tesseract) Recognize(ctx context.Context, img []byte, lang string) (string, error) {
+	if lang == "" {
+		lang = DefaultLanguage
+	}
+	cmd := exec.CommandContext(ctx, t.Path, "stdin", "stdout", "-l", lang)
+	cmd.Stdin = bytes.NewReader(img)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("tesseract failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// HTTPService posts the image as image/png to URL, with the language in
+// the "lang" query parameter, and expects a JSON object with the text
+// under "text" back.
+type HTTPService struct {
+	URL    string
+	APIKey string
+	Client *http.Client
+}
+
+func (s *HTTPService) Recognize(ctx context.Context, img []byte, lang string) (string, error) {
+	if lang == "" {
+		lang = DefaultLanguage
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("lang", lang)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(img))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "image/png")
+	if s.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("OCR service returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid OCR service response: %w", err)
+	}
+	return strings.TrimSpace(result.Text), nil
+}
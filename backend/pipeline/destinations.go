@@ -0,0 +1,270 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type destinationFunc func(ctx context.Context, e *Executor, d Destination, p Pipeline, run RunInfo, records []Record) error
+
+var destinations = map[string]destinationFunc{
+	"redis_stream": toRedisStream,
+	"webhook":      toWebhook,
+	"csv":          toCSVArtifact,
+	"s3":           toS3,
+}
+
+func toRedisStream(ctx context.Context, e *Executor, d Destination, p Pipeline, run RunInfo, records []Record) error {
+	if d.Stream == "" {
+		return fmt.Errorf("missing stream")
+	}
+	for _, r := range records {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		err = e.db.XAdd(ctx, &redis.XAddArgs{
+			Stream: d.Stream,
+			MaxLen: d.MaxLen,
+			Approx: d.MaxLen > 0,
+			Values: map[string]interface{}{
+				"run_id":   run.RunID,
+				"flow_id":  run.FlowID,
+				"pipeline": p.Name,
+				"data":     string(data),
+			},
+		}).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func toWebhook(ctx context.Context, e *Executor, d Destination, p Pipeline, run RunInfo, records []Record) error {
+	data := map[string]interface{}{
+		"run_id":   run.RunID,
+		"flow_id":  run.FlowID,
+		"labels":   run.Labels,
+		"pipeline": p.Name,
+		"records":  records,
+	}
+	if !d.Each {
+		return e.sendWebhook(ctx, d, data)
+	}
+	for i, r := range records {
+		data["record"] = r
+		if err := e.sendWebhook(ctx, d, data); err != nil {
+			return fmt.Errorf("record %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// sendWebhook sends the payload of a webhook for data: its template
+// rendered, or else data as JSON.
+func (e *Executor) sendWebhook(ctx context.Context, d Destination, data map[string]interface{}) error {
+	var body []byte
+	if d.Template != "" {
+		payload, err := e.render(ctx, "template", d.Template, data)
+		if err != nil {
+			return fmt.Errorf("template: %w", err)
+		}
+		body = []byte(payload)
+	} else {
+		var err error
+		if body, err = json.Marshal(data); err != nil {
+			return err
+		}
+	}
+	method := http.MethodPost
+	if d.Method != "" {
+		method = strings.ToUpper(d.Method)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range d.Headers {
+		value, err := e.render(ctx, k, v, data)
+		if err != nil {
+			return fmt.Errorf("header %s: %w", k, err)
+		}
+		req.Header.Set(k, value)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func toCSVArtifact(ctx context.Context, e *Executor, d Destination, p Pipeline, run RunInfo, records []Record) error {
+	name := d.Filename
+	if name == "" {
+		name = p.Name + ".csv"
+	}
+	return e.WriteCSV(run, name, nil, records)
+}
+
+// WriteCSV stores records as the CSV artifact name of run. Columns sets
+// the column order; without it every field seen is a column, sorted.
+func (e *Executor) WriteCSV(run RunInfo, name string, columns []string, records []Record) error {
+	if columns == nil {
+		columns = recordColumns(records)
+	}
+	data, err := encodeCSV(columns, records)
+	if err != nil {
+		return err
+	}
+	return e.WriteArtifact(run, name, data)
+}
+
+// WriteArtifact stores data as the artifact name of run.
+func (e *Executor) WriteArtifact(run RunInfo, name string, data []byte) error {
+	dir := filepath.Join(e.artifactDir, run.RunID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, filepath.Base(name)), data, 0644)
+}
+
+// RemoveArtifacts removes the artifacts of a run.
+func (e *Executor) RemoveArtifacts(runID string) error {
+	if runID == "" || runID != filepath.Base(runID) {
+		return fmt.Errorf("invalid run ID %q", runID)
+	}
+	return os.RemoveAll(filepath.Join(e.artifactDir, runID))
+}
+
+func toS3(ctx context.Context, e *Executor, d Destination, p Pipeline, run RunInfo, records []Record) error {
+	if d.Bucket == "" {
+		return fmt.Errorf("missing bucket")
+	}
+	key := d.Key
+	if key == "" {
+		key = fmt.Sprintf("%s/%s/%s", run.FlowID, run.RunID, p.Name)
+	}
+	key = strings.NewReplacer("{run_id}", run.RunID, "{flow_id}", run.FlowID, "{pipeline}", p.Name).Replace(key)
+
+	var body []byte
+	var contentType string
+	var err error
+	switch d.Format {
+	case "csv":
+		body, err = encodeCSV(recordColumns(records), records)
+		contentType = "text/csv"
+	case "", "json":
+		body, err = json.Marshal(records)
+		contentType = "application/json"
+	default:
+		return fmt.Errorf("unknown format: %s", d.Format)
+	}
+	if err != nil {
+		return err
+	}
+	return putS3Object(ctx, e.client, d, key, contentType, body)
+}
+
+// recordColumns returns every field seen in any record, sorted.
+func recordColumns(records []Record) []string {
+	seen := make(map[string]bool)
+	var columns []string
+	for _, r := range records {
+		for k := range r {
+			if !seen[k] {
+				seen[k] = true
+				columns = append(columns, k)
+			}
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// encodeCSV writes records with the given columns. Nested values are JSON
+// encoded.
+func encodeCSV(columns []string, records []Record) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(columns); err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		row := make([]string, len(columns))
+		for i, c := range columns {
+			row[i] = csvValue(r[c])
+		}
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func csvValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(v)
+		return string(b)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// DecodeRecords parses a JSON array of objects, also returning their
+// fields in the order they first appear, which a Record does not keep.
+func DecodeRecords(data []byte) ([]Record, []string, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, err
+	}
+	records := make([]Record, 0, len(raw))
+	seen := make(map[string]bool)
+	var columns []string
+	for _, item := range raw {
+		var r Record
+		if err := json.Unmarshal(item, &r); err != nil {
+			return nil, nil, err
+		}
+		records = append(records, r)
+
+		dec := json.NewDecoder(bytes.NewReader(item))
+		dec.Token() // {
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, nil, err
+			}
+			if key, ok := tok.(string); ok && !seen[key] {
+				seen[key] = true
+				columns = append(columns, key)
+			}
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return records, columns, nil
+}
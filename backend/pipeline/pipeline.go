@@ -0,0 +1,185 @@
+// Package pipeline post-processes the data extracted by a flow run and
+// delivers it to its destinations once the run has completed.
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"auto/secrets"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// Pipeline turns the result of one step into records, transforms them and
+// sends them to every destination. An empty Source uses the results of all
+// steps as a single record.
+type Pipeline struct {
+	Name         string        `json:"name"`
+	Source       string        `json:"source"`
+	Transforms   []Transform   `json:"transforms"`
+	Destinations []Destination `json:"destinations"`
+}
+
+// Transform is a single record transformation:
+//
+//	map      Expr is a JS expression computing the new record from "item"
+//	filter   Expr is a JS expression; records where it is falsy are dropped
+//	flatten  Field names an array whose elements replace the record, merged
+//	         with the record's other fields; without Field nested arrays of
+//	         records are spread
+//	rename   Fields maps old field names to new ones
+//	coerce   Fields maps field names to int, float, bool or string
+type Transform struct {
+	Type   string            `json:"type"`
+	Expr   string            `json:"expr,omitempty"`
+	Field  string            `json:"field,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// Destination is where the records of a pipeline are delivered:
+//
+//	redis_stream  each record is added to Stream
+//	webhook       the records are sent to URL, POSTed as JSON unless
+//	              Method and Template say otherwise
+//	csv           the records are written as a CSV artifact of the run
+//	s3            the records are uploaded to Bucket/Key as JSON or CSV
+//
+// The Template of a webhook is a Go template of the payload, executed with
+// run_id, flow_id, labels, pipeline and records; with Each it is sent once per
+// record, which it gets as record. Header values are templates too, see
+// parseTemplate.
+type Destination struct {
+	Type     string            `json:"type"`
+	Stream   string            `json:"stream,omitempty"`
+	MaxLen   int64             `json:"max_len,omitempty"`
+	URL      string            `json:"url,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Bucket   string            `json:"bucket,omitempty"`
+	Key      string            `json:"key,omitempty"`
+	Region   string            `json:"region,omitempty"`
+	Endpoint string            `json:"endpoint,omitempty"`
+	Format   string            `json:"format,omitempty"`
+	Filename string            `json:"filename,omitempty"`
+	Method   string            `json:"method,omitempty"`
+	Template string            `json:"template,omitempty"`
+	Each     bool              `json:"each,omitempty"`
+}
+
+// RunInfo identifies the run whose output is being processed.
+type RunInfo struct {
+	RunID  string            `json:"run_id"`
+	FlowID string            `json:"flow_id"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Record is a single row of pipeline data.
+type Record = map[string]interface{}
+
+// Executor runs pipelines.
+type Executor struct {
+	db          *redis.Client
+	client      *http.Client
+	artifactDir string
+	secrets     *secrets.Store
+	logger      *zap.Logger
+}
+
+func NewExecutor(db *redis.Client, artifactDir string, logger *zap.Logger) *Executor {
+	return &Executor{
+		db:          db,
+		client:      &http.Client{Timeout: 30 * time.Second},
+		artifactDir: artifactDir,
+		logger:      logger,
+	}
+}
+
+// Validate checks that the transforms and destinations of p are known.
+func Validate(p Pipeline) error {
+	if p.Name == "" {
+		return fmt.Errorf("pipeline is missing a name")
+	}
+	for _, t := range p.Transforms {
+		if _, ok := transforms[t.Type]; !ok {
+			return fmt.Errorf("pipeline %s: unknown transform: %s", p.Name, t.Type)
+		}
+	}
+	for _, d := range p.Destinations {
+		if _, ok := destinations[d.Type]; !ok {
+			return fmt.Errorf("pipeline %s: unknown destination: %s", p.Name, d.Type)
+		}
+		if d.Type == "webhook" {
+			if err := validateTemplates(d); err != nil {
+				return fmt.Errorf("pipeline %s: %w", p.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Run processes the step results of a completed run through p.
+func (e *Executor) Run(ctx context.Context, p Pipeline, run RunInfo, results map[string]string) error {
+	records, err := input(p.Source, results)
+	if err != nil {
+		return err
+	}
+	for _, t := range p.Transforms {
+		records, err = transforms[t.Type](t, records, e.logger)
+		if err != nil {
+			return fmt.Errorf("%s transform failed: %w", t.Type, err)
+		}
+	}
+	for _, d := range p.Destinations {
+		if err := destinations[d.Type](ctx, e, d, p, run, records); err != nil {
+			return fmt.Errorf("%s destination failed: %w", d.Type, err)
+		}
+	}
+	e.logger.Info("Pipeline completed", zap.String("pipeline", p.Name), zap.String("runID", run.RunID), zap.Int("records", len(records)))
+	return nil
+}
+
+// input builds the initial records from a step result: a JSON array gives
+// one record per element, a JSON object a single record and anything else
+// a record holding the raw value.
+func input(source string, results map[string]string) ([]Record, error) {
+	if source == "" {
+		record := make(Record, len(results))
+		for k, v := range results {
+			record[k] = v
+		}
+		return []Record{record}, nil
+	}
+	raw, ok := results[source]
+	if !ok {
+		return nil, fmt.Errorf("no result for step %s", source)
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return []Record{{"value": raw}}, nil
+	}
+	return toRecords(value), nil
+}
+
+func toRecords(value interface{}) []Record {
+	switch v := value.(type) {
+	case []interface{}:
+		records := make([]Record, 0, len(v))
+		for _, item := range v {
+			records = append(records, toRecord(item))
+		}
+		return records
+	default:
+		return []Record{toRecord(v)}
+	}
+}
+
+func toRecord(value interface{}) Record {
+	if r, ok := value.(map[string]interface{}); ok {
+		return r
+	}
+	return Record{"value": value}
+}
@@ -0,0 +1,209 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Location is a bucket of S3, or of an S3 compatible store such as MinIO
+// when Endpoint is set, which switches to path-style addressing. Requests
+// are SigV4 signed with AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and the
+// optional AWS_SESSION_TOKEN.
+type S3Location struct {
+	Bucket   string `json:"bucket"`
+	Prefix   string `json:"prefix,omitempty"`
+	Region   string `json:"region,omitempty"`
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// S3Object is an object listed by S3Location.List.
+type S3Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// putS3Object uploads body to the bucket of d.
+func putS3Object(ctx context.Context, client *http.Client, d Destination, key, contentType string, body []byte) error {
+	return S3Location{Bucket: d.Bucket, Region: d.Region, Endpoint: d.Endpoint}.Put(ctx, client, key, contentType, body)
+}
+
+// Put uploads body as key.
+func (l S3Location) Put(ctx context.Context, client *http.Client, key, contentType string, body []byte) error {
+	_, err := l.do(ctx, client, http.MethodPut, key, nil, map[string]string{"Content-Type": contentType}, body)
+	return err
+}
+
+// Get downloads key.
+func (l S3Location) Get(ctx context.Context, client *http.Client, key string) ([]byte, error) {
+	return l.do(ctx, client, http.MethodGet, key, nil, nil, nil)
+}
+
+// Copy copies the object from to the key to, within the bucket.
+func (l S3Location) Copy(ctx context.Context, client *http.Client, from, to string) error {
+	source := (&url.URL{Path: "/" + l.Bucket + "/" + strings.TrimPrefix(from, "/")}).EscapedPath()
+	_, err := l.do(ctx, client, http.MethodPut, to, nil, map[string]string{"X-Amz-Copy-Source": source}, nil)
+	return err
+}
+
+// Delete removes key.
+func (l S3Location) Delete(ctx context.Context, client *http.Client, key string) error {
+	_, err := l.do(ctx, client, http.MethodDelete, key, nil, nil, nil)
+	return err
+}
+
+// List returns the objects under the prefix of l, following continuation
+// tokens until the listing is complete.
+func (l S3Location) List(ctx context.Context, client *http.Client) ([]S3Object, error) {
+	var objects []S3Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {l.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		body, err := l.do(ctx, client, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents              []S3Object `xml:"Contents"`
+			IsTruncated           bool       `xml:"IsTruncated"`
+			NextContinuationToken string     `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("invalid s3 listing: %w", err)
+		}
+		objects = append(objects, page.Contents...)
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// do sends a signed request for key, or for the bucket itself when key is
+// empty, and returns the response body.
+func (l S3Location) do(ctx context.Context, client *http.Client, method, key string, query url.Values, headers map[string]string, body []byte) ([]byte, error) {
+	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+	}
+	region := l.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	escapedKey := (&url.URL{Path: "/" + strings.TrimPrefix(key, "/")}).EscapedPath()
+	var endpoint string
+	if l.Endpoint != "" {
+		endpoint = strings.TrimSuffix(l.Endpoint, "/") + "/" + l.Bucket + escapedKey
+	} else {
+		endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com%s", l.Bucket, region, escapedKey)
+	}
+	if len(query) > 0 {
+		// SigV4 wants spaces as %20, not the + Encode produces.
+		endpoint += "?" + strings.ReplaceAll(query.Encode(), "+", "%20")
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	signV4(req, body, accessKey, secretKey, region, time.Now().UTC())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("s3 returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func signV4(req *http.Request, body []byte, accessKey, secretKey, region string, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Content-Type, when set, and every x-amz-* header are signed.
+	headers := []string{"host"}
+	for h := range req.Header {
+		lower := strings.ToLower(h)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers = append(headers, lower)
+		}
+	}
+	sort.Strings(headers)
+	var canonicalHeaders strings.Builder
+	for _, h := range headers {
+		value := req.Header.Get(h)
+		if h == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
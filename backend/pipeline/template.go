@@ -0,0 +1,69 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"auto/secrets"
+)
+
+// SetSecrets sets the store the "secret" function of templates reads from.
+func (e *Executor) SetSecrets(store *secrets.Store) {
+	e.secrets = store
+}
+
+// parseTemplate parses a payload or header template of a webhook. Besides
+// the builtins, templates have "json", which encodes a value, and
+// "secret", which returns a secret of the store by name, as in
+//
+//	{"Authorization": "Bearer {{secret \"crm_token\"}}"}
+func parseTemplate(name, text string, secret func(string) (string, error)) (*template.Template, error) {
+	funcs := template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+		"secret": secret,
+	}
+	return template.New(name).Funcs(funcs).Option("missingkey=zero").Parse(text)
+}
+
+// render executes the template text with data. Text without actions is
+// returned as is.
+func (e *Executor) render(ctx context.Context, name, text string, data interface{}) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := parseTemplate(name, text, func(secret string) (string, error) {
+		if e.secrets == nil {
+			return "", errors.New("no secrets store")
+		}
+		return e.secrets.Get(ctx, secret)
+	})
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// validateTemplates checks that the templates of a destination parse.
+func validateTemplates(d Destination) error {
+	noSecret := func(string) (string, error) { return "", nil }
+	if _, err := parseTemplate("template", d.Template, noSecret); err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+	for name, value := range d.Headers {
+		if _, err := parseTemplate(name, value, noSecret); err != nil {
+			return fmt.Errorf("invalid template for header %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package pipeline
+
+import (
+	"fmt"
+	"strconv"
+
+	"auto/sandbox"
+
+	"go.uber.org/zap"
+)
+
+type transformFunc func(t Transform, records []Record, logger *zap.Logger) ([]Record, error)
+
+var transforms = map[string]transformFunc{
+	"map":     mapRecords,
+	"filter":  filterRecords,
+	"flatten": flattenRecords,
+	"rename":  renameFields,
+	"coerce":  coerceFields,
+}
+
+func mapRecords(t Transform, records []Record, logger *zap.Logger) ([]Record, error) {
+	out := make([]Record, 0, len(records))
+	for _, r := range records {
+		value, err := sandbox.Run(t.Expr, map[string]interface{}{"item": r}, 0, logger)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+		out = append(out, toRecord(value))
+	}
+	return out, nil
+}
+
+func filterRecords(t Transform, records []Record, logger *zap.Logger) ([]Record, error) {
+	out := make([]Record, 0, len(records))
+	for _, r := range records {
+		value, err := sandbox.Run(t.Expr, map[string]interface{}{"item": r}, 0, logger)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(value) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case int64:
+		return v != 0
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	default:
+		return true
+	}
+}
+
+func flattenRecords(t Transform, records []Record, logger *zap.Logger) ([]Record, error) {
+	var out []Record
+	for _, r := range records {
+		if t.Field == "" {
+			if items, ok := r["value"].([]interface{}); ok && len(r) == 1 {
+				out = append(out, toRecords(items)...)
+				continue
+			}
+			out = append(out, r)
+			continue
+		}
+		items, ok := r[t.Field].([]interface{})
+		if !ok {
+			out = append(out, r)
+			continue
+		}
+		for _, item := range items {
+			merged := make(Record, len(r))
+			for k, v := range r {
+				if k != t.Field {
+					merged[k] = v
+				}
+			}
+			for k, v := range toRecord(item) {
+				merged[k] = v
+			}
+			out = append(out, merged)
+		}
+	}
+	return out, nil
+}
+
+func renameFields(t Transform, records []Record, logger *zap.Logger) ([]Record, error) {
+	for _, r := range records {
+		for from, to := range t.Fields {
+			if v, ok := r[from]; ok {
+				delete(r, from)
+				r[to] = v
+			}
+		}
+	}
+	return records, nil
+}
+
+func coerceFields(t Transform, records []Record, logger *zap.Logger) ([]Record, error) {
+	for _, r := range records {
+		for field, typ := range t.Fields {
+			v, ok := r[field]
+			if !ok || v == nil {
+				continue
+			}
+			coerced, err := coerce(v, typ)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", field, err)
+			}
+			r[field] = coerced
+		}
+	}
+	return records, nil
+}
+
+func coerce(v interface{}, typ string) (interface{}, error) {
+	s := fmt.Sprint(v)
+	switch typ {
+	case "string":
+		return s, nil
+	case "int":
+		if f, ok := v.(float64); ok {
+			return int64(f), nil
+		}
+		return strconv.ParseInt(s, 10, 64)
+	case "float":
+		if f, ok := v.(float64); ok {
+			return f, nil
+		}
+		return strconv.ParseFloat(s, 64)
+	case "bool":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		return strconv.ParseBool(s)
+	default:
+		return nil, fmt.Errorf("unknown type: %s", typ)
+	}
+}
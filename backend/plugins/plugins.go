@@ -0,0 +1,172 @@
+// Package plugins discovers external executables that provide custom step
+// actions and exposes them in the model action registry.
+//
+// A plugin is any executable file in the plugin directory. At startup it is
+// run as "<plugin> describe" and must print a manifest:
+//
+//	{"name": "slack", "actions": ["postToSlack"]}
+//
+// Each step using one of its actions runs "<plugin> run <action>" with a
+// JSON request on stdin and expects a JSON reply on stdout:
+//
+//	{"action": "postToSlack", "instance": {"id": "...", "url": "..."}, "params": {...}}
+//	{"result": "ok"} or {"error": "reason"}
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"auto/model"
+
+	"go.uber.org/zap"
+)
+
+// DefaultTimeout bounds how long a single plugin invocation may run.
+const DefaultTimeout = 60 * time.Second
+
+// Plugin is an external executable providing step actions.
+type Plugin struct {
+	Name    string   `json:"name"`
+	Path    string   `json:"path"`
+	Actions []string `json:"actions"`
+}
+
+type request struct {
+	Action   string                 `json:"action"`
+	Instance instanceInfo           `json:"instance"`
+	Params   map[string]interface{} `json:"params"`
+}
+
+type instanceInfo struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+type reply struct {
+	Result string `json:"result"`
+	Error  string `json:"error"`
+}
+
+// Discover describes every executable in dir. A missing directory yields no
+// plugins; a plugin that fails to describe itself is logged and skipped.
+func Discover(dir string, logger *zap.Logger) ([]*Plugin, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var plugins []*Plugin
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.Mode()&0111 == 0 {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		p, err := describe(path)
+		if err != nil {
+			logger.Warn("Skipping plugin", zap.String("path", path), zap.Error(err))
+			continue
+		}
+		plugins = append(plugins, p)
+	}
+	return plugins, nil
+}
+
+func describe(path string) (*Plugin, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, "describe").Output()
+	if err != nil {
+		return nil, fmt.Errorf("describe failed: %w", err)
+	}
+	var p Plugin
+	if err := json.Unmarshal(out, &p); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if p.Name == "" {
+		p.Name = filepath.Base(path)
+	}
+	p.Path = path
+	return &p, nil
+}
+
+// Register adds the plugin actions to the action registry.
+func (p *Plugin) Register() error {
+	for _, action := range p.Actions {
+		if err := model.RegisterAction(action, p.handler(action)); err != nil {
+			return fmt.Errorf("plugin %s: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+func (p *Plugin) handler(action string) func(context.Context, *model.Instance, map[string]interface{}) (string, error) {
+	return func(ctx context.Context, i *model.Instance, params map[string]interface{}) (string, error) {
+		return p.run(ctx, request{
+			Action:   action,
+			Instance: instanceInfo{ID: i.ID, URL: i.Settings().URL},
+			Params:   params,
+		})
+	}
+}
+
+func (p *Plugin) run(ctx context.Context, req request) (string, error) {
+	input, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Path, "run", req.Action)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("plugin %s failed: %w: %s", p.Name, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var r reply
+	if err := json.Unmarshal(stdout.Bytes(), &r); err != nil {
+		return "", fmt.Errorf("plugin %s returned invalid output: %w", p.Name, err)
+	}
+	if r.Error != "" {
+		return "", errors.New(r.Error)
+	}
+	return r.Result, nil
+}
+
+// Load discovers the plugins in dir and registers their actions, returning
+// the plugins that were registered.
+func Load(dir string, logger *zap.Logger) ([]*Plugin, error) {
+	found, err := Discover(dir, logger)
+	if err != nil {
+		return nil, err
+	}
+	var loaded []*Plugin
+	for _, p := range found {
+		if err := p.Register(); err != nil {
+			logger.Warn("Failed to register plugin", zap.String("plugin", p.Name), zap.Error(err))
+			continue
+		}
+		logger.Info("Plugin loaded", zap.String("plugin", p.Name), zap.Strings("actions", p.Actions))
+		loaded = append(loaded, p)
+	}
+	return loaded, nil
+}
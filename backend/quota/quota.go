@@ -0,0 +1,268 @@
+// Package quota limits what each workspace may use: its instances, the
+// runs it has going at once, the storage of its artifacts and the browser
+// minutes of its runs each month.
+package quota
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"auto/apperr"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// DefaultWorkspace owns what is not given a workspace.
+const DefaultWorkspace = "default"
+
+// staleRun is how long a run counts as going when its end was never
+// recorded, as when its server went away.
+const staleRun = 24 * time.Hour
+
+// minutesTTL is how long the browser minutes of a month are kept.
+const minutesTTL = 400 * 24 * time.Hour
+
+var workspaceName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// Limits are the quotas of a workspace; zero is unlimited.
+type Limits struct {
+	Instances      int `json:"instances,omitempty"`
+	ConcurrentRuns int `json:"concurrent_runs,omitempty"`
+	StorageMB      int `json:"storage_mb,omitempty"`
+	BrowserMinutes int `json:"browser_minutes,omitempty"`
+}
+
+// Usage is what a workspace uses, next to its limits. BrowserMinutes are
+// those of the runs of Month so far.
+type Usage struct {
+	Workspace      string  `json:"workspace"`
+	Month          string  `json:"month"`
+	Instances      int     `json:"instances"`
+	ConcurrentRuns int     `json:"concurrent_runs"`
+	StorageBytes   int64   `json:"storage_bytes"`
+	BrowserMinutes float64 `json:"browser_minutes"`
+	Limits         Limits  `json:"limits"`
+}
+
+// Name returns the workspace called name, the default one if it is empty.
+func Name(name string) string {
+	if name == "" {
+		return DefaultWorkspace
+	}
+	return name
+}
+
+// ValidateName checks a workspace name: up to 64 letters, digits, '_',
+// '.' and '-'.
+func ValidateName(name string) error {
+	if !workspaceName.MatchString(name) {
+		return apperr.New(apperr.CodeInvalidRequest, fmt.Sprintf("invalid workspace %q: use up to 64 letters, digits, '_', '.' and '-'", name))
+	}
+	return nil
+}
+
+// ParseLimits builds the limits of the workspaces from entries such as
+// "acme:instances=5" or "acme:concurrent_runs=2"; the resources are
+// instances, concurrent_runs, storage_mb and browser_minutes. The
+// workspace "*" sets the limits of those without their own.
+func ParseLimits(entries []string) (map[string]Limits, error) {
+	limits := make(map[string]Limits)
+	for _, entry := range entries {
+		workspace, rule, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		resource, value, ok2 := strings.Cut(rule, "=")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid workspace quota %q: want \"<workspace>:<resource>=<limit>\"", entry)
+		}
+		if workspace != "*" {
+			if err := ValidateName(workspace); err != nil {
+				return nil, err
+			}
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid workspace quota %q: the limit must be a number", entry)
+		}
+		l := limits[workspace]
+		switch strings.TrimSpace(resource) {
+		case "instances":
+			l.Instances = n
+		case "concurrent_runs":
+			l.ConcurrentRuns = n
+		case "storage_mb":
+			l.StorageMB = n
+		case "browser_minutes":
+			l.BrowserMinutes = n
+		default:
+			return nil, fmt.Errorf("invalid workspace quota %q: unknown resource %q", entry, resource)
+		}
+		limits[workspace] = l
+	}
+	return limits, nil
+}
+
+// Tracker keeps what the workspaces use in Redis, so the servers agree on
+// it, and checks it against their limits.
+type Tracker struct {
+	rdb         *redis.Client
+	limits      map[string]Limits
+	artifactDir string
+}
+
+// NewTracker returns a tracker for limits; the artifacts of each run are
+// in the directory of its ID under artifactDir.
+func NewTracker(rdb *redis.Client, limits map[string]Limits, artifactDir string) *Tracker {
+	return &Tracker{rdb: rdb, limits: limits, artifactDir: artifactDir}
+}
+
+// Limits returns the limits of workspace.
+func (t *Tracker) Limits(workspace string) Limits {
+	if l, ok := t.limits[Name(workspace)]; ok {
+		return l
+	}
+	return t.limits["*"]
+}
+
+func activeKey(workspace string) string {
+	return "workspace_active_runs:" + Name(workspace)
+}
+
+func runsKey(workspace string) string {
+	return "workspace_runs:" + Name(workspace)
+}
+
+func minutesKey(month string) string {
+	return "workspace_minutes:" + month
+}
+
+func month(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// RunStarted records that the run runID of workspace is going.
+func (t *Tracker) RunStarted(ctx context.Context, workspace, runID string) error {
+	now := float64(time.Now().Unix())
+	pipe := t.rdb.TxPipeline()
+	pipe.ZAdd(ctx, activeKey(workspace), &redis.Z{Score: now, Member: runID})
+	pipe.ZAdd(ctx, runsKey(workspace), &redis.Z{Score: now, Member: runID})
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// RunFinished records that the run runID of workspace is over after using
+// the browser for d.
+func (t *Tracker) RunFinished(ctx context.Context, workspace, runID string, d time.Duration) error {
+	key := minutesKey(month(time.Now()))
+	pipe := t.rdb.TxPipeline()
+	pipe.ZRem(ctx, activeKey(workspace), runID)
+	pipe.HIncrByFloat(ctx, key, Name(workspace), d.Minutes())
+	pipe.Expire(ctx, key, minutesTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Usage returns what workspace uses; its instances are counted by the
+// caller, which knows them.
+func (t *Tracker) Usage(ctx context.Context, workspace string, instances int) (Usage, error) {
+	workspace = Name(workspace)
+	now := time.Now()
+	u := Usage{Workspace: workspace, Month: month(now), Instances: instances, Limits: t.Limits(workspace)}
+	active, err := t.rdb.ZCount(ctx, activeKey(workspace), strconv.FormatInt(now.Add(-staleRun).Unix(), 10), "+inf").Result()
+	if err != nil {
+		return u, err
+	}
+	u.ConcurrentRuns = int(active)
+	minutes, err := t.rdb.HGet(ctx, minutesKey(u.Month), workspace).Float64()
+	if err != nil && err != redis.Nil {
+		return u, err
+	}
+	u.BrowserMinutes = minutes
+	u.StorageBytes, err = t.storage(ctx, workspace, now)
+	return u, err
+}
+
+// storage adds up the size of the artifacts of the runs of workspace. Runs
+// over for a day without artifacts are forgotten, their artifacts having
+// been removed or never written.
+func (t *Tracker) storage(ctx context.Context, workspace string, now time.Time) (int64, error) {
+	runs, err := t.rdb.ZRangeWithScores(ctx, runsKey(workspace), 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	var gone []interface{}
+	for _, run := range runs {
+		id, _ := run.Member.(string)
+		size, found := dirSize(filepath.Join(t.artifactDir, filepath.Base(id)))
+		total += size
+		if !found && now.Sub(time.Unix(int64(run.Score), 0)) > staleRun {
+			gone = append(gone, id)
+		}
+	}
+	if len(gone) > 0 {
+		t.rdb.ZRem(ctx, runsKey(workspace), gone...)
+	}
+	return total, nil
+}
+
+func dirSize(dir string) (int64, bool) {
+	if _, err := os.Stat(dir); err != nil {
+		return 0, false
+	}
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size, true
+}
+
+// CheckInstances returns an error with apperr.CodeQuotaExceeded when
+// workspace, having instances of them, may not have one more.
+func (t *Tracker) CheckInstances(workspace string, instances int) error {
+	limit := t.Limits(workspace).Instances
+	if limit > 0 && instances >= limit {
+		return exceeded(apperr.CodeQuotaExceeded, workspace, "instances", limit, instances)
+	}
+	return nil
+}
+
+// CheckRun returns an error when workspace may not start a run: with
+// apperr.CodeConcurrencyQuotaExceeded when it has as many going as it may,
+// so that it can retry later, and with apperr.CodeQuotaExceeded when its
+// artifacts or browser minutes are used up.
+func (t *Tracker) CheckRun(ctx context.Context, workspace string, instances int) error {
+	limits := t.Limits(workspace)
+	if limits == (Limits{}) {
+		return nil
+	}
+	u, err := t.Usage(ctx, workspace, instances)
+	if err != nil {
+		return err
+	}
+	switch {
+	case limits.ConcurrentRuns > 0 && u.ConcurrentRuns >= limits.ConcurrentRuns:
+		return exceeded(apperr.CodeConcurrencyQuotaExceeded, workspace, "concurrent_runs", limits.ConcurrentRuns, u.ConcurrentRuns)
+	case limits.BrowserMinutes > 0 && u.BrowserMinutes >= float64(limits.BrowserMinutes):
+		return exceeded(apperr.CodeQuotaExceeded, workspace, "browser_minutes", limits.BrowserMinutes, u.BrowserMinutes)
+	case limits.StorageMB > 0 && u.StorageBytes >= int64(limits.StorageMB)<<20:
+		return exceeded(apperr.CodeQuotaExceeded, workspace, "storage_mb", limits.StorageMB, float64(u.StorageBytes)/(1<<20))
+	}
+	return nil
+}
+
+func exceeded(code apperr.Code, workspace, resource string, limit int, used interface{}) error {
+	err := apperr.New(code, fmt.Sprintf("workspace %s has reached its %s quota of %d", Name(workspace), resource, limit))
+	err.Details = map[string]interface{}{"workspace": Name(workspace), "resource": resource, "limit": limit, "used": used}
+	return err
+}
@@ -0,0 +1,265 @@
+package reconcile
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"auto/dbmanager"
+	"auto/flow"
+	"auto/model"
+	"auto/scheduler"
+)
+
+// instanceState holds the settings of an instance a spec manages, for
+// both the spec and the instance, so the two compare field by field.
+type instanceState struct {
+	URL                string                `json:"url"`
+	TemplateID         string                `json:"template_id"`
+	LoginProfileID     string                `json:"login_profile_id"`
+	Tags               []string              `json:"tags"`
+	IdleTimeoutMinutes int                   `json:"idle_timeout_minutes"`
+	Headers            model.HeaderOverrides `json:"headers"`
+	Device             string                `json:"device"`
+	Permissions        map[string]string     `json:"permissions"`
+	Driver             model.DriverConfig    `json:"driver"`
+}
+
+func stateOf(i *model.Instance) instanceState {
+	s := i.Settings()
+	return instanceState{
+		URL:                s.URL,
+		TemplateID:         s.TemplateID,
+		LoginProfileID:     s.LoginProfileID,
+		Tags:               s.Tags,
+		IdleTimeoutMinutes: s.IdleTimeoutMinutes,
+		Headers:            s.Headers,
+		Device:             s.Device,
+		Permissions:        s.Permissions,
+		Driver:             s.Driver,
+	}
+}
+
+// desiredState resolves the template of an instance spec, the same way
+// creating an instance from a template does.
+func (r *Reconciler) desiredState(s InstanceSpec) (instanceState, error) {
+	state := instanceState{
+		URL:                s.URL,
+		TemplateID:         s.TemplateID,
+		LoginProfileID:     s.LoginProfileID,
+		Tags:               s.Tags,
+		IdleTimeoutMinutes: s.IdleTimeoutMinutes,
+		Device:             s.Device,
+		Permissions:        s.Permissions,
+	}
+	if s.TemplateID != "" {
+		t, err := r.instances.GetInstanceTemplate(s.TemplateID)
+		if err != nil {
+			return state, fmt.Errorf("instance %s: %w", s.ID, err)
+		}
+		if state.URL == "" {
+			if state.URL, err = t.URLFor(s.Vars); err != nil {
+				return state, fmt.Errorf("instance %s: %w", s.ID, err)
+			}
+		}
+		if state.LoginProfileID == "" {
+			state.LoginProfileID = t.LoginProfileID
+		}
+		if state.IdleTimeoutMinutes == 0 {
+			state.IdleTimeoutMinutes = t.IdleTimeoutMinutes
+		}
+		if state.Device == "" {
+			state.Device = t.Device
+		}
+		if state.Permissions == nil {
+			state.Permissions = t.Permissions
+		}
+		state.Tags = append(append([]string{}, t.Tags...), s.Tags...)
+		state.Headers = t.Headers
+		state.Driver = t.Driver
+	}
+	if s.Headers != nil {
+		state.Headers = *s.Headers
+	}
+	if s.Driver != nil {
+		state.Driver = *s.Driver
+	}
+	if state.URL == "" {
+		return state, fmt.Errorf("instance %s: a url or a template is required", s.ID)
+	}
+
+	// Instances keep their tags sorted and without duplicates.
+	seen := make(map[string]bool, len(state.Tags))
+	tags := make([]string, 0, len(state.Tags))
+	for _, tag := range state.Tags {
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	state.Tags = tags
+	return state, nil
+}
+
+func (r *Reconciler) planInstance(s InstanceSpec) (*Change, error) {
+	desired, err := r.desiredState(s)
+	if err != nil {
+		return nil, err
+	}
+	c := &Change{Kind: KindInstance, ID: s.ID}
+
+	current, err := r.instances.GetInstance(s.ID)
+	if err != nil {
+		c.Action = ActionCreate
+		c.apply = func() error {
+			created, err := r.instances.CreateInstanceWithID(s.ID, desired.URL, model.Auth{})
+			if err != nil {
+				return err
+			}
+			fields, err := diffFields(stateOf(created), desired)
+			if err != nil {
+				return err
+			}
+			if err := r.setInstance(s.ID, desired, fields); err != nil {
+				return err
+			}
+			return r.store.SaveInstance(dbmanager.DbInstance{
+				ID:       dbmanager.NewNullString(created.ID),
+				URL:      dbmanager.NewNullString(created.URL),
+				Auth:     dbmanager.NewNullString(""),
+				Status:   dbmanager.NewNullString(created.GetStatus()),
+				LastUsed: dbmanager.NewNullTime(time.Now()),
+			})
+		}
+		return c, nil
+	}
+
+	if c.Fields, err = diffFields(stateOf(current), desired); err != nil {
+		return nil, err
+	}
+	if len(c.Fields) == 0 {
+		return nil, nil
+	}
+	c.Action = ActionUpdate
+	c.apply = func() error { return r.setInstance(s.ID, desired, c.Fields) }
+	return c, nil
+}
+
+// setInstance changes the fields of an instance to those of state.
+func (r *Reconciler) setInstance(id string, state instanceState, fields []string) error {
+	var template bool
+	for _, field := range fields {
+		var err error
+		switch field {
+		case "url":
+			err = r.instances.SetInstanceURL(id, state.URL)
+		case "template_id":
+			template = true
+		case "login_profile_id":
+			err = r.instances.SetInstanceLoginProfile(id, state.LoginProfileID)
+		case "tags":
+			err = r.instances.SetInstanceTags(id, state.Tags)
+		case "idle_timeout_minutes":
+			err = r.instances.SetInstanceIdleTimeout(id, state.IdleTimeoutMinutes)
+		case "headers":
+			err = r.instances.SetInstanceHeaders(id, state.Headers)
+		case "device":
+			err = r.instances.SetInstanceDevice(id, state.Device)
+		case "permissions":
+			err = r.instances.SetInstancePermissions(id, state.Permissions)
+		case "driver":
+			err = r.instances.SetInstanceDriver(id, state.Driver)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !template {
+		return nil
+	}
+	// Only applying a template records it on the instance. The settings
+	// it applies are those of the spec, which the setters above checked.
+	return r.instances.ApplyInstanceTemplate(id, &model.InstanceTemplate{
+		ID:          state.TemplateID,
+		Headers:     state.Headers,
+		Device:      state.Device,
+		Permissions: state.Permissions,
+		Driver:      state.Driver,
+	})
+}
+
+func (r *Reconciler) planFlow(f *flow.FlowImpl) (*Change, error) {
+	if f.Retention != nil {
+		if err := f.Retention.Validate(); err != nil {
+			return nil, fmt.Errorf("flow %s: %w", f.ID, err)
+		}
+	}
+	if f.Proxy != nil {
+		if err := f.Proxy.Validate(); err != nil {
+			return nil, fmt.Errorf("flow %s: %w", f.ID, err)
+		}
+		if f.Proxy.Mode != model.ProxyReplay {
+			return nil, fmt.Errorf("flow %s: a flow can only replay its runs through a proxy: mode must be replay", f.ID)
+		}
+	}
+	// Steps get IDs from their position rather than random ones, so that
+	// applying the same spec again finds nothing to change.
+	for i := range f.Steps {
+		if f.Steps[i].ID == "" {
+			f.Steps[i].ID = fmt.Sprintf("%s-%d", f.ID, i+1)
+		}
+	}
+	if f.Steps == nil {
+		f.Steps = []flow.Step{}
+	}
+	c := &Change{Kind: KindFlow, ID: f.ID}
+
+	current, err := r.flows.GetFlow(f.ID)
+	if err != nil {
+		c.Action = ActionCreate
+		c.apply = func() error {
+			_, err := r.flows.CreateFlowFrom(f)
+			return err
+		}
+		return c, nil
+	}
+
+	if c.Fields, err = diffFields(current, f, "revision"); err != nil {
+		return nil, err
+	}
+	if len(c.Fields) == 0 {
+		return nil, nil
+	}
+	c.Action = ActionUpdate
+	c.apply = func() error {
+		f.Revision = current.GetRevision()
+		return r.flows.UpdateFlow(f)
+	}
+	return c, nil
+}
+
+func (r *Reconciler) planSchedule(sch *scheduler.Schedule) (*Change, error) {
+	if sch.Cron == "" {
+		return nil, fmt.Errorf("schedule %s: cron is required", sch.ID)
+	}
+	c := &Change{Kind: KindSchedule, ID: sch.ID}
+
+	current, err := r.schedules.GetSchedule(sch.ID)
+	if err != nil {
+		c.Action = ActionCreate
+	} else {
+		if c.Fields, err = diffFields(current, sch, "next_run", "warnings"); err != nil {
+			return nil, err
+		}
+		if len(c.Fields) == 0 {
+			return nil, nil
+		}
+		c.Action = ActionUpdate
+	}
+	c.apply = func() error {
+		_, err := r.schedules.PutSchedule(*sch)
+		return err
+	}
+	return c, nil
+}
@@ -0,0 +1,105 @@
+package reconcile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"auto/apperr"
+	"auto/flow"
+)
+
+// Plan is what reconciling would change. Hash identifies the changes:
+// carrying them out takes the hash back, so that nothing is created,
+// changed or deleted that the caller was not shown first.
+type Plan struct {
+	Changes []*Change `json:"changes"`
+	// Summary counts the changes by action.
+	Summary map[string]int `json:"summary"`
+	// ActiveRuns are the runs in progress on this server on an instance or
+	// of a flow the plan deletes. They are not part of the hash, as they
+	// come and go while the plan is looked at.
+	ActiveRuns []flow.LiveRun `json:"active_runs"`
+	Hash       string         `json:"hash"`
+}
+
+func (r *Reconciler) newPlan(changes []*Change) (*Plan, error) {
+	if changes == nil {
+		changes = []*Change{}
+	}
+	p := &Plan{
+		Changes:    changes,
+		Summary:    map[string]int{ActionCreate: 0, ActionUpdate: 0, ActionDelete: 0},
+		ActiveRuns: []flow.LiveRun{},
+	}
+	deleted := map[string]map[string]bool{KindInstance: {}, KindFlow: {}}
+	for _, c := range changes {
+		p.Summary[c.Action]++
+		if c.Action == ActionDelete && deleted[c.Kind] != nil {
+			deleted[c.Kind][c.ID] = true
+		}
+	}
+	for _, run := range r.flows.LiveRuns() {
+		if deleted[KindInstance][run.InstanceID] || deleted[KindFlow][run.FlowID] {
+			p.ActiveRuns = append(p.ActiveRuns, run)
+		}
+	}
+
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	p.Hash = hex.EncodeToString(sum[:])
+	return p, nil
+}
+
+// PlanInstanceDeletes plans deleting the instances of ids, as a bulk
+// delete does. IDs of no instance are left out.
+func (r *Reconciler) PlanInstanceDeletes(ids []string) (*Plan, error) {
+	var changes []*Change
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] || !r.exists(KindInstance, id) {
+			continue
+		}
+		seen[id] = true
+		changes = append(changes, r.deletion(KindInstance, id))
+	}
+	return r.newPlan(changes)
+}
+
+// CheckPlan checks that hash is the hash of p: that the caller was shown
+// the plan about to be carried out, and nothing changed since that would
+// make it a different one.
+func CheckPlan(p *Plan, hash string) error {
+	if hash != p.Hash {
+		return &PlanMismatchError{Plan: p, Given: hash}
+	}
+	return nil
+}
+
+// PlanMismatchError is returned when changes are carried out without the
+// hash of their plan. Its details hold the plan as it stands.
+type PlanMismatchError struct {
+	Plan  *Plan
+	Given string
+}
+
+func (e *PlanMismatchError) Error() string {
+	if e.Given == "" {
+		return "no plan hash given: review the plan and apply it with its hash"
+	}
+	return "the plan has changed since it was made: review it again and apply it with its new hash"
+}
+
+func (e *PlanMismatchError) ErrorCode() apperr.Code {
+	if e.Given == "" {
+		return apperr.CodePlanRequired
+	}
+	return apperr.CodePlanChanged
+}
+
+func (e *PlanMismatchError) ErrorDetails() map[string]interface{} {
+	return map[string]interface{}{"plan": e.Plan}
+}
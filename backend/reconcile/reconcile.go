@@ -0,0 +1,354 @@
+// Package reconcile brings instances, flows and schedules in line with a
+// declarative spec, so a deployment can be managed from files kept in
+// version control.
+//
+// Nothing changes without a plan first: Plan lists the changes, with a
+// hash, and Apply makes them only when given that hash back.
+//
+// Resources are matched by the IDs the spec gives them. A resource the
+// spec lists is created when it does not exist and updated when it
+// differs; from then on it is managed. With Prune, managed resources the
+// spec no longer lists are deleted. Resources made by hand are never
+// deleted, even when they share the kind of those in the spec.
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"auto/dbmanager"
+	"auto/flow"
+	"auto/model"
+	"auto/scheduler"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// Resource kinds, in the order they are created. Deletions go in reverse,
+// so schedules go before the flows they run and flows before their
+// instances.
+const (
+	KindInstance = "instance"
+	KindFlow     = "flow"
+	KindSchedule = "schedule"
+)
+
+var kinds = []string{KindInstance, KindFlow, KindSchedule}
+
+// Actions of a Change.
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// managedKey is the Redis set of the IDs of the managed resources of a
+// kind.
+func managedKey(kind string) string {
+	return "reconcile_managed:" + kind
+}
+
+// Spec is the desired state of a deployment.
+type Spec struct {
+	Instances []InstanceSpec        `json:"instances"`
+	Flows     []*flow.FlowImpl      `json:"flows"`
+	Schedules []*scheduler.Schedule `json:"schedules"`
+	// Prune deletes the managed resources the spec does not list.
+	Prune bool `json:"prune"`
+}
+
+// InstanceSpec is the desired state of an instance. With a template, the
+// fields left empty come from the template, whose URL takes Vars; Tags
+// add to those of the template. Credentials are not part of a spec: give
+// the instance a login profile that reads them from secrets.
+type InstanceSpec struct {
+	ID                 string                 `json:"id"`
+	TemplateID         string                 `json:"template_id,omitempty"`
+	Vars               map[string]string      `json:"vars,omitempty"`
+	URL                string                 `json:"url,omitempty"`
+	LoginProfileID     string                 `json:"login_profile_id,omitempty"`
+	Tags               []string               `json:"tags,omitempty"`
+	IdleTimeoutMinutes int                    `json:"idle_timeout_minutes,omitempty"`
+	Headers            *model.HeaderOverrides `json:"headers,omitempty"`
+	Device             string                 `json:"device,omitempty"`
+	Permissions        map[string]string      `json:"permissions,omitempty"`
+	Driver             *model.DriverConfig    `json:"driver,omitempty"`
+}
+
+// ParseSpec reads a spec from JSON or, when name ends in .yaml or .yml or
+// the content type says so, YAML.
+func ParseSpec(data []byte, name, contentType string) (*Spec, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".yaml" || ext == ".yml" || strings.Contains(contentType, "yaml") {
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid spec: %v", err)
+		}
+		// Decoded through JSON so that YAML specs take the same field
+		// names as JSON ones.
+		var err error
+		if data, err = json.Marshal(doc); err != nil {
+			return nil, fmt.Errorf("invalid spec: %v", err)
+		}
+	}
+	var spec Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("invalid spec: %v", err)
+	}
+	return &spec, spec.validate()
+}
+
+// validate checks that every resource has an ID of its own.
+func (s *Spec) validate() error {
+	ids := func(kind string, list []string) error {
+		seen := make(map[string]bool, len(list))
+		for i, id := range list {
+			if id == "" {
+				return fmt.Errorf("%s %d of the spec has no id", kind, i+1)
+			}
+			if seen[id] {
+				return fmt.Errorf("%s %s is in the spec twice", kind, id)
+			}
+			seen[id] = true
+		}
+		return nil
+	}
+	var instances, flows, schedules []string
+	for _, i := range s.Instances {
+		instances = append(instances, i.ID)
+	}
+	for _, f := range s.Flows {
+		if f == nil {
+			return errors.New("the spec has an empty flow")
+		}
+		flows = append(flows, f.ID)
+	}
+	for _, sch := range s.Schedules {
+		if sch == nil {
+			return errors.New("the spec has an empty schedule")
+		}
+		schedules = append(schedules, sch.ID)
+	}
+	if err := ids(KindInstance, instances); err != nil {
+		return err
+	}
+	if err := ids(KindFlow, flows); err != nil {
+		return err
+	}
+	return ids(KindSchedule, schedules)
+}
+
+// Change is a difference between the spec and the deployment, and what
+// reconciling does about it.
+type Change struct {
+	Kind   string `json:"kind"`
+	ID     string `json:"id"`
+	Action string `json:"action"`
+	// Fields are the fields an update changes.
+	Fields []string `json:"fields,omitempty"`
+	// Error is why applying the change failed.
+	Error string `json:"error,omitempty"`
+
+	apply func() error
+}
+
+// Reconciler diffs specs against the deployment and applies them.
+type Reconciler struct {
+	db        *redis.Client
+	store     *dbmanager.DbManager
+	instances *model.InstanceManager
+	flows     *flow.Manager
+	schedules *scheduler.Scheduler
+	logger    *zap.Logger
+}
+
+func New(db *redis.Client, store *dbmanager.DbManager, instances *model.InstanceManager, flows *flow.Manager, schedules *scheduler.Scheduler, logger *zap.Logger) *Reconciler {
+	return &Reconciler{db: db, store: store, instances: instances, flows: flows, schedules: schedules, logger: logger}
+}
+
+// Plan returns the changes that bring the deployment in line with spec,
+// instances first, then flows, then schedules, and deletions last.
+func (r *Reconciler) Plan(ctx context.Context, spec *Spec) (*Plan, error) {
+	var changes []*Change
+	for _, s := range spec.Instances {
+		c, err := r.planInstance(s)
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			changes = append(changes, c)
+		}
+	}
+	for _, f := range spec.Flows {
+		c, err := r.planFlow(f)
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			changes = append(changes, c)
+		}
+	}
+	for _, sch := range spec.Schedules {
+		c, err := r.planSchedule(sch)
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			changes = append(changes, c)
+		}
+	}
+	if !spec.Prune {
+		return r.newPlan(changes)
+	}
+
+	listed := map[string]map[string]bool{KindInstance: {}, KindFlow: {}, KindSchedule: {}}
+	for _, s := range spec.Instances {
+		listed[KindInstance][s.ID] = true
+	}
+	for _, f := range spec.Flows {
+		listed[KindFlow][f.ID] = true
+	}
+	for _, sch := range spec.Schedules {
+		listed[KindSchedule][sch.ID] = true
+	}
+	for i := len(kinds) - 1; i >= 0; i-- {
+		kind := kinds[i]
+		managed, err := r.db.SMembers(ctx, managedKey(kind)).Result()
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(managed)
+		for _, id := range managed {
+			if listed[kind][id] || !r.exists(kind, id) {
+				continue
+			}
+			changes = append(changes, r.deletion(kind, id))
+		}
+	}
+	return r.newPlan(changes)
+}
+
+// Apply makes the changes of a plan, in order, and marks the resources it
+// creates or updates as managed, provided hash is the hash of the plan;
+// see CheckPlan. A change that fails is recorded on it and does not stop
+// the others; Apply returns how many failed.
+func (r *Reconciler) Apply(ctx context.Context, p *Plan, hash string) (int, error) {
+	if err := CheckPlan(p, hash); err != nil {
+		return 0, err
+	}
+	failed := 0
+	for _, c := range p.Changes {
+		err := c.apply()
+		if err == nil {
+			if c.Action == ActionDelete {
+				err = r.db.SRem(ctx, managedKey(c.Kind), c.ID).Err()
+			} else {
+				err = r.db.SAdd(ctx, managedKey(c.Kind), c.ID).Err()
+			}
+		}
+		if err != nil {
+			c.Error = err.Error()
+			failed++
+			r.logger.Error("Failed to reconcile", zap.String("kind", c.Kind), zap.String("id", c.ID), zap.String("action", c.Action), zap.Error(err))
+			continue
+		}
+		r.logger.Info("Reconciled", zap.String("kind", c.Kind), zap.String("id", c.ID), zap.String("action", c.Action))
+	}
+	return failed, nil
+}
+
+func (r *Reconciler) exists(kind, id string) bool {
+	var err error
+	switch kind {
+	case KindInstance:
+		_, err = r.instances.GetInstance(id)
+	case KindFlow:
+		_, err = r.flows.GetFlow(id)
+	case KindSchedule:
+		_, err = r.schedules.GetSchedule(id)
+	}
+	return err == nil
+}
+
+func (r *Reconciler) deletion(kind, id string) *Change {
+	c := &Change{Kind: kind, ID: id, Action: ActionDelete}
+	switch kind {
+	case KindInstance:
+		c.apply = func() error {
+			if err := r.instances.DeleteInstance(id); err != nil {
+				return err
+			}
+			return r.store.DeleteInstance(id)
+		}
+	case KindFlow:
+		c.apply = func() error { return r.flows.DeleteFlow(id) }
+	case KindSchedule:
+		c.apply = func() error { return r.schedules.DeleteSchedule(id) }
+	}
+	return c
+}
+
+// diffFields returns the names of the top-level JSON fields that differ
+// between current and desired, apart from those of ignore.
+func diffFields(current, desired interface{}, ignore ...string) ([]string, error) {
+	var a, b map[string]interface{}
+	for _, v := range []struct {
+		from interface{}
+		to   *map[string]interface{}
+	}{{current, &a}, {desired, &b}} {
+		data, err := json.Marshal(v.from)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, v.to); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range ignore {
+		delete(a, name)
+		delete(b, name)
+	}
+	var fields []string
+	for name := range b {
+		if !jsonEqual(a[name], b[name]) {
+			fields = append(fields, name)
+		}
+	}
+	for name := range a {
+		if _, ok := b[name]; !ok && !jsonEqual(a[name], nil) {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// jsonEqual compares decoded JSON values, taking null, empty objects and
+// empty arrays as alike.
+func jsonEqual(a, b interface{}) bool {
+	if isEmpty(a) && isEmpty(b) {
+		return true
+	}
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
+
+func isEmpty(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
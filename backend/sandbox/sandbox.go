@@ -0,0 +1,88 @@
+// Package sandbox runs user supplied JavaScript on the server. Scripts get
+// the ECMAScript built-ins (JSON, Math, RegExp, Date, ...) plus the
+// variables they are given and a console that writes to the logger; there is
+// no access to the file system, network or processes.
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dop251/goja"
+	"go.uber.org/zap"
+)
+
+// DefaultTimeout bounds how long a script may run.
+const DefaultTimeout = 5 * time.Second
+
+// ErrTimeout is returned when a script runs past its timeout.
+var ErrTimeout = errors.New("script timed out")
+
+// Run evaluates code with vars defined as globals and returns the value of
+// its last expression. The script is interrupted once timeout elapses.
+func Run(code string, vars map[string]interface{}, timeout time.Duration, logger *zap.Logger) (interface{}, error) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	vm := goja.New()
+	vm.SetFieldNameMapper(goja.TagFieldNameMapper("json", true))
+
+	for name, value := range vars {
+		if err := vm.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+	if err := vm.Set("console", console(logger)); err != nil {
+		return nil, err
+	}
+
+	timer := time.AfterFunc(timeout, func() {
+		vm.Interrupt(ErrTimeout)
+	})
+	defer timer.Stop()
+
+	value, err := vm.RunString(code)
+	if err != nil {
+		var interrupted *goja.InterruptedError
+		if errors.As(err, &interrupted) {
+			return nil, ErrTimeout
+		}
+		return nil, fmt.Errorf("script failed: %w", err)
+	}
+	if value == nil || goja.IsUndefined(value) || goja.IsNull(value) {
+		return nil, nil
+	}
+	return value.Export(), nil
+}
+
+// RunString is Run with the result rendered as a step result: strings are
+// returned as they are and other values are JSON encoded.
+func RunString(code string, vars map[string]interface{}, timeout time.Duration, logger *zap.Logger) (string, error) {
+	value, err := Run(code, vars, timeout, logger)
+	if err != nil {
+		return "", err
+	}
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+}
+
+func console(logger *zap.Logger) map[string]interface{} {
+	log := func(args ...interface{}) {
+		logger.Info("Script output", zap.String("message", fmt.Sprint(args...)))
+	}
+	return map[string]interface{}{
+		"log":   log,
+		"info":  log,
+		"warn":  log,
+		"error": log,
+	}
+}
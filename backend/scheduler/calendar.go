@@ -0,0 +1,126 @@
+package scheduler
+
+import (
+	"bufio"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// Calendar is a named list of dates on which scheduled runs are skipped,
+// typically public holidays.
+type Calendar struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name"`
+	Dates []string `json:"dates"`
+}
+
+func (c *Calendar) contains(date string) bool {
+	i := sort.SearchStrings(c.Dates, date)
+	return i < len(c.Dates) && c.Dates[i] == date
+}
+
+// normalizeDates validates, sorts and dedupes YYYY-MM-DD dates.
+func normalizeDates(dates []string) ([]string, error) {
+	seen := make(map[string]bool, len(dates))
+	out := make([]string, 0, len(dates))
+	for _, d := range dates {
+		if _, err := time.Parse(dateLayout, d); err != nil {
+			return nil, errors.New("invalid date: " + d)
+		}
+		if !seen[d] {
+			seen[d] = true
+			out = append(out, d)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// ParseICal returns the days covered by the events of an iCalendar file.
+// All-day events cover DTSTART up to, but excluding, DTEND; timed events
+// cover the day they start on. Recurrence rules are not expanded, which
+// matches how holiday calendars list one event per occurrence.
+func ParseICal(data string) ([]string, error) {
+	var dates []string
+	var inEvent bool
+	var start, end string
+
+	for _, line := range unfoldICal(data) {
+		name, value := splitICalLine(line)
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			inEvent, start, end = true, "", ""
+		case name == "END" && value == "VEVENT":
+			inEvent = false
+			days, err := eventDays(start, end)
+			if err != nil {
+				return nil, err
+			}
+			dates = append(dates, days...)
+		case inEvent && name == "DTSTART":
+			start = value
+		case inEvent && name == "DTEND":
+			end = value
+		}
+	}
+	if len(dates) == 0 {
+		return nil, errors.New("no events found in calendar")
+	}
+	return normalizeDates(dates)
+}
+
+// unfoldICal joins continuation lines, which start with a space or tab.
+func unfoldICal(data string) []string {
+	var lines []string
+	sc := bufio.NewScanner(strings.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// splitICalLine returns the property name without its parameters and the
+// value, e.g. "DTSTART;VALUE=DATE:20241225" gives DTSTART and 20241225.
+func splitICalLine(line string) (string, string) {
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return "", ""
+	}
+	name := line[:i]
+	if j := strings.Index(name, ";"); j >= 0 {
+		name = name[:j]
+	}
+	return strings.ToUpper(name), strings.TrimSpace(line[i+1:])
+}
+
+func eventDays(start, end string) ([]string, error) {
+	if len(start) < 8 {
+		return nil, errors.New("event without a valid DTSTART")
+	}
+	first, err := time.Parse("20060102", start[:8])
+	if err != nil {
+		return nil, err
+	}
+	// Only all-day events span several days; their DTEND is exclusive.
+	last := first
+	if len(start) == 8 && len(end) == 8 {
+		if e, err := time.Parse("20060102", end); err == nil && e.After(first) {
+			last = e.AddDate(0, 0, -1)
+		}
+	}
+	var days []string
+	for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+		days = append(days, d.Format(dateLayout))
+	}
+	return days, nil
+}
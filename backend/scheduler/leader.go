@@ -0,0 +1,146 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// When several servers share the Redis database only one of them, the
+// leader, triggers schedules. It holds the key leaderKey for leaderTTL and
+// renews it every leaderRenew; when it dies the key expires and another
+// server takes over.
+const (
+	leaderKey   = "scheduler_leader"
+	leaderTTL   = 15 * time.Second
+	leaderRenew = 5 * time.Second
+	// fireClaimTTL is how long the claim on one trigger of a schedule is
+	// kept, which covers a leader change right as the schedule fires.
+	fireClaimTTL = 10 * time.Minute
+)
+
+var (
+	// renewLeader extends the lease KEYS[1] if ARGV[1] still holds it.
+	renewLeader = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	// resignLeader deletes the lease KEYS[1] if ARGV[1] still holds it.
+	resignLeader = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+func nodeName() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "umba"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// IsLeader reports whether this server triggers the schedules.
+func (s *Scheduler) IsLeader() bool {
+	s.leaderMu.Lock()
+	defer s.leaderMu.Unlock()
+	return time.Now().Before(s.leaseUntil)
+}
+
+// Leader returns the name of the server triggering the schedules, if any.
+func (s *Scheduler) Leader() (string, error) {
+	leader, err := s.db.Get(context.Background(), leaderKey).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return leader, err
+}
+
+// elect campaigns for leadership until ctx ends.
+func (s *Scheduler) elect(ctx context.Context) {
+	ticker := time.NewTicker(leaderRenew)
+	defer ticker.Stop()
+	for {
+		s.campaign(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resign gives up leadership so another server takes over right away
+// rather than once the lease expires.
+func (s *Scheduler) resign() {
+	if !s.IsLeader() {
+		return
+	}
+	s.setLease(time.Time{})
+	if err := resignLeader.Run(context.Background(), s.db, []string{leaderKey}, s.node).Err(); err != nil {
+		s.logger.Warn("Failed to resign scheduler leadership", zap.Error(err))
+	}
+}
+
+// campaign takes or renews the lease. The lease is counted from before the
+// request, so this server stops triggering before the key can expire.
+func (s *Scheduler) campaign(ctx context.Context) {
+	start := time.Now()
+	wasLeader := s.IsLeader()
+	var held bool
+	var err error
+	if wasLeader {
+		var n int
+		n, err = renewLeader.Run(ctx, s.db, []string{leaderKey}, s.node, leaderTTL.Milliseconds()).Int()
+		held = n == 1
+	} else {
+		held, err = s.db.SetNX(ctx, leaderKey, s.node, leaderTTL).Result()
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			s.logger.Warn("Failed to reach Redis for scheduler leadership", zap.Error(err))
+		}
+		// Keep the current lease: it runs out on its own.
+		return
+	}
+	if held {
+		s.setLease(start.Add(leaderTTL))
+		if !wasLeader {
+			s.logger.Info("Became scheduler leader", zap.String("node", s.node))
+		}
+		return
+	}
+	s.setLease(time.Time{})
+	if wasLeader {
+		s.logger.Warn("Lost scheduler leadership", zap.String("node", s.node))
+	}
+}
+
+func (s *Scheduler) setLease(until time.Time) {
+	s.leaderMu.Lock()
+	s.leaseUntil = until
+	s.leaderMu.Unlock()
+}
+
+// claimFire reports whether this server is the one to start the run of a
+// schedule due at t: the leader, and the first to claim that trigger.
+func (s *Scheduler) claimFire(scheduleID string, t time.Time) bool {
+	if !s.IsLeader() {
+		return false
+	}
+	// Cron fires on the minute; servers whose clocks drift a little still
+	// agree on which trigger this is.
+	claim := fmt.Sprintf("schedule_fire:%s:%d", scheduleID, t.Truncate(time.Minute).Unix())
+	ok, err := s.db.SetNX(context.Background(), claim, s.node, fireClaimTTL).Result()
+	if err != nil {
+		s.logger.Error("Failed to claim scheduled run", zap.String("scheduleID", scheduleID), zap.Error(err))
+		return false
+	}
+	return ok
+}
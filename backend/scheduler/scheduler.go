@@ -0,0 +1,531 @@
+// Package scheduler runs flows on cron schedules evaluated in an explicit
+// time zone, skipping weekends and calendar dates when asked to.
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"auto/flow"
+	"auto/model"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+)
+
+// Overlap policies say what a schedule does when it triggers while its
+// previous run is still going: start a run that queues for the instance
+// behind it (the default), skip the trigger, or cancel the previous run.
+const (
+	OverlapQueue        = "queue"
+	OverlapSkip         = "skip"
+	OverlapKillPrevious = "kill-previous"
+)
+
+// durationWindow is how far back the runs of a flow go when comparing its
+// duration with the interval of a schedule.
+const durationWindow = 7 * 24 * time.Hour
+
+// Schedule runs a flow whenever its cron expression matches in Timezone.
+type Schedule struct {
+	ID              string    `json:"id"`
+	FlowID          string    `json:"flow_id"`
+	Cron            string    `json:"cron"`
+	Timezone        string    `json:"timezone"`
+	ExcludeWeekends bool      `json:"exclude_weekends"`
+	Calendars       []string  `json:"calendars"`
+	Enabled         bool      `json:"enabled"`
+	OverlapPolicy   string    `json:"overlap_policy,omitempty"`
+	NextRun         time.Time `json:"next_run,omitempty"`
+	// Warnings are given when the schedule is saved, e.g. when runs of its
+	// flow take longer on average than the time between triggers.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Scheduler keeps schedules and calendars in Redis and triggers flow runs.
+type Scheduler struct {
+	cron      *cron.Cron
+	entries   map[string]cron.EntryID
+	mu        sync.RWMutex
+	db        *redis.Client
+	flows     *flow.Manager
+	instances *model.InstanceManager
+	logger    *zap.Logger
+
+	// running holds the cancel functions of the runs each schedule has
+	// going, by sequence number.
+	runMu   sync.Mutex
+	running map[string]map[uint64]context.CancelFunc
+	runSeq  uint64
+
+	// node names this server in the leader election.
+	node       string
+	leaderMu   sync.Mutex
+	leaseUntil time.Time
+	stopElect  context.CancelFunc
+}
+
+func NewScheduler(db *redis.Client, flows *flow.Manager, instances *model.InstanceManager, logger *zap.Logger) *Scheduler {
+	return &Scheduler{
+		cron:      cron.New(),
+		entries:   make(map[string]cron.EntryID),
+		db:        db,
+		flows:     flows,
+		instances: instances,
+		logger:    logger,
+		running:   make(map[string]map[uint64]context.CancelFunc),
+		node:      nodeName(),
+	}
+}
+
+// Start registers the stored schedules and starts triggering them once
+// this server is elected leader.
+func (s *Scheduler) Start() error {
+	schedules, err := s.GetSchedules()
+	if err != nil {
+		return err
+	}
+	for _, sch := range schedules {
+		if err := s.register(sch); err != nil {
+			s.logger.Error("Failed to register schedule", zap.String("scheduleID", sch.ID), zap.Error(err))
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopElect = cancel
+	go s.elect(ctx)
+	s.cron.Start()
+	return nil
+}
+
+// Reload registers the stored schedules again, dropping entries of
+// schedules that no longer exist, e.g. after a restore.
+func (s *Scheduler) Reload() error {
+	schedules, err := s.GetSchedules()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	for id, entryID := range s.entries {
+		s.cron.Remove(entryID)
+		delete(s.entries, id)
+	}
+	s.mu.Unlock()
+	for _, sch := range schedules {
+		if err := s.register(sch); err != nil {
+			s.logger.Error("Failed to register schedule", zap.String("scheduleID", sch.ID), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// Stop stops triggering schedules and hands leadership over; runs already
+// started keep going.
+func (s *Scheduler) Stop() {
+	s.cron.Stop()
+	if s.stopElect != nil {
+		s.stopElect()
+		s.resign()
+	}
+}
+
+func (s *Scheduler) location(sch *Schedule) (*time.Location, error) {
+	if sch.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(sch.Timezone)
+}
+
+func (s *Scheduler) spec(sch *Schedule) (string, error) {
+	loc, err := s.location(sch)
+	if err != nil {
+		return "", fmt.Errorf("invalid timezone: %w", err)
+	}
+	return fmt.Sprintf("CRON_TZ=%s %s", loc.String(), sch.Cron), nil
+}
+
+func (s *Scheduler) validate(sch *Schedule) error {
+	if sch.FlowID == "" {
+		return errors.New("flow_id is required")
+	}
+	spec, err := s.spec(sch)
+	if err != nil {
+		return err
+	}
+	if _, err := cron.ParseStandard(spec); err != nil {
+		return fmt.Errorf("invalid cron expression: %w", err)
+	}
+	switch sch.OverlapPolicy {
+	case "", OverlapQueue, OverlapSkip, OverlapKillPrevious:
+	default:
+		return fmt.Errorf("invalid overlap_policy: %q", sch.OverlapPolicy)
+	}
+	for _, id := range sch.Calendars {
+		if _, err := s.GetCalendar(id); err != nil {
+			return fmt.Errorf("calendar %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// register (re)adds the cron entry of a schedule.
+func (s *Scheduler) register(sch *Schedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.entries[sch.ID]; ok {
+		s.cron.Remove(id)
+		delete(s.entries, sch.ID)
+	}
+	if !sch.Enabled {
+		return nil
+	}
+	spec, err := s.spec(sch)
+	if err != nil {
+		return err
+	}
+	scheduleID := sch.ID
+	entryID, err := s.cron.AddFunc(spec, func() { s.trigger(scheduleID) })
+	if err != nil {
+		return err
+	}
+	s.entries[sch.ID] = entryID
+	return nil
+}
+
+// trigger runs the flow of a schedule unless today is excluded or another
+// server runs it. The schedule is re-read so edits made since registration
+// apply.
+func (s *Scheduler) trigger(scheduleID string) {
+	if !s.claimFire(scheduleID, time.Now()) {
+		return
+	}
+	sch, err := s.GetSchedule(scheduleID)
+	if err != nil {
+		s.logger.Error("Failed to load schedule", zap.String("scheduleID", scheduleID), zap.Error(err))
+		return
+	}
+	if excluded, reason := s.excluded(sch, time.Now()); excluded {
+		s.logger.Info("Skipping scheduled run", zap.String("scheduleID", sch.ID), zap.String("reason", reason))
+		return
+	}
+	ctx, done, ok := s.startRun(sch)
+	if !ok {
+		s.logger.Warn("Skipping scheduled run, the previous one is still going", zap.String("scheduleID", sch.ID), zap.String("flowID", sch.FlowID))
+		return
+	}
+	defer done()
+	s.logger.Info("Starting scheduled run", zap.String("scheduleID", sch.ID), zap.String("flowID", sch.FlowID))
+	if err := s.flows.ExecuteFlowContext(ctx, sch.FlowID, s.instances, nil, model.PriorityBatch); err != nil {
+		s.logger.Error("Scheduled run failed", zap.String("scheduleID", sch.ID), zap.String("flowID", sch.FlowID), zap.Error(err))
+	}
+}
+
+// startRun applies the overlap policy of sch to a new run. It reports
+// false when the run is to be skipped; otherwise it returns the context of
+// the run and a function to call when it ends.
+func (s *Scheduler) startRun(sch *Schedule) (context.Context, func(), bool) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	runs := s.running[sch.ID]
+	if len(runs) > 0 {
+		switch sch.OverlapPolicy {
+		case OverlapSkip:
+			return nil, nil, false
+		case OverlapKillPrevious:
+			s.logger.Warn("Cancelling the previous scheduled run", zap.String("scheduleID", sch.ID), zap.String("flowID", sch.FlowID))
+			for _, cancel := range runs {
+				cancel()
+			}
+		}
+	}
+	if runs == nil {
+		runs = make(map[uint64]context.CancelFunc)
+		s.running[sch.ID] = runs
+	}
+	s.runSeq++
+	seq := s.runSeq
+	ctx, cancel := context.WithCancel(context.Background())
+	runs[seq] = cancel
+	return ctx, func() {
+		cancel()
+		s.runMu.Lock()
+		delete(runs, seq)
+		if len(runs) == 0 {
+			delete(s.running, sch.ID)
+		}
+		s.runMu.Unlock()
+	}, true
+}
+
+// warn sets the warnings of a saved schedule and logs them.
+func (s *Scheduler) warn(sch *Schedule) {
+	sch.Warnings = s.overlapWarnings(sch)
+	for _, w := range sch.Warnings {
+		s.logger.Warn("Schedule warning", zap.String("scheduleID", sch.ID), zap.String("warning", w))
+	}
+}
+
+// overlapWarnings warns when runs of the flow of sch have lately taken
+// longer on average than the shortest time between two of its triggers, so
+// that runs would overlap.
+func (s *Scheduler) overlapWarnings(sch *Schedule) []string {
+	stats, err := s.flows.GetStats(sch.FlowID, durationWindow)
+	if err != nil || stats.Runs == 0 {
+		return nil
+	}
+	spec, err := s.spec(sch)
+	if err != nil {
+		return nil
+	}
+	parsed, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil
+	}
+	var interval time.Duration
+	prev := parsed.Next(time.Now())
+	for i := 0; i < 100 && !prev.IsZero(); i++ {
+		next := parsed.Next(prev)
+		if next.IsZero() {
+			break
+		}
+		if gap := next.Sub(prev); interval == 0 || gap < interval {
+			interval = gap
+		}
+		prev = next
+	}
+	avg := time.Duration(stats.AvgDurationMs) * time.Millisecond
+	if interval == 0 || avg <= interval {
+		return nil
+	}
+	outcome := "queue behind the previous run"
+	switch sch.OverlapPolicy {
+	case OverlapSkip:
+		outcome = "be skipped"
+	case OverlapKillPrevious:
+		outcome = "cancel the previous run"
+	}
+	return []string{fmt.Sprintf("runs of flow %s took %s on average over the last %d runs, longer than the %s between triggers; overlapping triggers will %s",
+		sch.FlowID, avg.Round(time.Second), stats.Runs, interval, outcome)}
+}
+
+// excluded reports whether t falls on an excluded day, judged in the time
+// zone of the schedule.
+func (s *Scheduler) excluded(sch *Schedule, t time.Time) (bool, string) {
+	loc, err := s.location(sch)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := t.In(loc)
+	if sch.ExcludeWeekends && (local.Weekday() == time.Saturday || local.Weekday() == time.Sunday) {
+		return true, "weekend"
+	}
+	date := local.Format(dateLayout)
+	for _, id := range sch.Calendars {
+		cal, err := s.GetCalendar(id)
+		if err != nil {
+			s.logger.Warn("Failed to load calendar", zap.String("calendarID", id), zap.Error(err))
+			continue
+		}
+		if cal.contains(date) {
+			return true, "calendar " + cal.Name
+		}
+	}
+	return false, ""
+}
+
+func (s *Scheduler) CreateSchedule(sch Schedule) (*Schedule, error) {
+	sch.ID = uuid.New().String()
+	return s.PutSchedule(sch)
+}
+
+// PutSchedule creates or replaces the schedule with the ID sch carries,
+// such as one from a declarative spec.
+func (s *Scheduler) PutSchedule(sch Schedule) (*Schedule, error) {
+	if sch.ID == "" {
+		return nil, errors.New("schedule id is required")
+	}
+	if err := s.validate(&sch); err != nil {
+		return nil, err
+	}
+	if err := s.save(&sch); err != nil {
+		return nil, err
+	}
+	if err := s.register(&sch); err != nil {
+		return nil, err
+	}
+	s.warn(&sch)
+	return s.withNextRun(&sch), nil
+}
+
+func (s *Scheduler) UpdateSchedule(id string, sch Schedule) (*Schedule, error) {
+	if _, err := s.GetSchedule(id); err != nil {
+		return nil, err
+	}
+	sch.ID = id
+	if err := s.validate(&sch); err != nil {
+		return nil, err
+	}
+	if err := s.save(&sch); err != nil {
+		return nil, err
+	}
+	if err := s.register(&sch); err != nil {
+		return nil, err
+	}
+	s.warn(&sch)
+	return s.withNextRun(&sch), nil
+}
+
+func (s *Scheduler) DeleteSchedule(id string) error {
+	n, err := s.db.HDel(context.Background(), "schedules", id).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("schedule not found")
+	}
+	s.mu.Lock()
+	if entryID, ok := s.entries[id]; ok {
+		s.cron.Remove(entryID)
+		delete(s.entries, id)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Scheduler) GetSchedule(id string) (*Schedule, error) {
+	data, err := s.db.HGet(context.Background(), "schedules", id).Bytes()
+	if err == redis.Nil {
+		return nil, errors.New("schedule not found")
+	} else if err != nil {
+		return nil, err
+	}
+	var sch Schedule
+	if err := json.Unmarshal(data, &sch); err != nil {
+		return nil, err
+	}
+	return s.withNextRun(&sch), nil
+}
+
+func (s *Scheduler) GetSchedules() ([]*Schedule, error) {
+	all, err := s.db.HGetAll(context.Background(), "schedules").Result()
+	if err != nil {
+		return nil, err
+	}
+	schedules := make([]*Schedule, 0, len(all))
+	for _, data := range all {
+		var sch Schedule
+		if err := json.Unmarshal([]byte(data), &sch); err != nil {
+			continue
+		}
+		schedules = append(schedules, s.withNextRun(&sch))
+	}
+	return schedules, nil
+}
+
+func (s *Scheduler) save(sch *Schedule) error {
+	stored := *sch
+	stored.NextRun = time.Time{}
+	stored.Warnings = nil
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return s.db.HSet(context.Background(), "schedules", sch.ID, data).Err()
+}
+
+// withNextRun fills in the next time the schedule triggers, skipping
+// excluded days.
+func (s *Scheduler) withNextRun(sch *Schedule) *Schedule {
+	sch.NextRun = time.Time{}
+	if !sch.Enabled {
+		return sch
+	}
+	spec, err := s.spec(sch)
+	if err != nil {
+		return sch
+	}
+	parsed, err := cron.ParseStandard(spec)
+	if err != nil {
+		return sch
+	}
+	next := parsed.Next(time.Now())
+	for i := 0; i < 366 && !next.IsZero(); i++ {
+		if excluded, _ := s.excluded(sch, next); !excluded {
+			sch.NextRun = next
+			break
+		}
+		next = parsed.Next(next)
+	}
+	return sch
+}
+
+// CreateCalendar stores a calendar from explicit dates, an iCalendar file
+// or both.
+func (s *Scheduler) CreateCalendar(name string, dates []string, ical string) (*Calendar, error) {
+	if ical != "" {
+		parsed, err := ParseICal(ical)
+		if err != nil {
+			return nil, fmt.Errorf("invalid calendar: %w", err)
+		}
+		dates = append(dates, parsed...)
+	}
+	dates, err := normalizeDates(dates)
+	if err != nil {
+		return nil, err
+	}
+	cal := &Calendar{ID: uuid.New().String(), Name: name, Dates: dates}
+	data, err := json.Marshal(cal)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.HSet(context.Background(), "calendars", cal.ID, data).Err(); err != nil {
+		return nil, err
+	}
+	return cal, nil
+}
+
+func (s *Scheduler) GetCalendar(id string) (*Calendar, error) {
+	data, err := s.db.HGet(context.Background(), "calendars", id).Bytes()
+	if err == redis.Nil {
+		return nil, errors.New("calendar not found")
+	} else if err != nil {
+		return nil, err
+	}
+	var cal Calendar
+	if err := json.Unmarshal(data, &cal); err != nil {
+		return nil, err
+	}
+	return &cal, nil
+}
+
+func (s *Scheduler) GetCalendars() ([]*Calendar, error) {
+	all, err := s.db.HGetAll(context.Background(), "calendars").Result()
+	if err != nil {
+		return nil, err
+	}
+	calendars := make([]*Calendar, 0, len(all))
+	for _, data := range all {
+		var cal Calendar
+		if err := json.Unmarshal([]byte(data), &cal); err != nil {
+			continue
+		}
+		calendars = append(calendars, &cal)
+	}
+	return calendars, nil
+}
+
+func (s *Scheduler) DeleteCalendar(id string) error {
+	n, err := s.db.HDel(context.Background(), "calendars", id).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("calendar not found")
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+// Package schema checks extracted data against the subset of JSON Schema
+// flows use to describe their output: type, properties, required,
+// additionalProperties, items, minItems, enum and pattern.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Schema describes the expected shape of a value.
+type Schema struct {
+	Type                 Types              `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+}
+
+// Types lists the allowed JSON types. In JSON it is a single type name or
+// an array of them.
+type Types []string
+
+func (t *Types) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*t = Types{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("type must be a string or an array of strings")
+	}
+	*t = many
+	return nil
+}
+
+func (t Types) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+var knownTypes = map[string]bool{
+	"string": true, "number": true, "integer": true, "boolean": true,
+	"object": true, "array": true, "null": true,
+}
+
+// Violation is one way a value does not match its schema. Path locates the
+// value, as in "$.items[2].price".
+type Violation struct {
+	Path     string `json:"path"`
+	Kind     string `json:"kind"`
+	Message  string `json:"message"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// Kinds of violation.
+const (
+	KindMissing    = "missing"
+	KindType       = "type"
+	KindAdditional = "additional"
+	KindMinItems   = "min_items"
+	KindEnum       = "enum"
+	KindPattern    = "pattern"
+)
+
+// Check reports whether s is usable: known types and valid patterns.
+func Check(s *Schema) error {
+	return check(s, "$")
+}
+
+func check(s *Schema, path string) error {
+	if s == nil {
+		return nil
+	}
+	for _, t := range s.Type {
+		if !knownTypes[t] {
+			return fmt.Errorf("%s: unknown type %q", path, t)
+		}
+	}
+	if s.Pattern != "" {
+		if _, err := regexp.Compile(s.Pattern); err != nil {
+			return fmt.Errorf("%s: invalid pattern: %w", path, err)
+		}
+	}
+	for name, prop := range s.Properties {
+		if err := check(prop, path+"."+name); err != nil {
+			return err
+		}
+	}
+	return check(s.Items, path+"[]")
+}
+
+// Validate returns every violation of s by v, a value decoded from JSON.
+func (s *Schema) Validate(v interface{}) []Violation {
+	var out []Violation
+	s.validate(v, "$", &out)
+	return out
+}
+
+func (s *Schema) validate(v interface{}, path string, out *[]Violation) {
+	if s == nil {
+		return
+	}
+	actual := typeOf(v)
+	if len(s.Type) > 0 && !s.allows(v, actual) {
+		*out = append(*out, Violation{
+			Path:     path,
+			Kind:     KindType,
+			Message:  fmt.Sprintf("expected %s, got %s", strings.Join(s.Type, " or "), actual),
+			Expected: strings.Join(s.Type, ","),
+			Actual:   actual,
+		})
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		*out = append(*out, Violation{Path: path, Kind: KindEnum, Message: fmt.Sprintf("value %v is not allowed", v)})
+	}
+
+	switch v := v.(type) {
+	case string:
+		if s.Pattern != "" {
+			if re, err := regexp.Compile(s.Pattern); err == nil && !re.MatchString(v) {
+				*out = append(*out, Violation{Path: path, Kind: KindPattern, Message: fmt.Sprintf("does not match %s", s.Pattern), Expected: s.Pattern})
+			}
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			*out = append(*out, Violation{
+				Path:     path,
+				Kind:     KindMinItems,
+				Message:  fmt.Sprintf("expected at least %d items, got %d", *s.MinItems, len(v)),
+				Expected: fmt.Sprint(*s.MinItems),
+				Actual:   fmt.Sprint(len(v)),
+			})
+		}
+		for i, item := range v {
+			s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), out)
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*out = append(*out, Violation{Path: path + "." + name, Kind: KindMissing, Message: "required field is missing"})
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					*out = append(*out, Violation{Path: path + "." + name, Kind: KindAdditional, Message: "field is not in the schema"})
+				}
+				continue
+			}
+			prop.validate(v[name], path+"."+name, out)
+		}
+	}
+}
+
+func (s *Schema) allows(v interface{}, actual string) bool {
+	for _, t := range s.Type {
+		if t == actual || t == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf names the JSON type of v; whole numbers are integers.
+func typeOf(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func inEnum(enum []interface{}, v interface{}) bool {
+	for _, e := range enum {
+		if reflect.DeepEqual(e, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+// Package secrets keeps named credentials, such as the API tokens output
+// destinations authenticate with, so flows refer to them by name instead
+// of holding them.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"auto/apperr"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// key is the Redis hash holding the secret values by name.
+const key = "secrets"
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Store keeps secrets in Redis. Their values can be set and used, never
+// listed.
+type Store struct {
+	db *redis.Client
+}
+
+func NewStore(db *redis.Client) *Store {
+	return &Store{db: db}
+}
+
+// Set stores the value of the secret name, replacing any previous one.
+func (s *Store) Set(ctx context.Context, name, value string) error {
+	if !validName.MatchString(name) {
+		return apperr.New(apperr.CodeInvalidRequest, fmt.Sprintf("invalid secret name %q: use letters, digits, _, . and -", name))
+	}
+	return s.db.HSet(ctx, key, name, value).Err()
+}
+
+// Get returns the value of the secret name.
+func (s *Store) Get(ctx context.Context, name string) (string, error) {
+	value, err := s.db.HGet(ctx, key, name).Result()
+	if err == redis.Nil {
+		return "", apperr.New(apperr.CodeNotFound, fmt.Sprintf("secret not found: %s", name))
+	}
+	return value, err
+}
+
+// Delete removes the secret name.
+func (s *Store) Delete(ctx context.Context, name string) error {
+	n, err := s.db.HDel(ctx, key, name).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return apperr.New(apperr.CodeNotFound, fmt.Sprintf("secret not found: %s", name))
+	}
+	return nil
+}
+
+// Names returns the names of the stored secrets, sorted.
+func (s *Store) Names(ctx context.Context) ([]string, error) {
+	names, err := s.db.HKeys(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
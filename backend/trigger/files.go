@@ -0,0 +1,425 @@
+package trigger
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"auto/pipeline"
+
+	"go.uber.org/zap"
+)
+
+// TypeFile triggers watch a directory or S3 prefix for CSV and JSON files,
+// such as nightly exports, and run their flow for each file or each row of
+// it. Handled files are moved to an archive.
+const TypeFile = "file"
+
+// DefaultPollSeconds is how often file triggers look for new files.
+const DefaultPollSeconds = 30
+
+// settleTime is how long a local file must be left alone before it is
+// picked up, so files still being written are not read half way.
+const settleTime = 10 * time.Second
+
+// claimTTL bounds how long a file stays claimed by a server that stopped
+// while handling it.
+const claimTTL = time.Hour
+
+// FileWatch is where a file trigger finds its files: the top level of Dir,
+// or the objects directly under the prefix of S3. Files are moved to
+// Archive once handled, or to its "failed" folder when a run failed, next
+// to a "<file>.errors.json" report. Archive defaults to "processed" in the
+// watched directory or prefix.
+//
+// Dir and Archive must be inside the file root of the manager, relative
+// paths being taken from it; see SetFileRoot.
+//
+// Without PerRow a file starts one run with the parameters "file" and
+// "rows", all of its rows. With PerRow each row starts a run with the row
+// as its payload, plus "file" and "row", its number, unless the row has
+// such columns.
+type FileWatch struct {
+	Dir         string               `json:"dir,omitempty"`
+	S3          *pipeline.S3Location `json:"s3,omitempty"`
+	Archive     string               `json:"archive,omitempty"`
+	PerRow      bool                 `json:"per_row,omitempty"`
+	PollSeconds int                  `json:"poll_seconds,omitempty"`
+}
+
+func (w *FileWatch) validate(root string) error {
+	if w == nil {
+		return errors.New("files is required for file triggers")
+	}
+	if (w.Dir == "") == (w.S3 == nil) {
+		return errors.New("file triggers watch either a dir or an s3 location")
+	}
+	if w.S3 != nil && w.S3.Bucket == "" {
+		return errors.New("s3 bucket is required")
+	}
+	if w.Dir != "" {
+		if _, err := w.dirFiles(root); err != nil {
+			return err
+		}
+	}
+	if w.PollSeconds < 0 {
+		return errors.New("poll_seconds must not be negative")
+	}
+	return nil
+}
+
+// fileFailure is a failed run in the error report of a file.
+type fileFailure struct {
+	Row      int    `json:"row,omitempty"`
+	Error    string `json:"error"`
+	Attempts int    `json:"attempts,omitempty"`
+}
+
+// fileSource lists, reads and archives the files of a trigger.
+type fileSource interface {
+	list(ctx context.Context) ([]string, error)
+	read(ctx context.Context, name string) ([]byte, error)
+	archive(ctx context.Context, name string, failed bool, report []byte) error
+}
+
+func (m *Manager) fileSource(w *FileWatch) (fileSource, error) {
+	if w.S3 != nil {
+		loc := *w.S3
+		loc.Prefix = folder(loc.Prefix)
+		archive := folder(w.Archive)
+		if archive == "" {
+			archive = loc.Prefix + "processed/"
+		}
+		return &s3Files{loc: loc, archivePrefix: archive, client: m.client}, nil
+	}
+	return w.dirFiles(m.fileRoot)
+}
+
+// dirFiles resolves Dir and Archive, following symbolic links, and checks
+// that both are inside root. Without a root the server reads no local
+// files at all.
+func (w *FileWatch) dirFiles(root string) (*dirFiles, error) {
+	if root == "" {
+		return nil, errors.New("file triggers cannot watch directories: no TRIGGER_FILE_ROOT is configured")
+	}
+	root, err := resolvePath(root, ".")
+	if err != nil {
+		return nil, fmt.Errorf("invalid file root: %w", err)
+	}
+	dir, err := resolvePath(root, w.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dir: %w", err)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("dir is not a directory: %s", w.Dir)
+	}
+	archive := filepath.Join(dir, "processed")
+	if w.Archive != "" {
+		if archive, err = resolvePath(root, w.Archive); err != nil {
+			return nil, fmt.Errorf("invalid archive: %w", err)
+		}
+	}
+	for _, p := range []string{dir, archive} {
+		if rel, err := filepath.Rel(root, p); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("%s is outside of the file root %s", p, root)
+		}
+	}
+	return &dirFiles{dir: dir, archiveDir: archive}, nil
+}
+
+// resolvePath returns the absolute path of p, taken from root when it is
+// relative, with the symbolic links of the part of it that exists
+// resolved, so that a link cannot lead out of the root. The rest, such as
+// an archive not made yet, is created by the server itself.
+func resolvePath(root, p string) (string, error) {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(root, p)
+	}
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	existing, rest := p, ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return "", err
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+}
+
+// watchFiles polls the source of t until ctx ends.
+func (m *Manager) watchFiles(ctx context.Context, t *Trigger) {
+	src, err := m.fileSource(t.Files)
+	if err != nil {
+		m.logger.Error("Not watching files", zap.String("triggerID", t.ID), zap.Error(err))
+		return
+	}
+	interval := time.Duration(t.Files.PollSeconds) * time.Second
+	if interval == 0 {
+		interval = DefaultPollSeconds * time.Second
+	}
+	for {
+		m.scanFiles(ctx, t, src)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// scanFiles handles the files waiting in src, one at a time. A file is
+// claimed in Redis first, so servers watching the same place split them.
+func (m *Manager) scanFiles(ctx context.Context, t *Trigger, src fileSource) {
+	logger := m.logger.With(zap.String("triggerID", t.ID))
+	names, err := src.list(ctx)
+	if err != nil {
+		logger.Error("Failed to list files", zap.Error(err))
+		return
+	}
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return
+		}
+		claim := "trigger_claims:" + t.ID + ":" + name
+		ok, err := m.db.SetNX(ctx, claim, m.consumer, claimTTL).Result()
+		if err != nil {
+			logger.Error("Failed to claim file", zap.String("file", name), zap.Error(err))
+			return
+		} else if !ok {
+			continue
+		}
+		if err := m.processFile(ctx, t, src, name); err != nil {
+			// Left claimed; it is picked up again once the claim expires.
+			logger.Error("Failed to process file", zap.String("file", name), zap.Error(err))
+			continue
+		}
+		m.db.Del(ctx, claim)
+	}
+}
+
+// processFile runs the flow of t for the file and archives it. It fails
+// only when the file could not be archived or ctx ended, leaving the file
+// where it is.
+func (m *Manager) processFile(ctx context.Context, t *Trigger, src fileSource, name string) error {
+	m.logger.Info("Processing file", zap.String("triggerID", t.ID), zap.String("file", name))
+	var failures []fileFailure
+	data, err := src.read(ctx, name)
+	if err != nil {
+		return err
+	}
+	rows, err := decodeFile(name, data)
+	if err != nil {
+		failures = append(failures, fileFailure{Error: err.Error()})
+	} else if t.Files.PerRow {
+		for i, row := range rows {
+			params := t.params(map[string]interface{}(row))
+			setDefault(params, "file", name)
+			setDefault(params, "row", i+1)
+			attempts, err := m.execute(ctx, t, params)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				failures = append(failures, fileFailure{Row: i + 1, Error: err.Error(), Attempts: attempts})
+			}
+		}
+	} else {
+		attempts, err := m.execute(ctx, t, t.params(map[string]interface{}{"file": name, "rows": rows}))
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			failures = append(failures, fileFailure{Error: err.Error(), Attempts: attempts})
+		}
+	}
+
+	var report []byte
+	if len(failures) > 0 {
+		m.logger.Warn("File had failed runs", zap.String("triggerID", t.ID), zap.String("file", name), zap.Int("failures", len(failures)))
+		report, _ = json.MarshalIndent(map[string]interface{}{
+			"trigger_id": t.ID,
+			"flow_id":    t.FlowID,
+			"file":       name,
+			"failures":   failures,
+		}, "", "  ")
+	}
+	return src.archive(ctx, name, len(failures) > 0, report)
+}
+
+// decodeFile parses a CSV file with a header row, or a JSON file holding
+// an array of objects or a single object.
+func decodeFile(name string, data []byte) ([]pipeline.Record, error) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".csv":
+		r := csv.NewReader(bytes.NewReader(data))
+		r.FieldsPerRecord = -1
+		lines, err := r.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		if len(lines) == 0 {
+			return nil, nil
+		}
+		header := lines[0]
+		rows := make([]pipeline.Record, 0, len(lines)-1)
+		for _, line := range lines[1:] {
+			row := make(pipeline.Record, len(header))
+			for i, column := range header {
+				if i < len(line) {
+					row[column] = line[i]
+				}
+			}
+			rows = append(rows, row)
+		}
+		return rows, nil
+	default:
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+			var row pipeline.Record
+			if err := json.Unmarshal(trimmed, &row); err != nil {
+				return nil, fmt.Errorf("invalid JSON: %w", err)
+			}
+			return []pipeline.Record{row}, nil
+		}
+		rows, _, err := pipeline.DecodeRecords(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return rows, nil
+	}
+}
+
+func watchedFile(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".csv" || ext == ".json"
+}
+
+// archiveName prefixes a handled file with the time, so exports that reuse
+// their name every night do not overwrite each other in the archive.
+func archiveName(name string) string {
+	return time.Now().UTC().Format("20060102T150405") + "-" + name
+}
+
+// folder makes a non-empty key prefix end in a slash.
+func folder(prefix string) string {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+func setDefault(params map[string]interface{}, key string, value interface{}) {
+	if _, ok := params[key]; !ok {
+		params[key] = value
+	}
+}
+
+type dirFiles struct {
+	dir        string
+	archiveDir string
+}
+
+func (d *dirFiles) list(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !watchedFile(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < settleTime {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+func (d *dirFiles) read(ctx context.Context, name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(d.dir, name))
+}
+
+func (d *dirFiles) archive(ctx context.Context, name string, failed bool, report []byte) error {
+	dest := d.archiveDir
+	if failed {
+		dest = filepath.Join(dest, "failed")
+	}
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	archived := archiveName(name)
+	if report != nil {
+		if err := os.WriteFile(filepath.Join(dest, archived+".errors.json"), report, 0644); err != nil {
+			return err
+		}
+	}
+	return os.Rename(filepath.Join(d.dir, name), filepath.Join(dest, archived))
+}
+
+type s3Files struct {
+	loc           pipeline.S3Location
+	archivePrefix string
+	client        *http.Client
+}
+
+func (s *s3Files) list(ctx context.Context) ([]string, error) {
+	objects, err := s.loc.List(ctx, s.client)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, o := range objects {
+		name := strings.TrimPrefix(o.Key, s.loc.Prefix)
+		if name == "" || strings.Contains(name, "/") || !watchedFile(name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *s3Files) read(ctx context.Context, name string) ([]byte, error) {
+	return s.loc.Get(ctx, s.client, s.loc.Prefix+name)
+}
+
+func (s *s3Files) archive(ctx context.Context, name string, failed bool, report []byte) error {
+	dest := s.archivePrefix
+	if failed {
+		dest += "failed/"
+	}
+	archived := dest + archiveName(name)
+	if report != nil {
+		if err := s.loc.Put(ctx, s.client, archived+".errors.json", "application/json", report); err != nil {
+			return err
+		}
+	}
+	if err := s.loc.Copy(ctx, s.client, s.loc.Prefix+name, archived); err != nil {
+		return err
+	}
+	return s.loc.Delete(ctx, s.client, s.loc.Prefix+name)
+}
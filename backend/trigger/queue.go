@@ -0,0 +1,308 @@
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"auto/model"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// Queue trigger types. Stream triggers read a Redis stream through a
+// consumer group, so several servers share its messages; list triggers pop
+// a Redis list. Either way every message starts one run, with the message
+// body as its payload.
+const (
+	TypeStream = "stream"
+	TypeList   = "list"
+)
+
+// DefaultGroup is the consumer group of stream triggers without one.
+const DefaultGroup = "umba"
+
+// queueBlock is how long a consumer waits for a message before checking
+// whether it should stop.
+const queueBlock = 5 * time.Second
+
+// DeadLetter is what a message whose runs failed is moved to: the
+// dead-letter stream or list of its trigger.
+type DeadLetter struct {
+	TriggerID string    `json:"trigger_id"`
+	FlowID    string    `json:"flow_id"`
+	MessageID string    `json:"message_id,omitempty"`
+	Body      string    `json:"body"`
+	Error     string    `json:"error"`
+	Attempts  int       `json:"attempts"`
+	Time      time.Time `json:"time"`
+}
+
+func (t *Trigger) deadLetterKey() string {
+	if t.DeadLetter != "" {
+		return t.DeadLetter
+	}
+	return t.Source + ":dead"
+}
+
+// Start consumes the sources of the stored queue and file triggers until
+// Stop.
+func (m *Manager) Start() error {
+	m.mu.Lock()
+	m.ctx, m.stop = context.WithCancel(context.Background())
+	m.mu.Unlock()
+
+	values, err := m.db.HGetAll(m.ctx, "triggers").Result()
+	if err != nil {
+		return err
+	}
+	for _, data := range values {
+		var t Trigger
+		if err := json.Unmarshal([]byte(data), &t); err != nil {
+			continue
+		}
+		m.watch(&t)
+	}
+	return nil
+}
+
+// Stop stops every queue consumer and file watcher. Runs in progress finish; their
+// messages stay pending and are picked up again on the next Start.
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop != nil {
+		m.stop()
+	}
+	m.consumers = make(map[string]context.CancelFunc)
+}
+
+// watch (re)starts the consumer of a queue or file trigger. Disabled
+// triggers and webhooks are only unwatched.
+func (m *Manager) watch(t *Trigger) {
+	m.unwatch(t.ID)
+	if t.Type == TypeWebhook || t.Disabled {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ctx == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.consumers[t.ID] = cancel
+	trigger := *t
+	switch t.Type {
+	case TypeStream:
+		go m.consumeStream(ctx, &trigger)
+	case TypeList:
+		go m.consumeList(ctx, &trigger)
+	case TypeFile:
+		go m.watchFiles(ctx, &trigger)
+	}
+}
+
+func (m *Manager) unwatch(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if cancel, ok := m.consumers[id]; ok {
+		cancel()
+		delete(m.consumers, id)
+	}
+}
+
+// consumeStream reads the stream of t as a member of its consumer group.
+// Messages this consumer read but never acknowledged, because the server
+// stopped mid-run, are handled first. Each message is acknowledged once its
+// run succeeded or it was moved to the dead-letter stream.
+func (m *Manager) consumeStream(ctx context.Context, t *Trigger) {
+	logger := m.logger.With(zap.String("triggerID", t.ID), zap.String("stream", t.Source))
+	err := m.db.XGroupCreateMkStream(ctx, t.Source, t.Group, "$").Err()
+	if err != nil && !isBusyGroup(err) {
+		logger.Error("Failed to create consumer group", zap.Error(err))
+		return
+	}
+
+	pending := true
+	for ctx.Err() == nil {
+		id := ">"
+		if pending {
+			id = "0"
+		}
+		streams, err := m.db.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    t.Group,
+			Consumer: m.consumer,
+			Streams:  []string{t.Source, id},
+			Count:    10,
+			Block:    queueBlock,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			if ctx.Err() == nil {
+				logger.Error("Failed to read stream", zap.Error(err))
+				sleep(ctx, queueBlock)
+			}
+			continue
+		}
+		if pending && len(streams) > 0 && len(streams[0].Messages) == 0 {
+			pending = false
+		}
+		for _, s := range streams {
+			for _, msg := range s.Messages {
+				body := streamBody(msg.Values)
+				if err := m.consume(ctx, t, msg.ID, body); err != nil {
+					// Stopped; the message stays pending.
+					return
+				}
+				if err := m.db.XAck(ctx, t.Source, t.Group, msg.ID).Err(); err != nil {
+					logger.Error("Failed to acknowledge message", zap.String("messageID", msg.ID), zap.Error(err))
+				}
+			}
+		}
+	}
+}
+
+// consumeList pops the list of t into a processing list of this consumer,
+// removing each message from it once handled. Messages left there by an
+// earlier process are put back first.
+func (m *Manager) consumeList(ctx context.Context, t *Trigger) {
+	logger := m.logger.With(zap.String("triggerID", t.ID), zap.String("list", t.Source))
+	processing := t.Source + ":processing:" + m.consumer
+	for {
+		err := m.db.RPopLPush(ctx, processing, t.Source).Err()
+		if err == redis.Nil {
+			break
+		} else if err != nil {
+			logger.Error("Failed to requeue unfinished messages", zap.Error(err))
+			break
+		}
+	}
+
+	for ctx.Err() == nil {
+		body, err := m.db.BRPopLPush(ctx, t.Source, processing, queueBlock).Result()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			if ctx.Err() == nil {
+				logger.Error("Failed to read list", zap.Error(err))
+				sleep(ctx, queueBlock)
+			}
+			continue
+		}
+		if err := m.consume(ctx, t, "", body); err != nil {
+			return
+		}
+		if err := m.db.LRem(ctx, processing, 1, body).Err(); err != nil {
+			logger.Error("Failed to remove handled message", zap.Error(err))
+		}
+	}
+}
+
+// consume runs the flow of t for one message, retrying failed runs up to
+// t.Retries times before moving the message to the dead-letter key. It
+// only fails when ctx ends first.
+func (m *Manager) consume(ctx context.Context, t *Trigger, messageID, body string) error {
+	var payload interface{}
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		payload = map[string]interface{}{"body": body}
+	}
+	attempts, err := m.execute(ctx, t, t.params(payload))
+	if err == nil {
+		return nil
+	} else if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	m.deadLetter(ctx, t, DeadLetter{
+		TriggerID: t.ID,
+		FlowID:    t.FlowID,
+		MessageID: messageID,
+		Body:      body,
+		Error:     err.Error(),
+		Attempts:  attempts,
+		Time:      time.Now().UTC(),
+	})
+	return nil
+}
+
+// execute runs the flow of t, waiting for its rate limit, and retries a
+// failed run up to t.Retries times. It returns the number of runs and the
+// error of the last one.
+func (m *Manager) execute(ctx context.Context, t *Trigger, params map[string]interface{}) (int, error) {
+	var err error
+	attempts := 0
+	for attempts <= t.Retries {
+		if waitErr := m.limiter(t).Wait(ctx); waitErr != nil {
+			return attempts, waitErr
+		}
+		attempts++
+		if err = m.flows.ExecuteFlowContext(context.Background(), t.FlowID, m.instances, params, model.PriorityBatch); err == nil {
+			return attempts, nil
+		}
+		m.logger.Warn("Triggered run failed", zap.String("triggerID", t.ID), zap.Int("attempt", attempts), zap.Error(err))
+	}
+	return attempts, err
+}
+
+func (m *Manager) deadLetter(ctx context.Context, t *Trigger, d DeadLetter) {
+	var err error
+	if t.Type == TypeStream {
+		err = m.db.XAdd(ctx, &redis.XAddArgs{
+			Stream: t.deadLetterKey(),
+			Values: map[string]interface{}{
+				"trigger_id": d.TriggerID,
+				"flow_id":    d.FlowID,
+				"message_id": d.MessageID,
+				"body":       d.Body,
+				"error":      d.Error,
+				"attempts":   d.Attempts,
+			},
+		}).Err()
+	} else {
+		var data []byte
+		if data, err = json.Marshal(d); err == nil {
+			err = m.db.LPush(ctx, t.deadLetterKey(), data).Err()
+		}
+	}
+	if err != nil {
+		m.logger.Error("Failed to dead-letter message", zap.String("triggerID", t.ID), zap.String("messageID", d.MessageID), zap.Error(err))
+		return
+	}
+	m.logger.Warn("Message moved to dead letters", zap.String("triggerID", t.ID), zap.String("key", t.deadLetterKey()), zap.String("messageID", d.MessageID))
+}
+
+// streamBody is the payload of a stream message: its "body" field when it
+// has one, otherwise all of its fields as a JSON object.
+func streamBody(values map[string]interface{}) string {
+	if body, ok := values["body"].(string); ok {
+		return body
+	}
+	data, _ := json.Marshal(values)
+	return string(data)
+}
+
+func isBusyGroup(err error) bool {
+	return strings.HasPrefix(err.Error(), "BUSYGROUP")
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// consumerName identifies this process within consumer groups, stable
+// across restarts so its pending messages are found again.
+func consumerName() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return fmt.Sprintf("umba-%d", os.Getpid())
+	}
+	return host
+}
@@ -0,0 +1,405 @@
+// Package trigger starts flow runs on behalf of external systems. Each
+// trigger runs one flow, with parameters taken from what set it off.
+package trigger
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"auto/apperr"
+	"auto/flow"
+	"auto/model"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// TypeWebhook triggers run when their URL, POST /api/v1/triggers/<token>,
+// is called. See queue.go for the queue trigger types.
+const TypeWebhook = "webhook"
+
+// Default rate limits of a trigger.
+const (
+	DefaultRatePerMinute = 60
+	DefaultBurst         = 10
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the request body, keyed
+// with the trigger secret, as "sha256=<hex>".
+const SignatureHeader = "X-Umba-Signature"
+
+// Trigger starts FlowID when set off. The run parameters are Params,
+// overlaid with the values Mapping picks from the payload: each maps a
+// parameter name to a dotted path into the JSON payload, such as
+// "order.customer.email". Without a mapping the top-level fields of the
+// payload are the parameters.
+//
+// Queue triggers read their messages from the Redis stream or list named
+// by Source. A failed run is retried Retries times, after which the message
+// goes to DeadLetter, by default "<source>:dead". File triggers find their
+// files as Files says, and retry failed runs the same way.
+type Trigger struct {
+	ID            string                 `json:"id"`
+	Name          string                 `json:"name"`
+	Type          string                 `json:"type"`
+	FlowID        string                 `json:"flow_id"`
+	Token         string                 `json:"token,omitempty"`
+	Secret        string                 `json:"secret,omitempty"`
+	Params        map[string]interface{} `json:"params,omitempty"`
+	Mapping       map[string]string      `json:"mapping,omitempty"`
+	Source        string                 `json:"source,omitempty"`
+	Group         string                 `json:"group,omitempty"`
+	DeadLetter    string                 `json:"dead_letter,omitempty"`
+	Retries       int                    `json:"retries,omitempty"`
+	Files         *FileWatch             `json:"files,omitempty"`
+	RatePerMinute float64                `json:"rate_per_minute,omitempty"`
+	Burst         int                    `json:"burst,omitempty"`
+	Disabled      bool                   `json:"disabled"`
+	CreatedAt     time.Time              `json:"created_at"`
+}
+
+// Redacted returns a copy of t with its secret hidden, for responses.
+func (t *Trigger) Redacted() *Trigger {
+	out := *t
+	if out.Secret != "" {
+		out.Secret = "********"
+	}
+	return &out
+}
+
+// Manager stores triggers in Redis, the definitions in the "triggers"
+// hash and the webhook tokens in "trigger_tokens", and consumes the
+// sources of queue triggers once started.
+type Manager struct {
+	db        *redis.Client
+	flows     *flow.Manager
+	instances *model.InstanceManager
+	logger    *zap.Logger
+
+	consumer string
+	client   *http.Client
+	// fileRoot is the directory file triggers may watch, see SetFileRoot.
+	fileRoot string
+
+	mu        sync.Mutex
+	limiters  map[string]*rate.Limiter
+	consumers map[string]context.CancelFunc
+	ctx       context.Context
+	stop      context.CancelFunc
+}
+
+func NewManager(db *redis.Client, flows *flow.Manager, instances *model.InstanceManager, logger *zap.Logger) *Manager {
+	return &Manager{
+		db:        db,
+		flows:     flows,
+		instances: instances,
+		logger:    logger,
+		consumer:  consumerName(),
+		client:    &http.Client{Timeout: 60 * time.Second},
+		limiters:  make(map[string]*rate.Limiter),
+		consumers: make(map[string]context.CancelFunc),
+	}
+}
+
+func (m *Manager) validate(t *Trigger) error {
+	if t.FlowID == "" {
+		return errors.New("flow_id is required")
+	}
+	if _, err := m.flows.GetFlow(t.FlowID); err != nil {
+		return apperr.New(apperr.CodeFlowNotFound, fmt.Sprintf("flow not found: %s", t.FlowID))
+	}
+	switch t.Type {
+	case TypeWebhook:
+	case TypeStream, TypeList:
+		if t.Source == "" {
+			return errors.New("source is required for queue triggers")
+		}
+		if t.Type == TypeStream && t.Group == "" {
+			t.Group = DefaultGroup
+		}
+	case TypeFile:
+		if err := t.Files.validate(m.fileRoot); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown trigger type: %s", t.Type)
+	}
+	if t.RatePerMinute < 0 || t.Burst < 0 {
+		return errors.New("rate limits must not be negative")
+	}
+	if t.Retries < 0 {
+		return errors.New("retries must not be negative")
+	}
+	for name, path := range t.Mapping {
+		if name == "" || path == "" {
+			return errors.New("mapping entries need a parameter name and a path")
+		}
+	}
+	return nil
+}
+
+// SetFileRoot sets the directory file triggers may watch and archive to,
+// anywhere inside it. Without one, file triggers can only watch S3. It is
+// meant to be called before Start.
+func (m *Manager) SetFileRoot(dir string) {
+	m.fileRoot = dir
+}
+
+// CreateTrigger stores a new trigger. Webhook triggers get a random token,
+// which is their URL and should be kept private.
+func (m *Manager) CreateTrigger(t Trigger) (*Trigger, error) {
+	if t.Type == "" {
+		t.Type = TypeWebhook
+	}
+	if err := m.validate(&t); err != nil {
+		return nil, err
+	}
+	t.ID = uuid.New().String()
+	t.CreatedAt = time.Now().UTC()
+	t.Token = ""
+	if t.Type == TypeWebhook {
+		token, err := newToken()
+		if err != nil {
+			return nil, err
+		}
+		t.Token = token
+	}
+	if err := m.save(&t); err != nil {
+		return nil, err
+	}
+	m.watch(&t)
+	return &t, nil
+}
+
+// UpdateTrigger replaces a trigger, keeping its ID, token and creation
+// time. An empty secret keeps the stored one.
+func (m *Manager) UpdateTrigger(id string, t Trigger) (*Trigger, error) {
+	existing, err := m.GetTrigger(id)
+	if err != nil {
+		return nil, err
+	}
+	t.ID, t.Type, t.Token, t.CreatedAt = existing.ID, existing.Type, existing.Token, existing.CreatedAt
+	if t.Secret == "" {
+		t.Secret = existing.Secret
+	}
+	if err := m.validate(&t); err != nil {
+		return nil, err
+	}
+	if err := m.save(&t); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	delete(m.limiters, id)
+	m.mu.Unlock()
+	m.watch(&t)
+	return t.Redacted(), nil
+}
+
+func (m *Manager) DeleteTrigger(id string) error {
+	t, err := m.GetTrigger(id)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	_, err = m.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, "triggers", id)
+		if t.Token != "" {
+			pipe.HDel(ctx, "trigger_tokens", t.Token)
+		}
+		return nil
+	})
+	m.mu.Lock()
+	delete(m.limiters, id)
+	m.mu.Unlock()
+	m.unwatch(id)
+	return err
+}
+
+func (m *Manager) GetTrigger(id string) (*Trigger, error) {
+	data, err := m.db.HGet(context.Background(), "triggers", id).Bytes()
+	if err == redis.Nil {
+		return nil, apperr.New(apperr.CodeTriggerNotFound, fmt.Sprintf("trigger not found: %s", id))
+	} else if err != nil {
+		return nil, err
+	}
+	var t Trigger
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetTriggers returns every trigger with its secret hidden.
+func (m *Manager) GetTriggers() ([]*Trigger, error) {
+	values, err := m.db.HGetAll(context.Background(), "triggers").Result()
+	if err != nil {
+		return nil, err
+	}
+	triggers := make([]*Trigger, 0, len(values))
+	for _, data := range values {
+		var t Trigger
+		if err := json.Unmarshal([]byte(data), &t); err != nil {
+			continue
+		}
+		triggers = append(triggers, t.Redacted())
+	}
+	return triggers, nil
+}
+
+func (m *Manager) save(t *Trigger) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	_, err = m.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, "triggers", t.ID, data)
+		if t.Token != "" {
+			pipe.HSet(ctx, "trigger_tokens", t.Token, t.ID)
+		}
+		return nil
+	})
+	return err
+}
+
+// Webhook sets off the webhook trigger with token, checking the signature
+// of payload when the trigger has a secret, and starts its flow in the
+// background. It returns the trigger and the parameters of the run.
+func (m *Manager) Webhook(token string, payload []byte, signature string) (*Trigger, map[string]interface{}, error) {
+	id, err := m.db.HGet(context.Background(), "trigger_tokens", token).Result()
+	if err == redis.Nil {
+		return nil, nil, apperr.New(apperr.CodeTriggerNotFound, "trigger not found")
+	} else if err != nil {
+		return nil, nil, err
+	}
+	t, err := m.GetTrigger(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t.Secret != "" && !validSignature(t.Secret, payload, signature) {
+		return nil, nil, apperr.New(apperr.CodeUnauthorized, "invalid or missing "+SignatureHeader+" header")
+	}
+
+	var body interface{}
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &body); err != nil {
+			return nil, nil, apperr.New(apperr.CodeInvalidRequest, "payload is not valid JSON")
+		}
+	}
+	params := t.params(body)
+	if err := m.fire(t, params); err != nil {
+		return nil, nil, err
+	}
+	return t.Redacted(), params, nil
+}
+
+// fire starts a run of the flow of t unless it is disabled or over its
+// rate limit.
+func (m *Manager) fire(t *Trigger, params map[string]interface{}) error {
+	if t.Disabled {
+		return apperr.New(apperr.CodeConflict, "trigger is disabled")
+	}
+	if !m.limiter(t).Allow() {
+		return apperr.New(apperr.CodeRateLimited, "trigger rate limit exceeded")
+	}
+	m.logger.Info("Starting triggered run", zap.String("triggerID", t.ID), zap.String("flowID", t.FlowID))
+	go func() {
+		if err := m.flows.ExecuteFlowWithParams(t.FlowID, m.instances, params); err != nil {
+			m.logger.Error("Triggered run failed", zap.String("triggerID", t.ID), zap.String("flowID", t.FlowID), zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+func (m *Manager) limiter(t *Trigger) *rate.Limiter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l, ok := m.limiters[t.ID]; ok {
+		return l
+	}
+	perMinute, burst := t.RatePerMinute, t.Burst
+	if perMinute == 0 {
+		perMinute = DefaultRatePerMinute
+	}
+	if burst == 0 {
+		burst = DefaultBurst
+	}
+	l := rate.NewLimiter(rate.Limit(perMinute/60), burst)
+	m.limiters[t.ID] = l
+	return l
+}
+
+// params builds the run parameters from the payload.
+func (t *Trigger) params(body interface{}) map[string]interface{} {
+	params := make(map[string]interface{}, len(t.Params)+len(t.Mapping))
+	for k, v := range t.Params {
+		params[k] = v
+	}
+	if len(t.Mapping) == 0 {
+		if fields, ok := body.(map[string]interface{}); ok {
+			for k, v := range fields {
+				params[k] = v
+			}
+		}
+		return params
+	}
+	for name, path := range t.Mapping {
+		if v, ok := lookup(body, path); ok {
+			params[name] = v
+		}
+	}
+	return params
+}
+
+// lookup follows a dotted path through objects and arrays.
+func lookup(v interface{}, path string) (interface{}, bool) {
+	for _, part := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = node[part]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func validSignature(secret string, payload []byte, signature string) bool {
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || len(sig) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
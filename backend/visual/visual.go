@@ -0,0 +1,132 @@
+// Package visual compares screenshots pixel by pixel, for flows that check
+// a page still looks the way it was approved.
+package visual
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+
+	// Screenshots are PNG unless asked otherwise; JPEG is decoded too.
+	_ "image/jpeg"
+)
+
+// Region is a rectangle of a screenshot, in its pixels.
+type Region struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func (r Region) rect() image.Rectangle {
+	return image.Rect(r.X, r.Y, r.X+r.Width, r.Y+r.Height)
+}
+
+// Options tune a comparison. Ignore are regions that may differ, such as
+// clocks or ads; Tolerance is how far a colour channel, out of 255, may be
+// off before the pixel counts as different.
+type Options struct {
+	Ignore    []Region
+	Tolerance int
+}
+
+// Result is how a screenshot differs from its baseline. Image is a PNG of
+// the screenshot, faded, with the differing pixels in red; it is nil when
+// nothing differs or the sizes do not match.
+type Result struct {
+	DiffPixels  int     `json:"diff_pixels"`
+	TotalPixels int     `json:"total_pixels"`
+	Ratio       float64 `json:"ratio"`
+	SizeChanged bool    `json:"size_changed,omitempty"`
+	Image       []byte  `json:"-"`
+}
+
+var diffColor = color.RGBA{R: 255, A: 255}
+
+// Compare compares the screenshot actual with baseline.
+func Compare(baseline, actual []byte, opts Options) (*Result, error) {
+	base, _, err := image.Decode(bytes.NewReader(baseline))
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(actual))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	total := bounds.Dx() * bounds.Dy()
+	if base.Bounds().Dx() != bounds.Dx() || base.Bounds().Dy() != bounds.Dy() {
+		return &Result{DiffPixels: total, TotalPixels: total, Ratio: 1, SizeChanged: true}, nil
+	}
+
+	ignore := make([]image.Rectangle, len(opts.Ignore))
+	for n, r := range opts.Ignore {
+		ignore[n] = r.rect()
+	}
+	offset := base.Bounds().Min.Sub(bounds.Min)
+	diff := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	result := &Result{TotalPixels: total}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.At(x, y)
+			p := image.Pt(x-bounds.Min.X, y-bounds.Min.Y)
+			if !ignored(ignore, p) && !similar(base.At(x+offset.X, y+offset.Y), c, opts.Tolerance) {
+				result.DiffPixels++
+				diff.Set(p.X, p.Y, diffColor)
+				continue
+			}
+			diff.Set(p.X, p.Y, faded(c))
+		}
+	}
+	if total > 0 {
+		result.Ratio = float64(result.DiffPixels) / float64(total)
+	}
+	if result.DiffPixels == 0 {
+		return result, nil
+	}
+	for _, r := range ignore {
+		// Ignored regions show as grey boxes.
+		draw.Draw(diff, r.Intersect(diff.Bounds()), image.NewUniform(color.Gray{Y: 200}), image.Point{}, draw.Src)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, diff); err != nil {
+		return nil, err
+	}
+	result.Image = buf.Bytes()
+	return result, nil
+}
+
+func ignored(regions []image.Rectangle, p image.Point) bool {
+	for _, r := range regions {
+		if p.In(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// similar reports whether no channel of a and b is more than tolerance
+// apart.
+func similar(a, b color.Color, tolerance int) bool {
+	r1, g1, b1, a1 := a.RGBA()
+	r2, g2, b2, a2 := b.RGBA()
+	limit := uint32(tolerance) * 0x101
+	return within(r1, r2, limit) && within(g1, g2, limit) && within(b1, b2, limit) && within(a1, a2, limit)
+}
+
+func within(a, b, limit uint32) bool {
+	if a > b {
+		return a-b <= limit
+	}
+	return b-a <= limit
+}
+
+// faded returns c in grey, washed out so the differences stand out.
+func faded(c color.Color) color.Color {
+	g := color.GrayModel.Convert(c).(color.Gray)
+	return color.Gray{Y: 180 + g.Y/4}
+}
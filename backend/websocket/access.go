@@ -0,0 +1,188 @@
+package websocket
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"auto/apperr"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+)
+
+// Options controls who may connect to the WebSocket endpoint and how much
+// each connection may send.
+type Options struct {
+	// APIKeys are the tokens accepted on upgrade; none disables
+	// authentication.
+	APIKeys []string
+	// AllowedOrigins lists the browser origins allowed to connect, such as
+	// "https://app.example.com"; "*" allows any. Without entries only the
+	// server's own origin is allowed. Clients that send no Origin header
+	// are not browsers and are not checked.
+	AllowedOrigins []string
+	// MaxConnsPerKey bounds the concurrent connections of one API key.
+	MaxConnsPerKey    int
+	MessagesPerSecond float64
+	MessageBurst      int
+	MaxMessageBytes   int64
+	// A ping is sent every PingInterval; a connection that answers
+	// nothing for PongWait is closed.
+	PingInterval time.Duration
+	PongWait     time.Duration
+	// A disconnected client can resume its session within SessionTTL and
+	// receives up to SessionBuffer of the events it missed.
+	SessionTTL    time.Duration
+	SessionBuffer int
+	// LiveViewMinChange is the share of pixels, from 0 to 1, that must
+	// change before a live-view frame is sent; 0 sends every frame.
+	LiveViewMinChange float64
+	// ReadOnly limits clients to subscribing to events and watching live
+	// views; actions that change state are refused.
+	ReadOnly bool
+}
+
+// DefaultOptions are used until Configure is called.
+var DefaultOptions = Options{
+	MaxConnsPerKey:    10,
+	MessagesPerSecond: 10,
+	MessageBurst:      20,
+	MaxMessageBytes:   64 << 10,
+	PingInterval:      30 * time.Second,
+	PongWait:          60 * time.Second,
+	SessionTTL:        2 * time.Minute,
+	SessionBuffer:     256,
+	LiveViewMinChange: 0.005,
+}
+
+var options = DefaultOptions
+
+// Configure sets the access options. Call it before serving connections.
+func Configure(opts Options) {
+	options = opts
+}
+
+func init() {
+	upgrader.CheckOrigin = checkOrigin
+}
+
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if len(options.AllowedOrigins) == 0 {
+		return strings.EqualFold(u.Host, r.Host)
+	}
+	for _, allowed := range options.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) || strings.EqualFold(allowed, u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate returns the API key of a request, from an
+// "Authorization: Bearer" header or, for browsers that can't set headers
+// on WebSockets, the "token" query parameter.
+func authenticate(r *http.Request) (string, bool) {
+	if len(options.APIKeys) == 0 {
+		return "", true
+	}
+	token := r.URL.Query().Get("token")
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token = strings.TrimPrefix(h, "Bearer ")
+	}
+	for _, key := range options.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// connsPerKey counts the open connections of each API key; guarded by
+// clientsLock.
+var connsPerKey = make(map[string]int)
+
+// admit reserves a connection slot for key.
+func admit(key string) bool {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+	if options.MaxConnsPerKey > 0 && connsPerKey[key] >= options.MaxConnsPerKey {
+		return false
+	}
+	connsPerKey[key]++
+	return true
+}
+
+func release(key string) {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+	if connsPerKey[key]--; connsPerKey[key] <= 0 {
+		delete(connsPerKey, key)
+	}
+}
+
+// rejectUpgrade answers a refused upgrade with a structured API error.
+func rejectUpgrade(w http.ResponseWriter, err *apperr.Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.Status())
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": err})
+}
+
+func newLimiter() *rate.Limiter {
+	if options.MessagesPerSecond <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	burst := options.MessageBurst
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(options.MessagesPerSecond), burst)
+}
+
+// keepAlive bounds incoming messages and pings conn until done is closed,
+// so dead peers are noticed by the read deadline instead of lingering.
+func keepAlive(conn *websocket.Conn, done <-chan struct{}) {
+	if options.MaxMessageBytes > 0 {
+		conn.SetReadLimit(options.MaxMessageBytes)
+	}
+	if options.PongWait <= 0 {
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(options.PongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(options.PongWait))
+	})
+	if options.PingInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(options.PingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+func tooManyConnections() *apperr.Error {
+	return apperr.New(apperr.CodeTooManyConnections, fmt.Sprintf("at most %d connections per API key", options.MaxConnsPerKey))
+}
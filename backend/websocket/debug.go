@@ -0,0 +1,202 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// debugPauseTimeout aborts a debug run nobody answered, so it does not hold
+// its instance forever.
+const debugPauseTimeout = 10 * time.Minute
+
+// DebugStep is the step a debug run is paused before. Screenshot is the
+// page, as a base64 PNG.
+type DebugStep struct {
+	RunID      string                 `json:"runId"`
+	Index      int                    `json:"index"`
+	ID         string                 `json:"id"`
+	Action     string                 `json:"action"`
+	Params     map[string]interface{} `json:"params"`
+	Screenshot string                 `json:"screenshot,omitempty"`
+}
+
+// DebugCommand is the answer to a paused step: "step", "continue" or
+// "abort", with the step parameters to use when they were edited.
+type DebugCommand struct {
+	Command string
+	Params  map[string]interface{}
+}
+
+// DebugFunc runs a flow in debug mode, calling pause before each step.
+type DebugFunc func(flowID string, params map[string]interface{}, pause func(DebugStep) DebugCommand) error
+
+var debugFunc DebugFunc
+
+// SetDebugFunc enables the debugFlow action. The flow engine lives in a
+// package that imports this one, so it is passed in rather than called.
+func SetDebugFunc(f DebugFunc) {
+	debugFunc = f
+}
+
+// debugSession is a debug run driven by one connection.
+type debugSession struct {
+	id       string
+	conn     *websocket.Conn
+	commands chan DebugCommand
+	closed   chan struct{}
+
+	mu      sync.Mutex
+	pending *DebugStep
+	edited  map[string]interface{}
+}
+
+var debugSessions = make(map[string]*debugSession)
+var debugSessionsLock sync.Mutex
+
+func debugFlow(conn *websocket.Conn, msg map[string]interface{}) {
+	if debugFunc == nil {
+		sendError(conn, "Flow debugging is not available")
+		return
+	}
+	flowID, ok := msg["flowId"].(string)
+	if !ok {
+		sendError(conn, "Flow ID is required")
+		return
+	}
+	params, _ := msg["params"].(map[string]interface{})
+
+	s := &debugSession{
+		id:       generateID(),
+		conn:     conn,
+		commands: make(chan DebugCommand, 1),
+		closed:   make(chan struct{}),
+	}
+	debugSessionsLock.Lock()
+	debugSessions[s.id] = s
+	debugSessionsLock.Unlock()
+
+	sendSuccess(conn, map[string]interface{}{
+		"message": "Debug run started",
+		"debugId": s.id,
+		"flowId":  flowID,
+	})
+
+	go func() {
+		err := debugFunc(flowID, params, s.pause)
+		debugSessionsLock.Lock()
+		delete(debugSessions, s.id)
+		debugSessionsLock.Unlock()
+
+		data := map[string]interface{}{"debugId": s.id, "flowId": flowID}
+		if err != nil {
+			data["error"] = err.Error()
+		}
+		writeJSON(conn, map[string]interface{}{"status": "debug", "event": "finished", "data": data})
+	}()
+}
+
+// pause shows the client the step the run is about to take and waits for
+// its command. A closed connection or a client that does not answer in
+// time aborts the run.
+func (s *debugSession) pause(step DebugStep) DebugCommand {
+	s.mu.Lock()
+	s.pending = &step
+	s.edited = nil
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.pending = nil
+		s.mu.Unlock()
+	}()
+	s.sendPaused(step)
+
+	timeout := time.NewTimer(debugPauseTimeout)
+	defer timeout.Stop()
+	select {
+	case cmd := <-s.commands:
+		return cmd
+	case <-s.closed:
+		return DebugCommand{Command: "abort"}
+	case <-timeout.C:
+		logger.Warn("Debug run timed out waiting for a command", zap.String("debugId", s.id))
+		writeJSON(s.conn, map[string]interface{}{"status": "debug", "event": "timeout", "data": map[string]interface{}{"debugId": s.id}})
+		return DebugCommand{Command: "abort"}
+	}
+}
+
+func (s *debugSession) sendPaused(step DebugStep) {
+	writeJSON(s.conn, map[string]interface{}{
+		"status": "debug",
+		"event":  "paused",
+		"data": map[string]interface{}{
+			"debugId": s.id,
+			"step":    step,
+		},
+	})
+}
+
+// debugCommand answers a paused debug run. "edit" replaces the parameters
+// of the paused step and shows it again without running it; "step" and
+// "continue" may also carry new parameters.
+func debugCommand(conn *websocket.Conn, msg map[string]interface{}) {
+	id, ok := msg["debugId"].(string)
+	if !ok {
+		sendError(conn, "Debug ID is required")
+		return
+	}
+	debugSessionsLock.Lock()
+	s, ok := debugSessions[id]
+	debugSessionsLock.Unlock()
+	if !ok || s.conn != conn {
+		sendError(conn, "Debug run not found")
+		return
+	}
+	command, _ := msg["command"].(string)
+	params, hasParams := msg["params"].(map[string]interface{})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == nil {
+		sendError(conn, "Debug run is not paused")
+		return
+	}
+	switch command {
+	case "edit":
+		if !hasParams {
+			sendError(conn, "Params are required")
+			return
+		}
+		s.edited = params
+		s.pending.Params = params
+		s.sendPaused(*s.pending)
+		return
+	case "step", "continue", "abort":
+	default:
+		sendError(conn, "Unknown debug command")
+		return
+	}
+	if !hasParams {
+		params = s.edited
+	}
+	select {
+	case s.commands <- DebugCommand{Command: command, Params: params}:
+		s.pending = nil
+	default:
+		sendError(conn, "A command is already pending")
+	}
+}
+
+// stopDebugSessions aborts the debug runs of a closed connection.
+func stopDebugSessions(conn *websocket.Conn) {
+	debugSessionsLock.Lock()
+	defer debugSessionsLock.Unlock()
+	for id, s := range debugSessions {
+		if s.conn == conn {
+			close(s.closed)
+			delete(debugSessions, id)
+		}
+	}
+}
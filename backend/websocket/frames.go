@@ -0,0 +1,334 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"sync"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// Binary messages carry images without the base64 overhead of JSON. Each
+// starts with a header:
+//
+//	byte 0      FrameVersion
+//	byte 1      frame type (FrameScreenshot, FrameLiveView)
+//	byte 2      image format (FormatPNG, FormatJPEG)
+//	byte 3      length n of the instance ID
+//	bytes 4-11  frame number, big endian; it counts up per live view and
+//	            is 0 for screenshots
+//	n bytes     instance ID
+//
+// and the encoded image follows.
+const (
+	FrameVersion byte = 1
+
+	FrameScreenshot byte = 1
+	FrameLiveView   byte = 2
+
+	FormatPNG  byte = 1
+	FormatJPEG byte = 2
+)
+
+const frameHeaderSize = 12
+
+// imageFormat is the encoding requested by a message: "format" is "png"
+// (the default) or "jpeg", with "quality" from 1 to 100 for JPEG.
+type imageFormat struct {
+	code    byte
+	quality int64
+}
+
+func parseImageFormat(msg map[string]interface{}) (imageFormat, error) {
+	f := imageFormat{code: FormatPNG}
+	switch msg["format"] {
+	case nil, "png":
+	case "jpeg", "jpg":
+		f.code, f.quality = FormatJPEG, 80
+		if q, ok := msg["quality"].(float64); ok {
+			if q < 1 || q > 100 {
+				return f, errors.New("Quality must be between 1 and 100")
+			}
+			f.quality = int64(q)
+		}
+	default:
+		return f, errors.New("Format must be png or jpeg")
+	}
+	return f, nil
+}
+
+func (f imageFormat) screenshot() page.CaptureScreenshotFormat {
+	if f.code == FormatJPEG {
+		return page.CaptureScreenshotFormatJpeg
+	}
+	return page.CaptureScreenshotFormatPng
+}
+
+func (f imageFormat) screencast() page.ScreencastFormat {
+	if f.code == FormatJPEG {
+		return page.ScreencastFormatJpeg
+	}
+	return page.ScreencastFormatPng
+}
+
+func encodeFrame(frameType, format byte, instanceID string, number uint64, image []byte) []byte {
+	id := instanceID
+	if len(id) > 255 {
+		id = id[:255]
+	}
+	buf := make([]byte, frameHeaderSize+len(id)+len(image))
+	buf[0] = FrameVersion
+	buf[1] = frameType
+	buf[2] = format
+	buf[3] = byte(len(id))
+	binary.BigEndian.PutUint64(buf[4:12], number)
+	copy(buf[frameHeaderSize:], id)
+	copy(buf[frameHeaderSize+len(id):], image)
+	return buf
+}
+
+func writeBinary(conn *websocket.Conn, data []byte) error {
+	clientsLock.Lock()
+	mu, ok := clients[conn]
+	clientsLock.Unlock()
+	if ok {
+		mu.Lock()
+		defer mu.Unlock()
+	}
+	return conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+func captureScreenshot(ctx context.Context, f imageFormat) ([]byte, error) {
+	var buf []byte
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		params := page.CaptureScreenshot().WithFormat(f.screenshot())
+		if f.code == FormatJPEG {
+			params = params.WithQuality(f.quality)
+		}
+		var err error
+		buf, err = params.Do(ctx)
+		return err
+	}))
+	return buf, err
+}
+
+// liveViews holds the cancel function of each live view a connection
+// watches, by instance ID.
+var liveViews = make(map[*websocket.Conn]map[string]context.CancelFunc)
+var liveViewsLock sync.Mutex
+
+func startLiveView(conn *websocket.Conn, msg map[string]interface{}) {
+	id, ok := msg["id"].(string)
+	if !ok {
+		sendError(conn, "Instance ID is required")
+		return
+	}
+	f, err := parseImageFormat(msg)
+	if err != nil {
+		sendError(conn, err.Error())
+		return
+	}
+	everyNth := int64(1)
+	if n, ok := msg["everyNthFrame"].(float64); ok && n >= 1 {
+		everyNth = int64(n)
+	}
+	minChange := options.LiveViewMinChange
+	if c, ok := msg["minChange"].(float64); ok {
+		if c < 0 || c > 1 {
+			sendError(conn, "minChange must be between 0 and 1")
+			return
+		}
+		minChange = c
+	}
+	changes := &changeDetector{minChange: minChange}
+
+	instancesLock.Lock()
+	instance, ok := instances[id]
+	instancesLock.Unlock()
+	if !ok {
+		sendError(conn, "Instance not found")
+		return
+	}
+	if instance.Status != "On" {
+		sendError(conn, "Instance is not running")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(instance.ChromeCtx)
+	liveViewsLock.Lock()
+	if liveViews[conn] == nil {
+		liveViews[conn] = make(map[string]context.CancelFunc)
+	}
+	if _, running := liveViews[conn][id]; running {
+		liveViewsLock.Unlock()
+		cancel()
+		sendError(conn, "Live view is already running")
+		return
+	}
+	liveViews[conn][id] = cancel
+	liveViewsLock.Unlock()
+
+	var number uint64
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		frame, ok := ev.(*page.EventScreencastFrame)
+		if !ok {
+			return
+		}
+		number++
+		n := number
+		// Listeners run on the event loop, so sending and acknowledging
+		// happen elsewhere.
+		go func() {
+			if data, err := base64.StdEncoding.DecodeString(frame.Data); err == nil && changes.changed(data) {
+				if err := writeBinary(conn, encodeFrame(FrameLiveView, f.code, id, n, data)); err != nil {
+					stopLiveView(conn, id)
+					return
+				}
+			}
+			chromedp.Run(ctx, page.ScreencastFrameAck(frame.SessionID))
+		}()
+	})
+
+	params := page.StartScreencast().WithFormat(f.screencast()).WithEveryNthFrame(everyNth)
+	if f.code == FormatJPEG {
+		params = params.WithQuality(f.quality)
+	}
+	if err := chromedp.Run(ctx, params); err != nil {
+		logger.Error("Failed to start live view", zap.String("id", id), zap.Error(err))
+		stopLiveView(conn, id)
+		sendError(conn, "Failed to start live view")
+		return
+	}
+
+	sendSuccess(conn, map[string]interface{}{
+		"message": "Live view started",
+		"id":      id,
+	})
+}
+
+func stopLiveViewHandler(conn *websocket.Conn, msg map[string]interface{}) {
+	id, ok := msg["id"].(string)
+	if !ok {
+		sendError(conn, "Instance ID is required")
+		return
+	}
+	if !stopLiveView(conn, id) {
+		sendError(conn, "Live view is not running")
+		return
+	}
+	sendSuccess(conn, map[string]interface{}{
+		"message": "Live view stopped",
+		"id":      id,
+	})
+}
+
+// stopLiveView ends the screencast of instance id watched by conn.
+func stopLiveView(conn *websocket.Conn, id string) bool {
+	liveViewsLock.Lock()
+	cancel, ok := liveViews[conn][id]
+	delete(liveViews[conn], id)
+	if len(liveViews[conn]) == 0 {
+		delete(liveViews, conn)
+	}
+	liveViewsLock.Unlock()
+	if !ok {
+		return false
+	}
+
+	instancesLock.Lock()
+	instance, found := instances[id]
+	instancesLock.Unlock()
+	if found && instance.ChromeCtx != nil {
+		chromedp.Run(instance.ChromeCtx, page.StopScreencast())
+	}
+	cancel()
+	return true
+}
+
+// stopLiveViews ends every live view of a closed connection.
+func stopLiveViews(conn *websocket.Conn) {
+	liveViewsLock.Lock()
+	ids := make([]string, 0, len(liveViews[conn]))
+	for id := range liveViews[conn] {
+		ids = append(ids, id)
+	}
+	liveViewsLock.Unlock()
+	for _, id := range ids {
+		stopLiveView(conn, id)
+	}
+}
+
+// Per-channel differences up to pixelTolerance are compression noise, not
+// change.
+const pixelTolerance = 24
+
+// diffStride samples every diffStride-th pixel in both directions, which
+// finds any visible change at a fraction of the cost.
+const diffStride = 4
+
+// changeDetector drops live-view frames that differ from the last frame
+// sent in less than minChange of their pixels.
+type changeDetector struct {
+	minChange float64
+	mu        sync.Mutex
+	last      image.Image
+}
+
+func (d *changeDetector) changed(data []byte) bool {
+	if d.minChange <= 0 {
+		return true
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.last != nil && changedFraction(d.last, img) < d.minChange {
+		return false
+	}
+	d.last = img
+	return true
+}
+
+// changedFraction returns the share of sampled pixels that differ between
+// a and b; images of different sizes differ completely.
+func changedFraction(a, b image.Image) float64 {
+	ab, bb := a.Bounds(), b.Bounds()
+	if ab.Dx() != bb.Dx() || ab.Dy() != bb.Dy() {
+		return 1
+	}
+	var sampled, changed int
+	for y := 0; y < ab.Dy(); y += diffStride {
+		for x := 0; x < ab.Dx(); x += diffStride {
+			sampled++
+			r1, g1, b1, _ := a.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			r2, g2, b2, _ := b.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			if channelDiff(r1, r2) > pixelTolerance || channelDiff(g1, g2) > pixelTolerance || channelDiff(b1, b2) > pixelTolerance {
+				changed++
+			}
+		}
+	}
+	if sampled == 0 {
+		return 0
+	}
+	return float64(changed) / float64(sampled)
+}
+
+// channelDiff compares two 16-bit color channels on an 8-bit scale.
+func channelDiff(a, b uint32) uint32 {
+	a, b = a>>8, b>>8
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
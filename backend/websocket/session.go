@@ -0,0 +1,221 @@
+package websocket
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"auto/events"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// session outlives its connection so a client that reconnects within the
+// session TTL gets its subscriptions back and the events it missed.
+type session struct {
+	id  string
+	key string
+	// subscriptions holds event names, or prefixes ending in "*"; no
+	// subscriptions means every event.
+	subscriptions map[string]bool
+	conn          *websocket.Conn
+	detachedAt    time.Time
+	seq           uint64
+	buffer        []eventMessage
+}
+
+// eventMessage is an event as sent to clients. Seq increases by one per
+// event delivered to a session, so clients resume from the last they saw.
+type eventMessage struct {
+	Status string      `json:"status"`
+	Event  string      `json:"event"`
+	Seq    uint64      `json:"seq"`
+	Data   interface{} `json:"data"`
+}
+
+var sessions = make(map[string]*session)
+var sessionsLock sync.Mutex
+
+func (s *session) subscribed(event string) bool {
+	if len(s.subscriptions) == 0 {
+		return true
+	}
+	for pattern := range s.subscriptions {
+		if pattern == event || strings.HasSuffix(pattern, "*") && strings.HasPrefix(event, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *session) subscriptionList() []string {
+	list := make([]string, 0, len(s.subscriptions))
+	for pattern := range s.subscriptions {
+		list = append(list, pattern)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// sweepSessions drops the sessions detached for longer than the TTL.
+// sessionsLock must be held.
+func sweepSessions(now time.Time) {
+	for id, s := range sessions {
+		if s.conn == nil && now.Sub(s.detachedAt) > options.SessionTTL {
+			delete(sessions, id)
+		}
+	}
+}
+
+// attach binds conn to the session id of key, replaying the events after
+// lastSeq, or starts a new session if id is unknown, expired or belongs
+// to another key.
+func attach(conn *websocket.Conn, key, id string, lastSeq uint64) *session {
+	sessionsLock.Lock()
+	sweepSessions(time.Now())
+	s, resumed := sessions[id]
+	if !resumed || s.key != key {
+		s, resumed = &session{id: uuid.New().String(), key: key, subscriptions: make(map[string]bool)}, false
+		sessions[s.id] = s
+	}
+	s.conn = conn
+	var missed []eventMessage
+	if resumed {
+		for _, msg := range s.buffer {
+			if msg.Seq > lastSeq {
+				missed = append(missed, msg)
+			}
+		}
+	}
+
+	// Hold the connection's write lock before letting go of the sessions,
+	// so no live event overtakes the replay.
+	clientsLock.Lock()
+	mu := clients[conn]
+	clientsLock.Unlock()
+	mu.Lock()
+	defer mu.Unlock()
+	hello := map[string]interface{}{
+		"status": "session",
+		"data": map[string]interface{}{
+			"session_id":    s.id,
+			"resumed":       resumed,
+			"seq":           s.seq,
+			"subscriptions": s.subscriptionList(),
+		},
+	}
+	sessionsLock.Unlock()
+
+	conn.WriteJSON(hello)
+	for _, msg := range missed {
+		if err := conn.WriteJSON(msg); err != nil {
+			break
+		}
+	}
+	return s
+}
+
+// detach marks the session disconnected unless another connection has
+// resumed it meanwhile.
+func detach(s *session, conn *websocket.Conn) {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+	if s.conn == conn {
+		s.conn = nil
+		s.detachedAt = time.Now()
+	}
+}
+
+func subscribe(conn *websocket.Conn, s *session, msg map[string]interface{}) {
+	patterns, ok := stringList(msg["events"])
+	if !ok {
+		sendError(conn, "Events are required")
+		return
+	}
+	sessionsLock.Lock()
+	for _, pattern := range patterns {
+		s.subscriptions[pattern] = true
+	}
+	list := s.subscriptionList()
+	sessionsLock.Unlock()
+	sendSuccess(conn, map[string]interface{}{"message": "Subscribed", "subscriptions": list})
+}
+
+func unsubscribe(conn *websocket.Conn, s *session, msg map[string]interface{}) {
+	patterns, ok := stringList(msg["events"])
+	if !ok {
+		sendError(conn, "Events are required")
+		return
+	}
+	sessionsLock.Lock()
+	for _, pattern := range patterns {
+		delete(s.subscriptions, pattern)
+	}
+	list := s.subscriptionList()
+	sessionsLock.Unlock()
+	sendSuccess(conn, map[string]interface{}{"message": "Unsubscribed", "subscriptions": list})
+}
+
+func stringList(v interface{}) ([]string, bool) {
+	items, ok := v.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, false
+	}
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		s, ok := item.(string)
+		if !ok || s == "" {
+			return nil, false
+		}
+		list = append(list, s)
+	}
+	return list, true
+}
+
+// Broadcast sends an event to every session subscribed to it, buffering it
+// for sessions whose client is reconnecting.
+func Broadcast(event string, data interface{}) {
+	type delivery struct {
+		conn *websocket.Conn
+		msg  eventMessage
+	}
+	var deliveries []delivery
+
+	sessionsLock.Lock()
+	sweepSessions(time.Now())
+	for _, s := range sessions {
+		if !s.subscribed(event) {
+			continue
+		}
+		s.seq++
+		msg := eventMessage{Status: "event", Event: event, Seq: s.seq, Data: data}
+		s.buffer = append(s.buffer, msg)
+		if over := len(s.buffer) - options.SessionBuffer; over > 0 {
+			s.buffer = append(s.buffer[:0:0], s.buffer[over:]...)
+		}
+		if s.conn != nil {
+			deliveries = append(deliveries, delivery{s.conn, msg})
+		}
+	}
+	sessionsLock.Unlock()
+
+	for _, d := range deliveries {
+		if err := writeJSON(d.conn, d.msg); err != nil {
+			logger.Warn("Failed to broadcast event", zap.String("event", event), zap.Error(err))
+		}
+	}
+}
+
+// EventPublisher forwards run events to WebSocket clients.
+type EventPublisher struct{}
+
+func (EventPublisher) Publish(ctx context.Context, e events.Event) error {
+	Broadcast(e.Type, e)
+	return nil
+}
+
+func (EventPublisher) Close() error { return nil }